@@ -1,11 +1,18 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func GeneratePassword(length int) string {
@@ -18,7 +25,91 @@ func GeneratePassword(length int) string {
 	return string(b)
 }
 
+// GenerateToken 生成适合放在 URL 中的随机令牌 (小写字母和数字)
+func GenerateToken(length int) string {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, length)
+	rand.Read(b)
+	for i := range b {
+		b[i] = charset[int(b[i])%len(charset)]
+	}
+	return string(b)
+}
+
+// LinkTokenPrefix 是 --auth token 时访问令牌在分享链接中所在的路径段，
+// 链接形如 https://share.example.com/t/<token>/...，持有链接即可访问
+const LinkTokenPrefix = "/t/"
+
+// LinkTokenPath 返回令牌对应的路径前缀 /t/<token>
+func LinkTokenPath(token string) string {
+	return LinkTokenPrefix + token
+}
+
+// HashToken 返回随机令牌 (分享者令牌、访问令牌等) 的 SHA-256 哈希，state.json 中只保存它。
+// 令牌本身是长随机串，不需要 PBKDF2 那样的慢哈希。
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckToken 用常量时间比较校验令牌与 HashToken 生成的哈希，哈希或令牌为空时不通过
+func CheckToken(hash, token string) bool {
+	return hash != "" && token != "" && subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
+// StripLinkToken 校验请求路径中的访问令牌 (与令牌哈希 tokenHash 比较)，返回去掉 /t/<token> 之后的路径。
+// 请求 /t/<token> 本身时返回空路径。
+func StripLinkToken(path, tokenHash string) (string, bool) {
+	if tokenHash == "" || !strings.HasPrefix(path, LinkTokenPrefix) {
+		return "", false
+	}
+	got, rest, _ := strings.Cut(path[len(LinkTokenPrefix):], "/")
+	if !CheckToken(tokenHash, got) {
+		return "", false
+	}
+	if rest == "" && !strings.HasSuffix(path, "/") {
+		return "", true
+	}
+	return "/" + rest, true
+}
+
+// Challenge 定制认证失败时的响应
+type Challenge struct {
+	Realm string // WWW-Authenticate 中的 realm，为空时使用 "cfshare"
+	Page  string // 401 响应的 HTML 页面，为空时输出 "Unauthorized"
+}
+
 func BasicAuthMiddleware(username, password string, next http.Handler) http.Handler {
+	return BasicAuthWithChallenge(username, password, Challenge{}, next)
+}
+
+// BasicAuthWithChallenge 与 BasicAuthMiddleware 相同，但使用自定义的 realm 和 401 页面
+func BasicAuthWithChallenge(username, password string, c Challenge, next http.Handler) http.Handler {
+	return BasicAuthWithUsers(username, password, nil, c, next)
+}
+
+// BasicAuthWithUsers 除分享口令外还接受用户表中的用户，users 可以为 nil
+func BasicAuthWithUsers(username, password string, users *UserTable, c Challenge, next http.Handler) http.Handler {
+	return basicAuth(func(user, pass string) bool {
+		usernameMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+		return usernameMatch && passwordMatch || users.Authenticate(user, pass)
+	}, c, next)
+}
+
+// BasicAuthWithHash 与 BasicAuthWithUsers 相同，但分享口令只以 HashPassword 的哈希给出，
+// 服务进程不需要知道口令明文
+func BasicAuthWithHash(username, passwordHash string, users *UserTable, c Challenge, next http.Handler) http.Handler {
+	account := NewUserTable([]User{{Name: username, PasswordHash: passwordHash}})
+	return basicAuth(func(user, pass string) bool {
+		return account.Authenticate(user, pass) || users.Authenticate(user, pass)
+	}, c, next)
+}
+
+// basicAuth 解析 Authorization 头部，match 校验用户名和口令
+func basicAuth(match func(user, pass string) bool, c Challenge, next http.Handler) http.Handler {
+	unauthorized := func(w http.ResponseWriter) { c.unauthorized(w) }
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		if auth == "" {
@@ -43,20 +134,67 @@ func BasicAuthMiddleware(username, password string, next http.Handler) http.Hand
 			return
 		}
 
-		usernameMatch := subtle.ConstantTimeCompare([]byte(parts[0]), []byte(username)) == 1
-		passwordMatch := subtle.ConstantTimeCompare([]byte(parts[1]), []byte(password)) == 1
-
-		if !usernameMatch || !passwordMatch {
+		if !match(parts[0], parts[1]) {
 			unauthorized(w)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), parts[0])))
 	})
 }
 
-func unauthorized(w http.ResponseWriter) {
-	w.Header().Set("WWW-Authenticate", `Basic realm="cfshare"`)
+// SignPath 为 path 生成到 expires 为止有效的签名，用于免口令的直接下载链接
+func SignPath(secret, path string, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%d", path, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// VerifyPath 校验 SignPath 生成的签名，exp 为 Unix 时间戳字符串
+func VerifyPath(secret, path, exp, sig string) bool {
+	if secret == "" || sig == "" {
+		return false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return false
+	}
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return false
+	}
+	return hmac.Equal([]byte(SignPath(secret, path, expires)), []byte(sig))
+}
+
+type userContextKey struct{}
+
+// WithUser 把已认证的访问者身份附加到 context
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFromContext 返回请求的已认证身份，未认证时返回空字符串
+func UserFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userContextKey{}).(string)
+	return user
+}
+
+func (c Challenge) unauthorized(w http.ResponseWriter) {
+	realm := c.Realm
+	if realm == "" {
+		realm = "cfshare"
+	}
+	// realm 是带引号的字符串，去掉会破坏头部格式的字符
+	realm = strings.NewReplacer(`"`, "", `\`, "", "\r", "", "\n", "").Replace(realm)
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+
+	if c.Page == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized\n"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte("Unauthorized\n"))
+	w.Write([]byte(c.Page))
 }
@@ -3,7 +3,11 @@ package auth
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGeneratePassword(t *testing.T) {
@@ -51,6 +55,36 @@ func TestGeneratePasswordCharset(t *testing.T) {
 	}
 }
 
+func TestGeneratePassphrase(t *testing.T) {
+	known := make(map[string]bool)
+	for _, w := range passphraseWords {
+		if known[w] || len(w) < 3 || len(w) > 8 || strings.ToLower(w) != w {
+			t.Errorf("bad word in list: %q", w)
+		}
+		known[w] = true
+	}
+
+	for _, n := range []int{3, 4, 6} {
+		pass := GeneratePassphrase(n)
+		parts := strings.Split(pass, "-")
+		if len(parts) != n+1 {
+			t.Fatalf("GeneratePassphrase(%d) = %q, want %d words and a number", n, pass, n)
+		}
+		for _, w := range parts[:n] {
+			if !known[w] {
+				t.Errorf("unexpected word %q in %q", w, pass)
+			}
+		}
+		if num := parts[n]; len(num) != 2 || num[0] < '1' || num[0] > '9' {
+			t.Errorf("expected a two-digit number at the end of %q", pass)
+		}
+	}
+
+	if GeneratePassphrase(4) == GeneratePassphrase(4) {
+		t.Error("expected different passphrases")
+	}
+}
+
 func TestBasicAuthMiddleware_Success(t *testing.T) {
 	username := "testuser"
 	password := "testpass"
@@ -137,3 +171,270 @@ func TestBasicAuthMiddleware_WrongUsername(t *testing.T) {
 		t.Errorf("expected 401, got %d", w.Code)
 	}
 }
+
+func TestBasicAuthMiddleware_SetsUser(t *testing.T) {
+	var gotUser string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = UserFromContext(r.Context())
+	})
+
+	protected := BasicAuthMiddleware("testuser", "testpass", handler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("testuser", "testpass")
+	protected.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotUser != "testuser" {
+		t.Errorf("expected user testuser in context, got %q", gotUser)
+	}
+}
+
+func TestBasicAuthWithHash(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	protected := BasicAuthWithHash("testuser", HashPassword("testpass"), nil, Challenge{}, handler)
+
+	for _, tc := range []struct {
+		user, pass string
+		want       int
+	}{
+		{"testuser", "testpass", http.StatusOK},
+		{"testuser", "testpass", http.StatusOK}, // 第二次命中缓存
+		{"testuser", "wrong", http.StatusUnauthorized},
+		{"other", "testpass", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(tc.user, tc.pass)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s:%s: expected status %d, got %d", tc.user, tc.pass, tc.want, w.Code)
+		}
+	}
+}
+
+func TestBasicAuthWithChallenge(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	protected := BasicAuthWithChallenge("testuser", "testpass", Challenge{
+		Realm: `Team "Files"`,
+		Page:  "<p>ask alice</p>",
+	}, handler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	protected.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401, got %d", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="Team Files", charset="UTF-8"` {
+		t.Errorf("unexpected WWW-Authenticate header: %s", got)
+	}
+	if w.Body.String() != "<p>ask alice</p>" {
+		t.Errorf("expected custom page, got %q", w.Body.String())
+	}
+}
+
+func TestSignPath(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	sig := SignPath("secret", "/report.pdf", expires)
+
+	if !VerifyPath("secret", "/report.pdf", exp, sig) {
+		t.Error("expected valid signature")
+	}
+	if VerifyPath("secret", "/other.pdf", exp, sig) {
+		t.Error("signature should not apply to another path")
+	}
+	if VerifyPath("other", "/report.pdf", exp, sig) {
+		t.Error("signature should not verify with another secret")
+	}
+	if VerifyPath("secret", "/report.pdf", exp+"0", sig) {
+		t.Error("signature should not verify with a modified expiry")
+	}
+	if VerifyPath("", "/report.pdf", exp, SignPath("", "/report.pdf", expires)) {
+		t.Error("empty secret should never verify")
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if VerifyPath("secret", "/report.pdf", strconv.FormatInt(past.Unix(), 10), SignPath("secret", "/report.pdf", past)) {
+		t.Error("expired signature should not verify")
+	}
+}
+
+func TestSignSession(t *testing.T) {
+	token := SignSession("secret", "dl", time.Now().Add(time.Hour))
+
+	if user, ok := VerifySession("secret", token); !ok || user != "dl" {
+		t.Errorf("expected valid session for dl, got %q %v", user, ok)
+	}
+	if _, ok := VerifySession("other", token); ok {
+		t.Error("session should not verify with another secret")
+	}
+	if _, ok := VerifySession("secret", token+"x"); ok {
+		t.Error("tampered session should not verify")
+	}
+	if _, ok := VerifySession("", SignSession("", "dl", time.Now().Add(time.Hour))); ok {
+		t.Error("empty secret should never verify")
+	}
+	if _, ok := VerifySession("secret", SignSession("secret", "dl", time.Now().Add(-time.Minute))); ok {
+		t.Error("expired session should not verify")
+	}
+}
+
+func TestFormAuthMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + UserFromContext(r.Context())))
+	})
+	protected := FormAuthMiddleware(FormAuth{
+		Username: "dl",
+		Password: "testpass",
+		Secret:   "secret",
+		Lifetime: time.Hour,
+	}, handler)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		return w
+	}
+
+	// 浏览器看到登录页，不会弹出 Basic Auth 登录框
+	req := httptest.NewRequest("GET", "/docs/a.pdf", nil)
+	req.Header.Set("Accept", "text/html")
+	w := serve(req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "" || !strings.Contains(w.Body.String(), `value="/docs/a.pdf"`) {
+		t.Errorf("expected login page without WWW-Authenticate, got %d %q", w.Code, w.Body.String())
+	}
+
+	login := func(password, next string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"dl"}, "password": {password}, "next": {next}}
+		req := httptest.NewRequest("POST", LoginPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-Proto", "https")
+		return serve(req)
+	}
+
+	if w := login("wrong", "/"); w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("wrong password should not log in, got %d", w.Code)
+	}
+	if w := login("testpass", "//evil.example.com/"); w.Header().Get("Location") != "/" {
+		t.Errorf("expected redirect to / for an off-site next, got %q", w.Header().Get("Location"))
+	}
+
+	w = login("testpass", "/docs/a.pdf")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/docs/a.pdf" {
+		t.Fatalf("expected redirect back after login, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != SessionCookie || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("expected secure HttpOnly session cookie, got %+v", cookies)
+	}
+
+	req = httptest.NewRequest("GET", "/docs/a.pdf", nil)
+	req.AddCookie(cookies[0])
+	if w := serve(req); w.Code != http.StatusOK || w.Body.String() != "hello dl" {
+		t.Errorf("expected session to authenticate, got %d %q", w.Code, w.Body.String())
+	}
+
+	// 脚本仍然可以使用 Basic Auth
+	req = httptest.NewRequest("GET", "/docs/a.pdf", nil)
+	req.SetBasicAuth("dl", "testpass")
+	if w := serve(req); w.Code != http.StatusOK {
+		t.Errorf("expected Basic Auth to work, got %d", w.Code)
+	}
+
+	w = serve(httptest.NewRequest("GET", LogoutPath, nil))
+	if cookies := w.Result().Cookies(); w.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected logout to clear the cookie, got %d %+v", w.Code, cookies)
+	}
+
+	// 更换口令后旧会话失效
+	rotated := FormAuthMiddleware(FormAuth{Username: "dl", Password: "newpass", Secret: "secret", Lifetime: time.Hour}, handler)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	rotated.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("session should not survive a password change, got %d", w.Code)
+	}
+}
+
+func TestStripLinkToken(t *testing.T) {
+	tests := []struct {
+		path, rest string
+		ok         bool
+	}{
+		{"/t/abc/", "/", true},
+		{"/t/abc", "", true},
+		{"/t/abc/dir/a.txt", "/dir/a.txt", true},
+		{"/t/abd/a.txt", "", false},
+		{"/t/ab/a.txt", "", false},
+		{"/t/abcd/a.txt", "", false},
+		{"/a.txt", "", false},
+	}
+	for _, tt := range tests {
+		rest, ok := StripLinkToken(tt.path, HashToken("abc"))
+		if rest != tt.rest || ok != tt.ok {
+			t.Errorf("StripLinkToken(%q) = %q, %v; want %q, %v", tt.path, rest, ok, tt.rest, tt.ok)
+		}
+	}
+	if _, ok := StripLinkToken("/t//", ""); ok {
+		t.Error("empty token should never match")
+	}
+}
+
+func TestUserTable(t *testing.T) {
+	hash := HashPassword("alice-pass")
+	if !CheckPassword(hash, "alice-pass") || CheckPassword(hash, "wrong") {
+		t.Fatal("password hash does not verify correctly")
+	}
+	if CheckPassword("alice-pass", "alice-pass") {
+		t.Error("a plaintext value must not be accepted as a hash")
+	}
+
+	users := NewUserTable([]User{{Name: "alice", PasswordHash: hash, Perm: PermRead, Items: []string{"a.txt"}}})
+	handler := BasicAuthWithUsers("user", "secret", users, Challenge{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(UserFromContext(r.Context())))
+	}))
+
+	for _, tt := range []struct {
+		user, pass string
+		code       int
+	}{
+		{"user", "secret", http.StatusOK},
+		{"alice", "alice-pass", http.StatusOK},
+		{"alice", "alice-pass", http.StatusOK}, // 命中缓存
+		{"alice", "secret", http.StatusUnauthorized},
+		{"bob", "alice-pass", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(tt.user, tt.pass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code || (tt.code == http.StatusOK && w.Body.String() != tt.user) {
+			t.Errorf("%s:%s: got %d %q, want %d", tt.user, tt.pass, w.Code, w.Body.String(), tt.code)
+		}
+	}
+
+	u, _ := users.Lookup("alice")
+	if u.CanUpload() || !u.CanAccess("a.txt") || u.CanAccess("b.txt") {
+		t.Errorf("unexpected permissions for %+v", u)
+	}
+
+	// 删除用户后口令立即失效
+	users.Set(nil)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("alice", "alice-pass")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("removed user should be rejected, got %d", w.Code)
+	}
+}
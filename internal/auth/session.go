@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SessionCookie 是表单登录 (--auth form) 后保存会话令牌的 cookie
+	SessionCookie = "cfshare_session"
+	// LoginPath 接收登录表单的 POST
+	LoginPath = "/__login"
+	// LogoutPath 清除会话 cookie 后回到登录页
+	LogoutPath = "/__logout"
+)
+
+// FormAuth 是表单登录的配置。登录成功后发放带签名的会话 cookie，
+// 不依赖 Authorization 头部，会删除该头部的代理后面也能使用。
+type FormAuth struct {
+	Username     string
+	Password     string
+	PasswordHash string        // 代替 Password，只给出 HashPassword 生成的口令哈希
+	Secret       string        // 会话签名密钥，与口令一起参与签名，更换口令后旧会话全部失效
+	Lifetime     time.Duration // 会话有效期
+	Users        *UserTable    // 分享口令之外单独添加的用户，可以为 nil
+
+	// Page 输出登录页，next 是登录后返回的地址，failed 表示上次提交的口令错误。
+	// 为空时输出不带样式的简单表单。
+	Page func(w http.ResponseWriter, r *http.Request, next string, failed bool)
+
+	account *UserTable // PasswordHash 对应的账户，缓存校验结果
+}
+
+// SignSession 生成 user 到 expires 为止有效的会话令牌
+func SignSession(secret, user string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(user)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sessionMAC(secret, payload)
+}
+
+// VerifySession 校验 SignSession 生成的令牌，返回令牌中的用户
+func VerifySession(secret, token string) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", false
+	}
+	payload, sig := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(sessionMAC(secret, payload)), []byte(sig)) {
+		return "", false
+	}
+	encodedUser, exp, ok := strings.Cut(payload, ".")
+	if !ok {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return "", false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(encodedUser)
+	if err != nil {
+		return "", false
+	}
+	return string(user), true
+}
+
+func sessionMAC(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "session\n%s", payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// FormAuthMiddleware 用登录页和会话 cookie 代替 Basic Auth 的浏览器弹窗。
+// 脚本仍然可以用 Basic Auth (curl -u)，但未登录时不发送 WWW-Authenticate，
+// 浏览器不会弹出登录框。
+func FormAuthMiddleware(f FormAuth, next http.Handler) http.Handler {
+	secret := f.Secret + "\n" + f.Password + f.PasswordHash
+	if f.PasswordHash != "" {
+		f.account = NewUserTable([]User{{Name: f.Username, PasswordHash: f.PasswordHash}})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LoginPath:
+			f.login(w, r, secret)
+			return
+		case LogoutPath:
+			http.SetCookie(w, f.cookie(r, "", -1))
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			if user, ok := VerifySession(secret, c.Value); ok && f.known(user) {
+				next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+				return
+			}
+		}
+		if user, pass, ok := r.BasicAuth(); ok && f.match(user, pass) {
+			next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), user)))
+			return
+		}
+
+		// 只对浏览器打开页面的请求显示登录页，缩略图等子资源和脚本得到纯文本的 401
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			f.page(w, r, r.URL.RequestURI(), false)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized\n"))
+	})
+}
+
+// login 处理登录表单，成功后设置会话 cookie 并回到登录前的页面
+func (f FormAuth) login(w http.ResponseWriter, r *http.Request, secret string) {
+	if r.Method != http.MethodPost {
+		f.page(w, r, "/", false)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+	next := safeNext(r.PostFormValue("next"))
+	user := r.PostFormValue("username")
+	if !f.match(user, r.PostFormValue("password")) {
+		f.page(w, r, next, true)
+		return
+	}
+
+	token := SignSession(secret, user, time.Now().Add(f.Lifetime))
+	http.SetCookie(w, f.cookie(r, token, int(f.Lifetime/time.Second)))
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (f FormAuth) match(user, pass string) bool {
+	if f.account != nil {
+		return f.account.Authenticate(user, pass) || f.Users.Authenticate(user, pass)
+	}
+	usernameMatch := subtle.ConstantTimeCompare([]byte(user), []byte(f.Username)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(f.Password)) == 1
+	return usernameMatch && passwordMatch || f.Users.Authenticate(user, pass)
+}
+
+// known 判断会话中的用户是否仍然有效，用户被 cfshare user rm 删除后其会话随之失效
+func (f FormAuth) known(user string) bool {
+	if user == f.Username {
+		return true
+	}
+	_, ok := f.Users.Lookup(user)
+	return ok
+}
+
+// cookie 返回会话 cookie，maxAge 为负数时删除。经 Cloudflare 访问时为 HTTPS，
+// 本地的连接却是 HTTP，因此按 X-Forwarded-Proto 决定是否加 Secure。
+func (f FormAuth) cookie(r *http.Request, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// page 以 401 输出登录页
+func (f FormAuth) page(w http.ResponseWriter, r *http.Request, next string, failed bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	if f.Page != nil {
+		f.Page(w, r, next, failed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	msg := ""
+	if failed {
+		msg = "<p>Wrong username or password</p>"
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html><html><body>%s<form method="post" action="%s">
+<input name="username" autocomplete="username"> <input name="password" type="password" autocomplete="current-password">
+<input type="hidden" name="next" value="%s"> <button type="submit">Log in</button></form></body></html>`,
+		msg, LoginPath, html.EscapeString(next))
+}
+
+// safeNext 只允许登录后跳回本站的路径，避免被用作开放重定向
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") ||
+		strings.HasPrefix(next, LoginPath) || strings.HasPrefix(next, LogoutPath) {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Permission 是 cfshare user add 添加的用户的权限
+type Permission string
+
+const (
+	PermRead   Permission = "read"   // 只能浏览和下载
+	PermUpload Permission = "upload" // 还可以向文件请求上传
+)
+
+// User 是分享口令之外单独添加的用户，各自的下载记录在访问日志中按用户名区分。
+// 只保存口令的 PBKDF2 哈希，口令本身只在添加时显示一次。
+type User struct {
+	Name         string     `json:"name"`
+	PasswordHash string     `json:"password_hash"`
+	Perm         Permission `json:"perm"`
+	Items        []string   `json:"items,omitempty"` // 允许访问的分享项名称，为空时可以访问全部
+}
+
+// CanUpload 判断用户能否向文件请求上传
+func (u User) CanUpload() bool {
+	return u.Perm == PermUpload
+}
+
+// CanAccess 判断用户能否访问名为 item 的分享项
+func (u User) CanAccess(item string) bool {
+	if len(u.Items) == 0 {
+		return true
+	}
+	for _, name := range u.Items {
+		if name == item {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordIterations 是 PBKDF2-SHA256 的迭代次数
+const passwordIterations = 600000
+
+// checkSlots 限制同时进行的 PBKDF2 校验数。每次校验约占一个 CPU 核心几百毫秒，
+// 大量并发的错误口令只会排队，不会占满服务进程所在机器的全部 CPU。
+var checkSlots = make(chan struct{}, 2)
+
+// HashPassword 返回口令的 PBKDF2-SHA256 哈希，形如 pbkdf2-sha256$<迭代次数>$<盐>$<哈希>
+func HashPassword(password string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, _ := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// CheckPassword 校验口令与 HashPassword 生成的哈希是否一致
+func CheckPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	checkSlots <- struct{}{}
+	defer func() { <-checkSlots }()
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// UserTable 是服务进程中的用户表，可以在运行时整体替换 (cfshare user add/rm 之后)。
+// Basic Auth 每个请求都会带上口令，校验成功的组合会缓存，避免每次都计算 PBKDF2。
+type UserTable struct {
+	mu       sync.RWMutex
+	users    map[string]User
+	verified map[[32]byte]bool
+}
+
+func NewUserTable(users []User) *UserTable {
+	t := &UserTable{}
+	t.Set(users)
+	return t
+}
+
+// Set 替换全部用户，已缓存的校验结果随之作废
+func (t *UserTable) Set(users []User) {
+	m := make(map[string]User, len(users))
+	for _, u := range users {
+		m[u.Name] = u
+	}
+	t.mu.Lock()
+	t.users = m
+	t.verified = make(map[[32]byte]bool)
+	t.mu.Unlock()
+}
+
+// Lookup 按用户名查找用户
+func (t *UserTable) Lookup(name string) (User, bool) {
+	if t == nil {
+		return User{}, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	u, ok := t.users[name]
+	return u, ok
+}
+
+// Len 返回用户数
+func (t *UserTable) Len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.users)
+}
+
+// Authenticate 校验用户名和口令
+func (t *UserTable) Authenticate(name, password string) bool {
+	u, ok := t.Lookup(name)
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(name + "\x00" + password + "\x00" + u.PasswordHash))
+	t.mu.RLock()
+	hit := t.verified[key]
+	t.mu.RUnlock()
+	if hit {
+		return true
+	}
+	if !CheckPassword(u.PasswordHash, password) {
+		return false
+	}
+	t.mu.Lock()
+	t.verified[key] = true
+	t.mu.Unlock()
+	return true
+}
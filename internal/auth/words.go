@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// 随机口令的样式 (--pass-style)
+const (
+	PassStyleChars = "chars" // 字母和数字，默认
+	PassStyleWords = "words" // 单词加数字，如 horse-planet-copper-42，便于电话里口头转述
+)
+
+// GeneratePassphrase 生成由 words 个常见英文单词和一个两位数组成、以 - 连接的口令。
+// 词表只有容易拼写、不易听错的单词，每个单词约 8.8 位熵，4 个单词加数字约 42 位，
+// 配合 --max-auth-failures 足以抵御在线猜测；需要更强时增加单词数。
+func GeneratePassphrase(words int) string {
+	parts := make([]string, 0, words+1)
+	for range words {
+		parts = append(parts, passphraseWords[randInt(len(passphraseWords))])
+	}
+	parts = append(parts, fmt.Sprint(10+randInt(90)))
+	return strings.Join(parts, "-")
+}
+
+// randInt 返回 [0, n) 内均匀分布的随机数
+func randInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(v.Int64())
+}
+
+// passphraseWords 是 GeneratePassphrase 的词表: 小写、3 到 8 个字母、没有重复
+var passphraseWords = []string{
+	"acorn", "actor", "adult", "agent", "alarm", "album", "alley", "amber", "angel", "ankle",
+	"apple", "apron", "arena", "armor", "arrow", "atlas", "attic", "autumn", "avenue", "award",
+	"bacon", "badge", "bagel", "baker", "bamboo", "banana", "band", "banjo", "barn", "barrel",
+	"basket", "beach", "beacon", "beard", "beaver", "bedroom", "beetle", "bell", "bench", "berry",
+	"bike", "bingo", "biscuit", "bison", "blanket", "blossom", "board", "boat", "bolt", "bonus",
+	"book", "boot", "bottle", "boulder", "bowl", "box", "brain", "branch", "brass", "bread",
+	"brick", "bridge", "broom", "brush", "bubble", "bucket", "buffalo", "bugle", "bunny", "butter",
+	"button", "cabin", "cable", "cactus", "cake", "camel", "camera", "camp", "canal", "candle",
+	"candy", "canoe", "canvas", "canyon", "captain", "carbon", "card", "carpet", "carrot", "castle",
+	"cattle", "cave", "cedar", "cell", "cereal", "chair", "chalk", "cheese", "cherry", "chess",
+	"chest", "chicken", "chimney", "cider", "cinema", "circle", "circus", "citrus", "city", "clam",
+	"clay", "cliff", "clock", "cloud", "clover", "coach", "coast", "cobra", "cocoa", "coconut",
+	"coffee", "coin", "comet", "compass", "cookie", "copper", "coral", "corn", "cotton", "couch",
+	"cousin", "cowboy", "crab", "crane", "crayon", "cream", "cricket", "crown", "crystal", "cube",
+	"cupcake", "curtain", "cushion", "daisy", "dance", "dawn", "deer", "denim", "desert", "desk",
+	"diamond", "diary", "dinner", "dolphin", "donkey", "door", "dragon", "drawer", "dream", "drum",
+	"duck", "eagle", "earth", "easel", "echo", "eclipse", "elbow", "elephant", "elm", "ember",
+	"engine", "falcon", "farm", "feather", "fence", "fern", "ferry", "fiddle", "field", "fig",
+	"finger", "fire", "fish", "flag", "flame", "flute", "foam", "forest", "fork", "fossil",
+	"fountain", "fox", "frame", "frog", "frost", "fruit", "galaxy", "garden", "garlic", "gate",
+	"gecko", "gem", "ghost", "giant", "ginger", "giraffe", "glacier", "glass", "globe", "glove",
+	"goat", "gold", "goose", "gorilla", "grape", "grass", "gravel", "guitar", "gull", "hammer",
+	"harbor", "harp", "hat", "hawk", "hazel", "heart", "hedge", "helmet", "hero", "hill",
+	"hippo", "hive", "honey", "hook", "horizon", "horse", "hotel", "house", "husky", "igloo",
+	"iris", "island", "ivory", "jacket", "jaguar", "jam", "jar", "jelly", "jewel", "judge",
+	"juice", "jungle", "kayak", "kettle", "key", "kite", "kitten", "kiwi", "knight", "koala",
+	"ladder", "lake", "lamp", "lantern", "laptop", "lava", "lawn", "leaf", "lemon", "lens",
+	"letter", "lettuce", "lily", "lime", "lion", "lizard", "llama", "lobster", "locket", "lotus",
+	"lunar", "magnet", "mango", "maple", "marble", "market", "meadow", "melon", "mirror", "mitten",
+	"monkey", "moon", "moose", "motor", "mountain", "mouse", "muffin", "museum", "music", "nest",
+	"noodle", "north", "novel", "nutmeg", "oak", "oasis", "ocean", "octopus", "olive", "onion",
+	"orange", "orbit", "orchid", "otter", "owl", "oyster", "paddle", "palace", "panda", "paper",
+	"parade", "parrot", "pasta", "peach", "peanut", "pearl", "pebble", "pencil", "penguin", "pepper",
+	"piano", "pickle", "pigeon", "pillow", "pilot", "pine", "pirate", "pizza", "planet", "plum",
+	"pocket", "poem", "pony", "poppy", "potato", "pumpkin", "puppy", "puzzle", "quail", "queen",
+	"quilt", "rabbit", "radio", "raft", "rain", "raven", "reef", "ribbon", "rice", "river",
+	"robin", "robot", "rocket", "rose", "ruby", "saddle", "sail", "salad", "salmon", "sand",
+	"saturn", "scarf", "school", "scooter", "seal", "shadow", "shark", "sheep", "shell", "ship",
+	"shoe", "silver", "singer", "sketch", "skunk", "sled", "slipper", "snail", "snow", "soap",
+	"sock", "sofa", "spider", "spoon", "spring", "squid", "stamp", "star", "statue", "stone",
+	"storm", "stove", "sugar", "summer", "sun", "swan", "sweater", "table", "taco", "tail",
+	"tiger", "timber", "toast", "tomato", "torch", "tower", "tractor", "train", "tree", "tulip",
+	"tunnel", "turtle", "tuxedo", "umbrella", "unicorn", "valley", "velvet", "violin", "volcano", "wagon",
+	"walnut", "walrus", "wave", "whale", "wheat", "whistle", "willow", "window", "winter", "wizard",
+	"wolf", "yacht", "yogurt", "zebra", "zipper",
+}
@@ -3,17 +3,174 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 )
 
 const (
 	DefaultPort       = 8787
 	DefaultUsername   = "dl"
 	PasswordLength    = 16
+	DefaultPassWords  = 4 // --pass-style words 的单词数
 	StateFileName     = "state.json"
 	AccessLogFileName = "access.log"
 	TunnelName        = "cfshare"
+	TokenLength       = 12
+	LinkTokenLength   = 24 // --auth token 的访问令牌，单独就能访问分享，比上传链接的令牌更长
+	SlugLength        = 8  // --slug 的随机路径
+	DefaultMaxUploads = 10
+
+	// MountEnv 把当前分享的挂载前缀 (--mount) 传给服务进程等子进程
+	MountEnv = "CFSHARE_MOUNT"
+
+	// ListenFDEnv 是平滑升级时旧服务进程传给新进程的监听 socket 的文件描述符编号
+	ListenFDEnv = "CFSHARE_LISTEN_FD"
+
+	// StatePassphraseEnv 设置后使用该口令派生状态文件的加密密钥
+	StatePassphraseEnv = "CFSHARE_STATE_PASSPHRASE"
+
+	// CloudflareAPITokenEnv 是 --tunnel-token 时查询控制台中 tunnel 公共主机名所用的 Cloudflare API 令牌
+	CloudflareAPITokenEnv = "CLOUDFLARE_API_TOKEN"
+
+	// DefaultConfirmSize 是默认需要确认才开始下载的文件大小
+	DefaultConfirmSize = "1GB"
+
+	// DefaultSplitSize 是分卷下载 (?zip=split) 每卷的默认大小
+	DefaultSplitSize = 2 << 30
+
+	// DefaultLinkTTL 是 cfshare qr 和网页二维码中签名链接的默认有效期
+	DefaultLinkTTL = 24 * time.Hour
+
+	// 测速地址 (/__speedtest__) 默认传输 SpeedtestDefaultMB MiB，单次最多 SpeedtestMaxBytes；
+	// cfshare speedtest 给访问者的测速链接有效期为 SpeedtestLinkTTL
+	SpeedtestDefaultMB = 100
+	SpeedtestMaxBytes  = 1 << 30
+	SpeedtestLinkTTL   = time.Hour
+
+	// DefaultMirrorTTL 是 cfshare mirror 预签名链接的默认有效期 (SigV4 允许的上限)
+	DefaultMirrorTTL = 7 * 24 * time.Hour
+
+	// 热备 (cfshare standby) 每隔 StandbyInterval 探测一次主机，
+	// 连续 StandbyFailures 次无响应后接管分享
+	StandbyInterval = 10 * time.Second
+	StandbyFailures = 3
+	StandbyTimeout  = 5 * time.Second
+
+	// TunnelConnectTimeout 是 cloudflared 连接到边缘的等待时间，超时后换另一种协议重试
+	TunnelConnectTimeout = 20 * time.Second
+
+	// 服务进程每隔 HealthInterval 探测一次公开地址，连续 HealthFailures 次失败
+	// 标记为降级并重启 tunnel，重启间隔从 RestartMinBackoff 倍增到 RestartMaxBackoff
+	HealthInterval    = 30 * time.Second
+	HealthFailures    = 3
+	RestartMinBackoff = 30 * time.Second
+	RestartMaxBackoff = 10 * time.Minute
+
+	// 服务进程每隔 TunnelCheckInterval 从 cloudflared 的指标读取已注册的边缘连接数，
+	// cloudflared 进程存活但连续 TunnelCheckFailures 次没有连接时重启 tunnel
+	TunnelCheckInterval = 15 * time.Second
+	TunnelCheckFailures = 4
+
+	// cfshare status 和 cfshare doctor 只诊断 tunnel 日志中最近 TunnelDiagnoseWindow 内的错误
+	TunnelDiagnoseWindow = time.Hour
+
+	// 服务进程每隔 ResourceInterval 采样一次自身的内存、goroutine 和打开的文件数，
+	// 超过下面的阈值时写入警告，长时间运行的分享可以据此发现资源泄漏
+	ResourceInterval      = time.Minute
+	ResourceMaxHeap       = 1 << 30
+	ResourceMaxGoroutines = 5000
+	ResourceMaxFDs        = 2048
+
+	// 使用 --watch 时服务进程每隔 WatchInterval 检查一次分享文件的修改时间和大小，
+	// 变化后重新计算 SHA-256，内容确实改变时版本号加一
+	WatchInterval = time.Minute
+
+	// 原始访问记录默认保留 DefaultStatsRetention (--stats-retention)，服务进程每隔
+	// StatsPruneInterval 清理一次过期记录；聚合统计永久保留
+	DefaultStatsRetention = 30 * 24 * time.Hour
+	StatsPruneInterval    = time.Hour
+
+	// ReceiptWindow 是完整下载之后可以确认收到 (--receipts) 的时长，只记录在服务进程内存中
+	ReceiptWindow = 24 * time.Hour
+
+	// DefaultMaxRequestSize 是 --max-request-size 的默认值: 表单上传 (multipart POST) 一个请求的
+	// 总大小上限。表单解析时超出内存的部分先写入临时目录，不能不设上限
+	DefaultMaxRequestSize = 10 << 30
+	// UploadFreeSpaceReserve 是收件目录和临时目录所在磁盘在写入上传内容后至少保留的空间
+	UploadFreeSpaceReserve = 256 << 20
+
+	// 原始访问记录先放入 StatsBufferSize 条的缓冲区，由后台 goroutine 每隔 StatsFlushInterval
+	// 批量写入，请求不等待磁盘或 sqlite3；缓冲区满时丢弃新记录 (聚合统计不受影响)
+	StatsBufferSize    = 4096
+	StatsFlushInterval = time.Second
+
+	// cfshare digest 列出下载最多的 DigestTopFiles 个文件和最近的 DigestMaxEvents 个事件
+	DigestTopFiles  = 10
+	DigestMaxEvents = 20
+
+	// ReadHeaderTimeout 是客户端发送请求头的时限，IdleTimeout 是 keep-alive 连接的空闲时限
+	ReadHeaderTimeout = 30 * time.Second
+	IdleTimeout       = 2 * time.Minute
+
+	// MinRateWindow 是 --min-rate 统计传输速率的窗口，第一个窗口为宽限期
+	MinRateWindow = 30 * time.Second
+
+	// 平滑升级 (cfshare upgrade --inplace) 时新服务进程启动后等待 UpgradeReadyWait
+	// 确认没有退出，旧进程最多等待 UpgradeDrainTimeout 让进行中的传输完成。
+	// cfshare upgrade 最多等待 UpgradeHandoffTimeout 让旧进程完成交接
+	UpgradeReadyWait      = time.Second
+	UpgradeDrainTimeout   = 12 * time.Hour
+	UpgradeHandoffTimeout = 15 * time.Second
+
+	// BusyRetryAfter 是并发传输达到上限时 429 响应中 Retry-After 建议的等待时间
+	BusyRetryAfter = 30 * time.Second
+
+	// WaitingRoomRefresh 是 --waiting-room 排队页面自动刷新的间隔
+	WaitingRoomRefresh = 5 * time.Second
+	// WaitingRoomTimeout 是排队的访问者多久没有刷新就让出位置 (关闭了页面)
+	WaitingRoomTimeout = 30 * time.Second
+
+	// ApproveRefresh 是 --approve 等待批准页面自动刷新的间隔，
+	// StatusWatchInterval 是 cfshare status --watch 检查新访问者的间隔
+	ApproveRefresh      = 5 * time.Second
+	StatusWatchInterval = 2 * time.Second
+
+	// TelemetryInterval 是开启遥测后汇总发送一次使用计数的间隔
+	TelemetryInterval = 7 * 24 * time.Hour
+
+	// TelemetryCheckInterval 是服务进程检查计数是否到期的间隔，
+	// TelemetryTimeout 是发送一次计数的超时 (在服务进程中发送，不拖慢命令行)
+	TelemetryCheckInterval = time.Hour
+	TelemetryTimeout       = 10 * time.Second
+
+	// TelemetryURLEnv 设置后覆盖构建时设置的遥测接收地址
+	TelemetryURLEnv = "CFSHARE_TELEMETRY_URL"
+
+	// DefaultSessionLifetime 是 --auth form 登录后会话的默认有效期
+	DefaultSessionLifetime = 24 * time.Hour
+
+	// BanDuration 是触发诱饵路径等异常行为后临时封禁 IP 的时长
+	BanDuration = time.Hour
+
+	// DefaultMaxAuthFailures 是 --max-auth-failures 的默认值: 同一 IP 在 AuthFailureWindow 内
+	// 口令错误达到该次数后被锁定 AuthLockoutDuration
+	DefaultMaxAuthFailures = 10
+	AuthFailureWindow      = 10 * time.Minute
+	AuthLockoutDuration    = 15 * time.Minute
+
+	// AuthAttemptRate 是单个 IP 每秒允许的口令错误次数 (令牌桶，容量为 2 倍)，
+	// 超出后在校验口令之前返回 429，不受 --req-rate 影响
+	AuthAttemptRate = 1
 )
 
+// Mount 是当前命令操作的分享的挂载前缀 (--mount，不含斜杠，如 "docs")，
+// 为空时是占用整个主机名的默认分享。挂载的分享各有自己的状态和进程文件，
+// 可以与默认分享及其他挂载的分享同时运行，共用同一个 tunnel。
+var Mount string
+
+// Hostname 是当前分享独占的主机名 (--hostname，如 docs.example.com)，为空时使用 tunnel 的默认主机名。
+// 有独立主机名的分享不加路径前缀；没有 --mount 时以主机名作为 Mount，与其他分享分开保存。
+var Hostname string
+
 func GetConfigDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -22,26 +179,166 @@ func GetConfigDir() string {
 	return filepath.Join(home, ".cfshare")
 }
 
+// GetSharesDir 返回挂载到路径前缀的分享所在的目录
+func GetSharesDir() string {
+	return filepath.Join(GetConfigDir(), "shares")
+}
+
+// GetShareDir 返回当前分享的状态、进程文件、访问日志和统计所在目录：
+// 默认分享直接使用配置目录，挂载的分享使用 shares/<mount>
+func GetShareDir() string {
+	return ShareDirOf(Mount)
+}
+
+// ShareDirOf 返回挂载名称为 mount 的分享所在目录，mount 为空时是默认分享
+func ShareDirOf(mount string) string {
+	if mount == "" {
+		return GetConfigDir()
+	}
+	return filepath.Join(GetSharesDir(), mount)
+}
+
 func GetStatePath() string {
-	return filepath.Join(GetConfigDir(), StateFileName)
+	return filepath.Join(GetShareDir(), StateFileName)
 }
 
 func GetAccessLogPath() string {
-	return filepath.Join(GetConfigDir(), AccessLogFileName)
+	return filepath.Join(GetShareDir(), AccessLogFileName)
+}
+
+// GetTunnelLogPath 返回 cloudflared 的日志文件路径
+func GetTunnelLogPath() string {
+	return filepath.Join(GetConfigDir(), "tunnel.log")
 }
 
 func GetPidFilePath() string {
-	return filepath.Join(GetConfigDir(), "server.pid")
+	return filepath.Join(GetShareDir(), "server.pid")
 }
 
 func GetTunnelPidFilePath() string {
 	return filepath.Join(GetConfigDir(), "tunnel.pid")
 }
 
+// GetTunnelMetricsPath 返回记录 cloudflared 指标接口地址的文件
+func GetTunnelMetricsPath() string {
+	return filepath.Join(GetConfigDir(), "tunnel-metrics")
+}
+
+// GetThumbsDir 返回缩略图缓存目录
+func GetThumbsDir() string {
+	return filepath.Join(GetConfigDir(), "thumbs")
+}
+
+// GetTemplatesDir 返回用户覆盖模板所在目录
+func GetTemplatesDir() string {
+	return filepath.Join(GetConfigDir(), "templates")
+}
+
+// GetPastesDir 返回 cfshare send 保存文本片段的目录
+func GetPastesDir() string {
+	return filepath.Join(GetConfigDir(), "pastes")
+}
+
+// GetRequestsDir 返回文件请求的收件目录
+func GetRequestsDir() string {
+	return filepath.Join(GetConfigDir(), "requests")
+}
+
 func EnsureConfigDir() error {
-	return os.MkdirAll(GetConfigDir(), 0700)
+	return os.MkdirAll(GetShareDir(), 0700)
+}
+
+// GetHealthPath 返回服务进程写入的公开地址健康状态
+func GetHealthPath() string {
+	return filepath.Join(GetShareDir(), "health.json")
+}
+
+// GetResourcesPath 返回服务进程最近一次资源采样的保存位置
+func GetResourcesPath() string {
+	return filepath.Join(GetShareDir(), "resources.json")
+}
+
+// GetItemSizesPath 返回目录分享项递归大小的保存位置，由服务进程在后台统计后写入
+func GetItemSizesPath() string {
+	return filepath.Join(GetShareDir(), "sizes.json")
+}
+
+// GetVersionsPath 返回 --watch 记录的分享文件版本
+func GetVersionsPath() string {
+	return filepath.Join(GetShareDir(), "versions.json")
+}
+
+// GetVisitorsPath 返回 --approve 模式下等待批准和已处理的访问者，
+// 服务进程写入新访问者，cfshare approve/deny 写入处理结果
+func GetVisitorsPath() string {
+	return filepath.Join(GetShareDir(), "visitors.json")
+}
+
+// GetRoutePath 返回当前分享在路由进程 (--router) 中登记的路由
+func GetRoutePath() string {
+	return filepath.Join(GetShareDir(), "route.json")
+}
+
+// GetSecretsPath 返回钥匙串不可用时保存分享令牌和签名密钥的文件 (state.json 中只有令牌哈希)
+func GetSecretsPath() string {
+	return filepath.Join(GetShareDir(), "secrets.json")
+}
+
+// GetHandoffPath 返回平滑升级 (cfshare upgrade --inplace) 时服务进程交接监听端口的记录
+func GetHandoffPath() string {
+	return filepath.Join(GetShareDir(), "handoff.json")
+}
+
+// GetServerLogPath 返回当前分享的服务进程日志
+func GetServerLogPath() string {
+	return filepath.Join(GetShareDir(), "server.log")
+}
+
+// GetRegistryPath 返回所有分享的索引，cfshare shares 和共用 tunnel 的判断读取它
+func GetRegistryPath() string {
+	return filepath.Join(GetConfigDir(), "shares.json")
+}
+
+// GetHistoryPath 返回分享历史，每次分享开始和结束各追加一行，cfshare history search 读取
+func GetHistoryPath() string {
+	return filepath.Join(GetConfigDir(), "history.jsonl")
+}
+
+// GetRouterPath 返回路由进程的记录，所有分享共用一个路由进程
+func GetRouterPath() string {
+	return filepath.Join(GetConfigDir(), "router.json")
+}
+
+// GetEndedPath 返回分享停止后 "分享已结束" 提示进程 (--ended-grace) 的记录
+func GetEndedPath() string {
+	return filepath.Join(GetShareDir(), "ended.json")
 }
 
 func GetStatsPath() string {
-	return filepath.Join(GetConfigDir(), "stats.json")
+	return filepath.Join(GetShareDir(), "stats.json")
+}
+
+// GetStatsRecordsPath 返回 json 存储方式下原始访问记录的文件路径 (每行一条)
+func GetStatsRecordsPath() string {
+	return filepath.Join(GetShareDir(), "stats-records.jsonl")
+}
+
+// GetStatsDBPath 返回 sqlite 存储方式下原始访问记录的数据库路径
+func GetStatsDBPath() string {
+	return filepath.Join(GetShareDir(), "stats.db")
+}
+
+// GetEncryptionPath 返回状态加密配置文件路径，文件存在即表示已启用加密
+func GetEncryptionPath() string {
+	return filepath.Join(GetConfigDir(), "encryption.json")
+}
+
+// GetTelemetryPath 返回遥测设置和尚未发送的使用计数
+func GetTelemetryPath() string {
+	return filepath.Join(GetConfigDir(), "telemetry.json")
+}
+
+// GetStateKeyPath 返回本机密钥文件路径 (未设置口令时用于加密状态)
+func GetStateKeyPath() string {
+	return filepath.Join(GetConfigDir(), "state.key")
 }
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvPrefix 是用环境变量设置参数时的前缀: --max-conns 对应 CFSHARE_MAX_CONNS
+const EnvPrefix = "CFSHARE_"
+
+// GetConfigFilePath 返回用户配置文件路径。文件是 JSON 对象，键为命令行参数名 (不带 --)，
+// 例如 {"tunnel": "home", "max-conns": 20, "exclude": ["*.log", "node_modules"]}
+func GetConfigFilePath() string {
+	return filepath.Join(GetConfigDir(), "config.json")
+}
+
+// EnvName 返回参数对应的环境变量名
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// LoadFile 读取配置文件，返回每个参数的值，数组对应可重复的参数。文件不存在时返回 nil。
+func LoadFile() (map[string][]string, error) {
+	data, err := os.ReadFile(GetConfigFilePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", GetConfigFilePath(), err)
+	}
+
+	values := make(map[string][]string, len(raw))
+	for name, v := range raw {
+		name = strings.TrimLeft(name, "-")
+		switch v := v.(type) {
+		case []any:
+			for _, item := range v {
+				s, err := scalarString(item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", GetConfigFilePath(), name, err)
+				}
+				values[name] = append(values[name], s)
+			}
+		default:
+			s, err := scalarString(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", GetConfigFilePath(), name, err)
+			}
+			values[name] = []string{s}
+		}
+	}
+	return values, nil
+}
+
+// scalarString 把 JSON 中的字符串、数字和布尔值转换为命令行参数的写法
+func scalarString(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("max-conns-per-ip"); got != "CFSHARE_MAX_CONNS_PER_IP" {
+		t.Errorf("EnvName = %q", got)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	values, err := LoadFile()
+	if err != nil || values != nil {
+		t.Fatalf("missing file: %v, %v", values, err)
+	}
+
+	os.MkdirAll(GetConfigDir(), 0700)
+	os.WriteFile(filepath.Join(GetConfigDir(), "config.json"), []byte(`{
+		"tunnel": "home",
+		"--max-conns": 20,
+		"public": true,
+		"exclude": ["*.log", "node_modules"]
+	}`), 0600)
+
+	values, err = LoadFile()
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	want := map[string][]string{
+		"tunnel":    {"home"},
+		"max-conns": {"20"},
+		"public":    {"true"},
+		"exclude":   {"*.log", "node_modules"},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("LoadFile = %v, want %v", values, want)
+	}
+
+	os.WriteFile(GetConfigFilePath(), []byte(`{"tunnel": {"name": "x"}}`), 0600)
+	if _, err := LoadFile(); err == nil {
+		t.Error("expected error for a nested object")
+	}
+}
@@ -0,0 +1,132 @@
+// Package health 通过公开地址探测分享在 Cloudflare 边缘上是否可达，
+// 用于发现本地服务正常但 tunnel 已断开的降级状态并自动恢复。
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// OwnerHeader 携带分享者令牌，探测请求走优先通道，不受口令和访问限制影响
+	OwnerHeader = "X-Cfshare-Owner"
+	// ProbeHeader 标记探测请求，分享者的探测不计入访问日志和统计
+	ProbeHeader = "X-Cfshare-Probe"
+)
+
+// Probe 对 url 发送一次 HEAD 请求。网络错误或 5xx (例如 Cloudflare 在没有可用
+// connector 时返回的 530/502) 视为不可达，返回错误。
+func Probe(ctx context.Context, client *http.Client, url, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ProbeHeader, "1")
+	if token != "" {
+		req.Header.Set(OwnerHeader, token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// Watchdog 周期性探测公开地址 (或执行 Check)，连续 Failures 次失败后进入降级状态并调用 Restart
+// 重启 tunnel；仍不可达时按 MinBackoff 起倍增、不超过 MaxBackoff 的间隔继续重启，
+// 直到恢复。
+type Watchdog struct {
+	URL      string
+	Token    string
+	Interval time.Duration
+	// Check 不为空时代替对 URL 的探测，返回错误表示失败
+	Check    func(ctx context.Context) error
+	Failures int
+	Timeout  time.Duration
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	Client *http.Client // 为空时使用带 Timeout 的默认客户端
+
+	// Restart 重启 tunnel，在进入降级状态后按退避间隔调用
+	Restart func() error
+	// OnChange 在进入 (degraded 为 true) 或退出降级状态时调用，err 为最近一次探测的错误
+	OnChange func(degraded bool, err error)
+	// OnRestart 在每次重启后调用，err 为 Restart 的返回值
+	OnRestart func(attempt int, err error)
+}
+
+// Run 持续探测直到 ctx 结束
+func (w *Watchdog) Run(ctx context.Context) {
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: w.Timeout}
+	}
+
+	var (
+		failures    int
+		degraded    bool
+		attempts    int
+		backoff     time.Duration
+		nextRestart time.Time
+	)
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		var err error
+		if w.Check != nil {
+			err = w.Check(ctx)
+		} else {
+			err = Probe(ctx, client, w.URL, w.Token)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil:
+			failures = 0
+			if degraded {
+				degraded = false
+				attempts = 0
+				if w.OnChange != nil {
+					w.OnChange(false, nil)
+				}
+			}
+
+		default:
+			failures++
+			if !degraded && failures >= w.Failures {
+				degraded = true
+				backoff = w.MinBackoff
+				nextRestart = time.Now()
+				if w.OnChange != nil {
+					w.OnChange(true, err)
+				}
+			}
+			if degraded && !time.Now().Before(nextRestart) && w.Restart != nil {
+				attempts++
+				rerr := w.Restart()
+				if w.OnRestart != nil {
+					w.OnRestart(attempts, rerr)
+				}
+				nextRestart = time.Now().Add(backoff)
+				backoff = min(backoff*2, w.MaxBackoff)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdogRestartsWithBackoff(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(OwnerHeader) != "tok" {
+			t.Error("expected owner token on probe")
+		}
+		if down.Load() {
+			w.WriteHeader(530)
+		}
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var changes []bool
+	var restarts []time.Time
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wd := &Watchdog{
+		URL:        srv.URL,
+		Token:      "tok",
+		Interval:   5 * time.Millisecond,
+		Failures:   3,
+		Timeout:    time.Second,
+		MinBackoff: 40 * time.Millisecond,
+		MaxBackoff: 80 * time.Millisecond,
+		Restart: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			restarts = append(restarts, time.Now())
+			// 第 3 次重启后恢复
+			if len(restarts) == 3 {
+				down.Store(false)
+			}
+			return nil
+		},
+		OnChange: func(degraded bool, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, degraded)
+			if !degraded {
+				cancel()
+			}
+		},
+	}
+	wd.Run(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Fatalf("state changes = %v, want [true false]", changes)
+	}
+	if len(restarts) != 3 {
+		t.Fatalf("restarts = %d, want 3", len(restarts))
+	}
+	if gap := restarts[1].Sub(restarts[0]); gap < 40*time.Millisecond {
+		t.Errorf("first backoff = %v, want >= 40ms", gap)
+	}
+	if gap := restarts[2].Sub(restarts[1]); gap < 80*time.Millisecond {
+		t.Errorf("second backoff = %v, want >= 80ms", gap)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 需要口令的分享返回 401 也说明边缘可达
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	if err := Probe(context.Background(), srv.Client(), srv.URL, ""); err != nil {
+		t.Errorf("401 treated as unreachable: %v", err)
+	}
+	if err := Probe(context.Background(), srv.Client(), "http://127.0.0.1:1", ""); err == nil {
+		t.Error("expected error for closed port")
+	}
+}
+
+func TestWatchdogCheck(t *testing.T) {
+	var checks, restarts atomic.Int32
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Check 代替 URL 探测: 前 3 次失败，重启后恢复
+	wd := &Watchdog{
+		Interval:   5 * time.Millisecond,
+		Failures:   3,
+		MinBackoff: time.Second,
+		MaxBackoff: time.Second,
+		Check: func(ctx context.Context) error {
+			if checks.Add(1) <= 3 {
+				return errors.New("no connections")
+			}
+			return nil
+		},
+		Restart: func() error {
+			restarts.Add(1)
+			return nil
+		},
+		OnChange: func(down bool, err error) {
+			if !down {
+				cancel()
+			}
+		},
+	}
+	wd.Run(ctx)
+
+	if restarts.Load() != 1 {
+		t.Errorf("restarts = %d, want 1", restarts.Load())
+	}
+	if ctx.Err() != context.Canceled {
+		t.Error("watchdog did not recover")
+	}
+}
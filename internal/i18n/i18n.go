@@ -0,0 +1,308 @@
+// Package i18n 提供网页界面的多语言文案和 Accept-Language 协商
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 支持的语言代码
+const (
+	English = "en"
+	Chinese = "zh"
+)
+
+// Default 是无法协商出语言时使用的语言
+const Default = Chinese
+
+// Language 描述一种可选语言，Name 为该语言中的自称，用于语言切换链接
+type Language struct {
+	Code string
+	Name string
+}
+
+var languages = []Language{
+	{Code: Chinese, Name: "中文"},
+	{Code: English, Name: "English"},
+}
+
+// catalogs 是各语言的文案，键在所有语言中必须一致。
+// 含格式化参数的文案使用 fmt 动词。
+var catalogs = map[string]map[string]string{
+	English: {
+		"index_of":        "Index of %s",
+		"search":          "Search",
+		"search_title":    "Search: %s",
+		"search_hint":     "Search all shared files…",
+		"results":         "%d results",
+		"back_home":       "Back to home",
+		"download_all":    "Download all",
+		"download_dir":    "Download folder",
+		"parent_dir":      "Parent directory",
+		"list_view":       "List view",
+		"gallery_view":    "Gallery view",
+		"name":            "Name",
+		"size":            "Size",
+		"modified":        "Modified",
+		"play":            "Play",
+		"confirm_receipt": "Confirm receipt",
+		"computing":       "computing",
+		"updated_at":      "Updated",
+		"no_matches":      "No matching files",
+		"empty_dir":       "Empty folder",
+		"prev_page":       "Previous",
+		"next_page":       "Next",
+		"page_of":         "Page %d of %d, %d items",
+		"language":        "Language",
+		"copy_link":       "Copy link",
+		"link_copied":     "Copied",
+		"qr_code":         "QR",
+		"qr_hint":         "Scan to download on your phone",
+
+		"large_file_warning": "This is a large file. Make sure you are on a fast, unmetered connection before downloading.",
+		"estimated_time":     "Estimated time at %s",
+		"start_download":     "Start download",
+		"go_back":            "Back",
+
+		"raw_file":       "Raw file (%s)",
+		"download":       "Download",
+		"terms_title":    "Terms of use",
+		"terms_agree":    "I have read and agree to the terms above",
+		"terms_continue": "Agree and continue",
+
+		"name_title":       "Who's downloading?",
+		"name_hint":        "The owner of this share asks visitors to leave their name (or a short note) before downloading. It is only shown to the owner.",
+		"name_placeholder": "Your name",
+		"name_continue":    "Continue",
+
+		"waiting_title":    "You're in the queue",
+		"waiting_position": "Your place in line: %d. Too many people are downloading right now.",
+		"waiting_hint":     "This page refreshes every %d seconds and your download starts automatically when it's your turn. Keep it open to hold your place.",
+		"approve_title":    "Waiting for approval",
+		"approve_message":  "The owner of this share approves each new visitor. They have been notified of your request.",
+		"approve_hint":     "This page refreshes every %d seconds and opens the content once your request is approved.",
+
+		"upload_title":      "Upload files",
+		"upload_button":     "Upload",
+		"upload_remaining":  "Files remaining: %d",
+		"upload_expires":    "Open until: %s",
+		"upload_choose":     "Please choose files to upload",
+		"upload_received":   "✅ Received %d file(s): %s",
+		"upload_closed":     "This file request is no longer open",
+		"upload_too_large":  "Not saved, over the size limit: %s",
+		"upload_no_space":   "The receiving computer is out of disk space",
+		"upload_max_size":   "Max file size: %s",
+		"upload_space_left": "Space left: %s",
+
+		"receipt_title":     "Confirm receipt",
+		"receipt_recorded":  "✅ Receipt recorded",
+		"receipt_id":        "Receipt ID",
+		"receipt_time":      "Time",
+		"receipt_prompt":    "After the download finishes, click the button below to confirm you received the file.",
+		"receipt_name_hint": "Name or note (optional)",
+		"receipt_download":  "Download the file first; you can confirm receipt here once the download has finished.",
+
+		"split_zip":   "Split ZIP",
+		"split_title": "Split download",
+		"split_hint":  "Each part is a standalone ZIP of up to %s. Download every part and extract them into the same folder.",
+		"split_part":  "Part %d of %d",
+		"split_files": "%d files",
+
+		"error_401":     "Password required",
+		"error_401_msg": "This share needs a username and password. The password may have been mistyped, please check it and try again (it is case-sensitive).",
+		"error_403":     "Access denied",
+		"error_403_msg": "You do not have permission to access this page.",
+		"error_404":     "Not found",
+		"error_404_msg": "The file or page you requested does not exist. It may have been removed from the share.",
+		"error_contact": "To get access, contact the person who shared this:",
+		"error_retry":   "Enter password again",
+
+		"login_title":    "Sign in",
+		"login_username": "Username",
+		"login_password": "Password",
+		"login_submit":   "Sign in",
+		"login_failed":   "Wrong username or password.",
+		"logout":         "Sign out",
+
+		"status_title":       "Share status",
+		"status_active":      "This share is active",
+		"status_expired":     "This share has expired",
+		"status_ended":       "This share has ended",
+		"status_expires":     "Available until %s.",
+		"status_no_expiry":   "No expiry has been set.",
+		"status_expired_at":  "It expired at %s and files can no longer be downloaded.",
+		"status_ended_msg":   "The download limit has been reached and files can no longer be downloaded.",
+		"status_stopped_msg": "The person who shared this stopped sharing at %s. If you still need the files, ask them for a new link.",
+	},
+	Chinese: {
+		"index_of":        "Index of %s",
+		"search":          "搜索",
+		"search_title":    "搜索: %s",
+		"search_hint":     "搜索所有分享的文件…",
+		"results":         "找到 %d 个结果",
+		"back_home":       "返回首页",
+		"download_all":    "全部下载",
+		"download_dir":    "打包下载",
+		"parent_dir":      "返回上级目录",
+		"list_view":       "列表视图",
+		"gallery_view":    "图库视图",
+		"name":            "名称",
+		"size":            "大小",
+		"modified":        "修改时间",
+		"play":            "播放",
+		"confirm_receipt": "确认收到",
+		"computing":       "计算中",
+		"updated_at":      "更新于",
+		"no_matches":      "没有匹配的文件",
+		"empty_dir":       "空目录",
+		"prev_page":       "上一页",
+		"next_page":       "下一页",
+		"page_of":         "第 %d / %d 页，共 %d 项",
+		"language":        "语言",
+		"copy_link":       "复制链接",
+		"link_copied":     "已复制",
+		"qr_code":         "二维码",
+		"qr_hint":         "用手机扫码下载",
+
+		"large_file_warning": "这是一个大文件，请确认网络速度和流量充足后再下载。",
+		"estimated_time":     "%s 下预计耗时",
+		"start_download":     "开始下载",
+		"go_back":            "返回",
+
+		"raw_file":       "原始文件 (%s)",
+		"download":       "下载",
+		"terms_title":    "使用条款",
+		"terms_agree":    "我已阅读并同意以上条款",
+		"terms_continue": "同意并继续",
+
+		"name_title":       "请留下您的姓名",
+		"name_hint":        "分享者希望知道是谁下载了文件，下载前请填写姓名（或简短备注），仅分享者可见。",
+		"name_placeholder": "姓名",
+		"name_continue":    "继续",
+
+		"waiting_title":    "正在排队",
+		"waiting_position": "当前下载的人较多，您排在第 %d 位。",
+		"waiting_hint":     "页面每 %d 秒自动刷新，轮到您时会自动开始下载。请保持页面打开以保留位置。",
+		"approve_title":    "等待分享者批准",
+		"approve_message":  "分享者需要逐一批准新访问者，已经通知分享者您的访问请求。",
+		"approve_hint":     "页面每 %d 秒自动刷新，批准后会自动打开内容。",
+
+		"upload_title":      "上传文件",
+		"upload_button":     "上传",
+		"upload_remaining":  "剩余可上传文件数: %d",
+		"upload_expires":    "有效期至: %s",
+		"upload_choose":     "请选择要上传的文件",
+		"upload_received":   "✅ 已收到 %d 个文件: %s",
+		"upload_closed":     "此文件请求已失效",
+		"upload_too_large":  "超出大小限制，未保存: %s",
+		"upload_no_space":   "接收方的磁盘空间不足",
+		"upload_max_size":   "单个文件上限: %s",
+		"upload_space_left": "剩余空间: %s",
+
+		"receipt_title":     "确认收到",
+		"receipt_recorded":  "✅ 已记录签收凭证",
+		"receipt_id":        "凭证编号",
+		"receipt_time":      "时间",
+		"receipt_prompt":    "下载完成后，请点击下方按钮确认已收到该文件。",
+		"receipt_name_hint": "姓名或备注（可选）",
+		"receipt_download":  "请先下载文件，下载完成后才能在这里确认收到。",
+
+		"split_zip":   "分卷 ZIP",
+		"split_title": "分卷下载",
+		"split_hint":  "每一卷都是独立的 ZIP，最大约 %s。请下载所有分卷并解压到同一个文件夹。",
+		"split_part":  "第 %d / %d 卷",
+		"split_files": "%d 个文件",
+
+		"error_401":     "需要口令",
+		"error_401_msg": "此分享需要用户名和口令才能访问。口令可能输入有误，请检查后重试（注意大小写）。",
+		"error_403":     "禁止访问",
+		"error_403_msg": "你没有权限访问此页面。",
+		"error_404":     "未找到",
+		"error_404_msg": "请求的文件或页面不存在，可能已从分享中移除。",
+		"error_contact": "如需获取访问权限，请联系分享者:",
+		"error_retry":   "重新输入口令",
+
+		"login_title":    "登录",
+		"login_username": "用户名",
+		"login_password": "口令",
+		"login_submit":   "登录",
+		"login_failed":   "用户名或口令错误。",
+		"logout":         "退出登录",
+
+		"status_title":       "分享状态",
+		"status_active":      "分享正常",
+		"status_expired":     "分享已到期",
+		"status_ended":       "分享已结束",
+		"status_expires":     "可下载至 %s。",
+		"status_no_expiry":   "未设置到期时间。",
+		"status_expired_at":  "分享已于 %s 到期，文件无法再下载。",
+		"status_ended_msg":   "下载次数已满，文件无法再下载。",
+		"status_stopped_msg": "分享者已于 %s 停止分享。如仍需要这些文件，请向分享者索取新的链接。",
+	},
+}
+
+// Languages 返回所有支持的语言
+func Languages() []Language {
+	return languages
+}
+
+// Supported 判断是否支持该语言代码
+func Supported(code string) bool {
+	_, ok := catalogs[code]
+	return ok
+}
+
+// T 返回 key 在 lang 中的文案；缺失时依次回退到默认语言和 key 本身
+func T(lang, key string, args ...any) string {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		if msg, ok = catalogs[Default][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Negotiate 按 Accept-Language 的权重选出支持的语言，只比较主语言标签
+// (zh-CN、zh-TW 都对应 zh)。没有可用语言时返回 fallback。
+func Negotiate(acceptLanguage, fallback string) string {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{tag, q})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, c := range candidates {
+		primary, _, _ := strings.Cut(c.tag, "-")
+		if Supported(primary) {
+			return primary
+		}
+	}
+	return fallback
+}
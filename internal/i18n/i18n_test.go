@@ -0,0 +1,47 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", Chinese},
+		{"en-US,en;q=0.9", English},
+		{"zh-CN,zh;q=0.9,en;q=0.8", Chinese},
+		{"fr-FR,fr;q=0.9,en;q=0.5", English},
+		{"en;q=0.3, zh-TW;q=0.7", Chinese},
+		{"de, *;q=0.1", Chinese},
+		{"en;q=0, zh", Chinese},
+		{"EN-gb", English},
+	}
+
+	for _, tt := range tests {
+		if got := Negotiate(tt.header, Chinese); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogsComplete(t *testing.T) {
+	for key := range catalogs[Default] {
+		for _, lang := range Languages() {
+			if _, ok := catalogs[lang.Code][key]; !ok {
+				t.Errorf("%s catalog is missing %q", lang.Code, key)
+			}
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := T(English, "page_of", 2, 3, 1200); got != "Page 2 of 3, 1200 items" {
+		t.Errorf("unexpected translation: %q", got)
+	}
+	if got := T("fr", "search"); got != "搜索" {
+		t.Errorf("expected fallback to default language, got %q", got)
+	}
+	if got := T(English, "no_such_key"); got != "no_such_key" {
+		t.Errorf("expected key for missing message, got %q", got)
+	}
+}
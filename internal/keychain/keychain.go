@@ -0,0 +1,40 @@
+// Package keychain 把分享口令保存到操作系统的凭据存储中
+// (macOS Keychain、Windows 凭据管理器、Linux libsecret)，避免明文写入 state.json。
+package keychain
+
+import "errors"
+
+// service 是在系统凭据存储中使用的服务名
+const service = "cfshare"
+
+// ErrUnavailable 表示当前系统没有可用的凭据存储
+var ErrUnavailable = errors.New("keychain not available")
+
+// Available 判断当前系统是否有可用的凭据存储
+func Available() bool {
+	return available()
+}
+
+// Set 保存 account 对应的口令，已存在时覆盖
+func Set(account, secret string) error {
+	if !available() {
+		return ErrUnavailable
+	}
+	return set(account, secret)
+}
+
+// Get 读取 account 对应的口令
+func Get(account string) (string, error) {
+	if !available() {
+		return "", ErrUnavailable
+	}
+	return get(account)
+}
+
+// Delete 删除 account 对应的口令，不存在时不报错
+func Delete(account string) error {
+	if !available() {
+		return ErrUnavailable
+	}
+	return del(account)
+}
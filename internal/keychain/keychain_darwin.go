@@ -0,0 +1,35 @@
+//go:build darwin
+
+package keychain
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func available() bool {
+	_, err := exec.LookPath("security")
+	return err == nil
+}
+
+func set(account, secret string) error {
+	cmd := exec.Command("security", "add-generic-password", "-U", "-s", service, "-a", account, "-w", secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("security add-generic-password: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func get(account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("security find-generic-password: %w", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func del(account string) error {
+	exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run()
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !darwin && !windows
+
+package keychain
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Linux 等系统通过 libsecret 的 secret-tool 访问 Secret Service
+func available() bool {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return false
+	}
+	// Secret Service 依赖 D-Bus 会话
+	return os.Getenv("DBUS_SESSION_BUS_ADDRESS") != ""
+}
+
+func set(account, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label=cfshare "+account, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("secret-tool store: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func get(account string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret-tool lookup: %w", err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func del(account string) error {
+	exec.Command("secret-tool", "clear", "service", service, "account", account).Run()
+	return nil
+}
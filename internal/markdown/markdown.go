@@ -0,0 +1,461 @@
+// Package markdown 把 Markdown 渲染为 HTML，支持 README 中常见的语法子集:
+// 标题、段落、列表、引用、代码块、表格、分隔线以及链接、图片、强调等行内元素。
+//
+// 源文本中的 HTML 一律转义，链接只允许 http、https、mailto 和相对地址，
+// 因此输出可以直接嵌入页面。
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	headingRe   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	fenceRe     = regexp.MustCompile("^ {0,3}(```+|~~~+)\\s*([^`\\s]*)")
+	bulletRe    = regexp.MustCompile(`^( {0,3})([-*+])\s+(.*)$`)
+	orderedRe   = regexp.MustCompile(`^( {0,3})(\d{1,9})[.)]\s+(.*)$`)
+	tableSepRe  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	autolinkRe  = regexp.MustCompile(`^<((?:https?://|mailto:)[^\s<>]+)>`)
+	urlSchemeRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+)
+
+// Render 把 Markdown 文本转换为 HTML
+func Render(src []byte) string {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(text, "\n"))
+	return b.String()
+}
+
+func renderBlocks(b *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case fenceRe.MatchString(line):
+			m := fenceRe.FindStringSubmatch(line)
+			fence := m[1]
+			var code []string
+			i++
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++ // 跳过结束标记
+			if m[2] != "" {
+				b.WriteString(`<pre><code class="language-` + html.EscapeString(m[2]) + `">`)
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+
+		case headingRe.MatchString(trimmed):
+			m := headingRe.FindStringSubmatch(trimmed)
+			tag := "h" + string(rune('0'+len(m[1])))
+			b.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">\n")
+			i++
+
+		case isRule(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, strings.TrimPrefix(q, " "))
+				i++
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quote)
+			b.WriteString("</blockquote>\n")
+
+		case bulletRe.MatchString(line) || orderedRe.MatchString(line):
+			i = renderList(b, lines, i)
+
+		case i+1 < len(lines) && strings.Contains(line, "|") && tableSepRe.MatchString(lines[i+1]):
+			i = renderTable(b, lines, i)
+
+		default:
+			var para []string
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines, i) {
+				para = append(para, lines[i])
+				i++
+			}
+			if len(para) == 0 {
+				// 无法识别的块，按普通文本处理，避免死循环
+				para = append(para, line)
+				i++
+			}
+			b.WriteString("<p>" + renderParagraph(para) + "</p>\n")
+		}
+	}
+}
+
+// startsBlock 判断第 i 行是否开始一个新的块，用于结束当前段落
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	trimmed := strings.TrimSpace(line)
+	return fenceRe.MatchString(line) ||
+		headingRe.MatchString(trimmed) ||
+		isRule(line) ||
+		strings.HasPrefix(trimmed, ">") ||
+		bulletRe.MatchString(line) ||
+		orderedRe.MatchString(line)
+}
+
+// renderParagraph 渲染段落，行尾两个空格或反斜杠表示强制换行
+func renderParagraph(lines []string) string {
+	parts := make([]string, len(lines))
+	for j, line := range lines {
+		hardBreak := strings.HasSuffix(line, "  ") || strings.HasSuffix(line, "\\")
+		line = strings.TrimSpace(line)
+		if hardBreak && j < len(lines)-1 {
+			parts[j] = renderInline(strings.TrimSuffix(line, "\\")) + "<br>"
+		} else {
+			parts[j] = renderInline(line)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func renderList(b *strings.Builder, lines []string, i int) int {
+	ordered := orderedRe.MatchString(lines[i])
+	itemRe := bulletRe
+	if ordered {
+		itemRe = orderedRe
+		m := orderedRe.FindStringSubmatch(lines[i])
+		if m[2] != "1" {
+			b.WriteString(`<ol start="` + html.EscapeString(strings.TrimLeft(m[2], "0")) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for i < len(lines) {
+		m := itemRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		indent := len(m[1])
+		item := []string{m[3]}
+		i++
+
+		// 缩进的后续行属于当前列表项 (包括嵌套列表)
+		loose := false
+		for i < len(lines) {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) > indent+1 {
+					item = append(item, "")
+					loose = true
+					i++
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) <= indent || (!strings.HasPrefix(line, " ") && startsBlock(lines, i)) {
+				break
+			}
+			item = append(item, dedent(line, indent+2))
+			i++
+		}
+
+		var inner strings.Builder
+		renderBlocks(&inner, item)
+		content := strings.TrimSuffix(inner.String(), "\n")
+		if !loose && strings.HasPrefix(content, "<p>") {
+			// 紧凑列表不包裹段落
+			if end := strings.Index(content, "</p>"); end >= 0 {
+				content = content[3:end] + content[end+4:]
+			}
+		}
+		b.WriteString("<li>" + content + "</li>\n")
+
+		// 列表项之间的空行
+		if i < len(lines) && strings.TrimSpace(lines[i]) == "" && i+1 < len(lines) && itemRe.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+	return i
+}
+
+func renderTable(b *strings.Builder, lines []string, i int) int {
+	header := splitRow(lines[i])
+	var aligns []string
+	for _, cell := range splitRow(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, "center")
+		case strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, "right")
+		case strings.HasPrefix(cell, ":"):
+			aligns = append(aligns, "left")
+		default:
+			aligns = append(aligns, "")
+		}
+	}
+	i += 2
+
+	writeRow := func(tag string, cells []string) {
+		b.WriteString("<tr>")
+		for j := range header {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			if j < len(aligns) && aligns[j] != "" {
+				b.WriteString("<" + tag + ` style="text-align: ` + aligns[j] + `">`)
+			} else {
+				b.WriteString("<" + tag + ">")
+			}
+			b.WriteString(renderInline(cell) + "</" + tag + ">")
+		}
+		b.WriteString("</tr>\n")
+	}
+
+	b.WriteString("<table>\n<thead>\n")
+	writeRow("th", header)
+	b.WriteString("</thead>\n<tbody>\n")
+	for i < len(lines) && strings.TrimSpace(lines[i]) != "" && strings.Contains(lines[i], "|") {
+		writeRow("td", splitRow(lines[i]))
+		i++
+	}
+	b.WriteString("</tbody>\n</table>\n")
+	return i
+}
+
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+
+	var cells []string
+	var cur strings.Builder
+	for j := 0; j < len(line); j++ {
+		switch {
+		case line[j] == '\\' && j+1 < len(line) && line[j+1] == '|':
+			cur.WriteByte('|')
+			j++
+		case line[j] == '|':
+			cells = append(cells, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteByte(line[j])
+		}
+	}
+	return append(cells, strings.TrimSpace(cur.String()))
+}
+
+// isRule 判断是否为分隔线: 三个及以上相同的 -、* 或 _，中间可以有空格
+func isRule(line string) bool {
+	if leadingSpaces(line) > 3 {
+		return false
+	}
+	s := strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	if len(s) < 3 || strings.IndexByte("-*_", s[0]) < 0 {
+		return false
+	}
+	return countRun(s, s[0]) == len(s)
+}
+
+func leadingSpaces(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " "))
+}
+
+func dedent(s string, n int) string {
+	if k := leadingSpaces(s); k < n {
+		n = k
+	}
+	return s[n:]
+}
+
+// renderInline 渲染行内元素并转义其余文本
+func renderInline(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte("\\`*_{}[]()#+-.!|~<>", s[i+1]) >= 0:
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			n := countRun(s[i:], '`')
+			fence := s[i : i+n]
+			if end := strings.Index(s[i+n:], fence); end >= 0 {
+				code := strings.TrimSpace(s[i+n : i+n+end])
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += n + end + n
+				continue
+			}
+			b.WriteString(fence)
+			i += n
+			continue
+
+		case c == '!' && i+1 < len(s) && s[i+1] == '[':
+			if text, url, n, ok := parseLink(s[i+1:]); ok {
+				b.WriteString(`<img src="` + html.EscapeString(safeURL(url)) + `" alt="` + html.EscapeString(text) + `">`)
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if text, url, n, ok := parseLink(s[i:]); ok {
+				b.WriteString(`<a href="` + html.EscapeString(safeURL(url)) + `">` + renderInline(text) + "</a>")
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if m := autolinkRe.FindStringSubmatch(s[i:]); m != nil {
+				b.WriteString(`<a href="` + html.EscapeString(m[1]) + `">` + html.EscapeString(m[1]) + "</a>")
+				i += len(m[0])
+				continue
+			}
+
+		case c == '~' && strings.HasPrefix(s[i:], "~~"):
+			if end := strings.Index(s[i+2:], "~~"); end > 0 {
+				b.WriteString("<del>" + renderInline(s[i+2:i+2+end]) + "</del>")
+				i += 2 + end + 2
+				continue
+			}
+
+		case c == '*' || c == '_':
+			// 单词内部的下划线 (如 snake_case) 不作为强调
+			if c == '_' && i > 0 && isWordChar(s[i-1]) {
+				break
+			}
+			n := countRun(s[i:], c)
+			if n >= 2 {
+				marker := s[i : i+2]
+				if end := strings.Index(s[i+2:], marker); end > 0 {
+					b.WriteString("<strong>" + renderInline(s[i+2:i+2+end]) + "</strong>")
+					i += 2 + end + 2
+					continue
+				}
+			} else if i+1 < len(s) && s[i+1] != ' ' {
+				if end := findCloser(s[i+1:], c); end > 0 {
+					b.WriteString("<em>" + renderInline(s[i+1:i+1+end]) + "</em>")
+					i += 1 + end + 1
+					continue
+				}
+			}
+		}
+
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// parseLink 解析 [text](url "title")，返回消耗的字节数
+func parseLink(s string) (text, url string, n int, ok bool) {
+	depth := 0
+	closeBracket := -1
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeBracket = j
+			}
+		}
+		if closeBracket >= 0 {
+			break
+		}
+	}
+	if closeBracket < 0 || closeBracket+1 >= len(s) || s[closeBracket+1] != '(' {
+		return "", "", 0, false
+	}
+
+	rest := s[closeBracket+2:]
+	depth = 1
+	closeParen := -1
+	for j := 0; j < len(rest) && closeParen < 0; j++ {
+		switch rest[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				closeParen = j
+			}
+		}
+	}
+	if closeParen < 0 {
+		return "", "", 0, false
+	}
+
+	dest := strings.TrimSpace(rest[:closeParen])
+	if strings.HasPrefix(dest, "<") {
+		if end := strings.Index(dest, ">"); end > 0 {
+			dest = dest[1:end]
+		}
+	} else if sp := strings.IndexAny(dest, " \t"); sp >= 0 {
+		dest = dest[:sp] // 忽略标题
+	}
+	return s[1:closeBracket], dest, closeBracket + 2 + closeParen + 1, true
+}
+
+// safeURL 只保留安全的链接协议，阻止 javascript: 等地址
+func safeURL(u string) string {
+	u = strings.TrimSpace(u)
+	if m := urlSchemeRe.FindStringSubmatch(u); m != nil {
+		switch strings.ToLower(m[1]) {
+		case "http", "https", "mailto":
+			return u
+		}
+		return "#"
+	}
+	return u
+}
+
+func findCloser(s string, c byte) int {
+	for j := 0; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == c && j > 0 && s[j-1] != ' ' && (j+1 >= len(s) || s[j+1] != c) {
+			if c == '_' && j+1 < len(s) && isWordChar(s[j+1]) {
+				continue
+			}
+			return j
+		}
+	}
+	return -1
+}
+
+func countRun(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
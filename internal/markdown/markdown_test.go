@@ -0,0 +1,50 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderBlocks(t *testing.T) {
+	src := "# Title\n\nSome **bold** and *em* text.\n\n- one\n- two\n\n1. first\n2. second\n\n> quote\n\n```go\nfmt.Println(\"<hi>\")\n```\n\n| a | b |\n|---|:-:|\n| 1 | 2 |\n"
+	out := Render([]byte(src))
+
+	for _, want := range []string{
+		"<h1>Title</h1>",
+		"<strong>bold</strong>",
+		"<em>em</em>",
+		"<ul>\n<li>one</li>\n<li>two</li>\n</ul>",
+		"<ol>\n<li>first</li>\n<li>second</li>\n</ol>",
+		"<blockquote>\n<p>quote</p>\n</blockquote>",
+		`<pre><code class="language-go">fmt.Println(&#34;&lt;hi&gt;&#34;)</code></pre>`,
+		`<th style="text-align: center">b</th>`,
+		"<td>1</td>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	out := Render([]byte("<script>alert(1)</script>\n\n[x](javascript:alert(1)) ![i](img.png)"))
+	if strings.Contains(out, "<script>") {
+		t.Error("raw HTML should be escaped")
+	}
+	if strings.Contains(out, "javascript:") {
+		t.Error("javascript: links should be dropped")
+	}
+	if !strings.Contains(out, `<img src="img.png" alt="i">`) {
+		t.Errorf("expected relative image, got %s", out)
+	}
+}
+
+func TestRenderInlineEdgeCases(t *testing.T) {
+	out := Render([]byte("use snake_case_name and `a*b*c` <https://example.com>"))
+	if strings.Contains(out, "<em>") {
+		t.Errorf("intraword underscores and code should not produce emphasis: %s", out)
+	}
+	if !strings.Contains(out, `<a href="https://example.com">`) {
+		t.Errorf("expected autolink: %s", out)
+	}
+}
@@ -0,0 +1,248 @@
+// Package mirror 把分享项上传到 R2/S3 兼容的对象存储，并生成预签名下载链接，
+// 本机离线时接收方仍可通过镜像下载。签名使用 AWS Signature Version 4，不依赖 SDK。
+package mirror
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	service   = "s3"
+	// unsignedPayload 让上传时不必预先计算整个文件的哈希 (仅用于 HTTPS)
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// MaxPresignTTL 是 SigV4 预签名链接允许的最长有效期
+	MaxPresignTTL = 7 * 24 * time.Hour
+)
+
+// Bucket 是一个 S3 兼容的存储桶，对象以 path-style 地址访问 (endpoint/bucket/key)
+type Bucket struct {
+	Endpoint  string // 例如 https://<account>.r2.cloudflarestorage.com
+	Region    string // R2 使用 auto
+	Name      string
+	AccessKey string
+	SecretKey string
+
+	Client *http.Client // 为空时使用 http.DefaultClient
+}
+
+// R2Endpoint 返回 Cloudflare R2 账户的 S3 API 地址
+func R2Endpoint(accountID string) string {
+	return "https://" + accountID + ".r2.cloudflarestorage.com"
+}
+
+// FromEnv 按环境变量创建存储桶: 凭据来自 AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY，
+// endpoint 为空时使用 R2_ACCOUNT_ID 对应的 R2 地址，区域来自 AWS_REGION (默认 auto)。
+func FromEnv(name, endpoint string) (*Bucket, error) {
+	b := &Bucket{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    os.Getenv("AWS_REGION"),
+		Name:      name,
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if b.Endpoint == "" {
+		account := os.Getenv("R2_ACCOUNT_ID")
+		if account == "" {
+			return nil, errors.New("未指定存储地址: 请设置 R2_ACCOUNT_ID 或使用 --endpoint")
+		}
+		b.Endpoint = R2Endpoint(account)
+	}
+	if b.Region == "" {
+		b.Region = "auto"
+	}
+	if b.AccessKey == "" || b.SecretKey == "" {
+		return nil, errors.New("缺少凭据: 请设置 AWS_ACCESS_KEY_ID 和 AWS_SECRET_ACCESS_KEY")
+	}
+	return b, nil
+}
+
+// objectURL 返回对象的地址，key 中的每一段分别转义
+func (b *Bucket) objectURL(key string) (*url.URL, error) {
+	u, err := url.Parse(b.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("无效的存储地址: %s", b.Endpoint)
+	}
+	u.Path = "/" + b.Name + "/" + key
+	u.RawPath = "/" + uriEncode(b.Name, false) + "/" + uriEncode(key, false)
+	return u, nil
+}
+
+// Put 上传文件内容为对象 key
+func (b *Bucket) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	u, err := b.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	b.sign(req, time.Now())
+
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("上传 %s 失败: %s %s", key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// PresignGet 返回对象的预签名下载链接，有效期最长 7 天
+func (b *Bucket) PresignGet(key string, ttl time.Duration, now time.Time) (string, error) {
+	if ttl <= 0 || ttl > MaxPresignTTL {
+		return "", fmt.Errorf("预签名链接的有效期必须在 1 秒到 7 天之间")
+	}
+	u, err := b.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = presign(http.MethodGet, u.Host, u.EscapedPath(), b.Region, b.AccessKey, b.SecretKey, ttl, now)
+	return u.String(), nil
+}
+
+// sign 为请求添加 SigV4 Authorization 头，签名 host、x-amz-content-sha256 和 x-amz-date
+func (b *Bucket) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := credentialScope(now, b.Region)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	canonicalHeaders, signedHeaders := canonicalizeHeaders(headers)
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	signature := signString(b.SecretKey, now, b.Region, stringToSign(amzDate, scope, canonical))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, b.AccessKey, scope, signedHeaders, signature))
+}
+
+// presign 生成预签名 URL 的查询字符串，只签名 host 头
+func presign(method, host, escapedPath, region, accessKey, secretKey string, ttl time.Duration, now time.Time) string {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := credentialScope(now, region)
+
+	params := [][2]string{
+		{"X-Amz-Algorithm", algorithm},
+		{"X-Amz-Credential", accessKey + "/" + scope},
+		{"X-Amz-Date", amzDate},
+		{"X-Amz-Expires", strconv.FormatInt(int64(ttl/time.Second), 10)},
+		{"X-Amz-SignedHeaders", "host"},
+	}
+	var query []string
+	for _, p := range params {
+		query = append(query, uriEncode(p[0], true)+"="+uriEncode(p[1], true))
+	}
+	sort.Strings(query)
+	canonicalQuery := strings.Join(query, "&")
+
+	canonical := strings.Join([]string{
+		method,
+		escapedPath,
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	signature := signString(secretKey, now, region, stringToSign(amzDate, scope, canonical))
+	return canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+func credentialScope(now time.Time, region string) string {
+	return now.Format("20060102") + "/" + region + "/" + service + "/aws4_request"
+}
+
+func canonicalizeHeaders(headers map[string]string) (canonical, signed string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+func stringToSign(amzDate, scope, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	return algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+}
+
+// signString 按 SigV4 派生签名密钥并对 stringToSign 签名
+func signString(secretKey string, now time.Time, region, s string) string {
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, s))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode 按 SigV4 规则转义: 只保留 A-Z a-z 0-9 - . _ ~，encodeSlash 为 false 时保留 /
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,37 @@
+// Package notify 把分享的汇总、告警等消息推送到 --notify 设置的 webhook。
+// 消息体是 JSON，text 字段兼容 Slack 等常见 webhook。
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// client 是推送 webhook 用的 HTTP 客户端
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Post 把 payload 以 JSON 形式 POST 到 webhook，非 2xx 响应作为错误返回
+func Post(url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Text 以 {"text": ...} 格式推送一条消息
+func Text(url, text string) error {
+	return Post(url, map[string]string{"text": text})
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	var got map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	if err := Text(ts.URL, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got["text"] != "hello" {
+		t.Errorf("payload = %v", got)
+	}
+}
+
+func TestPostReportsHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	if err := Post(ts.URL, struct{}{}); err == nil {
+		t.Error("non-2xx response not reported")
+	}
+}
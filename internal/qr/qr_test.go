@@ -0,0 +1,235 @@
+package qr
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// ISO/IEC 18004 附录中 "HELLO WORLD" (1-M) 的示例
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomon(data, 10); !bytes.Equal(got, want) {
+		t.Errorf("reedSolomon = %v, want %v", got, want)
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	if got := formatBits(0); got != 0b101010000010010 {
+		t.Errorf("formatBits(0) = %015b", got)
+	}
+}
+
+func TestEncodeVersionSelection(t *testing.T) {
+	tests := []struct {
+		n    int
+		size int
+	}{
+		{10, 21},  // 版本 1
+		{14, 21},  // 版本 1 的上限
+		{15, 25},  // 版本 2
+		{100, 41}, // 版本 6
+		{213, 57}, // 版本 10 的上限
+	}
+	for _, tt := range tests {
+		c, err := Encode(strings.Repeat("a", tt.n))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", tt.n, err)
+		}
+		if c.Size != tt.size {
+			t.Errorf("Encode(%d bytes) size = %d, want %d", tt.n, c.Size, tt.size)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("a", 214)); err != ErrTooLong {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestEncodeFunctionPatterns(t *testing.T) {
+	c, err := Encode("https://share.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 三个定位图案的中心和外框为深色，分隔符为浅色
+	for _, p := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		if !c.Dark(p[0], p[1]) || !c.Dark(p[0]-3, p[1]) || c.Dark(p[0]-2, p[1]) {
+			t.Errorf("finder pattern at %v is malformed", p)
+		}
+	}
+	// 定时图案
+	for i := 8; i < c.Size-8; i++ {
+		if c.Dark(i, 6) != (i%2 == 0) || c.Dark(6, i) != (i%2 == 0) {
+			t.Fatalf("timing pattern broken at %d", i)
+		}
+	}
+	if !c.Dark(8, c.Size-8) {
+		t.Error("dark module missing")
+	}
+
+	out := c.Terminal()
+	if lines := strings.Count(out, "\n"); lines != (c.Size+4+1)/2 {
+		t.Errorf("unexpected terminal height %d", lines)
+	}
+}
+
+// TestEncodeRoundTrip 按标准流程读回格式信息和数据，验证编码结果可以被解码
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, input := range []string{"hi", "dl:Xk3vQ9pLm2Rt8sWz", "https://share.example.com/report.pdf?sig=" + strings.Repeat("ab", 40)} {
+		c, err := Encode(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		version := (c.Size - 17) / 4
+
+		// 读取第一份格式信息并找到对应的掩码
+		bits := 0
+		get := func(x, y, i int) {
+			if c.Dark(x, y) {
+				bits |= 1 << i
+			}
+		}
+		for i := 0; i <= 5; i++ {
+			get(8, i, i)
+		}
+		get(8, 7, 6)
+		get(8, 8, 7)
+		get(7, 8, 8)
+		for i := 9; i < 15; i++ {
+			get(14-i, 8, i)
+		}
+		mask := -1
+		for m := 0; m < 8; m++ {
+			if formatBits(m) == bits {
+				mask = m
+			}
+		}
+		if mask < 0 {
+			t.Fatalf("%q: format bits %015b do not match any mask", input, bits)
+		}
+
+		// 去掉掩码后按之字形顺序读出码字
+		m := newMatrix(version)
+		for y := 0; y < c.Size; y++ {
+			for x := 0; x < c.Size; x++ {
+				m.modules[y][x] = c.Dark(x, y)
+			}
+		}
+		m.applyMask(mask)
+		var raw []byte
+		n := 0
+		for right := m.size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := 0; vert < m.size; vert++ {
+				for j := 0; j < 2; j++ {
+					x, y := right-j, vert
+					if (right+1)&2 == 0 {
+						y = m.size - 1 - vert
+					}
+					if m.isFunction[y][x] {
+						continue
+					}
+					if n%8 == 0 {
+						raw = append(raw, 0)
+					}
+					if m.modules[y][x] {
+						raw[n/8] |= 0x80 >> (n % 8)
+					}
+					n++
+				}
+			}
+		}
+
+		// 反交织并校验每个块的纠错码
+		spec := versionsM[version]
+		var blocks [][]byte
+		for _, g := range spec.groups {
+			for i := 0; i < g[0]; i++ {
+				blocks = append(blocks, make([]byte, 0, g[1]))
+			}
+		}
+		pos := 0
+		for i := 0; ; i++ {
+			added := false
+			for b := range blocks {
+				if i < cap(blocks[b]) {
+					blocks[b] = append(blocks[b], raw[pos])
+					pos++
+					added = true
+				}
+			}
+			if !added {
+				break
+			}
+		}
+		var data []byte
+		for b, block := range blocks {
+			ec := make([]byte, spec.ecPerBlock)
+			for i := range ec {
+				ec[i] = raw[pos+i*len(blocks)+b]
+			}
+			if !bytes.Equal(reedSolomon(block, spec.ecPerBlock), ec) {
+				t.Fatalf("%q: error correction mismatch in block %d", input, b)
+			}
+			data = append(data, block...)
+		}
+
+		// 解析字节模式数据
+		if data[0]>>4 != 0x4 {
+			t.Fatalf("%q: unexpected mode %x", input, data[0]>>4)
+		}
+		length := int(data[0]&0x0F)<<4 | int(data[1]>>4)
+		offset := 1
+		if version >= 10 {
+			t.Skip("16-bit count not exercised")
+		}
+		out := make([]byte, length)
+		for i := range out {
+			out[i] = data[offset+i]<<4 | data[offset+i+1]>>4
+		}
+		if string(out) != input {
+			t.Errorf("round trip = %q, want %q", out, input)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	c, err := Encode("https://share.example.com/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svg := c.SVG(4)
+	n := c.Size + 8
+	if !strings.HasPrefix(svg, fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d"`, n*4, n*4, n, n)) {
+		t.Errorf("unexpected SVG header: %.120s", svg)
+	}
+	if !strings.HasSuffix(svg, "</svg>") {
+		t.Error("SVG is not closed")
+	}
+
+	// 路径中的矩形覆盖的模块数等于深色模块数
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				dark++
+			}
+		}
+	}
+	covered := 0
+	for _, seg := range strings.Split(svg, "M")[1:] {
+		var x, y, run int
+		if _, err := fmt.Sscanf(seg, "%d %dh%d", &x, &y, &run); err != nil {
+			t.Fatalf("malformed path segment %q: %v", seg, err)
+		}
+		covered += run
+	}
+	if covered != dark {
+		t.Errorf("path covers %d modules, want %d", covered, dark)
+	}
+}
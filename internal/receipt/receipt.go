@@ -0,0 +1,151 @@
+package receipt
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// Receipt 是接收方确认收到文件后生成的签收凭证
+type Receipt struct {
+	ID         string    `json:"id"`
+	ShareID    string    `json:"share_id"`
+	Time       time.Time `json:"time"`
+	Path       string    `json:"path"`   // 请求的 URL 路径
+	Name       string    `json:"name"`   // 文件名
+	Size       int64     `json:"size"`   // 文件大小
+	SHA256     string    `json:"sha256"` // 签收时文件内容的哈希
+	RemoteAddr string    `json:"remote_addr"`
+	Recipient  string    `json:"recipient,omitempty"` // 接收方自填的姓名/备注
+	Signature  string    `json:"signature,omitempty"`
+}
+
+// payload 返回参与签名的字节 (不含签名本身)
+func (r Receipt) payload() []byte {
+	r.Signature = ""
+	data, _ := json.Marshal(r)
+	return data
+}
+
+func keyPath() string {
+	return filepath.Join(config.GetConfigDir(), "receipt.key")
+}
+
+func receiptsPath() string {
+	return filepath.Join(config.GetConfigDir(), "receipts.jsonl")
+}
+
+// loadKey 读取签名私钥，不存在时生成新的密钥
+func loadKey() (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(keyPath())
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid receipt key file %s", keyPath())
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read receipt key: %w", err)
+	}
+
+	if err := config.EnsureConfigDir(); err != nil {
+		return nil, fmt.Errorf("create config dir: %w", err)
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate receipt key: %w", err)
+	}
+	seed := base64.StdEncoding.EncodeToString(priv.Seed())
+	if err := os.WriteFile(keyPath(), []byte(seed), 0600); err != nil {
+		return nil, fmt.Errorf("write receipt key: %w", err)
+	}
+	return priv, nil
+}
+
+// PublicKey 返回用于验证签收凭证的公钥 (base64)
+func PublicKey() (string, error) {
+	priv, err := loadKey()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey)), nil
+}
+
+// Sign 为签收凭证生成 ID 并签名
+func Sign(r *Receipt) error {
+	priv, err := loadKey()
+	if err != nil {
+		return err
+	}
+	if r.ID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		r.ID = fmt.Sprintf("%x", b)
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, r.payload()))
+	return nil
+}
+
+// Verify 使用本机公钥验证签收凭证的签名
+func Verify(r Receipt) bool {
+	priv, err := loadKey()
+	if err != nil {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(priv.Public().(ed25519.PublicKey), r.payload(), sig)
+}
+
+// Append 追加保存一条签收凭证
+func Append(r Receipt) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+	f, err := os.OpenFile(receiptsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open receipts file: %w", err)
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal receipt: %w", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Load 读取所有签收凭证
+func Load() ([]Receipt, error) {
+	f, err := os.Open(receiptsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open receipts file: %w", err)
+	}
+	defer f.Close()
+
+	var receipts []Receipt
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Receipt
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		receipts = append(receipts, r)
+	}
+	return receipts, scanner.Err()
+}
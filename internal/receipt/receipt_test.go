@@ -0,0 +1,55 @@
+package receipt
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+
+	r := Receipt{
+		ShareID:    "test123",
+		Time:       time.Now().UTC(),
+		Path:       "/report.pdf",
+		Name:       "report.pdf",
+		Size:       1024,
+		SHA256:     "abc",
+		RemoteAddr: "203.0.113.5",
+	}
+	if err := Sign(&r); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if r.ID == "" || r.Signature == "" {
+		t.Fatal("Sign should fill ID and Signature")
+	}
+
+	if err := Append(r); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	loaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(loaded) != 1 {
+		t.Fatalf("expected 1 receipt, got %d", len(loaded))
+	}
+	if !Verify(loaded[0]) {
+		t.Error("loaded receipt should verify")
+	}
+
+	// 篡改后签名失效
+	loaded[0].SHA256 = "tampered"
+	if Verify(loaded[0]) {
+		t.Error("tampered receipt should not verify")
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+	"cfshare/internal/notify"
+	"cfshare/internal/state"
+)
+
+// approveMiddleware 实现 --approve: 访问者 (按 CF-Connecting-IP 区分) 第一次请求时进入待批准队列，
+// 服务进程通知分享者 (服务日志、访问日志和 --notify)，访问者看到自动刷新的等待页面。
+// 分享者用 cfshare approve/deny 或 cfshare status --watch 处理后，批准的访问者正常访问，
+// 拒绝的得到 403。分享者令牌不受影响。
+func (s *Server) approveMiddleware(next http.Handler) http.Handler {
+	if !s.state.Approve {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOwner(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		v, ok := state.FindVisitor(ip)
+		if !ok {
+			var added bool
+			var err error
+			v, added, err = state.RequestVisit(state.Visitor{
+				IP:        ip,
+				Country:   requestCountry(r),
+				UserAgent: r.UserAgent(),
+				User:      auth.UserFromContext(r.Context()),
+				Path:      r.URL.Path,
+				FirstSeen: time.Now(),
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[approve] %v\n", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if added {
+				s.announceVisitor(v)
+			}
+		}
+
+		switch v.Status {
+		case state.VisitorApproved:
+			next.ServeHTTP(w, r)
+		case state.VisitorDenied:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		default:
+			s.renderApprovalPage(w, r)
+		}
+	})
+}
+
+// announceVisitor 通知分享者有新访问者等待批准
+func (s *Server) announceVisitor(v state.Visitor) {
+	logData, _ := json.Marshal(map[string]interface{}{
+		"time":       v.FirstSeen.UTC().Format(time.RFC3339),
+		"event":      "visitor_pending",
+		"path":       v.Path,
+		"client_ip":  v.IP,
+		"user_agent": v.UserAgent,
+	})
+	appendToAccessLog(string(logData))
+
+	message := fmt.Sprintf("新访问者 %s 请求访问 %s，使用 cfshare approve %s 批准或 cfshare deny %s 拒绝", v.Describe(), v.Path, v.IP, v.IP)
+	fmt.Fprintf(os.Stderr, "[approve] %s\n", message)
+	if s.state.NotifyURL != "" {
+		go notify.Text(s.state.NotifyURL, "🔔 cfshare: "+message)
+	}
+}
+
+func (s *Server) renderApprovalPage(w http.ResponseWriter, r *http.Request) {
+	refresh := int(config.ApproveRefresh.Seconds())
+	lang := s.pageLang(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(refresh))
+	w.WriteHeader(http.StatusForbidden)
+
+	tmpl := template.Must(template.New("approve").Parse(approvalTemplate))
+	tmpl.Execute(w, struct {
+		Lang    string
+		Refresh int
+		T       func(key string, args ...any) string
+	}{
+		Lang:    lang,
+		Refresh: refresh,
+		T:       translator(lang),
+	})
+}
+
+const approvalTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta http-equiv="refresh" content="{{.Refresh}}">
+    <title>{{call .T "approve_title"}}</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 480px;
+            margin: 60px auto;
+            padding: 30px;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            text-align: center;
+        }
+        h1 { margin: 0 0 10px; font-size: 20px; font-weight: 500; }
+        p { color: #666; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🔔 {{call .T "approve_title"}}</h1>
+        <p>{{call .T "approve_message"}}</p>
+        <p>{{call .T "approve_hint" .Refresh}}</p>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cfshare/internal/state"
+)
+
+// archiveFormat 是打包下载支持的格式
+type archiveFormat string
+
+const (
+	formatZip   archiveFormat = "zip"
+	formatTarGz archiveFormat = "tar.gz"
+)
+
+// archiveFormatFromQuery 根据查询参数判断是否请求打包下载 (?zip=1 或 ?tgz=1)
+func archiveFormatFromQuery(r *http.Request) (archiveFormat, bool) {
+	q := r.URL.Query()
+	switch {
+	case q.Get("zip") == "1":
+		return formatZip, true
+	case q.Get("tgz") == "1":
+		return formatTarGz, true
+	}
+	return "", false
+}
+
+// archiveWriter 抽象 zip / tar.gz 的写入，便于统一遍历逻辑
+type archiveWriter interface {
+	addDir(name string, info fs.FileInfo) error
+	addFile(name, path string, info fs.FileInfo) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format archiveFormat) archiveWriter {
+	if format == formatTarGz {
+		gz := gzip.NewWriter(w)
+		return &tarArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipArchive{zw: zip.NewWriter(w)}
+}
+
+// serveArchive 以流的形式发送打包文件，边遍历边压缩，内存占用与目录大小无关。
+// fill 负责把内容写入归档。
+func serveArchive(w http.ResponseWriter, r *http.Request, name string, format archiveFormat, fill func(archiveWriter) error) {
+	if format == formatTarGz {
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(name+"."+string(format)))
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	aw := newArchiveWriter(w, format)
+	if err := fill(aw); err != nil {
+		// 响应头已发送，只能中断连接让客户端感知下载不完整
+		panic(http.ErrAbortHandler)
+	}
+	aw.Close()
+}
+
+// serveDirArchive 打包下载单个目录，skip 返回 true 的条目 (相对 dir 的路径) 不打包
+func serveDirArchive(w http.ResponseWriter, r *http.Request, dir string, format archiveFormat, skip func(rel string) bool) {
+	name := filepath.Base(dir)
+	serveArchive(w, r, name, format, func(aw archiveWriter) error {
+		return walkArchive(aw, dir, name, skip)
+	})
+}
+
+// serveItemsArchive 把所有分享项打包成一个归档 (多文件模式的 "全部下载")
+func serveItemsArchive(w http.ResponseWriter, r *http.Request, items []state.ShareItem, format archiveFormat, skip func(rel string) bool) {
+	serveArchive(w, r, "cfshare", format, func(aw archiveWriter) error {
+		for _, item := range items {
+			if item.ShareType == state.TypeDir {
+				if err := walkArchive(aw, item.Path, item.Name, skip); err != nil {
+					return err
+				}
+				continue
+			}
+			info, err := os.Stat(item.Path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if err := aw.addFile(item.Name, item.Path, info); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// walkArchive 把 dir 下的所有内容写入 aw，条目名以 prefix 开头。
+// 指向 dir 外部的符号链接、符号链接目录以及 skip 返回 true 的条目会被跳过。
+func walkArchive(aw archiveWriter, dir, prefix string, skip func(rel string) bool) error {
+	return walkArchiveEntries(dir, skip, func(rel, path string, info fs.FileInfo) error {
+		name := prefix
+		if rel != "" {
+			name = prefix + "/" + rel
+		}
+		if info.IsDir() {
+			return aw.addDir(name, info)
+		}
+		return aw.addFile(name, path, info)
+	})
+}
+
+// walkArchiveEntries 按字典序遍历 dir 下应当打包的目录和普通文件，
+// rel 为相对 dir 的路径 (dir 本身为空)，path 为实际读取的路径。
+func walkArchiveEntries(dir string, skip func(rel string) bool, fn func(rel, path string, info fs.FileInfo) error) error {
+	realBase, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(realBase, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 无法读取的子目录直接跳过，不中断整个打包
+			if d != nil && d.IsDir() && path != realBase {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(realBase, path)
+		if err != nil {
+			return nil
+		}
+		if rel == "." {
+			rel = ""
+		} else {
+			rel = filepath.ToSlash(rel)
+			if skip(rel) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil || !isWithin(target, realBase) {
+				return nil
+			}
+			info, err := os.Stat(target)
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			return fn(rel, target, info)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		return fn(rel, path, info)
+	})
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) addDir(name string, info fs.FileInfo) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name + "/"
+	_, err = a.zw.CreateHeader(hdr)
+	return err
+}
+
+func (a *zipArchive) addFile(name, path string, info fs.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+
+	dst, err := a.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchive) addDir(name string, info fs.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name + "/"
+	return a.tw.WriteHeader(hdr)
+}
+
+func (a *tarArchive) addFile(name, path string, info fs.FileInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// tar 头中的大小必须与写入的内容一致，文件在打包过程中变大时截断
+	_, err = io.CopyN(a.tw, f, hdr.Size)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// isWithin 判断 path 是否位于 base 目录内 (含 base 本身)
+func isWithin(path, base string) bool {
+	if path == base {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(base, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// WriteDirZip 按打包下载的规则 (--exclude 和隐藏文件过滤) 把目录写成 ZIP，
+// 供 cfshare mirror 上传镜像使用
+func WriteDirZip(w io.Writer, dir string, exclude []string, showHidden bool) error {
+	aw := newArchiveWriter(w, formatZip)
+	if err := walkArchive(aw, dir, filepath.Base(dir), newPathFilter(exclude, showHidden).excluded); err != nil {
+		return err
+	}
+	return aw.Close()
+}
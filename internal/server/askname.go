@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	namePath       = "/__name__"
+	nameCookieName = "cfshare_name"
+	maxVisitorName = 64
+)
+
+// askNameMiddleware 实现 --ask-name: 访问者下载前须先填写姓名 (或备注)，
+// 之后的请求都在访问日志中带上 visitor_name，分享给一小群人时能看出谁拿走了文件。
+// 姓名保存在 cookie 中，目录列表和页面照常浏览，只拦截下载。分享者本人不需要填写。
+func (s *Server) askNameMiddleware(next http.Handler) http.Handler {
+	if !s.state.AskName {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == namePath {
+			s.handleName(w, r)
+			return
+		}
+		if isOwner(r) || s.isFileRequest() || visitorName(r) != "" || !s.isTransfer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.renderNamePage(w, s.pageLang(w, r), http.StatusForbidden, r.URL.RequestURI(), "")
+	})
+}
+
+// visitorName 返回访问者在 --ask-name 页面填写的姓名
+func visitorName(r *http.Request) string {
+	c, err := r.Cookie(nameCookieName)
+	if err != nil {
+		return ""
+	}
+	name, err := url.QueryUnescape(c.Value)
+	if err != nil {
+		return ""
+	}
+	return cleanVisitorName(name)
+}
+
+// cleanVisitorName 去掉首尾空白和控制字符，超长或无效的姓名返回空字符串
+func cleanVisitorName(name string) string {
+	name = strings.Join(strings.FieldsFunc(name, func(c rune) bool {
+		return c < ' ' || c == 0x7f
+	}), " ")
+	name = strings.TrimSpace(name)
+	if !utf8.ValidString(name) || utf8.RuneCountInString(name) > maxVisitorName {
+		return ""
+	}
+	return name
+}
+
+func (s *Server) handleName(w http.ResponseWriter, r *http.Request) {
+	lang := s.pageLang(w, r)
+	if r.Method != http.MethodPost {
+		s.renderNamePage(w, lang, http.StatusOK, "/", visitorName(r))
+		return
+	}
+
+	r.ParseForm()
+	name := cleanVisitorName(r.PostFormValue("name"))
+	if name == "" {
+		s.renderNamePage(w, lang, http.StatusBadRequest, r.PostFormValue("next"), "")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     nameCookieName,
+		Value:    url.QueryEscape(name),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	logData, _ := json.Marshal(map[string]interface{}{
+		"time":         time.Now().UTC().Format(time.RFC3339),
+		"event":        "name_entered",
+		"visitor_name": name,
+		"client_ip":    clientIP(r),
+		"user_agent":   r.UserAgent(),
+	})
+	appendToAccessLog(string(logData))
+
+	http.Redirect(w, r, safeRedirect(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+func (s *Server) renderNamePage(w http.ResponseWriter, lang string, status int, next, name string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	tmpl := template.Must(template.New("name").Parse(nameTemplate))
+	tmpl.Execute(w, struct {
+		Lang   string
+		Action string
+		Next   string
+		Name   string
+		Max    int
+		T      func(key string, args ...any) string
+	}{
+		Lang:   lang,
+		Action: s.mounted(namePath),
+		Next:   safeRedirect(next),
+		Name:   name,
+		Max:    maxVisitorName,
+		T:      translator(lang),
+	})
+}
+
+const nameTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{call .T "name_title"}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 420px;
+            margin: 60px auto;
+            padding: 30px;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        h1 { margin: 0 0 10px; font-size: 20px; font-weight: 500; }
+        p { color: #666; font-size: 14px; }
+        input[type=text] {
+            width: 100%;
+            padding: 10px;
+            border: 1px solid #ddd;
+            border-radius: 6px;
+            font-size: 14px;
+        }
+        button {
+            margin-top: 15px;
+            padding: 10px 20px;
+            background: #2563eb;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            font-size: 14px;
+            cursor: pointer;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>👋 {{call .T "name_title"}}</h1>
+        <p>{{call .T "name_hint"}}</p>
+        <form method="post" action="{{.Action}}">
+            <input type="hidden" name="next" value="{{.Next}}">
+            <input type="text" name="name" value="{{.Name}}" maxlength="{{.Max}}" placeholder="{{call .T "name_placeholder"}}" required autofocus>
+            <button type="submit">{{call .T "name_continue"}}</button>
+        </form>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/notify"
+)
+
+// honeypotPaths 是扫描器常探测的路径，正常访问者不会请求这些地址。
+// 以 / 结尾的表示前缀匹配。
+var honeypotPaths = []string{
+	"/wp-login.php",
+	"/wp-admin/",
+	"/xmlrpc.php",
+	"/.env",
+	"/.git/",
+	"/.aws/",
+	"/phpmyadmin/",
+	"/admin.php",
+	"/config.php",
+	"/server-status",
+}
+
+// isHoneypotPath 判断请求路径是否为诱饵路径
+func isHoneypotPath(urlPath string) bool {
+	p := strings.ToLower(urlPath)
+	for _, h := range honeypotPaths {
+		if p == h || p == strings.TrimSuffix(h, "/") || (strings.HasSuffix(h, "/") && strings.HasPrefix(p, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+// banList 是临时封禁的 IP 列表，到期后自动解除
+type banList struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newBanList() *banList {
+	return &banList{until: make(map[string]time.Time)}
+}
+
+func (b *banList) ban(ip string, d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.until[ip] = time.Now().Add(d)
+}
+
+func (b *banList) banned(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(b.until, ip)
+		return false
+	}
+	return true
+}
+
+// banMiddleware 拒绝被临时封禁的 IP；启用 --honeypot 时，
+// 请求诱饵路径的 IP 会被立即封禁并触发异常告警。
+// 该中间件位于认证之前，扫描器不会收到口令提示。
+func (s *Server) banMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if s.bans.banned(ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		// 分享内容中真实存在的路径 (如共享目录里的 .env) 不当作诱饵
+		if s.state.Honeypot && isHoneypotPath(r.URL.Path) {
+			if _, err := s.resolve(r.URL.Path); err != nil {
+				s.bans.ban(ip, config.BanDuration)
+				s.raiseAnomaly(r, "honeypot", fmt.Sprintf("%s 请求诱饵路径 %s，已封禁 %s", ip, r.URL.Path, config.BanDuration))
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// raiseAnomaly 记录异常事件: 写入访问日志和服务日志，设置了 --notify 时推送到 webhook
+func (s *Server) raiseAnomaly(r *http.Request, kind, message string) {
+	logEntry := map[string]interface{}{
+		"time":        time.Now().UTC().Format(time.RFC3339),
+		"event":       "anomaly",
+		"kind":        kind,
+		"path":        r.URL.Path,
+		"remote_addr": r.RemoteAddr,
+		"client_ip":   clientIP(r),
+		"user_agent":  r.UserAgent(),
+		"message":     message,
+	}
+	logData, _ := json.Marshal(logEntry)
+	appendToAccessLog(string(logData))
+
+	fmt.Fprintf(os.Stderr, "[anomaly] %s\n", message)
+
+	if s.state.NotifyURL != "" {
+		go notify.Text(s.state.NotifyURL, "⚠️ cfshare: "+message)
+	}
+}
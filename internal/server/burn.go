@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+// burnTracker 记录一次性链接 (--burn) 正在进行中的下载。
+// 同一项目同时只允许一个下载，避免并发请求在标记失效前都拿到完整文件。
+type burnTracker struct {
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+func newBurnTracker() *burnTracker {
+	return &burnTracker{inFlight: make(map[string]bool)}
+}
+
+// claim 占用 key，已被其他请求占用时返回 false
+func (b *burnTracker) claim(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.inFlight[key] {
+		return false
+	}
+	b.inFlight[key] = true
+	return true
+}
+
+func (b *burnTracker) release(key string) {
+	b.mu.Lock()
+	delete(b.inFlight, key)
+	b.mu.Unlock()
+}
+
+// burnKey 返回请求对应的失效记录键: 按项目失效时为分享项名称，整个分享失效时为 state.BurnShareKey
+func (s *Server) burnKey(urlPath string) string {
+	if s.state.Burn == state.BurnShare {
+		return state.BurnShareKey
+	}
+	return s.itemName(urlPath)
+}
+
+// burnMiddleware 实现一次性链接: 文件被完整下载一次后对应项目 (或整个分享) 失效，之后返回 410。
+// 分段下载 (Range) 发送的字节合起来覆盖了整个文件时同样失效。
+// 失效记录保存在 stats.json 中，重启后仍然有效。页面和缩略图不受影响，分享者自己的请求不受限制。
+func (s *Server) burnMiddleware(next http.Handler) http.Handler {
+	if s.state.Burn == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOwner(r) || !s.isTransfer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := s.burnKey(r.URL.Path)
+		if state.IsBurned(s.state.ShareID, key) {
+			http.Error(w, "This link has already been used", http.StatusGone)
+			return
+		}
+		if !s.burn.claim(key) {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "This one-time link is being downloaded by someone else", http.StatusConflict)
+			return
+		}
+		defer s.burn.release(key)
+
+		next.ServeHTTP(w, r)
+
+		rw, ok := w.(*responseWriter)
+		if !ok || !s.downloadComplete(r, rw) {
+			return
+		}
+		marked, err := state.MarkBurned(s.state.ShareID, key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[burn] 记录失效状态失败: %v\n", err)
+			return
+		}
+		if !marked {
+			return
+		}
+
+		logData, _ := json.Marshal(map[string]interface{}{
+			"time":  time.Now().UTC().Format(time.RFC3339),
+			"event": "burned",
+			"item":  key,
+			"ip":    clientIP(r),
+		})
+		appendToAccessLog(string(logData))
+	})
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// fileETag 由文件大小和修改时间生成校验值，文件内容变化后随之改变
+func fileETag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// serveHTML 发送渲染好的页面，附带 ETag (页面内容的摘要) 和 Last-Modified，
+// 并处理 If-None-Match / If-Modified-Since，未变化时返回 304。
+// modTime 为零时不发送 Last-Modified。
+func serveHTML(w http.ResponseWriter, r *http.Request, modTime time.Time, body []byte) {
+	serveGenerated(w, r, modTime, "text/html; charset=utf-8", body)
+}
+
+// serveGenerated 与 serveHTML 相同，但可以指定 Content-Type
+func serveGenerated(w http.ResponseWriter, r *http.Request, modTime time.Time, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `W/"`+hex.EncodeToString(sum[:12])+`"`)
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
+
+// latestModTime 返回目录本身及其条目中最新的修改时间
+func latestModTime(dirModTime time.Time, files []FileInfo) time.Time {
+	latest := dirModTime
+	for _, f := range files {
+		if f.ModTime.After(latest) {
+			latest = f.ModTime
+		}
+	}
+	return latest
+}
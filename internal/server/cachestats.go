@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"cfshare/internal/storage"
+)
+
+// cachePath 是 cfshare cache 查询和清除服务进程内存缓存的地址，
+// 只接受来自本机并带分享者令牌的请求
+const cachePath = "/__cache__"
+
+// memoryCaches 是服务进程内存中的缓存名称
+var memoryCaches = []string{"checksums", "dirsizes", "templates"}
+
+// cacheStats 返回服务进程内存缓存的统计
+func (s *Server) cacheStats() []storage.Cache {
+	c := s.checksums
+	c.mu.Lock()
+	checksums := storage.Cache{Name: "checksums", Entries: len(c.sums), Where: "memory"}
+	for key, sum := range c.sums {
+		checksums.Bytes += int64(len(key) + len(sum))
+	}
+	c.mu.Unlock()
+
+	d := s.dirSizes
+	d.mu.Lock()
+	dirsizes := storage.Cache{Name: "dirsizes", Entries: len(d.sizes), Where: "memory"}
+	for key := range d.sizes {
+		dirsizes.Bytes += int64(len(key)) + 40
+	}
+	d.mu.Unlock()
+
+	l := s.templates
+	l.mu.Lock()
+	templates := storage.Cache{Name: "templates", Where: "memory"}
+	for _, t := range l.loaded {
+		if t.tmpl != nil {
+			templates.Entries++
+		}
+	}
+	l.mu.Unlock()
+
+	return []storage.Cache{checksums, dirsizes, templates}
+}
+
+// clearCache 清除名为 name 的内存缓存，返回是否存在该缓存
+func (s *Server) clearCache(name string) bool {
+	switch name {
+	case "checksums":
+		c := s.checksums
+		c.mu.Lock()
+		c.sums = make(map[string]string)
+		c.mu.Unlock()
+		// 重新为顶层文件排队计算
+		s.warmChecksums()
+	case "dirsizes":
+		d := s.dirSizes
+		d.mu.Lock()
+		d.sizes = make(map[string]dirSize)
+		d.mu.Unlock()
+		s.warmDirSizes()
+	case "templates":
+		l := s.templates
+		l.mu.Lock()
+		l.loaded = make(map[string]*loadedTemplate)
+		l.mu.Unlock()
+	default:
+		return false
+	}
+	return true
+}
+
+// handleCache 响应 cfshare cache: GET 返回内存缓存统计，POST ?name= 清除指定缓存 (不指定时全部清除)
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	if !loopbackRequest(r) || !s.validOwnerToken(ownerToken(r)) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		names := memoryCaches
+		if name := r.URL.Query().Get("name"); name != "" {
+			names = []string{name}
+		}
+		for _, name := range names {
+			if !s.clearCache(name) {
+				http.Error(w, "unknown cache "+name, http.StatusNotFound)
+				return
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(s.cacheStats())
+}
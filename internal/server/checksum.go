@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+// checksumQueueSize 是等待计算的文件数上限，队列满时新任务在下次访问时重新排队
+const checksumQueueSize = 1024
+
+type checksumJob struct {
+	path string
+	key  string
+}
+
+// checksumCache 在后台逐个计算分享文件的 SHA-256 并缓存结果。
+// 缓存键包含文件大小和修改时间，文件变化后会重新计算。
+type checksumCache struct {
+	mu      sync.Mutex
+	sums    map[string]string
+	pending map[string]bool
+	queue   chan checksumJob
+}
+
+func newChecksumCache() *checksumCache {
+	c := &checksumCache{
+		sums:    make(map[string]string),
+		pending: make(map[string]bool),
+		queue:   make(chan checksumJob, checksumQueueSize),
+	}
+	go c.run()
+	return c
+}
+
+func checksumKey(path string, size int64, modTime time.Time) string {
+	return fmt.Sprintf("%s\x00%d\x00%d", path, size, modTime.UnixNano())
+}
+
+// lookup 返回已计算好的校验值，尚未计算时加入后台队列并返回 false
+func (c *checksumCache) lookup(path string, size int64, modTime time.Time) (string, bool) {
+	key := checksumKey(path, size, modTime)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sum, ok := c.sums[key]; ok {
+		return sum, true
+	}
+	if !c.pending[key] {
+		select {
+		case c.queue <- checksumJob{path: path, key: key}:
+			c.pending[key] = true
+		default:
+		}
+	}
+	return "", false
+}
+
+// compute 同步计算校验值 (已缓存时直接返回)
+func (c *checksumCache) compute(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := checksumKey(path, info.Size(), info.ModTime())
+
+	c.mu.Lock()
+	sum, ok := c.sums[key]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	sum, err = fileSHA256(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.sums[key] = sum
+	c.mu.Unlock()
+	return sum, nil
+}
+
+func (c *checksumCache) run() {
+	for job := range c.queue {
+		// 排队期间文件可能已变化，按当前状态计算并缓存
+		c.compute(job.path)
+
+		c.mu.Lock()
+		delete(c.pending, job.key)
+		c.mu.Unlock()
+	}
+}
+
+// warmChecksums 启动时为顶层分享的文件排队计算校验值，目录中的文件在首次列出时排队
+func (s *Server) warmChecksums() {
+	for _, item := range s.shares().items {
+		if item.ShareType != state.TypeFile {
+			continue
+		}
+		if info, err := os.Stat(item.Path); err == nil && info.Mode().IsRegular() {
+			s.checksums.lookup(item.Path, info.Size(), info.ModTime())
+		}
+	}
+}
+
+// fillChecksums 为列表中的普通文件填充已算好的校验值，未算好的加入后台队列
+func (s *Server) fillChecksums(files []FileInfo) {
+	for i := range files {
+		f := &files[i]
+		if f.IsDir || f.diskPath == "" {
+			continue
+		}
+		f.Checksum, _ = s.checksums.lookup(f.diskPath, f.Size, f.ModTime)
+	}
+}
+
+// handleChecksum 响应 /<name>.sha256，输出与 sha256sum 兼容的格式。
+// 对应文件不存在或分享中本来就有同名 .sha256 文件时返回 false，由后续逻辑处理。
+func (s *Server) handleChecksum(w http.ResponseWriter, r *http.Request) bool {
+	if _, err := s.resolve(r.URL.Path); err == nil {
+		return false
+	}
+
+	fullPath, err := s.resolve(strings.TrimSuffix(r.URL.Path, ".sha256"))
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+
+	sum, err := s.checksums.compute(fullPath)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return true
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", fileETag(info))
+	fmt.Fprintf(w, "%s  %s\n", sum, filepath.Base(fullPath))
+	return true
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// estimateSpeeds 是确认页上用来估算下载时间的典型带宽 (bit/s)
+var estimateSpeeds = []struct {
+	Label string
+	Bits  float64
+}{
+	{"10 Mbps", 10e6},
+	{"100 Mbps", 100e6},
+	{"1 Gbps", 1e9},
+}
+
+// download 发送文件附件。浏览器直接打开超过 ConfirmSize 的文件时先显示确认页，
+// 避免手机上误触发几十 GB 的下载；curl 等客户端和断点续传不受影响。
+func (s *Server) download(w http.ResponseWriter, r *http.Request, path, name string) {
+	if s.state.ConfirmSize > 0 && needsConfirm(r) {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Size() >= s.state.ConfirmSize {
+			s.serveConfirmPage(w, r, path, name, info)
+			return
+		}
+	}
+	serveDownload(w, r, path, name)
+}
+
+// needsConfirm 判断是否为浏览器的首次下载请求
+func needsConfirm(r *http.Request) bool {
+	if r.Method != http.MethodGet || r.Header.Get("Range") != "" || r.URL.Query().Get("confirm") == "1" {
+		return false
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// serveConfirmPage 显示文件大小、预计下载时间和校验值，点击后才开始下载
+func (s *Server) serveConfirmPage(w http.ResponseWriter, r *http.Request, path, name string, info os.FileInfo) {
+	lang := s.pageLang(w, r)
+	checksum, _ := s.checksums.lookup(path, info.Size(), info.ModTime())
+
+	q := r.URL.Query()
+	q.Del("lang")
+	q.Set("confirm", "1")
+
+	type estimate struct {
+		Speed string
+		Time  string
+	}
+	var estimates []estimate
+	for _, speed := range estimateSpeeds {
+		seconds := float64(info.Size()) * 8 / speed.Bits
+		estimates = append(estimates, estimate{speed.Label, formatETA(time.Duration(seconds * float64(time.Second)))})
+	}
+
+	data := struct {
+		Lang        string
+		Name        string
+		Size        string
+		Checksum    string
+		Estimates   []estimate
+		DownloadURL string
+		T           func(key string, args ...any) string
+	}{
+		Lang:        lang,
+		Name:        name,
+		Size:        formatSize(info.Size()),
+		Checksum:    checksum,
+		Estimates:   estimates,
+		DownloadURL: s.mounted(r.URL.EscapedPath()) + "?" + q.Encode(),
+		T:           translator(lang),
+	}
+
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("confirm").Parse(confirmTemplate))
+	if err := tmpl.Execute(&buf, data); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Vary", "Accept, Accept-Language, Cookie")
+	serveHTML(w, r, info.ModTime(), buf.Bytes())
+}
+
+// formatETA 把预计时间格式化为 "3 h 20 min" 这样的粗略值
+func formatETA(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d s", int(d.Seconds())+1)
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()+0.5))
+	default:
+		return fmt.Sprintf("%d h %d min", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+const confirmTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Name}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 520px;
+            margin: 40px auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            padding: 25px;
+        }
+        h1 {
+            margin: 0 0 10px;
+            font-size: 18px;
+            font-weight: 500;
+            word-break: break-all;
+        }
+        .size { font-size: 28px; font-weight: 600; color: #b45309; }
+        .warning { color: #6b7280; font-size: 14px; }
+        table { width: 100%; border-collapse: collapse; margin: 15px 0; font-size: 14px; }
+        td { padding: 6px 0; border-bottom: 1px solid #eee; }
+        td:last-child { text-align: right; color: #374151; }
+        .checksum { font-size: 12px; color: #6b7280; word-break: break-all; }
+        .button {
+            display: block;
+            margin-top: 20px;
+            padding: 12px;
+            background: #2563eb;
+            color: white;
+            text-align: center;
+            border-radius: 6px;
+            text-decoration: none;
+            font-size: 16px;
+        }
+        .cancel { display: block; margin-top: 12px; text-align: center; font-size: 14px; color: #6b7280; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>📦 {{.Name}}</h1>
+        <div class="size">{{.Size}}</div>
+        <p class="warning">{{call .T "large_file_warning"}}</p>
+        <table>
+            {{range .Estimates}}
+            <tr><td>{{call $.T "estimated_time" .Speed}}</td><td>{{.Time}}</td></tr>
+            {{end}}
+        </table>
+        <div class="checksum">SHA-256: {{if .Checksum}}<code>{{.Checksum}}</code>{{else}}{{call .T "computing"}}{{end}}</div>
+        <a class="button" href="{{.DownloadURL}}">⬇️ {{call .T "start_download"}}</a>
+        <a class="cancel" href="./">{{call .T "go_back"}}</a>
+    </div>
+</body>
+</html>`
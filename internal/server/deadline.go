@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// 中止原因，记录在访问日志和统计中
+const (
+	abortDeadline = "deadline" // 超过 --max-duration
+	abortSlow     = "slow"     // 传输速率低于 --min-rate
+)
+
+// deadlineMiddleware 限制单个请求的总时长 (--max-duration) 和最低传输速率 (--min-rate)。
+// 速率按 config.MinRateWindow 窗口统计发送和接收的字节数，第一个窗口作为宽限期不检查；
+// 低于下限时把连接的读写截止时间设为当前时间，阻塞中的读写立即失败，连接被释放。
+// 分享者自己的请求不受限制。
+func (s *Server) deadlineMiddleware(next http.Handler) http.Handler {
+	maxDuration, minRate := s.state.MaxRequestDuration, s.state.MinRate
+	if maxDuration <= 0 && minRate <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOwner(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		var transferred atomic.Int64
+		var reason atomic.Value
+		rc := http.NewResponseController(w)
+		abort := func(why string) {
+			if !reason.CompareAndSwap(nil, why) {
+				return
+			}
+			rc.SetReadDeadline(time.Now())
+			rc.SetWriteDeadline(time.Now())
+			cancel()
+		}
+
+		if maxDuration > 0 {
+			timer := time.AfterFunc(maxDuration, func() { abort(abortDeadline) })
+			defer timer.Stop()
+		}
+		if minRate > 0 {
+			go watchRate(ctx, &transferred, minRate, config.MinRateWindow, func() { abort(abortSlow) })
+		}
+
+		if r.Body != nil {
+			r.Body = &countingBody{ReadCloser: r.Body, n: &transferred}
+		}
+		next.ServeHTTP(&countingWriter{ResponseWriter: w, n: &transferred}, r.WithContext(ctx))
+
+		why, _ := reason.Load().(string)
+		if why == "" {
+			return
+		}
+		if rw, ok := w.(*responseWriter); ok {
+			rw.aborted = why
+		}
+		fmt.Fprintf(os.Stderr, "[abort] %s %s from %s: %s after %s, %d bytes\n",
+			r.Method, r.URL.Path, clientIP(r), abortMessage(why), time.Since(requestStart(w)).Round(time.Second), transferred.Load())
+	})
+}
+
+// watchRate 每个窗口检查一次传输量，第一个窗口之后任一窗口低于 minRate 字节/秒即调用 abort
+func watchRate(ctx context.Context, transferred *atomic.Int64, minRate int64, window time.Duration, abort func()) {
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+	last := int64(0)
+	grace := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n := transferred.Load()
+		if !grace && float64(n-last)/window.Seconds() < float64(minRate) {
+			abort()
+			return
+		}
+		grace = false
+		last = n
+	}
+}
+
+func abortMessage(why string) string {
+	if why == abortSlow {
+		return "传输过慢"
+	}
+	return "超过最长请求时间"
+}
+
+// requestStart 返回日志中间件记录的请求开始时间，取不到时返回当前时间
+func requestStart(w http.ResponseWriter) time.Time {
+	if rw, ok := w.(*responseWriter); ok && !rw.start.IsZero() {
+		return rw.start
+	}
+	return time.Now()
+}
+
+// countingWriter 统计已发送的字节数
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// countingBody 统计已接收的请求体字节数，上传也算作传输进度
+type countingBody struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (cb *countingBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.n.Add(int64(n))
+	return n, err
+}
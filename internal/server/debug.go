@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"time"
+)
+
+// loopbackRetryInterval 是调试或指标地址被占用时重试监听的间隔。平滑升级时旧服务进程
+// 退出前仍占用该地址，新进程会在旧进程释放后接管。
+const loopbackRetryInterval = 5 * time.Second
+
+// ValidateLoopbackAddr 检查 --debug-addr、--metrics-addr 是 host:port 形式的本机回环地址。
+// 这些接口不做认证，不允许监听在其他地址上。
+func ValidateLoopbackAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("expected host:port, got %q", addr)
+	}
+	if port == "" {
+		return fmt.Errorf("missing port in %q", addr)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("%q is not a loopback address; use 127.0.0.1 or localhost", host)
+	}
+	return nil
+}
+
+// debugHandler 返回 net/http/pprof 和 expvar 的处理器:
+// /debug/pprof/ 下为性能分析，/debug/vars 为 expvar (包括 cfshare_resources)
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// ServeDebug 在 --debug-addr 上提供 pprof、expvar 和 /metrics，直到 ctx 结束
+func (s *Server) ServeDebug(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", debugHandler())
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.healthHandlers(mux)
+	serveLoopback(ctx, "debug", s.state.DebugAddr, mux)
+}
+
+// ServeMetrics 在 --metrics-addr 上提供 Prometheus 格式的 /metrics，直到 ctx 结束
+func (s *Server) ServeMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	s.healthHandlers(mux)
+	serveLoopback(ctx, "metrics", s.state.MetricsAddr, mux)
+}
+
+// serveLoopback 在本机回环地址上提供 handler，直到 ctx 结束。
+// 这些接口不影响分享: 地址被占用时只记录错误并稍后重试。
+func serveLoopback(ctx context.Context, name, addr string, handler http.Handler) {
+	if addr == "" {
+		return
+	}
+	if err := ValidateLoopbackAddr(addr); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] %v\n", name, err)
+		return
+	}
+	publishResources()
+
+	for {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil {
+			srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			fmt.Fprintf(os.Stderr, "[%s] listening on http://%s/\n", name, ln.Addr())
+			srv.Serve(ln)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "[%s] listen %s: %v, retrying\n", name, addr, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(loopbackRetryInterval):
+		}
+	}
+}
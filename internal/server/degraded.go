@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/health"
+	"cfshare/internal/notify"
+	"cfshare/internal/state"
+)
+
+// WatchEdge 持续探测分享的公开地址，直到 ctx 结束。本地服务正常但公开地址连续不可达时
+// 把分享标记为降级 (health.json)、写入日志并推送 --notify 告警，然后按退避间隔调用
+// restart 重启 tunnel；恢复后清除降级标记并再次告警。
+func (s *Server) WatchEdge(ctx context.Context, restart func() error) {
+	if s.state.PublicURL == "" {
+		return
+	}
+
+	s.updateHealth(func(h *state.Health) {})
+
+	var since time.Time
+	wd := &health.Watchdog{
+		URL:        s.state.PublicURL,
+		Token:      s.state.OwnerToken,
+		Interval:   config.HealthInterval,
+		Failures:   config.HealthFailures,
+		Timeout:    config.StandbyTimeout,
+		MinBackoff: config.RestartMinBackoff,
+		MaxBackoff: config.RestartMaxBackoff,
+		Restart:    restart,
+		OnChange: func(degraded bool, err error) {
+			if degraded {
+				since = time.Now()
+				s.edgeEvent("degraded", fmt.Sprintf("公开地址 %s 无法访问 (%v)，正在重启 tunnel", s.state.PublicURL, err))
+			} else {
+				s.edgeEvent("recovered", fmt.Sprintf("公开地址 %s 已恢复访问 (降级 %s)", s.state.PublicURL, time.Since(since).Round(time.Second)))
+			}
+			s.updateHealth(func(h *state.Health) {
+				h.Degraded, h.Since, h.LastError, h.Restarts = degraded, time.Time{}, "", 0
+				if degraded {
+					h.Since, h.LastError = since, err.Error()
+				}
+			})
+		},
+		OnRestart: func(attempt int, err error) {
+			s.updateHealth(func(h *state.Health) {
+				h.Restarts = attempt
+				if err != nil {
+					h.LastError = "重启 tunnel 失败: " + err.Error()
+				}
+			})
+		},
+	}
+	wd.Run(ctx)
+}
+
+// TunnelCheck 是一次 cloudflared 边缘连接检查的结果
+type TunnelCheck struct {
+	Running     bool   // cloudflared 进程在运行，为 false 时不做判断
+	Connections int    // 注册到边缘的连接数
+	Source      string // 连接数的来源: metrics 或 log
+	Err         error  // 无法读取连接数的原因
+}
+
+// WatchTunnel 定期调用 check 读取 cloudflared 注册到边缘的连接数，直到 ctx 结束。
+// 进程存活不代表连接正常: cloudflared 在运行但持续没有连接时标记中断 (health.json)、
+// 写入日志并推送 --notify 告警，然后按退避间隔调用 restart 重启 tunnel。
+// cloudflared 没有运行的情况由 WatchEdge 通过公开地址发现。
+func (s *Server) WatchTunnel(ctx context.Context, check func() TunnelCheck, restart func() error) {
+	if s.state.PublicURL == "" {
+		return
+	}
+
+	var since time.Time
+	wd := &health.Watchdog{
+		Interval:   config.TunnelCheckInterval,
+		Failures:   config.TunnelCheckFailures,
+		MinBackoff: config.RestartMinBackoff,
+		MaxBackoff: config.RestartMaxBackoff,
+		Restart:    restart,
+		Check: func(ctx context.Context) error {
+			c := check()
+			s.updateHealth(func(h *state.Health) {
+				h.CheckedAt, h.Connections, h.ConnSource = time.Now(), c.Connections, c.Source
+				if !c.Running {
+					h.CheckedAt = time.Time{}
+				}
+			})
+			switch {
+			case !c.Running:
+				return nil
+			case c.Err != nil:
+				return c.Err
+			case c.Connections == 0:
+				return fmt.Errorf("没有注册到边缘的连接")
+			}
+			return nil
+		},
+		OnChange: func(down bool, err error) {
+			if down {
+				since = time.Now()
+				s.edgeEvent("disconnected", fmt.Sprintf("cloudflared 在运行但没有边缘连接 (%v)，正在重启 tunnel", err))
+			} else {
+				s.edgeEvent("recovered", fmt.Sprintf("cloudflared 已重新连接到边缘 (中断 %s)", time.Since(since).Round(time.Second)))
+			}
+			s.updateHealth(func(h *state.Health) {
+				h.TunnelDown, h.DownSince, h.TunnelError, h.TunnelRestarts = down, time.Time{}, "", 0
+				if down {
+					h.DownSince, h.TunnelError = since, err.Error()
+				}
+			})
+		},
+		OnRestart: func(attempt int, err error) {
+			s.updateHealth(func(h *state.Health) {
+				h.TunnelRestarts = attempt
+				if err != nil {
+					h.TunnelError = "重启 tunnel 失败: " + err.Error()
+				}
+			})
+		},
+	}
+	wd.Run(ctx)
+}
+
+// updateHealth 修改并写入 health.json，WatchEdge 和 WatchTunnel 各自只修改自己的字段
+func (s *Server) updateHealth(update func(h *state.Health)) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	update(&s.health)
+	state.SaveHealth(&s.health)
+}
+
+// edgeEvent 记录公开地址可达性的变化: 写入访问日志和服务日志，设置了 --notify 时推送
+func (s *Server) edgeEvent(kind, message string) {
+	logData, _ := json.Marshal(map[string]interface{}{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"event":   "edge",
+		"kind":    kind,
+		"message": message,
+	})
+	appendToAccessLog(string(logData))
+
+	fmt.Fprintf(os.Stderr, "[edge] %s\n", message)
+
+	if s.state.NotifyURL != "" {
+		icon := "⚠️"
+		if kind == "recovered" {
+			icon = "✅"
+		}
+		go notify.Text(s.state.NotifyURL, icon+" cfshare: "+message)
+	}
+}
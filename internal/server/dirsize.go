@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+const (
+	// dirSizeQueueSize 是等待统计的目录数上限，队列满时新任务在下次列出时重新排队
+	dirSizeQueueSize = 256
+	// dirSizeTTL 是统计结果的有效期，过期后再次列出时在后台重新统计，完成前继续显示旧值
+	dirSizeTTL = 5 * time.Minute
+	// dirSizeProgress 是统计大目录时公布中间结果的间隔 (文件数)
+	dirSizeProgress = 1000
+)
+
+// dirSize 是目录的递归大小，只计访问者可见的普通文件
+type dirSize struct {
+	files int
+	bytes int64
+	done  bool      // 为 false 时正在统计，bytes 是目前已统计的部分
+	at    time.Time // 统计完成的时间
+}
+
+type dirSizeJob struct {
+	path string
+	rel  string // 在分享项内的相对路径，用于 --exclude 和隐藏文件过滤
+	item string // 非空时是顶层分享项，结果同时写入 sizes.json 供 cfshare status 显示
+}
+
+// dirSizeCache 在后台逐个统计目录的递归大小并缓存结果。
+// 统计一个目录时顺带记录其下每个子目录的大小，进入子目录时通常不必重新统计。
+type dirSizeCache struct {
+	mu      sync.Mutex
+	sizes   map[string]dirSize
+	pending map[string]bool
+	queue   chan dirSizeJob
+	filter  *pathFilter
+}
+
+func newDirSizeCache(filter *pathFilter) *dirSizeCache {
+	c := &dirSizeCache{
+		sizes:   make(map[string]dirSize),
+		pending: make(map[string]bool),
+		queue:   make(chan dirSizeJob, dirSizeQueueSize),
+		filter:  filter,
+	}
+	go c.run()
+	return c
+}
+
+// lookup 返回目录的大小。尚未统计或结果已过期时加入后台队列；
+// 第二个返回值为 false 表示还没有任何结果。
+func (c *dirSizeCache) lookup(job dirSizeJob) (dirSize, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ds, ok := c.sizes[job.path]
+	stale := !ok || (ds.done && time.Since(ds.at) > dirSizeTTL)
+	if stale && !c.pending[job.path] {
+		select {
+		case c.queue <- job:
+			c.pending[job.path] = true
+		default:
+		}
+	}
+	return ds, ok
+}
+
+func (c *dirSizeCache) run() {
+	for job := range c.queue {
+		c.measure(job)
+
+		c.mu.Lock()
+		delete(c.pending, job.path)
+		c.mu.Unlock()
+	}
+}
+
+// measure 统计 job 对应的目录，每统计 dirSizeProgress 个文件公布一次中间结果
+func (c *dirSizeCache) measure(job dirSizeJob) {
+	var progress dirSize
+	var walk func(dir, rel string) dirSize
+	walk = func(dir, rel string) dirSize {
+		var ds dirSize
+		// 无法读取的目录计为空，结果过期后再试
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			childRel := path.Join(rel, entry.Name())
+			if c.filter.excluded(childRel) {
+				continue
+			}
+			if entry.IsDir() {
+				sub := walk(filepath.Join(dir, entry.Name()), childRel)
+				ds.files += sub.files
+				ds.bytes += sub.bytes
+				continue
+			}
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			ds.files++
+			ds.bytes += info.Size()
+			progress.files++
+			progress.bytes += info.Size()
+			if progress.files%dirSizeProgress == 0 {
+				c.publish(job, progress)
+			}
+		}
+		ds.done = true
+		ds.at = time.Now()
+		c.mu.Lock()
+		c.sizes[dir] = ds
+		c.mu.Unlock()
+		return ds
+	}
+
+	ds := walk(job.path, job.rel)
+	c.save(job, ds)
+}
+
+// publish 公布统计中的中间结果。已有完整的旧结果时继续显示旧值，直到新的统计完成。
+func (c *dirSizeCache) publish(job dirSizeJob, progress dirSize) {
+	c.mu.Lock()
+	old, ok := c.sizes[job.path]
+	if !ok || !old.done {
+		c.sizes[job.path] = progress
+	}
+	c.mu.Unlock()
+	if !ok || !old.done {
+		c.save(job, progress)
+	}
+}
+
+// save 把顶层分享项的大小写入 sizes.json
+func (c *dirSizeCache) save(job dirSizeJob, ds dirSize) {
+	if job.item == "" {
+		return
+	}
+	err := state.SaveItemSize(job.item, state.ItemSize{Files: ds.files, Bytes: ds.bytes, Done: ds.done, Time: time.Now()})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[dirsize] 保存目录大小失败: %v\n", err)
+	}
+}
+
+// warmDirSizes 为顶层的目录分享项排队统计大小，子目录在首次列出时排队
+func (s *Server) warmDirSizes() {
+	for _, item := range s.shares().items {
+		if item.ShareType == state.TypeDir {
+			s.dirSizes.lookup(dirSizeJob{path: item.Path, item: item.Name})
+		}
+	}
+}
+
+// fillDirSizes 为列表中的目录填充已统计的大小，未统计的加入后台队列
+func (s *Server) fillDirSizes(files []FileInfo) {
+	for i := range files {
+		f := &files[i]
+		if !f.IsDir || f.diskPath == "" {
+			continue
+		}
+		job := dirSizeJob{path: f.diskPath, rel: s.itemRelPath(f.Path)}
+		if job.rel == "" {
+			// 多文件模式根目录中的分享项
+			job.item = f.Name
+		}
+		ds, ok := s.dirSizes.lookup(job)
+		f.Size = ds.bytes
+		f.SizePending = !ok || !ds.done
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"os"
+)
+
+// serveDownload 以附件形式发送文件，支持 Range / If-Range 断点续传
+func serveDownload(w http.ResponseWriter, r *http.Request, path, name string) {
+	serveContent(w, r, path, name, contentDisposition(name))
+}
+
+// serveInline 以内联形式发送文件，供浏览器直接播放或显示 (同样支持 Range)
+func serveInline(w http.ResponseWriter, r *http.Request, path, name string) {
+	serveContent(w, r, path, name, inlineDisposition(name))
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request, path, name, disposition string) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("ETag", fileETag(info))
+
+	// ServeContent 负责 Range、If-Range、If-None-Match 以及 Last-Modified 相关的条件请求
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// contentDisposition 生成 attachment 头，非 ASCII 文件名使用 RFC 5987 编码
+func contentDisposition(name string) string {
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return "attachment"
+}
+
+func inlineDisposition(name string) string {
+	if v := mime.FormatMediaType("inline", map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return "inline"
+}
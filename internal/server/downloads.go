@@ -0,0 +1,286 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadCounter 统计完整下载的次数，用于 --max-downloads
+type downloadCounter struct {
+	mu      sync.Mutex
+	total   int
+	byItem  map[string]int
+	done    bool
+	onLimit func()
+}
+
+func newDownloadCounter() *downloadCounter {
+	return &downloadCounter{byItem: make(map[string]int)}
+}
+
+// OnDownloadLimit 设置下载次数达到 --max-downloads 后的回调，服务进程用它结束整个分享
+func (s *Server) OnDownloadLimit(fn func()) {
+	s.downloads.mu.Lock()
+	s.downloads.onLimit = fn
+	s.downloads.mu.Unlock()
+}
+
+// itemName 返回 URL 路径所属分享项的名称
+func (s *Server) itemName(urlPath string) string {
+	set := s.shares()
+	if !set.isMulti {
+		return set.items[0].Name
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/")
+	return name
+}
+
+// completeDownload 判断响应本身是否为一次完整的下载: 带 Content-Disposition 的 200 响应，
+// 且已发送的字节数达到 Content-Length (打包下载没有 Content-Length)。
+// 分段发送的文件由 downloadComplete 按已发送的字节区间判断。
+func completeDownload(r *http.Request, rw *responseWriter) bool {
+	if r.Method != http.MethodGet || rw.statusCode != http.StatusOK || rw.aborted != "" {
+		return false
+	}
+	if rw.Header().Get("Content-Disposition") == "" {
+		return false
+	}
+	if cl := rw.Header().Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		return err == nil && rw.bytes >= n
+	}
+	return true
+}
+
+// downloadComplete 判断这次响应之后文件是否已被完整下载: 响应本身是完整下载，或者与之前的响应
+// (Range 请求的 206、中断的 200) 合起来已经发送了文件的每个字节。否则 Range: bytes=0- 这样的请求
+// 可以拿到整个文件却不计入 --burn 和 --max-downloads。结果缓存在 rw 中，一次请求只记录一次区间。
+func (s *Server) downloadComplete(r *http.Request, rw *responseWriter) bool {
+	if !rw.checked {
+		rw.checked = true
+		rw.complete = s.checkDownload(r, rw)
+	}
+	return rw.complete
+}
+
+func (s *Server) checkDownload(r *http.Request, rw *responseWriter) bool {
+	path := r.URL.Path
+	if completeDownload(r, rw) {
+		s.coverage.reset(path)
+		return true
+	}
+	if r.Method != http.MethodGet || rw.Header().Get("Content-Disposition") == "" || rw.bytes == 0 {
+		return false
+	}
+
+	switch rw.statusCode {
+	case http.StatusOK:
+		size, err := strconv.ParseInt(rw.Header().Get("Content-Length"), 10, 64)
+		if err != nil {
+			return false
+		}
+		return s.coverage.add(path, size, byteRange{0, rw.bytes})
+	case http.StatusPartialContent:
+		// 单个区间: Content-Range 给出位置和文件大小，按实际发送的字节数计算
+		var start, end, size int64
+		if _, err := fmt.Sscanf(rw.Header().Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size); err == nil {
+			return s.coverage.add(path, size, byteRange{start, min(end+1, start+rw.bytes)})
+		}
+		// 多个区间 (multipart/byteranges): 整个响应发送完时按请求的区间计算
+		cl, err := strconv.ParseInt(rw.Header().Get("Content-Length"), 10, 64)
+		if err != nil || rw.bytes < cl {
+			return false
+		}
+		file, err := s.resolve(path)
+		if err != nil {
+			return false
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false
+		}
+		return s.coverage.add(path, info.Size(), parseRanges(r.Header.Get("Range"), info.Size())...)
+	}
+	return false
+}
+
+// byteRange 是文件中 [start, end) 的字节
+type byteRange struct {
+	start, end int64
+}
+
+// rangeCoverage 记录每个文件 (按 URL 路径) 已经发送过的字节区间，区间有序且不重叠
+type rangeCoverage struct {
+	mu    sync.Mutex
+	files map[string][]byteRange
+}
+
+func newRangeCoverage() *rangeCoverage {
+	return &rangeCoverage{files: make(map[string][]byteRange)}
+}
+
+// add 记录 path 发送过的区间，覆盖了整个文件 (size 字节) 时清除记录并返回 true
+func (c *rangeCoverage) add(path string, size int64, ranges ...byteRange) bool {
+	if size <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	covered := c.files[path]
+	for _, r := range ranges {
+		r.start, r.end = max(r.start, 0), min(r.end, size)
+		if r.start >= r.end {
+			continue
+		}
+		covered = mergeRange(covered, r)
+	}
+	if len(covered) == 1 && covered[0].start == 0 && covered[0].end >= size {
+		delete(c.files, path)
+		return true
+	}
+	if len(covered) > 0 {
+		c.files[path] = covered
+	}
+	return false
+}
+
+// reset 在文件被完整下载一次后清除之前的部分记录
+func (c *rangeCoverage) reset(path string) {
+	c.mu.Lock()
+	delete(c.files, path)
+	c.mu.Unlock()
+}
+
+// mergeRange 把 r 并入有序且不重叠的 ranges，相邻或重叠的区间合并为一个
+func mergeRange(ranges []byteRange, r byteRange) []byteRange {
+	var merged []byteRange
+	for _, cur := range ranges {
+		switch {
+		case cur.end < r.start:
+			merged = append(merged, cur)
+		case r.end < cur.start:
+			merged = append(merged, r)
+			r = cur
+		default:
+			r = byteRange{min(cur.start, r.start), max(cur.end, r.end)}
+		}
+	}
+	return append(merged, r)
+}
+
+// parseRanges 解析 Range 请求头 (bytes=0-99,200-,-50)，无效时返回 nil
+func parseRanges(header string, size int64) []byteRange {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil
+	}
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil
+		}
+		if first == "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return nil
+			}
+			ranges = append(ranges, byteRange{size - n, size})
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return nil
+		}
+		end := size
+		if last != "" {
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil {
+				return nil
+			}
+			end = n + 1
+		}
+		ranges = append(ranges, byteRange{start, end})
+	}
+	return ranges
+}
+
+// itemExhausted 判断分享项是否已达到下载次数上限
+func (s *Server) itemExhausted(urlPath string) bool {
+	limit := s.state.MaxDownloads
+	if limit <= 0 {
+		return false
+	}
+	c := s.downloads
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done {
+		return true
+	}
+	return s.state.MaxDownloadsPerItem && c.byItem[s.itemName(urlPath)] >= limit
+}
+
+// recordDownload 在日志中间件中记录一次完整下载 (包括分段下载合起来覆盖了整个文件)，
+// 达到上限时停止提供下载并结束分享。按项计数时所有分享项都下载满 N 次才结束。
+func (s *Server) recordDownload(r *http.Request) {
+	limit := s.state.MaxDownloads
+	if limit <= 0 {
+		return
+	}
+	c := s.downloads
+	c.mu.Lock()
+	name := s.itemName(r.URL.Path)
+	c.total++
+	c.byItem[name]++
+	reached := c.total >= limit
+	if s.state.MaxDownloadsPerItem {
+		reached = true
+		for _, item := range s.shares().items {
+			if c.byItem[item.Name] < limit {
+				reached = false
+				break
+			}
+		}
+	}
+	if !reached || c.done {
+		c.mu.Unlock()
+		return
+	}
+	c.done = true
+	onLimit := c.onLimit
+	c.mu.Unlock()
+
+	message := fmt.Sprintf("已完成 %d 次下载，达到 --max-downloads 上限，分享即将结束", c.total)
+	logData, _ := json.Marshal(map[string]interface{}{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"event":   "max_downloads",
+		"message": message,
+	})
+	appendToAccessLog(string(logData))
+	fmt.Fprintf(os.Stderr, "[downloads] %s\n", message)
+
+	if onLimit != nil {
+		go onLimit()
+	}
+}
+
+// downloadLimitMiddleware 对已达到 --max-downloads 的分享项返回 410，页面和缩略图不受影响。
+// 分享者自己的请求不受限制。
+func (s *Server) downloadLimitMiddleware(next http.Handler) http.Handler {
+	if s.state.MaxDownloads <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isOwner(r) && s.itemExhausted(r.URL.Path) && s.isTransfer(r) {
+			http.Error(w, "This share has reached its download limit", http.StatusGone)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"cfshare/internal/state"
+)
+
+// EndedHandler 是分享停止后 (--ended-grace) 提示进程的全部服务：任何路径都返回 410，
+// 浏览器看到 "分享已结束" 页面和 --contact，早先发出的链接不会落到 Cloudflare 的边缘错误页
+func EndedHandler(e *state.Ended) http.Handler {
+	s := &Server{state: &state.State{
+		Contact:       e.Contact,
+		Theme:         e.Theme,
+		Lang:          e.Lang,
+		AllowIndexing: e.AllowIndexing,
+	}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := shareStatus{Status: statusEnded, Contact: e.Contact, EndedAt: &e.EndedAt}
+		if wantsJSON(r) || strings.Contains(r.Header.Get("Accept"), "text/html") {
+			s.renderStatus(w, r, http.StatusGone, current)
+			return
+		}
+		if !e.AllowIndexing {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive")
+		}
+		http.Error(w, "This share has ended", http.StatusGone)
+	})
+}
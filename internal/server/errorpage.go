@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errorPage 是错误页模板的数据，覆盖模板 (~/.cfshare/templates/error.html) 可以使用这些字段
+type errorPage struct {
+	Lang        string
+	Status      int
+	Title       string // 错误标题，例如 "未找到"
+	Message     string // 错误说明
+	Realm       string // 口令认证的 realm，默认 "cfshare"
+	Contact     string // --contact 指定的联系方式
+	ContactLink string // 联系方式是邮箱或网址时对应的链接
+	Retry       bool   // 401 时显示 "重新输入口令" 按钮
+	Root        string // 挂载的路径前缀 (--mount)，"返回首页" 链接指向 Root + "/"
+	ThemeCSS    template.CSS
+}
+
+// T 返回当前页面语言的文案，供错误页模板使用
+func (p errorPage) T(key string, args ...any) string {
+	return translator(p.Lang)(key, args...)
+}
+
+// errorPageStatus 判断状态码是否换成 HTML 错误页
+func errorPageStatus(code int) bool {
+	return code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusNotFound
+}
+
+// contactLink 把邮箱转换为 mailto: 链接，网址原样返回，其他联系方式不生成链接
+func contactLink(contact string) string {
+	switch {
+	case strings.HasPrefix(contact, "http://") || strings.HasPrefix(contact, "https://"):
+		return contact
+	case strings.Contains(contact, "@") && !strings.ContainsAny(contact, " \t/"):
+		return "mailto:" + contact
+	}
+	return ""
+}
+
+// renderErrorPage 渲染 status 对应的错误页，优先使用用户的覆盖模板
+func (s *Server) renderErrorPage(w http.ResponseWriter, r *http.Request, status int) []byte {
+	lang := s.pageLang(w, r)
+	page := errorPage{
+		Lang:        lang,
+		Status:      status,
+		Title:       translator(lang)("error_" + strconv.Itoa(status)),
+		Message:     translator(lang)("error_" + strconv.Itoa(status) + "_msg"),
+		Realm:       s.state.Realm,
+		Contact:     s.state.Contact,
+		ContactLink: contactLink(s.state.Contact),
+		Retry:       status == http.StatusUnauthorized,
+		Root:        s.root(),
+		ThemeCSS:    themeCSS(s.state.Theme),
+	}
+	if page.Realm == "" {
+		page.Realm = "cfshare"
+	}
+
+	var buf bytes.Buffer
+	tmpl, custom := s.templates.errorTemplate()
+	if err := tmpl.Execute(&buf, page); err != nil && custom {
+		// 覆盖模板执行出错时回退到内置模板，避免输出半截页面
+		buf.Reset()
+		defaultErrorTemplate.Execute(&buf, page)
+	}
+	return buf.Bytes()
+}
+
+// errorPageMiddleware 把 401/403/404 的纯文本错误 (http.Error、http.NotFound 等)
+// 换成带主题的 HTML 错误页。只处理浏览器请求 (Accept 含 text/html)，
+// curl 和脚本仍然得到纯文本；已经自带 HTML 或 JSON 的响应保持不变。
+func (s *Server) errorPageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&errorPageWriter{ResponseWriter: w, s: s, r: r}, r)
+	})
+}
+
+// errorPageWriter 在写出状态码时判断是否替换为错误页，替换后丢弃原来的正文
+type errorPageWriter struct {
+	http.ResponseWriter
+	s *Server
+	r *http.Request
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	ctype := w.Header().Get("Content-Type")
+	if !errorPageStatus(code) || (ctype != "" && !strings.HasPrefix(ctype, "text/plain")) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.replaced = true
+	body := w.s.renderErrorPage(w.ResponseWriter, w.r, code)
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("X-Content-Type-Options")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.Write(body)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cardStyle 是错误页和状态页共用的卡片样式，主题样式追加在其后
+const cardStyle = `        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 560px;
+            margin: 60px auto 0;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        h1 {
+            margin: 0;
+            padding: 20px;
+            background: #2563eb;
+            color: white;
+            font-size: 18px;
+            font-weight: 500;
+        }
+        .body { padding: 20px; line-height: 1.6; color: #374151; }
+        .status { font-size: 13px; color: #9ca3af; }
+        .contact {
+            margin-top: 15px;
+            padding: 12px 15px;
+            background: #f9fafb;
+            border: 1px solid #eee;
+            border-radius: 6px;
+        }
+        a { color: #2563eb; }
+        button {
+            margin-top: 15px;
+            padding: 10px 20px;
+            background: #2563eb;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            font-size: 14px;
+            cursor: pointer;
+        }
+`
+
+var defaultErrorTemplate = template.Must(template.New("error").Funcs(dirTemplateFuncs()).Parse(errorTemplate))
+
+const errorTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}} - {{.Realm}}</title>
+    <style>
+` + cardStyle + `{{.ThemeCSS}}
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>{{if .Retry}}🔒 {{.Realm}}{{else}}{{.Title}}{{end}}</h1>
+        <div class="body">
+            <p>{{.Message}}</p>
+            {{if .Contact}}
+            <div class="contact">
+                {{.T "error_contact"}}
+                {{if .ContactLink}}<a href="{{.ContactLink}}">{{.Contact}}</a>{{else}}{{.Contact}}{{end}}
+            </div>
+            {{end}}
+            {{if .Retry}}<button onclick="location.reload()">{{.T "error_retry"}}</button>{{else}}<p class="status">HTTP {{.Status}} · <a href="{{.Root}}/">{{.T "back_home"}}</a></p>{{end}}
+        </div>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cfshare/internal/state"
+)
+
+// pathFilter 决定分享目录中哪些条目对访问者隐藏。
+// 被隐藏的条目不出现在列表、搜索和打包下载中，直接访问也返回 404。
+type pathFilter struct {
+	patterns   []string // --exclude 的 glob 模式
+	showHidden bool     // 为 false 时隐藏以 . 开头的文件和目录
+}
+
+func newPathFilter(patterns []string, showHidden bool) *pathFilter {
+	return &pathFilter{patterns: patterns, showHidden: showHidden}
+}
+
+// excluded 判断分享项内的相对路径 (以 / 分隔) 是否被隐藏。
+// 不含 / 的模式匹配任意一级的名称，例如 node_modules 或 *.log；
+// 含 / 的模式从分享项根目录开始匹配，例如 build/*.o。
+func (f *pathFilter) excluded(rel string) bool {
+	rel = strings.Trim(rel, "/")
+	if f == nil || rel == "" || rel == "." {
+		return false
+	}
+
+	segments := strings.Split(rel, "/")
+	for i, seg := range segments {
+		if !f.showHidden && strings.HasPrefix(seg, ".") && seg != "." && seg != ".." {
+			return true
+		}
+		for _, p := range f.patterns {
+			var ok bool
+			if strings.Contains(p, "/") {
+				ok, _ = path.Match(strings.Trim(p, "/"), strings.Join(segments[:i+1], "/"))
+			} else {
+				ok, _ = path.Match(p, seg)
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// itemRelPath 返回 URL 路径在所属分享项内的相对路径。
+// 多文件模式下第一级是分享项本身，由分享者明确指定，不受过滤影响。
+func (s *Server) itemRelPath(urlPath string) string {
+	reqPath := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	set := s.shares()
+	if set.isMulti {
+		_, rel, _ := strings.Cut(reqPath, "/")
+		return rel
+	}
+	if set.shareType == state.TypeFile {
+		return ""
+	}
+	return reqPath
+}
+
+// excludedURL 判断 URL 路径是否指向被隐藏的条目
+func (s *Server) excludedURL(urlPath string) bool {
+	return s.filter.excluded(s.itemRelPath(urlPath))
+}
+
+// skipUnder 返回打包 urlPath 对应目录时使用的过滤函数，参数为相对该目录的路径
+func (s *Server) skipUnder(urlPath string) func(rel string) bool {
+	base := s.itemRelPath(urlPath)
+	return func(rel string) bool {
+		return s.filter.excluded(path.Join(base, rel))
+	}
+}
+
+// ExposedSize 按 --exclude 和隐藏文件规则统计目录中访问者能看到的文件数和总大小，
+// 供 cfshare review 使用。无法读取的子目录跳过。
+func ExposedSize(dir string, exclude []string, showHidden bool) (files int, size int64, err error) {
+	err = WalkExposed(dir, exclude, showHidden, func(rel string, info fs.FileInfo) {
+		files++
+		size += info.Size()
+	})
+	return files, size, err
+}
+
+// WalkExposed 按 --exclude 和隐藏文件规则遍历目录中访问者能看到的普通文件，
+// rel 是以 / 分隔的相对路径。无法读取的子目录跳过。
+func WalkExposed(dir string, exclude []string, showHidden bool, fn func(rel string, info fs.FileInfo)) error {
+	filter := newPathFilter(exclude, showHidden)
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if filter.excluded(filepath.ToSlash(rel)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				fn(filepath.ToSlash(rel), info)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiryTimer 保存分享的结束时间 (--expire)。cfshare review 延长到期时间后，
+// 服务进程通过 SetStopAt 更新，不需要重启。
+type expiryTimer struct {
+	mu       sync.Mutex
+	stopAt   time.Time
+	timer    *time.Timer
+	onExpire func()
+}
+
+func newExpiryTimer(stopAt time.Time) *expiryTimer {
+	return &expiryTimer{stopAt: stopAt}
+}
+
+func (e *expiryTimer) get() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stopAt
+}
+
+// arm 按当前结束时间重新设置定时器，调用方须持有 mu
+func (e *expiryTimer) arm() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	if e.stopAt.IsZero() || e.onExpire == nil {
+		return
+	}
+	stopAt, onExpire := e.stopAt, e.onExpire
+	e.timer = time.AfterFunc(time.Until(stopAt), func() {
+		e.mu.Lock()
+		current := e.stopAt
+		e.mu.Unlock()
+		// 定时器触发前到期时间被延长或取消
+		if !current.Equal(stopAt) {
+			return
+		}
+		message := fmt.Sprintf("分享已于 %s 到期，即将结束", stopAt.Format("2006-01-02 15:04:05"))
+		logData, _ := json.Marshal(map[string]interface{}{
+			"time":    time.Now().UTC().Format(time.RFC3339),
+			"event":   "expired",
+			"message": message,
+		})
+		appendToAccessLog(string(logData))
+		fmt.Fprintf(os.Stderr, "[expire] %s\n", message)
+		onExpire()
+	})
+}
+
+// expired 判断分享是否已超过 --expire 设置的结束时间
+func (s *Server) expired() bool {
+	stopAt := s.expiry.get()
+	return !stopAt.IsZero() && !time.Now().Before(stopAt)
+}
+
+// expiryMiddleware 在分享到期后拒绝所有请求，服务进程随后自行结束分享
+func (s *Server) expiryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.expired() {
+			// 开启状态页时浏览器看到到期说明和联系方式，脚本仍然得到纯文本
+			if s.state.StatusPage && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				s.writeStatusPage(w, r, http.StatusGone)
+				return
+			}
+			http.Error(w, "This share has expired", http.StatusGone)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ScheduleExpiry 在到达 --expire 的结束时间时调用 onExpire (服务进程用它结束整个分享)。
+// 服务进程重启时结束时间已过则立即调用。
+func (s *Server) ScheduleExpiry(onExpire func()) {
+	s.expiry.mu.Lock()
+	defer s.expiry.mu.Unlock()
+	s.expiry.onExpire = onExpire
+	s.expiry.arm()
+}
+
+// SetStopAt 更新分享的结束时间 (cfshare review 延长到期时间后 reload)，零值表示不再自动结束
+func (s *Server) SetStopAt(stopAt time.Time) {
+	s.expiry.mu.Lock()
+	defer s.expiry.mu.Unlock()
+	if stopAt.Equal(s.expiry.stopAt) {
+		return
+	}
+	s.expiry.stopAt = stopAt
+	s.expiry.arm()
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"cfshare/internal/thumb"
+)
+
+// galleryMinImages 目录中至少有这么多图片且图片占多数时默认使用图库视图
+const galleryMinImages = 4
+
+// handleThumb 返回图片的缩略图 (?thumb=1)
+func (s *Server) handleThumb(w http.ResponseWriter, r *http.Request) {
+	fullPath, err := s.resolve(r.URL.Path)
+	if err != nil {
+		writeResolveError(w, r, err)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() || !thumb.IsImage(fullPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	cachePath, err := thumb.Get(fullPath, info)
+	if err != nil {
+		http.Error(w, "Thumbnail Unavailable", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// 缩略图内容由源文件决定，允许浏览器短期缓存以减少经过 tunnel 的流量
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeFile(w, r, cachePath)
+}
+
+// useGallery 根据目录内容和 ?view= 参数决定是否使用图库视图
+func useGallery(r *http.Request, files []FileInfo) (gallery, available bool) {
+	images, others := 0, 0
+	for _, f := range files {
+		if f.IsDir {
+			continue
+		}
+		if thumb.IsImage(f.Name) {
+			images++
+		} else {
+			others++
+		}
+	}
+
+	available = images > 0
+	switch r.URL.Query().Get("view") {
+	case "gallery":
+		return available, available
+	case "list":
+		return false, available
+	}
+	return images >= galleryMinImages && images >= others, available
+}
+
+// isImageName 供模板判断是否为图片
+func isImageName(name string) bool {
+	return thumb.IsImage(filepath.Base(name))
+}
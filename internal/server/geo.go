@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// geoFilter 是 --allow-country / --block-country 的访问者国家规则，按 Cloudflare 注入的
+// CF-IPCountry 判断 (ISO 3166-1 两位代码，无法定位时为 XX，Tor 为 T1)
+type geoFilter struct {
+	allow map[string]bool // 非空时只允许这些国家
+	block map[string]bool // 总是拒绝，优先于 allow
+}
+
+// ParseCountries 解析国家代码，每个值可以用逗号分隔多个，不区分大小写
+func ParseCountries(values []string) ([]string, error) {
+	var codes []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.ToUpper(strings.TrimSpace(s))
+			if s == "" {
+				continue
+			}
+			if len(s) != 2 || !isCountryCode(s) {
+				return nil, fmt.Errorf("invalid country code %q (expected two letters such as CN or US)", s)
+			}
+			codes = append(codes, s)
+		}
+	}
+	return codes, nil
+}
+
+func isCountryCode(s string) bool {
+	for _, c := range s {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// newGeoFilter 创建访问者国家规则，没有任何规则时返回 nil
+func newGeoFilter(allow, block []string) *geoFilter {
+	f := &geoFilter{allow: make(map[string]bool), block: make(map[string]bool)}
+	codes, _ := ParseCountries(allow)
+	for _, c := range codes {
+		f.allow[c] = true
+	}
+	codes, _ = ParseCountries(block)
+	for _, c := range codes {
+		f.block[c] = true
+	}
+	if len(f.allow) == 0 && len(f.block) == 0 {
+		return nil
+	}
+	return f
+}
+
+// permitted 判断国家代码是否允许访问。没有 CF-IPCountry 的请求按 XX (未知) 处理。
+func (f *geoFilter) permitted(country string) bool {
+	if f == nil {
+		return true
+	}
+	if country == "" {
+		country = "XX"
+	}
+	if f.block[country] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[country]
+}
+
+// requestCountry 返回请求的 CF-IPCountry
+func requestCountry(r *http.Request) string {
+	return strings.ToUpper(strings.TrimSpace(r.Header.Get("CF-IPCountry")))
+}
+
+// geoFilterMiddleware 拒绝不符合 --allow-country / --block-country 的访问者 (403)。
+// 被拒绝的请求不经过普通的访问日志，而是单独记录为 geo_blocked 事件，
+// 便于区分地区限制和口令错误等其他 403。分享者令牌不受影响。
+func (s *Server) geoFilterMiddleware(next http.Handler) http.Handler {
+	if s.geoRules == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		country := requestCountry(r)
+		if s.geoRules.permitted(country) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logData, _ := json.Marshal(map[string]interface{}{
+			"time":        time.Now().UTC().Format(time.RFC3339),
+			"event":       "geo_blocked",
+			"country":     country,
+			"path":        r.URL.Path,
+			"remote_addr": r.RemoteAddr,
+			"client_ip":   clientIP(r),
+			"user_agent":  r.UserAgent(),
+		})
+		appendToAccessLog(string(logData))
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+const (
+	getScriptPath = "/__get.sh"
+
+	// getScriptTTL 是脚本中签名链接的有效期，过期后重新获取脚本即可继续下载
+	getScriptTTL = 6 * time.Hour
+	// segmentMinSize 不小于该大小的文件分段并行下载
+	segmentMinSize = 64 << 20
+)
+
+// isGetScriptRequest 判断是否请求下载脚本 (--get-script)。分享中真实存在同名文件时不拦截。
+func (s *Server) isGetScriptRequest(r *http.Request) bool {
+	if !s.state.GetScript || r.URL.Path != getScriptPath {
+		return false
+	}
+	_, err := s.resolve(r.URL.Path)
+	return err != nil
+}
+
+// scriptFile 是脚本要下载的一个文件
+type scriptFile struct {
+	dest    string // 相对当前目录的保存路径
+	urlPath string // 未转义的 URL 路径，也是签名的对象
+	size    int64
+	sum     string // 已算好的 SHA-256，未算好时为空，只校验大小
+}
+
+// handleGetScript 生成下载全部文件 (或 ?path= 指定的文件或目录) 的 shell 脚本，
+// 用法: curl -fsSL -u user:pass https://.../__get.sh | sh
+// 需要口令的分享在脚本中使用短期有效的签名链接，脚本本身不包含口令。
+func (s *Server) handleGetScript(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("path")
+	if target == "" {
+		target = "/"
+	}
+	target = path.Clean("/" + target)
+	if s.excludedURL(target) {
+		http.NotFound(w, r)
+		return
+	}
+
+	files, err := s.scriptFiles(r, target)
+	if err != nil {
+		writeResolveError(w, r, err)
+		return
+	}
+
+	if !s.canLink() {
+		http.Error(w, "Signed links are not available for this share", http.StatusServiceUnavailable)
+		return
+	}
+
+	expires := time.Now().Add(getScriptTTL)
+	base := s.baseURL(r)
+	var cookie string
+	// 通过条款检查的 cookie 一并交给脚本，否则下载会被条款页面拦截
+	if c, err := r.Cookie(termsCookieName); err == nil {
+		cookie = c.Name + "=" + c.Value
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, getScriptHeader, expires.UTC().Format(time.RFC3339), segmentMinSize, shellQuote(cookie))
+	for _, f := range files {
+		link := s.directLink(base, f.urlPath, expires)
+		fmt.Fprintf(&buf, "download %s %d %s %s || failed=$((failed + 1))\n",
+			shellQuote(f.dest), f.size, shellQuote(f.sum), shellQuote(link))
+	}
+	fmt.Fprintf(&buf, getScriptFooter, len(files))
+
+	w.Header().Set("Content-Type", "text/x-shellscript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// scriptFiles 列出 urlPath 对应的全部文件，目录按打包下载的规则递归并跳过隐藏条目
+func (s *Server) scriptFiles(r *http.Request, urlPath string) ([]scriptFile, error) {
+	set := s.shares()
+	if urlPath == "/" && set.isMulti {
+		var files []scriptFile
+		for _, item := range s.visibleItems(r.Context()) {
+			f, err := s.scriptFiles(r, "/"+item.Name)
+			if err != nil {
+				continue
+			}
+			files = append(files, f...)
+		}
+		return files, nil
+	}
+
+	fullPath, err := s.resolve(urlPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(fullPath)
+
+	if !info.IsDir() {
+		if urlPath == "/" && set.shareType == state.TypeFile {
+			urlPath = "/" + name
+		}
+		return []scriptFile{s.newScriptFile(name, urlPath, fullPath, info)}, nil
+	}
+
+	var files []scriptFile
+	prefix := dirURLPath(urlPath)
+	err = walkArchiveEntries(fullPath, s.skipUnder(urlPath), func(rel, p string, info fs.FileInfo) error {
+		if info.Mode().IsRegular() {
+			files = append(files, s.newScriptFile(name+"/"+rel, prefix+rel, p, info))
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (s *Server) newScriptFile(dest, urlPath, fullPath string, info fs.FileInfo) scriptFile {
+	// 只使用已缓存的校验值，未算好的排队计算，下次获取脚本时即可带上
+	sum, _ := s.checksums.lookup(fullPath, info.Size(), info.ModTime())
+	return scriptFile{dest: dest, urlPath: urlPath, size: info.Size(), sum: sum}
+}
+
+// baseURL 返回分享的公开地址，没有配置时按请求推断
+func (s *Server) baseURL(r *http.Request) string {
+	if s.state.PublicURL != "" {
+		return strings.TrimSuffix(s.state.ShareURL(), "/")
+	}
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + s.root()
+}
+
+// shellQuote 用单引号包裹字符串，使其可以安全地嵌入 shell 脚本
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+const getScriptHeader = `#!/bin/sh
+# Generated by cfshare. Links in this script expire at %s;
+# fetch a fresh script to continue after that. Re-running resumes
+# interrupted downloads and skips files that are already complete.
+set -u
+
+SEGMENTS=${CFSHARE_SEGMENTS:-4}
+SEGMENT_MIN=${CFSHARE_SEGMENT_MIN:-%d}
+COOKIE=%s
+
+if command -v sha256sum >/dev/null 2>&1; then
+	SHA="sha256sum"
+elif command -v shasum >/dev/null 2>&1; then
+	SHA="shasum -a 256"
+else
+	SHA=""
+	echo "warning: sha256sum not found, only file sizes will be verified" >&2
+fi
+
+fetch() {
+	if [ -n "$COOKIE" ]; then
+		curl -fsSL --retry 5 --retry-delay 2 -H "Cookie: $COOKIE" "$@"
+	else
+		curl -fsSL --retry 5 --retry-delay 2 "$@"
+	fi
+}
+
+filesize() {
+	wc -c < "$1" | tr -d ' '
+}
+
+verify() {
+	[ -f "$1" ] && [ "$(filesize "$1")" = "$2" ] || return 1
+	[ -z "$3" ] || [ -z "$SHA" ] && return 0
+	[ "$($SHA "$1" | cut -d ' ' -f 1)" = "$3" ]
+}
+
+# segmented downloads one large file as parallel Range requests,
+# each part resuming from whatever it already has on disk.
+segmented() {
+	chunk=$(( ($2 + SEGMENTS - 1) / SEGMENTS ))
+	pids=""
+	i=0
+	while [ $i -lt $SEGMENTS ]; do
+		start=$((i * chunk))
+		end=$((start + chunk - 1))
+		[ $end -lt $2 ] || end=$(($2 - 1))
+		if [ $start -le $end ]; then
+			(
+				part="$1.cfpart$i"
+				have=0
+				[ -f "$part" ] && have=$(filesize "$part")
+				if [ "$have" -lt $((end - start + 1)) ]; then
+					fetch -r "$((start + have))-$end" "$3" >> "$part"
+				fi
+			) &
+			pids="$pids $!"
+		fi
+		i=$((i + 1))
+	done
+
+	ok=0
+	for pid in $pids; do
+		wait "$pid" || ok=1
+	done
+	[ $ok -eq 0 ] || return 1
+
+	: > "$1.cfpart"
+	i=0
+	while [ $i -lt $SEGMENTS ]; do
+		[ ! -f "$1.cfpart$i" ] || cat "$1.cfpart$i" >> "$1.cfpart"
+		i=$((i + 1))
+	done
+	mv "$1.cfpart" "$1"
+	i=0
+	while [ $i -lt $SEGMENTS ]; do
+		rm -f "$1.cfpart$i"
+		i=$((i + 1))
+	done
+}
+
+# download <path> <size> <sha256> <url>
+download() {
+	if verify "$1" "$2" "$3"; then
+		echo "ok       $1 (already complete)"
+		return 0
+	fi
+	mkdir -p "$(dirname "$1")" || return 1
+	if [ -f "$1" ] && [ "$(filesize "$1")" -ge "$2" ]; then
+		rm -f "$1"
+	fi
+
+	if [ "$2" -ge "$SEGMENT_MIN" ] && [ "$SEGMENTS" -gt 1 ]; then
+		segmented "$1" "$2" "$4"
+	else
+		fetch -C - -o "$1" "$4"
+	fi || {
+		echo "failed   $1" >&2
+		return 1
+	}
+
+	if ! verify "$1" "$2" "$3"; then
+		echo "corrupt  $1 (checksum mismatch, removed)" >&2
+		rm -f "$1"
+		return 1
+	fi
+	echo "ok       $1"
+}
+
+failed=0
+`
+
+const getScriptFooter = `
+if [ $failed -gt 0 ]; then
+	echo "$failed of %[1]d file(s) failed; run the script again to resume" >&2
+	exit 1
+fi
+echo "All %[1]d file(s) downloaded and verified."
+`
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"cfshare/internal/state"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+)
+
+// readiness 是 /readyz 的检查结果，Checks 中失败的项目带有原因
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// checkReady 检查分享是否可以正常提供下载: 分享项都能访问、没有到期、
+// 有公开地址时 tunnel 没有处于降级状态 (见 WatchEdge)
+func (s *Server) checkReady() readiness {
+	res := readiness{Ready: true, Checks: map[string]string{"items": "ok"}}
+	fail := func(name, reason string) {
+		res.Ready = false
+		res.Checks[name] = reason
+	}
+
+	for _, item := range s.shares().items {
+		if item.ShareType == state.TypeStream {
+			continue
+		}
+		if _, err := os.Stat(item.Path); err != nil {
+			fail("items", item.Name+": "+err.Error())
+			break
+		}
+	}
+	if s.expired() {
+		fail("expiry", "share has expired")
+	}
+	if s.state.PublicURL != "" {
+		res.Checks["tunnel"] = "ok"
+		if h := state.LoadHealth(); h != nil && h.Degraded {
+			fail("tunnel", "public URL unreachable: "+h.LastError)
+		} else if h != nil && h.TunnelDown {
+			fail("tunnel", "no edge connections registered: "+h.TunnelError)
+		}
+	}
+	return res
+}
+
+// handleHealthz 表示服务进程存活，不做其他检查
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz 在分享可以正常提供下载时返回 200，否则返回 503。
+// 不需要认证，但只有分享者 (带分享者令牌) 能看到具体的检查项和原因，其他人只看到结果。
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	res := s.checkReady()
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if !s.validOwnerToken(ownerToken(r)) && !loopbackRequest(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		if res.Ready {
+			w.Write([]byte("ok\n"))
+		} else {
+			w.Write([]byte("not ready\n"))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
+
+// loopbackRequest 判断请求是否直接来自本机 (不是经 tunnel 转发的访问)
+func loopbackRequest(r *http.Request) bool {
+	return r.Header.Get("CF-Connecting-IP") == "" && ValidateLoopbackAddr(r.RemoteAddr) == nil
+}
+
+// healthHandlers 注册 /healthz 和 /readyz，这两个路径不经过认证和访问日志
+func (s *Server) healthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(healthzPath, s.handleHealthz)
+	mux.HandleFunc(readyzPath, s.handleReadyz)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter 是 --allow-ip / --block-ip 的访问者 IP 规则。所有请求都经 tunnel 从本机发出，
+// 因此按 CF-Connecting-IP 判断，没有该头部时 (本地访问) 使用连接的地址。
+type ipFilter struct {
+	allow []netip.Prefix // 非空时只允许这些网段
+	block []netip.Prefix // 总是拒绝，优先于 allow
+}
+
+// ParseIPPrefixes 解析 CIDR 网段或单个 IP (视为 /32 或 /128)，每个值可以用逗号分隔多个
+func ParseIPPrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if strings.Contains(s, "/") {
+				p, err := netip.ParsePrefix(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid CIDR %q", s)
+				}
+				prefixes = append(prefixes, p.Masked())
+				continue
+			}
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	return prefixes, nil
+}
+
+// newIPFilter 创建访问者 IP 规则，没有任何规则时返回 nil。规则已在启动分享时校验过，
+// 这里忽略无法解析的值。
+func newIPFilter(allow, block []string) *ipFilter {
+	f := &ipFilter{}
+	f.allow, _ = ParseIPPrefixes(allow)
+	f.block, _ = ParseIPPrefixes(block)
+	if len(f.allow) == 0 && len(f.block) == 0 {
+		return nil
+	}
+	return f
+}
+
+// permitted 判断访问者 IP 是否允许访问。设置了 allow 时无法解析的地址一律拒绝。
+func (f *ipFilter) permitted(ip string) bool {
+	if f == nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(f.allow) == 0
+	}
+	addr = addr.Unmap()
+	for _, p := range f.block {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilterMiddleware 拒绝不符合 --allow-ip / --block-ip 的访问者 (403)，
+// 与封禁一样位于所有限制之前，分享者令牌不受影响
+func (s *Server) ipFilterMiddleware(next http.Handler) http.Handler {
+	if s.ipRules == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ipRules.permitted(clientIP(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"cfshare/internal/config"
+	"cfshare/internal/state"
+)
+
+// connLimiter 统计进行中的传输，限制总数 (--max-conns) 和单个 IP 的数量 (--max-conns-per-ip)
+type connLimiter struct {
+	mu       sync.Mutex
+	max      int // 0 表示不限
+	maxPerIP int // 0 表示不限
+	total    int
+	byIP     map[string]int
+}
+
+func newConnLimiter(max, maxPerIP int) *connLimiter {
+	return &connLimiter{max: max, maxPerIP: maxPerIP, byIP: make(map[string]int)}
+}
+
+// acquire 占用一个名额，超过任一上限时返回 false
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	if l.maxPerIP > 0 && l.byIP[ip] >= l.maxPerIP {
+		return false
+	}
+	l.total++
+	l.byIP[ip]++
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.byIP[ip]--; l.byIP[ip] <= 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+// isTransfer 判断请求是否为文件传输 (下载、打包下载、流、上传)。
+// 目录页面、缩略图等小请求不占用并发名额，浏览器并行加载页面时不会被拒绝。
+func (s *Server) isTransfer(r *http.Request) bool {
+	if s.isFileRequest() {
+		return r.Method == http.MethodPut || r.Method == http.MethodPost
+	}
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if s.shares().shareType == state.TypeStream {
+		return true
+	}
+	q := r.URL.Query()
+	if q.Get("thumb") == "1" || q.Get("qr") == "1" || q.Get("receipt") == "1" {
+		return false
+	}
+	if _, ok := archiveFormatFromQuery(r); ok {
+		return true
+	}
+	path, err := s.resolve(r.URL.Path)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// concurrencyMiddleware 在同时进行的传输达到上限时返回 429 和 Retry-After，
+// 避免公开链接被大量转发时拖垮本机。按 CF-Connecting-IP 区分访问者，分享者自己的请求不受限制。
+func (s *Server) concurrencyMiddleware(next http.Handler) http.Handler {
+	if s.state.MaxConns <= 0 && s.state.MaxConnsPerIP <= 0 {
+		return next
+	}
+	limiter := newConnLimiter(s.state.MaxConns, s.state.MaxConnsPerIP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOwner(r) || !s.isTransfer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if !limiter.acquire(ip) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(config.BusyRetryAfter.Seconds())))
+			http.Error(w, "Too Many Requests: too many downloads in progress, try again later", http.StatusTooManyRequests)
+			return
+		}
+		defer limiter.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
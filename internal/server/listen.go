@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"cfshare/internal/config"
+)
+
+// Listen 监听分享端口。端口已被占用 (残留的服务进程或同一 --port 的另一个分享) 时返回错误，
+// 不与其他进程共用端口。平滑升级 (cfshare upgrade --inplace) 时旧服务进程通过 ListenFDEnv
+// 把自己的监听 socket 传给新进程，新进程直接接管它而不重新绑定端口。
+func Listen(port int) (net.Listener, error) {
+	if fd := os.Getenv(config.ListenFDEnv); fd != "" {
+		// 之后启动的子进程不应再继承
+		os.Unsetenv(config.ListenFDEnv)
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", config.ListenFDEnv, fd)
+		}
+		f := os.NewFile(uintptr(n), "listener")
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", port))
+}
+
+// ListenerFile 返回监听 socket 的文件描述符副本，传给新服务进程 (exec.Cmd.ExtraFiles)
+func ListenerFile(ln net.Listener) (*os.File, error) {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("listener %T cannot be handed over", ln)
+	}
+	return tl.File()
+}
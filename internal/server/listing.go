@@ -0,0 +1,95 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// wantsJSON 判断请求是否需要 JSON 格式的目录列表:
+// ?format=json，或 Accept 中 application/json 优先于 text/html
+func wantsJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+
+	accept := r.Header.Get("Accept")
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		// 按出现顺序取第一个能识别的类型，浏览器总是把 text/html 放在前面
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html", "application/xhtml+xml", "*/*":
+			return false
+		}
+	}
+	return false
+}
+
+// listingJSON 是 JSON 目录列表的格式
+type listingJSON struct {
+	Path   string     `json:"path"`
+	Parent string     `json:"parent,omitempty"`
+	Files  []FileInfo `json:"files"`
+	Total  int        `json:"total"`
+	Page   int        `json:"page,omitempty"` // 仅在指定 ?page= 时分页
+	Pages  int        `json:"pages,omitempty"`
+}
+
+// serveListingJSON 以 JSON 输出目录列表，路径统一为以 / 开头的 URL 路径
+func serveListingJSON(w http.ResponseWriter, r *http.Request, page dirPage) {
+	out := listingJSON{
+		Path:  dirURLPath(page.Path),
+		Files: make([]FileInfo, len(page.Files)),
+		Total: page.Total,
+		Page:  page.Page,
+		Pages: page.Pages,
+	}
+	if out.Path != "/" {
+		out.Parent = dirURLPath(page.Parent)
+	}
+	for i, f := range page.Files {
+		f.Path = absURLPath(f.Path)
+		f.URL = page.Link(f.Path)
+		out.Files[i] = f
+	}
+
+	body, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	serveGenerated(w, r, page.modTime, "application/json; charset=utf-8", append(body, '\n'))
+}
+
+func absURLPath(p string) string {
+	if p == "." || p == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(p, "/") {
+		return "/" + p
+	}
+	return p
+}
+
+// Link 返回 URL 路径对应的完整公开链接，用于复制和分享单个文件
+func (p dirPage) Link(urlPath string) string {
+	return p.BaseURL + (&url.URL{Path: absURLPath(urlPath)}).EscapedPath()
+}
+
+func dirURLPath(p string) string {
+	p = absURLPath(p)
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p
+}
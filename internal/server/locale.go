@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"cfshare/internal/i18n"
+)
+
+// langCookie 保存访问者通过语言切换链接选择的语言
+const langCookie = "cfshare_lang"
+
+// pageLang 选择页面语言: 分享时用 --lang 指定语言则始终使用该语言；
+// 否则 ?lang= 切换并写入 cookie，其次是 cookie，最后按 Accept-Language 协商
+func (s *Server) pageLang(w http.ResponseWriter, r *http.Request) string {
+	if s.langFixed() {
+		return s.state.Lang
+	}
+	if lang := r.URL.Query().Get("lang"); i18n.Supported(lang) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     langCookie,
+			Value:    lang,
+			Path:     "/",
+			MaxAge:   365 * 24 * 3600,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return lang
+	}
+	if c, err := r.Cookie(langCookie); err == nil && i18n.Supported(c.Value) {
+		return c.Value
+	}
+	return i18n.Negotiate(r.Header.Get("Accept-Language"), i18n.Default)
+}
+
+// langFixed 判断是否用 --lang 固定了页面语言，此时不显示语言切换链接
+func (s *Server) langFixed() bool {
+	return i18n.Supported(s.state.Lang)
+}
+
+// translator 返回 lang 的文案函数，供模板通过 {{call .T "key"}} 使用
+func translator(lang string) func(key string, args ...any) string {
+	return func(key string, args ...any) string {
+		return i18n.T(lang, key, args...)
+	}
+}
+
+// T 返回当前页面语言的文案，供目录列表模板使用
+func (p dirPage) T(key string, args ...any) string {
+	return i18n.T(p.Lang, key, args...)
+}
+
+// Languages 返回语言切换链接中列出的语言，语言固定时为空
+func (p dirPage) Languages() []i18n.Language {
+	return p.languages
+}
+
+// LangLink 返回切换到 code 语言的链接，保留当前排序和页码
+func (p dirPage) LangLink(code string) string {
+	kv := []string{"lang", code}
+	if p.Page > 1 {
+		kv = append(kv, "page", strconv.Itoa(p.Page))
+	}
+	return p.link(kv...)
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+)
+
+// authFailures 统计每个 IP 最近的口令错误，用于 --max-auth-failures 锁定暴力破解
+type authFailures struct {
+	mu        sync.Mutex
+	window    time.Duration
+	times     map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newAuthFailures(window time.Duration) *authFailures {
+	return &authFailures{window: window, times: make(map[string][]time.Time)}
+}
+
+// fail 记录一次口令错误，返回该 IP 在窗口内的错误次数
+func (f *authFailures) fail(ip string, now time.Time) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// 每分钟清理一次窗口已过期的 IP，错误次数没有达到锁定阈值的 IP 不会一直留在表中
+	if now.Sub(f.lastSweep) > time.Minute {
+		for k, times := range f.times {
+			if now.Sub(times[len(times)-1]) >= f.window {
+				delete(f.times, k)
+			}
+		}
+		f.lastSweep = now
+	}
+	recent := f.times[ip][:0]
+	for _, t := range f.times[ip] {
+		if now.Sub(t) < f.window {
+			recent = append(recent, t)
+		}
+	}
+	f.times[ip] = append(recent, now)
+	return len(f.times[ip])
+}
+
+// reset 清除 IP 的错误记录 (登录成功或已被锁定)
+func (f *authFailures) reset(ip string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.times, ip)
+}
+
+// authFailureMiddleware 包在口令认证外面: 带了口令 (Authorization 头或登录表单) 却得到 401
+// 的请求计为一次失败。每个 IP 的尝试按 config.AuthAttemptRate 限速: 校验口令之前先取走一个令牌，
+// 取不到时直接返回 429，口令正确时退还。同一 IP 的并发请求也不能绕过限速反复触发 PBKDF2 计算
+// (不设 --req-rate 时同样生效)。
+// 同一 IP 在 config.AuthFailureWindow 内失败 --max-auth-failures 次后被临时封禁，
+// 并作为 lockout 异常写入访问日志和 --notify。没有带口令的请求 (浏览器首次访问) 不计。
+func (s *Server) authFailureMiddleware(next http.Handler) http.Handler {
+	max := s.state.MaxAuthFailures
+	failures := newAuthFailures(config.AuthFailureWindow)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := r.Header.Get("Authorization") != "" || (r.Method == http.MethodPost && r.URL.Path == auth.LoginPath)
+		if !attempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if !s.attempts.allow(ip, time.Now()) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		if rw.statusCode != http.StatusUnauthorized {
+			s.attempts.refund(ip)
+			failures.reset(ip)
+			return
+		}
+		if max <= 0 {
+			return
+		}
+		if n := failures.fail(ip, time.Now()); n >= max {
+			failures.reset(ip)
+			s.bans.ban(ip, config.AuthLockoutDuration)
+			s.raiseAnomaly(r, "lockout", fmt.Sprintf("%s 口令连续错误 %d 次，已锁定 %s", ip, n, config.AuthLockoutDuration))
+		}
+	})
+}
+
+// requestLimiter 是按 IP 的请求令牌桶 (--req-rate)，每秒补充 rate 个，容量为 2 倍，
+// 允许页面加载时的短暂并发请求
+type requestLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*requestBucket
+	lastSweep time.Time
+}
+
+type requestBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRequestLimiter(rate int) *requestLimiter {
+	return &requestLimiter{rate: float64(rate), burst: float64(2 * rate), buckets: make(map[string]*requestBucket)}
+}
+
+// allow 为 IP 取走一个令牌，令牌用完时返回 false
+func (l *requestLimiter) allow(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(ip, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund 退还 allow 取走的令牌
+func (l *requestLimiter) refund(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b := l.buckets[ip]; b != nil {
+		b.tokens = min(l.burst, b.tokens+1)
+	}
+}
+
+// refill 按经过的时间给 IP 的桶补充令牌并返回它，调用者持有 l.mu
+func (l *requestLimiter) refill(ip string, now time.Time) *requestBucket {
+	// 每分钟清理一次已经补满的桶，长时间运行的公开分享不会无限积累
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b := l.buckets[ip]
+	if b == nil {
+		b = &requestBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+// requestRateMiddleware 限制单个 IP 每秒的请求数 (--req-rate)，超出返回 429 和 Retry-After，
+// 挡住扫描和请求洪水。位于认证之前，也限制了猜口令的速度。分享者令牌不受影响。
+func (s *Server) requestRateMiddleware(next http.Handler) http.Handler {
+	if s.state.ReqRate <= 0 {
+		return next
+	}
+	limiter := newRequestLimiter(s.state.ReqRate)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !limiter.allow(clientIP(r), time.Now()) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	"cfshare/internal/auth"
+)
+
+// loginPage 是 --auth form 登录页模板的数据
+type loginPage struct {
+	Lang        string
+	Realm       string
+	Action      string
+	Next        string
+	Failed      bool
+	Contact     string
+	ContactLink string
+	ThemeCSS    template.CSS
+}
+
+// T 返回当前页面语言的文案，供登录页模板使用
+func (p loginPage) T(key string, args ...any) string {
+	return translator(p.Lang)(key, args...)
+}
+
+// formAuth 返回 --auth form 的配置，登录页使用与错误页相同的卡片样式和主题
+func (s *Server) formAuth(username, passwordHash string) auth.FormAuth {
+	return auth.FormAuth{
+		Username:     username,
+		PasswordHash: passwordHash,
+		Secret:       s.state.SessionSecret,
+		Lifetime:     s.state.SessionLifetime,
+		Users:        s.users,
+		Page:         s.writeLoginPage,
+	}
+}
+
+// writeLoginPage 以 401 输出登录页，next 是登录后返回的地址
+func (s *Server) writeLoginPage(w http.ResponseWriter, r *http.Request, next string, failed bool) {
+	page := loginPage{
+		Lang:        s.pageLang(w, r),
+		Realm:       s.state.Realm,
+		Action:      s.mounted(auth.LoginPath),
+		Next:        next,
+		Failed:      failed,
+		Contact:     s.state.Contact,
+		ContactLink: contactLink(s.state.Contact),
+		ThemeCSS:    themeCSS(s.state.Theme),
+	}
+	if page.Realm == "" {
+		page.Realm = "cfshare"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	loginPageTemplate.Execute(w, page)
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.T "login_title"}} - {{.Realm}}</title>
+    <style>
+` + cardStyle + `        label { display: block; margin-top: 12px; font-size: 14px; }
+        input[type=text], input[type=password] {
+            width: 100%;
+            margin-top: 4px;
+            padding: 10px;
+            border: 1px solid #ddd;
+            border-radius: 6px;
+            font-size: 16px;
+        }
+        .error { color: #dc2626; }
+{{.ThemeCSS}}
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🔒 {{.Realm}}</h1>
+        <form class="body" method="post" action="{{.Action}}">
+            {{if .Failed}}<p class="error">{{.T "login_failed"}}</p>{{end}}
+            <label>{{.T "login_username"}}
+                <input type="text" name="username" autocomplete="username" autocapitalize="none" required autofocus>
+            </label>
+            <label>{{.T "login_password"}}
+                <input type="password" name="password" autocomplete="current-password" required>
+            </label>
+            <input type="hidden" name="next" value="{{.Next}}">
+            <button type="submit">{{.T "login_submit"}}</button>
+            {{if .Contact}}
+            <div class="contact">
+                {{.T "error_contact"}}
+                {{if .ContactLink}}<a href="{{.ContactLink}}">{{.Contact}}</a>{{else}}{{.Contact}}{{end}}
+            </div>
+            {{end}}
+        </form>
+    </div>
+</body>
+</html>`))
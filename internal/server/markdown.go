@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cfshare/internal/markdown"
+)
+
+// maxMarkdownBytes 超过该大小的 Markdown 文件不渲染，直接下载
+const maxMarkdownBytes = 2 << 20
+
+// isMarkdown 判断文件名是否为 Markdown 文件
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// isMarkdownRequest 判断是否应把请求的文件渲染为 HTML。
+// 带任何查询参数 (如 ?raw=1) 时按原样下载。
+func isMarkdownRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.URL.RawQuery == "" && isMarkdown(r.URL.Path)
+}
+
+// handleMarkdown 把 .md 文件渲染为带样式的 HTML 页面
+func (s *Server) handleMarkdown(w http.ResponseWriter, r *http.Request) {
+	fullPath, err := s.resolve(r.URL.Path)
+	if err != nil {
+		writeResolveError(w, r, err)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := filepath.Base(fullPath)
+	content, ok := renderMarkdownFile(fullPath)
+	if !ok {
+		s.download(w, r, fullPath, name)
+		return
+	}
+
+	tmpl := template.Must(template.New("markdown").Parse(markdownTemplate))
+	var buf bytes.Buffer
+	lang := s.pageLang(w, r)
+	tmpl.Execute(&buf, struct {
+		Lang    string
+		Name    string
+		Path    string
+		Size    string
+		Content template.HTML
+		T       func(key string, args ...any) string
+	}{
+		Lang:    lang,
+		T:       translator(lang),
+		Name:    name,
+		Path:    s.mounted(r.URL.Path),
+		Size:    formatSize(info.Size()),
+		Content: content,
+	})
+	serveHTML(w, r, info.ModTime(), buf.Bytes())
+}
+
+// renderMarkdownFile 读取并渲染 Markdown 文件，文件过大或无法读取时返回 false
+func renderMarkdownFile(path string) (template.HTML, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxMarkdownBytes+1))
+	if err != nil || len(data) > maxMarkdownBytes {
+		return "", false
+	}
+	// markdown.Render 会转义源文本中的 HTML，输出可以直接嵌入页面
+	return template.HTML(markdown.Render(data)), true
+}
+
+// readmeHTML 查找目录中的 README.md 并渲染，没有时返回空
+func readmeHTML(dir string) template.HTML {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(entry.Name(), "README.md") {
+			continue
+		}
+		// 符号链接可能指向分享目录之外
+		if entry.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		if content, ok := renderMarkdownFile(filepath.Join(dir, entry.Name())); ok {
+			return content
+		}
+	}
+	return ""
+}
+
+// markdownStyle 是 Markdown 内容的样式，文件页面和目录 README 共用
+const markdownStyle = `
+        .markdown { line-height: 1.6; color: #1f2937; word-wrap: break-word; }
+        .markdown h1, .markdown h2 { border-bottom: 1px solid #eee; padding-bottom: 6px; }
+        .markdown h1 { font-size: 1.8em; background: none; color: inherit; padding: 0 0 6px; margin: 0.6em 0; }
+        .markdown code { background: #f3f4f6; padding: 2px 4px; border-radius: 4px; font-size: 0.9em; }
+        .markdown pre { background: #f3f4f6; padding: 12px; border-radius: 6px; overflow-x: auto; }
+        .markdown pre code { background: none; padding: 0; }
+        .markdown blockquote { margin: 0; padding: 0 1em; color: #6b7280; border-left: 4px solid #e5e7eb; }
+        .markdown table { border-collapse: collapse; width: auto; }
+        .markdown th, .markdown td { border: 1px solid #e5e7eb; padding: 6px 12px; }
+        .markdown img { max-width: 100%; }
+        .markdown a { color: #2563eb; }
+`
+
+const markdownTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Name}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 900px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        .header {
+            padding: 15px 20px;
+            background: #2563eb;
+            color: white;
+            display: flex;
+            justify-content: space-between;
+            gap: 10px;
+            word-break: break-all;
+        }
+        .header a { color: white; white-space: nowrap; }
+        .body { padding: 10px 30px 30px; }
+` + markdownStyle + `
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <span>📄 {{.Name}}</span>
+            <a href="{{.Path}}?raw=1">⬇️ {{call .T "raw_file" .Size}}</a>
+        </div>
+        <div class="body markdown">
+            {{.Content}}
+        </div>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cfshare/internal/thumb"
+)
+
+// mediaTypes 是可以在浏览器中直接播放的媒体扩展名
+var mediaTypes = map[string]string{
+	".mp4":  "video",
+	".m4v":  "video",
+	".webm": "video",
+	".mkv":  "video",
+	".mov":  "video",
+	".ogv":  "video",
+	".mp3":  "audio",
+	".m4a":  "audio",
+	".aac":  "audio",
+	".ogg":  "audio",
+	".oga":  "audio",
+	".opus": "audio",
+	".wav":  "audio",
+	".flac": "audio",
+}
+
+// mediaKind 返回文件的媒体类型 ("video" / "audio")，非媒体文件返回空字符串
+func mediaKind(name string) string {
+	return mediaTypes[strings.ToLower(filepath.Ext(name))]
+}
+
+// isMediaRequest 判断是否为播放页 (?play=1) 或内联流 (?inline=1) 请求
+func isMediaRequest(r *http.Request) bool {
+	q := r.URL.Query()
+	return q.Get("play") == "1" || q.Get("inline") == "1"
+}
+
+// handleMedia 为媒体文件提供播放页和支持 Range 的内联流
+func (s *Server) handleMedia(w http.ResponseWriter, r *http.Request) {
+	fullPath, err := s.resolve(r.URL.Path)
+	if err != nil {
+		writeResolveError(w, r, err)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := filepath.Base(fullPath)
+	kind := mediaKind(name)
+	if kind == "" {
+		// 图片可以内联查看，其他文件仍然作为附件下载
+		if thumb.IsImage(name) && r.URL.Query().Get("inline") == "1" {
+			serveInline(w, r, fullPath, name)
+		} else {
+			s.download(w, r, fullPath, name)
+		}
+		return
+	}
+
+	if r.URL.Query().Get("inline") == "1" {
+		serveInline(w, r, fullPath, name)
+		return
+	}
+
+	tmpl := template.Must(template.New("player").Parse(playerTemplate))
+	var buf bytes.Buffer
+	lang := s.pageLang(w, r)
+	tmpl.Execute(&buf, struct {
+		Lang string
+		Name string
+		Path string
+		Kind string
+		Size string
+		T    func(key string, args ...any) string
+	}{
+		Lang: lang,
+		T:    translator(lang),
+		Name: name,
+		Path: s.mounted(r.URL.Path),
+		Kind: kind,
+		Size: formatSize(info.Size()),
+	})
+	serveHTML(w, r, info.ModTime(), buf.Bytes())
+}
+
+const playerTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Name}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #111827;
+            color: #e5e7eb;
+        }
+        .container {
+            max-width: 1100px;
+            margin: 0 auto;
+        }
+        h1 {
+            font-size: 16px;
+            font-weight: 500;
+            word-break: break-all;
+        }
+        video, audio {
+            width: 100%;
+            background: black;
+            border-radius: 8px;
+        }
+        audio { background: transparent; }
+        a { color: #93c5fd; text-decoration: none; }
+        .meta { margin-top: 15px; font-size: 14px; color: #9ca3af; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>▶ {{.Name}}</h1>
+        {{if eq .Kind "video"}}
+        <video controls autoplay preload="metadata" src="{{.Path}}?inline=1"></video>
+        {{else}}
+        <audio controls autoplay preload="metadata" src="{{.Path}}?inline=1"></audio>
+        {{end}}
+        <div class="meta">
+            {{.Size}} · <a href="{{.Path}}">⬇️ {{call .T "download"}}</a>
+        </div>
+    </div>
+</body>
+</html>`
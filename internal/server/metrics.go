@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"cfshare/internal/state"
+)
+
+// shareMetrics 是服务进程启动以来的访问计数，以 Prometheus 文本格式在 /metrics 上提供
+type shareMetrics struct {
+	active atomic.Int64 // 进行中的传输
+
+	mu        sync.Mutex
+	requests  map[string]int64 // 状态码 -> 请求数
+	bytesSent int64
+	downloads map[string]int64 // 分享项 -> 完整下载次数
+}
+
+func newShareMetrics() *shareMetrics {
+	return &shareMetrics{
+		requests:  make(map[string]int64),
+		downloads: make(map[string]int64),
+	}
+}
+
+// observe 记录一个已完成的请求，item 为完整下载的分享项 (不是完整下载时为空)
+func (m *shareMetrics) observe(status int, bytes int64, item string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[strconv.Itoa(status)]++
+	m.bytesSent += bytes
+	if item != "" {
+		m.downloads[item]++
+	}
+}
+
+// writeMetrics 以 Prometheus 文本格式输出访问计数、tunnel 状态和服务进程的资源采样
+func (s *Server) writeMetrics(w io.Writer) {
+	m := s.metrics
+	// 尚未下载过的分享项也输出 0，便于在图表中看到全部分享项
+	perItem := make(map[string]int64)
+	for _, item := range s.shares().items {
+		perItem[item.Name] = 0
+	}
+	m.mu.Lock()
+	requests := sortedCounts(m.requests)
+	for name, n := range m.downloads {
+		perItem[name] = n
+	}
+	bytesSent := m.bytesSent
+	m.mu.Unlock()
+	downloads := sortedCounts(perItem)
+
+	fmt.Fprintln(w, "# HELP cfshare_requests_total HTTP requests served, by status code.")
+	fmt.Fprintln(w, "# TYPE cfshare_requests_total counter")
+	for _, c := range requests {
+		fmt.Fprintf(w, "cfshare_requests_total{code=%s} %d\n", promLabel(c.label), c.count)
+	}
+
+	fmt.Fprintln(w, "# HELP cfshare_bytes_sent_total Response bytes sent.")
+	fmt.Fprintln(w, "# TYPE cfshare_bytes_sent_total counter")
+	fmt.Fprintf(w, "cfshare_bytes_sent_total %d\n", bytesSent)
+
+	fmt.Fprintln(w, "# HELP cfshare_active_transfers Downloads and uploads in progress.")
+	fmt.Fprintln(w, "# TYPE cfshare_active_transfers gauge")
+	fmt.Fprintf(w, "cfshare_active_transfers %d\n", m.active.Load())
+
+	fmt.Fprintln(w, "# HELP cfshare_item_downloads_total Complete downloads per shared item.")
+	fmt.Fprintln(w, "# TYPE cfshare_item_downloads_total counter")
+	for _, c := range downloads {
+		fmt.Fprintf(w, "cfshare_item_downloads_total{item=%s} %d\n", promLabel(c.label), c.count)
+	}
+
+	// tunnel 状态来自降级检测写入的 health.json，没有公开地址时不输出
+	if s.state.PublicURL != "" {
+		up, restarts := 1, 0
+		h := state.LoadHealth()
+		if h != nil && h.Degraded {
+			up, restarts = 0, h.Restarts
+		} else if h != nil && h.TunnelDown {
+			up, restarts = 0, h.TunnelRestarts
+		}
+		if h != nil && !h.CheckedAt.IsZero() {
+			fmt.Fprintln(w, "# HELP cfshare_tunnel_connections Edge connections registered by cloudflared.")
+			fmt.Fprintln(w, "# TYPE cfshare_tunnel_connections gauge")
+			fmt.Fprintf(w, "cfshare_tunnel_connections %d\n", h.Connections)
+		}
+		fmt.Fprintln(w, "# HELP cfshare_tunnel_up Whether the public URL is reachable through the tunnel.")
+		fmt.Fprintln(w, "# TYPE cfshare_tunnel_up gauge")
+		fmt.Fprintf(w, "cfshare_tunnel_up %d\n", up)
+		fmt.Fprintln(w, "# HELP cfshare_tunnel_restarts Automatic tunnel restarts during the current outage.")
+		fmt.Fprintln(w, "# TYPE cfshare_tunnel_restarts gauge")
+		fmt.Fprintf(w, "cfshare_tunnel_restarts %d\n", restarts)
+	}
+
+	if !s.state.StartTime.IsZero() {
+		fmt.Fprintln(w, "# HELP cfshare_start_time_seconds Unix time the share was started.")
+		fmt.Fprintln(w, "# TYPE cfshare_start_time_seconds gauge")
+		fmt.Fprintf(w, "cfshare_start_time_seconds %d\n", s.state.StartTime.Unix())
+	}
+
+	resourcesVar.mu.Lock()
+	r := resourcesVar.last
+	resourcesVar.mu.Unlock()
+	if !r.Time.IsZero() {
+		fmt.Fprintln(w, "# HELP cfshare_heap_bytes Heap memory in use by the server process.")
+		fmt.Fprintln(w, "# TYPE cfshare_heap_bytes gauge")
+		fmt.Fprintf(w, "cfshare_heap_bytes %d\n", r.HeapBytes)
+		fmt.Fprintln(w, "# HELP cfshare_goroutines Goroutines in the server process.")
+		fmt.Fprintln(w, "# TYPE cfshare_goroutines gauge")
+		fmt.Fprintf(w, "cfshare_goroutines %d\n", r.Goroutines)
+		if r.OpenFDs >= 0 {
+			fmt.Fprintln(w, "# HELP cfshare_open_fds Open file descriptors in the server process.")
+			fmt.Fprintln(w, "# TYPE cfshare_open_fds gauge")
+			fmt.Fprintf(w, "cfshare_open_fds %d\n", r.OpenFDs)
+		}
+	}
+}
+
+// handleMetrics 提供 /metrics，只挂在本机回环地址的监听上 (--metrics-addr、--debug-addr)
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writeMetrics(w)
+}
+
+type labeledCount struct {
+	label string
+	count int64
+}
+
+func sortedCounts(counts map[string]int64) []labeledCount {
+	out := make([]labeledCount, 0, len(counts))
+	for k, v := range counts {
+		out = append(out, labeledCount{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].label < out[j].label })
+	return out
+}
+
+// promLabel 按 Prometheus 文本格式转义标签值
+func promLabel(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
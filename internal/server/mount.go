@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/state"
+)
+
+// mountMiddleware 在分享挂载到路径前缀 (--mount /docs/)、随机路径 (--slug) 下或链接中带访问令牌
+// (--auth token，/t/<token>/) 时去掉请求路径中的前缀，前缀之外的请求返回 404，
+// 内部的路径解析、签名和日志都按去掉前缀后的路径处理，日志中不会出现令牌。
+// 多个分享共用一个 tunnel 主机名时，cloudflared ingress 按前缀把请求转给各自的端口。
+// 本机直接访问的 /healthz 和 /readyz 不需要前缀，公开状态页不需要令牌。
+func (s *Server) mountMiddleware(next http.Handler) http.Handler {
+	root := s.root()
+	if root == "" {
+		return next
+	}
+	mount := s.state.BasePath()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case healthzPath, readyzPath:
+			next.ServeHTTP(w, r)
+			return
+		case mount + statusPath:
+			next.ServeHTTP(w, stripPrefix(r, mount))
+			return
+		}
+
+		rest, ok := strings.CutPrefix(r.URL.Path, mount)
+		if ok && rest != "" && !strings.HasPrefix(rest, "/") {
+			ok = false
+		}
+		if ok && s.state.Auth == state.AuthToken {
+			rest, ok = auth.StripLinkToken(rest, s.state.LinkTokenHash)
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if s.state.Auth == state.AuthToken {
+			// 页面中的外部链接不应通过 Referer 带走令牌
+			w.Header().Set("Referrer-Policy", "same-origin")
+		}
+		if rest == "" {
+			target := root + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		next.ServeHTTP(&mountWriter{ResponseWriter: w, mount: root}, stripPrefix(r, root))
+	})
+}
+
+// root 返回分享在主机名下的根路径 (挂载前缀、随机路径加上访问令牌)，占用整个主机名时为空
+func (s *Server) root() string {
+	if s.state.Auth == state.AuthToken {
+		return s.state.BasePath() + auth.LinkTokenPath(s.state.LinkToken)
+	}
+	return s.state.BasePath()
+}
+
+// stripPrefix 返回去掉路径前缀 prefix 的请求副本
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	if r.URL.RawPath != "" {
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	}
+	return r2
+}
+
+// mountWriter 给处理程序写出的站内跳转和 cookie 路径加上挂载前缀，
+// 处理程序不需要知道自己挂载在哪里
+type mountWriter struct {
+	http.ResponseWriter
+	mount       string
+	wroteHeader bool
+}
+
+func (w *mountWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if loc := h.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+			h.Set("Location", w.mount+loc)
+		}
+		// 同一主机名下的其他分享不应收到这个分享的会话和条款 cookie
+		for i, c := range h.Values("Set-Cookie") {
+			h["Set-Cookie"][i] = strings.Replace(c, "; Path=/", "; Path="+w.mount+"/", 1)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *mountWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *mountWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// mounted 返回站内路径 p 在挂载前缀下的完整路径，用于页面中的链接。
+// 根目录列表中的路径不带开头的斜杠 (如 "sub/")，同样相对于分享根目录。
+func (s *Server) mounted(p string) string {
+	root := s.root()
+	if root != "" && !strings.HasPrefix(p, "/") {
+		return root + "/" + p
+	}
+	return root + p
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"cfshare/internal/auth"
+)
+
+const (
+	ownerPath   = "/__owner__"
+	ownerCookie = "cfshare_owner"
+	ownerHeader = "X-Cfshare-Owner"
+
+	// ownerUser 是分享者本人访问时记录的身份
+	ownerUser = "owner"
+)
+
+type ownerContextKey struct{}
+
+// isOwner 判断请求是否来自持有分享者令牌的分享者本人。
+// 分享者的请求走优先通道，不受封禁、限速和并发上限等针对访问者的限制。
+func isOwner(r *http.Request) bool {
+	owner, _ := r.Context().Value(ownerContextKey{}).(bool)
+	return owner
+}
+
+// ownerToken 返回请求携带的分享者令牌: X-Cfshare-Owner 头或 /__owner__ 设置的 cookie
+func ownerToken(r *http.Request) string {
+	if token := r.Header.Get(ownerHeader); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(ownerCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+func (s *Server) validOwnerToken(token string) bool {
+	return auth.CheckToken(s.state.OwnerTokenHash, token)
+}
+
+// ownerMiddleware 位于所有限制之前: 带有效分享者令牌的请求跳过封禁和口令认证，
+// 直接交给 inner 处理；其他请求交给 next。
+// 访问 /__owner__?token= 会把令牌保存到 cookie，之后浏览器中的访问都走优先通道。
+func (s *Server) ownerMiddleware(next, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == ownerPath {
+			if token := r.URL.Query().Get("token"); s.validOwnerToken(token) {
+				http.SetCookie(w, &http.Cookie{
+					Name:     ownerCookie,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+					SameSite: http.SameSiteLaxMode,
+				})
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+		}
+
+		if !s.validOwnerToken(ownerToken(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ownerContextKey{}, true)
+		ctx = auth.WithUser(ctx, ownerUser)
+		inner.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
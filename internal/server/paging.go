@@ -0,0 +1,134 @@
+package server
+
+import (
+	"cmp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// listingPageSize 是目录列表每页显示的条目数，避免超大目录生成巨大的页面
+const listingPageSize = 500
+
+// 目录列表支持的排序字段 (?sort=)
+const (
+	sortName  = "name"
+	sortSize  = "size"
+	sortMTime = "mtime"
+)
+
+// listingSort 解析 ?sort= 和 ?order=，无效值按名称升序
+func listingSort(q url.Values) (key string, desc bool) {
+	key = q.Get("sort")
+	switch key {
+	case sortSize, sortMTime:
+	default:
+		key = sortName
+	}
+	return key, q.Get("order") == "desc"
+}
+
+// listingPage 解析 ?page=，页码从 1 开始
+func listingPage(q url.Values) int {
+	n, err := strconv.Atoi(q.Get("page"))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// sortFiles 按指定字段排序，目录始终排在文件前面，相同时按名称排序
+func sortFiles(files []FileInfo, key string, desc bool) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+
+		c := 0
+		switch key {
+		case sortSize:
+			// 目录按后台统计的递归大小，尚未统计完成的按目前的部分
+			c = cmp.Compare(a.Size, b.Size)
+		case sortMTime:
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if desc {
+			c = -c
+		}
+		return c < 0
+	})
+}
+
+// paginate 把 Files 截取为第 n 页，超出范围的页码取最后一页
+func (p *dirPage) paginate(n int) {
+	p.Pages = (len(p.Files) + listingPageSize - 1) / listingPageSize
+	if p.Pages < 1 {
+		p.Pages = 1
+	}
+	p.Page = min(n, p.Pages)
+
+	start := (p.Page - 1) * listingPageSize
+	end := min(start+listingPageSize, len(p.Files))
+	p.Files = p.Files[start:end]
+}
+
+// link 返回修改查询参数后的当前页链接；除翻页外的修改都回到第一页
+func (p dirPage) link(kv ...string) string {
+	q := url.Values{}
+	for k, v := range p.params {
+		q[k] = v
+	}
+	q.Del("format")
+	q.Del("page")
+	q.Del("lang")
+	for i := 0; i+1 < len(kv); i += 2 {
+		q.Set(kv[i], kv[i+1])
+	}
+	return "?" + q.Encode()
+}
+
+// ViewLink 返回切换到列表或图库视图的链接，保留当前排序
+func (p dirPage) ViewLink(view string) string {
+	return p.link("view", view)
+}
+
+// SortLink 返回按 key 排序的链接，已按 key 升序时切换为降序
+func (p dirPage) SortLink(key string) string {
+	order := "asc"
+	if p.Sort == key && !p.Desc {
+		order = "desc"
+	}
+	return p.link("sort", key, "order", order)
+}
+
+// SortMark 返回表头上表示当前排序方向的箭头
+func (p dirPage) SortMark(key string) string {
+	if p.Sort != key {
+		return ""
+	}
+	if p.Desc {
+		return " ▼"
+	}
+	return " ▲"
+}
+
+// PrevLink 返回上一页的链接，已是第一页时返回空字符串
+func (p dirPage) PrevLink() string {
+	if p.Page <= 1 {
+		return ""
+	}
+	return p.link("page", strconv.Itoa(p.Page-1))
+}
+
+// NextLink 返回下一页的链接，已是最后一页时返回空字符串
+func (p dirPage) NextLink() string {
+	if p.Page >= p.Pages {
+		return ""
+	}
+	return p.link("page", strconv.Itoa(p.Page+1))
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"cfshare/internal/state"
+)
+
+// maxPasteBytes 超过该大小的文本片段不渲染页面，只提供原始内容
+const maxPasteBytes = 1 << 20
+
+// isPasteRequest 判断是否请求 cfshare send 分享的文本片段 (页面或 ?raw=1 原始内容)
+func (s *Server) isPasteRequest(r *http.Request) bool {
+	set := s.shares()
+	if !s.state.Paste || set.isMulti || set.shareType != state.TypeFile {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	name := filepath.Base(set.sharePath)
+	return r.URL.Path == "/" || r.URL.Path == "/"+name
+}
+
+// handlePaste 把文本片段显示为简单的页面，?raw=1 返回 text/plain 原文，便于 curl 直接取用
+func (s *Server) handlePaste(w http.ResponseWriter, r *http.Request) {
+	f, err := os.Open(s.shares().sharePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("raw") == "1" || info.Size() > maxPasteBytes {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeContent(w, r, "", info.ModTime(), f)
+		return
+	}
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	name := filepath.Base(s.shares().sharePath)
+	lang := s.pageLang(w, r)
+	tmpl := template.Must(template.New("paste").Parse(pasteTemplate))
+	var buf bytes.Buffer
+	tmpl.Execute(&buf, struct {
+		Lang    string
+		Name    string
+		Raw     string
+		Size    string
+		Content string
+		T       func(key string, args ...any) string
+	}{
+		Lang:    lang,
+		T:       translator(lang),
+		Name:    name,
+		Raw:     s.mounted("/" + name + "?raw=1"),
+		Size:    formatSize(info.Size()),
+		Content: string(content),
+	})
+	serveHTML(w, r, info.ModTime(), buf.Bytes())
+}
+
+const pasteTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Name}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 1000px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        .header {
+            padding: 15px 20px;
+            background: #2563eb;
+            color: white;
+            display: flex;
+            justify-content: space-between;
+            gap: 10px;
+            word-break: break-all;
+        }
+        .header a { color: white; white-space: nowrap; }
+        pre {
+            margin: 0;
+            padding: 20px;
+            overflow-x: auto;
+            font-family: ui-monospace, SFMono-Regular, Menlo, Consolas, monospace;
+            font-size: 13px;
+            line-height: 1.5;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <span>📝 {{.Name}}</span>
+            <a href="{{.Raw}}">⬇️ {{call .T "raw_file" .Size}}</a>
+        </div>
+        <pre>{{.Content}}</pre>
+    </div>
+</body>
+</html>`
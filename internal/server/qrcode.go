@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/qr"
+)
+
+// qrModuleSize 是网页中 QR 码每个模块的像素数
+const qrModuleSize = 4
+
+// handleQR 以 SVG 返回文件完整链接的 QR 码 (?qr=1)，方便在手机上扫码下载。
+// 需要口令的分享使用签名链接，手机上无需再输入口令。
+func (s *Server) handleQR(w http.ResponseWriter, r *http.Request) {
+	fullPath, err := s.resolve(r.URL.Path)
+	if err != nil {
+		writeResolveError(w, r, err)
+		return
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.canLink() {
+		http.Error(w, "Signed links are not available for this share", http.StatusServiceUnavailable)
+		return
+	}
+
+	link := s.directLink(s.baseURL(r), r.URL.Path, time.Now().Add(config.DefaultLinkTTL))
+	code, err := qr.Encode(link)
+	if err != nil {
+		http.Error(w, "Link too long for a QR code", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// 签名链接每次生成都不同，不缓存
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(code.SVG(qrModuleSize)))
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket 是按字节计的令牌桶。take 采用预约方式: 先扣除令牌 (可以为负)，
+// 再等待欠下的部分补足，多个连接共享同一个桶时按到达顺序公平分配带宽。
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的字节数
+	burst  float64 // 桶的容量
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	burst := float64(rateChunk(rate))
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take 取走 n 个令牌，令牌不足时等待，ctx 结束时归还令牌并返回错误
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// 请求已结束，归还预约的令牌，不占用其他连接的带宽
+		b.mu.Lock()
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateChunk 返回每次写入的最大字节数: 约 1/10 秒的流量，在 1KB 到 32KB 之间，
+// 分小块写入使速率平稳，也避免一次写入等待过久
+func rateChunk(rate int64) int {
+	return int(min(max(rate/10, 1<<10), 32<<10))
+}
+
+// rateLimitedWriter 按令牌桶限制写入速度，buckets 依次为全局和单连接的限额
+type rateLimitedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*tokenBucket
+	chunk   int
+}
+
+func (lw *rateLimitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), lw.chunk)
+		for _, b := range lw.buckets {
+			if err := b.take(lw.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		m, err := lw.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (lw *rateLimitedWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// rateLimitMiddleware 限制下载带宽 (--limit-rate): LimitRate 是所有连接合计的上限，
+// LimitRateConn 是单个连接的上限，避免大文件分享占满上行带宽。分享者自己的请求不受限制。
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	total, perConn := s.state.LimitRate, s.state.LimitRateConn
+	if total <= 0 && perConn <= 0 {
+		return next
+	}
+	var shared *tokenBucket
+	if total > 0 {
+		shared = newTokenBucket(total)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOwner(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		lw := &rateLimitedWriter{ResponseWriter: w, ctx: r.Context()}
+		smallest := int64(0)
+		if shared != nil {
+			lw.buckets = append(lw.buckets, shared)
+			smallest = total
+		}
+		if perConn > 0 {
+			lw.buckets = append(lw.buckets, newTokenBucket(perConn))
+			if smallest == 0 || perConn < smallest {
+				smallest = perConn
+			}
+		}
+		lw.chunk = rateChunk(smallest)
+		next.ServeHTTP(lw, r)
+	})
+}
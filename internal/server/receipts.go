@@ -0,0 +1,264 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+	"cfshare/internal/i18n"
+	"cfshare/internal/receipt"
+)
+
+// maxRecipientLength 限制接收方自填备注的长度
+const maxRecipientLength = 100
+
+// receiptFormLifetime 是确认页表单令牌的有效期
+const receiptFormLifetime = time.Hour
+
+// transferLog 记录每个访问者 (IP) 最近完整下载过的文件，签收凭证只能为这些下载生成
+type transferLog struct {
+	mu        sync.Mutex
+	done      map[string]time.Time // IP + "\x00" + 路径 → 下载完成的时间
+	lastSweep time.Time
+}
+
+func newTransferLog() *transferLog {
+	return &transferLog{done: make(map[string]time.Time)}
+}
+
+// add 记录 ip 完整下载了 urlPath
+func (l *transferLog) add(ip, urlPath string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 每分钟清理一次超过 config.ReceiptWindow 的记录
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, t := range l.done {
+			if now.Sub(t) > config.ReceiptWindow {
+				delete(l.done, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	l.done[ip+"\x00"+urlPath] = now
+}
+
+// has 判断 ip 是否在 config.ReceiptWindow 内完整下载过 urlPath
+func (l *transferLog) has(ip, urlPath string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.done[ip+"\x00"+urlPath]
+	return ok && now.Sub(t) <= config.ReceiptWindow
+}
+
+// receiptFormPath 是表单令牌签名的内容: 令牌只对同一个访问者和同一个文件有效
+func receiptFormPath(ip, urlPath string) string {
+	return "receipt\x00" + ip + "\x00" + urlPath
+}
+
+// sameOrigin 判断 POST 是否来自分享自己的页面: 浏览器带 Origin 时其主机必须与请求的主机相同，
+// 没有 Origin 时拒绝浏览器标记为跨站的请求
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// handleReceipt 处理 "确认收到": GET 显示确认页，POST 生成并保存签收凭证。
+// 只有在 config.ReceiptWindow 内从同一 IP 完整下载过该文件 (loggingMiddleware 记录) 才能签收，
+// POST 须来自同源页面并带有确认页签发的表单令牌。
+func (s *Server) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	fullPath, err := s.resolve(r.URL.Path)
+	if err != nil {
+		writeResolveError(w, r, err)
+		return
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	lang := s.pageLang(w, r)
+	ip := clientIP(r)
+	page := receiptPage{
+		Lang:       lang,
+		T:          translator(lang),
+		Name:       filepath.Base(fullPath),
+		Size:       info.Size(),
+		Path:       s.mounted(r.URL.Path),
+		Downloaded: s.transfers.has(ip, r.URL.Path, time.Now()),
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		expires := time.Now().Add(receiptFormLifetime)
+		page.Exp = strconv.FormatInt(expires.Unix(), 10)
+		page.Sig = auth.SignPath(s.formKey, receiptFormPath(ip, r.URL.Path), expires)
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+		r.ParseForm()
+		if !sameOrigin(r) || !auth.VerifyPath(s.formKey, receiptFormPath(ip, r.URL.Path), r.PostFormValue("exp"), r.PostFormValue("sig")) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !page.Downloaded {
+			http.Error(w, i18n.T(lang, "receipt_download"), http.StatusForbidden)
+			return
+		}
+		recipient := strings.TrimSpace(r.PostFormValue("name"))
+		if len(recipient) > maxRecipientLength {
+			recipient = recipient[:maxRecipientLength]
+		}
+
+		sum, err := fileSHA256(fullPath)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		rec := receipt.Receipt{
+			ShareID:    s.state.ShareID,
+			Time:       time.Now().UTC(),
+			Path:       r.URL.Path,
+			Name:       page.Name,
+			Size:       info.Size(),
+			SHA256:     sum,
+			RemoteAddr: ip,
+			Recipient:  recipient,
+		}
+		if err := receipt.Sign(&rec); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := receipt.Append(rec); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		page.Receipt = &rec
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl := template.Must(template.New("receipt").Funcs(template.FuncMap{
+		"formatSize": formatSize,
+		"formatTime": func(t time.Time) string { return t.Format(time.RFC3339) },
+	}).Parse(receiptTemplate))
+	tmpl.Execute(w, page)
+}
+
+// fileSHA256 计算文件内容的 SHA-256 (十六进制)
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type receiptPage struct {
+	Lang       string
+	T          func(key string, args ...any) string
+	Name       string
+	Size       int64
+	Path       string
+	Downloaded bool             // 访问者已完整下载过该文件，可以签收
+	Exp, Sig   string           // 表单令牌
+	Receipt    *receipt.Receipt // 已签收时非空
+}
+
+const receiptTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{call .T "receipt_title"}} {{.Name}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        h1 {
+            margin: 0;
+            padding: 20px;
+            background: #059669;
+            color: white;
+            font-size: 18px;
+            font-weight: 500;
+        }
+        .body { padding: 20px; }
+        .meta { color: #6b7280; font-size: 14px; word-break: break-all; }
+        input[type=text] { width: 100%; padding: 8px; margin-top: 10px; }
+        button {
+            margin-top: 15px;
+            padding: 10px 20px;
+            background: #059669;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            font-size: 14px;
+            cursor: pointer;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>✔ {{call .T "receipt_title"}}</h1>
+        <div class="body">
+            <p><a href="{{.Path}}">📄 {{.Name}}</a> ({{formatSize .Size}})</p>
+            {{if .Receipt}}
+            <p>{{call .T "receipt_recorded"}}</p>
+            <div class="meta">
+                {{call .T "receipt_id"}}: {{.Receipt.ID}}<br>
+                {{call .T "receipt_time"}}: {{formatTime .Receipt.Time}}<br>
+                SHA-256: {{.Receipt.SHA256}}
+            </div>
+            {{else if .Downloaded}}
+            <p>{{call .T "receipt_prompt"}}</p>
+            <form method="post">
+                <input type="hidden" name="exp" value="{{.Exp}}">
+                <input type="hidden" name="sig" value="{{.Sig}}">
+                <input type="text" name="name" placeholder="{{call .T "receipt_name_hint"}}" maxlength="100">
+                <button type="submit">{{call .T "receipt_title"}}</button>
+            </form>
+            {{else}}
+            <p>{{call .T "receipt_download"}}</p>
+            {{end}}
+        </div>
+    </div>
+</body>
+</html>`
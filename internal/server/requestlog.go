@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+)
+
+// requestIDHeader 是响应中携带请求 ID 的头部。访问者报告问题时提供这个 ID，
+// 就能在 access.log 中找到对应的请求，再用同一行的 cf_ray 到 Cloudflare 一侧排查。
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// withRequestID 为请求分配 ID，保存在 context 中并通过响应头返回给访问者
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := auth.GenerateToken(16)
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// requestID 返回 loggingMiddleware 分配的请求 ID，未分配时为空
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// accessLogWriter 每次写入时打开 access.log 追加，cfshare stop 清理日志后自动重建文件
+type accessLogWriter struct{}
+
+func (accessLogWriter) Write(p []byte) (int, error) {
+	f, err := os.OpenFile(config.GetAccessLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return f.Write(p)
+}
+
+// accessLog 把请求记录写成 access.log 中的 JSON 行。
+// 不输出 level 和 msg，time 为秒精度的 UTC 时间，与事件行和旧版本日志格式一致。
+var accessLog = slog.New(slog.NewJSONHandler(accessLogWriter{}, &slog.HandlerOptions{
+	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) > 0 {
+			return a
+		}
+		switch a.Key {
+		case slog.LevelKey, slog.MessageKey:
+			return slog.Attr{}
+		case slog.TimeKey:
+			return slog.String(slog.TimeKey, a.Value.Time().UTC().Format(time.RFC3339))
+		}
+		return a
+	},
+}))
+
+// logRequest 以请求开始的时间写入一条请求记录
+func logRequest(start time.Time, attrs []slog.Attr) {
+	record := slog.NewRecord(start, slog.LevelInfo, "request", 0)
+	record.AddAttrs(attrs...)
+	accessLog.Handler().Handle(context.Background(), record)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cfshare/internal/state"
+)
+
+var (
+	errNotFound  = errors.New("not found")
+	errForbidden = errors.New("forbidden")
+)
+
+// resolve 把请求的 URL 路径映射为分享范围内的本地文件路径，
+// 与目录浏览使用相同的路径遍历和符号链接检查。
+func (s *Server) resolve(urlPath string) (string, error) {
+	if s.isFileRequest() || s.excludedURL(urlPath) {
+		return "", errNotFound
+	}
+
+	reqPath := strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+urlPath)), "/")
+
+	set := s.shares()
+	if !set.isMulti {
+		if set.shareType == state.TypeFile {
+			if reqPath == "" || reqPath == filepath.Base(set.sharePath) {
+				return set.sharePath, nil
+			}
+			return "", errNotFound
+		}
+		return resolveInDir(set.sharePath, reqPath)
+	}
+
+	if reqPath == "" {
+		return "", errNotFound
+	}
+	parts := strings.SplitN(reqPath, "/", 2)
+	item, ok := set.itemMap[parts[0]]
+	if !ok {
+		return "", errNotFound
+	}
+	subPath := ""
+	if len(parts) > 1 {
+		subPath = parts[1]
+	}
+	if item.ShareType == state.TypeFile {
+		if subPath != "" {
+			return "", errNotFound
+		}
+		return item.Path, nil
+	}
+	return resolveInDir(item.Path, subPath)
+}
+
+// resolveInDir 解析 basePath 下的子路径，拒绝逃逸出 basePath 的路径
+func resolveInDir(basePath, subPath string) (string, error) {
+	cleanSub := filepath.Clean(filepath.FromSlash(subPath))
+	if cleanSub == "." {
+		cleanSub = ""
+	}
+	if strings.HasPrefix(cleanSub, "..") {
+		return "", errForbidden
+	}
+
+	realBasePath, err := filepath.EvalSymlinks(basePath)
+	if err != nil {
+		realBasePath = basePath
+	}
+
+	fullPath := filepath.Join(realBasePath, cleanSub)
+	if !isWithin(fullPath, realBasePath) {
+		return "", errForbidden
+	}
+
+	realFullPath, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errNotFound
+		}
+		return "", err
+	}
+	if !isWithin(realFullPath, realBasePath) {
+		return "", errForbidden
+	}
+	return fullPath, nil
+}
+
+// writeResolveError 把 resolve 的错误转换为 HTTP 响应
+func writeResolveError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case errNotFound:
+		http.NotFound(w, r)
+	case errForbidden:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// clientIP 返回访问者 IP，优先使用 Cloudflare 注入的 CF-Connecting-IP
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
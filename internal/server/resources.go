@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/notify"
+	"cfshare/internal/state"
+)
+
+// resourceLimits 是资源采样的警告阈值，为 0 时不检查
+type resourceLimits struct {
+	heap       uint64
+	goroutines int
+	fds        int
+}
+
+var defaultResourceLimits = resourceLimits{
+	heap:       config.ResourceMaxHeap,
+	goroutines: config.ResourceMaxGoroutines,
+	fds:        config.ResourceMaxFDs,
+}
+
+// sampleResources 采样当前进程的内存、goroutine 数和打开的文件描述符数
+func sampleResources() state.Resources {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return state.Resources{
+		Time:       time.Now(),
+		HeapBytes:  m.HeapAlloc,
+		SysBytes:   m.Sys,
+		Goroutines: runtime.NumGoroutine(),
+		OpenFDs:    countOpenFDs(),
+	}
+}
+
+// countOpenFDs 返回打开的文件描述符数，不支持的平台 (Windows) 返回 -1
+func countOpenFDs() int {
+	dir := "/proc/self/fd"
+	if runtime.GOOS != "linux" {
+		dir = "/dev/fd"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return -1
+	}
+	return len(entries) - 1 // 不计 ReadDir 自己打开的目录
+}
+
+// exceeded 返回超过阈值的项目 (heap、goroutines、fds) 及说明
+func (l resourceLimits) exceeded(r state.Resources) map[string]string {
+	over := make(map[string]string)
+	if l.heap > 0 && r.HeapBytes > l.heap {
+		over["heap"] = fmt.Sprintf("堆内存 %d MB 超过阈值 %d MB", r.HeapBytes>>20, l.heap>>20)
+	}
+	if l.goroutines > 0 && r.Goroutines > l.goroutines {
+		over["goroutines"] = fmt.Sprintf("goroutine 数 %d 超过阈值 %d", r.Goroutines, l.goroutines)
+	}
+	if l.fds > 0 && r.OpenFDs > l.fds {
+		over["fds"] = fmt.Sprintf("打开的文件数 %d 超过阈值 %d", r.OpenFDs, l.fds)
+	}
+	return over
+}
+
+// resourceWatch 保存最近一次采样和当前处于警告状态的项目
+type resourceWatch struct {
+	mu     sync.Mutex
+	last   state.Resources
+	warned map[string]bool
+}
+
+var (
+	resourcesOnce sync.Once
+	resourcesVar  = &resourceWatch{warned: make(map[string]bool)}
+)
+
+// publishResources 把最近一次采样以 "cfshare_resources" 发布到 expvar
+func publishResources() {
+	resourcesOnce.Do(func() {
+		expvar.Publish("cfshare_resources", expvar.Func(func() any {
+			resourcesVar.mu.Lock()
+			defer resourcesVar.mu.Unlock()
+			return resourcesVar.last
+		}))
+	})
+}
+
+// record 保存一次采样，返回带上警告的采样和新超过阈值的项目 (同一项目在回落到阈值以下之前只警告一次)
+func (w *resourceWatch) record(r state.Resources, limits resourceLimits) (state.Resources, []string) {
+	over := limits.exceeded(r)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var fresh []string
+	for _, kind := range []string{"heap", "goroutines", "fds"} {
+		msg, ok := over[kind]
+		if ok {
+			r.Warnings = append(r.Warnings, msg)
+			if !w.warned[kind] {
+				fresh = append(fresh, msg)
+			}
+		}
+		w.warned[kind] = ok
+	}
+	w.last = r
+	return r, fresh
+}
+
+// WatchResources 每隔 config.ResourceInterval 采样服务进程自身的资源占用，直到 ctx 结束。
+// 采样结果写入 resources.json (cfshare status 显示) 并发布到 expvar，超过阈值时写入警告。
+func (s *Server) WatchResources(ctx context.Context) {
+	publishResources()
+
+	ticker := time.NewTicker(config.ResourceInterval)
+	defer ticker.Stop()
+	for {
+		r, fresh := resourcesVar.record(sampleResources(), defaultResourceLimits)
+		for _, msg := range fresh {
+			s.resourceWarning(msg)
+		}
+		state.SaveResources(&r)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resourceWarning 把资源超限写入访问日志和服务日志，设置了 --notify 时推送
+func (s *Server) resourceWarning(message string) {
+	logData, _ := json.Marshal(map[string]interface{}{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"event":   "resources",
+		"message": message,
+	})
+	appendToAccessLog(string(logData))
+	fmt.Fprintf(os.Stderr, "[resources] %s\n", message)
+
+	if s.state.NotifyURL != "" {
+		go notify.Text(s.state.NotifyURL, "⚠️ cfshare: "+message)
+	}
+}
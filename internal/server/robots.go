@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+)
+
+const robotsPath = "/robots.txt"
+
+// robotsTxt 禁止所有爬虫抓取分享内容
+const robotsTxt = "User-agent: *\nDisallow: /\n"
+
+// robotsMiddleware 防止分享被搜索引擎收录: 所有响应 (包括 401 和错误页) 都带
+// X-Robots-Tag: noindex，/robots.txt 不经认证直接返回 Disallow。
+// 分享时指定 --allow-indexing 则不做任何处理。
+func (s *Server) robotsMiddleware(next http.Handler) http.Handler {
+	if s.state.AllowIndexing {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive")
+
+		if r.URL.Path == robotsPath && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(robotsTxt))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
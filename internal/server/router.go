@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+// routesTTL 是路由进程缓存分享登记的时间，新分享启动后最多这么久开始收到请求
+const routesTTL = 2 * time.Second
+
+// router 是路由进程 (--router) 的处理程序。cloudflared 只指向路由进程的端口，
+// 路由进程按主机名和挂载前缀把请求原样转发给对应分享的服务进程，
+// 挂载前缀由分享自己去掉，CF-Connecting-IP、X-Forwarded-Proto 等头部保持不变。
+type router struct {
+	load func() []state.Route
+
+	mu     sync.Mutex
+	routes []state.Route
+	at     time.Time
+}
+
+// RouterHandler 返回路由进程的处理程序，load 返回当前正在运行的分享登记的路由
+func RouterHandler(load func() []state.Route) http.Handler {
+	return &router{load: load}
+}
+
+func (rt *router) current() []state.Route {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if time.Since(rt.at) > routesTTL {
+		rt.routes = rt.load()
+		rt.at = time.Now()
+	}
+	return rt.routes
+}
+
+// match 选出处理请求的分享：主机名有登记时只在该主机名的分享中选，
+// 否则忽略主机名；再取挂载前缀最长的一个，默认分享 (无前缀) 接收其余路径
+func (rt *router) match(host, path string) (state.Route, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	routes := rt.current()
+	var candidates []state.Route
+	for _, r := range routes {
+		if strings.EqualFold(r.Host, host) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = routes
+	}
+
+	var best state.Route
+	found := false
+	for _, r := range candidates {
+		if r.Mount != "" && path != r.Mount && !strings.HasPrefix(path, r.Mount+"/") {
+			continue
+		}
+		if !found || len(r.Mount) > len(best.Mount) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, ok := rt.match(r.Host, r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	target := fmt.Sprintf("127.0.0.1:%d", route.Port)
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = target
+		},
+		// 下载和流式分享边收边转发，不在路由进程中缓冲
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			fmt.Fprintf(os.Stderr, "[router] %s -> %s: %v\n", req.URL.Path, target, err)
+			http.Error(w, "Share unavailable", http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+const (
+	searchPath = "/search"
+
+	// maxSearchResults 是一次搜索返回的最多结果数
+	maxSearchResults = 200
+	// searchTimeout 限制遍历大目录的时间
+	searchTimeout = 5 * time.Second
+)
+
+// isSearchRequest 判断是否为搜索请求。分享中真实存在名为 search 的项目时不拦截。
+func (s *Server) isSearchRequest(r *http.Request) bool {
+	if r.URL.Path != searchPath || !r.URL.Query().Has("q") {
+		return false
+	}
+	_, err := s.resolve(r.URL.Path)
+	return err != nil
+}
+
+// handleSearch 在所有分享项中按文件名搜索 (/search?q=)，多个关键词需同时匹配
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	terms := strings.Fields(strings.ToLower(query))
+
+	var results []FileInfo
+	if len(terms) > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), searchTimeout)
+		defer cancel()
+		results = s.search(ctx, terms)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].IsDir != results[j].IsDir {
+			return results[i].IsDir
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	s.renderDir(w, r, dirPage{
+		Path:   "/",
+		Files:  results,
+		Parent: "/",
+		Query:  query,
+	})
+}
+
+// search 遍历分享项，返回相对路径包含所有关键词的文件和目录
+func (s *Server) search(ctx context.Context, terms []string) []FileInfo {
+	var results []FileInfo
+	matches := func(rel string) bool {
+		lower := strings.ToLower(rel)
+		for _, t := range terms {
+			if !strings.Contains(lower, t) {
+				return false
+			}
+		}
+		return true
+	}
+
+	set := s.shares()
+	for _, item := range s.visibleItems(ctx) {
+		// 单路径模式下 URL 不包含分享项名称
+		prefix := "/" + item.Name
+		if !set.isMulti {
+			prefix = ""
+		}
+
+		if item.ShareType == state.TypeFile {
+			if matches(item.Name) {
+				if info, err := os.Stat(item.Path); err == nil {
+					results = append(results, FileInfo{
+						Name:     item.Name,
+						Size:     info.Size(),
+						ModTime:  info.ModTime(),
+						Path:     "/" + item.Name,
+						diskPath: item.Path,
+					})
+				}
+			}
+			continue
+		}
+
+		realBase, err := filepath.EvalSymlinks(item.Path)
+		if err != nil {
+			continue
+		}
+		filepath.WalkDir(realBase, func(path string, d fs.DirEntry, err error) error {
+			if ctx.Err() != nil || len(results) >= maxSearchResults {
+				return fs.SkipAll
+			}
+			if err != nil {
+				if d != nil && d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if path == realBase {
+				return nil
+			}
+			// 不跟随符号链接，避免逃逸出分享目录
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+
+			rel, err := filepath.Rel(realBase, path)
+			if err != nil {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if s.filter.excluded(rel) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			name := rel
+			if set.isMulti {
+				name = item.Name + "/" + rel
+			}
+			if !matches(name) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			fi := FileInfo{
+				Name:    name,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+				IsDir:   d.IsDir(),
+				Path:    prefix + "/" + rel,
+			}
+			if fi.IsDir {
+				fi.Path += "/"
+			} else if info.Mode().IsRegular() {
+				fi.diskPath = path
+			}
+			results = append(results, fi)
+			return nil
+		})
+	}
+	return results
+}
@@ -1,25 +1,33 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cfshare/internal/auth"
 	"cfshare/internal/config"
+	"cfshare/internal/health"
+	"cfshare/internal/i18n"
 	"cfshare/internal/state"
 )
 
-type Server struct {
+// shareSet 是一组分享项，热重载时整体替换
+type shareSet struct {
 	// 多路径支持
 	items   []state.ShareItem
 	itemMap map[string]*state.ShareItem // 名称->项映射
@@ -28,13 +36,89 @@ type Server struct {
 	// 单文件兼容
 	sharePath string
 	shareType state.ShareType
+}
 
-	state   *state.State
-	stateMu sync.Mutex
-	srv     *http.Server
+type Server struct {
+	set atomic.Pointer[shareSet] // 当前的分享项，通过 shares() 读取
+
+	state     *state.State
+	stateMu   sync.Mutex
+	uploading map[string]int64 // 正在写入的上传文件 -> 预留的字节数，由 stateMu 保护
+	srv       *http.Server
+
+	bans      *banList        // 临时封禁的 IP
+	attempts  *requestLimiter // 每个 IP 口令错误的速度 (config.AuthAttemptRate)
+	transfers *transferLog    // 完整下载的记录，确认收到 (--receipts) 时核对
+	formKey   string          // 本进程签发表单令牌 (确认收到) 的随机密钥
+	checksums *checksumCache  // 分享文件的 SHA-256
+	dirSizes  *dirSizeCache   // 目录的递归大小
+	templates *templateLoader // ~/.cfshare/templates 下的覆盖模板
+	filter    *pathFilter     // 隐藏文件和 --exclude
+	stream    *streamSource   // 流分享的数据源，只能读取一次
+	coverage  *rangeCoverage  // 分段下载已发送的字节区间
+	downloads *downloadCounter
+	burn      *burnTracker // 一次性链接正在进行中的下载
+	expiry    *expiryTimer // --expire 的结束时间，可由 reload 延长
+	metrics   *shareMetrics
+	versions  *versionTracker // --watch 记录的分享文件版本
+	users     *auth.UserTable // cfshare user add 添加的用户
+	ipRules   *ipFilter       // --allow-ip / --block-ip，没有规则时为 nil
+	geoRules  *geoFilter      // --allow-country / --block-country，没有规则时为 nil
+	stats     *statsRecorder  // 聚合统计和原始访问记录
+
+	healthMu sync.Mutex
+	health   state.Health // WatchEdge 和 WatchTunnel 共同写入的 health.json
 }
 
 func NewServer(paths []string, st *state.State) (*Server, error) {
+	set, err := newShareSet(paths, st.StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	st.Items = set.items
+	st.IsMulti = set.isMulti
+	if !set.isMulti {
+		// 单路径: 保持向后兼容
+		st.Path = set.sharePath
+		st.ShareType = set.shareType
+	}
+
+	// 服务进程只用 state.json 中的令牌哈希校验令牌，直接构造的状态还没有哈希时补上
+	st.HashTokens()
+
+	filter := newPathFilter(st.Exclude, st.ShowHidden)
+	s := &Server{
+		state:     st,
+		bans:      newBanList(),
+		attempts:  newRequestLimiter(config.AuthAttemptRate),
+		transfers: newTransferLog(),
+		uploading: make(map[string]int64),
+		formKey:   auth.GenerateToken(32),
+		checksums: newChecksumCache(),
+		dirSizes:  newDirSizeCache(filter),
+		templates: newTemplateLoader(config.GetTemplatesDir()),
+		filter:    filter,
+		downloads: newDownloadCounter(),
+		burn:      newBurnTracker(),
+		coverage:  newRangeCoverage(),
+		expiry:    newExpiryTimer(st.StopAt),
+		metrics:   newShareMetrics(),
+		versions:  newVersionTracker(),
+		users:     auth.NewUserTable(st.Users),
+		ipRules:   newIPFilter(st.AllowIPs, st.BlockIPs),
+		geoRules:  newGeoFilter(st.AllowCountries, st.BlockCountries),
+		stats:     newStatsRecorder(st),
+	}
+	s.set.Store(set)
+	if set.shareType == state.TypeStream {
+		s.stream = newStreamSource(set.sharePath)
+	}
+	return s, nil
+}
+
+// newShareSet 检查路径并构建分享项
+func newShareSet(paths []string, streamName string) (*shareSet, error) {
 	if len(paths) == 0 {
 		return nil, fmt.Errorf("no paths provided")
 	}
@@ -42,6 +126,14 @@ func NewServer(paths []string, st *state.State) (*Server, error) {
 	var items []state.ShareItem
 
 	for _, p := range paths {
+		if IsStreamPath(p) {
+			if len(paths) > 1 {
+				return nil, fmt.Errorf("a stream must be the only shared path")
+			}
+			items = append(items, StreamItem(p, streamName))
+			continue
+		}
+
 		absPath, err := filepath.Abs(p)
 		if err != nil {
 			return nil, fmt.Errorf("invalid path %s: %w", p, err)
@@ -74,35 +166,50 @@ func NewServer(paths []string, st *state.State) (*Server, error) {
 		return nil, err
 	}
 
-	// 单路径: 保持向后兼容
 	if len(items) == 1 {
-		st.Items = items
-		st.Path = items[0].Path
-		st.ShareType = items[0].ShareType
-		st.IsMulti = false
-
-		return &Server{
+		return &shareSet{
 			sharePath: items[0].Path,
 			shareType: items[0].ShareType,
 			items:     items,
 			itemMap:   itemMap,
 			isMulti:   false,
-			state:     st,
 		}, nil
 	}
 
 	// 多路径
-	st.Items = items
-	st.IsMulti = true
-
-	return &Server{
+	return &shareSet{
 		items:   items,
 		itemMap: itemMap,
 		isMulti: true,
-		state:   st,
 	}, nil
 }
 
+// shares 返回当前的分享项。cfshare add/remove 热重载时整体替换，
+// 同一请求内需要一致的视图时应只调用一次
+func (s *Server) shares() *shareSet {
+	return s.set.Load()
+}
+
+// Reload 用新的路径替换分享项，进行中的传输不受影响 (cfshare add/remove)。
+// 流分享不能重载。
+func (s *Server) Reload(paths []string) error {
+	if s.stream != nil {
+		return fmt.Errorf("a stream share cannot be reloaded")
+	}
+	set, err := newShareSet(paths, "")
+	if err != nil {
+		return err
+	}
+	for _, item := range set.items {
+		if item.ShareType == state.TypeStream {
+			return fmt.Errorf("cannot add a stream to a running share")
+		}
+	}
+	s.set.Store(set)
+	s.warmDirSizes()
+	return nil
+}
+
 // buildItemMap 构建名称到项的映射，检测名称冲突
 func buildItemMap(items []state.ShareItem) (map[string]*state.ShareItem, error) {
 	result := make(map[string]*state.ShareItem)
@@ -118,27 +225,88 @@ func buildItemMap(items []state.ShareItem) (map[string]*state.ShareItem, error)
 	return result, nil
 }
 
-func (s *Server) Start(port int, username, password string) error {
+func (s *Server) Start(port int, username, passwordHash string) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln, username, passwordHash)
+}
+
+// Serve 在已有的 listener 上提供服务，cfshare upgrade --inplace 时新旧进程共用同一端口。
+// passwordHash 是分享口令的哈希 (auth.HashPassword)，服务进程不接触口令明文。
+func (s *Server) Serve(ln net.Listener, username, passwordHash string) error {
 	mux := http.NewServeMux()
 
 	var handler http.Handler = http.HandlerFunc(s.handleRequest)
+	handler = s.askNameMiddleware(handler)
+
+	if s.state.TermsPath != "" {
+		terms, err := os.ReadFile(s.state.TermsPath)
+		if err != nil {
+			return fmt.Errorf("read terms: %w", err)
+		}
+		handler = s.termsMiddleware(string(terms), handler)
+	}
+
+	handler = s.userMiddleware(handler)
+	handler = s.rateLimitMiddleware(handler)
+	handler = s.deadlineMiddleware(handler)
+	handler = s.concurrencyMiddleware(handler)
+	handler = s.waitingRoomMiddleware(handler)
+	handler = s.downloadLimitMiddleware(handler)
+	handler = s.burnMiddleware(handler)
+	handler = s.serveWindowMiddleware(handler)
+	handler = s.approveMiddleware(handler)
 	handler = s.loggingMiddleware(handler)
+	inner := handler
 
-	if username != "" && password != "" {
-		handler = auth.BasicAuthMiddleware(username, password, handler)
+	if username != "" && passwordHash != "" {
+		var authed http.Handler
+		if s.state.Auth == state.AuthForm {
+			authed = auth.FormAuthMiddleware(s.formAuth(username, passwordHash), handler)
+		} else {
+			// 401 页面由 errorPageMiddleware 渲染
+			authed = auth.BasicAuthWithHash(username, passwordHash, s.users, auth.Challenge{Realm: s.state.Realm}, handler)
+		}
+		authed = s.authFailureMiddleware(authed)
+		handler = s.signedLinkMiddleware(authed, handler)
 	}
 
+	handler = s.requestRateMiddleware(handler)
+	handler = s.banMiddleware(handler)
+	handler = s.ipFilterMiddleware(handler)
+	handler = s.geoFilterMiddleware(handler)
+	handler = s.expiryMiddleware(handler)
+	handler = s.ownerMiddleware(handler, inner)
+	handler = s.errorPageMiddleware(handler)
+	handler = s.robotsMiddleware(handler)
+
+	s.warmChecksums()
+	s.warmDirSizes()
+
 	mux.Handle("/", handler)
+	s.healthHandlers(mux)
+	if s.state.StatusPage {
+		mux.HandleFunc(statusPath, s.handleStatusPage)
+	}
+	mux.HandleFunc(cachePath, s.handleCache)
+	mux.HandleFunc(speedtestPath, s.handleSpeedtest)
 
 	s.srv = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Handler: s.mountMiddleware(mux),
+		// 请求头必须在限定时间内发完，空闲的 keep-alive 连接也会被关闭，
+		// 避免慢速客户端 (slowloris) 长期占用连接
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		IdleTimeout:       config.IdleTimeout,
 	}
 
-	return s.srv.ListenAndServe()
+	return s.srv.Serve(ln)
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	// 请求处理完后写入缓冲区中剩余的访问记录
+	defer s.stats.flush()
 	if s.srv != nil {
 		return s.srv.Shutdown(ctx)
 	}
@@ -146,11 +314,77 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Cache-Control", "no-store")
+	// 允许浏览器保存但每次使用前必须验证 (ETag / Last-Modified)，
+	// 未变化的内容只需返回 304，不再经过 tunnel 重新下载
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if s.isFileRequest() {
+		s.handleFileRequest(w, r)
+		return
+	}
+
+	if s.shares().shareType == state.TypeStream {
+		s.serveStream(w, r)
+		return
+	}
+
+	if s.excludedURL(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("thumb") == "1" {
+		s.handleThumb(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("qr") == "1" {
+		s.handleQR(w, r)
+		return
+	}
+
+	if s.state.Receipts && r.URL.Query().Get("receipt") == "1" {
+		s.handleReceipt(w, r)
+		return
+	}
 
-	if !s.isMulti {
+	if !s.state.NoStream && isMediaRequest(r) {
+		s.handleMedia(w, r)
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".sha256") && s.handleChecksum(w, r) {
+		return
+	}
+
+	if s.isGetScriptRequest(r) {
+		s.handleGetScript(w, r)
+		return
+	}
+
+	if s.isSearchRequest(r) {
+		s.handleSearch(w, r)
+		return
+	}
+
+	if s.state.Watch && r.URL.Path == feedPath {
+		s.handleFeed(w, r)
+		return
+	}
+
+	if s.isPasteRequest(r) {
+		s.handlePaste(w, r)
+		return
+	}
+
+	if isMarkdownRequest(r) {
+		s.handleMarkdown(w, r)
+		return
+	}
+
+	if set := s.shares(); !set.isMulti {
 		// 向后兼容: 单路径模式
-		if s.shareType == state.TypeFile {
+		if set.shareType == state.TypeFile {
 			s.serveFile(w, r)
 		} else {
 			s.serveDir(w, r)
@@ -167,7 +401,12 @@ func (s *Server) handleMultiShare(w http.ResponseWriter, r *http.Request) {
 	reqPath := strings.TrimPrefix(filepath.Clean(r.URL.Path), "/")
 
 	// 根路径: 显示虚拟目录列表
+	set := s.shares()
 	if reqPath == "/" || reqPath == "." || reqPath == "" {
+		if format, ok := archiveFormatFromQuery(r); ok {
+			serveItemsArchive(w, r, s.visibleItems(r.Context()), format, s.filter.excluded)
+			return
+		}
 		s.listVirtualRoot(w, r)
 		return
 	}
@@ -182,7 +421,7 @@ func (s *Server) handleMultiShare(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 查找分享项
-	item, ok := s.itemMap[itemName]
+	item, ok := set.itemMap[itemName]
 	if !ok {
 		http.NotFound(w, r)
 		return
@@ -195,8 +434,7 @@ func (s *Server) handleMultiShare(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 			return
 		}
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, item.Name))
-		http.ServeFile(w, r, item.Path)
+		s.download(w, r, item.Path, item.Name)
 	} else {
 		// 目录: 使用基于项的目录浏览
 		s.serveDirWithBase(w, r, item.Path, "/"+itemName, subPath)
@@ -207,7 +445,7 @@ func (s *Server) handleMultiShare(w http.ResponseWriter, r *http.Request) {
 func (s *Server) listVirtualRoot(w http.ResponseWriter, r *http.Request) {
 	var files []FileInfo
 
-	for _, item := range s.items {
+	for _, item := range s.visibleItems(r.Context()) {
 		fi := FileInfo{
 			Name:  item.Name,
 			Size:  item.Size,
@@ -220,6 +458,12 @@ func (s *Server) listVirtualRoot(w http.ResponseWriter, r *http.Request) {
 		// 获取真实的修改时间
 		if info, err := os.Stat(item.Path); err == nil {
 			fi.ModTime = info.ModTime()
+			if info.Mode().IsRegular() {
+				fi.Size = info.Size()
+				fi.diskPath = item.Path
+			} else if fi.IsDir {
+				fi.diskPath = item.Path
+			}
 		} else {
 			fi.ModTime = time.Now()
 		}
@@ -234,24 +478,11 @@ func (s *Server) listVirtualRoot(w http.ResponseWriter, r *http.Request) {
 		return files[i].Name < files[j].Name
 	})
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	tmpl := template.Must(template.New("dir").Funcs(template.FuncMap{
-		"formatSize": formatSize,
-		"formatTime": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
-	}).Parse(dirTemplate))
-
-	data := struct {
-		Path   string
-		Files  []FileInfo
-		Parent string
-	}{
-		Path:   "/",
-		Files:  files,
-		Parent: "",
-	}
-
-	tmpl.Execute(w, data)
+	s.renderDir(w, r, dirPage{
+		Path:       "/",
+		Files:      files,
+		ArchiveURL: "/",
+	})
 }
 
 // serveDirWithBase 处理多文件模式下的目录浏览
@@ -301,15 +532,27 @@ func (s *Server) serveDirWithBase(w http.ResponseWriter, r *http.Request, basePa
 	}
 
 	if info.IsDir() {
+		if isSplitRequest(r) {
+			s.serveSplitArchive(w, r, fullPath, s.skipUnder(r.URL.Path))
+			return
+		}
+		if format, ok := archiveFormatFromQuery(r); ok {
+			serveDirArchive(w, r, fullPath, format, s.skipUnder(r.URL.Path))
+			return
+		}
 		s.listDirectoryWithBase(w, r, fullPath, urlPrefix, subPath)
 	} else {
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(fullPath)))
-		http.ServeFile(w, r, fullPath)
+		s.download(w, r, fullPath, filepath.Base(fullPath))
 	}
 }
 
 // listDirectoryWithBase 列出目录内容（多文件模式）
 func (s *Server) listDirectoryWithBase(w http.ResponseWriter, r *http.Request, fullPath, urlPrefix, subPath string) {
+	dirInfo, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -323,6 +566,9 @@ func (s *Server) listDirectoryWithBase(w http.ResponseWriter, r *http.Request, f
 	}
 
 	for _, entry := range entries {
+		if s.filter.excluded(path.Join(subPath, entry.Name())) {
+			continue
+		}
 		info, err := entry.Info()
 		if err != nil {
 			continue
@@ -333,13 +579,17 @@ func (s *Server) listDirectoryWithBase(w http.ResponseWriter, r *http.Request, f
 			entryPath += "/"
 		}
 
-		files = append(files, FileInfo{
+		fi := FileInfo{
 			Name:    entry.Name(),
 			Size:    info.Size(),
 			ModTime: info.ModTime(),
 			IsDir:   entry.IsDir(),
 			Path:    entryPath,
-		})
+		}
+		if info.Mode().IsRegular() || entry.IsDir() {
+			fi.diskPath = filepath.Join(fullPath, entry.Name())
+		}
+		files = append(files, fi)
 	}
 
 	sort.Slice(files, func(i, j int) bool {
@@ -349,13 +599,6 @@ func (s *Server) listDirectoryWithBase(w http.ResponseWriter, r *http.Request, f
 		return files[i].Name < files[j].Name
 	})
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	tmpl := template.Must(template.New("dir").Funcs(template.FuncMap{
-		"formatSize": formatSize,
-		"formatTime": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
-	}).Parse(dirTemplate))
-
 	// 计算父目录
 	parent := "/"
 	if subPath != "" {
@@ -370,30 +613,28 @@ func (s *Server) listDirectoryWithBase(w http.ResponseWriter, r *http.Request, f
 		displayPath += "/"
 	}
 
-	data := struct {
-		Path   string
-		Files  []FileInfo
-		Parent string
-	}{
-		Path:   displayPath,
-		Files:  files,
-		Parent: parent,
-	}
-
-	tmpl.Execute(w, data)
+	s.renderDir(w, r, dirPage{
+		Path:       displayPath,
+		Files:      files,
+		Parent:     parent,
+		ArchiveURL: currentPath,
+		Readme:     readmeHTML(fullPath),
+		Split:      true,
+		modTime:    latestModTime(dirInfo.ModTime(), files),
+	})
 }
 
 func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
 	reqPath := r.URL.Path
-	fileName := filepath.Base(s.sharePath)
+	set := s.shares()
+	fileName := filepath.Base(set.sharePath)
 
 	if reqPath != "/" && reqPath != "/"+fileName {
 		http.NotFound(w, r)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
-	http.ServeFile(w, r, s.sharePath)
+	s.download(w, r, set.sharePath, fileName)
 }
 
 func (s *Server) serveDir(w http.ResponseWriter, r *http.Request) {
@@ -403,15 +644,16 @@ func (s *Server) serveDir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fullPath := filepath.Join(s.sharePath, reqPath)
+	set := s.shares()
+	fullPath := filepath.Join(set.sharePath, reqPath)
 
-	if !strings.HasPrefix(fullPath, s.sharePath) {
+	if !strings.HasPrefix(fullPath, set.sharePath) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
 	realPath, err := filepath.EvalSymlinks(fullPath)
-	if err == nil && !strings.HasPrefix(realPath, s.sharePath) {
+	if err == nil && !strings.HasPrefix(realPath, set.sharePath) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -427,22 +669,43 @@ func (s *Server) serveDir(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if info.IsDir() {
+		if isSplitRequest(r) {
+			s.serveSplitArchive(w, r, fullPath, s.skipUnder(r.URL.Path))
+			return
+		}
+		if format, ok := archiveFormatFromQuery(r); ok {
+			serveDirArchive(w, r, fullPath, format, s.skipUnder(r.URL.Path))
+			return
+		}
 		s.listDirectory(w, r, fullPath, reqPath)
 	} else {
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(fullPath)))
-		http.ServeFile(w, r, fullPath)
+		s.download(w, r, fullPath, filepath.Base(fullPath))
 	}
 }
 
 type FileInfo struct {
-	Name    string
-	Size    int64
-	ModTime time.Time
-	IsDir   bool
-	Path    string
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	IsDir    bool      `json:"is_dir"`
+	Path     string    `json:"path"`
+	Checksum string    `json:"sha256,omitempty"` // SHA-256，后台计算完成前为空
+	// 目录的 Size 是后台统计的递归大小，统计完成前 SizePending 为 true，Size 是目前已统计的部分
+	SizePending bool   `json:"size_pending,omitempty"`
+	URL         string `json:"url,omitempty"` // 完整的公开链接，仅 JSON 列表填充
+	// --watch 监视的顶层分享文件的版本号和最近一次内容变化的时间，版本 1 表示分享后未改变过
+	Version   int       `json:"version,omitempty"`
+	UpdatedAt time.Time `json:"-"`
+
+	diskPath string // 本地路径，仅普通文件和目录填充，用于计算校验值和目录大小
 }
 
 func (s *Server) listDirectory(w http.ResponseWriter, r *http.Request, fullPath, reqPath string) {
+	dirInfo, err := os.Stat(fullPath)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -451,6 +714,9 @@ func (s *Server) listDirectory(w http.ResponseWriter, r *http.Request, fullPath,
 
 	var files []FileInfo
 	for _, entry := range entries {
+		if s.filter.excluded(path.Join(reqPath, entry.Name())) {
+			continue
+		}
 		info, err := entry.Info()
 		if err != nil {
 			continue
@@ -461,13 +727,17 @@ func (s *Server) listDirectory(w http.ResponseWriter, r *http.Request, fullPath,
 			entryPath += "/"
 		}
 
-		files = append(files, FileInfo{
+		fi := FileInfo{
 			Name:    entry.Name(),
 			Size:    info.Size(),
 			ModTime: info.ModTime(),
 			IsDir:   entry.IsDir(),
 			Path:    entryPath,
-		})
+		}
+		if info.Mode().IsRegular() || entry.IsDir() {
+			fi.diskPath = filepath.Join(fullPath, entry.Name())
+		}
+		files = append(files, fi)
 	}
 
 	sort.Slice(files, func(i, j int) bool {
@@ -477,24 +747,126 @@ func (s *Server) listDirectory(w http.ResponseWriter, r *http.Request, fullPath,
 		return files[i].Name < files[j].Name
 	})
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.renderDir(w, r, dirPage{
+		Path:       reqPath,
+		Files:      files,
+		Parent:     filepath.Dir(strings.TrimSuffix(reqPath, "/")),
+		ArchiveURL: "/" + reqPath,
+		Readme:     readmeHTML(fullPath),
+		Split:      true,
+		modTime:    latestModTime(dirInfo.ModTime(), files),
+	})
+}
 
-	tmpl := template.Must(template.New("dir").Funcs(template.FuncMap{
-		"formatSize": formatSize,
-		"formatTime": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
-	}).Parse(dirTemplate))
+// dirPage 是目录列表模板的数据
+type dirPage struct {
+	Path       string
+	Files      []FileInfo
+	Parent     string
+	ArchiveURL string // 打包下载的链接 (不含查询参数)，为空时不显示
+	Split      bool   // 是否提供分卷下载 (?zip=split)
+
+	Receipts bool // 是否显示 "确认收到" 链接
+	Stream   bool // 是否为媒体文件显示 "播放" 链接
+
+	Gallery          bool // 以缩略图网格显示图片
+	GalleryAvailable bool // 目录中有图片，可以切换图库视图
+
+	Readme   template.HTML // 目录中 README.md 渲染后的内容
+	ThemeCSS template.CSS  // --theme 选择的主题样式，追加在内置样式之后
+	Query    string        // 非空时为搜索结果页
+	BaseURL  string        // 分享的公开地址，用于生成可复制的完整链接
+	Root     string        // 挂载的路径前缀 (--mount)，页面中的站内链接以它开头
+
+	Title   string // --title，为空时显示路径
+	Message string // --message，显示在列表上方
+	Footer  string // --footer，显示在页面底部
+	Logout  string // --auth form 时的退出登录链接
+
+	Lang  string // 页面语言
+	Sort  string // 排序字段 (name / size / mtime)
+	Desc  bool   // 是否降序
+	Page  int    // 当前页码，从 1 开始
+	Pages int    // 总页数
+	Total int    // 分页前的条目总数
+
+	modTime   time.Time       // 用于 Last-Modified，为零时不发送
+	params    url.Values      // 请求的查询参数，用于生成排序和翻页链接
+	languages []i18n.Language // 语言切换链接
+}
 
-	data := struct {
-		Path   string
-		Files  []FileInfo
-		Parent string
-	}{
-		Path:   reqPath,
-		Files:  files,
-		Parent: filepath.Dir(strings.TrimSuffix(reqPath, "/")),
+func (s *Server) renderDir(w http.ResponseWriter, r *http.Request, page dirPage) {
+	q := r.URL.Query()
+	page.params = q
+	page.Sort, page.Desc = listingSort(q)
+	s.fillDirSizes(page.Files)
+	// 未指定排序时保留调用方的顺序（目录在前按名称，搜索结果按路径）
+	if q.Has("sort") || q.Has("order") {
+		sortFiles(page.Files, page.Sort, page.Desc)
+	}
+	if page.Query == "" {
+		page.Gallery, page.GalleryAvailable = useGallery(r, page.Files)
 	}
 
-	tmpl.Execute(w, data)
+	// HTML 总是分页；JSON 默认返回完整列表便于脚本处理，指定 ?page= 时才分页
+	page.Total = len(page.Files)
+	asJSON := wantsJSON(r)
+	if !asJSON || q.Has("page") {
+		page.paginate(listingPage(q))
+	}
+	s.fillChecksums(page.Files)
+	s.fillVersions(page.Files)
+	// 公开地址包含挂载前缀，而页面中的路径已经带上前缀
+	page.BaseURL = strings.TrimSuffix(s.baseURL(r), s.root())
+	page.Root = s.root()
+	if page.Root != "" {
+		for i := range page.Files {
+			page.Files[i].Path = s.mounted(page.Files[i].Path)
+		}
+		// 相对路径 (如根目录的 ".") 不需要前缀
+		if strings.HasPrefix(page.Parent, "/") {
+			page.Parent = s.mounted(page.Parent)
+		}
+		if strings.HasPrefix(page.ArchiveURL, "/") {
+			page.ArchiveURL = s.mounted(page.ArchiveURL)
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if asJSON {
+		serveListingJSON(w, r, page)
+		return
+	}
+
+	page.Receipts = s.state.Receipts
+	page.Stream = !s.state.NoStream
+	page.ThemeCSS = themeCSS(s.state.Theme)
+	page.Title, page.Message, page.Footer = s.state.Title, s.state.Message, s.state.Footer
+	if s.state.Auth == state.AuthForm && s.state.Mode == state.ModeProtected {
+		page.Logout = s.mounted(auth.LogoutPath)
+	}
+	page.Lang = s.pageLang(w, r)
+	if !s.langFixed() {
+		page.languages = i18n.Languages()
+	}
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+
+	tmpl, custom := s.templates.dirTemplate()
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, page); err != nil {
+		if !custom {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// 覆盖模板执行出错时不影响访问者，回退到内置模板
+		fmt.Fprintf(os.Stderr, "警告: 模板 %s 渲染失败，使用内置模板: %v\n", dirTemplateName, err)
+		buf.Reset()
+		if err := defaultDirTemplate.Execute(&buf, page); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	serveHTML(w, r, page.modTime, buf.Bytes())
 }
 
 func formatSize(size int64) string {
@@ -520,6 +892,10 @@ type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
 	bytes      int64
+	start      time.Time
+	aborted    string // 被 deadlineMiddleware 中止的原因
+	checked    bool   // downloadComplete 已经判断过这次响应
+	complete   bool   // 这次响应之后文件已被完整下载
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -533,58 +909,109 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWriter{ResponseWriter: w, statusCode: 200}
+		// 分享者自己的可达性探测 (cfshare standby、降级检测) 不算访问
+		if isOwner(r) && r.Header.Get(health.ProbeHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		start := time.Now()
+		r = withRequestID(w, r)
+		rw := &responseWriter{ResponseWriter: w, statusCode: 200, start: start}
 
+		transfer := s.isTransfer(r)
+		if transfer {
+			s.metrics.active.Add(1)
+		}
 		next.ServeHTTP(rw, r)
+		if transfer {
+			s.metrics.active.Add(-1)
+		}
+		elapsed := time.Since(start)
 
 		record := state.AccessRecord{
-			Time:       start,
+			Time:       start.UTC(),
 			Path:       r.URL.Path,
 			StatusCode: rw.statusCode,
 			BytesSent:  rw.bytes,
 			RemoteAddr: r.RemoteAddr,
+			User:       auth.UserFromContext(r.Context()),
+			UserAgent:  r.UserAgent(),
+			Aborted:    rw.aborted,
 		}
 
-		
-		state.UpdateAccessStats(record)
-		// 已在 UpdateAccessStats 中保存
-		
-
-		logEntry := map[string]interface{}{
-			"time":        start.Format(time.RFC3339),
-			"path":        r.URL.Path,
-			"method":      r.Method,
-			"status":      rw.statusCode,
-			"bytes":       rw.bytes,
-			"remote_addr": r.RemoteAddr,
-			"user_agent":  r.UserAgent(),
-			"duration_ms": time.Since(start).Milliseconds(),
+		s.stats.record(record)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID(r)),
+			slog.String("path", r.URL.Path),
+			slog.String("method", r.Method),
+			slog.Int("status", rw.statusCode),
+			slog.Int64("bytes", rw.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("client_ip", clientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+			slog.String("user", record.User),
+			slog.Int64("duration_ms", elapsed.Milliseconds()),
+		}
+		// Cloudflare 边缘的请求标识和原始访问者地址，用于对照 tunnel 一侧的日志
+		if ray := r.Header.Get("CF-Ray"); ray != "" {
+			attrs = append(attrs, slog.String("cf_ray", ray))
+		}
+		if ip := r.Header.Get("CF-Connecting-IP"); ip != "" {
+			attrs = append(attrs, slog.String("cf_connecting_ip", ip))
+		}
+		// --ask-name 时访问者填写的姓名
+		if s.state.AskName {
+			if name := visitorName(r); name != "" {
+				attrs = append(attrs, slog.String("visitor_name", name))
+			}
+		}
+		// 传输速率和响应的完整大小，cfshare logs 据此显示速度和中断时的进度
+		if rw.bytes > 0 && elapsed > 0 {
+			attrs = append(attrs, slog.Int64("bytes_per_sec", int64(float64(rw.bytes)/elapsed.Seconds())))
+		}
+		if cl, err := strconv.ParseInt(rw.Header().Get("Content-Length"), 10, 64); err == nil && cl > 0 {
+			attrs = append(attrs, slog.Int64("content_length", cl))
+		}
+		// 以附件或内联方式发送的文件内容计为一次下载，用于分享结束时的汇总
+		if rw.Header().Get("Content-Disposition") != "" {
+			attrs = append(attrs, slog.Bool("download", true))
+		}
+		downloaded := ""
+		if s.downloadComplete(r, rw) {
+			downloaded = s.itemName(r.URL.Path)
+			s.recordDownload(r)
+			if s.state.Receipts {
+				s.transfers.add(clientIP(r), r.URL.Path, time.Now())
+			}
+		}
+		s.metrics.observe(rw.statusCode, rw.bytes, downloaded)
+		if rw.aborted != "" {
+			attrs = append(attrs, slog.String("aborted", rw.aborted))
 		}
 
-		logData, _ := json.Marshal(logEntry)
-		appendToAccessLog(string(logData))
+		logRequest(start, attrs)
 	})
 }
 
+// appendToAccessLog 向 access.log 追加一行事件 (封禁、到期等)，请求记录见 logRequest
 func appendToAccessLog(entry string) {
-	logPath := config.GetAccessLogPath()
-	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return
-	}
-	defer f.Close()
-	io.WriteString(f, entry+"\n")
+	accessLogWriter{}.Write([]byte(entry + "\n"))
 }
 
 const dirTemplate = `<!DOCTYPE html>
-<html>
+<html lang="{{.Lang}}">
 <head>
     <meta charset="utf-8">
     <meta name="viewport" content="width=device-width, initial-scale=1">
-    <title>Index of {{.Path}}</title>
+    <title>{{if .Title}}{{.Title}}{{else}}{{.T "index_of" .Path}}{{end}}</title>
     <style>
         * { box-sizing: border-box; }
         body {
@@ -646,50 +1073,298 @@ const dirTemplate = `<!DOCTYPE html>
             padding: 15px 20px;
             border-bottom: 1px solid #eee;
         }
+        .actions {
+            float: right;
+        }
+        .play {
+            margin-left: 10px;
+            font-size: 12px;
+        }
+        .receipt {
+            margin-left: 10px;
+            font-size: 12px;
+            color: #059669;
+        }
+        .search {
+            display: flex;
+            gap: 8px;
+            padding: 12px 20px;
+            border-bottom: 1px solid #eee;
+        }
+        .search input {
+            flex: 1;
+            padding: 8px 10px;
+            border: 1px solid #d1d5db;
+            border-radius: 6px;
+            font-size: 14px;
+        }
+        .search button {
+            padding: 8px 16px;
+            background: #2563eb;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            font-size: 14px;
+            cursor: pointer;
+        }
+        .checksum {
+            margin-top: 2px;
+            font-size: 12px;
+            color: #9ca3af;
+        }
+        .checksum a { color: #9ca3af; }
+        .version {
+            margin-left: 6px;
+            padding: 1px 6px;
+            border-radius: 8px;
+            font-size: 12px;
+            background: #dbeafe;
+            color: #1d4ed8;
+        }
+        .copy, .qr {
+            margin-left: 10px;
+            padding: 0;
+            border: none;
+            background: none;
+            color: #2563eb;
+            font-size: 12px;
+            cursor: pointer;
+        }
+        .qr-popup {
+            margin-top: 6px;
+        }
+        .qr-popup img {
+            display: block;
+            width: 180px;
+            height: 180px;
+        }
+        .qr-popup span {
+            font-size: 12px;
+            color: #6b7280;
+        }
+        .permalink {
+            margin-top: 2px;
+            font-size: 12px;
+            color: #9ca3af;
+            word-break: break-all;
+        }
+        .view-switch {
+            text-align: right;
+            font-size: 14px;
+        }
+        .gallery {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax(160px, 1fr));
+            gap: 10px;
+            padding: 15px 20px;
+            border-bottom: 1px solid #eee;
+        }
+        .gallery a {
+            display: block;
+            text-align: center;
+            font-size: 12px;
+            color: #374151;
+            overflow: hidden;
+        }
+        .gallery img {
+            width: 100%;
+            height: 140px;
+            object-fit: cover;
+            border-radius: 4px;
+            background: #f3f4f6;
+        }
+        .gallery span {
+            display: block;
+            white-space: nowrap;
+            overflow: hidden;
+            text-overflow: ellipsis;
+        }
+        th a { color: inherit; }
+        .pager {
+            text-align: center;
+            font-size: 14px;
+            color: #6b7280;
+        }
+        .pager a { margin: 0 10px; }
+        .subtitle {
+            display: block;
+            margin-top: 4px;
+            font-size: 13px;
+            opacity: 0.8;
+        }
+        .message {
+            padding: 15px 20px;
+            background: #eff6ff;
+            border-bottom: 1px solid #dbeafe;
+            color: #1e3a8a;
+            white-space: pre-line;
+        }
+        .footer {
+            padding: 15px 20px;
+            font-size: 13px;
+            color: #6b7280;
+            text-align: center;
+            white-space: pre-line;
+        }
+        .lang {
+            float: right;
+            font-size: 13px;
+        }
+        .lang a { color: white; margin-left: 8px; opacity: 0.8; }
+        .lang a.current { opacity: 1; font-weight: 600; }
+        .readme {
+            padding: 5px 20px 15px;
+            border-bottom: 1px solid #eee;
+        }
+` + markdownStyle + `
         @media (max-width: 600px) {
             .time { display: none; }
             th, td { padding: 10px 15px; }
         }
+{{.ThemeCSS}}
     </style>
 </head>
 <body>
     <div class="container">
-        <h1>📁 {{.Path}}</h1>
-        {{if ne .Path "/"}}
+        <h1>
+            <span class="lang" title="{{.T "language"}}">{{range .Languages}}<a href="{{$.LangLink .Code}}"{{if eq .Code $.Lang}} class="current"{{end}}>{{.Name}}</a>{{end}}{{if .Logout}}<a href="{{.Logout}}">{{.T "logout"}}</a>{{end}}</span>
+            {{if .Query}}🔍 {{.T "search_title" .Query}}{{else if .Title}}{{.Title}}<span class="subtitle">📁 {{.Path}}</span>{{else}}📁 {{.Path}}{{end}}
+        </h1>
+        {{if .Message}}<div class="message">{{.Message}}</div>{{end}}
+        <form class="search" action="{{.Root}}/search" method="get">
+            <input type="search" name="q" value="{{.Query}}" placeholder="{{.T "search_hint"}}">
+            <button type="submit">{{.T "search"}}</button>
+        </form>
+        {{if .Query}}
         <div class="back">
-            <a href="{{.Parent}}">⬆️ 返回上级目录</a>
+            <span class="actions">{{.T "results" .Total}}</span>
+            <a href="{{.Root}}/">⬅️ {{.T "back_home"}}</a>
+        </div>
+        {{else if or (ne .Path "/") .ArchiveURL}}
+        <div class="back">
+            {{if .ArchiveURL}}<span class="actions">📦 {{if eq .Path "/"}}{{.T "download_all"}}{{else}}{{.T "download_dir"}}{{end}}: <a href="{{.ArchiveURL}}?zip=1">ZIP</a> · <a href="{{.ArchiveURL}}?tgz=1">tar.gz</a>{{if .Split}} · <a href="{{.ArchiveURL}}?zip=split">{{.T "split_zip"}}</a>{{end}}</span>{{end}}
+            {{if ne .Path "/"}}<a href="{{.Parent}}">⬆️ {{.T "parent_dir"}}</a>{{end}}
+        </div>
+        {{end}}
+        {{if .Readme}}
+        <div class="readme markdown">{{.Readme}}</div>
+        {{end}}
+        {{if .GalleryAvailable}}
+        <div class="back view-switch">
+            {{if .Gallery}}<a href="{{.ViewLink "list"}}">☰ {{.T "list_view"}}</a>{{else}}<a href="{{.ViewLink "gallery"}}">🖼 {{.T "gallery_view"}}</a>{{end}}
+        </div>
+        {{end}}
+        {{if .Gallery}}
+        <div class="gallery">
+            {{range .Files}}{{if and (not .IsDir) (isImage .Name)}}
+            <a href="{{.Path}}?inline=1" title="{{.Name}}">
+                <img loading="lazy" src="{{.Path}}?thumb=1" alt="{{.Name}}">
+                <span>{{.Name}}</span>
+            </a>
+            {{end}}{{end}}
         </div>
         {{end}}
         <table>
             <thead>
                 <tr>
-                    <th>名称</th>
-                    <th>大小</th>
-                    <th class="time">修改时间</th>
+                    <th><a href="{{.SortLink "name"}}">{{.T "name"}}{{.SortMark "name"}}</a></th>
+                    <th><a href="{{.SortLink "size"}}">{{.T "size"}}{{.SortMark "size"}}</a></th>
+                    <th class="time"><a href="{{.SortLink "mtime"}}">{{.T "modified"}}{{.SortMark "mtime"}}</a></th>
                 </tr>
             </thead>
             <tbody>
-                {{range .Files}}
+                {{range .Files}}{{if not (and $.Gallery (not .IsDir) (isImage .Name))}}
                 <tr>
                     <td>
                         <a href="{{.Path}}">
                             {{if .IsDir}}<span class="icon">📁</span>{{else}}<span class="icon">📄</span>{{end}}
                             {{.Name}}
                         </a>
+                        {{if gt .Version 1}}<span class="version" title="{{$.T "updated_at"}} {{formatTime .UpdatedAt}}">v{{.Version}}</span>{{end}}
+                        {{if and $.Stream (not .IsDir) (mediaKind .Name)}}<a class="play" href="{{.Path}}?play=1">▶ {{$.T "play"}}</a>{{end}}
+                        {{if and $.Receipts (not .IsDir)}}<a class="receipt" href="{{.Path}}?receipt=1">✔ {{$.T "confirm_receipt"}}</a>{{end}}
+                        {{if not .IsDir}}<button type="button" class="copy" data-url="{{$.Link .Path}}" data-copied="{{$.T "link_copied"}}">🔗 {{$.T "copy_link"}}</button>
+                        <button type="button" class="qr" data-qr="{{.Path}}?qr=1" title="{{$.T "qr_hint"}}">▦ {{$.T "qr_code"}}</button>
+                        <div class="permalink">{{$.Link .Path}}</div>{{end}}
+                        {{if not .IsDir}}<div class="checksum">SHA-256: {{if .Checksum}}<code title="{{.Checksum}}">{{slice .Checksum 0 16}}…</code>{{else}}{{$.T "computing"}}{{end}} · <a href="{{.Path}}.sha256">.sha256</a></div>{{end}}
                     </td>
-                    <td class="size">{{if .IsDir}}-{{else}}{{formatSize .Size}}{{end}}</td>
-                    <td class="time">{{formatTime .ModTime}}</td>
+                    <td class="size">{{if and .IsDir .SizePending (not .Size)}}{{$.T "computing"}}{{else}}{{formatSize .Size}}{{if .SizePending}}+{{end}}{{end}}</td>
+                    <td class="time"><time datetime="{{isoTime .ModTime}}">{{formatTime .ModTime}}</time></td>
                 </tr>
-                {{end}}
+                {{end}}{{end}}
                 {{if not .Files}}
                 <tr>
                     <td colspan="3" style="text-align: center; color: #6b7280; padding: 40px;">
-                        📭 空目录
+                        {{if .Query}}🔍 {{.T "no_matches"}}{{else}}📭 {{.T "empty_dir"}}{{end}}
                     </td>
                 </tr>
                 {{end}}
             </tbody>
         </table>
+        {{if gt .Pages 1}}
+        <div class="back pager">
+            {{with .PrevLink}}<a href="{{.}}">← {{$.T "prev_page"}}</a>{{end}}
+            {{.T "page_of" .Page .Pages .Total}}
+            {{with .NextLink}}<a href="{{.}}">{{$.T "next_page"}} →</a>{{end}}
+        </div>
+        {{end}}
+        {{if .Footer}}<div class="footer">{{.Footer}}</div>{{end}}
     </div>
+    <script>
+    // 把服务器时间换算为访问者的本地时间，原始时间保留在 title 中
+    document.querySelectorAll("time[datetime]").forEach(function (el) {
+        var d = new Date(el.getAttribute("datetime"));
+        if (isNaN(d)) return;
+        var pad = function (n) { return n < 10 ? "0" + n : "" + n; };
+        el.title = el.textContent;
+        el.textContent = d.getFullYear() + "-" + pad(d.getMonth() + 1) + "-" + pad(d.getDate()) +
+            " " + pad(d.getHours()) + ":" + pad(d.getMinutes());
+    });
+
+    // 点击时才向服务器请求二维码，再次点击收起
+    document.querySelectorAll("button.qr").forEach(function (btn) {
+        btn.addEventListener("click", function () {
+            var popup = btn.parentNode.querySelector(".qr-popup");
+            if (popup) {
+                popup.hidden = !popup.hidden;
+                return;
+            }
+            popup = document.createElement("div");
+            popup.className = "qr-popup";
+            var img = document.createElement("img");
+            img.src = btn.getAttribute("data-qr");
+            img.alt = btn.title;
+            var hint = document.createElement("span");
+            hint.textContent = btn.title;
+            popup.appendChild(img);
+            popup.appendChild(hint);
+            btn.parentNode.appendChild(popup);
+        });
+    });
+
+    // 复制文件的完整链接；剪贴板不可用 (非 HTTPS) 时选中链接文字方便手动复制
+    document.querySelectorAll("button.copy").forEach(function (btn) {
+        btn.addEventListener("click", function () {
+            var url = btn.getAttribute("data-url");
+            var done = function () {
+                var label = btn.textContent;
+                btn.textContent = "✓ " + btn.getAttribute("data-copied");
+                setTimeout(function () { btn.textContent = label; }, 1500);
+            };
+            var select = function () {
+                var el = btn.parentNode.querySelector(".permalink");
+                var range = document.createRange();
+                range.selectNodeContents(el);
+                window.getSelection().removeAllRanges();
+                window.getSelection().addRange(range);
+            };
+            if (navigator.clipboard) {
+                navigator.clipboard.writeText(url).then(done, select);
+            } else {
+                select();
+            }
+        });
+    });
+    </script>
 </body>
 </html>`
@@ -1,13 +1,37 @@
 package server
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
 	"cfshare/internal/state"
+	"cfshare/internal/storage"
 )
 
 func TestNewServerSingleFile(t *testing.T) {
@@ -26,14 +50,14 @@ func TestNewServerSingleFile(t *testing.T) {
 		t.Fatalf("NewServer failed: %v", err)
 	}
 
-	if srv.isMulti {
+	if srv.shares().isMulti {
 		t.Error("single file should not be multi mode")
 	}
-	if len(srv.items) != 1 {
-		t.Errorf("expected 1 item, got %d", len(srv.items))
+	if len(srv.shares().items) != 1 {
+		t.Errorf("expected 1 item, got %d", len(srv.shares().items))
 	}
-	if srv.items[0].ShareType != state.TypeFile {
-		t.Errorf("expected TypeFile, got %s", srv.items[0].ShareType)
+	if srv.shares().items[0].ShareType != state.TypeFile {
+		t.Errorf("expected TypeFile, got %s", srv.shares().items[0].ShareType)
 	}
 }
 
@@ -51,11 +75,11 @@ func TestNewServerSingleDir(t *testing.T) {
 		t.Fatalf("NewServer failed: %v", err)
 	}
 
-	if srv.isMulti {
+	if srv.shares().isMulti {
 		t.Error("single dir should not be multi mode")
 	}
-	if srv.items[0].ShareType != state.TypeDir {
-		t.Errorf("expected TypeDir, got %s", srv.items[0].ShareType)
+	if srv.shares().items[0].ShareType != state.TypeDir {
+		t.Errorf("expected TypeDir, got %s", srv.shares().items[0].ShareType)
 	}
 }
 
@@ -75,11 +99,11 @@ func TestNewServerMultiItems(t *testing.T) {
 		t.Fatalf("NewServer failed: %v", err)
 	}
 
-	if !srv.isMulti {
+	if !srv.shares().isMulti {
 		t.Error("multiple items should be multi mode")
 	}
-	if len(srv.items) != 2 {
-		t.Errorf("expected 2 items, got %d", len(srv.items))
+	if len(srv.shares().items) != 2 {
+		t.Errorf("expected 2 items, got %d", len(srv.shares().items))
 	}
 }
 
@@ -185,7 +209,7 @@ func TestHandleMultiShareNotFound(t *testing.T) {
 
 	st := &state.State{}
 	srv, _ := NewServer([]string{tmpFile.Name()}, st)
-	srv.isMulti = true // 强制多文件模式测试
+	srv.shares().isMulti = true // 强制多文件模式测试
 
 	req := httptest.NewRequest("GET", "/nonexistent", nil)
 	w := httptest.NewRecorder()
@@ -207,9 +231,9 @@ func TestPathTraversalPrevention(t *testing.T) {
 
 	st := &state.State{}
 	srv, _ := NewServer([]string{subDir}, st)
-	srv.isMulti = true
-	srv.itemMap = map[string]*state.ShareItem{
-		"sub": &srv.items[0],
+	srv.shares().isMulti = true
+	srv.shares().itemMap = map[string]*state.ShareItem{
+		"sub": &srv.shares().items[0],
 	}
 
 	// 尝试路径遍历
@@ -258,3 +282,3596 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestFileRequestUpload(t *testing.T) {
+	inbox, _ := os.MkdirTemp("", "inbox")
+	defer os.RemoveAll(inbox)
+
+	st := &state.State{
+		Mode:         state.ModeRequest,
+		RequestToken: "abc123",
+		MaxUploads:   1,
+	}
+	srv, err := NewServer([]string{inbox}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	// 根路径不允许浏览
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for root, got %d", w.Code)
+	}
+
+	// 上传表单
+	req = httptest.NewRequest("GET", "/r/abc123/", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 for upload form, got %d", w.Code)
+	}
+
+	// 上传文件
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "../contract.pdf")
+	fw.Write([]byte("signed"))
+	mw.Close()
+
+	req = httptest.NewRequest("POST", "/r/abc123/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for upload, got %d", w.Code)
+	}
+
+	data, err := os.ReadFile(filepath.Join(inbox, "contract.pdf"))
+	if err != nil || string(data) != "signed" {
+		t.Errorf("uploaded file not saved correctly: %v", err)
+	}
+
+	// 达到上限后链接失效
+	req = httptest.NewRequest("GET", "/r/abc123/", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusGone {
+		t.Errorf("expected 410 after max uploads, got %d", w.Code)
+	}
+}
+
+func TestReceiveLimits(t *testing.T) {
+	inbox := t.TempDir()
+
+	st := &state.State{
+		Mode:         state.ModeRequest,
+		RequestToken: "abc123",
+		MaxFileSize:  8,
+		UploadQuota:  12,
+	}
+	srv, err := NewServer([]string{inbox}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	put := func(name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/r/abc123/"+name, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w
+	}
+
+	// PUT 上传，文件名中的目录部分被去掉
+	if w := put("..%2Fnotes.txt", "12345"); w.Code != http.StatusCreated || !contains(w.Body.String(), `"notes.txt"`) {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(inbox, "notes.txt")); err != nil || string(data) != "12345" {
+		t.Errorf("uploaded file not saved correctly: %q %v", data, err)
+	}
+
+	// 超过单文件上限
+	if w := put("big.bin", "123456789"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 over max file size, got %d", w.Code)
+	}
+	// 超过剩余配额 (12 - 5 = 7)，同名文件不覆盖
+	if w := put("notes.txt", "12345678"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 over quota, got %d", w.Code)
+	}
+	if w := put("notes.txt", "1234567"); w.Code != http.StatusCreated || !contains(w.Body.String(), "notes (1).txt") {
+		t.Errorf("PUT = %d %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(inbox, "big.bin")); !os.IsNotExist(err) {
+		t.Errorf("rejected upload left a file behind: %v", err)
+	}
+
+	// 配额用完后链接关闭
+	req := httptest.NewRequest("GET", "/r/abc123/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusGone {
+		t.Errorf("expected 410 when quota is used up, got %d", w.Code)
+	}
+}
+
+func TestReceiveMultipartJSON(t *testing.T) {
+	inbox := t.TempDir()
+	st := &state.State{Mode: state.ModeRequest, RequestToken: "abc123", MaxFileSize: 4}
+	srv, err := NewServer([]string{inbox}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "ok.txt")
+	fw.Write([]byte("ok"))
+	fw, _ = mw.CreateFormFile("file", "toolarge.txt")
+	fw.Write([]byte("too large"))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/r/abc123/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if got := w.Body.String(); !contains(got, `"saved":["ok.txt"]`) || !contains(got, `"rejected":["toolarge.txt"]`) {
+		t.Errorf("response = %s", got)
+	}
+}
+
+func TestReceiveMaxRequestSize(t *testing.T) {
+	inbox := t.TempDir()
+	st := &state.State{Mode: state.ModeRequest, RequestToken: "abc123", MaxRequestSize: 256}
+	srv, err := NewServer([]string{inbox}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	for _, length := range []string{"declared", "chunked"} {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "big.bin")
+		fw.Write(bytes.Repeat([]byte("x"), 1024))
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/r/abc123/", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		if length == "chunked" {
+			// 没有 Content-Length 时由 MaxBytesReader 在读取中截断
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413, got %d", length, w.Code)
+		}
+	}
+	if entries, _ := os.ReadDir(inbox); len(entries) != 0 {
+		t.Errorf("nothing should be saved, got %d files", len(entries))
+	}
+}
+
+func TestReceiveSlowUpload(t *testing.T) {
+	inbox := t.TempDir()
+	st := &state.State{Mode: state.ModeRequest, RequestToken: "abc123", UploadQuota: 100}
+	srv, err := NewServer([]string{inbox}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	// 慢速上传: 请求体在测试结束前一直读不完
+	pr, pw := io.Pipe()
+	slow := httptest.NewRequest("PUT", "/r/abc123/slow.bin", pr)
+	slow.ContentLength = 80
+	slowDone := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, slow)
+		slowDone <- w.Code
+	}()
+	pw.Write(bytes.Repeat([]byte("s"), 10))
+
+	put := func(name string, size int) int {
+		done := make(chan int)
+		go func() {
+			w := httptest.NewRecorder()
+			srv.handleRequest(w, httptest.NewRequest("PUT", "/r/abc123/"+name, bytes.NewReader(bytes.Repeat([]byte("x"), size))))
+			done <- w.Code
+		}()
+		select {
+		case code := <-done:
+			return code
+		case <-time.After(5 * time.Second):
+			t.Fatal("upload blocked by a slow upload")
+			return 0
+		}
+	}
+	// 慢速上传预留了 80 字节，剩余配额只有 20 字节
+	if code := put("big.bin", 30); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over the remaining quota: got %d", code)
+	}
+	if code := put("small.bin", 20); code != http.StatusCreated {
+		t.Errorf("upload within the remaining quota: got %d", code)
+	}
+
+	// 慢速上传中断时删除已写入的部分并释放预留
+	pw.CloseWithError(io.ErrUnexpectedEOF)
+	if code := <-slowDone; code != http.StatusInternalServerError {
+		t.Errorf("interrupted upload: got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(inbox, "slow.bin")); !os.IsNotExist(err) {
+		t.Error("partial upload should be removed")
+	}
+	if code := put("more.bin", 70); code != http.StatusCreated {
+		t.Errorf("reservation should be released, got %d", code)
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"report.pdf":         "report.pdf",
+		"../../etc/passwd":   "passwd",
+		`C:\Users\a\doc.txt`: "doc.txt",
+		".env":               "env",
+		"..":                 "",
+	}
+	for in, want := range tests {
+		if got := sanitizeFileName(in); got != want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRangeResume(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	file1 := filepath.Join(tmpDir, "file1.txt")
+	os.WriteFile(file1, []byte("0123456789"), 0644)
+	file2 := filepath.Join(tmpDir, "file2.txt")
+	os.WriteFile(file2, []byte("abcdefghij"), 0644)
+
+	st := &state.State{}
+	srv, _ := NewServer([]string{file1, file2}, st)
+
+	req := httptest.NewRequest("GET", "/file1.txt", nil)
+	req.Header.Set("Range", "bytes=5-")
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206, got %d", w.Code)
+	}
+	if w.Body.String() != "56789" {
+		t.Errorf("unexpected range content: %s", w.Body.String())
+	}
+	if w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Error("Accept-Ranges should be advertised")
+	}
+
+	// If-Range 不匹配时返回完整内容
+	req = httptest.NewRequest("GET", "/file1.txt", nil)
+	req.Header.Set("Range", "bytes=5-")
+	req.Header.Set("If-Range", "Mon, 02 Jan 2006 15:04:05 GMT")
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 when If-Range does not match, got %d", w.Code)
+	}
+	if w.Body.String() != "0123456789" {
+		t.Errorf("expected full content, got %s", w.Body.String())
+	}
+}
+
+func TestZipDownload(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	outside, _ := os.MkdirTemp("", "outside")
+	defer os.RemoveAll(outside)
+
+	photos := filepath.Join(tmpDir, "photos")
+	os.MkdirAll(filepath.Join(photos, "2024"), 0755)
+	os.WriteFile(filepath.Join(photos, "a.jpg"), []byte("aaa"), 0644)
+	os.WriteFile(filepath.Join(photos, "2024", "b.jpg"), []byte("bbb"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(photos, "link.txt"))
+
+	other := filepath.Join(tmpDir, "other.txt")
+	os.WriteFile(other, []byte("other"), 0644)
+
+	st := &state.State{}
+	srv, _ := NewServer([]string{photos, other}, st)
+
+	req := httptest.NewRequest("GET", "/photos?zip=1", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["photos/a.jpg"] || !names["photos/2024/b.jpg"] {
+		t.Errorf("zip missing expected entries: %v", names)
+	}
+	if names["photos/link.txt"] {
+		t.Error("symlink pointing outside the share should be skipped")
+	}
+}
+
+func TestDownloadAllArchive(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	docs := filepath.Join(tmpDir, "docs")
+	os.Mkdir(docs, 0755)
+	os.WriteFile(filepath.Join(docs, "a.txt"), []byte("aaa"), 0644)
+	file := filepath.Join(tmpDir, "b.txt")
+	os.WriteFile(file, []byte("bbb"), 0644)
+
+	st := &state.State{}
+	srv, _ := NewServer([]string{docs, file}, st)
+
+	req := httptest.NewRequest("GET", "/?tgz=1", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	names := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names[hdr.Name] = true
+	}
+	if !names["docs/a.txt"] || !names["b.txt"] {
+		t.Errorf("archive missing expected entries: %v", names)
+	}
+}
+
+func TestTermsInterstitial(t *testing.T) {
+	// 同意条款会写访问日志，使用临时配置目录
+	tmpHome, _ := os.MkdirTemp("", "cfshare-test")
+	defer os.RemoveAll(tmpHome)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpFile, _ := os.CreateTemp("", "test*.txt")
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString("content")
+	tmpFile.Close()
+
+	st := &state.State{ShareID: "test123"}
+	srv, _ := NewServer([]string{tmpFile.Name()}, st)
+	handler := srv.termsMiddleware("NDA terms", http.HandlerFunc(srv.handleRequest))
+
+	// 未同意时显示条款页
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden || !contains(w.Body.String(), "NDA terms") {
+		t.Fatalf("expected terms page, got %d", w.Code)
+	}
+
+	// 同意条款
+	form := strings.NewReader("agree=1&next=/")
+	req = httptest.NewRequest("POST", "/__terms__", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect after agreeing, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("expected terms cookie")
+	}
+
+	// 带 cookie 可以下载
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "content" {
+		t.Errorf("expected download after agreeing, got %d", w.Code)
+	}
+}
+
+func TestMediaStreaming(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	video := filepath.Join(tmpDir, "clip.mp4")
+	os.WriteFile(video, []byte("0123456789"), 0644)
+
+	st := &state.State{}
+	srv, _ := NewServer([]string{tmpDir}, st)
+
+	// 播放页
+	req := httptest.NewRequest("GET", "/clip.mp4?play=1", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK || !contains(w.Body.String(), "<video") {
+		t.Fatalf("expected player page, got %d", w.Code)
+	}
+
+	// 内联流支持 Range
+	req = httptest.NewRequest("GET", "/clip.mp4?inline=1", nil)
+	req.Header.Set("Range", "bytes=0-3")
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "0123" {
+		t.Errorf("expected 206 with partial content, got %d %q", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline") {
+		t.Errorf("expected inline disposition, got %q", w.Header().Get("Content-Disposition"))
+	}
+
+	// --no-stream 时忽略播放参数，直接下载
+	st.NoStream = true
+	req = httptest.NewRequest("GET", "/clip.mp4?play=1", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Error("expected attachment download when streaming is disabled")
+	}
+}
+
+func TestGalleryThumbnails(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	homeDir, _ := os.MkdirTemp("", "testhome")
+	defer os.RemoveAll(homeDir)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", homeDir)
+	defer os.Setenv("HOME", origHome)
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for i := 0; i < galleryMinImages; i++ {
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("photo%d.png", i)), buf.Bytes(), 0644)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "notes.txt"), []byte("hi"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+
+	// 图片占多数时默认使用图库视图
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !contains(w.Body.String(), "photo0.png?thumb=1") {
+		t.Error("expected gallery view with thumbnails")
+	}
+
+	// ?view=list 切换回列表
+	req = httptest.NewRequest("GET", "/?view=list", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if contains(w.Body.String(), "?thumb=1") {
+		t.Error("expected list view without thumbnails")
+	}
+
+	// 缩略图按比例缩小
+	req = httptest.NewRequest("GET", "/photo0.png?thumb=1", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	thumbImg, err := jpeg.Decode(w.Body)
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if b := thumbImg.Bounds(); b.Dx() != 320 || b.Dy() != 160 {
+		t.Errorf("unexpected thumbnail size %dx%d", b.Dx(), b.Dy())
+	}
+
+	// 非图片文件不生成缩略图
+	req = httptest.NewRequest("GET", "/notes.txt?thumb=1", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for non-image, got %d", w.Code)
+	}
+}
+
+func TestMarkdownRendering(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, "README.md"), []byte("# Project Docs\n\nSee <b>guide</b>."), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "guide.md"), []byte("## Guide\n\n- step one"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+
+	// 目录列表顶部显示 README
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+	if !contains(body, "<h1>Project Docs</h1>") {
+		t.Error("expected README rendered in listing")
+	}
+	if contains(body, "<b>guide</b>") {
+		t.Error("expected HTML in README to be escaped")
+	}
+
+	// .md 文件渲染为 HTML
+	req = httptest.NewRequest("GET", "/guide.md", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !contains(w.Body.String(), "<h2>Guide</h2>") || !contains(w.Body.String(), "guide.md?raw=1") {
+		t.Errorf("expected rendered markdown page, got %q", w.Body.String())
+	}
+
+	// ?raw=1 下载原始文件
+	req = httptest.NewRequest("GET", "/guide.md?raw=1", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Body.String() != "## Guide\n\n- step one" {
+		t.Errorf("expected raw markdown, got %q", w.Body.String())
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+	// 先算好校验值，避免后台计算完成导致列表内容 (以及 ETag) 在两次请求之间变化
+	srv.checksums.compute(filepath.Join(tmpDir, "a.txt"))
+
+	for _, path := range []string{"/", "/a.txt"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+
+		etag := w.Header().Get("ETag")
+		lastModified := w.Header().Get("Last-Modified")
+		if etag == "" || lastModified == "" {
+			t.Fatalf("%s: expected validators, got ETag=%q Last-Modified=%q", path, etag, lastModified)
+		}
+
+		req = httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-None-Match", etag)
+		w = httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for If-None-Match, got %d", path, w.Code)
+		}
+
+		req = httptest.NewRequest("GET", path, nil)
+		req.Header.Set("If-Modified-Since", lastModified)
+		w = httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for If-Modified-Since, got %d", path, w.Code)
+		}
+	}
+
+	// 目录内容变化后 ETag 随之改变
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	before := w.Header().Get("ETag")
+
+	os.WriteFile(filepath.Join(tmpDir, "b.txt"), []byte("new"), 0644)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", before)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 after directory changed, got %d", w.Code)
+	}
+}
+
+func TestHoneypotBansClient(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	homeDir, _ := os.MkdirTemp("", "testhome")
+	defer os.RemoveAll(homeDir)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", homeDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(homeDir, ".cfshare"), 0755)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, ".git"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, ".git", "config"), []byte("[core]"), 0644)
+
+	// 隐藏文件默认不可访问，这里显式显示以验证真实存在的路径不会被当作诱饵
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Honeypot: true, ShowHidden: true})
+	handler := srv.banMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	get := func(path, ip string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 分享中真实存在的路径不是诱饵
+	if code := get("/.git/config", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("expected real file to be served, got %d", code)
+	}
+	if code := get("/a.txt", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+
+	if code := get("/wp-login.php", "10.0.0.2"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for decoy path, got %d", code)
+	}
+	if code := get("/a.txt", "10.0.0.2"); code != http.StatusForbidden {
+		t.Errorf("expected banned client to get 403, got %d", code)
+	}
+	if code := get("/a.txt", "10.0.0.1"); code != http.StatusOK {
+		t.Errorf("other clients should not be affected, got %d", code)
+	}
+
+	logData, _ := os.ReadFile(filepath.Join(homeDir, ".cfshare", "access.log"))
+	if !contains(string(logData), `"event":"anomaly"`) {
+		t.Error("expected anomaly event in access log")
+	}
+}
+
+func TestChecksumEndpoint(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, "big.bin"), []byte("hello"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "real.txt.sha256"), []byte("provided by owner"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "real.txt"), []byte("x"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+
+	req := httptest.NewRequest("GET", "/big.bin.sha256", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  big.bin\n"
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("unexpected checksum response %d %q", w.Code, w.Body.String())
+	}
+
+	// 分享中已有的 .sha256 文件按原样提供
+	req = httptest.NewRequest("GET", "/real.txt.sha256", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Body.String() != "provided by owner" {
+		t.Errorf("expected shared .sha256 file, got %q", w.Body.String())
+	}
+
+	// 列表中显示已算好的校验值
+	req = httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !contains(w.Body.String(), "2cf24dba5fb0a30e…") {
+		t.Error("expected cached checksum in listing")
+	}
+}
+
+func TestUnauthorizedPage(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	unauthorized := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="x"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("Unauthorized\n"))
+	})
+	get := func(st *state.State) string {
+		srv, _ := NewServer([]string{tmpDir}, st)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		srv.errorPageMiddleware(unauthorized).ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("expected 401 with challenge, got %d", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	page := get(&state.State{Realm: "Acme Files", Contact: "ops@example.com"})
+	if !contains(page, "Acme Files") || !contains(page, `href="mailto:ops@example.com"`) {
+		t.Error("expected realm and mailto contact link in 401 page")
+	}
+	if contains(page, "Unauthorized") {
+		t.Error("plain-text body should be replaced")
+	}
+
+	page = get(&state.State{Contact: "<script>x</script>"})
+	if contains(page, "<script>x") {
+		t.Error("contact text should be escaped")
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	homeDir, _ := os.MkdirTemp("", "testhome")
+	defer os.RemoveAll(homeDir)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", homeDir)
+	defer os.Setenv("HOME", origHome)
+	tmplDir := filepath.Join(homeDir, ".cfshare", "templates")
+	os.MkdirAll(tmplDir, 0755)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Theme: "dark", Contact: "https://example.com/help"})
+	handler := srv.errorPageMiddleware(http.HandlerFunc(srv.handleRequest))
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/missing.txt", "text/html,application/xhtml+xml,*/*;q=0.8")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML error page, got %q", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	if !contains(body, "background: #0f172a") || !contains(body, `href="https://example.com/help"`) {
+		t.Error("expected themed error page with contact link")
+	}
+	if contains(body, "404 page not found") {
+		t.Error("plain-text body should not follow the HTML page")
+	}
+
+	// curl 和脚本仍然得到纯文本
+	w = get("/missing.txt", "")
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected plain-text 404 without Accept: text/html, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	// 正常响应不受影响
+	if w = get("/a.txt", "text/html"); w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Errorf("expected file content, got %d %q", w.Code, w.Body.String())
+	}
+
+	os.WriteFile(filepath.Join(tmplDir, errorTemplateName), []byte(`<p>custom {{.Status}} {{.Title}}</p>`), 0644)
+	if body := get("/missing.txt", "text/html").Body.String(); body != "<p>custom 404 未找到</p>" {
+		t.Errorf("expected custom error template, got %q", body)
+	}
+}
+
+func TestJSONListing(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "sub", "b.txt"), []byte("hi"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+
+	var listing struct {
+		Path   string `json:"path"`
+		Parent string `json:"parent"`
+		Files  []struct {
+			Name  string `json:"name"`
+			Size  int64  `json:"size"`
+			IsDir bool   `json:"is_dir"`
+			Path  string `json:"path"`
+		} `json:"files"`
+	}
+
+	req := httptest.NewRequest("GET", "/?format=json", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("expected JSON content type, got %q", w.Header().Get("Content-Type"))
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Files) != 2 || !listing.Files[0].IsDir || listing.Files[1].Path != "/a.txt" || listing.Files[1].Size != 5 {
+		t.Errorf("unexpected root listing: %+v", listing)
+	}
+
+	// Accept 协商
+	req = httptest.NewRequest("GET", "/sub/", nil)
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("expected JSON for Accept: application/json: %v", err)
+	}
+	if listing.Path != "/sub/" || listing.Parent != "/" || listing.Files[0].Path != "/sub/b.txt" {
+		t.Errorf("unexpected sub listing: %+v", listing)
+	}
+
+	// 浏览器仍然得到 HTML
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/json;q=0.9,*/*;q=0.8")
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML for browsers, got %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	outside, _ := os.MkdirTemp("", "outside")
+	defer os.RemoveAll(outside)
+
+	docs := filepath.Join(tmpDir, "docs")
+	os.MkdirAll(filepath.Join(docs, "2024", "q1"), 0755)
+	os.WriteFile(filepath.Join(docs, "2024", "q1", "Report-Final.pdf"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(docs, "notes.txt"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(outside, "report-secret.pdf"), []byte("x"), 0644)
+	os.Symlink(outside, filepath.Join(docs, "link"))
+
+	other := filepath.Join(tmpDir, "final-summary.txt")
+	os.WriteFile(other, []byte("x"), 0644)
+
+	srv, _ := NewServer([]string{docs, other}, &state.State{})
+
+	req := httptest.NewRequest("GET", "/search?q=report&format=json", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+
+	var listing struct {
+		Files []struct {
+			Name string `json:"name"`
+			Path string `json:"path"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Files) != 1 || listing.Files[0].Path != "/docs/2024/q1/Report-Final.pdf" {
+		t.Errorf("unexpected results (symlinks must not be followed): %+v", listing.Files)
+	}
+
+	// 多个关键词需同时匹配，且同时搜索文件分享项
+	req = httptest.NewRequest("GET", "/search?q=final+pdf", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+	if !contains(body, "Report-Final.pdf") || contains(body, "final-summary.txt") {
+		t.Error("expected only files matching all terms")
+	}
+
+	req = httptest.NewRequest("GET", "/search?q=final", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !contains(w.Body.String(), `href="/final-summary.txt"`) {
+		t.Error("expected top-level file item in results")
+	}
+}
+
+func TestSignedLinks(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	homeDir, _ := os.MkdirTemp("", "testhome")
+	defer os.RemoveAll(homeDir)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", homeDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(homeDir, ".cfshare"), 0755)
+
+	filePath := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(filePath, []byte("hello"), 0644)
+	dirPath := filepath.Join(tmpDir, "docs")
+	os.MkdirAll(dirPath, 0755)
+	os.WriteFile(filepath.Join(dirPath, "b.txt"), []byte("world"), 0644)
+
+	srv, _ := NewServer([]string{filePath, dirPath}, &state.State{LinkSecret: "secret"})
+	authed := auth.BasicAuthMiddleware("cfshare", "pw", http.HandlerFunc(srv.handleRequest))
+	handler := srv.signedLinkMiddleware(authed, http.HandlerFunc(srv.handleRequest))
+
+	expires := time.Now().Add(time.Hour)
+	signed := func(path string, expires time.Time) string {
+		return fmt.Sprintf("exp=%d&sig=%s", expires.Unix(), auth.SignPath("secret", path, expires))
+	}
+	get := func(target string) int {
+		req := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get("/a.txt?" + signed("/a.txt", expires)); code != http.StatusOK {
+		t.Errorf("expected signed file link to bypass auth, got %d", code)
+	}
+	if code := get("/docs?zip=1&" + signed("/docs", expires)); code != http.StatusOK {
+		t.Errorf("expected signed archive link to bypass auth, got %d", code)
+	}
+	if code := get("/docs?" + signed("/docs", expires)); code != http.StatusUnauthorized {
+		t.Errorf("signed link must not allow browsing a directory, got %d", code)
+	}
+	if code := get("/docs/b.txt?" + signed("/a.txt", expires)); code != http.StatusUnauthorized {
+		t.Errorf("signature for another path should be rejected, got %d", code)
+	}
+	past := time.Now().Add(-time.Minute)
+	if code := get("/a.txt?" + signed("/a.txt", past)); code != http.StatusUnauthorized {
+		t.Errorf("expired link should be rejected, got %d", code)
+	}
+	if code := get("/a.txt"); code != http.StatusUnauthorized {
+		t.Errorf("unsigned request should require auth, got %d", code)
+	}
+}
+
+func TestListingSortAndPagination(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	total := listingPageSize + 5
+	for i := 0; i < total; i++ {
+		os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%04d.txt", i)), nil, 0644)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "f0100.txt"), []byte("largest"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "zdir"), 0755)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+
+	getJSON := func(target string) listingJSON {
+		req := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		var out listingJSON
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", target, err)
+		}
+		return out
+	}
+
+	// JSON 未指定页码时返回完整列表
+	if out := getJSON("/?format=json"); len(out.Files) != total+1 || out.Total != total+1 || out.Pages != 0 {
+		t.Errorf("expected full listing, got %d files, total %d, pages %d", len(out.Files), out.Total, out.Pages)
+	}
+
+	out := getJSON("/?format=json&sort=size&order=desc&page=1")
+	if len(out.Files) != listingPageSize || out.Page != 1 || out.Pages != 2 {
+		t.Fatalf("expected first page of %d, got %d files, page %d/%d", listingPageSize, len(out.Files), out.Page, out.Pages)
+	}
+	if out.Files[0].Name != "zdir" || out.Files[1].Name != "f0100.txt" {
+		t.Errorf("expected directory then largest file first, got %s, %s", out.Files[0].Name, out.Files[1].Name)
+	}
+
+	out = getJSON("/?format=json&page=99")
+	if out.Page != 2 || len(out.Files) != total+1-listingPageSize {
+		t.Errorf("expected out-of-range page to clamp to last page, got page %d with %d files", out.Page, len(out.Files))
+	}
+	if last := out.Files[len(out.Files)-1].Name; last != fmt.Sprintf("f%04d.txt", total-1) {
+		t.Errorf("expected last file on last page, got %s", last)
+	}
+
+	req := httptest.NewRequest("GET", "/?sort=mtime", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+	if !contains(body, "第 1 / 2 页") || !contains(body, "page=2") {
+		t.Error("expected pager on first page")
+	}
+	if contains(body, fmt.Sprintf("f%04d.txt", total-1)) {
+		t.Error("first page should not include entries from the second page")
+	}
+	if !contains(body, "order=desc&amp;sort=mtime") {
+		t.Error("expected header link to toggle sort order")
+	}
+}
+
+func TestTemplateOverrideAndTheme(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	homeDir, _ := os.MkdirTemp("", "testhome")
+	defer os.RemoveAll(homeDir)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", homeDir)
+	defer os.Setenv("HOME", origHome)
+	tmplDir := filepath.Join(homeDir, ".cfshare", "templates")
+	os.MkdirAll(tmplDir, 0755)
+
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Theme: "dark"})
+	get := func() string {
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	if body := get(); !contains(body, "background: #0f172a") {
+		t.Error("expected dark theme CSS in listing")
+	}
+
+	tmplPath := filepath.Join(tmplDir, dirTemplateName)
+	os.WriteFile(tmplPath, []byte(`<ul>{{range .Files}}<li>custom {{.Name}} {{formatSize .Size}}</li>{{end}}</ul>`), 0644)
+	if body := get(); !contains(body, "<li>custom a.txt 5 B</li>") {
+		t.Errorf("expected custom template, got %s", body)
+	}
+
+	// 解析失败时回退到内置模板
+	os.WriteFile(tmplPath, []byte(`{{range .Files}`), 0644)
+	os.Chtimes(tmplPath, time.Now(), time.Now().Add(time.Minute))
+	if body := get(); !contains(body, "📁") || contains(body, "custom") {
+		t.Error("expected fallback to built-in template on parse error")
+	}
+
+	// 执行出错时同样回退
+	os.WriteFile(tmplPath, []byte(`{{.NoSuchField}}`), 0644)
+	os.Chtimes(tmplPath, time.Now(), time.Now().Add(2*time.Minute))
+	if body := get(); !contains(body, "a.txt") {
+		t.Error("expected fallback to built-in template on execution error")
+	}
+}
+
+func TestListingLanguage(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.MkdirAll(filepath.Join(tmpDir, "empty"), 0755)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+	get := func(target, acceptLang string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if acceptLang != "" {
+			req.Header.Set("Accept-Language", acceptLang)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w
+	}
+
+	w := get("/empty/", "en-US,en;q=0.9", nil)
+	if body := w.Body.String(); !contains(body, "Empty folder") || !contains(body, `lang="en"`) {
+		t.Error("expected English listing for English Accept-Language")
+	}
+	if !contains(strings.Join(w.Header().Values("Vary"), ","), "Accept-Language") {
+		t.Error("expected Vary: Accept-Language")
+	}
+
+	if body := get("/empty/", "", nil).Body.String(); !contains(body, "空目录") {
+		t.Error("expected default Chinese listing without Accept-Language")
+	}
+
+	// 语言切换链接写入 cookie，之后优先于 Accept-Language
+	w = get("/empty/?lang=zh", "en", nil)
+	if !contains(w.Body.String(), "空目录") {
+		t.Error("expected ?lang=zh to switch language")
+	}
+	var langCookieValue *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == langCookie {
+			langCookieValue = c
+		}
+	}
+	if langCookieValue == nil || langCookieValue.Value != "zh" {
+		t.Fatal("expected language cookie to be set")
+	}
+	if body := get("/empty/", "en", langCookieValue).Body.String(); !contains(body, "空目录") {
+		t.Error("expected cookie to take priority over Accept-Language")
+	}
+	if body := get("/empty/", "en", &http.Cookie{Name: langCookie, Value: "xx"}).Body.String(); !contains(body, "Empty folder") {
+		t.Error("expected unsupported cookie value to be ignored")
+	}
+}
+
+func TestPageTitleMessageFooter(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "a.jpg"), []byte("x"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{
+		Title:   "Wedding <photos>",
+		Message: "Pick what you want",
+		Footer:  "Thanks!",
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+
+	if !contains(body, "<title>Wedding &lt;photos&gt;</title>") {
+		t.Error("expected escaped custom title")
+	}
+	if !contains(body, `<div class="message">Pick what you want</div>`) {
+		t.Error("expected banner message")
+	}
+	if !contains(body, `<div class="footer">Thanks!</div>`) {
+		t.Error("expected footer")
+	}
+	if !contains(body, `<time datetime="`) {
+		t.Error("expected machine-readable modification time")
+	}
+}
+
+func TestLargeFileConfirmation(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "big.iso"), bytes.Repeat([]byte("x"), 2048), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "small.txt"), []byte("hi"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{ConfirmSize: 1024})
+	get := func(target, accept, rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept", accept)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w
+	}
+	const browser = "text/html,application/xhtml+xml,*/*;q=0.8"
+
+	w := get("/big.iso", browser, "")
+	if w.Header().Get("Content-Disposition") != "" || !contains(w.Body.String(), "big.iso?confirm=1") {
+		t.Fatal("expected confirmation page for large file")
+	}
+	if !contains(w.Body.String(), "2.00 KB") {
+		t.Error("expected file size on confirmation page")
+	}
+
+	if w := get("/big.iso?confirm=1", browser, ""); w.Body.Len() != 2048 {
+		t.Errorf("expected download after confirmation, got %d bytes", w.Body.Len())
+	}
+	if w := get("/big.iso", "*/*", ""); w.Body.Len() != 2048 {
+		t.Errorf("expected non-browser clients to download directly, got %d bytes", w.Body.Len())
+	}
+	if w := get("/big.iso", browser, "bytes=0-9"); w.Code != http.StatusPartialContent {
+		t.Errorf("expected range requests to bypass confirmation, got %d", w.Code)
+	}
+	if w := get("/small.txt", browser, ""); w.Body.String() != "hi" {
+		t.Error("expected small files to download directly")
+	}
+}
+
+func TestFixedLanguage(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.MkdirAll(filepath.Join(tmpDir, "empty"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "song.mp3"), []byte("x"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Lang: "en"})
+	get := func(target string) string {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Language", "zh-CN")
+		req.AddCookie(&http.Cookie{Name: langCookie, Value: "zh"})
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w.Body.String()
+	}
+
+	body := get("/empty/")
+	if !contains(body, "Empty folder") {
+		t.Error("expected --lang to override visitor preferences")
+	}
+	if contains(body, "?lang=") {
+		t.Error("expected no language switcher when the language is fixed")
+	}
+	if body := get("/song.mp3?play=1"); !contains(body, "Download") || !contains(body, `lang="en"`) {
+		t.Error("expected player page in the fixed language")
+	}
+}
+
+func TestExcludeAndHiddenFiles(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, "keep.txt"), []byte("keep"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, ".env"), []byte("SECRET=1"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, ".git"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, ".git", "config"), []byte("[core]"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "node_modules", "pkg"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "node_modules", "pkg", "index.js"), []byte("x"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "build"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "build", "main.o"), []byte("obj"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "build", "main.txt"), []byte("txt"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "debug.log"), []byte("log"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Exclude: []string{"node_modules", "*.log", "build/*.o"}})
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w
+	}
+
+	body := get("/").Body.String()
+	for _, hidden := range []string{".env", ".git", "node_modules", "debug.log"} {
+		if contains(body, hidden) {
+			t.Errorf("listing should not include %s", hidden)
+		}
+	}
+	if !contains(body, "keep.txt") || !contains(body, "build/") {
+		t.Error("listing should include visible entries")
+	}
+	if body := get("/build/").Body.String(); contains(body, "main.o") || !contains(body, "main.txt") {
+		t.Error("expected path pattern to hide build/*.o only")
+	}
+
+	for _, target := range []string{"/.env", "/.git/config", "/node_modules/pkg/index.js", "/debug.log", "/build/main.o", "/.env.sha256", "/.env?play=1"} {
+		if code := get(target).Code; code != http.StatusNotFound {
+			t.Errorf("expected 404 for %s, got %d", target, code)
+		}
+	}
+
+	if body := get("/search?q=index").Body.String(); contains(body, "index.js") {
+		t.Error("search should not return excluded files")
+	}
+
+	w := get("/?zip=1")
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	for _, f := range zr.File {
+		if contains(f.Name, ".env") || contains(f.Name, "node_modules") || contains(f.Name, "main.o") || contains(f.Name, ".git") {
+			t.Errorf("archive should not include %s", f.Name)
+		}
+	}
+
+	// cfshare review 只统计访问者能看到的文件: keep.txt 和 build/main.txt
+	files, size, err := ExposedSize(tmpDir, []string{"node_modules", "*.log", "build/*.o"}, false)
+	if err != nil || files != 2 || size != 7 {
+		t.Errorf("expected 2 visible files / 7 bytes, got %d/%d (%v)", files, size, err)
+	}
+}
+
+func TestSplitArchive(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "photos")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.bin"), bytes.Repeat([]byte("a"), 600), 0644)
+	os.WriteFile(filepath.Join(dir, "b.bin"), bytes.Repeat([]byte("b"), 600), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "c.bin"), bytes.Repeat([]byte("c"), 300), 0644)
+	os.WriteFile(filepath.Join(dir, ".secret"), []byte("hidden"), 0644)
+
+	srv, _ := NewServer([]string{dir}, &state.State{SplitSize: 1000})
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w
+	}
+
+	var manifest splitManifest
+	if err := json.Unmarshal(get("/?zip=split&format=json").Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if len(manifest.Parts) != 2 || manifest.TotalBytes != 1500 {
+		t.Fatalf("expected 2 parts totalling 1500 bytes, got %+v", manifest)
+	}
+
+	var names []string
+	var bodies [][]byte
+	for _, part := range manifest.Parts {
+		w := get(part.URL)
+		if w.Code != http.StatusOK {
+			t.Fatalf("part %d: expected 200, got %d", part.Part, w.Code)
+		}
+		bodies = append(bodies, w.Body.Bytes())
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("part %d: invalid zip: %v", part.Part, err)
+		}
+		var size int64
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+			size += int64(f.UncompressedSize64)
+		}
+		if size != part.Bytes || size > 1000 {
+			t.Errorf("part %d: expected %d bytes within the part size, got %d", part.Part, part.Bytes, size)
+		}
+	}
+	if strings.Join(names, ",") != "photos/a.bin,photos/b.bin,photos/sub/c.bin" {
+		t.Errorf("unexpected archive contents: %v", names)
+	}
+
+	// 相同内容的分卷每次生成的字节完全一致
+	if again := get(manifest.Parts[0].URL).Body.Bytes(); !bytes.Equal(again, bodies[0]) {
+		t.Error("expected split parts to be deterministic")
+	}
+
+	if code := get("/?zip=split&part=3").Code; code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing part, got %d", code)
+	}
+	if code := get("/?zip=split&part=1&v=stale").Code; code != http.StatusConflict {
+		t.Errorf("expected 409 for stale manifest, got %d", code)
+	}
+	if body := get("/?zip=split").Body.String(); !contains(body, "第 1 / 2 卷") {
+		t.Error("expected HTML manifest with part links")
+	}
+}
+
+func TestGetScript(t *testing.T) {
+	for _, tool := range []string{"sh", "curl", "sha256sum"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "data")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	big := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	os.WriteFile(filepath.Join(dir, "big.bin"), big, 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "it's.md"), []byte("# notes\n"), 0644)
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0644)
+
+	// 口令含有 base64url 签名中不会出现的字符，脚本中出现它只可能是泄露
+	const password = "pw!get#script%leak"
+	st := &state.State{Mode: state.ModeProtected, LinkSecret: "secret", Username: "cfshare"}
+	st.SetPassword(password)
+	srv, _ := NewServer([]string{dir}, st)
+	srv.checksums.compute(filepath.Join(dir, "big.bin"))
+
+	authed := auth.BasicAuthMiddleware("cfshare", password, http.HandlerFunc(srv.handleRequest))
+	ts := httptest.NewServer(srv.signedLinkMiddleware(authed, http.HandlerFunc(srv.handleRequest)))
+	defer ts.Close()
+	st.PublicURL = ts.URL
+
+	req := httptest.NewRequest("GET", getScriptPath, nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without --get-script, got %d", w.Code)
+	}
+
+	// 访问者登录后下载脚本，脚本用签名链接下载，不能带上口令
+	st.GetScript = true
+	req = httptest.NewRequest("GET", getScriptPath, nil)
+	req.SetBasicAuth("cfshare", password)
+	w = httptest.NewRecorder()
+	authed.ServeHTTP(w, req)
+	script := w.Body.String()
+	if w.Code != http.StatusOK || !contains(script, "sig=") || contains(script, password) ||
+		contains(script, base64.StdEncoding.EncodeToString([]byte("cfshare:"+password))) || contains(script, ".env") {
+		t.Fatalf("unexpected script (%d):\n%s", w.Code, script)
+	}
+
+	// 预先放一个不完整的文件，验证断点续传
+	outDir := t.TempDir()
+	os.MkdirAll(filepath.Join(outDir, "data", "sub"), 0755)
+	os.WriteFile(filepath.Join(outDir, "data", "sub", "it's.md"), []byte("# no"), 0644)
+
+	cmd := exec.Command("sh")
+	cmd.Dir = outDir
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Env = append(os.Environ(), "CFSHARE_SEGMENT_MIN=1024", "CFSHARE_SEGMENTS=3")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("script failed: %v\n%s", err, out)
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(outDir, "data", "big.bin")); !bytes.Equal(got, big) {
+		t.Error("segmented download does not match the original file")
+	}
+	if got, _ := os.ReadFile(filepath.Join(outDir, "data", "sub", "it's.md")); string(got) != "# notes\n" {
+		t.Errorf("expected raw markdown resumed from partial file, got %q", got)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "data", "*.cfpart*")); len(matches) > 0 {
+		t.Errorf("expected segment files to be cleaned up, found %v", matches)
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, httptest.NewRequest("GET", getScriptPath+"?path=/.env", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected hidden path to be refused, got %d", w.Code)
+	}
+}
+
+func TestCopyLinks(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "my file.txt"), []byte("hello"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{PublicURL: "https://share.example.com/"})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+	if !contains(body, `data-url="https://share.example.com/my%20file.txt"`) {
+		t.Errorf("expected copy button with absolute URL, got:\n%s", body)
+	}
+	if !contains(body, `<div class="permalink">https://share.example.com/my%20file.txt</div>`) {
+		t.Error("expected absolute URL displayed for the file")
+	}
+	if contains(body, "share.example.com/sub") {
+		t.Error("directories should not get a copy link")
+	}
+
+	req = httptest.NewRequest("GET", "/?format=json", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	var listing listingJSON
+	json.Unmarshal(w.Body.Bytes(), &listing)
+	for _, f := range listing.Files {
+		if f.Name == "my file.txt" && f.URL != "https://share.example.com/my%20file.txt" {
+			t.Errorf("unexpected JSON url: %q", f.URL)
+		}
+	}
+}
+
+func TestOwnerPriorityLane(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	homeDir, _ := os.MkdirTemp("", "testhome")
+	defer os.RemoveAll(homeDir)
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", homeDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(homeDir, ".cfshare"), 0755)
+
+	filePath := filepath.Join(tmpDir, "a.txt")
+	os.WriteFile(filePath, []byte("hello"), 0644)
+
+	srv, _ := NewServer([]string{filePath}, &state.State{OwnerToken: "owner-token"})
+	var sawOwner bool
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawOwner = isOwner(r) && auth.UserFromContext(r.Context()) == ownerUser
+		srv.handleRequest(w, r)
+	})
+	authed := auth.BasicAuthMiddleware("cfshare", "pw", http.HandlerFunc(srv.handleRequest))
+	handler := srv.ownerMiddleware(srv.banMiddleware(authed), inner)
+
+	// httptest 请求的来源地址为 192.0.2.1
+	srv.bans.ban("192.0.2.1", time.Hour)
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		sawOwner = false
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(httptest.NewRequest("GET", "/a.txt", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("expected banned visitor to be refused, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set(ownerHeader, "wrong")
+	if w := do(req); w.Code != http.StatusForbidden || sawOwner {
+		t.Errorf("expected invalid owner token to be ignored, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set(ownerHeader, "owner-token")
+	if w := do(req); w.Code != http.StatusOK || !sawOwner {
+		t.Errorf("expected owner header to bypass bans and auth, got %d", w.Code)
+	}
+
+	w := do(httptest.NewRequest("GET", ownerPath+"?token=owner-token", nil))
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusSeeOther || len(cookies) != 1 || cookies[0].Name != ownerCookie {
+		t.Fatalf("expected owner link to set a cookie, got %d %v", w.Code, cookies)
+	}
+	req = httptest.NewRequest("GET", "/a.txt", nil)
+	req.AddCookie(cookies[0])
+	if w := do(req); w.Code != http.StatusOK || !sawOwner {
+		t.Errorf("expected owner cookie to bypass bans and auth, got %d", w.Code)
+	}
+
+	if w := do(httptest.NewRequest("GET", ownerPath+"?token=wrong", nil)); len(w.Result().Cookies()) != 0 {
+		t.Error("invalid owner link must not set a cookie")
+	}
+}
+
+func TestFileQRCode(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+	os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{
+		Mode:       state.ModeProtected,
+		PublicURL:  "https://share.example.com",
+		LinkSecret: "secret",
+	})
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		return w
+	}
+
+	if body := get("/").Body.String(); !contains(body, `data-qr="a.txt?qr=1"`) {
+		t.Error("expected a QR button for the file")
+	}
+
+	w := get("/a.txt?qr=1")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("expected SVG QR code, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(w.Body.String(), "<svg") {
+		t.Error("expected SVG body")
+	}
+
+	if code := get("/sub?qr=1").Code; code != http.StatusNotFound {
+		t.Errorf("expected 404 for directory QR code, got %d", code)
+	}
+
+	srv.state.LinkSecret = ""
+	if code := get("/a.txt?qr=1").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without a link secret, got %d", code)
+	}
+}
+
+func TestWriteDirZip(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "project")
+	os.MkdirAll(filepath.Join(dir, "node_modules"), 0755)
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644)
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0644)
+	os.WriteFile(filepath.Join(dir, "node_modules", "x.js"), []byte("x"), 0644)
+
+	var buf bytes.Buffer
+	if err := WriteDirZip(&buf, dir, []string{"node_modules"}, false); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "project/,project/main.go" {
+		t.Errorf("unexpected zip contents: %v", names)
+	}
+}
+
+func TestRobotsNoindex(t *testing.T) {
+	tmpDir, _ := os.MkdirTemp("", "testdir")
+	defer os.RemoveAll(tmpDir)
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("hello"), 0644)
+
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+	handler := srv.robotsMiddleware(auth.BasicAuthMiddleware("cfshare", "pw", http.HandlerFunc(srv.handleRequest)))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/a.txt", nil))
+	if w.Code != http.StatusUnauthorized || !contains(w.Header().Get("X-Robots-Tag"), "noindex") {
+		t.Errorf("expected noindex on the 401 response, got %d %q", w.Code, w.Header().Get("X-Robots-Tag"))
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/robots.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "User-agent: *\nDisallow: /\n" {
+		t.Errorf("expected robots.txt without auth, got %d %q", w.Code, w.Body.String())
+	}
+
+	srv.state.AllowIndexing = true
+	handler = srv.robotsMiddleware(http.HandlerFunc(srv.handleRequest))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/robots.txt", nil))
+	if w.Code != http.StatusNotFound || w.Header().Get("X-Robots-Tag") != "" {
+		t.Errorf("expected --allow-indexing to leave responses alone, got %d %q", w.Code, w.Header().Get("X-Robots-Tag"))
+	}
+}
+
+func TestPastePage(t *testing.T) {
+	dir := t.TempDir()
+	pastePath := filepath.Join(dir, "paste-20261016-120000.txt")
+	os.WriteFile(pastePath, []byte("level=error msg=\"<boom>\"\n"), 0600)
+
+	srv, err := NewServer([]string{pastePath}, &state.State{Mode: state.ModePublic, Paste: true})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("page = %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !contains(body, `msg=&#34;&lt;boom&gt;&#34;`) || !contains(body, `/paste-20261016-120000.txt?raw=1`) {
+		t.Errorf("page body = %s", body)
+	}
+
+	req = httptest.NewRequest("GET", "/paste-20261016-120000.txt?raw=1", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" || w.Body.String() != "level=error msg=\"<boom>\"\n" {
+		t.Errorf("raw = %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "" {
+		t.Errorf("raw paste should be inline, got Content-Disposition %q", cd)
+	}
+}
+
+func TestStreamOneShot(t *testing.T) {
+	srv, err := NewServer([]string{StdinPath}, &state.State{Mode: state.ModePublic, StreamName: "backup.tar.gz"})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	if srv.shares().shareType != state.TypeStream || srv.shares().items[0].Name != "backup.tar.gz" {
+		t.Fatalf("stream item = %+v", srv.shares().items[0])
+	}
+	srv.stream.open = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("streamed bytes")), nil
+	}
+
+	// HEAD (如链接预览) 不取走内容
+	req := httptest.NewRequest("HEAD", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK || !contains(w.Header().Get("Content-Disposition"), "backup.tar.gz") {
+		t.Fatalf("HEAD = %d %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+
+	req = httptest.NewRequest("GET", "/backup.tar.gz", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "streamed bytes" {
+		t.Fatalf("GET = %d %q", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if w.Code != http.StatusGone {
+		t.Errorf("expected 410 for second download, got %d", w.Code)
+	}
+
+	if _, err := NewServer([]string{StdinPath, t.TempDir()}, &state.State{}); err == nil {
+		t.Error("expected error when mixing a stream with other paths")
+	}
+}
+
+// trickleReader 每次读取等待一段时间后只返回少量数据，模拟持续很久的下载
+type trickleReader struct{ delay time.Duration }
+
+func (tr trickleReader) Read(p []byte) (int, error) {
+	time.Sleep(tr.delay)
+	n := min(len(p), 1024)
+	for i := range n {
+		p[i] = 'x'
+	}
+	return n, nil
+}
+
+func TestMaxRequestDuration(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	st := &state.State{Mode: state.ModePublic, MaxRequestDuration: 300 * time.Millisecond}
+	srv, err := NewServer([]string{StdinPath}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	srv.stream.open = func() (io.ReadCloser, error) {
+		return io.NopCloser(trickleReader{delay: 20 * time.Millisecond}), nil
+	}
+
+	ts := httptest.NewServer(srv.loggingMiddleware(srv.deadlineMiddleware(http.HandlerFunc(srv.handleRequest))))
+	defer ts.Close()
+
+	start := time.Now()
+	resp, err := http.Get(ts.URL + "/")
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request was not aborted, took %s", elapsed)
+	}
+
+	// 客户端读到连接断开时服务端可能还没写完统计
+	var stats *state.Stats
+	for range 50 {
+		if stats = state.ReadStats(); stats.RequestCount > 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if stats.Aborted[abortDeadline] != 1 {
+		t.Errorf("aborted = %v, want one %q", stats.Aborted, abortDeadline)
+	}
+	if !contains(stats.FormatIn(false, time.UTC), "Aborted:     deadline 1") {
+		t.Errorf("stats output missing aborted line:\n%s", stats.FormatIn(false, time.UTC))
+	}
+}
+
+func TestWatchRate(t *testing.T) {
+	var transferred atomic.Int64
+	aborted := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go watchRate(ctx, &transferred, 1024, 50*time.Millisecond, func() { close(aborted) })
+	// 宽限期内没有进度也不中止，之后每个窗口至少需要 1024*0.05 字节
+	select {
+	case <-aborted:
+		t.Fatal("aborted during grace window")
+	case <-time.After(70 * time.Millisecond):
+	}
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("slow transfer was not aborted")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tmpFile, _ := os.CreateTemp("", "test*.bin")
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Write(bytes.Repeat([]byte("x"), 60<<10))
+	tmpFile.Close()
+
+	st := &state.State{ShareID: "test123", LimitRate: 100 << 10}
+	srv, _ := NewServer([]string{tmpFile.Name()}, st)
+	handler := srv.rateLimitMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	// 60KB 在 100KB/s 下至少需要约 0.5 秒 (第一块来自桶中初始的令牌)
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	elapsed := time.Since(start)
+	if w.Body.Len() != 60<<10 {
+		t.Fatalf("body = %d bytes", w.Body.Len())
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("60KB at 100KB/s took only %s", elapsed)
+	}
+
+	// 分享者不限速
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ownerContextKey{}, true))
+	start = time.Now()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("owner download was throttled: %s", elapsed)
+	}
+}
+
+func TestTokenBucketShared(t *testing.T) {
+	b := newTokenBucket(10 << 10)
+	ctx := context.Background()
+	start := time.Now()
+	// 两个连接共享 10KB/s，合计 4KB (初始令牌 1KB) 至少需要约 0.3 秒
+	var done = make(chan struct{})
+	for range 2 {
+		go func() {
+			for range 2 {
+				b.take(ctx, 1<<10)
+			}
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("shared bucket let 4KB through in %s", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := b.take(cancelled, 64<<10); err == nil {
+		t.Error("expected take to fail once the request is cancelled")
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "big.bin"), []byte("data"), 0644)
+
+	st := &state.State{ShareID: "test123", MaxConns: 2, MaxConnsPerIP: 1}
+	srv, _ := NewServer([]string{tmpDir}, st)
+
+	// 用阻塞的 handler 模拟进行中的下载
+	release := make(chan struct{})
+	started := make(chan struct{}, 4)
+	handler := srv.concurrencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	download := func(ip string) *http.Request {
+		req := httptest.NewRequest("GET", "/big.bin", nil)
+		req.Header.Set("CF-Connecting-IP", ip)
+		return req
+	}
+
+	done := make(chan struct{})
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), download(ip))
+			done <- struct{}{}
+		}()
+		<-started
+	}
+
+	// 同一 IP 的第二个下载和超过总数的下载都被拒绝
+	for _, ip := range []string{"198.51.100.1", "198.51.100.3"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, download(ip))
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected 429 with Retry-After, got %d", ip, w.Code)
+		}
+	}
+
+	// 目录页面和缩略图不占用名额
+	for _, target := range []string{"/", "/big.bin?thumb=1"} {
+		if srv.isTransfer(httptest.NewRequest("GET", target, nil)) {
+			t.Errorf("%s should not count as a transfer", target)
+		}
+	}
+
+	close(release)
+	<-done
+	<-done
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, download("198.51.100.3"))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected slot to be released, got %d", w.Code)
+	}
+}
+
+func TestMaxDownloads(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("aaaa"), 0644)
+	os.WriteFile(b, []byte("bbbb"), 0644)
+
+	st := &state.State{ShareID: "test123", MaxDownloads: 1, MaxDownloadsPerItem: true}
+	srv, err := NewServer([]string{a, b}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	limited := make(chan struct{}, 1)
+	srv.OnDownloadLimit(func() { limited <- struct{}{} })
+	handler := srv.loggingMiddleware(srv.downloadLimitMiddleware(http.HandlerFunc(srv.handleRequest)))
+
+	get := func(target string, header ...string) int {
+		req := httptest.NewRequest("GET", target, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 断点续传的部分下载不计入
+	if code := get("/a.txt", "Range", "bytes=0-1"); code != http.StatusPartialContent {
+		t.Fatalf("range request = %d", code)
+	}
+	if code := get("/a.txt"); code != http.StatusOK {
+		t.Fatalf("first download = %d", code)
+	}
+	if code := get("/a.txt"); code != http.StatusGone {
+		t.Errorf("expected 410 once a.txt reached its limit, got %d", code)
+	}
+	if code := get("/"); code != http.StatusOK {
+		t.Errorf("listing should stay available, got %d", code)
+	}
+	select {
+	case <-limited:
+		t.Fatal("share ended before every item was downloaded")
+	default:
+	}
+
+	if code := get("/b.txt"); code != http.StatusOK {
+		t.Fatalf("b.txt download = %d", code)
+	}
+	select {
+	case <-limited:
+	case <-time.After(time.Second):
+		t.Fatal("expected the share to end after every item was downloaded")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpFile := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(tmpFile, []byte("data"), 0644)
+
+	st := &state.State{ShareID: "test123", StopAt: time.Now().Add(100 * time.Millisecond)}
+	srv, _ := NewServer([]string{tmpFile}, st)
+	handler := srv.expiryMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	expired := make(chan struct{})
+	srv.ScheduleExpiry(func() { close(expired) })
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("before expiry = %d", w.Code)
+	}
+
+	select {
+	case <-expired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expiry callback did not run")
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusGone {
+		t.Errorf("after expiry = %d, want 410", w.Code)
+	}
+}
+
+func TestExtendExpiry(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpFile := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(tmpFile, []byte("data"), 0644)
+
+	srv, _ := NewServer([]string{tmpFile}, &state.State{ShareID: "test123", StopAt: time.Now().Add(100 * time.Millisecond)})
+	expired := make(chan struct{})
+	srv.ScheduleExpiry(func() { close(expired) })
+
+	// cfshare review 延长后，原来的定时器不再结束分享
+	srv.SetStopAt(time.Now().Add(300 * time.Millisecond))
+	select {
+	case <-expired:
+		t.Fatal("share ended at the original expiry")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if srv.expired() {
+		t.Error("extended share should not be expired yet")
+	}
+
+	select {
+	case <-expired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expiry callback did not run at the extended time")
+	}
+	if !srv.expired() {
+		t.Error("expected share to be expired after the extended time")
+	}
+}
+
+func TestMaxDownloadsRange(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	os.WriteFile(a, []byte("aaaa"), 0644)
+
+	st := &state.State{ShareID: "test123", MaxDownloads: 3}
+	srv, err := NewServer([]string{a}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	limited := make(chan struct{}, 1)
+	srv.OnDownloadLimit(func() { limited <- struct{}{} })
+	handler := srv.loggingMiddleware(srv.downloadLimitMiddleware(http.HandlerFunc(srv.handleRequest)))
+
+	get := func(rng string) int {
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		req.Header.Set("Range", rng)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 分段下载覆盖整个文件才计为一次下载
+	get("bytes=0-1")
+	get("bytes=2-3")
+	select {
+	case <-limited:
+		t.Fatal("share ended after a single download")
+	default:
+	}
+
+	// 每个 Range: bytes=0- 都返回整个文件，各计为一次下载
+	for i := 2; i <= 3; i++ {
+		if code := get("bytes=0-"); code != http.StatusPartialContent {
+			t.Fatalf("download %d = %d", i, code)
+		}
+	}
+	select {
+	case <-limited:
+	case <-time.After(time.Second):
+		t.Fatal("expected the share to end after three downloads")
+	}
+	if code := get("bytes=0-"); code != http.StatusGone {
+		t.Errorf("expected 410 after the download limit, got %d", code)
+	}
+}
+
+func TestBurn(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("aaaa"), 0644)
+	os.WriteFile(b, []byte("bbbb"), 0644)
+
+	st := &state.State{ShareID: "test123", Burn: state.BurnItem}
+	srv, err := NewServer([]string{a, b}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	handler := srv.loggingMiddleware(srv.burnMiddleware(http.HandlerFunc(srv.handleRequest)))
+
+	get := func(target string, header ...string) int {
+		req := httptest.NewRequest("GET", target, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 只下载了一部分时链接仍然有效，分段下载合起来覆盖整个文件后失效
+	if code := get("/a.txt", "Range", "bytes=0-1"); code != http.StatusPartialContent {
+		t.Fatalf("range request = %d", code)
+	}
+	if code := get("/a.txt", "Range", "bytes=2-"); code != http.StatusPartialContent {
+		t.Fatalf("link burned after a partial download, got %d", code)
+	}
+	if code := get("/a.txt"); code != http.StatusGone {
+		t.Errorf("expected 410 after every byte of a.txt was sent, got %d", code)
+	}
+
+	// 同一项目同时只允许一个下载
+	if !srv.burn.claim("b.txt") {
+		t.Fatal("claim failed")
+	}
+	if code := get("/b.txt"); code != http.StatusConflict {
+		t.Errorf("expected 409 while b.txt is being downloaded, got %d", code)
+	}
+	srv.burn.release("b.txt")
+
+	// Range: bytes=0- 返回 206 和整个文件，同样使链接失效
+	if code := get("/b.txt", "Range", "bytes=0-"); code != http.StatusPartialContent {
+		t.Errorf("b.txt should still be available, got %d", code)
+	}
+	if code := get("/b.txt", "Range", "bytes=0-"); code != http.StatusGone {
+		t.Errorf("expected 410 after b.txt was sent with a 206, got %d", code)
+	}
+	if code := get("/"); code != http.StatusOK {
+		t.Errorf("listing should stay available, got %d", code)
+	}
+
+	// 失效状态保存在 stats 中，新的服务进程同样拒绝
+	srv2, err := NewServer([]string{a, b}, st)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	handler = srv2.loggingMiddleware(srv2.burnMiddleware(http.HandlerFunc(srv2.handleRequest)))
+	if code := get("/a.txt"); code != http.StatusGone {
+		t.Errorf("expected 410 after restart, got %d", code)
+	}
+}
+
+func TestResourceWatch(t *testing.T) {
+	limits := resourceLimits{heap: 100 << 20, goroutines: 50, fds: 10}
+	w := &resourceWatch{warned: make(map[string]bool)}
+
+	r, fresh := w.record(state.Resources{HeapBytes: 10 << 20, Goroutines: 5, OpenFDs: 3}, limits)
+	if len(fresh) != 0 || len(r.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", r.Warnings)
+	}
+
+	r, fresh = w.record(state.Resources{HeapBytes: 200 << 20, Goroutines: 80, OpenFDs: 3}, limits)
+	if len(fresh) != 2 || len(r.Warnings) != 2 {
+		t.Fatalf("expected heap and goroutine warnings, got %v", fresh)
+	}
+
+	// 仍然超限时不重复警告，但采样中保留警告
+	r, fresh = w.record(state.Resources{HeapBytes: 200 << 20, Goroutines: 80, OpenFDs: -1}, limits)
+	if len(fresh) != 0 || len(r.Warnings) != 2 {
+		t.Errorf("expected no new warnings, got %v (warnings %v)", fresh, r.Warnings)
+	}
+
+	// 回落后再次超限会重新警告
+	w.record(state.Resources{HeapBytes: 10 << 20, Goroutines: 80}, limits)
+	if _, fresh = w.record(state.Resources{HeapBytes: 200 << 20, Goroutines: 80}, limits); len(fresh) != 1 {
+		t.Errorf("expected a new heap warning, got %v", fresh)
+	}
+
+	if s := sampleResources(); s.Goroutines == 0 || s.HeapBytes == 0 {
+		t.Errorf("unexpected sample: %+v", s)
+	}
+}
+
+func TestDebugAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:6060", "localhost:6060", "[::1]:6060"} {
+		if err := ValidateLoopbackAddr(addr); err != nil {
+			t.Errorf("ValidateLoopbackAddr(%q) = %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"0.0.0.0:6060", ":6060", "192.168.1.2:6060", "127.0.0.1", "example.com:6060"} {
+		if err := ValidateLoopbackAddr(addr); err == nil {
+			t.Errorf("ValidateLoopbackAddr(%q) should fail", addr)
+		}
+	}
+
+	publishResources()
+	w := httptest.NewRecorder()
+	debugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/vars", nil))
+	if w.Code != http.StatusOK || !contains(w.Body.String(), "cfshare_resources") {
+		t.Errorf("/debug/vars = %d %q", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	debugHandler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/debug/pprof/ = %d", w.Code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("aaaa"), 0644)
+	os.WriteFile(b, []byte("bbbb"), 0644)
+
+	srv, err := NewServer([]string{a}, &state.State{})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	old := srv.shares()
+
+	if err := srv.Reload([]string{a, b}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !srv.shares().isMulti || len(srv.shares().items) != 2 {
+		t.Fatalf("unexpected items after reload: %+v", srv.shares().items)
+	}
+	// 重载前取得的分享项 (进行中的请求) 保持不变
+	if old.isMulti || len(old.items) != 1 {
+		t.Errorf("previous share set was modified: %+v", old.items)
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, httptest.NewRequest("GET", "/b.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "bbbb" {
+		t.Errorf("GET /b.txt after reload = %d %q", w.Code, w.Body.String())
+	}
+
+	// 无效路径不替换当前分享项
+	if err := srv.Reload([]string{a, filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected error for a missing path")
+	}
+	if len(srv.shares().items) != 2 {
+		t.Errorf("failed reload should keep the current items, got %d", len(srv.shares().items))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, `b"q.txt`)
+	os.WriteFile(a, []byte("aaaa"), 0644)
+	os.WriteFile(b, []byte("bbbb"), 0644)
+
+	srv, err := NewServer([]string{a, b}, &state.State{PublicURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	handler := srv.loggingMiddleware(http.HandlerFunc(srv.handleRequest))
+	for _, target := range []string{"/a.txt", "/a.txt", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	w := httptest.NewRecorder()
+	srv.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`cfshare_requests_total{code="200"} 2`,
+		`cfshare_requests_total{code="404"} 1`,
+		`cfshare_item_downloads_total{item="a.txt"} 2`,
+		`cfshare_item_downloads_total{item="b\"q.txt"} 0`,
+		"cfshare_active_transfers 0",
+		"cfshare_tunnel_up 1",
+	} {
+		if !contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpFile := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(tmpFile, []byte("data"), 0644)
+
+	srv, err := NewServer([]string{tmpFile}, &state.State{PublicURL: "https://example.com", OwnerToken: "owner-token"})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	srv.healthHandlers(mux)
+
+	get := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if token != "" {
+			req.Header.Set(ownerHeader, token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/healthz", ""); w.Code != http.StatusOK {
+		t.Errorf("/healthz = %d", w.Code)
+	}
+	if w := get("/readyz", ""); w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("/readyz = %d %q", w.Code, w.Body.String())
+	}
+
+	state.SaveHealth(&state.Health{Degraded: true, LastError: "530"})
+	os.Remove(tmpFile)
+
+	w := get("/readyz", "")
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "not ready\n" {
+		t.Errorf("/readyz for recipients = %d %q", w.Code, w.Body.String())
+	}
+	w = get("/readyz", "owner-token")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz for owner = %d", w.Code)
+	}
+	var res readiness
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Ready || res.Checks["items"] == "ok" || res.Checks["tunnel"] == "ok" {
+		t.Errorf("unexpected readiness: %+v", res)
+	}
+}
+
+func TestCacheEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("data"), 0644)
+
+	srv, err := NewServer([]string{dir}, &state.State{OwnerToken: "owner-token"})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	if _, err := srv.checksums.compute(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, target, remote string) (int, []storage.Cache) {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = remote
+		req.Header.Set(ownerHeader, "owner-token")
+		w := httptest.NewRecorder()
+		srv.handleCache(w, req)
+		var caches []storage.Cache
+		json.Unmarshal(w.Body.Bytes(), &caches)
+		return w.Code, caches
+	}
+
+	if code, _ := call("GET", "/__cache__", "192.0.2.1:1234"); code != http.StatusNotFound {
+		t.Errorf("remote request = %d, want 404", code)
+	}
+	code, caches := call("GET", "/__cache__", "127.0.0.1:1234")
+	if code != http.StatusOK || len(caches) != len(memoryCaches) || caches[0].Name != "checksums" || caches[0].Entries != 1 || caches[0].Bytes == 0 {
+		t.Fatalf("GET = %d %+v", code, caches)
+	}
+	if code, _ := call("POST", "/__cache__?name=bogus", "127.0.0.1:1234"); code != http.StatusNotFound {
+		t.Errorf("unknown cache = %d, want 404", code)
+	}
+	code, caches = call("POST", "/__cache__?name=checksums", "127.0.0.1:1234")
+	if code != http.StatusOK || caches[0].Entries != 0 {
+		t.Errorf("POST = %d %+v", code, caches)
+	}
+}
+
+func TestRequestLog(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpFile := filepath.Join(t.TempDir(), "a.txt")
+	os.WriteFile(tmpFile, []byte("data"), 0644)
+
+	srv, _ := NewServer([]string{tmpFile}, &state.State{})
+	handler := srv.loggingMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("CF-Ray", "8a1b2c3d4e5f6789-NRT")
+	req.Header.Set("CF-Connecting-IP", "203.0.113.7")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	id := w.Header().Get(requestIDHeader)
+	if id == "" {
+		t.Fatal("expected request ID in response header")
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpHome, ".cfshare", "access.log"))
+	var entry map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+		t.Fatalf("access log line is not JSON: %q", data)
+	}
+	if entry["request_id"] != id || entry["cf_ray"] != "8a1b2c3d4e5f6789-NRT" || entry["cf_connecting_ip"] != "203.0.113.7" {
+		t.Errorf("expected request ID and Cloudflare headers in log entry, got %v", entry)
+	}
+	if entry["client_ip"] != "203.0.113.7" || entry["status"] != float64(200) || entry["bytes"] != float64(4) {
+		t.Errorf("unexpected request fields: %v", entry)
+	}
+	if _, err := time.Parse(time.RFC3339, entry["time"].(string)); err != nil || !strings.HasSuffix(entry["time"].(string), "Z") {
+		t.Errorf("expected RFC3339 UTC time, got %v", entry["time"])
+	}
+	if _, ok := entry["level"]; ok {
+		t.Error("log entry should not carry slog level")
+	}
+	if _, ok := entry["msg"]; ok {
+		t.Error("log entry should not carry slog message")
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpFile := filepath.Join(t.TempDir(), "secret-report.pdf")
+	os.WriteFile(tmpFile, []byte("data"), 0644)
+
+	st := &state.State{
+		ShareID:    "test123",
+		StatusPage: true,
+		Contact:    "ops@example.com",
+		Lang:       "en",
+		StopAt:     time.Now().Add(time.Hour),
+	}
+	srv, _ := NewServer([]string{tmpFile}, st)
+	handler := srv.expiryMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	get := func(h http.Handler, target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	status := http.HandlerFunc(srv.handleStatusPage)
+
+	w := get(status, statusPath, "text/html")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !contains(body, "This share is active") || !contains(body, `href="mailto:ops@example.com"`) {
+		t.Errorf("expected active status page with contact, got %d %s", w.Code, body)
+	}
+	if contains(body, "secret-report") {
+		t.Error("status page should not reveal shared items")
+	}
+
+	var js shareStatus
+	json.Unmarshal(get(status, statusPath+"?format=json", "").Body.Bytes(), &js)
+	if js.Status != statusActive || !js.Active || js.ExpiresAt == nil {
+		t.Errorf("unexpected JSON status: %+v", js)
+	}
+
+	srv.SetStopAt(time.Now().Add(-time.Minute))
+	if js := srv.currentStatus(); js.Status != statusExpired || js.Active {
+		t.Errorf("expected expired status, got %+v", js)
+	}
+
+	// 到期后浏览器访问其他路径看到状态页，脚本仍然得到纯文本
+	w = get(handler, "/", "text/html")
+	if w.Code != http.StatusGone || !contains(w.Body.String(), "This share has expired") || !contains(w.Body.String(), "ops@example.com") {
+		t.Errorf("expected 410 status page after expiry, got %d %s", w.Code, w.Body.String())
+	}
+	if w = get(handler, "/", ""); w.Code != http.StatusGone || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected plain 410 for scripts, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestDirSizes(t *testing.T) {
+	tmpHome := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpHome)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	dir := filepath.Join(t.TempDir(), "photos")
+	os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("aaaa"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bbbbbb"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "deep", "c.txt"), []byte("cc"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", ".secret"), []byte("hidden!!"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "skip.log"), []byte("excluded"), 0644)
+
+	srv, _ := NewServer([]string{dir}, &state.State{Exclude: []string{"*.log"}})
+	srv.warmDirSizes()
+
+	waitFor := func(what string, cond func() bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// 顶层分享项的大小写入 sizes.json 供 cfshare status 使用，隐藏和排除的文件不计入
+	waitFor("sizes.json", func() bool { return state.LoadItemSizes()["photos"].Done })
+	if size := state.LoadItemSizes()["photos"]; size.Files != 3 || size.Bytes != 12 {
+		t.Errorf("expected 3 files / 12 bytes, got %+v", size)
+	}
+
+	// 统计顶层目录时已记录子目录的大小，列出时直接可用
+	listing := func() []FileInfo {
+		req := httptest.NewRequest("GET", "/?format=json", nil)
+		w := httptest.NewRecorder()
+		srv.handleRequest(w, req)
+		var l struct{ Files []FileInfo }
+		json.Unmarshal(w.Body.Bytes(), &l)
+		return l.Files
+	}
+	var sub FileInfo
+	for _, f := range listing() {
+		if f.Name == "sub" {
+			sub = f
+		}
+	}
+	if !sub.IsDir || sub.SizePending || sub.Size != 8 {
+		t.Errorf("expected sub to be 8 bytes without waiting, got %+v", sub)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if !contains(w.Body.String(), `<td class="size">8 B</td>`) {
+		t.Error("expected directory size in HTML listing")
+	}
+}
+
+func TestEndedHandler(t *testing.T) {
+	endedAt := time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC)
+	handler := EndedHandler(&state.Ended{
+		Port:    8080,
+		EndedAt: endedAt,
+		Until:   endedAt.Add(24 * time.Hour),
+		Contact: "ops@example.com",
+		Lang:    "en",
+	})
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// 早先发出的任意链接都得到说明页
+	w := get("/report.pdf?sig=abc", "text/html")
+	body := w.Body.String()
+	if w.Code != http.StatusGone || !contains(body, "This share has ended") || !contains(body, "2026-10-16 09:30 UTC") || !contains(body, `href="mailto:ops@example.com"`) {
+		t.Errorf("expected 410 ended page with contact, got %d %s", w.Code, body)
+	}
+	if w.Header().Get("X-Robots-Tag") == "" {
+		t.Error("ended page should not be indexed")
+	}
+
+	var js shareStatus
+	json.Unmarshal(get("/__status__?format=json", "").Body.Bytes(), &js)
+	if js.Status != statusEnded || js.Active || js.EndedAt == nil || !js.EndedAt.Equal(endedAt) {
+		t.Errorf("unexpected JSON status: %+v", js)
+	}
+
+	if w = get("/report.pdf", ""); w.Code != http.StatusGone || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected plain 410 for scripts, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestLoginPage(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+
+	st := &state.State{
+		Mode:            state.ModeProtected,
+		Auth:            state.AuthForm,
+		SessionSecret:   "secret",
+		SessionLifetime: time.Hour,
+		Realm:           "Acme Files",
+		Contact:         "ops@example.com",
+		Lang:            "en",
+	}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	handler := srv.errorPageMiddleware(auth.FormAuthMiddleware(srv.formAuth("dl", auth.HashPassword("testpass")), http.HandlerFunc(srv.handleRequest)))
+
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	body := w.Body.String()
+	if w.Code != http.StatusUnauthorized || !contains(body, "Acme Files") || !contains(body, `action="/__login"`) || !contains(body, `href="mailto:ops@example.com"`) {
+		t.Errorf("expected themed login page, got %d %s", w.Code, body)
+	}
+
+	// 登录后目录页显示退出链接
+	req = httptest.NewRequest("POST", auth.LoginPath, strings.NewReader("username=dl&password=testpass&next=/"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusSeeOther || len(cookies) != 1 {
+		t.Fatalf("expected login to set a session cookie, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !contains(w.Body.String(), `href="/__logout"`) {
+		t.Errorf("expected listing with logout link, got %d", w.Code)
+	}
+}
+
+func TestMount(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.Mkdir(filepath.Join(tmpDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "sub", "a.txt"), []byte("data"), 0644)
+
+	st := &state.State{Mode: state.ModePublic, Mount: "/docs", Lang: "en"}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	handler := srv.mountMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	req := httptest.NewRequest("GET", "/docs/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !contains(body, `href="/docs/sub/"`) || !contains(body, `action="/docs/search"`) {
+		t.Errorf("expected listing with prefixed links, got %d %s", w.Code, body)
+	}
+	if contains(body, `href="/docs."`) {
+		t.Error("root listing should not link to a prefixed parent")
+	}
+
+	req = httptest.NewRequest("GET", "/docs/sub/a.txt", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("expected file under mount, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/docs", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/docs/" {
+		t.Errorf("expected redirect to /docs/, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	req = httptest.NewRequest("GET", "/other/sub/a.txt", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 outside mount, got %d", w.Code)
+	}
+
+	// 站内跳转和会话 cookie 的路径限制在挂载前缀内
+	rec := httptest.NewRecorder()
+	mw := &mountWriter{ResponseWriter: rec, mount: "/docs"}
+	http.SetCookie(mw, &http.Cookie{Name: "x", Value: "1", Path: "/"})
+	http.Redirect(mw, req, "/sub/", http.StatusSeeOther)
+	if loc := rec.Header().Get("Location"); loc != "/docs/sub/" {
+		t.Errorf("expected redirect inside mount, got %q", loc)
+	}
+	if c := rec.Header().Get("Set-Cookie"); !contains(c, "Path=/docs/") {
+		t.Errorf("expected cookie path inside mount, got %q", c)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s %s", name, r.Host, r.URL.Path, r.Header.Get("X-Forwarded-Proto"))
+		}))
+	}
+	root, docs, other := backend("root"), backend("docs"), backend("other")
+	defer root.Close()
+	defer docs.Close()
+	defer other.Close()
+	port := func(s *httptest.Server) int {
+		return s.Listener.Addr().(*net.TCPAddr).Port
+	}
+
+	handler := RouterHandler(func() []state.Route {
+		return []state.Route{
+			{Host: "share.example.com", Port: port(root)},
+			{Host: "share.example.com", Mount: "/docs", Port: port(docs)},
+			{Host: "other.example.com", Port: port(other)},
+		}
+	})
+
+	tests := []struct {
+		host, path, want string
+	}{
+		{"share.example.com", "/a.txt", "root share.example.com /a.txt https"},
+		{"share.example.com", "/docs/a.txt", "docs share.example.com /docs/a.txt https"},
+		{"share.example.com", "/docs", "docs share.example.com /docs https"},
+		{"share.example.com", "/docsx", "root share.example.com /docsx https"},
+		{"other.example.com", "/docs/a.txt", "other other.example.com /docs/a.txt https"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://"+tt.host+tt.path, nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Body.String() != tt.want {
+			t.Errorf("%s%s: got %q, want %q", tt.host, tt.path, w.Body.String(), tt.want)
+		}
+	}
+
+	// 没有默认分享时，前缀之外的路径返回 404
+	handler = RouterHandler(func() []state.Route {
+		return []state.Route{{Mount: "/docs", Port: port(docs)}}
+	})
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 outside any mount, got %d", w.Code)
+	}
+}
+
+func TestLinkToken(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+
+	st := &state.State{Mode: state.ModeProtected, Auth: state.AuthToken, LinkToken: "tok123", StatusPage: true, Lang: "en"}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", srv.handleRequest)
+	mux.HandleFunc(statusPath, srv.handleStatusPage)
+	handler := srv.mountMiddleware(mux)
+
+	req := httptest.NewRequest("GET", "/t/tok123/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !contains(w.Body.String(), `href="/t/tok123/a.txt"`) {
+		t.Errorf("expected listing with tokenized links, got %d", w.Code)
+	}
+	if w.Header().Get("Referrer-Policy") == "" {
+		t.Error("expected Referrer-Policy to keep the token out of Referer")
+	}
+
+	for _, path := range []string{"/a.txt", "/t/wrong/a.txt", "/t/tok1234/a.txt"} {
+		req = httptest.NewRequest("GET", path, nil)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 without the token, got %d", path, w.Code)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/t/tok123", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Header().Get("Location") != "/t/tok123/" {
+		t.Errorf("expected redirect to the share root, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// 公开状态页不需要令牌
+	req = httptest.NewRequest("GET", statusPath, nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected public status page without token, got %d", w.Code)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	tmpDir := t.TempDir()
+	doc := filepath.Join(tmpDir, "report.md")
+	os.WriteFile(doc, []byte("v1"), 0644)
+	other := filepath.Join(tmpDir, "notes.txt")
+	os.WriteFile(other, []byte("notes"), 0644)
+
+	st := &state.State{Mode: state.ModePublic, Watch: true, Lang: "en"}
+	srv, _ := NewServer([]string{doc, other}, st)
+	if updated := srv.checkVersions(); len(updated) != 0 {
+		t.Fatalf("first check should only record versions, got %v", updated)
+	}
+
+	// 修改时间变化但内容相同时不算更新
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(doc, later, later)
+	if updated := srv.checkVersions(); len(updated) != 0 {
+		t.Fatalf("touch should not bump the version, got %v", updated)
+	}
+
+	os.WriteFile(doc, []byte("version two"), 0644)
+	if updated := srv.checkVersions(); len(updated) != 1 || updated[0] != "report.md" {
+		t.Fatalf("expected report.md to be updated, got %v", updated)
+	}
+	if v := state.LoadItemVersions()["report.md"]; v.Version != 2 {
+		t.Errorf("expected saved version 2, got %+v", v)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	if body := w.Body.String(); !contains(body, `class="version"`) || !contains(body, ">v2<") {
+		t.Errorf("expected version badge in listing, got %s", body)
+	}
+
+	req = httptest.NewRequest("GET", feedPath, nil)
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, req)
+	body := w.Body.String()
+	if !contains(w.Header().Get("Content-Type"), "rss") || !contains(body, "<title>report.md v2</title>") || contains(body, "notes.txt") {
+		t.Errorf("expected feed with the updated file only, got %s", body)
+	}
+
+	// 未启用 --watch 时没有订阅地址
+	st.Watch = false
+	w = httptest.NewRecorder()
+	srv.handleRequest(w, httptest.NewRequest("GET", feedPath, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 without --watch, got %d", w.Code)
+	}
+}
+
+func TestUserPermissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	a := filepath.Join(tmpDir, "a.txt")
+	b := filepath.Join(tmpDir, "b.txt")
+	os.WriteFile(a, []byte("aaa"), 0644)
+	os.WriteFile(b, []byte("bbb"), 0644)
+
+	st := &state.State{Mode: state.ModeProtected, Lang: "en", Users: []auth.User{
+		{Name: "alice", PasswordHash: auth.HashPassword("alice-pass"), Perm: auth.PermRead, Items: []string{"a.txt"}},
+	}}
+	srv, _ := NewServer([]string{a, b}, st)
+	handler := auth.BasicAuthWithUsers("user", "secret", srv.users, auth.Challenge{}, srv.userMiddleware(http.HandlerFunc(srv.handleRequest)))
+
+	get := func(path, user, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.SetBasicAuth(user, pass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/b.txt", "user", "secret"); w.Code != http.StatusOK {
+		t.Errorf("share password should reach every item, got %d", w.Code)
+	}
+	if w := get("/a.txt", "alice", "alice-pass"); w.Code != http.StatusOK || w.Body.String() != "aaa" {
+		t.Errorf("alice should download a.txt, got %d", w.Code)
+	}
+	for _, p := range []string{"/b.txt", "/b.txt.sha256"} {
+		if w := get(p, "alice", "alice-pass"); w.Code != http.StatusNotFound {
+			t.Errorf("alice should not see %s, got %d", p, w.Code)
+		}
+	}
+	if body := get("/", "alice", "alice-pass").Body.String(); !contains(body, "a.txt") || contains(body, "b.txt") {
+		t.Errorf("root listing should only show alice's items, got %s", body)
+	}
+
+	srv.SetUsers(nil)
+	if w := get("/a.txt", "alice", "alice-pass"); w.Code != http.StatusUnauthorized {
+		t.Errorf("removed user should be rejected, got %d", w.Code)
+	}
+}
+
+func TestSpeedtest(t *testing.T) {
+	tmpDir := t.TempDir()
+	st := &state.State{Mode: state.ModePublic, OwnerToken: "owner-secret"}
+	srv, _ := NewServer([]string{tmpDir}, st)
+
+	w := httptest.NewRecorder()
+	srv.handleSpeedtest(w, httptest.NewRequest("GET", speedtestPath+"?mb=1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("speedtest without owner token should be hidden, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", speedtestPath+"?mb=2", nil)
+	req.Header.Set(ownerHeader, "owner-secret")
+	w = httptest.NewRecorder()
+	srv.handleSpeedtest(w, req)
+	if w.Code != http.StatusOK || w.Body.Len() != 2<<20 || w.Header().Get("Content-Length") != "2097152" {
+		t.Errorf("expected 2 MiB of data, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	// 签名链接给访问者使用，过期后失效
+	q := SpeedtestQuery("owner-secret", time.Now().Add(time.Hour))
+	w = httptest.NewRecorder()
+	srv.handleSpeedtest(w, httptest.NewRequest("POST", speedtestPath+"?"+q, strings.NewReader("0123456789")))
+	if w.Code != http.StatusOK || !contains(w.Body.String(), `"bytes":10`) {
+		t.Errorf("expected upload result, got %d %s", w.Code, w.Body.String())
+	}
+	q = SpeedtestQuery("owner-secret", time.Now().Add(-time.Minute))
+	w = httptest.NewRecorder()
+	srv.handleSpeedtest(w, httptest.NewRequest("GET", speedtestPath+"?"+q, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expired speedtest link should be rejected, got %d", w.Code)
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	if _, err := ParseIPPrefixes([]string{"10.0.0.0/8,192.168.1.5", "2001:db8::/32"}); err != nil {
+		t.Fatalf("valid rules rejected: %v", err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "office", "1.2.3"} {
+		if _, err := ParseIPPrefixes([]string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if newIPFilter(nil, nil) != nil {
+		t.Error("no rules should mean no filter")
+	}
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	st := &state.State{Mode: state.ModePublic, AllowIPs: []string{"203.0.113.0/24"}, BlockIPs: []string{"203.0.113.66"}}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	handler := srv.ipFilterMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	for _, tt := range []struct {
+		cfIP, remote string
+		code         int
+	}{
+		{"203.0.113.7", "127.0.0.1:1234", http.StatusOK},
+		{"203.0.113.66", "127.0.0.1:1234", http.StatusForbidden}, // 拒绝优先
+		{"198.51.100.1", "127.0.0.1:1234", http.StatusForbidden},
+		{"::ffff:203.0.113.9", "127.0.0.1:1234", http.StatusOK},
+		{"", "203.0.113.8:5555", http.StatusOK}, // 没有 CF-Connecting-IP 时使用连接地址
+		{"", "127.0.0.1:5555", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		req.RemoteAddr = tt.remote
+		if tt.cfIP != "" {
+			req.Header.Set("CF-Connecting-IP", tt.cfIP)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s/%s: got %d, want %d", tt.cfIP, tt.remote, w.Code, tt.code)
+		}
+	}
+}
+
+func TestServeWindow(t *testing.T) {
+	for _, bad := range []string{"01:00", "25:00-07:00", "01:00-01:00", "1-7"} {
+		if _, err := ParseServeWindow(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	night, err := ParseServeWindow("22:00-06:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if night.String() != "22:00-06:30" {
+		t.Errorf("String() = %s", night)
+	}
+	day := func(h, m int) time.Time { return time.Date(2024, 3, 1, h, m, 0, 0, time.UTC) }
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{day(23, 0), true}, {day(3, 0), true}, {day(6, 30), false}, {day(12, 0), false}, {day(22, 0), true},
+	} {
+		if got := night.Contains(tt.t); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+	if open := night.NextOpen(day(12, 0)); !open.Equal(day(22, 0)) {
+		t.Errorf("NextOpen(12:00) = %s", open)
+	}
+	if open := night.NextOpen(day(23, 0)); !open.Equal(day(22, 0).AddDate(0, 0, 1)) {
+		t.Errorf("NextOpen(23:00) = %s", open)
+	}
+
+	// 选一个不包含现在的时段
+	now := time.Now()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	st := &state.State{Mode: state.ModePublic, ServeWindow: closed}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	handler := srv.serveWindowMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/a.txt", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("download outside window: got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("expected Retry-After")
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || !contains(w.Body.String(), "a.txt") {
+		t.Errorf("listing outside window: got %d", w.Code)
+	}
+}
+
+func TestGeoFilter(t *testing.T) {
+	codes, err := ParseCountries([]string{"cn, US", "t1"})
+	if err != nil || strings.Join(codes, ",") != "CN,US,T1" {
+		t.Fatalf("ParseCountries = %v, %v", codes, err)
+	}
+	for _, bad := range []string{"USA", "C", "C-"} {
+		if _, err := ParseCountries([]string{bad}); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if newGeoFilter(nil, nil) != nil {
+		t.Error("no rules should mean no filter")
+	}
+
+	// 被拒绝的请求写入访问日志，使用临时配置目录
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	st := &state.State{Mode: state.ModePublic, AllowCountries: []string{"CN", "US"}, BlockCountries: []string{"US"}}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	handler := srv.geoFilterMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	for _, tt := range []struct {
+		country string
+		code    int
+	}{
+		{"CN", http.StatusOK},
+		{"cn", http.StatusOK},
+		{"US", http.StatusForbidden}, // 拒绝优先
+		{"DE", http.StatusForbidden},
+		{"", http.StatusForbidden}, // 没有 CF-IPCountry 视为 XX
+	} {
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		if tt.country != "" {
+			req.Header.Set("CF-IPCountry", tt.country)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%q: got %d, want %d", tt.country, w.Code, tt.code)
+		}
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpHome, ".cfshare", "access.log"))
+	if n := strings.Count(string(data), `"event":"geo_blocked"`); n != 3 {
+		t.Errorf("expected 3 geo_blocked events, got %d in %s", n, data)
+	}
+	if !contains(string(data), `"country":"DE"`) {
+		t.Error("expected blocked country in access log")
+	}
+}
+
+func TestWaitingRoom(t *testing.T) {
+	room := newWaitingRoom(1)
+	now := time.Now()
+	if ok, _ := room.enter("a", now); !ok {
+		t.Fatal("first visitor should be admitted")
+	}
+	if ok, pos := room.enter("b", now); ok || pos != 1 {
+		t.Fatalf("b: admitted=%v pos=%d, want queued at 1", ok, pos)
+	}
+	if ok, pos := room.enter("c", now); ok || pos != 2 {
+		t.Fatalf("c: admitted=%v pos=%d, want queued at 2", ok, pos)
+	}
+	// 同一访问者的第二个下载不占新的名额
+	if ok, _ := room.enter("a", now); !ok {
+		t.Fatal("active visitor should not queue")
+	}
+	room.leave("a")
+	room.leave("a")
+
+	// 名额空出后队首先进入，后来者不能插队
+	if ok, pos := room.enter("c", now); ok || pos != 2 {
+		t.Fatalf("c jumped the queue: admitted=%v pos=%d", ok, pos)
+	}
+	if ok, _ := room.enter("b", now); !ok {
+		t.Fatal("b should be admitted at the head of the queue")
+	}
+	room.leave("b")
+
+	// 长时间没有刷新的访问者让出位置
+	later := now.Add(2 * room.timeout)
+	if ok, pos := room.enter("d", later); !ok || pos != 0 {
+		t.Fatalf("d should take the place of the stale c: admitted=%v pos=%d", ok, pos)
+	}
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	st := &state.State{Mode: state.ModePublic, WaitingRoom: 1}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := srv.waitingRoomMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("CF-Connecting-IP") == "203.0.113.1" {
+			close(started)
+			<-release
+		}
+		srv.handleRequest(w, r)
+	}))
+
+	go func() {
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		req.Header.Set("CF-Connecting-IP", "203.0.113.1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set("CF-Connecting-IP", "203.0.113.2")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" || !contains(w.Body.String(), `http-equiv="refresh"`) {
+		t.Fatalf("expected queue page, got %d", w.Code)
+	}
+
+	// 列表页不排队
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("CF-Connecting-IP", "203.0.113.2")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("listing should not queue, got %d", w.Code)
+	}
+	close(release)
+}
+
+func TestAuthLockout(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	st := &state.State{MaxAuthFailures: 3}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	srv.attempts = newRequestLimiter(100) // 只测锁定，不触发错误口令限速
+	authed := srv.authFailureMiddleware(auth.BasicAuthMiddleware("user", "secret", http.HandlerFunc(srv.handleRequest)))
+	handler := srv.banMiddleware(authed)
+
+	try := func(ip, pass string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("CF-Connecting-IP", ip)
+		if pass != "" {
+			req.SetBasicAuth("user", pass)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 没有带口令的请求 (浏览器首次访问) 不计为失败
+	for i := 0; i < 5; i++ {
+		try("203.0.113.1", "")
+	}
+	if code := try("203.0.113.1", "secret"); code != http.StatusOK {
+		t.Fatalf("prompts should not count as failures, got %d", code)
+	}
+
+	// 登录成功会清零计数
+	try("203.0.113.1", "wrong")
+	try("203.0.113.1", "wrong")
+	try("203.0.113.1", "secret")
+	try("203.0.113.1", "wrong")
+	try("203.0.113.1", "wrong")
+	if code := try("203.0.113.1", "secret"); code != http.StatusOK {
+		t.Fatalf("successful login should reset failures, got %d", code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := try("203.0.113.2", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d", i+1, code)
+		}
+	}
+	if code := try("203.0.113.2", "secret"); code != http.StatusForbidden {
+		t.Errorf("locked out IP should be refused even with the right password, got %d", code)
+	}
+	if code := try("203.0.113.1", "secret"); code != http.StatusOK {
+		t.Errorf("other IPs should not be affected, got %d", code)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpHome, ".cfshare", "access.log"))
+	if !contains(string(data), `"kind":"lockout"`) || !contains(string(data), "203.0.113.2") {
+		t.Errorf("expected lockout event in access log: %s", data)
+	}
+}
+
+func TestReceiptRequiresDownload(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	config.EnsureConfigDir()
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("contract"), 0644)
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Mode: state.ModePublic, Receipts: true, Lang: "en"})
+	handler := srv.loggingMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	do := func(method, target, ip string, form url.Values, origin string) *httptest.ResponseRecorder {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req := httptest.NewRequest(method, target, body)
+		req.Header.Set("CF-Connecting-IP", ip)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	token := func(ip string) url.Values {
+		expires := time.Now().Add(time.Hour)
+		return url.Values{
+			"exp": {strconv.FormatInt(expires.Unix(), 10)},
+			"sig": {auth.SignPath(srv.formKey, receiptFormPath(ip, "/a.txt"), expires)},
+		}
+	}
+
+	// 没有下载过时不显示确认表单，也不能直接签收
+	if w := do("GET", "/a.txt?receipt=1", "203.0.113.1", nil, ""); contains(w.Body.String(), `name="sig"`) {
+		t.Error("receipt form should not be offered before the download")
+	}
+	if w := do("POST", "/a.txt?receipt=1", "203.0.113.1", token("203.0.113.1"), ""); w.Code != http.StatusForbidden {
+		t.Errorf("receipt without a download: got %d", w.Code)
+	}
+
+	if w := do("GET", "/a.txt", "203.0.113.1", nil, ""); w.Code != http.StatusOK {
+		t.Fatalf("download: got %d", w.Code)
+	}
+	if w := do("GET", "/a.txt?receipt=1", "203.0.113.1", nil, ""); !contains(w.Body.String(), `name="sig"`) {
+		t.Error("receipt form should be offered after the download")
+	}
+
+	// 跨站提交、没有表单令牌、其他 IP 都不能签收
+	if w := do("POST", "/a.txt?receipt=1", "203.0.113.1", token("203.0.113.1"), "https://evil.example"); w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin POST: got %d", w.Code)
+	}
+	if w := do("POST", "/a.txt?receipt=1", "203.0.113.1", url.Values{"name": {"x"}}, ""); w.Code != http.StatusForbidden {
+		t.Errorf("POST without form token: got %d", w.Code)
+	}
+	if w := do("POST", "/a.txt?receipt=1", "203.0.113.2", token("203.0.113.1"), ""); w.Code != http.StatusForbidden {
+		t.Errorf("POST from another IP: got %d", w.Code)
+	}
+
+	w := do("POST", "/a.txt?receipt=1", "203.0.113.1", token("203.0.113.1"), "http://example.com")
+	if w.Code != http.StatusOK || !contains(w.Body.String(), "Receipt recorded") {
+		t.Errorf("receipt after download: got %d\n%s", w.Code, w.Body.String())
+	}
+}
+
+func TestStatsRecorderBatches(t *testing.T) {
+	backends := []string{state.StatsBackendJSON}
+	if _, err := exec.LookPath("sqlite3"); err == nil {
+		backends = append(backends, state.StatsBackendSQLite)
+	}
+	for _, backend := range backends {
+		t.Setenv("HOME", t.TempDir())
+		config.EnsureConfigDir()
+
+		rec := newStatsRecorder(&state.State{StatsBackend: backend})
+		agent := `x'); DROP TABLE records; --`
+		for i := 0; i < 3; i++ {
+			rec.record(state.AccessRecord{Time: time.Now(), Path: "/a", StatusCode: 200, UserAgent: agent})
+		}
+		rec.flush()
+
+		records, err := rec.store.Records(time.Time{})
+		if err != nil || len(records) != 3 {
+			t.Fatalf("%s: expected 3 records after flush, got %d (%v)", backend, len(records), err)
+		}
+		if records[2].UserAgent != agent {
+			t.Errorf("%s: user agent stored as %q", backend, records[2].UserAgent)
+		}
+	}
+}
+
+func TestAuthAttemptRate(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+
+	tmpDir := t.TempDir()
+	srv, _ := NewServer([]string{tmpDir}, &state.State{})
+	checks := 0
+	inner := auth.BasicAuthMiddleware("user", "secret", http.HandlerFunc(srv.handleRequest))
+	handler := srv.authFailureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks++
+		inner.ServeHTTP(w, r)
+	}))
+
+	try := func(ip, pass string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("CF-Connecting-IP", ip)
+		req.SetBasicAuth("user", pass)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 不设 --max-auth-failures 和 --req-rate 时错误口令同样限速，超出后不再校验口令
+	for i := 0; i < 2*config.AuthAttemptRate; i++ {
+		if code := try("203.0.113.1", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d", i+1, code)
+		}
+	}
+	if code := try("203.0.113.1", "wrong"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 after the burst, got %d", code)
+	}
+	if checks != 2*config.AuthAttemptRate {
+		t.Errorf("password checked %d times, want %d", checks, 2*config.AuthAttemptRate)
+	}
+	if code := try("203.0.113.2", "secret"); code != http.StatusOK {
+		t.Errorf("other IPs should not be affected, got %d", code)
+	}
+	// 口令正确时退还令牌，不影响之后的请求
+	for i := 0; i < 3*config.AuthAttemptRate; i++ {
+		if code := try("203.0.113.2", "secret"); code != http.StatusOK {
+			t.Fatalf("request %d with the right password: got %d", i+1, code)
+		}
+	}
+
+	// 同一 IP 的并发错误口令在校验之前就被限速
+	checks = 0
+	var mu sync.Mutex
+	release := make(chan struct{})
+	slow := srv.authFailureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		checks++
+		mu.Unlock()
+		<-release
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("CF-Connecting-IP", "203.0.113.3")
+			req.SetBasicAuth("user", "wrong")
+			slow.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if checks != 2*config.AuthAttemptRate {
+		t.Errorf("concurrent attempts checked %d times, want %d", checks, 2*config.AuthAttemptRate)
+	}
+}
+
+func TestAuthFailuresSweep(t *testing.T) {
+	f := newAuthFailures(time.Minute)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		f.fail(fmt.Sprintf("10.0.0.%d", i), now)
+	}
+	if n := f.fail("10.0.1.1", now.Add(2*time.Minute)); n != 1 {
+		t.Errorf("failures = %d, want 1", n)
+	}
+	if len(f.times) != 1 {
+		t.Errorf("%d IPs kept after their window expired", len(f.times))
+	}
+}
+
+func TestRequestRate(t *testing.T) {
+	l := newRequestLimiter(2)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		if !l.allow("a", now) {
+			t.Fatalf("request %d within burst was refused", i+1)
+		}
+	}
+	if l.allow("a", now) {
+		t.Error("request over the burst should be refused")
+	}
+	if !l.allow("b", now) {
+		t.Error("other IPs have their own bucket")
+	}
+	if !l.allow("a", now.Add(time.Second)) {
+		t.Error("tokens should refill over time")
+	}
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Mode: state.ModePublic, ReqRate: 1})
+	handler := srv.requestRateMiddleware(http.HandlerFunc(srv.handleRequest))
+	var codes []int
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("unexpected status codes: %v", codes)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Mode: state.ModePublic, Approve: true, OwnerToken: "owner-token"})
+	approved := srv.approveMiddleware(http.HandlerFunc(srv.handleRequest))
+	handler := srv.ownerMiddleware(approved, approved)
+
+	do := func(ip string, owner bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/a.txt", nil)
+		req.Header.Set("CF-Connecting-IP", ip)
+		if owner {
+			req.Header.Set(ownerHeader, "owner-token")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// 新访问者进入等待队列，看到自动刷新的等待页面
+	w := do("203.0.113.1", false)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `http-equiv="refresh"`) {
+		t.Fatalf("new visitor: got %d %q, want the approval page", w.Code, w.Body.String())
+	}
+	if pending := state.PendingVisitors(); len(pending) != 1 || pending[0].IP != "203.0.113.1" || pending[0].Path != "/a.txt" {
+		t.Fatalf("pending visitors = %+v", pending)
+	}
+	do("203.0.113.1", false)
+	if pending := state.PendingVisitors(); len(pending) != 1 {
+		t.Fatalf("repeat request queued the visitor again: %+v", pending)
+	}
+
+	// 分享者不需要批准
+	if w := do("198.51.100.1", true); w.Code != http.StatusOK {
+		t.Errorf("owner: got %d, want 200", w.Code)
+	}
+
+	if _, err := state.DecideVisitors("203.0.113.1", state.VisitorApproved); err != nil {
+		t.Fatal(err)
+	}
+	if w := do("203.0.113.1", false); w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("approved visitor: got %d %q", w.Code, w.Body.String())
+	}
+
+	do("203.0.113.2", false)
+	if _, err := state.DecideVisitors("all", state.VisitorDenied); err != nil {
+		t.Fatal(err)
+	}
+	if w := do("203.0.113.2", false); w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "refresh") {
+		t.Errorf("denied visitor: got %d %q, want plain 403", w.Code, w.Body.String())
+	}
+	if w := do("203.0.113.1", false); w.Code != http.StatusOK {
+		t.Errorf("deny all affected an approved visitor: got %d", w.Code)
+	}
+}
+
+func TestAskName(t *testing.T) {
+	tmpHome := t.TempDir()
+	t.Setenv("HOME", tmpHome)
+	os.MkdirAll(filepath.Join(tmpHome, ".cfshare"), 0700)
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+	srv, _ := NewServer([]string{tmpDir}, &state.State{Mode: state.ModePublic, AskName: true})
+	handler := srv.loggingMiddleware(srv.askNameMiddleware(http.HandlerFunc(srv.handleRequest)))
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// 目录列表照常浏览，下载时要求填写姓名
+	if w := do(httptest.NewRequest("GET", "/", nil)); w.Code != http.StatusOK {
+		t.Errorf("listing: got %d, want 200", w.Code)
+	}
+	w := do(httptest.NewRequest("GET", "/a.txt", nil))
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), `name="name"`) {
+		t.Fatalf("download without a name: got %d, want the name page", w.Code)
+	}
+
+	req := httptest.NewRequest("POST", namePath, strings.NewReader("name=+&next=/a.txt"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if w := do(req); w.Code != http.StatusBadRequest {
+		t.Errorf("blank name: got %d, want 400", w.Code)
+	}
+
+	req = httptest.NewRequest("POST", namePath, strings.NewReader("name=+Alice+Chen%0A&next=/a.txt"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = do(req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/a.txt" {
+		t.Fatalf("submit name: got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("expected name cookie")
+	}
+
+	req = httptest.NewRequest("GET", "/a.txt", nil)
+	req.AddCookie(cookies[0])
+	if w := do(req); w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Fatalf("download with a name: got %d %q", w.Code, w.Body.String())
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpHome, ".cfshare", "access.log"))
+	if !strings.Contains(string(data), `"event":"name_entered"`) || !strings.Contains(string(data), `"visitor_name":"Alice Chen"`) {
+		t.Errorf("expected the name in the access log, got:\n%s", data)
+	}
+	if strings.Count(string(data), `"visitor_name":"Alice Chen"`) < 2 {
+		t.Errorf("expected the download to be logged with the visitor name, got:\n%s", data)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("data"), 0644)
+
+	st := &state.State{Mode: state.ModePublic, Slug: "x9f3kq7m", Lang: "en"}
+	srv, _ := NewServer([]string{tmpDir}, st)
+	handler := srv.mountMiddleware(http.HandlerFunc(srv.handleRequest))
+
+	for path, want := range map[string]int{
+		"/x9f3kq7m/a.txt":  http.StatusOK,
+		"/x9f3kq7m":        http.StatusMovedPermanently,
+		"/a.txt":           http.StatusNotFound,
+		"/":                http.StatusNotFound,
+		"/x9f3kq7/a.txt":   http.StatusNotFound,
+		"/x9f3kq7mz/a.txt": http.StatusNotFound,
+		"/__status__":      http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/x9f3kq7m/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if body := w.Body.String(); w.Code != http.StatusOK || !contains(body, `href="/x9f3kq7m/a.txt"`) {
+		t.Errorf("expected listing with slug links, got %d %s", w.Code, body)
+	}
+
+	// 随机路径在挂载前缀之下，令牌链接在随机路径之下
+	st = &state.State{Mode: state.ModeProtected, Auth: state.AuthToken, LinkToken: "tok", Mount: "/docs", Slug: "x9f3kq7m"}
+	if root := (&Server{state: st}).root(); root != "/docs/x9f3kq7m/t/tok" {
+		t.Errorf("root = %q", root)
+	}
+}
+
+func TestListen(t *testing.T) {
+	ln, err := Listen(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// 残留的服务进程或另一个分享占用端口时，正常启动必须失败，不能与它共用端口
+	if second, err := Listen(port); err == nil {
+		second.Close()
+		t.Fatalf("second listener on busy port %d succeeded", port)
+	}
+
+	// 平滑升级时新进程接管旧进程传来的监听 socket
+	f, err := ListenerFile(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(config.ListenFDEnv, fmt.Sprint(f.Fd()))
+	inherited, err := Listen(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if got := inherited.Addr().(*net.TCPAddr).Port; got != port {
+		t.Errorf("inherited listener port = %d, want %d", got, port)
+	}
+	if os.Getenv(config.ListenFDEnv) != "" {
+		t.Error("listen fd env not cleared")
+	}
+}
+
+func TestRangeCoverage(t *testing.T) {
+	c := newRangeCoverage()
+	if c.add("/f", 10, byteRange{0, 4}) || c.add("/f", 10, byteRange{6, 10}) {
+		t.Fatal("partial coverage reported as complete")
+	}
+	if !c.add("/f", 10, byteRange{3, 7}) {
+		t.Error("ranges covering the whole file should complete the download")
+	}
+	if len(c.files) != 0 {
+		t.Error("coverage should be cleared after a complete download")
+	}
+	if !c.add("/g", 10, parseRanges("bytes=0-4,-5", 10)...) {
+		t.Error("multipart ranges covering the whole file should complete the download")
+	}
+	if c.add("/h", 10, parseRanges("bytes=abc", 10)...) {
+		t.Error("invalid ranges should not count")
+	}
+}
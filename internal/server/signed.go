@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/state"
+)
+
+// signedLinkUser 是通过签名链接访问时记录的访问者身份
+const signedLinkUser = "signed-link"
+
+// signedLinkMiddleware 允许带有效签名 (?exp=&sig=) 的请求跳过口令认证，
+// 直接访问签名对应的单个分享项。其他请求交给 authed 处理。
+func (s *Server) signedLinkMiddleware(authed, inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.validSignedLink(r) {
+			inner.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), signedLinkUser)))
+			return
+		}
+		authed.ServeHTTP(w, r)
+	})
+}
+
+// canLink 判断能否生成免口令的直接下载链接: 公开分享总是可以，需要口令的分享需要签名密钥
+func (s *Server) canLink() bool {
+	return s.state.Mode != state.ModeProtected || s.state.LinkSecret != ""
+}
+
+// directLink 返回文件的完整直接下载链接，需要口令的分享附带到 expires 为止有效的签名
+func (s *Server) directLink(base, urlPath string, expires time.Time) string {
+	q := url.Values{}
+	if isMarkdown(urlPath) {
+		// 不带参数的 Markdown 请求会渲染为网页
+		q.Set("raw", "1")
+	}
+	if s.state.Mode == state.ModeProtected {
+		q.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+		q.Set("sig", auth.SignPath(s.state.LinkSecret, urlPath, expires))
+	}
+	link := base + (&url.URL{Path: urlPath}).EscapedPath()
+	if len(q) > 0 {
+		link += "?" + q.Encode()
+	}
+	return link
+}
+
+// validSignedLink 校验签名；目录只允许打包下载，不能借签名浏览目录内容
+func (s *Server) validSignedLink(r *http.Request) bool {
+	q := r.URL.Query()
+	if !q.Has("sig") || !auth.VerifyPath(s.state.LinkSecret, r.URL.Path, q.Get("exp"), q.Get("sig")) {
+		return false
+	}
+
+	fullPath, err := s.resolve(r.URL.Path)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		_, ok := archiveFormatFromQuery(r)
+		return ok
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+)
+
+// speedtestPath 是测速地址: GET 返回 ?mb= 指定大小 (MiB) 的随机数据，POST 读取并丢弃请求体，
+// 用于在传输大文件之前测量经过 tunnel 的实际吞吐量。只接受分享者令牌或
+// cfshare speedtest 生成的签名链接，其他请求得到 404，避免被用来消耗带宽。
+// 测速不经过限速和并发上限，也不计入访问日志和下载统计。
+const speedtestPath = "/__speedtest__"
+
+// speedtestChunk 是重复发送的 1 MiB 随机数据，随机内容不会被 Cloudflare 压缩
+var speedtestChunk = sync.OnceValue(func() []byte {
+	b := make([]byte, 1<<20)
+	rand.Read(b)
+	return b
+})
+
+// SpeedtestQuery 返回测速链接的签名参数 (exp=&sig=)，签名密钥是分享者令牌
+func SpeedtestQuery(ownerToken string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return "exp=" + exp + "&sig=" + auth.SignPath(ownerToken, speedtestPath, expires)
+}
+
+func (s *Server) speedtestAllowed(r *http.Request) bool {
+	if s.validOwnerToken(ownerToken(r)) {
+		return true
+	}
+	q := r.URL.Query()
+	return auth.VerifyPath(s.state.OwnerToken, speedtestPath, q.Get("exp"), q.Get("sig"))
+}
+
+func (s *Server) handleSpeedtest(w http.ResponseWriter, r *http.Request) {
+	if !s.speedtestAllowed(r) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		size := int64(config.SpeedtestDefaultMB) << 20
+		if mb, err := strconv.Atoi(r.URL.Query().Get("mb")); err == nil && mb > 0 {
+			size = int64(mb) << 20
+		}
+		size = min(size, config.SpeedtestMaxBytes)
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if r.Method == http.MethodHead {
+			return
+		}
+		chunk := speedtestChunk()
+		for sent := int64(0); sent < size; {
+			n := min(int64(len(chunk)), size-sent)
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return
+			}
+			sent += n
+		}
+
+	case http.MethodPost, http.MethodPut:
+		start := time.Now()
+		n, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, config.SpeedtestMaxBytes))
+		if err != nil {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		elapsed := time.Since(start)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"bytes":            n,
+			"seconds":          elapsed.Seconds(),
+			"bytes_per_second": int64(float64(n) / max(elapsed.Seconds(), 0.001)),
+		})
+
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST, PUT")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// isSplitRequest 判断是否请求分卷下载 (?zip=split)
+func isSplitRequest(r *http.Request) bool {
+	return r.URL.Query().Get("zip") == "split"
+}
+
+// splitEntry 是分卷中的一个文件
+type splitEntry struct {
+	rel  string
+	path string
+	info fs.FileInfo
+}
+
+// splitPlan 把目录中的文件按遍历顺序依次分配到各个分卷，每卷的原始大小不超过 partSize
+// (单个文件超过 partSize 时独占一卷)。同样的目录内容总是得到同样的分卷。
+type splitPlan struct {
+	ID    string // 目录内容的指纹，内容变化后旧的分卷链接失效
+	Parts [][]splitEntry
+	Total int64
+}
+
+func planSplit(dir string, skip func(rel string) bool, partSize int64) (*splitPlan, error) {
+	plan := &splitPlan{}
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", partSize)
+
+	var current []splitEntry
+	var size int64
+	err := walkArchiveEntries(dir, skip, func(rel, path string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", rel, info.Size(), info.ModTime().UnixNano())
+
+		if len(current) > 0 && size+info.Size() > partSize {
+			plan.Parts = append(plan.Parts, current)
+			current, size = nil, 0
+		}
+		current = append(current, splitEntry{rel: rel, path: path, info: info})
+		size += info.Size()
+		plan.Total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(current) > 0 || len(plan.Parts) == 0 {
+		plan.Parts = append(plan.Parts, current)
+	}
+	plan.ID = hex.EncodeToString(h.Sum(nil))[:12]
+	return plan, nil
+}
+
+// splitManifest 是分卷清单 (?zip=split) 的 JSON 格式
+type splitManifest struct {
+	Name       string      `json:"name"`
+	ID         string      `json:"id"`
+	PartSize   int64       `json:"part_size"`
+	TotalBytes int64       `json:"total_bytes"`
+	Parts      []splitPart `json:"parts"`
+}
+
+type splitPart struct {
+	Part  int    `json:"part"`
+	URL   string `json:"url"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// serveSplitArchive 处理分卷下载: 没有 part 参数时返回清单，否则返回第 part 卷。
+// 每一卷都是独立的 ZIP，解压到同一目录即可还原。
+func (s *Server) serveSplitArchive(w http.ResponseWriter, r *http.Request, dir string, skip func(rel string) bool) {
+	partSize := s.state.SplitSize
+	if partSize <= 0 {
+		partSize = config.DefaultSplitSize
+	}
+
+	plan, err := planSplit(dir, skip, partSize)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	name := filepath.Base(dir)
+
+	q := r.URL.Query()
+	if !q.Has("part") {
+		s.serveSplitManifest(w, r, name, plan, partSize)
+		return
+	}
+
+	n, err := strconv.Atoi(q.Get("part"))
+	if err != nil || n < 1 || n > len(plan.Parts) {
+		http.NotFound(w, r)
+		return
+	}
+	if v := q.Get("v"); v != "" && v != plan.ID {
+		http.Error(w, "Directory changed since the manifest was created; reload the manifest", http.StatusConflict)
+		return
+	}
+
+	serveArchive(w, r, fmt.Sprintf("%s.part%02d", name, n), formatZip, func(aw archiveWriter) error {
+		for _, e := range plan.Parts[n-1] {
+			if err := aw.addFile(name+"/"+e.rel, e.path, e.info); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Server) serveSplitManifest(w http.ResponseWriter, r *http.Request, name string, plan *splitPlan, partSize int64) {
+	manifest := splitManifest{
+		Name:       name,
+		ID:         plan.ID,
+		PartSize:   partSize,
+		TotalBytes: plan.Total,
+	}
+	for i, entries := range plan.Parts {
+		part := splitPart{
+			Part:  i + 1,
+			URL:   fmt.Sprintf("%s?zip=split&part=%d&v=%s", s.mounted(r.URL.EscapedPath()), i+1, plan.ID),
+			Files: len(entries),
+		}
+		for _, e := range entries {
+			part.Bytes += e.info.Size()
+		}
+		manifest.Parts = append(manifest.Parts, part)
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if wantsJSON(r) {
+		body, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		serveGenerated(w, r, time.Time{}, "application/json; charset=utf-8", append(body, '\n'))
+		return
+	}
+
+	lang := s.pageLang(w, r)
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("split").Funcs(template.FuncMap{
+		"formatSize": formatSize,
+	}).Parse(splitTemplate))
+	if err := tmpl.Execute(&buf, struct {
+		Lang     string
+		PartSize string
+		T        func(key string, args ...any) string
+		Manifest splitManifest
+	}{
+		Lang:     lang,
+		PartSize: formatSize(partSize),
+		T:        translator(lang),
+		Manifest: manifest,
+	}); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	serveHTML(w, r, time.Time{}, buf.Bytes())
+}
+
+const splitTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Manifest.Name}} - {{call .T "split_title"}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 640px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        h1 {
+            margin: 0;
+            padding: 20px;
+            background: #2563eb;
+            color: white;
+            font-size: 18px;
+            font-weight: 500;
+        }
+        .hint { padding: 15px 20px; color: #6b7280; font-size: 14px; border-bottom: 1px solid #eee; }
+        table { width: 100%; border-collapse: collapse; }
+        td { padding: 12px 20px; border-bottom: 1px solid #eee; font-size: 14px; }
+        td.meta { color: #6b7280; text-align: right; }
+        a { color: #2563eb; text-decoration: none; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>📦 {{.Manifest.Name}} · {{call .T "split_title"}}</h1>
+        <div class="hint">{{call .T "split_hint" .PartSize}}</div>
+        <table>
+            {{range .Manifest.Parts}}
+            <tr>
+                <td><a href="{{.URL}}">⬇️ {{call $.T "split_part" .Part (len $.Manifest.Parts)}}</a></td>
+                <td class="meta">{{call $.T "split_files" .Files}} · {{formatSize .Bytes}}</td>
+            </tr>
+            {{end}}
+        </table>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/state"
+)
+
+// statsRecorder 把每个请求写入聚合统计 (stats.json) 和原始访问记录 (--stats-backend)，
+// 并每隔 config.StatsPruneInterval 清理超过 --stats-retention 的原始记录。
+// 原始记录先进入缓冲区，由后台 goroutine 每隔 config.StatsFlushInterval 批量写入，
+// sqlite 存储每批只启动一次 sqlite3，不在处理请求时等待。
+type statsRecorder struct {
+	store     state.StatsStore
+	retention time.Duration // 0 表示永久保留
+
+	pending chan state.AccessRecord
+	flushed chan chan struct{} // flush 请求，处理完缓冲区后关闭其中的 channel
+	dropped atomic.Int64       // 缓冲区满时丢弃的记录数
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// newStatsRecorder 打开分享的原始访问记录存储，打不开时 (如 sqlite3 被卸载) 退回 json
+func newStatsRecorder(st *state.State) *statsRecorder {
+	store, err := state.OpenStatsStore(st.StatsBackend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[stats] %v，改用 json 保存访问记录\n", err)
+		store, _ = state.OpenStatsStore(state.StatsBackendJSON)
+	}
+	s := &statsRecorder{
+		store:     store,
+		retention: st.RecordRetention(),
+		pending:   make(chan state.AccessRecord, config.StatsBufferSize),
+		flushed:   make(chan chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *statsRecorder) record(r state.AccessRecord) {
+	state.UpdateAccessStats(r)
+	select {
+	case s.pending <- r:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// flush 等待缓冲区中的记录写入存储，服务进程退出前调用
+func (s *statsRecorder) flush() {
+	done := make(chan struct{})
+	s.flushed <- done
+	<-done
+}
+
+// run 在后台批量写入原始记录
+func (s *statsRecorder) run() {
+	ticker := time.NewTicker(config.StatsFlushInterval)
+	defer ticker.Stop()
+	var batch []state.AccessRecord
+	write := func() {
+		for drained := false; !drained; {
+			select {
+			case r := <-s.pending:
+				batch = append(batch, r)
+			default:
+				drained = true
+			}
+		}
+		if n := s.dropped.Swap(0); n > 0 {
+			fmt.Fprintf(os.Stderr, "[stats] 访问记录缓冲区已满，丢弃了 %d 条记录\n", n)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.store.Append(batch...); err != nil {
+			fmt.Fprintf(os.Stderr, "[stats] 保存访问记录失败: %v\n", err)
+		}
+		now := batch[len(batch)-1].Time
+		batch = batch[:0]
+		if s.retention > 0 && s.pruneDue(now) {
+			if _, err := s.store.Prune(now.Add(-s.retention)); err != nil {
+				fmt.Fprintf(os.Stderr, "[stats] 清理访问记录失败: %v\n", err)
+			}
+		}
+	}
+	for {
+		select {
+		case <-ticker.C:
+			write()
+		case done := <-s.flushed:
+			write()
+			close(done)
+		}
+	}
+}
+
+// pruneDue 判断是否到了清理的时间，服务进程启动后的第一批记录写入后即清理一次
+func (s *statsRecorder) pruneDue(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastPrune) < config.StatsPruneInterval {
+		return false
+	}
+	s.lastPrune = now
+	return true
+}
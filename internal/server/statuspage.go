@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+// statusPath 是 --status-page 开启时的公开状态页，不需要口令，只显示分享是否可用、
+// 何时到期和联系方式，不透露分享的任何内容
+const statusPath = "/__status__"
+
+// 分享的可用状态
+const (
+	statusActive  = "active"  // 正常提供下载
+	statusExpired = "expired" // 已超过 --expire 的结束时间
+	statusEnded   = "ended"   // 下载次数已满或一次性分享已被下载
+)
+
+// shareStatus 是状态页显示的内容，?format=json 时原样输出
+type shareStatus struct {
+	Status    string     `json:"status"`
+	Active    bool       `json:"active"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"` // 分享者停止分享的时间，只由 --ended-grace 的提示进程填写
+	Contact   string     `json:"contact,omitempty"`
+}
+
+// currentStatus 返回分享当前的可用状态
+func (s *Server) currentStatus() shareStatus {
+	st := shareStatus{Status: statusActive, Contact: s.state.Contact}
+	if stopAt := s.expiry.get(); !stopAt.IsZero() {
+		st.ExpiresAt = &stopAt
+	}
+
+	s.downloads.mu.Lock()
+	limitReached := s.downloads.done
+	s.downloads.mu.Unlock()
+
+	switch {
+	case s.expired():
+		st.Status = statusExpired
+	case limitReached, s.state.Burn == state.BurnShare && state.IsBurned(s.state.ShareID, state.BurnShareKey):
+		st.Status = statusEnded
+	}
+	st.Active = st.Status == statusActive
+	return st
+}
+
+// statusPage 是状态页模板的数据
+type statusPage struct {
+	Lang        string
+	Status      string
+	Active      bool
+	ExpiresAt   string
+	EndedAt     string
+	Contact     string
+	ContactLink string
+	ThemeCSS    template.CSS
+}
+
+// T 返回当前页面语言的文案，供状态页模板使用
+func (p statusPage) T(key string, args ...any) string {
+	return translator(p.Lang)(key, args...)
+}
+
+// handleStatusPage 输出公开状态页，不经过认证和访问日志
+func (s *Server) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeStatusPage(w, r, http.StatusOK)
+}
+
+// writeStatusPage 以 code 输出状态页，分享到期后浏览器访问其他路径时也用它代替纯文本的 410
+func (s *Server) writeStatusPage(w http.ResponseWriter, r *http.Request, code int) {
+	s.renderStatus(w, r, code, s.currentStatus())
+}
+
+// renderStatus 以 code 输出 current 对应的状态页，?format=json 时输出 JSON
+func (s *Server) renderStatus(w http.ResponseWriter, r *http.Request, code int, current shareStatus) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !s.state.AllowIndexing {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive")
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(current)
+		return
+	}
+
+	lang := s.pageLang(w, r)
+	page := statusPage{
+		Lang:        lang,
+		Status:      current.Status,
+		Active:      current.Active,
+		Contact:     current.Contact,
+		ContactLink: contactLink(current.Contact),
+		ThemeCSS:    themeCSS(s.state.Theme),
+	}
+	if current.ExpiresAt != nil {
+		page.ExpiresAt = current.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC")
+	}
+	if current.EndedAt != nil {
+		page.EndedAt = current.EndedAt.UTC().Format("2006-01-02 15:04 UTC")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	statusPageTemplate.Execute(w, page)
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.T "status_title"}}</title>
+    <style>
+` + cardStyle + `{{.ThemeCSS}}
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>{{if .Active}}✅{{else}}⏹{{end}} {{.T (print "status_" .Status)}}</h1>
+        <div class="body">
+            {{if .Active}}<p>{{if .ExpiresAt}}{{.T "status_expires" .ExpiresAt}}{{else}}{{.T "status_no_expiry"}}{{end}}</p>
+            {{else if eq .Status "expired"}}<p>{{.T "status_expired_at" .ExpiresAt}}</p>
+            {{else if .EndedAt}}<p>{{.T "status_stopped_msg" .EndedAt}}</p>
+            {{else}}<p>{{.T "status_ended_msg"}}</p>{{end}}
+            {{if .Contact}}
+            <div class="contact">
+                {{.T "error_contact"}}
+                {{if .ContactLink}}<a href="{{.ContactLink}}">{{.Contact}}</a>{{else}}{{.Contact}}{{end}}
+            </div>
+            {{end}}
+        </div>
+    </div>
+</body>
+</html>`))
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"cfshare/internal/state"
+)
+
+// StdinPath 是分享标准输入时使用的路径 (cfshare - < backup.tar.gz)
+const StdinPath = "-"
+
+// defaultStreamName 是标准输入流未指定 --as 时的下载文件名
+const defaultStreamName = "stdin"
+
+// IsStreamPath 判断路径是否应作为流分享: 标准输入或命名管道 (FIFO)
+func IsStreamPath(path string) bool {
+	if path == StdinPath {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// StreamItem 返回流分享的分享项。标准输入的名称来自 --as，命名管道使用文件名。
+func StreamItem(path, name string) state.ShareItem {
+	if path != StdinPath {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if name == "" {
+			name = filepath.Base(path)
+		}
+	}
+	if name == "" {
+		name = defaultStreamName
+	}
+	return state.ShareItem{Path: path, Name: name, ShareType: state.TypeStream}
+}
+
+// streamSource 是只能读取一次的数据源，第一个下载请求取走全部内容，之后的请求返回 410
+type streamSource struct {
+	open  func() (io.ReadCloser, error)
+	taken atomic.Bool
+}
+
+func newStreamSource(path string) *streamSource {
+	if path == StdinPath {
+		return &streamSource{open: func() (io.ReadCloser, error) { return os.Stdin, nil }}
+	}
+	// 命名管道在有写入方之前 Open 会阻塞，因此推迟到第一个请求时再打开
+	return &streamSource{open: func() (io.ReadCloser, error) { return os.Open(path) }}
+}
+
+// serveStream 把流的内容原样发送给第一个下载请求。长度未知，不支持断点续传。
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	name := s.shares().items[0].Name
+	if r.URL.Path != "/" && r.URL.Path != "/"+name {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.stream.taken.Load() {
+		http.Error(w, "This stream has already been downloaded", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+	w.Header().Set("Cache-Control", "no-store")
+	// HEAD 不消耗数据，链接预览等探测请求不会取走内容
+	if r.Method == http.MethodHead {
+		return
+	}
+	if !s.stream.taken.CompareAndSwap(false, true) {
+		http.Error(w, "This stream has already been downloaded", http.StatusGone)
+		return
+	}
+
+	src, err := s.stream.open()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+
+	start := time.Now()
+	n, err := io.Copy(w, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[stream] %s: sent %d bytes to %s before error: %v\n", name, n, clientIP(r), err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[stream] %s: sent %d bytes to %s in %s\n", name, n, clientIP(r), time.Since(start).Round(time.Second))
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 覆盖模板的文件名，放在 ~/.cfshare/templates/ 下
+const (
+	dirTemplateName   = "dir.html"   // 目录列表
+	errorTemplateName = "error.html" // 401/403/404 错误页
+)
+
+// themes 是内置主题，值为追加到目录列表样式之后的 CSS
+var themes = map[string]string{
+	"default": "",
+	"dark": `
+        body { background: #0f172a; color: #e2e8f0; }
+        .container { background: #1e293b; box-shadow: none; }
+        h1 { background: #334155; }
+        th { background: #1e293b; color: #94a3b8; }
+        th, td, .back, .search, .gallery, .readme { border-color: #334155; }
+        tr:hover { background: #273549; }
+        a, .gallery a { color: #93c5fd; }
+        .size, .time, .checksum, .checksum a, .pager { color: #94a3b8; }
+        .search input { background: #0f172a; color: #e2e8f0; border-color: #475569; }
+        .gallery img { background: #0f172a; }
+        .markdown code, .markdown pre { background: #0f172a; }
+`,
+	"minimal": `
+        body { background: white; padding: 10px; }
+        .container { box-shadow: none; border-radius: 0; }
+        h1 { background: none; color: #111827; padding: 10px 0; border-bottom: 2px solid #111827; }
+        th { background: none; }
+        th, td { padding: 8px 4px; }
+        .back, .search { padding-left: 0; padding-right: 0; }
+        .icon { display: none; }
+        a { color: #111827; text-decoration: underline; }
+`,
+}
+
+// ValidTheme 判断是否为内置主题名
+func ValidTheme(name string) bool {
+	_, ok := themes[name]
+	return ok
+}
+
+// ThemeNames 返回按名称排序的内置主题列表
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// themeCSS 返回主题的样式，未知主题返回空
+func themeCSS(name string) template.CSS {
+	return template.CSS(themes[name])
+}
+
+// dirTemplateFuncs 是目录列表模板可用的函数，覆盖模板同样可以使用
+func dirTemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"formatSize": formatSize,
+		"formatTime": func(t time.Time) string { return t.Format("2006-01-02 15:04 -07:00") },
+		"isoTime":    func(t time.Time) string { return t.Format(time.RFC3339) },
+		"mediaKind":  mediaKind,
+		"isImage":    isImageName,
+	}
+}
+
+var defaultDirTemplate = template.Must(template.New("dir").Funcs(dirTemplateFuncs()).Parse(dirTemplate))
+
+// templateLoader 加载用户的覆盖模板，文件修改后自动重新解析。
+// 模板不存在或解析失败时回退到内置模板。
+type templateLoader struct {
+	dir string
+
+	mu     sync.Mutex
+	loaded map[string]*loadedTemplate // 文件名 -> 已解析的覆盖模板
+}
+
+type loadedTemplate struct {
+	modTime time.Time
+	tmpl    *template.Template // 为 nil 表示解析失败，使用内置模板
+}
+
+func newTemplateLoader(dir string) *templateLoader {
+	return &templateLoader{dir: dir, loaded: make(map[string]*loadedTemplate)}
+}
+
+// dirTemplate 返回目录列表使用的模板，以及是否为用户覆盖模板
+func (l *templateLoader) dirTemplate() (*template.Template, bool) {
+	return l.load(dirTemplateName, defaultDirTemplate)
+}
+
+// errorTemplate 返回错误页使用的模板，以及是否为用户覆盖模板
+func (l *templateLoader) errorTemplate() (*template.Template, bool) {
+	return l.load(errorTemplateName, defaultErrorTemplate)
+}
+
+// load 返回 ~/.cfshare/templates/<name> 解析后的模板，不存在或解析失败时返回 fallback
+func (l *templateLoader) load(name string, fallback *template.Template) (*template.Template, bool) {
+	path := filepath.Join(l.dir, name)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return fallback, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cached := l.loaded[name]
+	if cached == nil || !info.ModTime().Equal(cached.modTime) {
+		cached = &loadedTemplate{modTime: info.ModTime()}
+		l.loaded[name] = cached
+
+		data, err := os.ReadFile(path)
+		if err == nil {
+			cached.tmpl, err = template.New(name).Funcs(dirTemplateFuncs()).Parse(string(data))
+		}
+		if err != nil {
+			cached.tmpl = nil
+			fmt.Fprintf(os.Stderr, "警告: 无法加载模板 %s，使用内置模板: %v\n", path, err)
+		}
+	}
+
+	if cached.tmpl == nil {
+		return fallback, false
+	}
+	return cached.tmpl, true
+}
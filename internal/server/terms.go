@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	termsPath       = "/__terms__"
+	termsCookieName = "cfshare_terms"
+)
+
+// termsMiddleware 要求访问者先同意条款才能访问分享内容。
+// 同意记录保存在 cookie 中，值与条款内容绑定，条款变更后需要重新同意。
+func (s *Server) termsMiddleware(terms string, next http.Handler) http.Handler {
+	sum := sha256.Sum256([]byte(s.state.ShareID + "\x00" + terms))
+	token := hex.EncodeToString(sum[:16])
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == termsPath {
+			s.handleTerms(w, r, terms, token)
+			return
+		}
+
+		// 条款针对访问者，分享者本人不需要同意
+		if isOwner(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if c, err := r.Cookie(termsCookieName); err == nil && c.Value == token {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.renderTerms(w, s.pageLang(w, r), http.StatusForbidden, terms, r.URL.RequestURI())
+	})
+}
+
+func (s *Server) handleTerms(w http.ResponseWriter, r *http.Request, terms, token string) {
+	if r.Method != http.MethodPost {
+		s.renderTerms(w, s.pageLang(w, r), http.StatusOK, terms, "/")
+		return
+	}
+
+	r.ParseForm()
+	if r.PostFormValue("agree") != "1" {
+		s.renderTerms(w, s.pageLang(w, r), http.StatusForbidden, terms, r.PostFormValue("next"))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     termsCookieName,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	logEntry := map[string]interface{}{
+		"time":        time.Now().UTC().Format(time.RFC3339),
+		"event":       "terms_accepted",
+		"remote_addr": clientIP(r),
+		"user_agent":  r.UserAgent(),
+	}
+	logData, _ := json.Marshal(logEntry)
+	appendToAccessLog(string(logData))
+
+	http.Redirect(w, r, safeRedirect(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+// safeRedirect 只允许跳转到本站的相对路径
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) renderTerms(w http.ResponseWriter, lang string, status int, terms, next string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	tmpl := template.Must(template.New("terms").Parse(termsTemplate))
+	tmpl.Execute(w, struct {
+		Lang   string
+		Terms  string
+		Action string
+		Next   string
+		T      func(key string, args ...any) string
+	}{
+		Lang:   lang,
+		Action: s.mounted(termsPath),
+		T:      translator(lang),
+		Terms:  terms,
+		Next:   safeRedirect(next),
+	})
+}
+
+const termsTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{call .T "terms_title"}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 800px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        h1 {
+            margin: 0;
+            padding: 20px;
+            background: #2563eb;
+            color: white;
+            font-size: 18px;
+            font-weight: 500;
+        }
+        .body { padding: 20px; }
+        .terms {
+            white-space: pre-wrap;
+            font-family: inherit;
+            max-height: 60vh;
+            overflow-y: auto;
+            padding: 15px;
+            background: #f9fafb;
+            border: 1px solid #eee;
+            border-radius: 6px;
+        }
+        button {
+            margin-top: 15px;
+            padding: 10px 20px;
+            background: #2563eb;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            font-size: 14px;
+            cursor: pointer;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>📜 {{call .T "terms_title"}}</h1>
+        <div class="body">
+            <pre class="terms">{{.Terms}}</pre>
+            <form method="post" action="{{.Action}}">
+                <input type="hidden" name="next" value="{{.Next}}">
+                <label><input type="checkbox" name="agree" value="1" required> {{call .T "terms_agree"}}</label>
+                <br>
+                <button type="submit">{{call .T "terms_continue"}}</button>
+            </form>
+        </div>
+    </div>
+</body>
+</html>`
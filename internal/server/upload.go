@@ -0,0 +1,539 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+	"cfshare/internal/i18n"
+	"cfshare/internal/state"
+	"cfshare/internal/storage"
+)
+
+// maxUploadMemory 解析 multipart 表单时使用的内存上限，超出部分写入临时文件
+const maxUploadMemory = 32 << 20
+
+// errTooLarge 表示上传的文件超过单文件大小上限或剩余配额
+var errTooLarge = errors.New("upload too large")
+
+// errNoSpace 表示收件目录所在磁盘写入后剩余空间将少于 config.UploadFreeSpaceReserve
+var errNoSpace = errors.New("not enough free disk space")
+
+// errUploadsClosed 表示已达到上传数量上限
+var errUploadsClosed = errors.New("upload limit reached")
+
+// handleFileRequest 处理文件请求模式: 只提供上传表单，不允许浏览。
+// 除了表单，还可以用 PUT /r/<token>/<name> 直接上传请求体 (如 curl -T file)。
+func (s *Server) handleFileRequest(w http.ResponseWriter, r *http.Request) {
+	rest, ok := strings.CutPrefix(r.URL.Path, "/r/")
+	token, name, _ := strings.Cut(rest, "/")
+	if !ok || !auth.CheckToken(s.state.RequestTokenHash, token) {
+		http.NotFound(w, r)
+		return
+	}
+
+	lang := s.pageLang(w, r)
+	if s.requestClosed() {
+		http.Error(w, i18n.T(lang, "upload_closed"), http.StatusGone)
+		return
+	}
+
+	if name != "" {
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", "PUT")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.receiveRaw(w, r, name)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.renderUploadForm(w, lang, "")
+	case http.MethodPost:
+		s.receiveUploads(w, r, lang)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requestClosed 判断文件请求是否已过期、已达到上传数量上限或配额已用完
+func (s *Server) requestClosed() bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if !s.state.ExpiresAt.IsZero() && time.Now().After(s.state.ExpiresAt) {
+		return true
+	}
+	if s.state.UploadQuota > 0 && s.usedBytes() >= s.state.UploadQuota {
+		return true
+	}
+	if s.storageAvailable() == 0 {
+		return true
+	}
+	return s.state.MaxUploads > 0 && s.countUploads() >= s.state.MaxUploads
+}
+
+// usedBytes 统计收件目录中已上传文件的总大小，正在写入的文件按预留的字节数计算。调用者持有 s.stateMu
+func (s *Server) usedBytes() int64 {
+	dir := s.shares().sharePath
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	total := s.reservedBytes()
+	for _, entry := range entries {
+		if _, ok := s.uploading[filepath.Join(dir, entry.Name())]; ok {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// reservedBytes 返回正在写入的上传预留的字节数之和，调用者持有 s.stateMu
+func (s *Server) reservedBytes() int64 {
+	var total int64
+	for _, n := range s.uploading {
+		total += n
+	}
+	return total
+}
+
+// uploadLimit 返回下一个文件允许的最大字节数，取单文件上限和剩余配额中较小的一个，-1 表示不限。
+// 调用者持有 s.stateMu
+func (s *Server) uploadLimit() int64 {
+	limit := int64(-1)
+	if s.state.MaxFileSize > 0 {
+		limit = s.state.MaxFileSize
+	}
+	if s.state.UploadQuota > 0 {
+		remaining := max(s.state.UploadQuota-s.usedBytes(), 0)
+		if limit < 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	if avail := s.storageAvailable(); avail >= 0 && (limit < 0 || avail < limit) {
+		limit = avail
+	}
+	return limit
+}
+
+// storageAvailable 返回 ~/.cfshare 存储配额内还能上传的字节数 (会先淘汰缓存)。
+// 收件目录不在 ~/.cfshare 下 (cfshare receive <dir>) 或没有配额时返回 -1。调用者持有 s.stateMu
+func (s *Server) storageAvailable() int64 {
+	if storage.Quota <= 0 || !storage.InConfigDir(s.shares().sharePath) {
+		return -1
+	}
+	return max(storage.Available()-s.reservedBytes(), 0)
+}
+
+// diskRoom 返回 dir 所在磁盘在保留 config.UploadFreeSpaceReserve 之后还能写入的字节数，无法查询时返回 -1
+func diskRoom(dir string) int64 {
+	free, err := storage.FreeSpace(dir)
+	if err != nil {
+		return -1
+	}
+	return max(free-config.UploadFreeSpaceReserve, 0)
+}
+
+// uploadSlot 是已经预留的上传: 目标文件已创建，最多写入 limit 字节 (-1 表示不限)
+type uploadSlot struct {
+	f      *os.File
+	limit  int64
+	capErr error // 超过 limit 时返回的错误
+}
+
+// saveUpload 把 src 写入收件目录。size 是已知的文件大小 (-1 表示未知)。
+// 只在检查上限和创建文件时持有 s.stateMu，读取请求体时不持有，慢速的上传不会挡住其他请求。
+func (s *Server) saveUpload(name string, src io.Reader, size int64) (string, error) {
+	s.stateMu.Lock()
+	slot, err := s.reserveUpload(name, size)
+	s.stateMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return s.writeUpload(slot, src)
+}
+
+// reserveUpload 检查上传数量上限 (errUploadsClosed)、大小上限和配额 (errTooLarge) 以及磁盘空间
+// (errNoSpace) 后创建目标文件。预留的字节数 (大小未知时为允许的上限) 计入 usedBytes，
+// 并发的上传看到的剩余配额随之减少。大小未知时写到磁盘只剩保留空间为止。调用者持有 s.stateMu
+func (s *Server) reserveUpload(name string, size int64) (*uploadSlot, error) {
+	if s.state.MaxUploads > 0 && s.countUploads() >= s.state.MaxUploads {
+		return nil, errUploadsClosed
+	}
+	limit := s.uploadLimit()
+	if limit >= 0 && size > limit {
+		return nil, errTooLarge
+	}
+
+	dir := s.shares().sharePath
+	capErr := errTooLarge
+	if room := diskRoom(dir); room >= 0 {
+		room = max(room-s.reservedBytes(), 0)
+		if room == 0 || size > room {
+			return nil, errNoSpace
+		}
+		if limit < 0 || room < limit {
+			limit, capErr = room, errNoSpace
+		}
+	}
+
+	f, err := createUniqueFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	reserved := size
+	if reserved < 0 {
+		reserved = max(limit, 0)
+	}
+	s.uploading[f.Name()] = reserved
+	return &uploadSlot{f: f, limit: limit, capErr: capErr}, nil
+}
+
+// writeUpload 把 src 写入预留的文件，不持有 s.stateMu。超过上限或写入失败时删除已写入的部分，
+// 完成后重新加锁释放预留，文件按实际大小计入 usedBytes
+func (s *Server) writeUpload(slot *uploadSlot, src io.Reader) (string, error) {
+	if slot.limit >= 0 {
+		src = io.LimitReader(src, slot.limit+1)
+	}
+	n, err := io.Copy(slot.f, src)
+	slot.f.Close()
+	if err == nil && slot.limit >= 0 && n > slot.limit {
+		err = slot.capErr
+	}
+	if err != nil {
+		os.Remove(slot.f.Name())
+	}
+
+	s.stateMu.Lock()
+	delete(s.uploading, slot.f.Name())
+	s.stateMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(slot.f.Name()), nil
+}
+
+// receiveRaw 处理 PUT 上传: 请求体即文件内容，文件名取 URL 的最后一段
+func (s *Server) receiveRaw(w http.ResponseWriter, r *http.Request, name string) {
+	name = sanitizeFileName(name)
+	if name == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	saved, err := s.saveUpload(name, r.Body, r.ContentLength)
+	if errors.Is(err, errUploadsClosed) {
+		http.Error(w, "Gone", http.StatusGone)
+		return
+	}
+	if errors.Is(err, errTooLarge) {
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, errNoSpace) {
+		http.Error(w, "Insufficient Storage", http.StatusInsufficientStorage)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string][]string{"saved": {saved}})
+}
+
+// countUploads 统计收件目录中已上传的文件数
+func (s *Server) countUploads() int {
+	entries, err := os.ReadDir(s.shares().sharePath)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			n++
+		}
+	}
+	return n
+}
+
+// receiveUploads 处理表单上传。整个请求体不超过 --max-request-size，表单解析时超出内存的部分
+// 写入临时目录，解析前先确认临时目录所在磁盘放得下。
+func (s *Server) receiveUploads(w http.ResponseWriter, r *http.Request, lang string) {
+	maxRequest := s.state.MaxRequestSize
+	if maxRequest <= 0 {
+		maxRequest = config.DefaultMaxRequestSize
+	}
+	if r.ContentLength > maxRequest {
+		http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if room := diskRoom(os.TempDir()); room >= 0 && r.ContentLength > room {
+		http.Error(w, i18n.T(lang, "upload_no_space"), http.StatusInsufficientStorage)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequest)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		s.renderUploadForm(w, lang, i18n.T(lang, "upload_choose"))
+		return
+	}
+
+	saved := []string{}
+	var rejected []string
+	noSpace := false
+	for _, fh := range files {
+		name := sanitizeFileName(fh.Filename)
+		if name == "" {
+			continue
+		}
+
+		src, err := fh.Open()
+		if err != nil {
+			continue
+		}
+		savedName, err := s.saveUpload(name, src, fh.Size)
+		src.Close()
+		if errors.Is(err, errUploadsClosed) {
+			break
+		}
+		if errors.Is(err, errTooLarge) {
+			rejected = append(rejected, name)
+			continue
+		}
+		if errors.Is(err, errNoSpace) {
+			noSpace = true
+			break
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		saved = append(saved, savedName)
+	}
+
+	if wantsJSON(r) {
+		status := http.StatusCreated
+		if len(saved) == 0 {
+			switch {
+			case noSpace:
+				status = http.StatusInsufficientStorage
+			case len(rejected) > 0:
+				status = http.StatusRequestEntityTooLarge
+			default:
+				status = http.StatusGone
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string][]string{"saved": saved, "rejected": rejected})
+		return
+	}
+
+	var notices []string
+	if len(saved) > 0 {
+		notices = append(notices, i18n.T(lang, "upload_received", len(saved), strings.Join(saved, ", ")))
+	}
+	if len(rejected) > 0 {
+		notices = append(notices, i18n.T(lang, "upload_too_large", strings.Join(rejected, ", ")))
+	}
+	if noSpace {
+		if len(saved) == 0 {
+			http.Error(w, i18n.T(lang, "upload_no_space"), http.StatusInsufficientStorage)
+			return
+		}
+		notices = append(notices, i18n.T(lang, "upload_no_space"))
+	}
+	if len(notices) == 0 {
+		http.Error(w, i18n.T(lang, "upload_closed"), http.StatusGone)
+		return
+	}
+
+	s.renderUploadForm(w, lang, strings.Join(notices, "\n"))
+}
+
+// sanitizeFileName 去除上传文件名中的目录部分和控制字符
+func sanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = filepath.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	// 去掉前导点，避免生成隐藏文件或 ".."
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if name == "/" {
+		return ""
+	}
+	return name
+}
+
+// createUniqueFile 在 dir 下创建文件，同名时追加序号而不是覆盖
+func createUniqueFile(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsExist(err) || i > 1000 {
+			return nil, err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+func (s *Server) renderUploadForm(w http.ResponseWriter, lang, notice string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	s.stateMu.Lock()
+	remaining := -1
+	if s.state.MaxUploads > 0 {
+		remaining = s.state.MaxUploads - s.countUploads()
+	}
+	var used int64
+	if s.state.UploadQuota > 0 {
+		used = s.usedBytes()
+	}
+	s.stateMu.Unlock()
+
+	data := struct {
+		Lang        string
+		Message     string
+		Notice      string
+		Remaining   int
+		Expires     string
+		MaxFileSize string
+		SpaceLeft   string
+		T           func(key string, args ...any) string
+	}{
+		Lang:      lang,
+		T:         translator(lang),
+		Message:   s.state.RequestMessage,
+		Notice:    notice,
+		Remaining: remaining,
+	}
+	if !s.state.ExpiresAt.IsZero() {
+		data.Expires = s.state.ExpiresAt.Format("2006-01-02 15:04")
+	}
+	if s.state.MaxFileSize > 0 {
+		data.MaxFileSize = formatSize(s.state.MaxFileSize)
+	}
+	if s.state.UploadQuota > 0 {
+		data.SpaceLeft = formatSize(max(s.state.UploadQuota-used, 0))
+	}
+
+	uploadPageTemplate.Execute(w, data)
+}
+
+// isFileRequest 判断当前分享是否为文件请求模式
+func (s *Server) isFileRequest() bool {
+	return s.state != nil && s.state.Mode == state.ModeRequest
+}
+
+var uploadPageTemplate = template.Must(template.New("upload").Parse(uploadTemplate))
+
+const uploadTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{call .T "upload_title"}}</title>
+    <style>
+        * { box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 600px;
+            margin: 0 auto;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            overflow: hidden;
+        }
+        h1 {
+            margin: 0;
+            padding: 20px;
+            background: #2563eb;
+            color: white;
+            font-size: 18px;
+            font-weight: 500;
+        }
+        .body { padding: 20px; }
+        .message { white-space: pre-wrap; margin-bottom: 20px; }
+        .notice { padding: 12px; background: #ecfdf5; border-radius: 6px; margin-bottom: 20px; white-space: pre-wrap; }
+        .meta { color: #6b7280; font-size: 14px; margin-top: 20px; }
+        button {
+            margin-top: 15px;
+            padding: 10px 20px;
+            background: #2563eb;
+            color: white;
+            border: none;
+            border-radius: 6px;
+            font-size: 14px;
+            cursor: pointer;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>📤 {{call .T "upload_title"}}</h1>
+        <div class="body">
+            {{if .Message}}<div class="message">{{.Message}}</div>{{end}}
+            {{if .Notice}}<div class="notice">{{.Notice}}</div>{{end}}
+            {{if ne .Remaining 0}}
+            <form method="post" enctype="multipart/form-data">
+                <input type="file" name="file" multiple required>
+                <br>
+                <button type="submit">{{call .T "upload_button"}}</button>
+            </form>
+            {{end}}
+            <div class="meta">
+                {{if ge .Remaining 0}}{{call .T "upload_remaining" .Remaining}}<br>{{end}}
+                {{if .MaxFileSize}}{{call .T "upload_max_size" .MaxFileSize}}<br>{{end}}
+                {{if .SpaceLeft}}{{call .T "upload_space_left" .SpaceLeft}}<br>{{end}}
+                {{if .Expires}}{{call .T "upload_expires" .Expires}}{{end}}
+            </div>
+        </div>
+    </div>
+</body>
+</html>`
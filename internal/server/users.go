@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/state"
+)
+
+// SetUsers 替换 cfshare user add/rm 维护的用户表，服务进程收到重新加载的信号时调用
+func (s *Server) SetUsers(users []auth.User) {
+	s.users.Set(users)
+}
+
+// requestUser 返回请求对应的用户表中的用户。使用分享口令、所有者链接或签名链接的请求返回 false，
+// 这些请求不受用户权限限制 (用户名不能与分享的用户名相同)。
+func (s *Server) requestUser(ctx context.Context) (auth.User, bool) {
+	name := auth.UserFromContext(ctx)
+	if name == "" {
+		return auth.User{}, false
+	}
+	return s.users.Lookup(name)
+}
+
+// visibleItems 返回请求者可以访问的分享项，用于根目录列表、搜索和打包下载
+func (s *Server) visibleItems(ctx context.Context) []state.ShareItem {
+	items := s.shares().items
+	u, ok := s.requestUser(ctx)
+	if !ok || len(u.Items) == 0 {
+		return items
+	}
+	var visible []state.ShareItem
+	for _, item := range items {
+		if u.CanAccess(item.Name) {
+			visible = append(visible, item)
+		}
+	}
+	return visible
+}
+
+// userMiddleware 检查用户表中用户的权限: 只读用户不能向文件请求上传，
+// 限定了分享项的用户访问其他分享项时得到 404，与分享项不存在时一样
+func (s *Server) userMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := s.requestUser(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.isFileRequest() {
+			if !u.CanUpload() {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if item := s.requestedItem(r.URL.Path); item != "" && !u.CanAccess(item) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestedItem 返回 URL 路径所属的分享项名称，根目录和搜索等不属于某个分享项的路径返回空字符串
+func (s *Server) requestedItem(urlPath string) string {
+	set := s.shares()
+	if !set.isMulti {
+		return set.items[0].Name
+	}
+	name := s.itemName(urlPath)
+	if _, ok := set.itemMap[name]; ok {
+		return name
+	}
+	// /<name>.sha256 是分享文件的校验值
+	if trimmed := strings.TrimSuffix(name, ".sha256"); trimmed != name {
+		if _, ok := set.itemMap[trimmed]; ok {
+			return trimmed
+		}
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// waitingRoom 限制同时下载的访问者数 (--waiting-room)，其余访问者按到达顺序排队。
+// 按 CF-Connecting-IP 区分访问者，同一访问者的多个下载只占一个名额。
+type waitingRoom struct {
+	mu      sync.Mutex
+	slots   int
+	active  map[string]int // 正在下载的访问者 -> 进行中的传输数
+	queue   []string       // 排队的访问者，按到达顺序
+	seen    map[string]time.Time
+	timeout time.Duration
+}
+
+func newWaitingRoom(slots int) *waitingRoom {
+	return &waitingRoom{
+		slots:   slots,
+		active:  make(map[string]int),
+		seen:    make(map[string]time.Time),
+		timeout: config.WaitingRoomTimeout,
+	}
+}
+
+// enter 尝试为访问者占用下载名额。名额已满时访问者进入 (或留在) 队列，返回从 1 开始的排队位置。
+// 只有排在队首、有空闲名额的访问者才能进入，后来者不会插队。
+func (q *waitingRoom) enter(ip string, now time.Time) (bool, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active[ip] > 0 {
+		q.active[ip]++
+		return true, 0
+	}
+
+	// 太久没有刷新的访问者已经离开，让出位置
+	kept := q.queue[:0]
+	pos := -1
+	for _, waiting := range q.queue {
+		if waiting != ip && now.Sub(q.seen[waiting]) > q.timeout {
+			delete(q.seen, waiting)
+			continue
+		}
+		if waiting == ip {
+			pos = len(kept)
+		}
+		kept = append(kept, waiting)
+	}
+	q.queue = kept
+	if pos < 0 {
+		q.queue = append(q.queue, ip)
+		pos = len(q.queue) - 1
+	}
+	q.seen[ip] = now
+
+	if pos < q.slots-len(q.active) {
+		q.queue = append(q.queue[:pos], q.queue[pos+1:]...)
+		delete(q.seen, ip)
+		q.active[ip]++
+		return true, 0
+	}
+	return false, pos + 1
+}
+
+func (q *waitingRoom) leave(ip string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.active[ip]--; q.active[ip] <= 0 {
+		delete(q.active, ip)
+	}
+}
+
+// waitingRoomMiddleware 在同时下载的访问者达到 --waiting-room 上限时，让其余访问者排队:
+// 返回 503 和自动刷新的排队页面，显示当前位置，轮到时刷新即开始下载。
+// 公开链接被大量转发时本机上行不会被挤满，访问者也不必反复重试。分享者自己的请求不受限制。
+func (s *Server) waitingRoomMiddleware(next http.Handler) http.Handler {
+	if s.state.WaitingRoom <= 0 {
+		return next
+	}
+	room := newWaitingRoom(s.state.WaitingRoom)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isOwner(r) || s.isFileRequest() || !s.isTransfer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		admitted, pos := room.enter(ip, time.Now())
+		if !admitted {
+			s.renderWaitingRoom(w, r, pos)
+			return
+		}
+		defer room.leave(ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) renderWaitingRoom(w http.ResponseWriter, r *http.Request, pos int) {
+	refresh := int(config.WaitingRoomRefresh.Seconds())
+	lang := s.pageLang(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(refresh))
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	tmpl := template.Must(template.New("waiting").Parse(waitingRoomTemplate))
+	tmpl.Execute(w, struct {
+		Lang     string
+		Position int
+		Refresh  int
+		T        func(key string, args ...any) string
+	}{
+		Lang:     lang,
+		Position: pos,
+		Refresh:  refresh,
+		T:        translator(lang),
+	})
+}
+
+const waitingRoomTemplate = `<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta http-equiv="refresh" content="{{.Refresh}}">
+    <title>{{call .T "waiting_title"}}</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f5f5;
+        }
+        .container {
+            max-width: 480px;
+            margin: 60px auto;
+            padding: 30px;
+            background: white;
+            border-radius: 8px;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+            text-align: center;
+        }
+        h1 { margin: 0 0 10px; font-size: 20px; font-weight: 500; }
+        .position { font-size: 48px; font-weight: 600; color: #2563eb; margin: 20px 0; }
+        p { color: #666; font-size: 14px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>⏳ {{call .T "waiting_title"}}</h1>
+        <div class="position">{{.Position}}</div>
+        <p>{{call .T "waiting_position" .Position}}</p>
+        <p>{{call .T "waiting_hint" .Refresh}}</p>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/notify"
+	"cfshare/internal/state"
+)
+
+// feedPath 是 --watch 的 RSS 订阅地址，列出内容改变过的分享文件，与列表页一样需要认证
+const feedPath = "/__feed.xml"
+
+// versionTracker 记录 --watch 监视的分享文件的版本，键为分享项名称
+type versionTracker struct {
+	mu       sync.Mutex
+	versions map[string]state.ItemVersion
+}
+
+func newVersionTracker() *versionTracker {
+	return &versionTracker{versions: make(map[string]state.ItemVersion)}
+}
+
+func (t *versionTracker) set(name string, v state.ItemVersion) {
+	t.mu.Lock()
+	t.versions[name] = v
+	t.mu.Unlock()
+}
+
+func (t *versionTracker) get(name string) (state.ItemVersion, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.versions[name]
+	return v, ok
+}
+
+func (t *versionTracker) snapshot() map[string]state.ItemVersion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]state.ItemVersion, len(t.versions))
+	for name, v := range t.versions {
+		out[name] = v
+	}
+	return out
+}
+
+// WatchFiles 在使用 --watch 时定期检查顶层分享的普通文件：修改时间或大小变化后重新计算
+// SHA-256，内容确实改变时版本号加一、写入访问日志并推送 --notify。
+// 目录中的文件不监视，逐个计算整棵目录树的校验值代价太大。
+func (s *Server) WatchFiles(ctx context.Context) {
+	if !s.state.Watch {
+		return
+	}
+	// 重启服务进程 (如 cfshare reload) 后沿用已有的版本号
+	for name, v := range state.LoadItemVersions() {
+		s.versions.set(name, v)
+	}
+
+	ticker := time.NewTicker(config.WatchInterval)
+	defer ticker.Stop()
+	for {
+		s.checkVersions()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkVersions 检查一遍分享文件，返回内容改变了的分享项名称
+func (s *Server) checkVersions() []string {
+	var updated []string
+	changed := false
+	for _, item := range s.shares().items {
+		if item.ShareType != state.TypeFile {
+			continue
+		}
+		info, err := os.Stat(item.Path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		old, ok := s.versions.get(item.Name)
+		if ok && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+		sum, err := s.checksums.compute(item.Path)
+		if err != nil {
+			continue
+		}
+
+		v := state.ItemVersion{Version: 1, SHA256: sum, Size: info.Size(), ModTime: info.ModTime(), UpdatedAt: time.Now()}
+		bumped := ok && sum != old.SHA256
+		if ok {
+			// 只是 touch 或原样覆盖时内容没有变化，沿用原来的版本
+			v.Version, v.UpdatedAt = old.Version, old.UpdatedAt
+			if bumped {
+				v.Version, v.UpdatedAt = old.Version+1, time.Now()
+			}
+		}
+		s.versions.set(item.Name, v)
+		changed = true
+
+		if bumped {
+			updated = append(updated, item.Name)
+			s.fileUpdated(item.Name, v)
+		}
+	}
+	if changed {
+		state.SaveItemVersions(s.versions.snapshot())
+	}
+	return updated
+}
+
+// fileUpdated 把分享文件的新版本写入访问日志和服务日志，设置了 --notify 时推送
+func (s *Server) fileUpdated(name string, v state.ItemVersion) {
+	logData, _ := json.Marshal(map[string]interface{}{
+		"time":    time.Now().UTC().Format(time.RFC3339),
+		"event":   "updated",
+		"path":    "/" + name,
+		"version": v.Version,
+		"sha256":  v.SHA256,
+	})
+	appendToAccessLog(string(logData))
+	fmt.Fprintf(os.Stderr, "[watch] %s 已更新为 v%d\n", name, v.Version)
+
+	if s.state.NotifyURL != "" {
+		go notify.Text(s.state.NotifyURL, fmt.Sprintf("📝 cfshare: %s 已更新为 v%d %s", name, v.Version, s.itemURL(nil, name)))
+	}
+}
+
+// itemURL 返回分享项的公开链接，单文件分享即分享地址本身
+func (s *Server) itemURL(r *http.Request, name string) string {
+	base := s.state.ShareURL()
+	if r != nil {
+		base = s.baseURL(r) + "/"
+	}
+	if !s.shares().isMulti {
+		return base
+	}
+	return base + url.PathEscape(name)
+}
+
+// fillVersions 为列表中的顶层分享文件填充版本号
+func (s *Server) fillVersions(files []FileInfo) {
+	if !s.state.Watch {
+		return
+	}
+	for i := range files {
+		f := &files[i]
+		if f.IsDir || f.diskPath == "" {
+			continue
+		}
+		for _, item := range s.shares().items {
+			if item.Path != f.diskPath {
+				continue
+			}
+			if v, ok := s.versions.get(item.Name); ok {
+				f.Version, f.UpdatedAt = v.Version, v.UpdatedAt
+			}
+			break
+		}
+	}
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+// handleFeed 以 RSS 2.0 输出内容改变过的分享文件，最近更新的在前，每个文件只列出当前版本
+func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
+	title := s.state.Title
+	if title == "" {
+		title = "cfshare"
+	}
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       title,
+		Link:        s.baseURL(r) + "/",
+		Description: "分享文件的更新",
+	}}
+
+	versions := s.versions.snapshot()
+	names := make([]string, 0, len(versions))
+	for name, v := range versions {
+		if v.Version > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return versions[names[i]].UpdatedAt.After(versions[names[j]].UpdatedAt) })
+	for _, name := range names {
+		v := versions[name]
+		link := s.itemURL(r, name)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:   fmt.Sprintf("%s v%d", name, v.Version),
+			Link:    link,
+			GUID:    fmt.Sprintf("%s#v%d", link, v.Version),
+			PubDate: v.UpdatedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
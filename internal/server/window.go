@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServeWindow 是 --serve-window 指定的每日下载时段 (本机时区)，如 01:00-07:00。
+// 结束时间早于开始时间表示跨越午夜，如 22:00-06:00。
+type ServeWindow struct {
+	Start, End int // 从午夜起的分钟数
+}
+
+// ParseServeWindow 解析 HH:MM-HH:MM 形式的时段
+func ParseServeWindow(s string) (ServeWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return ServeWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", s)
+	}
+	start, err1 := parseClock(from)
+	end, err2 := parseClock(to)
+	if err1 != nil || err2 != nil || start == end {
+		return ServeWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", s)
+	}
+	return ServeWindow{Start: start, End: end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w ServeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Contains 判断 t (按其时区) 是否在时段内
+func (w ServeWindow) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.Start < w.End {
+		return m >= w.Start && m < w.End
+	}
+	return m >= w.Start || m < w.End
+}
+
+// NextOpen 返回 t 之后时段下一次开始的时间
+func (w ServeWindow) NextOpen(t time.Time) time.Time {
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.Start/60, w.Start%60, 0, 0, t.Location())
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open
+}
+
+// serveWindowMiddleware 在 --serve-window 时段之外对文件下载返回 503 和 Retry-After
+// (到下一次开放的秒数)，目录列表、缩略图等小请求照常响应，白天的上行带宽留给其他用途。
+// 分享者自己的请求和文件请求的上传不受限制。
+func (s *Server) serveWindowMiddleware(next http.Handler) http.Handler {
+	if s.state.ServeWindow == "" {
+		return next
+	}
+	window, err := ParseServeWindow(s.state.ServeWindow)
+	if err != nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if isOwner(r) || window.Contains(now) || s.isFileRequest() || !s.isTransfer(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		open := window.NextOpen(now)
+		w.Header().Set("Retry-After", strconv.Itoa(int(open.Sub(now).Seconds())+1))
+		http.Error(w, fmt.Sprintf("Service Unavailable: downloads are served daily %s (%s), next at %s",
+			window, now.Format("UTC-07:00"), open.Format("2006-01-02 15:04")), http.StatusServiceUnavailable)
+	})
+}
@@ -0,0 +1,57 @@
+// Package standby 监控主机上的分享是否仍在响应，供热备机在主机失联时接管
+package standby
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"cfshare/internal/health"
+)
+
+// Monitor 周期性探测主机的公开地址。连续 Failures 次探测失败 (网络错误或 5xx，
+// 例如 Cloudflare 在没有可用 connector 时返回的 530/502) 即认为主机已停止响应。
+type Monitor struct {
+	URL      string // 探测地址，通常为分享的公开 URL
+	Token    string // 分享者令牌，探测请求走优先通道，不受口令和访问限制影响
+	Interval time.Duration
+	Failures int
+	Timeout  time.Duration
+
+	Client *http.Client // 为空时使用带 Timeout 的默认客户端
+
+	// OnProbe 在每次探测后调用，err 为 nil 表示主机正常
+	OnProbe func(err error)
+}
+
+// Wait 阻塞直到判定主机失联 (返回 nil) 或 ctx 结束 (返回 ctx 的错误)
+func (m *Monitor) Wait(ctx context.Context) error {
+	client := m.Client
+	if client == nil {
+		client = &http.Client{Timeout: m.Timeout}
+	}
+
+	failures := 0
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		err := health.Probe(ctx, client, m.URL, m.Token)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if m.OnProbe != nil {
+			m.OnProbe(err)
+		}
+		if err == nil {
+			failures = 0
+		} else if failures++; failures >= m.Failures {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package standby
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMonitorDetectsOutage(t *testing.T) {
+	var down atomic.Bool
+	var probes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		if r.Header.Get("X-Cfshare-Owner") != "tok" {
+			t.Error("expected owner token on probe")
+		}
+		if down.Load() {
+			// Cloudflare 在 tunnel 没有 connector 时返回 530
+			w.WriteHeader(530)
+			return
+		}
+		// 需要口令的分享返回 401 也说明主机在线
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	var failures int
+	m := &Monitor{
+		URL:      srv.URL,
+		Token:    "tok",
+		Interval: 5 * time.Millisecond,
+		Failures: 3,
+		Timeout:  time.Second,
+		OnProbe: func(err error) {
+			if err != nil {
+				failures++
+			}
+			if probes.Load() == 4 {
+				down.Store(true)
+			}
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Wait(ctx); err != nil {
+		t.Fatalf("expected outage to be detected, got %v", err)
+	}
+	if probes.Load() != 7 || failures != 3 {
+		t.Errorf("expected takeover after 4 healthy and 3 failed probes, got %d probes, %d failures", probes.Load(), failures)
+	}
+}
+
+func TestMonitorStopsWithContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	m := &Monitor{URL: srv.URL, Interval: 5 * time.Millisecond, Failures: 1, Timeout: time.Second}
+	if err := m.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context error while primary is healthy, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// 一次性链接 (--burn) 的范围
+const (
+	BurnItem  = "item"  // 每个分享项下载一次后失效
+	BurnShare = "share" // 任意内容下载一次后整个分享失效
+)
+
+// BurnShareKey 是整个分享失效时记录的键
+const BurnShareKey = "*"
+
+// Burned 记录一次性分享中已被下载而失效的项目，保存在 stats.json 中，
+// 由服务进程在文件锁内更新，重启或平滑升级后仍然有效
+type Burned struct {
+	ShareID string               `json:"share_id"`
+	Items   map[string]time.Time `json:"items"` // 分享项名称 (或 BurnShareKey) -> 失效时间
+}
+
+// MarkBurned 把 key 标记为已失效，返回是否为本次新标记。
+// 其他分享留下的记录会被丢弃。
+func MarkBurned(shareID, key string) (bool, error) {
+	marked := false
+	err := updateStats(func(stats *Stats) {
+		if stats.Burned == nil || stats.Burned.ShareID != shareID {
+			stats.Burned = &Burned{ShareID: shareID, Items: make(map[string]time.Time)}
+		}
+		if _, ok := stats.Burned.Items[key]; ok {
+			return
+		}
+		stats.Burned.Items[key] = time.Now()
+		marked = true
+	})
+	return marked, err
+}
+
+// IsBurned 判断当前分享中的 key 是否已失效
+func IsBurned(shareID, key string) bool {
+	b := ReadStats().Burned
+	if b == nil || b.ShareID != shareID {
+		return false
+	}
+	_, ok := b.Items[key]
+	return ok
+}
+
+// formatBurn 返回状态输出中的一次性链接说明，如 "每个项目下载一次后失效 (已失效: a.txt)"
+func (s *State) formatBurn() string {
+	out := "每个项目下载一次后失效"
+	if s.Burn == BurnShare {
+		out = "下载一次后整个分享失效"
+	}
+
+	b := ReadStats().Burned
+	if b == nil || b.ShareID != s.ShareID || len(b.Items) == 0 {
+		return out
+	}
+	var names []string
+	for name := range b.Items {
+		if name == BurnShareKey {
+			name = "全部"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return out + " (已失效: " + strings.Join(names, ", ") + ")"
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// 分享卡片的输出格式
+const (
+	CardText     = "text"
+	CardMarkdown = "md"
+	CardHTML     = "html"
+)
+
+// cardData 是分享卡片中展示给接收方的信息
+type cardData struct {
+	URL      string
+	Username string
+	Password string
+	Hidden   bool // 口令不写入卡片，需要另行发送
+	Message  string
+	Expires  string
+	Items    []cardItem
+	Upload   bool // 文件请求模式
+}
+
+type cardItem struct {
+	Name string
+	Desc string
+}
+
+// Card 生成可以直接粘贴到邮件或聊天中的分享卡片。
+// includePassword 为 false 时卡片中只提示口令另行发送。
+func (s *State) Card(format string, includePassword bool) (string, error) {
+	data := cardData{
+		URL:      s.ShareURL(),
+		Username: s.Username,
+		Password: s.Password,
+		Hidden:   !includePassword || s.SplitSecret != "" || s.Password == "",
+	}
+	if s.Mode == ModeRequest {
+		data.URL = s.RequestURL()
+		data.Message = s.RequestMessage
+		data.Upload = true
+	} else {
+		data.Message = s.Message
+		for _, item := range s.Items {
+			ci := cardItem{Name: item.Name}
+			if item.ShareType == TypeDir {
+				ci.Name += "/"
+				ci.Desc = "目录"
+			} else {
+				ci.Desc = formatBytes(item.Size)
+			}
+			data.Items = append(data.Items, ci)
+		}
+	}
+	if !s.ExpiresAt.IsZero() {
+		data.Expires = s.ExpiresAt.Format("2006-01-02 15:04")
+	}
+	if s.Mode == ModePublic {
+		data.Username, data.Password = "", ""
+	}
+
+	switch format {
+	case "", CardText:
+		return data.text(), nil
+	case CardMarkdown, "markdown":
+		return data.markdown(), nil
+	case CardHTML:
+		return data.html()
+	}
+	return "", fmt.Errorf("unknown card format %q (text, md, html)", format)
+}
+
+func (d cardData) title() string {
+	if d.Upload {
+		return "📥 请通过以下链接上传文件"
+	}
+	return "📦 给你分享了文件"
+}
+
+func (d cardData) passwordText() string {
+	if d.Hidden {
+		return "(另行发送)"
+	}
+	return d.Password
+}
+
+func (d cardData) text() string {
+	var b strings.Builder
+	b.WriteString(d.title() + "\n\n")
+	if d.Message != "" {
+		b.WriteString(d.Message + "\n\n")
+	}
+	b.WriteString("链接:   " + d.URL + "\n")
+	if d.Username != "" {
+		b.WriteString("用户名: " + d.Username + "\n")
+		b.WriteString("口令:   " + d.passwordText() + "\n")
+	}
+	if d.Expires != "" {
+		b.WriteString("有效期: " + d.Expires + " 前\n")
+	}
+	if len(d.Items) > 0 {
+		b.WriteString("\n内容:\n")
+		for _, item := range d.Items {
+			b.WriteString(fmt.Sprintf("  - %s (%s)\n", item.Name, item.Desc))
+		}
+	}
+	return b.String()
+}
+
+func (d cardData) markdown() string {
+	code := func(s string) string { return "`" + strings.ReplaceAll(s, "`", "'") + "`" }
+
+	var b strings.Builder
+	b.WriteString("**" + d.title() + "**\n\n")
+	if d.Message != "" {
+		b.WriteString("> " + d.Message + "\n\n")
+	}
+	b.WriteString("- 链接: <" + d.URL + ">\n")
+	if d.Username != "" {
+		b.WriteString("- 用户名: " + code(d.Username) + "\n")
+		if d.Hidden {
+			b.WriteString("- 口令: " + d.passwordText() + "\n")
+		} else {
+			b.WriteString("- 口令: " + code(d.Password) + "\n")
+		}
+	}
+	if d.Expires != "" {
+		b.WriteString("- 有效期: " + d.Expires + " 前\n")
+	}
+	if len(d.Items) > 0 {
+		b.WriteString("\n内容:\n\n")
+		for _, item := range d.Items {
+			b.WriteString(fmt.Sprintf("- %s (%s)\n", code(item.Name), item.Desc))
+		}
+	}
+	return b.String()
+}
+
+func (d cardData) html() (string, error) {
+	tmpl, err := template.New("card").Funcs(template.FuncMap{
+		"title":        d.title,
+		"passwordText": d.passwordText,
+	}).Parse(cardTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+const cardTemplate = `<div style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 480px; border: 1px solid #e5e7eb; border-radius: 8px; overflow: hidden;">
+  <div style="background: #2563eb; color: white; padding: 12px 16px; font-weight: 500;">{{title}}</div>
+  <div style="padding: 12px 16px; line-height: 1.6; color: #374151;">
+    {{if .Message}}<p style="margin: 0 0 8px;">{{.Message}}</p>{{end}}
+    <div>链接: <a href="{{.URL}}">{{.URL}}</a></div>
+    {{if .Username}}<div>用户名: <code>{{.Username}}</code></div>
+    <div>口令: {{if .Hidden}}{{passwordText}}{{else}}<code>{{.Password}}</code>{{end}}</div>{{end}}
+    {{if .Expires}}<div>有效期: {{.Expires}} 前</div>{{end}}
+    {{if .Items}}<div style="margin-top: 8px;">内容:</div>
+    <ul style="margin: 4px 0; padding-left: 20px;">{{range .Items}}
+      <li>{{.Name}} <span style="color: #6b7280;">({{.Desc}})</span></li>{{end}}
+    </ul>{{end}}
+  </div>
+</div>
+`
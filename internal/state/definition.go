@@ -0,0 +1,76 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// definitionFile 是导出的分享定义: 状态加上不写入 state.json 的令牌和签名密钥
+type definitionFile struct {
+	*State
+	Secrets *Secrets `json:"secrets,omitempty"`
+}
+
+// Definition 返回可以在另一台机器上重建该分享的声明式定义 (cfshare export)。
+// 运行时字段 (进程号、统计、钥匙串账户等) 被清除。输出包含口令哈希、令牌和签名密钥，应妥善保存。
+func (s *State) Definition() ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var def State
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+
+	def.ServerPID = 0
+	def.TunnelPID = 0
+	def.StartTime = time.Time{}
+	def.LastAccess = time.Time{}
+	def.RequestCount = 0
+	def.KeychainAccount = ""
+	def.SecretsAccount = ""
+	def.SecretRevealed = false
+	def.Mirrors = nil
+
+	secrets := s.secrets()
+	return json.MarshalIndent(definitionFile{State: &def, Secrets: &secrets}, "", "  ")
+}
+
+// LoadDefinition 读取 cfshare export 导出的分享定义，并检查分享项在本机是否存在
+func LoadDefinition(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var def State
+	file := definitionFile{State: &def}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse definition: %w", err)
+	}
+	if file.Secrets != nil {
+		def.fillSecrets(*file.Secrets)
+	}
+	def.migratePassword(data)
+	def.migrateSecrets(data)
+	if def.Mode == ModeRequest {
+		return nil, fmt.Errorf("文件请求不支持热备")
+	}
+	if len(def.Items) == 0 {
+		return nil, fmt.Errorf("定义中没有分享项")
+	}
+	if def.PublicURL == "" {
+		return nil, fmt.Errorf("定义中没有公开 URL")
+	}
+	for _, item := range def.Items {
+		if item.ShareType == TypeStream {
+			return nil, fmt.Errorf("流式分享不支持热备")
+		}
+		if _, err := os.Stat(item.Path); err != nil {
+			return nil, fmt.Errorf("分享项 %s 在本机不存在: %w", item.Name, err)
+		}
+	}
+	return &def, nil
+}
@@ -0,0 +1,123 @@
+package state
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SharedFile 是分享中的一个文件，URLPath 是它在分享链接中的路径 (如 /docs/a.pdf)
+type SharedFile struct {
+	URLPath string
+	ModTime time.Time
+}
+
+// FileChange 是 cfshare diff 报告的一个文件
+type FileChange struct {
+	Path         string
+	ModTime      time.Time
+	LastDownload time.Time // 为零值时本次分享中还没有人下载过
+	Clients      []string  // 最近一次下载它 (或打包下载所在目录) 的访问者
+}
+
+// DiffReport 是 cfshare diff 的结果
+type DiffReport struct {
+	Changed   []FileChange // 被下载过，但之后在磁盘上修改了
+	Fresh     []FileChange // 分享开始后新增或修改，还没有人下载
+	Unchanged int          // 下载后没有变化的文件数
+	Untouched int          // 分享开始前就存在、还没有人下载的文件数
+}
+
+type downloadMark struct {
+	time    time.Time
+	clients map[string]bool
+}
+
+// Diff 对照本次分享的访问日志，找出下载之后又被修改的文件，回答"要不要通知对方重新下载"。
+// 打包下载目录 (或整个分享) 视为下载了其中的全部文件。
+func (s *State) Diff(files []SharedFile) DiffReport {
+	// 每个路径最近一次成功下载的时间，同一秒内的多次下载合并访问者
+	marks := make(map[string]*downloadMark)
+	scanAccessLog(s.StartTime, func(e accessLogEntry) {
+		if !e.downloaded() {
+			return
+		}
+		p := e.Path
+		if unescaped, err := url.PathUnescape(p); err == nil {
+			p = unescaped
+		}
+		p = strings.TrimSuffix(path.Clean("/"+p), "/")
+		m := marks[p]
+		if m == nil || e.Time.After(m.time) {
+			m = &downloadMark{time: e.Time, clients: make(map[string]bool)}
+			marks[p] = m
+		}
+		if e.Time.Equal(m.time) {
+			m.clients[e.client()] = true
+		}
+	})
+
+	var r DiffReport
+	for _, f := range files {
+		last := lastDownload(marks, f.URLPath)
+		change := FileChange{Path: f.URLPath, ModTime: f.ModTime}
+		switch {
+		case last == nil && f.ModTime.After(s.StartTime):
+			r.Fresh = append(r.Fresh, change)
+		case last == nil:
+			r.Untouched++
+		case f.ModTime.Truncate(time.Second).After(last.time):
+			change.LastDownload = last.time
+			for c := range last.clients {
+				change.Clients = append(change.Clients, c)
+			}
+			sort.Strings(change.Clients)
+			r.Changed = append(r.Changed, change)
+		default:
+			r.Unchanged++
+		}
+	}
+	sort.Slice(r.Changed, func(i, j int) bool { return r.Changed[i].Path < r.Changed[j].Path })
+	sort.Slice(r.Fresh, func(i, j int) bool { return r.Fresh[i].Path < r.Fresh[j].Path })
+	return r
+}
+
+// lastDownload 返回覆盖 urlPath 的最近一次下载: 文件本身或它所在的任意一级目录
+func lastDownload(marks map[string]*downloadMark, urlPath string) *downloadMark {
+	var last *downloadMark
+	p := strings.TrimSuffix(path.Clean("/"+urlPath), "/")
+	for {
+		if m := marks[p]; m != nil && (last == nil || m.time.After(last.time)) {
+			last = m
+		}
+		if p == "" {
+			return last
+		}
+		p = p[:strings.LastIndex(p, "/")]
+	}
+}
+
+// Format 格式化 cfshare diff 的结果
+func (r DiffReport) Format(loc *time.Location) string {
+	var out string
+	if len(r.Changed) == 0 {
+		out += "✅ 没有文件在下载后被修改，无需通知重新下载\n"
+	} else {
+		out += fmt.Sprintf("⚠️  %d 个文件在下载后被修改:\n", len(r.Changed))
+		for _, c := range r.Changed {
+			out += fmt.Sprintf("  %s\n    修改于 %s，上次下载 %s (%s)\n", c.Path,
+				c.ModTime.In(loc).Format("2006-01-02 15:04"), c.LastDownload.In(loc).Format("2006-01-02 15:04"), strings.Join(c.Clients, ", "))
+		}
+	}
+	if len(r.Fresh) > 0 {
+		out += fmt.Sprintf("\n🆕 %d 个文件在分享开始后新增或修改，还没有人下载:\n", len(r.Fresh))
+		for _, c := range r.Fresh {
+			out += fmt.Sprintf("  %s  (%s)\n", c.Path, c.ModTime.In(loc).Format("2006-01-02 15:04"))
+		}
+	}
+	out += fmt.Sprintf("\n下载后未变化: %d 个文件，从未下载: %d 个文件\n", r.Unchanged, r.Untouched)
+	return out
+}
@@ -0,0 +1,316 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// Digest 是一段时间内所有分享的汇总报告 (cfshare digest)，适合把 cfshare 当作
+// 小型分发服务长期运行时每周或每月回顾一次
+type Digest struct {
+	Title      string        `json:"title"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Shares     []DigestShare `json:"shares"`
+	Requests   int           `json:"requests"`
+	Downloads  int           `json:"downloads"`
+	Files      int           `json:"files"` // 被下载过的不同文件数
+	Clients    int           `json:"clients"`
+	BytesSent  int64         `json:"bytes_sent"`
+	Top        []DigestFile  `json:"top,omitempty"`
+	GeoBlocked int           `json:"geo_blocked,omitempty"` // 被 --allow-country / --block-country 拦截的请求数
+	Events     []DigestEvent `json:"events,omitempty"`      // 最近的异常、到期、更新等事件
+
+	loc *time.Location
+}
+
+// DigestShare 是期间内运行过的一次分享
+type DigestShare struct {
+	Mount     string    `json:"mount,omitempty"`
+	URL       string    `json:"url"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time,omitempty"` // 为零值时仍在运行或没有正常结束
+	Items     []string  `json:"items"`
+}
+
+// DigestFile 是期间内一个文件的下载情况
+type DigestFile struct {
+	Path      string `json:"path"` // 其他目录 (--mount) 中的分享带 [名称] 前缀
+	Downloads int    `json:"downloads"`
+	Clients   int    `json:"clients"`
+	BytesSent int64  `json:"bytes_sent"`
+}
+
+// DigestEvent 是访问日志中值得注意的事件
+type DigestEvent struct {
+	Time    time.Time `json:"time"`
+	Label   string    `json:"label"`
+	Message string    `json:"message"`
+}
+
+// BuildDigest 根据分享历史和各分享的访问日志统计 [from, to) 期间的情况，
+// 时间按 loc 显示。title 是报告标题，如 "每周汇总"。
+func BuildDigest(title string, from, to time.Time, loc *time.Location) (*Digest, error) {
+	d := &Digest{Title: title, From: from, To: to, loc: loc}
+
+	shares, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	mounts := map[string]bool{"": true}
+	for i, s := range shares {
+		end := inferredEnd(shares, i)
+		if !s.StartTime.Before(to) || (!end.IsZero() && end.Before(from)) {
+			continue
+		}
+		ds := DigestShare{Mount: s.Mount, URL: s.URL, StartTime: s.StartTime, EndTime: s.EndTime}
+		for _, item := range s.Items {
+			ds.Items = append(ds.Items, item.Name)
+		}
+		d.Shares = append(d.Shares, ds)
+		mounts[s.Mount] = true
+	}
+
+	type fileAcc struct {
+		DigestFile
+		clients map[string]bool
+	}
+	files := make(map[string]*fileAcc)
+	clients := make(map[string]bool)
+	for mount := range mounts {
+		logPath := filepath.Join(config.ShareDirOf(mount), config.AccessLogFileName)
+		scanAccessLogLines(logPath, from, func(e accessLogEntry) {
+			if !e.Time.Before(to) {
+				return
+			}
+			if e.Event != "" {
+				d.addEvent(e)
+				return
+			}
+			d.Requests++
+			d.BytesSent += e.Bytes
+			clients[e.client()] = true
+			if !e.downloaded() {
+				return
+			}
+			p := e.Path
+			if unescaped, err := url.PathUnescape(p); err == nil {
+				p = unescaped
+			}
+			if mount != "" {
+				p = "[" + mount + "] " + p
+			}
+			f := files[p]
+			if f == nil {
+				f = &fileAcc{DigestFile: DigestFile{Path: p}, clients: make(map[string]bool)}
+				files[p] = f
+			}
+			d.Downloads++
+			f.Downloads++
+			f.BytesSent += e.Bytes
+			f.clients[e.client()] = true
+		})
+	}
+
+	d.Clients = len(clients)
+	d.Files = len(files)
+	for _, f := range files {
+		f.Clients = len(f.clients)
+		d.Top = append(d.Top, f.DigestFile)
+	}
+	sort.Slice(d.Top, func(i, j int) bool {
+		if d.Top[i].Downloads != d.Top[j].Downloads {
+			return d.Top[i].Downloads > d.Top[j].Downloads
+		}
+		return d.Top[i].Path < d.Top[j].Path
+	})
+	if len(d.Top) > config.DigestTopFiles {
+		d.Top = d.Top[:config.DigestTopFiles]
+	}
+
+	sort.SliceStable(d.Events, func(i, j int) bool { return d.Events[i].Time.After(d.Events[j].Time) })
+	if len(d.Events) > config.DigestMaxEvents {
+		d.Events = d.Events[:config.DigestMaxEvents]
+	}
+	return d, nil
+}
+
+// addEvent 记录值得注意的事件，地区拦截只计数，其他事件 (如 terms_accepted) 忽略
+func (d *Digest) addEvent(e accessLogEntry) {
+	ev := DigestEvent{Time: e.Time, Message: e.Message}
+	switch e.Event {
+	case "geo_blocked":
+		d.GeoBlocked++
+		return
+	case "anomaly":
+		ev.Label = "异常 (" + e.Kind + ")"
+	case "edge":
+		ev.Label = "连接"
+	case "resources":
+		ev.Label = "资源"
+	case "expired":
+		ev.Label = "到期"
+	case "max_downloads":
+		ev.Label = "下载上限"
+	case "burned":
+		ev.Label = "阅后即焚"
+		ev.Message = e.Item + " 已下载并销毁"
+	case "updated":
+		ev.Label = "更新"
+		ev.Message = fmt.Sprintf("%s 更新为 v%d", e.Path, e.Version)
+	default:
+		return
+	}
+	d.Events = append(d.Events, ev)
+}
+
+// Format 按 format (text、md、html，与分享卡片相同) 输出报告
+func (d *Digest) Format(format string) (string, error) {
+	switch format {
+	case "", CardText:
+		return d.text(), nil
+	case CardMarkdown, "markdown":
+		return d.markdown(), nil
+	case CardHTML:
+		return d.html()
+	}
+	return "", fmt.Errorf("unknown digest format %q (text, md, html)", format)
+}
+
+func (d *Digest) time(t time.Time) string {
+	if d.loc != nil {
+		t = t.In(d.loc)
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+func (d *Digest) heading() string {
+	return fmt.Sprintf("cfshare %s (%s – %s)", d.Title, d.time(d.From), d.time(d.To))
+}
+
+// shareLine 返回一次分享的起止时间、链接和分享项
+func (d *Digest) shareLine(s DigestShare) string {
+	end := "仍在运行或未正常结束"
+	if !s.EndTime.IsZero() {
+		end = d.time(s.EndTime)
+	}
+	line := fmt.Sprintf("%s – %s  %s", d.time(s.StartTime), end, s.URL)
+	if len(s.Items) > 0 {
+		line += "  " + strings.Join(s.Items, ", ")
+	}
+	return line
+}
+
+func (d *Digest) totals() string {
+	return fmt.Sprintf(`Shares:      %d
+Requests:    %d
+Downloads:   %d (%d 个文件)
+Clients:     %d
+Bytes Sent:  %s
+`, len(d.Shares), d.Requests, d.Downloads, d.Files, d.Clients, formatBytes(d.BytesSent))
+}
+
+func (d *Digest) text() string {
+	var b strings.Builder
+	b.WriteString(d.heading() + "\n────────────────────────────────────────\n")
+	b.WriteString(d.totals())
+	if len(d.Shares) > 0 {
+		b.WriteString("\n分享:\n")
+		for _, s := range d.Shares {
+			b.WriteString("  " + d.shareLine(s) + "\n")
+		}
+	}
+	if len(d.Top) > 0 {
+		b.WriteString(fmt.Sprintf("\n%-40s %9s %8s %12s\n", "TOP DOWNLOADS", "DOWNLOADS", "CLIENTS", "BYTES"))
+		for _, f := range d.Top {
+			b.WriteString(fmt.Sprintf("%-40s %9d %8d %12s\n", f.Path, f.Downloads, f.Clients, formatBytes(f.BytesSent)))
+		}
+	}
+	if len(d.Events) > 0 || d.GeoBlocked > 0 {
+		b.WriteString("\n事件:\n")
+		for _, e := range d.Events {
+			b.WriteString(fmt.Sprintf("  %s  %s  %s\n", d.time(e.Time), e.Label, e.Message))
+		}
+		if d.GeoBlocked > 0 {
+			b.WriteString(fmt.Sprintf("  地区限制拦截了 %d 个请求\n", d.GeoBlocked))
+		}
+	}
+	return b.String()
+}
+
+func (d *Digest) markdown() string {
+	var b strings.Builder
+	b.WriteString("**" + d.heading() + "**\n\n")
+	b.WriteString(fmt.Sprintf("- 分享: %d 次\n- 请求: %d\n- 下载: %d 次 (%d 个文件)\n- 访问者: %d\n- 流量: %s\n",
+		len(d.Shares), d.Requests, d.Downloads, d.Files, d.Clients, formatBytes(d.BytesSent)))
+	if len(d.Shares) > 0 {
+		b.WriteString("\n分享:\n\n")
+		for _, s := range d.Shares {
+			b.WriteString("- " + d.shareLine(s) + "\n")
+		}
+	}
+	if len(d.Top) > 0 {
+		b.WriteString("\n下载最多:\n\n| 文件 | 下载 | 访问者 | 流量 |\n| --- | ---: | ---: | ---: |\n")
+		for _, f := range d.Top {
+			b.WriteString(fmt.Sprintf("| `%s` | %d | %d | %s |\n", strings.ReplaceAll(f.Path, "`", "'"), f.Downloads, f.Clients, formatBytes(f.BytesSent)))
+		}
+	}
+	if len(d.Events) > 0 || d.GeoBlocked > 0 {
+		b.WriteString("\n事件:\n\n")
+		for _, e := range d.Events {
+			b.WriteString(fmt.Sprintf("- %s **%s** %s\n", d.time(e.Time), e.Label, e.Message))
+		}
+		if d.GeoBlocked > 0 {
+			b.WriteString(fmt.Sprintf("- 地区限制拦截了 %d 个请求\n", d.GeoBlocked))
+		}
+	}
+	return b.String()
+}
+
+func (d *Digest) html() (string, error) {
+	tmpl, err := template.New("digest").Funcs(template.FuncMap{
+		"time":      d.time,
+		"shareLine": d.shareLine,
+		"bytes":     formatBytes,
+	}).Parse(digestTemplate)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct {
+		*Digest
+		Heading string
+	}{d, d.heading()}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+const digestTemplate = `<div style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 640px; border: 1px solid #e5e7eb; border-radius: 8px; overflow: hidden;">
+  <div style="background: #2563eb; color: white; padding: 12px 16px; font-weight: 500;">{{.Heading}}</div>
+  <div style="padding: 12px 16px; line-height: 1.6; color: #374151;">
+    <div>分享: {{len .Shares}} 次 · 请求: {{.Requests}} · 下载: {{.Downloads}} 次 ({{.Files}} 个文件) · 访问者: {{.Clients}} · 流量: {{bytes .BytesSent}}</div>
+    {{if .Shares}}<div style="margin-top: 8px;">分享:</div>
+    <ul style="margin: 4px 0; padding-left: 20px;">{{range .Shares}}
+      <li>{{shareLine .}}</li>{{end}}
+    </ul>{{end}}
+    {{if .Top}}<div style="margin-top: 8px;">下载最多:</div>
+    <table style="border-collapse: collapse; width: 100%; font-size: 14px;">{{range .Top}}
+      <tr><td><code>{{.Path}}</code></td><td style="text-align: right;">{{.Downloads}} 次</td><td style="text-align: right;">{{.Clients}} 人</td><td style="text-align: right;">{{bytes .BytesSent}}</td></tr>{{end}}
+    </table>{{end}}
+    {{if or .Events .GeoBlocked}}<div style="margin-top: 8px;">事件:</div>
+    <ul style="margin: 4px 0; padding-left: 20px;">{{range .Events}}
+      <li>{{time .Time}} <strong>{{.Label}}</strong> {{.Message}}</li>{{end}}
+      {{if .GeoBlocked}}<li>地区限制拦截了 {{.GeoBlocked}} 个请求</li>{{end}}
+    </ul>{{end}}
+  </div>
+</div>
+`
@@ -0,0 +1,188 @@
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"cfshare/internal/config"
+)
+
+// encryptedMagic 是加密状态文件的文件头
+var encryptedMagic = []byte("CFSENC1\n")
+
+const (
+	keyModeFile       = "keyfile"
+	keyModePassphrase = "passphrase"
+
+	pbkdf2Iterations = 600000
+)
+
+// encryptionConfig 记录状态加密的密钥来源
+type encryptionConfig struct {
+	Mode string `json:"mode"`
+	Salt []byte `json:"salt,omitempty"` // 口令模式下派生密钥使用的盐
+}
+
+var (
+	keyOnce   sync.Once
+	cachedKey []byte
+	keyErr    error
+)
+
+// EncryptionEnabled 判断状态和统计文件是否需要加密保存
+func EncryptionEnabled() bool {
+	_, err := os.Stat(config.GetEncryptionPath())
+	return err == nil
+}
+
+// EnableEncryption 启用状态加密。设置了 CFSHARE_STATE_PASSPHRASE 时使用口令派生密钥，
+// 否则生成本机密钥文件。已启用时不做任何修改。
+func EnableEncryption() error {
+	if EncryptionEnabled() {
+		return nil
+	}
+	if err := config.EnsureConfigDir(); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+
+	cfg := encryptionConfig{Mode: keyModeFile}
+	if os.Getenv(config.StatePassphraseEnv) != "" {
+		cfg.Mode = keyModePassphrase
+		cfg.Salt = make([]byte, 16)
+		rand.Read(cfg.Salt)
+	} else {
+		key := make([]byte, 32)
+		rand.Read(key)
+		if err := os.WriteFile(config.GetStateKeyPath(), key, 0600); err != nil {
+			return fmt.Errorf("write state key: %w", err)
+		}
+	}
+
+	data, _ := json.MarshalIndent(cfg, "", "  ")
+	if err := os.WriteFile(config.GetEncryptionPath(), data, 0600); err != nil {
+		return fmt.Errorf("write encryption config: %w", err)
+	}
+
+	// 把现有的明文文件转换为密文
+	for _, path := range []string{config.GetStatePath(), config.GetStatsPath(), config.GetSecretsPath()} {
+		plain, err := os.ReadFile(path)
+		if err != nil || bytes.HasPrefix(plain, encryptedMagic) {
+			continue
+		}
+		sealed, err := seal(plain)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, sealed, 0600); err != nil {
+			return fmt.Errorf("encrypt %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// stateKey 读取或派生加密密钥，结果在进程内缓存 (口令派生较慢)
+func stateKey() ([]byte, error) {
+	keyOnce.Do(func() {
+		data, err := os.ReadFile(config.GetEncryptionPath())
+		if err != nil {
+			keyErr = fmt.Errorf("read encryption config: %w", err)
+			return
+		}
+		var cfg encryptionConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			keyErr = fmt.Errorf("parse encryption config: %w", err)
+			return
+		}
+
+		switch cfg.Mode {
+		case keyModePassphrase:
+			passphrase := os.Getenv(config.StatePassphraseEnv)
+			if passphrase == "" {
+				keyErr = fmt.Errorf("状态文件已加密，请设置环境变量 %s", config.StatePassphraseEnv)
+				return
+			}
+			cachedKey, keyErr = pbkdf2.Key(sha256.New, passphrase, cfg.Salt, pbkdf2Iterations, 32)
+		default:
+			key, err := os.ReadFile(config.GetStateKeyPath())
+			if err != nil {
+				keyErr = fmt.Errorf("read state key: %w", err)
+				return
+			}
+			if len(key) != 32 {
+				keyErr = fmt.Errorf("invalid state key %s", config.GetStateKeyPath())
+				return
+			}
+			cachedKey = key
+		}
+	})
+	return cachedKey, keyErr
+}
+
+// seal 使用 AES-256-GCM 加密数据
+func seal(plain []byte) ([]byte, error) {
+	key, err := stateKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plain, encryptedMagic), nil
+}
+
+// unseal 解密 seal 生成的数据，未加密的数据原样返回 (兼容启用加密前的文件)
+func unseal(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+
+	key, err := stateKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	body := data[len(encryptedMagic):]
+	if len(body) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted file too short")
+	}
+	plain, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w (口令或密钥不正确?)", err)
+	}
+	return plain, nil
+}
+
+// encodeFile 在启用加密时加密要写入的文件内容
+func encodeFile(data []byte) ([]byte, error) {
+	if !EncryptionEnabled() {
+		return data, nil
+	}
+	return seal(data)
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// Ended 是分享停止后继续运行的 "分享已结束" 提示进程 (--ended-grace)，保存在 ended.json。
+// 提示进程占用原来的端口，tunnel 保持运行，早先发出的链接得到说明而不是边缘错误；
+// 到达 Until 后提示进程停止 tunnel 并退出。
+type Ended struct {
+	PID           int       `json:"pid,omitempty"`
+	Port          int       `json:"port"`
+	PublicURL     string    `json:"public_url"`
+	EndedAt       time.Time `json:"ended_at"`
+	Until         time.Time `json:"until"`
+	Contact       string    `json:"contact,omitempty"`
+	Theme         string    `json:"theme,omitempty"`
+	Lang          string    `json:"lang,omitempty"`
+	AllowIndexing bool      `json:"allow_indexing,omitempty"`
+}
+
+// LoadEnded 读取提示进程的记录，文件不存在时返回 nil
+func LoadEnded() *Ended {
+	data, err := os.ReadFile(config.GetEndedPath())
+	if err != nil {
+		return nil
+	}
+	var e Ended
+	if json.Unmarshal(data, &e) != nil {
+		return nil
+	}
+	return &e
+}
+
+// SaveEnded 写入提示进程的记录
+func SaveEnded(e *Ended) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetEndedPath(), data, 0600)
+}
+
+// ClearEnded 删除提示进程的记录，提示进程退出或被新分享替换时调用
+func ClearEnded() {
+	os.Remove(config.GetEndedPath())
+}
+
+// IsRunning 判断提示进程是否仍在运行
+func (e *Ended) IsRunning() bool {
+	return e != nil && e.PID > 0 && isProcessAlive(e.PID)
+}
+
+// FormatStatus 返回没有活动分享时 cfshare status 显示的提示进程信息
+func (e *Ended) FormatStatus() string {
+	return fmt.Sprintf(`
+分享已于 %s 结束
+🪧 %s 在 %s 前显示"分享已结束"提示，之后停止 tunnel
+   立即停止: cfshare stop
+`, e.EndedAt.Format("2006-01-02 15:04"), strings.TrimSuffix(e.PublicURL, "/"), formatStopAt(e.Until, time.Now()))
+}
@@ -0,0 +1,38 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+
+	"cfshare/internal/config"
+)
+
+// Handoff 是平滑升级 (cfshare upgrade --inplace) 时服务进程交接监听端口的记录，保存在 handoff.json。
+// 服务进程启动时写入自己的 PID，表示它收到升级信号后会把监听 socket 交给新进程；
+// cfshare upgrade 在发信号前写入新的可执行文件，服务进程用它启动新进程。
+type Handoff struct {
+	PID int    `json:"pid"`
+	Exe string `json:"exe,omitempty"`
+}
+
+// SaveHandoff 写入交接记录
+func SaveHandoff(h Handoff) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetHandoffPath(), data, 0600)
+}
+
+// LoadHandoff 读取交接记录，文件不存在或无法解析时返回 nil
+func LoadHandoff() *Handoff {
+	data, err := os.ReadFile(config.GetHandoffPath())
+	if err != nil {
+		return nil
+	}
+	var h Handoff
+	if json.Unmarshal(data, &h) != nil {
+		return nil
+	}
+	return &h
+}
@@ -0,0 +1,102 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// Health 是服务进程对公开地址和 cloudflared 边缘连接的检查结果，保存在 health.json，
+// 与 state.json 分开写入，避免和 CLI 修改状态时互相覆盖
+type Health struct {
+	Degraded  bool      `json:"degraded"`             // 本地服务正常但公开地址不可达
+	Since     time.Time `json:"since,omitempty"`      // 进入降级状态的时间
+	LastError string    `json:"last_error,omitempty"` // 最近一次探测失败的原因
+	Restarts  int       `json:"restarts,omitempty"`   // 本次降级中自动重启 tunnel 的次数
+
+	Connections    int       `json:"connections"`               // cloudflared 注册到边缘的连接数
+	ConnSource     string    `json:"conn_source,omitempty"`     // 连接数的来源: metrics 或 log
+	CheckedAt      time.Time `json:"checked_at,omitempty"`      // 最近一次读取连接数的时间，为零表示还没有检查过
+	TunnelDown     bool      `json:"tunnel_down,omitempty"`     // cloudflared 在运行，但持续没有已注册的连接
+	DownSince      time.Time `json:"down_since,omitempty"`      // 连接中断的时间
+	TunnelError    string    `json:"tunnel_error,omitempty"`    // 最近一次检查或重启失败的原因
+	TunnelRestarts int       `json:"tunnel_restarts,omitempty"` // 本次中断中自动重启 tunnel 的次数
+}
+
+// LoadHealth 读取健康状态，文件不存在时返回 nil
+func LoadHealth() *Health {
+	data, err := os.ReadFile(config.GetHealthPath())
+	if err != nil {
+		return nil
+	}
+	var h Health
+	if json.Unmarshal(data, &h) != nil {
+		return nil
+	}
+	return &h
+}
+
+// SaveHealth 写入健康状态
+func SaveHealth(h *Health) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetHealthPath(), data, 0600)
+}
+
+// ClearHealth 删除健康状态，分享启动和停止时调用
+func ClearHealth() {
+	os.Remove(config.GetHealthPath())
+}
+
+// formatHealth 返回状态输出中的边缘连接和降级信息，没有检查结果且未降级时为空
+func formatHealth(h *Health) string {
+	if h == nil {
+		return ""
+	}
+	out := formatConnections(h)
+	if !h.Degraded {
+		return out
+	}
+	out += fmt.Sprintf("Health:     ⚠️  降级: 本地服务正常，但公开地址自 %s 起无法访问\n", h.Since.Format("2006-01-02 15:04:05"))
+	if h.LastError != "" {
+		out += fmt.Sprintf("            最近错误: %s\n", h.LastError)
+	}
+	if h.Restarts > 0 {
+		out += fmt.Sprintf("            已自动重启 tunnel %d 次，仍在重试\n", h.Restarts)
+	}
+	out += "            排查: cfshare tunnel logs --analyze\n"
+	return out
+}
+
+// formatConnections 返回 cloudflared 边缘连接的检查结果
+func formatConnections(h *Health) string {
+	if h.CheckedAt.IsZero() {
+		return ""
+	}
+	if h.TunnelDown {
+		out := fmt.Sprintf("Edge:       ⚠️  cloudflared 在运行，但自 %s 起没有注册到边缘的连接\n", h.DownSince.Format("2006-01-02 15:04:05"))
+		if h.TunnelError != "" {
+			out += fmt.Sprintf("            最近错误: %s\n", h.TunnelError)
+		}
+		if h.TunnelRestarts > 0 {
+			out += fmt.Sprintf("            已自动重启 tunnel %d 次，仍在重试\n", h.TunnelRestarts)
+		}
+		return out
+	}
+	source := "cloudflared 指标"
+	if h.ConnSource == "log" {
+		source = "tunnel 日志"
+	}
+	if h.Connections == 0 {
+		return fmt.Sprintf("Edge:       🟡 暂无注册到边缘的连接 (%s，%s 检查)\n", source, h.CheckedAt.Format("15:04:05"))
+	}
+	return fmt.Sprintf("Edge:       ✅ %d 条边缘连接已注册 (%s，%s 检查)\n", h.Connections, source, h.CheckedAt.Format("15:04:05"))
+}
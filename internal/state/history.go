@@ -0,0 +1,210 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// historyRecord 是 history.jsonl 中的一行：分享开始时记录分享的定义，
+// 结束时只记录 EndTime。分享项的路径和名称都可以搜索。
+type historyRecord struct {
+	ShareID   string        `json:"share_id"`
+	Mount     string        `json:"mount,omitempty"`
+	URL       string        `json:"url,omitempty"`
+	Mode      ShareMode     `json:"mode,omitempty"`
+	StartTime time.Time     `json:"start_time,omitempty"`
+	EndTime   time.Time     `json:"end_time,omitempty"`
+	Items     []historyItem `json:"items,omitempty"`
+}
+
+type historyItem struct {
+	Name string    `json:"name"`
+	Path string    `json:"path"`
+	Type ShareType `json:"type"`
+}
+
+// appendHistory 在 history.jsonl 末尾追加一行
+func appendHistory(rec historyRecord) error {
+	if err := os.MkdirAll(config.GetConfigDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(config.GetHistoryPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// RecordHistoryEnd 记录分享结束的时间，cfshare stop 在汇总之后调用
+func RecordHistoryEnd(shareID string) error {
+	return appendHistory(historyRecord{ShareID: shareID, EndTime: time.Now()})
+}
+
+// HistoryMatch 是 cfshare history search 找到的一次分享
+type HistoryMatch struct {
+	ShareID      string
+	URL          string
+	StartTime    time.Time
+	EndTime      time.Time // 为零值时分享仍在运行或没有正常结束
+	Items        []string  // 名称或路径与关键字匹配的分享项，形如 "name (path)"
+	Downloads    int
+	Clients      int
+	LastDownload time.Time
+	Files        map[string]int // 与关键字匹配的下载路径及次数
+}
+
+// SearchHistory 在分享历史中查找名称或路径包含 query (不区分大小写) 的文件，
+// 并从对应分享的访问日志中统计它们在分享期间的下载情况。
+// 分享项本身匹配时统计该项下的所有下载；目录中的文件只按下载路径匹配。
+func SearchHistory(query string) ([]HistoryMatch, error) {
+	shares, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	query = strings.ToLower(query)
+	var matches []HistoryMatch
+	for i, s := range shares {
+		if m, ok := s.search(query, inferredEnd(shares, i)); ok {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].StartTime.After(matches[j].StartTime) })
+	return matches, nil
+}
+
+// loadHistory 读取 history.jsonl 中的全部分享，按开始时间先后排列，结束记录合并到对应的分享
+func loadHistory() ([]*historyRecord, error) {
+	f, err := os.Open(config.GetHistoryPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var shares []*historyRecord
+	byID := make(map[string]*historyRecord)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec historyRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) != nil || rec.ShareID == "" {
+			continue
+		}
+		if rec.StartTime.IsZero() {
+			if s := byID[rec.ShareID]; s != nil {
+				s.EndTime = rec.EndTime
+			}
+			continue
+		}
+		s := rec
+		shares = append(shares, &s)
+		byID[rec.ShareID] = &s
+	}
+	return shares, scanner.Err()
+}
+
+// inferredEnd 返回 shares[i] 的结束时间。没有结束记录时，同一目录中的下一次分享开始
+// 即意味着本次分享已经结束；返回零值表示仍在运行或之后没有其他分享
+func inferredEnd(shares []*historyRecord, i int) time.Time {
+	if end := shares[i].EndTime; !end.IsZero() {
+		return end
+	}
+	for _, next := range shares[i+1:] {
+		if next.Mount == shares[i].Mount {
+			return next.StartTime
+		}
+	}
+	return time.Time{}
+}
+
+// search 统计一次分享中与 query 匹配的分享项和下载，end 为零值时统计到现在
+func (s *historyRecord) search(query string, end time.Time) (HistoryMatch, bool) {
+	m := HistoryMatch{
+		ShareID:   s.ShareID,
+		URL:       s.URL,
+		StartTime: s.StartTime,
+		EndTime:   s.EndTime,
+		Files:     make(map[string]int),
+	}
+	matched := make(map[string]bool)
+	for _, item := range s.Items {
+		if strings.Contains(strings.ToLower(item.Name), query) || strings.Contains(strings.ToLower(item.Path), query) {
+			matched[item.Name] = true
+			m.Items = append(m.Items, fmt.Sprintf("%s (%s)", item.Name, item.Path))
+		}
+	}
+
+	st := &State{IsMulti: len(s.Items) > 1}
+	for _, item := range s.Items {
+		st.Items = append(st.Items, ShareItem{Name: item.Name})
+	}
+	clients := make(map[string]bool)
+	logPath := filepath.Join(config.ShareDirOf(s.Mount), config.AccessLogFileName)
+	scanAccessLogFile(logPath, s.StartTime, func(e accessLogEntry) {
+		if !end.IsZero() && e.Time.After(end) {
+			return
+		}
+		if !e.downloaded() {
+			return
+		}
+		p := e.Path
+		if unescaped, err := url.PathUnescape(p); err == nil {
+			p = unescaped
+		}
+		if !matched[st.itemForPath(p)] && !strings.Contains(strings.ToLower(path.Base(p)), query) {
+			return
+		}
+		m.Downloads++
+		m.Files[p]++
+		clients[e.client()] = true
+		if e.Time.After(m.LastDownload) {
+			m.LastDownload = e.Time
+		}
+	})
+	m.Clients = len(clients)
+	return m, len(m.Items) > 0 || m.Downloads > 0
+}
+
+// Format 格式化一次匹配的分享
+func (m HistoryMatch) Format() string {
+	end := "仍在运行或未正常结束"
+	if !m.EndTime.IsZero() {
+		end = m.EndTime.Format("2006-01-02 15:04")
+	}
+	out := fmt.Sprintf("%s – %s  %s\n", m.StartTime.Format("2006-01-02 15:04"), end, m.URL)
+	for _, item := range m.Items {
+		out += "  项目: " + item + "\n"
+	}
+	if m.Downloads == 0 {
+		return out + "  ⚠️  没有人下载过\n"
+	}
+	out += fmt.Sprintf("  下载: %d 次，%d 个访问者，最近一次 %s\n", m.Downloads, m.Clients, m.LastDownload.Local().Format("2006-01-02 15:04"))
+	files := make([]string, 0, len(m.Files))
+	for p := range m.Files {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	for _, p := range files {
+		out += fmt.Sprintf("    %s  %d 次\n", p, m.Files[p])
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// lockouts 从访问日志中找出本次分享中口令错误过多被锁定的 IP，每个 IP 只列出最近一次，
+// 仍在锁定中的标明解除时间
+func (s *State) lockouts() []string {
+	last := make(map[string]time.Time)
+	count := make(map[string]int)
+	scanAccessLog(s.StartTime, func(e accessLogEntry) {
+		if e.Event != "anomaly" || e.Kind != "lockout" {
+			return
+		}
+		ip := e.client()
+		count[ip]++
+		if e.Time.After(last[ip]) {
+			last[ip] = e.Time
+		}
+	})
+
+	ips := make([]string, 0, len(last))
+	for ip := range last {
+		ips = append(ips, ip)
+	}
+	sort.Slice(ips, func(i, j int) bool { return last[ips[i]].After(last[ips[j]]) })
+
+	var lines []string
+	for _, ip := range ips {
+		line := fmt.Sprintf("%s 于 %s 被锁定", ip, last[ip].Local().Format("01-02 15:04"))
+		if count[ip] > 1 {
+			line += fmt.Sprintf(" (共 %d 次)", count[ip])
+		}
+		if until := last[ip].Add(config.AuthLockoutDuration); time.Now().Before(until) {
+			line += "，" + until.Local().Format("15:04") + " 解除"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
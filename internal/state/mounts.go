@@ -0,0 +1,106 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cfshare/internal/config"
+)
+
+// MountPort 返回挂载前缀 mount 固定使用的本地端口。第一次挂载时分配默认端口之后
+// 第一个未被其他挂载登记、也没有被占用的端口，记录在 ports.json 中；之后重新分享
+// 时端口不变，cloudflared 中对应的 ingress 规则不需要修改。
+func MountPort(mount string) (int, error) {
+	path := filepath.Join(config.GetConfigDir(), "ports.json")
+	ports := make(map[string]int)
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &ports)
+	}
+	if port, ok := ports[mount]; ok {
+		return port, nil
+	}
+
+	used := make(map[int]bool)
+	for _, port := range ports {
+		used[port] = true
+	}
+	port := config.DefaultPort + 1
+	for ; port < config.DefaultPort+1000; port++ {
+		if used[port] {
+			continue
+		}
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			continue
+		}
+		ln.Close()
+		break
+	}
+	if port >= config.DefaultPort+1000 {
+		return 0, fmt.Errorf("no free port for mount %s", mount)
+	}
+
+	ports[mount] = port
+	if err := os.MkdirAll(config.GetConfigDir(), 0700); err != nil {
+		return 0, err
+	}
+	data, err := json.MarshalIndent(ports, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return port, os.WriteFile(path, data, 0600)
+}
+
+// ActiveShares 返回正在运行的分享 (包括 --ended-grace 的提示进程) 的挂载前缀，
+// 默认分享为 ""。停止分享时只有没有其他分享在运行才停止共用的 tunnel。
+// 挂载的分享从索引 shares.json 中查找；默认分享可能由没有索引的旧版本启动，总是检查。
+func ActiveShares() []string {
+	var active []string
+	if shareAlive(config.GetConfigDir()) {
+		active = append(active, "")
+	}
+	for _, e := range LoadRegistry() {
+		if e.Mount != "" && e.Running() {
+			active = append(active, e.Mount)
+		}
+	}
+	sort.Strings(active)
+	return active
+}
+
+// shareAlive 判断分享目录 dir 中的服务进程或 "分享已结束" 提示进程是否在运行
+func shareAlive(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "server.pid"))
+	if err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && isProcessAlive(pid) {
+			return true
+		}
+	}
+	var e Ended
+	if data, err := os.ReadFile(filepath.Join(dir, "ended.json")); err == nil && json.Unmarshal(data, &e) == nil {
+		return e.IsRunning()
+	}
+	return false
+}
+
+// formatIngress 说明分享在 tunnel 中的位置。cfshare 生成的 cloudflared 配置为每个运行中的分享
+// 加入 ingress 规则；经路由进程转发时由路由进程按主机名和前缀分发。
+func (s *State) formatIngress() string {
+	where := "挂载在 " + s.Mount + "/"
+	switch {
+	case s.Hostname != "":
+		where = "使用独立主机名 " + s.Hostname
+	case s.Mount == "":
+		where = "使用整个主机名"
+	}
+	if s.RouterPort > 0 {
+		return fmt.Sprintf("\n🔀 %s，由路由进程 (端口 %d) 转发到本地端口 %d\n", where, s.RouterPort, s.Port)
+	}
+	return fmt.Sprintf("\n🔀 %s，本地端口 %d (ingress 规则已加入 cfshare 生成的 cloudflared 配置)\n", where, s.Port)
+}
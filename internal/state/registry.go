@@ -0,0 +1,116 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// ShareEntry 是分享索引 (shares.json) 中的一项。每个分享的状态、日志和统计
+// 保存在各自的目录中 (config.ShareDirOf)，索引记录有哪些分享，
+// 使 cfshare shares 和停止 tunnel 前的检查不必逐个读取各分享的状态。
+type ShareEntry struct {
+	Mount      string    `json:"mount,omitempty"`    // 挂载名称 (--mount)，默认分享为空
+	Hostname   string    `json:"hostname,omitempty"` // 分享独占的主机名 (--hostname)
+	ShareID    string    `json:"share_id"`
+	Mode       ShareMode `json:"mode"`
+	URL        string    `json:"url"`
+	Port       int       `json:"port"`
+	RouterPort int       `json:"router_port,omitempty"` // 经路由进程转发时 cloudflared 指向的端口
+	Items      []string  `json:"items,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+}
+
+// Running 判断索引项对应的分享 (或其 --ended-grace 提示进程) 是否仍在运行
+func (e ShareEntry) Running() bool {
+	return shareAlive(config.ShareDirOf(e.Mount))
+}
+
+// Register 在索引中登记刚启动的分享，同时删除已经不再运行的分享，
+// 并把分享的定义记入分享历史 (history.jsonl)
+func Register(st *State) error {
+	entry := ShareEntry{
+		Mount:      config.Mount,
+		Hostname:   st.Hostname,
+		ShareID:    st.ShareID,
+		Mode:       st.Mode,
+		URL:        st.ShareURL(),
+		Port:       st.Port,
+		RouterPort: st.RouterPort,
+		StartTime:  st.StartTime,
+	}
+	if st.Mode == ModeRequest {
+		entry.URL = st.RequestURL()
+	}
+	rec := historyRecord{ShareID: st.ShareID, Mount: config.Mount, URL: entry.URL, Mode: st.Mode, StartTime: st.StartTime}
+	for _, item := range st.Items {
+		entry.Items = append(entry.Items, item.Name)
+		rec.Items = append(rec.Items, historyItem{Name: item.Name, Path: item.Path, Type: item.ShareType})
+	}
+	if err := appendHistory(rec); err != nil {
+		return err
+	}
+
+	return updateRegistry(func(entries map[string]ShareEntry) {
+		for mount, e := range entries {
+			if mount != config.Mount && !e.Running() {
+				delete(entries, mount)
+			}
+		}
+		entries[config.Mount] = entry
+	})
+}
+
+// LoadRegistry 返回索引中登记的分享，按挂载名称排序，默认分享在最前
+func LoadRegistry() []ShareEntry {
+	data, err := os.ReadFile(config.GetRegistryPath())
+	if err != nil {
+		return nil
+	}
+	entries := make(map[string]ShareEntry)
+	if json.Unmarshal(data, &entries) != nil {
+		return nil
+	}
+	list := make([]ShareEntry, 0, len(entries))
+	for mount, e := range entries {
+		e.Mount = mount
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Mount < list[j].Mount })
+	return list
+}
+
+// updateRegistry 在文件锁内读取、修改并写回 shares.json，多个分享同时启动时不会互相覆盖
+func updateRegistry(update func(entries map[string]ShareEntry)) error {
+	if err := os.MkdirAll(config.GetConfigDir(), 0700); err != nil {
+		return err
+	}
+	path := config.GetRegistryPath()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	entries := make(map[string]ShareEntry)
+	data, _ := os.ReadFile(path)
+	json.Unmarshal(data, &entries)
+
+	update(entries)
+
+	newData, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	f.Truncate(0)
+	f.Seek(0, 0)
+	_, err = f.Write(newData)
+	return err
+}
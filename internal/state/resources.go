@@ -0,0 +1,67 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// Resources 是服务进程对自身资源占用的采样，保存在 resources.json
+type Resources struct {
+	Time       time.Time `json:"time"`
+	HeapBytes  uint64    `json:"heap_bytes"` // 堆上正在使用的内存
+	SysBytes   uint64    `json:"sys_bytes"`  // 向操作系统申请的内存
+	Goroutines int       `json:"goroutines"`
+	OpenFDs    int       `json:"open_fds"`           // 打开的文件描述符，不支持的平台为 -1
+	Warnings   []string  `json:"warnings,omitempty"` // 当前超过阈值的项目
+}
+
+// LoadResources 读取最近一次资源采样，文件不存在时返回 nil
+func LoadResources() *Resources {
+	data, err := os.ReadFile(config.GetResourcesPath())
+	if err != nil {
+		return nil
+	}
+	var r Resources
+	if json.Unmarshal(data, &r) != nil {
+		return nil
+	}
+	return &r
+}
+
+// SaveResources 写入资源采样
+func SaveResources(r *Resources) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetResourcesPath(), data, 0600)
+}
+
+// ClearResources 删除资源采样，分享停止时调用
+func ClearResources() {
+	os.Remove(config.GetResourcesPath())
+}
+
+// formatResources 返回状态输出中的资源占用，如 "Resources:  堆 12.0 MB / 系统 24.0 MB, 35 goroutines, 18 fds"
+func formatResources(r *Resources) string {
+	if r == nil {
+		return ""
+	}
+	fds := "fds 未知"
+	if r.OpenFDs >= 0 {
+		fds = fmt.Sprintf("%d fds", r.OpenFDs)
+	}
+	out := fmt.Sprintf("Resources:  堆 %s / 系统 %s, %d goroutines, %s (%s)\n",
+		formatBytes(int64(r.HeapBytes)), formatBytes(int64(r.SysBytes)), r.Goroutines, fds, r.Time.Format("15:04:05"))
+	for _, w := range r.Warnings {
+		out += "            ⚠️  " + w + "\n"
+	}
+	return out
+}
@@ -0,0 +1,176 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReviewWindow 是访问审查报告中 "最近访问" 覆盖的时间范围
+const ReviewWindow = 7 * 24 * time.Hour
+
+// ReviewItem 是当前对外暴露的一个分享项，Files 和 Size 只计访问者可见的文件
+type ReviewItem struct {
+	Name      string
+	Path      string
+	ShareType ShareType
+	Files     int
+	Size      int64
+}
+
+// ReviewClient 是一个访问者在审查范围内的访问情况
+type ReviewClient struct {
+	Client     string
+	Requests   int
+	BytesSent  int64
+	Items      []string // 成功下载过的分享项
+	LastAccess time.Time
+}
+
+// Review 是 cfshare review 的访问审查报告: 当前暴露了什么、谁访问过、已经运行多久
+type Review struct {
+	URL       string
+	Mode      ShareMode
+	Auth      string
+	StartTime time.Time
+	StopAt    time.Time
+	Now       time.Time
+	Since     time.Time // 最近访问的统计起点
+	Items     []ReviewItem
+	Files     int
+	TotalSize int64
+	Clients   []ReviewClient // 按最近访问时间倒序
+}
+
+// BuildReview 汇总访问审查报告。items 由调用方统计 (需要按 --exclude 遍历目录)，
+// 访问记录取自访问日志中本次分享最近 ReviewWindow 内的请求。
+func BuildReview(st *State, items []ReviewItem, now time.Time) *Review {
+	r := &Review{
+		URL:       st.ShareURL(),
+		Mode:      st.Mode,
+		Auth:      st.authSummary(),
+		StartTime: st.StartTime,
+		StopAt:    st.StopAt,
+		Now:       now,
+		Since:     st.StartTime,
+		Items:     items,
+	}
+	if since := now.Add(-ReviewWindow); since.After(r.Since) {
+		r.Since = since
+	}
+	for _, item := range items {
+		r.Files += item.Files
+		r.TotalSize += item.Size
+	}
+
+	type clientAcc struct {
+		ReviewClient
+		items map[string]bool
+	}
+	clients := make(map[string]*clientAcc)
+	scanAccessLog(r.Since, func(e accessLogEntry) {
+		client := e.client()
+		c := clients[client]
+		if c == nil {
+			c = &clientAcc{ReviewClient: ReviewClient{Client: client}, items: make(map[string]bool)}
+			clients[client] = c
+		}
+		c.Requests++
+		c.BytesSent += e.Bytes
+		if e.Time.After(c.LastAccess) {
+			c.LastAccess = e.Time
+		}
+		if e.downloaded() {
+			if name := st.itemForPath(e.Path); name != "" {
+				c.items[name] = true
+			}
+		}
+	})
+
+	for _, c := range clients {
+		for name := range c.items {
+			c.Items = append(c.Items, name)
+		}
+		sort.Strings(c.Items)
+		r.Clients = append(r.Clients, c.ReviewClient)
+	}
+	sort.Slice(r.Clients, func(i, j int) bool {
+		return r.Clients[i].LastAccess.After(r.Clients[j].LastAccess)
+	})
+	return r
+}
+
+// authSummary 描述访问者需要什么才能访问分享，以及限制访问次数的设置
+func (s *State) authSummary() string {
+	var parts []string
+	switch s.Mode {
+	case ModePublic:
+		parts = append(parts, "公开，无需口令")
+	case ModeProtected:
+		if s.Auth == AuthToken {
+			parts = append(parts, "令牌链接，持有链接即可访问")
+			break
+		}
+		auth := fmt.Sprintf("口令 (用户 %s)", s.Username)
+		if s.SplitSecret != "" {
+			auth += "，口令单独交付"
+		}
+		if s.Auth == AuthForm {
+			auth += fmt.Sprintf("，网页登录 (会话 %s)", formatRemaining(s.SessionLifetime))
+		}
+		parts = append(parts, auth)
+	case ModeRequest:
+		parts = append(parts, "上传链接，持有链接即可上传")
+	}
+	if s.TermsPath != "" {
+		parts = append(parts, "访问前须同意条款")
+	}
+	if s.Burn != "" {
+		parts = append(parts, s.formatBurn())
+	}
+	if s.MaxDownloads > 0 {
+		parts = append(parts, fmt.Sprintf("最多下载 %d 次", s.MaxDownloads))
+	}
+	return strings.Join(parts, "；")
+}
+
+// FormatIn 格式化访问审查报告，时间以 loc 时区显示
+func (r *Review) FormatIn(loc *time.Location) string {
+	expires := "不会自动结束"
+	if !r.StopAt.IsZero() {
+		expires = formatStopAt(r.StopAt, r.Now)
+	}
+
+	out := fmt.Sprintf(`访问审查
+────────────────────────────────────────
+URL:         %s
+Mode:        %s
+Auth:        %s
+Running:     %s (自 %s)
+Expires:     %s
+Exposed:     %d 个项目，%d 个文件，%s
+`, r.URL, r.Mode, r.Auth, formatRemaining(r.Now.Sub(r.StartTime)), DisplayTime(r.StartTime, loc),
+		expires, len(r.Items), r.Files, formatBytes(r.TotalSize))
+
+	if len(r.Items) > 0 {
+		out += fmt.Sprintf("\n%-30s %-6s %8s %12s  %s\n", "ITEM", "TYPE", "FILES", "SIZE", "PATH")
+		for _, item := range r.Items {
+			out += fmt.Sprintf("%-30s %-6s %8d %12s  %s\n", item.Name, item.ShareType, item.Files, formatBytes(item.Size), item.Path)
+		}
+	}
+
+	out += fmt.Sprintf("\n最近的访问 (自 %s)\n", DisplayTime(r.Since, loc))
+	if len(r.Clients) == 0 {
+		return out + "  无访问记录\n"
+	}
+	out += fmt.Sprintf("%-24s %8s %12s  %-26s %s\n", "CLIENT", "REQUESTS", "BYTES", "LAST ACCESS", "DOWNLOADED")
+	for _, c := range r.Clients {
+		downloaded := strings.Join(c.Items, ", ")
+		if downloaded == "" {
+			downloaded = "-"
+		}
+		out += fmt.Sprintf("%-24s %8d %12s  %-26s %s\n", c.Client, c.Requests, formatBytes(c.BytesSent), DisplayTime(c.LastAccess, loc), downloaded)
+	}
+	return out
+}
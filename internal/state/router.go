@@ -0,0 +1,92 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"cfshare/internal/config"
+)
+
+// Route 是分享在路由进程 (--router) 中的登记，保存在各分享目录的 route.json。
+// 路由进程只转发给仍在运行的分享，分享停止后不必删除登记。
+type Route struct {
+	Host  string `json:"host,omitempty"`  // 公开地址的主机名，多个主机名指向同一 tunnel 时按它区分
+	Mount string `json:"mount,omitempty"` // 挂载前缀，如 /docs，包括随机路径 (--slug)，默认分享为空
+	Port  int    `json:"port"`            // 分享服务进程的本地端口
+}
+
+// SaveRoute 登记当前分享的路由
+func SaveRoute(r Route) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetRoutePath(), data, 0600)
+}
+
+// ClearRoute 删除当前分享的路由，不经路由进程的分享启动时调用
+func ClearRoute() {
+	os.Remove(config.GetRoutePath())
+}
+
+// LoadRoutes 返回正在运行的分享登记的路由
+func LoadRoutes() []Route {
+	var routes []Route
+	for _, mount := range ActiveShares() {
+		data, err := os.ReadFile(filepath.Join(config.ShareDirOf(mount), "route.json"))
+		if err != nil {
+			continue
+		}
+		var r Route
+		if json.Unmarshal(data, &r) == nil && r.Port > 0 {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// Router 是路由进程的记录，保存在 router.json。路由进程占用 cloudflared 指向的端口，
+// 按主机名和路径前缀把请求转发给各分享的服务进程，开始或停止分享时 cloudflared 配置不变。
+type Router struct {
+	PID  int `json:"pid"`
+	Port int `json:"port"`
+}
+
+// LoadRouter 读取路由进程的记录，文件不存在时返回 nil
+func LoadRouter() *Router {
+	data, err := os.ReadFile(config.GetRouterPath())
+	if err != nil {
+		return nil
+	}
+	var r Router
+	if json.Unmarshal(data, &r) != nil {
+		return nil
+	}
+	return &r
+}
+
+// SaveRouter 写入路由进程的记录
+func SaveRouter(r *Router) error {
+	if err := os.MkdirAll(config.GetConfigDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetRouterPath(), data, 0600)
+}
+
+// ClearRouter 删除路由进程的记录
+func ClearRouter() {
+	os.Remove(config.GetRouterPath())
+}
+
+// IsRunning 判断路由进程是否仍在运行
+func (r *Router) IsRunning() bool {
+	return r != nil && r.PID > 0 && isProcessAlive(r.PID)
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
+	"cfshare/internal/keychain"
+)
+
+// Secrets 是分享的令牌和签名密钥。持有分享者令牌等于持有分享的管理权限，
+// 所以它们不写入 state.json: 令牌在 state.json 中只保存哈希 (服务进程用哈希校验)，
+// 明文保存在系统钥匙串中 (SecretsAccount 非空时)；钥匙串不可用或使用 --no-keychain 时
+// 保存在权限 0600 的 secrets.json 中，启用状态加密时与 state.json 一样加密。
+// JSON 字段名与旧版本 state.json 中的明文字段相同，读取旧状态文件时直接迁移。
+type Secrets struct {
+	LinkSecret    string `json:"link_secret,omitempty"`
+	OwnerToken    string `json:"owner_token,omitempty"`
+	SessionSecret string `json:"session_secret,omitempty"`
+	LinkToken     string `json:"link_token,omitempty"`
+	RequestToken  string `json:"request_token,omitempty"`
+}
+
+// secretsCache 缓存从钥匙串读取或写入的内容 (按账户)，每次读写钥匙串都要启动一个外部进程
+var (
+	secretsMu    sync.Mutex
+	secretsCache = make(map[string]string)
+)
+
+// secrets 返回分享当前的令牌和签名密钥
+func (s *State) secrets() Secrets {
+	return Secrets{
+		LinkSecret:    s.LinkSecret,
+		OwnerToken:    s.OwnerToken,
+		SessionSecret: s.SessionSecret,
+		LinkToken:     s.LinkToken,
+		RequestToken:  s.RequestToken,
+	}
+}
+
+// fillSecrets 用 sec 补上状态中还没有的令牌和签名密钥
+func (s *State) fillSecrets(sec Secrets) {
+	fill := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
+		}
+	}
+	fill(&s.LinkSecret, sec.LinkSecret)
+	fill(&s.OwnerToken, sec.OwnerToken)
+	fill(&s.SessionSecret, sec.SessionSecret)
+	fill(&s.LinkToken, sec.LinkToken)
+	fill(&s.RequestToken, sec.RequestToken)
+}
+
+// HashTokens 根据令牌明文更新 state.json 中保存的令牌哈希
+func (s *State) HashTokens() {
+	if s.OwnerToken != "" {
+		s.OwnerTokenHash = auth.HashToken(s.OwnerToken)
+	}
+	if s.LinkToken != "" {
+		s.LinkTokenHash = auth.HashToken(s.LinkToken)
+	}
+	if s.RequestToken != "" {
+		s.RequestTokenHash = auth.HashToken(s.RequestToken)
+	}
+}
+
+// saveSecrets 把令牌和签名密钥写入钥匙串或 secrets.json。钥匙串写入失败时改用文件。
+func (s *State) saveSecrets() error {
+	data, err := json.Marshal(s.secrets())
+	if err != nil {
+		return err
+	}
+	path := config.GetSecretsPath()
+
+	if s.SecretsAccount != "" {
+		secretsMu.Lock()
+		defer secretsMu.Unlock()
+		if secretsCache[s.SecretsAccount] == string(data) {
+			return nil
+		}
+		if err := keychain.Set(s.SecretsAccount, string(data)); err == nil {
+			secretsCache[s.SecretsAccount] = string(data)
+			os.Remove(path)
+			return nil
+		}
+		s.SecretsAccount = ""
+	}
+
+	if s.secrets() == (Secrets{}) {
+		os.Remove(path)
+		return nil
+	}
+	data, err = encodeFile(data)
+	if err != nil {
+		return fmt.Errorf("encrypt secrets: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write secrets file: %w", err)
+	}
+	return nil
+}
+
+// loadSecrets 从钥匙串或 secrets.json 读取令牌和签名密钥，读取不到时保持为空
+func (s *State) loadSecrets() {
+	var data []byte
+	if s.SecretsAccount != "" {
+		secretsMu.Lock()
+		cached, ok := secretsCache[s.SecretsAccount]
+		if !ok {
+			if v, err := keychain.Get(s.SecretsAccount); err == nil {
+				cached, ok = v, true
+				secretsCache[s.SecretsAccount] = v
+			}
+		}
+		secretsMu.Unlock()
+		if !ok {
+			return
+		}
+		data = []byte(cached)
+	} else {
+		raw, err := os.ReadFile(config.GetSecretsPath())
+		if err != nil {
+			return
+		}
+		if data, err = unseal(raw); err != nil {
+			return
+		}
+	}
+
+	var sec Secrets
+	if json.Unmarshal(data, &sec) == nil {
+		s.fillSecrets(sec)
+	}
+}
+
+// migrateSecrets 读取旧版本以明文写入 state.json (或导出的定义) 的令牌和签名密钥，
+// 之后保存时只写入哈希
+func (s *State) migrateSecrets(data []byte) {
+	var legacy Secrets
+	if json.Unmarshal(data, &legacy) == nil {
+		s.fillSecrets(legacy)
+	}
+}
+
+// DeleteSecrets 删除分享保存在钥匙串中的令牌和签名密钥 (分享结束后不再需要)
+func (s *State) DeleteSecrets() {
+	if s.SecretsAccount == "" {
+		return
+	}
+	keychain.Delete(s.SecretsAccount)
+	secretsMu.Lock()
+	delete(secretsCache, s.SecretsAccount)
+	secretsMu.Unlock()
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// ItemSize 是目录分享项的递归大小 (只计访问者可见的文件)，保存在 sizes.json。
+// 统计大目录时服务进程会陆续写入中间结果，Done 为 false 时 Bytes 是目前已统计的部分。
+type ItemSize struct {
+	Files int       `json:"files"`
+	Bytes int64     `json:"bytes"`
+	Done  bool      `json:"done"`
+	Time  time.Time `json:"time"`
+}
+
+// LoadItemSizes 读取各目录分享项的大小，键为分享项名称
+func LoadItemSizes() map[string]ItemSize {
+	sizes := make(map[string]ItemSize)
+	data, err := os.ReadFile(config.GetItemSizesPath())
+	if err != nil {
+		return sizes
+	}
+	json.Unmarshal(data, &sizes)
+	return sizes
+}
+
+// SaveItemSize 写入分享项 name 的大小
+func SaveItemSize(name string, size ItemSize) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	sizes := LoadItemSizes()
+	sizes[name] = size
+	data, err := json.MarshalIndent(sizes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetItemSizesPath(), data, 0600)
+}
+
+// ClearItemSizes 删除目录大小记录，分享停止时调用
+func ClearItemSizes() {
+	os.Remove(config.GetItemSizesPath())
+}
+
+// formatItemSize 返回状态输出中目录分享项的大小，如 "1.20 GB, 340 个文件"，尚未统计时为空
+func formatItemSize(size ItemSize, ok bool) string {
+	if !ok {
+		return ""
+	}
+	out := fmt.Sprintf("%s, %d 个文件", formatBytes(size.Bytes), size.Files)
+	if !size.Done {
+		out = "统计中 ≥ " + out
+	}
+	return out
+}
@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"cfshare/internal/auth"
 	"cfshare/internal/config"
+	"cfshare/internal/keychain"
+	"cfshare/internal/storage"
 )
 
 type ShareMode string
@@ -16,13 +21,15 @@ type ShareMode string
 const (
 	ModeProtected ShareMode = "protected"
 	ModePublic    ShareMode = "public"
+	ModeRequest   ShareMode = "request" // 文件请求: 仅允许上传
 )
 
 type ShareType string
 
 const (
-	TypeFile ShareType = "file"
-	TypeDir  ShareType = "dir"
+	TypeFile   ShareType = "file"
+	TypeDir    ShareType = "dir"
+	TypeStream ShareType = "stream" // 标准输入或命名管道，只能被下载一次
 )
 
 type AccessRecord struct {
@@ -31,13 +38,24 @@ type AccessRecord struct {
 	StatusCode int       `json:"status_code"`
 	BytesSent  int64     `json:"bytes_sent"`
 	RemoteAddr string    `json:"remote_addr"`
+	User       string    `json:"user,omitempty"`       // 已认证的访问者身份
+	UserAgent  string    `json:"user_agent,omitempty"` // 访问者的 User-Agent
+	Aborted    string    `json:"aborted,omitempty"`    // 被服务端中止的原因: deadline 或 slow
+}
+
+// MirrorLink 是上传到对象存储的分享项镜像 (cfshare mirror)，目录以 ZIP 上传
+type MirrorLink struct {
+	Name      string    `json:"name"`       // 分享项名称
+	Key       string    `json:"key"`        // 对象键
+	URL       string    `json:"url"`        // 预签名下载链接
+	ExpiresAt time.Time `json:"expires_at"` // 预签名链接的过期时间
 }
 
 // ShareItem 表示单个分享项
 type ShareItem struct {
 	Path      string    `json:"path"`       // 绝对路径
 	Name      string    `json:"name"`       // 显示名称 (基础文件名)
-	ShareType ShareType `json:"share_type"` // file 或 dir
+	ShareType ShareType `json:"share_type"` // file、dir 或 stream
 	Size      int64     `json:"size"`       // 文件大小 (目录为 0)
 }
 
@@ -49,8 +67,8 @@ type State struct {
 	Port    int       `json:"port"`
 
 	// 多路径支持
-	Items   []ShareItem `json:"items,omitempty"`   // 分享项列表
-	IsMulti bool        `json:"is_multi"`          // 是否多文件模式
+	Items   []ShareItem `json:"items,omitempty"` // 分享项列表
+	IsMulti bool        `json:"is_multi"`        // 是否多文件模式
 
 	// 向后兼容 (单文件时填充)
 	Path      string    `json:"path,omitempty"`
@@ -59,16 +77,175 @@ type State struct {
 	ServerPID int `json:"server_pid"`
 	TunnelPID int `json:"tunnel_pid"`
 
-	Username string `json:"username,omitempty"`
-	Password string `json:"password,omitempty"`
+	Username        string      `json:"username,omitempty"`
+	Password        string      `json:"-"`                          // 口令明文只在内存中: 刚启动分享时或从钥匙串读取
+	PasswordHash    string      `json:"password_hash,omitempty"`    // 口令哈希 (auth.HashPassword)，服务进程用它校验口令
+	KeychainAccount string      `json:"keychain_account,omitempty"` // 非空时口令保存在系统钥匙串中
+	SplitSecret     string      `json:"split_secret,omitempty"`     // 口令单独交付的方式，非空时不与链接一起显示
+	PassStyle       string      `json:"pass_style,omitempty"`       // 随机口令的样式 (--pass-style)，更换口令时沿用
+	PassWords       int         `json:"pass_words,omitempty"`       // --pass-style words 的单词数
+	SecretRevealed  bool        `json:"secret_revealed,omitempty"`  // 已通过 cfshare reveal 查看过口令
+	LinkSecret      string      `json:"-"`                          // 签名直接下载链接 (cfshare qr) 的密钥，保存在 Secrets 中
+	OwnerToken      string      `json:"-"`                          // 分享者本人的令牌，持有者走优先通道 (cfshare owner)，保存在 Secrets 中
+	OwnerTokenHash  string      `json:"owner_token_hash,omitempty"` // 分享者令牌的哈希 (auth.HashToken)，服务进程用它校验令牌
+	SecretsAccount  string      `json:"secrets_account,omitempty"`  // 非空时令牌和签名密钥保存在系统钥匙串中，否则在 secrets.json
+	Users           []auth.User `json:"users,omitempty"`            // cfshare user add 添加的用户，各自有口令和权限
+
+	Auth            string        `json:"auth,omitempty"`             // 访问者的认证方式，为空时为 AuthBasic
+	SessionSecret   string        `json:"-"`                          // AuthForm 会话 cookie 的签名密钥，保存在 Secrets 中
+	SessionLifetime time.Duration `json:"session_lifetime,omitempty"` // AuthForm 登录后会话的有效期
+	LinkToken       string        `json:"-"`                          // AuthToken 分享链接中的访问令牌，保存在 Secrets 中
+	LinkTokenHash   string        `json:"link_token_hash,omitempty"`  // 访问令牌的哈希，服务进程用它校验链接
 
 	StartTime  time.Time `json:"start_time"`
 	LastAccess time.Time `json:"last_access,omitempty"`
 
-	RequestCount int            `json:"request_count"`
-	RecentAccess []AccessRecord `json:"recent_access,omitempty"`
+	RequestCount int `json:"request_count"`
+
+	PublicURL      string `json:"public_url"`
+	TunnelName     string `json:"tunnel_name,omitempty"`     // 分享使用的 tunnel，服务进程重启 tunnel 时使用
+	TunnelProtocol string `json:"tunnel_protocol,omitempty"` // cloudflared 的协议 (--protocol)，为空时同 auto
+	TunnelToken    string `json:"tunnel_token,omitempty"`    // 控制台管理的 tunnel 的 connector 令牌 (--tunnel-token)，服务进程重启 tunnel 时使用
+	Quick          bool   `json:"quick,omitempty"`           // 使用 Quick Tunnel (--quick)，PublicURL 是随机分配的 trycloudflare.com 地址
+	Mount          string `json:"mount,omitempty"`           // 挂载的路径前缀 (--mount)，如 /docs，为空时占用整个主机名
+	Hostname       string `json:"hostname,omitempty"`        // 分享独占的主机名 (--hostname)，为空时使用 tunnel 的默认主机名
+	Slug           string `json:"slug,omitempty"`            // 分享所在的随机路径 (--slug)，挂载前缀之下，PublicURL 已包含它
+	RouterPort     int    `json:"router_port,omitempty"`     // 经路由进程 (--router) 转发时 cloudflared 指向的端口
+
+	// 文件请求模式
+	RequestToken     string    `json:"-"`                            // 上传链接中的随机令牌，保存在 Secrets 中
+	RequestTokenHash string    `json:"request_token_hash,omitempty"` // 上传令牌的哈希，服务进程用它校验上传链接
+	RequestMessage   string    `json:"request_message,omitempty"`    // 展示给上传者的说明
+	MaxUploads       int       `json:"max_uploads,omitempty"`        // 允许上传的文件数 (0 表示不限)
+	ExpiresAt        time.Time `json:"expires_at,omitempty"`
+	MaxFileSize      int64     `json:"max_file_size,omitempty"`    // 单个上传文件的大小上限 (0 表示不限)
+	UploadQuota      int64     `json:"upload_quota,omitempty"`     // 收件目录的总大小配额 (0 表示不限)
+	MaxRequestSize   int64     `json:"max_request_size,omitempty"` // 表单上传一个请求的总大小上限 (0 表示 config.DefaultMaxRequestSize)
+
+	Receipts        bool   `json:"receipts,omitempty"`          // 是否允许接收方确认收到并生成签收凭证
+	Watch           bool   `json:"watch,omitempty"`             // 是否监视分享文件的变化，内容改变时版本号加一并推送通知
+	TermsPath       string `json:"terms_path,omitempty"`        // 访问前必须同意的条款文件
+	NoStream        bool   `json:"no_stream,omitempty"`         // 禁用媒体文件的在线播放
+	NotifyURL       string `json:"notify_url,omitempty"`        // 分享结束时接收下载汇总的 webhook
+	Honeypot        bool   `json:"honeypot,omitempty"`          // 请求诱饵路径的 IP 会被临时封禁
+	MaxAuthFailures int    `json:"max_auth_failures,omitempty"` // 同一 IP 口令错误达到该次数后被临时锁定 (0 表示不锁定)
+	ReqRate         int    `json:"req_rate,omitempty"`          // 单个 IP 每秒的请求数上限 (0 表示不限)
+	Realm           string `json:"realm,omitempty"`             // Basic Auth 的 realm
+	Contact         string `json:"contact,omitempty"`           // 错误页面上显示的联系方式
+	Theme           string `json:"theme,omitempty"`             // 目录列表的内置主题
+	Lang            string `json:"lang,omitempty"`              // 网页界面语言，为空时按访问者协商
+
+	Exclude        []string `json:"exclude,omitempty"`         // 对访问者隐藏的 glob 模式
+	AllowIPs       []string `json:"allow_ips,omitempty"`       // --allow-ip: 只允许这些网段的访问者 (CF-Connecting-IP)
+	BlockIPs       []string `json:"block_ips,omitempty"`       // --block-ip: 拒绝这些网段的访问者
+	AllowCountries []string `json:"allow_countries,omitempty"` // --allow-country: 只允许这些国家的访问者 (CF-IPCountry)
+	BlockCountries []string `json:"block_countries,omitempty"` // --block-country: 拒绝这些国家的访问者
+	ShowHidden     bool     `json:"show_hidden,omitempty"`     // 显示以 . 开头的文件，默认隐藏
+	Title          string   `json:"title,omitempty"`           // 目录页面的标题
+	Message        string   `json:"message,omitempty"`         // 目录页面顶部的说明
+	Footer         string   `json:"footer,omitempty"`          // 目录页面底部的文字
+
+	GetScript     bool   `json:"get_script,omitempty"`     // 提供 /__get.sh 下载脚本
+	StreamName    string `json:"stream_name,omitempty"`    // 标准输入流的下载文件名 (--as)
+	Paste         bool   `json:"paste,omitempty"`          // cfshare send 分享的文本片段，显示为页面而不是下载
+	AllowIndexing bool   `json:"allow_indexing,omitempty"` // 不发送 noindex，也不提供 robots.txt
+	StatusPage    bool   `json:"status_page,omitempty"`    // 提供无需口令的 /__status__ 状态页
+	SplitSize     int64  `json:"split_size,omitempty"`     // 分卷下载每卷的大小 (0 表示默认值)
+	ConfirmSize   int64  `json:"confirm_size,omitempty"`   // 浏览器下载不小于该大小的文件前先显示确认页 (0 表示关闭)
+
+	MaxRequestDuration time.Duration `json:"max_request_duration,omitempty"` // 单个请求的最长时间 (0 表示不限)
+	MinRate            int64         `json:"min_rate,omitempty"`             // 最低传输速率，字节/秒 (0 表示不检查)
+	LimitRate          int64         `json:"limit_rate,omitempty"`           // 所有连接合计的下载带宽上限，字节/秒 (0 表示不限)
+	LimitRateConn      int64         `json:"limit_rate_conn,omitempty"`      // 单个连接的下载带宽上限，字节/秒 (0 表示不限)
+	MaxConns           int           `json:"max_conns,omitempty"`            // 同时进行的传输总数上限 (0 表示不限)
+	MaxConnsPerIP      int           `json:"max_conns_per_ip,omitempty"`     // 单个 IP 同时进行的传输上限 (0 表示不限)
+	WaitingRoom        int           `json:"waiting_room,omitempty"`         // 同时下载的访问者上限，其余访问者排队 (0 表示关闭)
+	Approve            bool          `json:"approve,omitempty"`              // 新访问者须经分享者批准才能访问 (--approve)
+	AskName            bool          `json:"ask_name,omitempty"`             // 访问者下载前须填写姓名，记录到访问日志 (--ask-name)
+	ServeWindow        string        `json:"serve_window,omitempty"`         // 每日提供下载的时段 (本机时区)，如 01:00-07:00
+	EndedGrace         time.Duration `json:"ended_grace,omitempty"`          // 分享停止后继续显示 "分享已结束" 提示的时长 (0 表示不显示)
+
+	StopAt              time.Time `json:"stop_at,omitempty"`                // 分享自动结束的时间 (--expire)
+	Burn                string    `json:"burn,omitempty"`                   // 一次性链接: BurnItem 或 BurnShare
+	MaxDownloads        int       `json:"max_downloads,omitempty"`          // 完整下载达到该次数后结束分享 (0 表示不限)
+	MaxDownloadsPerItem bool      `json:"max_downloads_per_item,omitempty"` // MaxDownloads 按每个分享项计数
+
+	Mirrors []MirrorLink `json:"mirrors,omitempty"` // 对象存储中的镜像，本机离线时的备用链接
+
+	DebugAddr   string `json:"debug_addr,omitempty"`   // pprof/expvar 调试监听地址，只允许本机回环地址
+	MetricsAddr string `json:"metrics_addr,omitempty"` // Prometheus /metrics 监听地址，只允许本机回环地址
+
+	StorageQuota int64 `json:"storage_quota,omitempty"` // ~/.cfshare 的总大小配额 (0 表示不限)
+
+	StatsBackend   string        `json:"stats_backend,omitempty"`   // 原始访问记录的存储方式: json (默认) 或 sqlite
+	StatsRetention time.Duration `json:"stats_retention,omitempty"` // 原始访问记录的保留时长，0 为默认的 30 天，负数表示永久保留
+}
+
+// 口令单独交付的方式 (--split-secret)
+const (
+	SecretReveal   = "reveal"   // 通过 cfshare reveal 在本机查看一次
+	SecretKeychain = "keychain" // 只保存在系统钥匙串中
+	SecretQR       = "qr"       // 只以二维码形式显示
+)
+
+// 访问者的认证方式 (--auth)
+const (
+	AuthBasic = "basic" // 浏览器弹出的 Basic Auth 登录框
+	AuthForm  = "form"  // 登录页和会话 cookie，适合手机和会删除 Authorization 头部的代理
+	AuthToken = "token" // 链接中带访问令牌 (/t/<token>/)，收件人点开链接即可访问，没有口令
+)
+
+// displayPassword 返回状态输出中显示的口令，单独交付或明文已不可得时用提示代替
+func (s *State) displayPassword() string {
+	if s.SplitSecret != "" {
+		return "(单独交付，使用 cfshare reveal 查看)"
+	}
+	if s.Password == "" {
+		return "(只在启动分享时显示，忘记时使用 cfshare rotate-password 生成新口令)"
+	}
+	return s.Password
+}
+
+// SetPassword 设置分享口令。state.json 中只保存口令哈希，明文只在内存中，
+// 启动分享或 cfshare rotate-password 时显示一次 (托管到钥匙串时可以再从钥匙串读取)。
+func (s *State) SetPassword(password string) {
+	s.Password = password
+	s.PasswordHash = auth.HashPassword(password)
+}
+
+// migratePassword 处理旧版本以明文写入 state.json 的口令: 读取时转换为哈希，
+// 之后保存时不再写入明文
+func (s *State) migratePassword(data []byte) {
+	if s.PasswordHash != "" {
+		return
+	}
+	var legacy struct {
+		Password string `json:"password"`
+	}
+	if json.Unmarshal(data, &legacy) == nil && legacy.Password != "" {
+		s.SetPassword(legacy.Password)
+	}
+}
+
+// BasePath 返回分享在主机名下的路径前缀: 挂载前缀加上随机路径 (--slug)，占用整个主机名时为空
+func (s *State) BasePath() string {
+	if s.Slug == "" {
+		return s.Mount
+	}
+	return s.Mount + "/" + s.Slug
+}
+
+// ShareURL 返回发给访问者的链接，--auth token 时包含访问令牌
+func (s *State) ShareURL() string {
+	if s.Auth == AuthToken && s.LinkToken != "" {
+		return strings.TrimSuffix(s.PublicURL, "/") + "/t/" + s.LinkToken + "/"
+	}
+	return s.PublicURL
+}
 
-	PublicURL string `json:"public_url"`
+// RequestURL 返回文件请求模式下的上传链接
+func (s *State) RequestURL() string {
+	return strings.TrimSuffix(s.PublicURL, "/") + "/r/" + s.RequestToken + "/"
 }
 
 func Load() (*State, error) {
@@ -81,6 +258,11 @@ func Load() (*State, error) {
 		return nil, fmt.Errorf("read state file: %w", err)
 	}
 
+	data, err = unseal(data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt state file: %w", err)
+	}
+
 	var s State
 	if err := json.Unmarshal(data, &s); err != nil {
 		return nil, fmt.Errorf("parse state file: %w", err)
@@ -95,6 +277,16 @@ func Load() (*State, error) {
 		}}
 		s.IsMulti = false
 	}
+	s.migratePassword(data)
+	s.migrateSecrets(data)
+	s.loadSecrets()
+
+	// 口令保存在系统钥匙串中
+	if s.KeychainAccount != "" && s.Password == "" {
+		if password, err := keychain.Get(s.KeychainAccount); err == nil {
+			s.Password = password
+		}
+	}
 
 	return &s, nil
 }
@@ -118,11 +310,22 @@ func (s *State) Save() error {
 		s.ShareType = ""
 	}
 
+	// 令牌和签名密钥单独保存，state.json 中只有令牌哈希
+	s.HashTokens()
+	if err := s.saveSecrets(); err != nil {
+		return err
+	}
+
 	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal state: %w", err)
 	}
 
+	data, err = encodeFile(data)
+	if err != nil {
+		return fmt.Errorf("encrypt state: %w", err)
+	}
+
 	path := config.GetStatePath()
 	if err := os.WriteFile(path, data, 0600); err != nil {
 		return fmt.Errorf("write state file: %w", err)
@@ -132,6 +335,12 @@ func (s *State) Save() error {
 }
 
 func Clear() error {
+	ClearHealth()
+	ClearResources()
+	ClearItemSizes()
+	ClearItemVersions()
+	ClearVisitors()
+	os.Remove(config.GetSecretsPath())
 	path := config.GetStatePath()
 	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("remove state file: %w", err)
@@ -139,19 +348,6 @@ func Clear() error {
 	return nil
 }
 
-func (s *State) RecordAccess(record AccessRecord) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.RequestCount++
-	s.LastAccess = record.Time
-
-	s.RecentAccess = append(s.RecentAccess, record)
-	if len(s.RecentAccess) > 10 {
-		s.RecentAccess = s.RecentAccess[len(s.RecentAccess)-10:]
-	}
-}
-
 func (s *State) IsRunning() bool {
 	if s == nil || s.ServerPID == 0 {
 		return false
@@ -169,25 +365,130 @@ func (s *State) FormatStatus() string {
 ━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━
 URL:        %s
 Mode:       %s
-`, s.PublicURL, s.Mode)
+`, s.ShareURL(), s.Mode)
+	if s.Quick {
+		status += "Tunnel:     Quick Tunnel (trycloudflare.com，重新分享后地址会改变)\n"
+	}
+	if s.TunnelToken != "" {
+		status += "Tunnel:     Zero Trust 控制台管理 (--tunnel-token)\n"
+	}
 
-	// 多文件显示
-	if s.IsMulti {
+	// 文件请求模式
+	if s.Mode == ModeRequest {
+		status += s.formatRequestInfo()
+	} else if s.IsMulti {
+		sizes := LoadItemSizes()
+		versions := LoadItemVersions()
 		status += fmt.Sprintf("Items:      %d 个项目\n", len(s.Items))
 		for i, item := range s.Items {
-			status += fmt.Sprintf("  [%d] %s (%s) - %s\n", i+1, item.Name, item.ShareType, item.Path)
+			kind := string(item.ShareType)
+			if size, ok := sizes[item.Name]; ok && item.ShareType == TypeDir {
+				kind += ", " + formatItemSize(size, ok)
+			}
+			if text := formatItemVersion(versions[item.Name]); text != "" {
+				kind += ", " + text
+			}
+			status += fmt.Sprintf("  [%d] %s (%s) - %s\n", i+1, item.Name, kind, item.Path)
 		}
 	} else if len(s.Items) > 0 {
 		status += fmt.Sprintf("Path:       %s\nType:       %s\n", s.Items[0].Path, s.Items[0].ShareType)
+		if s.Items[0].ShareType == TypeDir {
+			size, ok := LoadItemSizes()[s.Items[0].Name]
+			if text := formatItemSize(size, ok); text != "" {
+				status += "Size:       " + text + "\n"
+			}
+		}
+		if text := formatItemVersion(LoadItemVersions()[s.Items[0].Name]); text != "" {
+			status += "Version:    " + text + "\n"
+		}
 	} else {
 		// 兼容旧格式
 		status += fmt.Sprintf("Path:       %s\nType:       %s\n", s.Path, s.ShareType)
 	}
 
-	if s.Mode == ModeProtected {
+	if s.Auth == AuthToken {
+		status += "Login:      链接中包含访问令牌，无需口令\n"
+	} else if s.Mode == ModeProtected {
 		status += fmt.Sprintf(`Username:   %s
 Password:   %s
-`, s.Username, s.Password)
+`, s.Username, s.displayPassword())
+		if s.Auth == AuthForm {
+			status += fmt.Sprintf("Login:      网页登录，会话有效 %s\n", formatRemaining(s.SessionLifetime))
+		}
+		if len(s.Users) > 0 {
+			status += fmt.Sprintf("Users:      %d 个用户\n", len(s.Users))
+			for _, u := range s.Users {
+				status += "  " + FormatUser(u) + "\n"
+			}
+		}
+	}
+	if len(s.AllowIPs) > 0 {
+		status += "Allow IP:   " + strings.Join(s.AllowIPs, ", ") + "\n"
+	}
+	if len(s.BlockIPs) > 0 {
+		status += "Block IP:   " + strings.Join(s.BlockIPs, ", ") + "\n"
+	}
+	if len(s.AllowCountries) > 0 {
+		status += "Allow Geo:  " + strings.Join(s.AllowCountries, ", ") + "\n"
+	}
+	if len(s.BlockCountries) > 0 {
+		status += "Block Geo:  " + strings.Join(s.BlockCountries, ", ") + "\n"
+	}
+	if s.WaitingRoom > 0 {
+		status += fmt.Sprintf("Waiting:    最多 %d 个访问者同时下载，其余排队\n", s.WaitingRoom)
+	}
+	if s.Approve {
+		pending := PendingVisitors()
+		status += fmt.Sprintf("Approve:    新访问者须经批准，%d 个等待中\n", len(pending))
+		for i, v := range pending {
+			status += fmt.Sprintf("  %d. %s，%s 请求 %s\n", i+1, v.Describe(), v.FirstSeen.Local().Format("15:04"), v.Path)
+		}
+	}
+	if s.ServeWindow != "" {
+		status += "Window:     " + s.ServeWindow + " (时段外下载返回 503)\n"
+	}
+
+	if len(s.Mirrors) > 0 {
+		status += "Mirrors:\n"
+		for _, m := range s.Mirrors {
+			expired := ""
+			if time.Now().After(m.ExpiresAt) {
+				expired = "，已过期"
+			}
+			status += fmt.Sprintf("  %s (至 %s%s)\n    %s\n", m.Name, m.ExpiresAt.Format("2006-01-02 15:04"), expired, m.URL)
+		}
+	}
+
+	if s.Burn != "" {
+		status += "Burn:       " + s.formatBurn() + "\n"
+	}
+	if !s.StopAt.IsZero() {
+		status += "Expires:    " + formatStopAt(s.StopAt, time.Now()) + "\n"
+	}
+	if s.MaxDownloads > 0 {
+		scope := "合计"
+		if s.MaxDownloadsPerItem {
+			scope = "每个项目"
+		}
+		status += fmt.Sprintf("Downloads:  最多 %d 次 (%s)，达到后自动结束分享\n", s.MaxDownloads, scope)
+	}
+	if limit := s.formatRateLimit(); limit != "" {
+		status += "Rate Limit: " + limit + "\n"
+	}
+	if s.ReqRate > 0 {
+		status += fmt.Sprintf("Req Rate:   每个 IP 每秒 %d 个请求\n", s.ReqRate)
+	}
+	if s.MaxAuthFailures > 0 {
+		status += fmt.Sprintf("Lockout:    口令错误 %d 次锁定 IP %s\n", s.MaxAuthFailures, config.AuthLockoutDuration)
+		for _, l := range s.lockouts() {
+			status += "  🔒 " + l + "\n"
+		}
+	}
+	if s.DebugAddr != "" {
+		status += "Debug:      http://" + s.DebugAddr + "/debug/pprof/ (仅本机)\n"
+	}
+	if s.MetricsAddr != "" {
+		status += "Metrics:    http://" + s.MetricsAddr + "/metrics (仅本机)\n"
 	}
 
 	status += fmt.Sprintf(`
@@ -198,22 +499,59 @@ Port:       %d
 
 Started:    %s
 `, s.runningStatus(), s.ServerPID, s.TunnelPID, s.Port, s.StartTime.Format("2006-01-02 15:04:05"))
+	if s.IsRunning() {
+		status += formatHealth(LoadHealth())
+		status += formatResources(LoadResources())
+	}
+	status += formatStorage(storage.Measure(), s.StorageQuota)
 
-	requestCount, lastAccess, _ := LoadStats()
+	requestCount, lastAccess := LoadStats()
 	if requestCount > 0 {
 		status += fmt.Sprintf(`
 访问统计
 ────────────────────────────────────────
 Requests:   %d
 Last Access: %s
-`, requestCount, lastAccess.Format("2006-01-02 15:04:05"))
+Records:    %s
+`, requestCount, lastAccess.Format("2006-01-02 15:04:05"), s.formatStatsStore())
 	}
 
 	return status
 }
 
+// formatRequestInfo 格式化文件请求模式的信息
+func (s *State) formatRequestInfo() string {
+	info := fmt.Sprintf("Upload URL: %s\n", s.RequestURL())
+	if s.RequestMessage != "" {
+		info += fmt.Sprintf("Message:    %s\n", s.RequestMessage)
+	}
+	if len(s.Items) > 0 {
+		info += fmt.Sprintf("Inbox:      %s\n", s.Items[0].Path)
+	}
+	if s.MaxUploads > 0 {
+		info += fmt.Sprintf("Max Files:  %d\n", s.MaxUploads)
+	} else {
+		info += "Max Files:  不限\n"
+	}
+	if s.MaxFileSize > 0 {
+		info += fmt.Sprintf("Max Size:   %s\n", formatBytes(s.MaxFileSize))
+	}
+	if s.UploadQuota > 0 {
+		info += fmt.Sprintf("Quota:      %s\n", formatBytes(s.UploadQuota))
+	}
+	if !s.ExpiresAt.IsZero() {
+		info += fmt.Sprintf("Expires:    %s\n", s.ExpiresAt.Format("2006-01-02 15:04:05"))
+	}
+	return info
+}
+
 func (s *State) runningStatus() string {
 	if s.IsRunning() {
+		if h := LoadHealth(); h != nil && h.Degraded {
+			return "🟠 降级 (公开地址不可达)"
+		} else if h != nil && h.TunnelDown {
+			return "🟠 降级 (tunnel 没有边缘连接)"
+		}
 		return "🟢 服务运行中"
 	}
 	return "🔴 服务已停止"
@@ -225,7 +563,15 @@ func (s *State) FormatShareOutput() string {
 
 URL:      %s
 Mode:     %s
-`, s.PublicURL, s.Mode)
+`, s.ShareURL(), s.Mode)
+
+	if s.Mode == ModeRequest {
+		output += s.formatRequestInfo()
+		if s.Mount != "" || s.Hostname != "" || s.RouterPort > 0 {
+			output += s.formatIngress()
+		}
+		return output
+	}
 
 	// 多文件显示
 	if s.IsMulti {
@@ -239,76 +585,357 @@ Mode:     %s
 		output += fmt.Sprintf("Path:     %s\nType:     %s\n", s.Path, s.ShareType)
 	}
 
-	if s.Mode == ModeProtected {
+	if s.Auth == AuthToken {
+		output += "\n🔗 链接中包含访问令牌，收件人打开链接即可访问，请只发给需要的人\n"
+	} else if s.Mode == ModeProtected {
 		output += fmt.Sprintf(`
 Username: %s
 Password: %s
-`, s.Username, s.Password)
+`, s.Username, s.displayPassword())
+		if s.Auth == AuthForm {
+			output += fmt.Sprintf("Login:    网页登录，会话有效 %s，%s/__logout 退出\n", formatRemaining(s.SessionLifetime), strings.TrimSuffix(s.PublicURL, "/"))
+		}
 	} else {
 		output += "\n⚠️  公开分享，任何人都可以访问\n"
 	}
 
+	if s.Watch {
+		output += "\n📝 已启用变更监视，分享的文件内容改变时列表显示新版本号"
+		if s.NotifyURL != "" {
+			output += "并推送通知"
+		}
+		output += "\n"
+	}
+
+	if s.Receipts {
+		output += "\n✔ 已启用签收确认，使用 cfshare receipts 查看签收记录\n"
+	}
+
+	if s.TermsPath != "" {
+		output += fmt.Sprintf("📜 访问者需先同意条款: %s\n", s.TermsPath)
+	}
+
+	if s.Burn == BurnItem {
+		output += "🔥 一次性链接: 每个项目被完整下载一次后失效，之后返回 410\n"
+	} else if s.Burn == BurnShare {
+		output += "🔥 一次性链接: 任意内容被完整下载一次后整个分享失效，之后返回 410\n"
+	}
+	if !s.StopAt.IsZero() {
+		output += fmt.Sprintf("⏰ 分享将于 %s 自动结束\n", formatStopAt(s.StopAt, time.Now()))
+	}
+	if s.StatusPage {
+		output += fmt.Sprintf("📣 公开状态页 (无需口令): %s/__status__\n", strings.TrimSuffix(s.PublicURL, "/"))
+	}
+	if s.EndedGrace > 0 {
+		output += fmt.Sprintf("🪧 分享停止后 %s 内，链接显示\"分享已结束\"提示\n", formatRemaining(s.EndedGrace))
+	}
+
+	if s.ShareType == TypeStream {
+		output += "\n📡 流式分享: 内容只能被第一个下载者取走一次，之后的请求返回 410\n"
+	}
+	if s.Mount != "" || s.Hostname != "" || s.RouterPort > 0 {
+		output += s.formatIngress()
+	}
+
 	return output
 }
 
+// UserStats 是单个访问者身份的访问统计
+type UserStats struct {
+	Requests   int       `json:"requests"`
+	BytesSent  int64     `json:"bytes_sent"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// Stats 是 stats.json 中保存的访问统计
+type Stats struct {
+	RequestCount int                   `json:"request_count"`
+	BytesSent    int64                 `json:"bytes_sent,omitempty"`
+	LastAccess   time.Time             `json:"last_access,omitempty"`
+	ByUser       map[string]*UserStats `json:"by_user,omitempty"`  // 按访问者身份聚合，匿名访问的键为空字符串
+	Browsers     map[string]int        `json:"browsers,omitempty"` // 按 User-Agent 家族统计的请求数
+	Devices      map[string]int        `json:"devices,omitempty"`  // 按设备类别统计的请求数
+	Aborted      map[string]int        `json:"aborted,omitempty"`  // 按原因统计被中止的请求数 (deadline、slow)
+	Burned       *Burned               `json:"burned,omitempty"`   // 一次性分享中已失效的项目
+}
 
 // UpdateAccessStats 只更新访问统计（使用文件锁避免竞态）
 func UpdateAccessStats(record AccessRecord) error {
-	statsPath := config.GetConfigDir() + "/stats.json"
-	
+	return updateStats(func(stats *Stats) {
+		stats.RequestCount++
+		stats.BytesSent += record.BytesSent
+		stats.LastAccess = record.Time
+
+		if stats.ByUser == nil {
+			stats.ByUser = make(map[string]*UserStats)
+		}
+		us := stats.ByUser[record.User]
+		if us == nil {
+			us = &UserStats{}
+			stats.ByUser[record.User] = us
+		}
+		us.Requests++
+		us.BytesSent += record.BytesSent
+		us.LastAccess = record.Time
+
+		family, device := ClassifyUserAgent(record.UserAgent)
+		if stats.Browsers == nil {
+			stats.Browsers = make(map[string]int)
+		}
+		if stats.Devices == nil {
+			stats.Devices = make(map[string]int)
+		}
+		stats.Browsers[family]++
+		stats.Devices[device]++
+
+		if record.Aborted != "" {
+			if stats.Aborted == nil {
+				stats.Aborted = make(map[string]int)
+			}
+			stats.Aborted[record.Aborted]++
+		}
+	})
+}
+
+// updateStats 在文件锁内读取、修改并写回 stats.json，服务进程的并发请求
+// 和平滑升级时的新旧进程都通过它更新统计
+func updateStats(update func(stats *Stats)) error {
+	statsPath := config.GetStatsPath()
+
 	// 打开或创建 stats 文件并加锁
 	f, err := os.OpenFile(statsPath, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	
+
 	// 加文件锁
 	if err := lockFile(f); err != nil {
 		return err
 	}
 	defer unlockFile(f)
-	
+
 	// 读取现有统计
-	var stats struct {
-		RequestCount int            `json:"request_count"`
-		LastAccess   time.Time      `json:"last_access,omitempty"`
-		RecentAccess []AccessRecord `json:"recent_access,omitempty"`
-	}
-	
+	var stats Stats
 	data, _ := os.ReadFile(statsPath)
+	data, err = unseal(data)
+	if err != nil {
+		return err
+	}
 	json.Unmarshal(data, &stats)
-	
-	// 更新统计
-	stats.RequestCount++
-	stats.LastAccess = record.Time
-	stats.RecentAccess = append(stats.RecentAccess, record)
-	if len(stats.RecentAccess) > 10 {
-		stats.RecentAccess = stats.RecentAccess[len(stats.RecentAccess)-10:]
-	}
-	
+
+	update(&stats)
+
 	// 写回
 	newData, _ := json.MarshalIndent(stats, "", "  ")
+	newData, err = encodeFile(newData)
+	if err != nil {
+		return err
+	}
 	f.Truncate(0)
 	f.Seek(0, 0)
 	f.Write(newData)
-	
+
 	return nil
 }
 
+// formatStopAt 格式化 --expire 的结束时间和剩余时间，如 "2026-10-16 18:00 (剩余 1h59m)"
+func formatStopAt(stopAt, now time.Time) string {
+	out := stopAt.Format("2006-01-02 15:04")
+	if left := stopAt.Sub(now); left > 0 {
+		return out + fmt.Sprintf(" (剩余 %s)", formatRemaining(left))
+	}
+	return out + " (已到期)"
+}
+
+// formatRemaining 把剩余时间格式化为 "2d3h"、"1h59m"、"45m"，不足一分钟时为 "<1m"
+func formatRemaining(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm", minutes)
+	default:
+		return "<1m"
+	}
+}
+
+// formatRateLimit 返回 --limit-rate 的说明，如 "5.00 MB/s 合计, 1.00 MB/s 每连接"，未限速时为空
+func (s *State) formatRateLimit() string {
+	var parts []string
+	if s.LimitRate > 0 {
+		parts = append(parts, formatBytes(s.LimitRate)+"/s 合计")
+	}
+	if s.LimitRateConn > 0 {
+		parts = append(parts, formatBytes(s.LimitRateConn)+"/s 每连接")
+	}
+	return strings.Join(parts, ", ")
+}
+
 // LoadStats 加载访问统计
-func LoadStats() (requestCount int, lastAccess time.Time, recentAccess []AccessRecord) {
-	statsPath := config.GetConfigDir() + "/stats.json"
-	data, err := os.ReadFile(statsPath)
+func LoadStats() (requestCount int, lastAccess time.Time) {
+	stats := ReadStats()
+	return stats.RequestCount, stats.LastAccess
+}
+
+// ReadStats 读取完整的访问统计，文件不存在时返回空统计
+func ReadStats() *Stats {
+	var stats Stats
+	data, err := os.ReadFile(config.GetStatsPath())
 	if err != nil {
-		return
+		return &stats
 	}
-	var stats struct {
-		RequestCount int            `json:"request_count"`
-		LastAccess   time.Time      `json:"last_access,omitempty"`
-		RecentAccess []AccessRecord `json:"recent_access,omitempty"`
+	if data, err = unseal(data); err != nil {
+		return &stats
 	}
 	json.Unmarshal(data, &stats)
-	return stats.RequestCount, stats.LastAccess, stats.RecentAccess
+	return &stats
+}
+
+// Format 格式化访问统计，时间以 UTC 显示
+func (st *Stats) Format(byUser bool) string {
+	return st.FormatIn(byUser, time.UTC)
+}
+
+// FormatIn 格式化访问统计，时间以 loc 时区显示，byUser 为 true 时按访问者身份分组显示
+func (st *Stats) FormatIn(byUser bool, loc *time.Location) string {
+	if st.RequestCount == 0 {
+		return "暂无访问统计"
+	}
+
+	out := fmt.Sprintf(`访问统计
+────────────────────────────────────────
+Requests:    %d
+Bytes Sent:  %s
+Last Access: %s
+`, st.RequestCount, formatBytes(st.BytesSent), DisplayTime(st.LastAccess, loc))
+
+	if len(st.Browsers) > 0 {
+		out += "Browsers:    " + formatCounts(st.Browsers) + "\n"
+	}
+	if len(st.Devices) > 0 {
+		out += "Devices:     " + formatCounts(st.Devices) + "\n"
+	}
+	if len(st.Aborted) > 0 {
+		out += "Aborted:     " + formatCounts(st.Aborted) + "\n"
+	}
+
+	if !byUser {
+		return out
+	}
+
+	users := make([]string, 0, len(st.ByUser))
+	for user := range st.ByUser {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return st.ByUser[users[i]].BytesSent > st.ByUser[users[j]].BytesSent
+	})
+
+	out += "\n按访问者\n────────────────────────────────────────\n"
+	out += fmt.Sprintf("%-20s %8s %12s  %s\n", "USER", "REQUESTS", "BYTES", "LAST ACCESS")
+	for _, user := range users {
+		us := st.ByUser[user]
+		name := user
+		if name == "" {
+			name = "(匿名)"
+		}
+		out += fmt.Sprintf("%-20s %8d %12s  %s\n", name, us.Requests, formatBytes(us.BytesSent), DisplayTime(us.LastAccess, loc))
+	}
+	return out
 }
 
+// formatCounts 按数量从多到少格式化为 "Chrome 12, curl 4, Safari 2"
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s %d", k, counts[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// FormatTransfer 返回一次传输的大小、耗时和速度，如 "12.00 MB / 4.1s, 2.93 MB/s"。
+// 已知完整大小但没有传完时附上进度，以及按当时的速度还需要多久。
+func FormatTransfer(bytes, contentLength int64, d time.Duration) string {
+	if d < time.Minute {
+		d = d.Round(100 * time.Millisecond)
+	} else {
+		d = d.Round(time.Second)
+	}
+	out := fmt.Sprintf("%s / %s", formatBytes(bytes), d)
+	if d <= 0 {
+		return out
+	}
+	rate := float64(bytes) / d.Seconds()
+	out += fmt.Sprintf(", %s/s", formatBytes(int64(rate)))
+	if contentLength > bytes && rate > 0 {
+		eta := time.Duration(float64(contentLength-bytes) / rate * float64(time.Second)).Round(time.Second)
+		out += fmt.Sprintf(" (已传 %d%%，按此速度还需 %s)", bytes*100/contentLength, eta)
+	}
+	return out
+}
+
+// formatStorage 返回 ~/.cfshare 的占用，如 "Storage:    1.20 GB / 5.00 GB (收件 1.00 GB, 缓存 200.00 MB, ...)"
+func formatStorage(u storage.Usage, quota int64) string {
+	out := "Storage:    " + formatBytes(u.Total())
+	if quota > 0 {
+		out += " / " + formatBytes(quota)
+		if u.Total() >= quota {
+			out += " ⚠️  已满"
+		}
+	}
+	var parts []string
+	for _, p := range []struct {
+		name string
+		size int64
+	}{{"收件", u.Inbox}, {"文本", u.Pastes}, {"缓存", u.Cache}, {"日志", u.Logs}, {"其他", u.Other}} {
+		if p.size > 0 {
+			parts = append(parts, p.name+" "+formatBytes(p.size))
+		}
+	}
+	if len(parts) > 0 {
+		out += " (" + strings.Join(parts, ", ") + ")"
+	}
+	return out + "\n"
+}
+
+// FormatBytes 把字节数格式化为 "1.50 MB" 这样的可读大小
+func FormatBytes(size int64) string {
+	return formatBytes(size)
+}
+
+func formatBytes(size int64) string {
+	const (
+		KB = 1024
+		MB = KB * 1024
+		GB = MB * 1024
+	)
+
+	switch {
+	case size >= GB:
+		return fmt.Sprintf("%.2f GB", float64(size)/GB)
+	case size >= MB:
+		return fmt.Sprintf("%.2f MB", float64(size)/MB)
+	case size >= KB:
+		return fmt.Sprintf("%.2f KB", float64(size)/KB)
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
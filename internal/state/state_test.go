@@ -2,8 +2,15 @@ package state
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"cfshare/internal/auth"
+	"cfshare/internal/config"
 )
 
 func TestShareItemCreation(t *testing.T) {
@@ -264,3 +271,950 @@ func containsStr(s, substr string) bool {
 	}
 	return false
 }
+
+func TestUpdateAccessStatsByUser(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpDir, ".cfshare"), 0755)
+
+	UpdateAccessStats(AccessRecord{Time: time.Now(), Path: "/a", BytesSent: 100, User: "alice"})
+	UpdateAccessStats(AccessRecord{Time: time.Now(), Path: "/b", BytesSent: 50, User: "alice"})
+	UpdateAccessStats(AccessRecord{Time: time.Now(), Path: "/c", BytesSent: 10})
+
+	stats := ReadStats()
+	if stats.RequestCount != 3 {
+		t.Errorf("expected 3 requests, got %d", stats.RequestCount)
+	}
+	if us := stats.ByUser["alice"]; us == nil || us.Requests != 2 || us.BytesSent != 150 {
+		t.Errorf("unexpected stats for alice: %+v", us)
+	}
+	if us := stats.ByUser[""]; us == nil || us.Requests != 1 {
+		t.Errorf("unexpected anonymous stats: %+v", us)
+	}
+
+	output := stats.Format(true)
+	if !containsStr(output, "alice") || !containsStr(output, "(匿名)") {
+		t.Error("by-user output should list each user")
+	}
+}
+
+func TestSaveOmitsKeychainPassword(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+
+	st := &State{
+		ShareID:         "test123",
+		Mode:            ModeProtected,
+		Username:        "user",
+		Password:        "s3cret-in-keychain",
+		KeychainAccount: "share-test123",
+	}
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, ".cfshare", "state.json"))
+	if containsStr(string(data), "s3cret-in-keychain") {
+		t.Error("password escrowed in keychain should not be written to state.json")
+	}
+	if st.Password != "s3cret-in-keychain" {
+		t.Error("Save should not clear the in-memory password")
+	}
+}
+
+func TestSaveOmitsPlaintextPassword(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	if err := config.EnsureConfigDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{ShareID: "test123", Mode: ModeProtected, Username: "user"}
+	st.SetPassword("s3cret-pass")
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(tmpDir, ".cfshare", "state.json"))
+	if containsStr(string(data), "s3cret-pass") {
+		t.Error("state.json should not contain the plaintext password")
+	}
+	loaded, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Password != "" || !auth.CheckPassword(loaded.PasswordHash, "s3cret-pass") {
+		t.Errorf("expected only the hash after reload, got %q / %q", loaded.Password, loaded.PasswordHash)
+	}
+	if out := loaded.FormatStatus(); !containsStr(out, "cfshare rotate-password") {
+		t.Errorf("status should point to rotate-password when the password is gone:\n%s", out)
+	}
+
+	// 旧版本写入的明文口令读取时转换为哈希，再次保存后不再出现
+	legacy := []byte(`{"share_id":"old","mode":"protected","username":"user","password":"legacy-pass"}`)
+	os.WriteFile(filepath.Join(tmpDir, ".cfshare", "state.json"), legacy, 0600)
+	loaded, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.CheckPassword(loaded.PasswordHash, "legacy-pass") {
+		t.Error("legacy password should be migrated to a hash")
+	}
+	loaded.Save()
+	data, _ = os.ReadFile(filepath.Join(tmpDir, ".cfshare", "state.json"))
+	if containsStr(string(data), "legacy-pass") {
+		t.Error("migrated state should not keep the plaintext password")
+	}
+}
+
+func TestSaveOmitsTokens(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	if err := config.EnsureConfigDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := &State{
+		ShareID:       "test123",
+		Mode:          ModeProtected,
+		Auth:          AuthToken,
+		LinkSecret:    "link-secret-value",
+		OwnerToken:    "owner-token-value",
+		SessionSecret: "session-secret-value",
+		LinkToken:     "link-token-value",
+	}
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(tmpDir, ".cfshare", "state.json"))
+	for _, secret := range []string{"link-secret-value", "owner-token-value", "session-secret-value", "link-token-value"} {
+		if containsStr(string(data), secret) {
+			t.Errorf("state.json should not contain %s:\n%s", secret, data)
+		}
+	}
+	info, err := os.Stat(filepath.Join(tmpDir, ".cfshare", "secrets.json"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected secrets.json with mode 0600: %v", err)
+	}
+
+	loaded, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.OwnerToken != "owner-token-value" || loaded.LinkToken != "link-token-value" || loaded.LinkSecret != "link-secret-value" {
+		t.Errorf("secrets should be restored on load: %+v", loaded.secrets())
+	}
+	if !auth.CheckToken(loaded.OwnerTokenHash, "owner-token-value") || !auth.CheckToken(loaded.LinkTokenHash, "link-token-value") {
+		t.Error("state.json should keep the token hashes")
+	}
+
+	// 旧版本写入 state.json 的明文令牌读取时迁移，再次保存后不再出现
+	Clear()
+	legacy := []byte(`{"share_id":"old","mode":"public","owner_token":"legacy-owner","link_secret":"legacy-link"}`)
+	os.WriteFile(filepath.Join(tmpDir, ".cfshare", "state.json"), legacy, 0600)
+	loaded, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.OwnerToken != "legacy-owner" || loaded.LinkSecret != "legacy-link" {
+		t.Errorf("legacy tokens should be migrated: %+v", loaded.secrets())
+	}
+	loaded.Save()
+	data, _ = os.ReadFile(filepath.Join(tmpDir, ".cfshare", "state.json"))
+	if containsStr(string(data), "legacy-owner") || containsStr(string(data), "legacy-link") {
+		t.Errorf("migrated state should not keep plaintext tokens:\n%s", data)
+	}
+
+	// 分享结束后令牌随状态一起清除
+	Clear()
+	if _, err := os.Stat(filepath.Join(tmpDir, ".cfshare", "secrets.json")); !os.IsNotExist(err) {
+		t.Error("Clear should remove secrets.json")
+	}
+}
+
+func TestEncryptedStateRoundTrip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+
+	if err := EnableEncryption(); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	st := &State{
+		ShareID:  "test123",
+		Mode:     ModeProtected,
+		Username: "user",
+	}
+	st.SetPassword("plaintext-password")
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(tmpDir, ".cfshare", "state.json"))
+	if containsStr(string(data), "plaintext-password") {
+		t.Error("encrypted state file should not contain the password")
+	}
+
+	loaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !auth.CheckPassword(loaded.PasswordHash, "plaintext-password") {
+		t.Errorf("password hash mismatch after decrypt: %q", loaded.PasswordHash)
+	}
+
+	UpdateAccessStats(AccessRecord{Time: time.Now(), Path: "/a", RemoteAddr: "203.0.113.9"})
+	data, _ = os.ReadFile(filepath.Join(tmpDir, ".cfshare", "stats.json"))
+	if containsStr(string(data), "203.0.113.9") {
+		t.Error("encrypted stats file should not contain access history")
+	}
+	store, _ := OpenStatsStore(StatsBackendJSON)
+	store.Append(AccessRecord{Time: time.Now(), Path: "/a", RemoteAddr: "203.0.113.9"})
+	data, _ = os.ReadFile(config.GetStatsRecordsPath())
+	if containsStr(string(data), "203.0.113.9") {
+		t.Error("encrypted stats records should not contain access history")
+	}
+	if records, err := store.Records(time.Time{}); err != nil || len(records) != 1 || records[0].RemoteAddr != "203.0.113.9" {
+		t.Errorf("encrypted records round trip: %v, %v", records, err)
+	}
+	if ReadStats().RequestCount != 1 {
+		t.Error("stats should be readable after encryption")
+	}
+}
+
+func TestBuildSummary(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpDir, ".cfshare"), 0755)
+
+	start := time.Now().Add(-time.Hour)
+	st := &State{
+		StartTime: start,
+		IsMulti:   true,
+		Items: []ShareItem{
+			{Name: "report.pdf", ShareType: TypeFile},
+			{Name: "photos", ShareType: TypeDir},
+			{Name: "notes.txt", ShareType: TypeFile},
+		},
+	}
+
+	ts := func(d time.Duration) string { return start.Add(d).Format(time.RFC3339) }
+	log := `{"time":"` + ts(-time.Hour) + `","path":"/notes.txt","status":200,"bytes":5,"client_ip":"9.9.9.9","download":true}
+{"time":"` + ts(time.Minute) + `","path":"/report.pdf","status":200,"bytes":100,"client_ip":"1.1.1.1","download":true}
+{"time":"` + ts(2*time.Minute) + `","path":"/report.pdf","status":206,"bytes":50,"client_ip":"2.2.2.2","download":true}
+{"time":"` + ts(3*time.Minute) + `","path":"/photos/a.jpg","status":200,"bytes":30,"client_ip":"1.1.1.1","download":true}
+{"time":"` + ts(4*time.Minute) + `","path":"/photos/","status":200,"bytes":900,"client_ip":"3.3.3.3"}
+`
+	os.WriteFile(filepath.Join(tmpDir, ".cfshare", "access.log"), []byte(log), 0600)
+
+	sum := BuildSummary(st)
+	if sum.Requests != 4 || sum.Clients != 3 {
+		t.Errorf("expected 4 requests from 3 clients, got %d/%d", sum.Requests, sum.Clients)
+	}
+	if sum.Items[0].Name != "report.pdf" || sum.Items[0].Downloads != 2 || sum.Items[0].Clients != 2 || sum.Items[0].BytesSent != 150 {
+		t.Errorf("unexpected report.pdf summary: %+v", sum.Items[0])
+	}
+	// 早于本次分享的日志不计入
+	if len(sum.Untouched) != 1 || sum.Untouched[0] != "notes.txt" {
+		t.Errorf("expected notes.txt untouched, got %v", sum.Untouched)
+	}
+	if !containsStr(sum.Format(), "从未被下载") {
+		t.Error("summary should list untouched items")
+	}
+}
+
+func TestClassifyUserAgent(t *testing.T) {
+	tests := []struct {
+		ua, family, device string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36", "Chrome", DeviceDesktop},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "Safari", DeviceMobile},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0", "Edge", DeviceDesktop},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Googlebot", DeviceBot},
+		{"curl/8.4.0", "curl", DeviceCLI},
+		{"", "(none)", DeviceUnknown},
+	}
+
+	for _, tt := range tests {
+		family, device := ClassifyUserAgent(tt.ua)
+		if family != tt.family || device != tt.device {
+			t.Errorf("ClassifyUserAgent(%q) = %s/%s, want %s/%s", tt.ua, family, device, tt.family, tt.device)
+		}
+	}
+}
+
+func TestStatsBrowserBreakdown(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpDir, ".cfshare"), 0755)
+
+	chrome := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+	UpdateAccessStats(AccessRecord{Time: time.Now(), UserAgent: chrome})
+	UpdateAccessStats(AccessRecord{Time: time.Now(), UserAgent: chrome})
+	UpdateAccessStats(AccessRecord{Time: time.Now(), UserAgent: "curl/8.4.0"})
+
+	output := ReadStats().Format(false)
+	if !containsStr(output, "Browsers:    Chrome 2, curl 1") {
+		t.Errorf("unexpected browser breakdown:\n%s", output)
+	}
+	if !containsStr(output, "Devices:     desktop 2, cli 1") {
+		t.Errorf("unexpected device breakdown:\n%s", output)
+	}
+}
+
+func TestShareCard(t *testing.T) {
+	s := &State{
+		Mode:      ModeProtected,
+		PublicURL: "https://share.example.com",
+		Username:  "dl",
+		Password:  "secret123",
+		Items: []ShareItem{
+			{Name: "report.pdf", ShareType: TypeFile, Size: 2048},
+			{Name: "photos", ShareType: TypeDir},
+		},
+	}
+
+	text, err := s.Card(CardText, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"https://share.example.com", "secret123", "report.pdf (2.00 KB)", "photos/ (目录)"} {
+		if !containsStr(text, want) {
+			t.Errorf("text card missing %q:\n%s", want, text)
+		}
+	}
+
+	md, _ := s.Card(CardMarkdown, false)
+	if containsStr(md, "secret123") || !containsStr(md, "(另行发送)") {
+		t.Errorf("password should be withheld:\n%s", md)
+	}
+
+	html, _ := s.Card(CardHTML, true)
+	if !containsStr(html, `<a href="https://share.example.com">`) {
+		t.Errorf("html card missing link:\n%s", html)
+	}
+
+	if _, err := s.Card("pdf", true); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestSplitSecretHidesPassword(t *testing.T) {
+	s := &State{
+		Mode:        ModeProtected,
+		PublicURL:   "https://share.example.com",
+		Username:    "dl",
+		Password:    "secret123",
+		SplitSecret: SecretReveal,
+		Items:       []ShareItem{{Name: "a.txt", ShareType: TypeFile}},
+	}
+
+	for name, out := range map[string]string{
+		"share output": s.FormatShareOutput(),
+		"status":       s.FormatStatus(),
+	} {
+		if containsStr(out, "secret123") {
+			t.Errorf("%s should not contain the password", name)
+		}
+		if !containsStr(out, "cfshare reveal") {
+			t.Errorf("%s should point to cfshare reveal", name)
+		}
+	}
+
+	card, _ := s.Card(CardText, true)
+	if containsStr(card, "secret123") {
+		t.Error("card should not contain a split secret")
+	}
+}
+
+func TestStatsTimezone(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	last := time.Date(2026, 3, 1, 23, 30, 0, 0, shanghai)
+	stats := &Stats{RequestCount: 1, LastAccess: last}
+
+	if out := stats.Format(false); !containsStr(out, "2026-03-01 15:30:00 +00:00") {
+		t.Errorf("expected UTC by default, got %s", out)
+	}
+	if out := stats.FormatIn(false, shanghai); !containsStr(out, "2026-03-01 23:30:00 +08:00") {
+		t.Errorf("expected time in requested zone, got %s", out)
+	}
+
+	if loc, err := ParseTimezone("utc"); err != nil || loc != time.UTC {
+		t.Errorf("expected UTC, got %v, %v", loc, err)
+	}
+	if loc, err := ParseTimezone("local"); err != nil || loc != time.Local {
+		t.Errorf("expected local zone, got %v, %v", loc, err)
+	}
+	if _, err := ParseTimezone("Not/AZone"); err == nil {
+		t.Error("expected error for unknown zone")
+	}
+}
+
+func TestShareDefinition(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "report.pdf")
+	os.WriteFile(filePath, []byte("pdf"), 0644)
+
+	st := &State{
+		ShareID:         "1",
+		Mode:            ModeProtected,
+		Port:            8787,
+		PublicURL:       "https://share.example.com",
+		Username:        "cfshare",
+		Password:        "secret",
+		PasswordHash:    auth.HashPassword("secret"),
+		KeychainAccount: "share-1",
+		OwnerToken:      "owner",
+		ServerPID:       100,
+		TunnelPID:       200,
+		StartTime:       time.Now(),
+		RequestCount:    5,
+		Items:           []ShareItem{{Path: filePath, Name: "report.pdf", ShareType: TypeFile}},
+	}
+
+	data, err := st.Definition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsStr(string(data), `"password"`) {
+		t.Error("definition should not contain the plaintext password")
+	}
+	defPath := filepath.Join(tmpDir, "share.json")
+	os.WriteFile(defPath, data, 0600)
+
+	def, err := LoadDefinition(defPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.ServerPID != 0 || def.TunnelPID != 0 || def.RequestCount != 0 || !def.StartTime.IsZero() || def.KeychainAccount != "" {
+		t.Errorf("expected runtime fields to be cleared: %+v", def)
+	}
+	if !auth.CheckPassword(def.PasswordHash, "secret") || def.OwnerToken != "owner" || def.Port != 8787 || len(def.Items) != 1 {
+		t.Errorf("expected share settings to be kept: %+v", def)
+	}
+
+	os.Remove(filePath)
+	if _, err := LoadDefinition(defPath); err == nil {
+		t.Error("expected error when a shared item is missing on this machine")
+	}
+}
+
+func TestFormatStatusDegraded(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "cfshare-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpDir, ".cfshare"), 0755)
+
+	// 使用当前进程的 PID，让分享显示为运行中
+	st := &State{Mode: ModePublic, ServerPID: os.Getpid(), PublicURL: "https://example.com"}
+	if status := st.FormatStatus(); strings.Contains(status, "降级") {
+		t.Errorf("healthy share shown as degraded:\n%s", status)
+	}
+
+	SaveHealth(&Health{Degraded: true, Since: time.Now(), LastError: "530 ", Restarts: 2})
+	status := st.FormatStatus()
+	for _, want := range []string{"🟠 降级", "最近错误: 530", "已自动重启 tunnel 2 次"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("status missing %q:\n%s", want, status)
+		}
+	}
+
+	SaveHealth(&Health{Connections: 4, ConnSource: "metrics", CheckedAt: time.Now()})
+	if status := st.FormatStatus(); !strings.Contains(status, "✅ 4 条边缘连接已注册 (cloudflared 指标") || strings.Contains(status, "降级") {
+		t.Errorf("status missing edge connections:\n%s", status)
+	}
+	SaveHealth(&Health{CheckedAt: time.Now(), TunnelDown: true, DownSince: time.Now(), TunnelError: "没有注册到边缘的连接", TunnelRestarts: 1})
+	status = st.FormatStatus()
+	for _, want := range []string{"🟠 降级 (tunnel 没有边缘连接)", "没有注册到边缘的连接", "已自动重启 tunnel 1 次"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("status missing %q:\n%s", want, status)
+		}
+	}
+
+	Clear()
+	if LoadHealth() != nil {
+		t.Error("Clear should remove health.json")
+	}
+}
+
+func TestFormatStopAt(t *testing.T) {
+	now := time.Date(2026, 10, 16, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		stopAt time.Time
+		want   string
+	}{
+		{now.Add(2*time.Hour - time.Second), "2026-10-16 17:59 (剩余 1h59m)"},
+		{now.Add(50*time.Hour + 30*time.Minute), "2026-10-18 18:30 (剩余 2d2h)"},
+		{now.Add(30 * time.Second), "2026-10-16 16:00 (剩余 <1m)"},
+		{now.Add(-time.Minute), "2026-10-16 15:59 (已到期)"},
+	}
+	for _, tt := range tests {
+		if got := formatStopAt(tt.stopAt, now); got != tt.want {
+			t.Errorf("formatStopAt(%v) = %q, want %q", tt.stopAt, got, tt.want)
+		}
+	}
+}
+
+func TestMarkBurned(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpDir, ".cfshare"), 0755)
+
+	if IsBurned("s1", "a.txt") {
+		t.Fatal("nothing should be burned yet")
+	}
+	if marked, err := MarkBurned("s1", "a.txt"); err != nil || !marked {
+		t.Fatalf("MarkBurned = %v, %v", marked, err)
+	}
+	if marked, _ := MarkBurned("s1", "a.txt"); marked {
+		t.Error("second MarkBurned should report already burned")
+	}
+	if !IsBurned("s1", "a.txt") || IsBurned("s1", "b.txt") {
+		t.Error("unexpected burned state for s1")
+	}
+
+	// 新分享不继承之前分享的记录
+	if IsBurned("s2", "a.txt") {
+		t.Error("a.txt should not be burned for another share")
+	}
+	MarkBurned("s2", "b.txt")
+	if IsBurned("s1", "a.txt") {
+		t.Error("records of the previous share should be dropped")
+	}
+}
+
+func TestFormatTransfer(t *testing.T) {
+	tests := []struct {
+		bytes, contentLength int64
+		d                    time.Duration
+		want                 string
+	}{
+		{10 << 20, 10 << 20, 4 * time.Second, "10.00 MB / 4s, 2.50 MB/s"},
+		{512, 0, 0, "512 B / 0s"},
+		{25 << 20, 100 << 20, 90 * time.Second, "25.00 MB / 1m30s, 284.44 KB/s (已传 25%，按此速度还需 4m30s)"},
+	}
+	for _, tt := range tests {
+		if got := FormatTransfer(tt.bytes, tt.contentLength, tt.d); got != tt.want {
+			t.Errorf("FormatTransfer(%d, %d, %s) = %q, want %q", tt.bytes, tt.contentLength, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestBuildReview(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	os.MkdirAll(filepath.Join(tmpDir, ".cfshare"), 0755)
+
+	now := time.Now()
+	st := &State{
+		PublicURL: "https://share.example.com",
+		Mode:      ModeProtected,
+		Username:  "cfshare",
+		StartTime: now.Add(-10 * 24 * time.Hour),
+		StopAt:    now.Add(2 * time.Hour),
+		IsMulti:   true,
+		Items: []ShareItem{
+			{Name: "report.pdf", ShareType: TypeFile},
+			{Name: "photos", ShareType: TypeDir},
+		},
+	}
+
+	ts := func(d time.Duration) string { return now.Add(d).Format(time.RFC3339) }
+	log := `{"time":"` + ts(-9*24*time.Hour) + `","path":"/report.pdf","status":200,"bytes":5,"client_ip":"9.9.9.9","download":true}
+{"time":"` + ts(-2*time.Hour) + `","path":"/report.pdf","status":200,"bytes":100,"client_ip":"1.1.1.1","user":"alice","download":true}
+{"time":"` + ts(-time.Hour) + `","path":"/photos/","status":200,"bytes":900,"client_ip":"2.2.2.2"}
+{"time":"` + ts(-30*time.Minute) + `","path":"/photos/a.jpg","status":200,"bytes":30,"client_ip":"1.1.1.1","user":"alice","download":true}
+{"time":"` + ts(-20*time.Minute) + `","event":"expired"}
+`
+	os.WriteFile(filepath.Join(tmpDir, ".cfshare", "access.log"), []byte(log), 0600)
+
+	items := []ReviewItem{
+		{Name: "report.pdf", ShareType: TypeFile, Files: 1, Size: 1000},
+		{Name: "photos", ShareType: TypeDir, Files: 3, Size: 2000},
+	}
+	r := BuildReview(st, items, now)
+	if r.Files != 4 || r.TotalSize != 3000 {
+		t.Errorf("expected 4 files / 3000 bytes, got %d/%d", r.Files, r.TotalSize)
+	}
+	// 只统计最近 ReviewWindow 内的访问
+	if len(r.Clients) != 2 {
+		t.Fatalf("expected 2 recent clients, got %+v", r.Clients)
+	}
+	alice := r.Clients[0]
+	if alice.Client != "alice@1.1.1.1" || alice.Requests != 2 || alice.BytesSent != 130 {
+		t.Errorf("unexpected client summary: %+v", alice)
+	}
+	if len(alice.Items) != 2 || alice.Items[0] != "photos" || alice.Items[1] != "report.pdf" {
+		t.Errorf("expected both items downloaded, got %v", alice.Items)
+	}
+	if len(r.Clients[1].Items) != 0 {
+		t.Errorf("listing a directory is not a download: %v", r.Clients[1].Items)
+	}
+
+	out := r.FormatIn(time.UTC)
+	for _, want := range []string{"口令 (用户 cfshare)", "10d0h", "剩余", "2 个项目，4 个文件", "alice@1.1.1.1"} {
+		if !containsStr(out, want) {
+			t.Errorf("review output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	defer func() { config.Mount = "" }()
+
+	// 每个挂载的分享有自己的日志和统计
+	config.Mount = "docs"
+	if err := config.EnsureConfigDir(); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(config.GetAccessLogPath()) != filepath.Join(tmpDir, ".cfshare", "shares", "docs") {
+		t.Errorf("access log should be per share, got %s", config.GetAccessLogPath())
+	}
+	os.WriteFile(config.GetPidFilePath(), []byte(strconv.Itoa(os.Getpid())), 0600)
+	st := &State{ShareID: "1", Mode: ModePublic, PublicURL: "https://share.example.com/docs", Port: 8788, Items: []ShareItem{{Name: "a.txt"}}}
+	if err := Register(st); err != nil {
+		t.Fatal(err)
+	}
+
+	// 已停止的分享在下次登记时从索引中删除
+	config.Mount = "old"
+	config.EnsureConfigDir()
+	if err := Register(&State{ShareID: "2", Mode: ModePublic}); err != nil {
+		t.Fatal(err)
+	}
+	config.Mount = "new"
+	if err := Register(&State{ShareID: "3", Mode: ModePublic}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := LoadRegistry()
+	if len(entries) != 2 || entries[0].Mount != "docs" || entries[1].Mount != "new" {
+		t.Fatalf("unexpected registry: %+v", entries)
+	}
+	if entries[0].URL != "https://share.example.com/docs" || entries[0].Items[0] != "a.txt" || !entries[0].Running() {
+		t.Errorf("unexpected entry: %+v", entries[0])
+	}
+	if active := ActiveShares(); len(active) != 1 || active[0] != "docs" {
+		t.Errorf("expected only docs to be active, got %v", active)
+	}
+}
+
+func TestSearchHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	config.EnsureConfigDir()
+
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	st := &State{
+		ShareID:   "1",
+		Mode:      ModeProtected,
+		PublicURL: "https://share.example.com",
+		StartTime: start,
+		IsMulti:   true,
+		Items: []ShareItem{
+			{Name: "report.pdf", Path: "/home/u/report.pdf", ShareType: TypeFile},
+			{Name: "photos", Path: "/home/u/photos", ShareType: TypeDir},
+		},
+	}
+	if err := Register(st); err != nil {
+		t.Fatal(err)
+	}
+	logs := []string{
+		`{"time":"` + start.Add(time.Minute).Format(time.RFC3339) + `","path":"/report.pdf","status":200,"bytes":10,"client_ip":"1.1.1.1","download":true}`,
+		`{"time":"` + start.Add(2*time.Minute).Format(time.RFC3339) + `","path":"/report.pdf","status":200,"bytes":10,"client_ip":"2.2.2.2","download":true}`,
+		`{"time":"` + start.Add(3*time.Minute).Format(time.RFC3339) + `","path":"/photos/beach%20day.jpg","status":200,"bytes":10,"client_ip":"1.1.1.1","download":true}`,
+		`{"time":"` + start.Add(4*time.Minute).Format(time.RFC3339) + `","path":"/photos/","status":200,"bytes":10,"client_ip":"1.1.1.1"}`,
+	}
+	os.WriteFile(config.GetAccessLogPath(), []byte(strings.Join(logs, "\n")+"\n"), 0600)
+	if err := RecordHistoryEnd("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := SearchHistory("REPORT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Downloads != 2 || matches[0].Clients != 2 || len(matches[0].Items) != 1 || matches[0].EndTime.IsZero() {
+		t.Fatalf("unexpected matches: %+v", matches)
+	}
+
+	// 目录中的文件按下载路径匹配
+	matches, _ = SearchHistory("beach")
+	if len(matches) != 1 || matches[0].Files["/photos/beach day.jpg"] != 1 || len(matches[0].Items) != 0 {
+		t.Fatalf("expected file inside folder to match, got %+v", matches)
+	}
+
+	if matches, _ := SearchHistory("missing"); len(matches) != 0 {
+		t.Errorf("expected no matches, got %+v", matches)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("HOME", tmpDir)
+	config.EnsureConfigDir()
+
+	now := time.Now().Truncate(time.Second)
+	start := now.Add(-2 * time.Hour)
+	st := &State{
+		ShareID:   "1",
+		Mode:      ModeProtected,
+		PublicURL: "https://share.example.com",
+		StartTime: start,
+		Items:     []ShareItem{{Name: "report.pdf", Path: "/home/u/report.pdf", ShareType: TypeFile}},
+	}
+	if err := Register(st); err != nil {
+		t.Fatal(err)
+	}
+	at := func(d time.Duration) string { return start.Add(d).UTC().Format(time.RFC3339) }
+	logs := []string{
+		`{"time":"` + now.Add(-30*24*time.Hour).UTC().Format(time.RFC3339) + `","path":"/old.pdf","status":200,"bytes":99,"client_ip":"9.9.9.9","download":true}`,
+		`{"time":"` + at(time.Minute) + `","path":"/report.pdf","status":200,"bytes":10,"client_ip":"1.1.1.1","download":true}`,
+		`{"time":"` + at(2*time.Minute) + `","path":"/report.pdf","status":200,"bytes":10,"client_ip":"2.2.2.2","download":true}`,
+		`{"time":"` + at(3*time.Minute) + `","path":"/","status":200,"bytes":5,"client_ip":"2.2.2.2"}`,
+		`{"time":"` + at(4*time.Minute) + `","event":"anomaly","kind":"lockout","path":"/","client_ip":"3.3.3.3","message":"3.3.3.3 口令连续错误 10 次"}`,
+		`{"time":"` + at(5*time.Minute) + `","event":"geo_blocked","country":"XX","path":"/"}`,
+		`{"time":"` + at(6*time.Minute) + `","event":"expired","message":"分享已到期"}`,
+	}
+	os.WriteFile(config.GetAccessLogPath(), []byte(strings.Join(logs, "\n")+"\n"), 0600)
+
+	d, err := BuildDigest("每周汇总", now.AddDate(0, 0, -7), now.Add(time.Minute), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Shares) != 1 || d.Requests != 3 || d.Downloads != 2 || d.Files != 1 || d.Clients != 2 || d.BytesSent != 25 {
+		t.Fatalf("unexpected totals: %+v", d)
+	}
+	if len(d.Top) != 1 || d.Top[0].Path != "/report.pdf" || d.Top[0].Clients != 2 {
+		t.Errorf("unexpected top downloads: %+v", d.Top)
+	}
+	if d.GeoBlocked != 1 || len(d.Events) != 2 || d.Events[0].Label != "到期" || d.Events[1].Label != "异常 (lockout)" {
+		t.Errorf("unexpected events: %+v", d.Events)
+	}
+
+	text, _ := d.Format(CardText)
+	for _, want := range []string{"每周汇总", "https://share.example.com", "/report.pdf", "口令连续错误", "地区限制拦截了 1 个请求"} {
+		if !containsStr(text, want) {
+			t.Errorf("text digest missing %q:\n%s", want, text)
+		}
+	}
+	if containsStr(text, "old.pdf") {
+		t.Error("digest should only cover the requested period")
+	}
+	if md, _ := d.Format(CardMarkdown); !containsStr(md, "| `/report.pdf` | 2 | 2 |") {
+		t.Errorf("unexpected markdown digest:\n%s", md)
+	}
+	if html, err := d.Format(CardHTML); err != nil || !containsStr(html, "<code>/report.pdf</code>") {
+		t.Errorf("unexpected html digest (%v):\n%s", err, html)
+	}
+	if _, err := d.Format("pdf"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	config.EnsureConfigDir()
+
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	st := &State{StartTime: start, IsMulti: true}
+	logs := []string{
+		`{"time":"` + start.Add(time.Hour).Format(time.RFC3339) + `","path":"/docs/a%20b.txt","status":200,"client_ip":"1.1.1.1","download":true}`,
+		`{"time":"` + start.Add(time.Hour).Format(time.RFC3339) + `","path":"/docs/c.txt","status":200,"client_ip":"1.1.1.1","download":true}`,
+		// 打包下载目录覆盖其中所有文件
+		`{"time":"` + start.Add(2*time.Hour).Format(time.RFC3339) + `","path":"/docs/","status":200,"client_ip":"2.2.2.2","download":true}`,
+		`{"time":"` + start.Add(2*time.Hour).Format(time.RFC3339) + `","path":"/report.pdf","status":404,"client_ip":"2.2.2.2","download":true}`,
+	}
+	os.WriteFile(config.GetAccessLogPath(), []byte(strings.Join(logs, "\n")+"\n"), 0600)
+
+	r := st.Diff([]SharedFile{
+		{URLPath: "/docs/a b.txt", ModTime: start.Add(90 * time.Minute)}, // 单独下载后修改，但之后又被打包下载
+		{URLPath: "/docs/c.txt", ModTime: start.Add(150 * time.Minute)},  // 打包下载后修改
+		{URLPath: "/report.pdf", ModTime: start.Add(30 * time.Minute)},   // 分享后修改，没有成功下载
+		{URLPath: "/old.txt", ModTime: start.Add(-time.Hour)},            // 分享前就有，没人下载
+	})
+	if len(r.Changed) != 1 || r.Changed[0].Path != "/docs/c.txt" || len(r.Changed[0].Clients) != 1 || r.Changed[0].Clients[0] != "2.2.2.2" {
+		t.Errorf("unexpected changed files: %+v", r.Changed)
+	}
+	if len(r.Fresh) != 1 || r.Fresh[0].Path != "/report.pdf" || r.Unchanged != 1 || r.Untouched != 1 {
+		t.Errorf("unexpected report: %+v", r)
+	}
+}
+
+func TestLockouts(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	config.EnsureConfigDir()
+
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	st := &State{StartTime: start, MaxAuthFailures: 10}
+	lockout := func(at time.Time, ip string) string {
+		return `{"time":"` + at.Format(time.RFC3339) + `","event":"anomaly","kind":"lockout","path":"/","client_ip":"` + ip + `"}`
+	}
+	logs := []string{
+		lockout(start.Add(-time.Hour), "9.9.9.9"), // 上一次分享
+		lockout(start.Add(time.Hour), "1.1.1.1"),
+		`{"time":"` + start.Add(time.Hour).Format(time.RFC3339) + `","event":"anomaly","kind":"honeypot","path":"/.env","client_ip":"2.2.2.2"}`,
+		lockout(time.Now().Add(-time.Minute), "1.1.1.1"),
+	}
+	os.WriteFile(config.GetAccessLogPath(), []byte(strings.Join(logs, "\n")+"\n"), 0600)
+
+	lines := st.lockouts()
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "1.1.1.1") || !strings.Contains(lines[0], "共 2 次") || !strings.Contains(lines[0], "解除") {
+		t.Fatalf("unexpected lockouts: %q", lines)
+	}
+	if status := st.FormatStatus(); !strings.Contains(status, "🔒 1.1.1.1") {
+		t.Errorf("status should list lockouts:\n%s", status)
+	}
+}
+
+func TestStatsStore(t *testing.T) {
+	tmpDir := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", tmpDir)
+	defer os.Setenv("HOME", origHome)
+	config.EnsureConfigDir()
+
+	if _, err := OpenStatsStore("mysql"); err == nil {
+		t.Error("expected unknown backend to be rejected")
+	}
+
+	backends := []string{StatsBackendJSON}
+	if _, err := exec.LookPath("sqlite3"); err == nil {
+		backends = append(backends, StatsBackendSQLite)
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for _, backend := range backends {
+		store, err := OpenStatsStore(backend)
+		if err != nil {
+			t.Fatalf("%s: %v", backend, err)
+		}
+		// 超过 10 条也不会丢弃，只有 Prune 才删除
+		for i := 0; i < 15; i++ {
+			r := AccessRecord{Time: now.Add(time.Duration(i-14) * 24 * time.Hour), Path: "/f" + strconv.Itoa(i), StatusCode: 200, BytesSent: int64(i), RemoteAddr: "1.2.3.4:5", UserAgent: "O'Reilly"}
+			if err := store.Append(r); err != nil {
+				t.Fatalf("%s: Append: %v", backend, err)
+			}
+		}
+		records, err := store.Records(time.Time{})
+		if err != nil || len(records) != 15 {
+			t.Fatalf("%s: expected 15 records, got %d (%v)", backend, len(records), err)
+		}
+		if last := records[14]; !last.Time.Equal(now) || last.Path != "/f14" || last.UserAgent != "O'Reilly" || last.BytesSent != 14 {
+			t.Errorf("%s: unexpected last record %+v", backend, last)
+		}
+
+		n, err := store.Prune(now.Add(-7 * 24 * time.Hour))
+		if err != nil || n != 7 {
+			t.Errorf("%s: Prune removed %d (%v), want 7", backend, n, err)
+		}
+		records, _ = store.Records(now.Add(-48 * time.Hour))
+		if len(records) != 3 || records[0].Path != "/f12" {
+			t.Errorf("%s: unexpected records after prune: %+v", backend, records)
+		}
+	}
+
+	if got := (&State{}).RecordRetention(); got != config.DefaultStatsRetention {
+		t.Errorf("default retention = %s", got)
+	}
+	if got := (&State{StatsRetention: -1}).RecordRetention(); got != 0 {
+		t.Errorf("negative retention should mean forever, got %s", got)
+	}
+}
+
+func TestVisitors(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := config.EnsureConfigDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.1"} {
+		if _, _, err := RequestVisit(Visitor{IP: ip, Path: "/", FirstSeen: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, added, _ := RequestVisit(Visitor{IP: "203.0.113.3", Path: "/"})
+	if !added {
+		t.Error("new visitor was not added")
+	}
+	if pending := PendingVisitors(); len(pending) != 3 || pending[0].IP != "203.0.113.1" {
+		t.Fatalf("pending = %+v, want 3 visitors in arrival order", pending)
+	}
+
+	if _, err := DecideVisitors("203.0.113.2", VisitorDenied); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := FindVisitor("203.0.113.2"); v.Status != VisitorDenied || v.DecidedAt.IsZero() {
+		t.Errorf("denied visitor = %+v", v)
+	}
+	decided, err := DecideVisitors("all", VisitorApproved)
+	if err != nil || len(decided) != 2 {
+		t.Fatalf("approve all decided %d visitors (%v), want the 2 pending ones", len(decided), err)
+	}
+	if v, _ := FindVisitor("203.0.113.2"); v.Status != VisitorDenied {
+		t.Error("approve all changed a denied visitor")
+	}
+	if _, err := DecideVisitors("198.51.100.1", VisitorApproved); err == nil {
+		t.Error("expected an error for an unknown visitor")
+	}
+
+	ClearVisitors()
+	if len(LoadVisitors()) != 0 {
+		t.Error("visitors not cleared")
+	}
+}
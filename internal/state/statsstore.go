@@ -0,0 +1,333 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// StatsStore 保存每个请求的原始访问记录，存储方式由 --stats-backend 选择。
+// 聚合统计 (请求数、流量、按用户和浏览器分组等) 始终保存在 stats.json 中并永久保留，
+// 原始记录可能很多，按 --stats-retention 定期清理。
+type StatsStore interface {
+	// Append 追加一批记录
+	Append(records ...AccessRecord) error
+	// Records 返回 since 之后的记录，按时间先后排列
+	Records(since time.Time) ([]AccessRecord, error)
+	// Prune 删除 before 之前的记录，返回删除的条数
+	Prune(before time.Time) (int, error)
+}
+
+const (
+	StatsBackendJSON   = "json"   // 每行一条 JSON 记录的文件，默认
+	StatsBackendSQLite = "sqlite" // SQLite 数据库，需要 sqlite3 命令行工具
+)
+
+// OpenStatsStore 打开 backend 对应的原始访问记录存储，backend 为空时使用 json
+func OpenStatsStore(backend string) (StatsStore, error) {
+	switch backend {
+	case "", StatsBackendJSON:
+		return jsonStatsStore{path: config.GetStatsRecordsPath()}, nil
+	case StatsBackendSQLite:
+		return openSQLiteStatsStore(config.GetStatsDBPath())
+	}
+	return nil, fmt.Errorf("unknown stats backend %q (expected json or sqlite)", backend)
+}
+
+// jsonStatsStore 把记录逐行追加到 stats-records.jsonl。启用状态加密时每行单独加密
+// 并以 base64 保存，清理时在文件锁内重写整个文件。
+type jsonStatsStore struct {
+	path string
+}
+
+func (s jsonStatsStore) Append(records ...AccessRecord) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if EncryptionEnabled() {
+			sealed, err := seal(line)
+			if err != nil {
+				return err
+			}
+			line = []byte(base64.StdEncoding.EncodeToString(sealed))
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+	_, err = f.Write(buf.Bytes())
+	return err
+}
+
+func (s jsonStatsStore) Records(since time.Time) ([]AccessRecord, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []AccessRecord
+	err = scanRecordLines(f, func(r AccessRecord, _ []byte) {
+		if !r.Time.Before(since) {
+			records = append(records, r)
+		}
+	})
+	return records, err
+}
+
+func (s jsonStatsStore) Prune(before time.Time) (int, error) {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return 0, err
+	}
+	defer unlockFile(f)
+
+	var kept bytes.Buffer
+	pruned := 0
+	err = scanRecordLines(f, func(r AccessRecord, line []byte) {
+		if r.Time.Before(before) {
+			pruned++
+			return
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	})
+	if err != nil || pruned == 0 {
+		return 0, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := f.WriteAt(kept.Bytes(), 0); err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+// scanRecordLines 逐行解析记录，line 是文件中的原始行 (可能已加密)，无法解析的行跳过
+func scanRecordLines(f *os.File, fn func(r AccessRecord, line []byte)) error {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		data := line
+		if len(line) > 0 && line[0] != '{' {
+			sealed, err := base64.StdEncoding.DecodeString(string(line))
+			if err != nil {
+				continue
+			}
+			if data, err = unseal(sealed); err != nil {
+				return err
+			}
+		}
+		var r AccessRecord
+		if json.Unmarshal(data, &r) != nil {
+			continue
+		}
+		fn(r, line)
+	}
+	return scanner.Err()
+}
+
+// sqliteStatsStore 把记录保存在 SQLite 数据库中，通过 sqlite3 命令行工具读写
+// (macOS 自带，Linux 上通常可以直接安装)，不引入 cgo 依赖。适合记录很多的长期公开分享。
+// 记录中的字符串来自访问者 (路径、User-Agent 等)，不拼接进 SQL: 一批记录写成 JSON 临时文件，
+// 由固定的 INSERT 语句通过 readfile() 和 json_each() 读取，每批只启动一次 sqlite3。
+type sqliteStatsStore struct {
+	path string
+}
+
+func openSQLiteStatsStore(path string) (*sqliteStatsStore, error) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		return nil, fmt.Errorf("sqlite stats backend needs the sqlite3 command: %w", err)
+	}
+	// 数据库中有访问者的 IP，先以 0600 创建，避免 sqlite3 按 umask 创建
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	s := &sqliteStatsStore{path: path}
+	_, err = s.run(false, `CREATE TABLE IF NOT EXISTS records (
+	time_ms INTEGER NOT NULL,
+	path TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	bytes INTEGER NOT NULL,
+	remote_addr TEXT NOT NULL,
+	user TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	aborted TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS records_time ON records(time_ms);`)
+	return s, err
+}
+
+// run 执行 SQL，jsonOutput 为 true 时以 JSON 数组输出查询结果 (没有结果时输出为空)
+func (s *sqliteStatsStore) run(jsonOutput bool, sql string) ([]byte, error) {
+	args := []string{"-batch", "-bail", "-cmd", ".timeout 5000"}
+	if jsonOutput {
+		args = append(args, "-json")
+	}
+	cmd := exec.Command("sqlite3", append(args, s.path)...)
+	cmd.Stdin = strings.NewReader(sql)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// sqliteRow 是 records 表中的一行，Records 解析 sqlite3 -json 的输出，Append 写入批量文件
+type sqliteRow struct {
+	TimeMs     int64  `json:"time_ms"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Bytes      int64  `json:"bytes"`
+	RemoteAddr string `json:"remote_addr"`
+	User       string `json:"user"`
+	UserAgent  string `json:"user_agent"`
+	Aborted    string `json:"aborted"`
+}
+
+// sqliteInsert 从批量文件插入记录，文件路径由 cfshare 生成，是 SQL 中唯一的变量
+const sqliteInsert = `BEGIN;
+INSERT INTO records (time_ms, path, status, bytes, remote_addr, user, user_agent, aborted)
+SELECT json_extract(value, '$.time_ms'), json_extract(value, '$.path'), json_extract(value, '$.status'),
+	json_extract(value, '$.bytes'), json_extract(value, '$.remote_addr'), json_extract(value, '$.user'),
+	json_extract(value, '$.user_agent'), json_extract(value, '$.aborted')
+FROM json_each(readfile('%s'));
+COMMIT;`
+
+func (s *sqliteStatsStore) Append(records ...AccessRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	rows := make([]sqliteRow, len(records))
+	for i, r := range records {
+		rows[i] = sqliteRow{
+			TimeMs:     r.Time.UnixMilli(),
+			Path:       r.Path,
+			Status:     r.StatusCode,
+			Bytes:      r.BytesSent,
+			RemoteAddr: r.RemoteAddr,
+			User:       r.User,
+			UserAgent:  r.UserAgent,
+			Aborted:    r.Aborted,
+		}
+	}
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+
+	// 批量文件中有访问者的 IP，CreateTemp 以 0600 创建，写入后即删除
+	f, err := os.CreateTemp(filepath.Dir(s.path), "stats-batch-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.run(false, fmt.Sprintf(sqliteInsert, strings.ReplaceAll(f.Name(), "'", "''")))
+	return err
+}
+
+func (s *sqliteStatsStore) Records(since time.Time) ([]AccessRecord, error) {
+	out, err := s.run(true, fmt.Sprintf("SELECT * FROM records WHERE time_ms >= %d ORDER BY time_ms, rowid;", since.UnixMilli()))
+	if err != nil || len(bytes.TrimSpace(out)) == 0 {
+		return nil, err
+	}
+	var rows []sqliteRow
+	if err := json.Unmarshal(out, &rows); err != nil {
+		return nil, fmt.Errorf("parse sqlite3 output: %w", err)
+	}
+	records := make([]AccessRecord, len(rows))
+	for i, row := range rows {
+		records[i] = AccessRecord{
+			Time:       time.UnixMilli(row.TimeMs).UTC(),
+			Path:       row.Path,
+			StatusCode: row.Status,
+			BytesSent:  row.Bytes,
+			RemoteAddr: row.RemoteAddr,
+			User:       row.User,
+			UserAgent:  row.UserAgent,
+			Aborted:    row.Aborted,
+		}
+	}
+	return records, nil
+}
+
+func (s *sqliteStatsStore) Prune(before time.Time) (int, error) {
+	out, err := s.run(false, fmt.Sprintf("DELETE FROM records WHERE time_ms < %d;\nSELECT changes();", before.UnixMilli()))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+// RecordRetention 返回原始访问记录的保留时长，0 表示永久保留
+func (s *State) RecordRetention() time.Duration {
+	switch {
+	case s.StatsRetention < 0:
+		return 0
+	case s.StatsRetention == 0:
+		return config.DefaultStatsRetention
+	}
+	return s.StatsRetention
+}
+
+// formatStatsStore 返回原始访问记录的存储说明，如 "sqlite，保留 30 天"
+func (s *State) formatStatsStore() string {
+	backend := s.StatsBackend
+	if backend == "" {
+		backend = StatsBackendJSON
+	}
+	retention := s.RecordRetention()
+	if retention == 0 {
+		return backend + "，永久保留"
+	}
+	if retention%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%s，保留 %d 天", backend, retention/(24*time.Hour))
+	}
+	return fmt.Sprintf("%s，保留 %s", backend, retention)
+}
@@ -0,0 +1,205 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// ItemSummary 是单个分享项在本次分享中的下载情况
+type ItemSummary struct {
+	Name      string `json:"name"`
+	Downloads int    `json:"downloads"`
+	Clients   int    `json:"clients"` // 下载过该项的不同访问者数量
+	BytesSent int64  `json:"bytes_sent"`
+}
+
+// Summary 是分享结束时的下载回执汇总
+type Summary struct {
+	ShareID   string        `json:"share_id"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	Requests  int           `json:"requests"`
+	Clients   int           `json:"clients"`
+	BytesSent int64         `json:"bytes_sent"`
+	Items     []ItemSummary `json:"items"`
+	Untouched []string      `json:"untouched,omitempty"` // 从未被下载的分享项
+}
+
+// accessLogEntry 是 access.log 中与汇总相关的字段
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	RemoteAddr string    `json:"remote_addr"`
+	ClientIP   string    `json:"client_ip"`
+	User       string    `json:"user"`
+	Name       string    `json:"visitor_name"` // --ask-name 时访问者填写的姓名
+	Download   bool      `json:"download"`
+	Event      string    `json:"event"`   // 非请求的事件，如 anomaly、updated
+	Kind       string    `json:"kind"`    // 异常事件的类型，如 honeypot、lockout
+	Message    string    `json:"message"` // 事件的说明
+	Item       string    `json:"item"`    // burned 事件销毁的分享项
+	Version    int       `json:"version"` // updated 事件的新版本号
+}
+
+// client 返回访问者标识: 有用户名时为 user@ip，否则为 IP，访问者填写了姓名时附在后面
+func (e accessLogEntry) client() string {
+	client := e.ClientIP
+	if client == "" {
+		client = e.RemoteAddr
+	}
+	if e.User != "" {
+		client = e.User + "@" + client
+	}
+	if e.Name != "" {
+		client += " (" + e.Name + ")"
+	}
+	return client
+}
+
+// downloaded 判断是否为成功的文件下载
+func (e accessLogEntry) downloaded() bool {
+	return e.Download && (e.Status == 200 || e.Status == 206)
+}
+
+// scanAccessLog 依次把 since 之后的请求记录交给 fn，跳过事件和无法解析的行
+func scanAccessLog(since time.Time, fn func(e accessLogEntry)) {
+	scanAccessLogFile(config.GetAccessLogPath(), since, fn)
+}
+
+// scanAccessLogFile 与 scanAccessLog 相同，但读取指定的访问日志 (其他分享的目录中)
+func scanAccessLogFile(path string, since time.Time, fn func(e accessLogEntry)) {
+	scanAccessLogLines(path, since, func(e accessLogEntry) {
+		if e.Path != "" {
+			fn(e)
+		}
+	})
+}
+
+// scanAccessLogLines 依次把 since 之后的每一行交给 fn，包括没有路径的事件
+func scanAccessLogLines(path string, since time.Time, fn func(e accessLogEntry)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	// 日志时间精确到秒
+	since = since.Truncate(time.Second)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e accessLogEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Time.Before(since) {
+			continue
+		}
+		fn(e)
+	}
+}
+
+// BuildSummary 根据访问日志统计本次分享 (StartTime 之后) 各分享项的下载情况
+func BuildSummary(st *State) *Summary {
+	sum := &Summary{
+		ShareID:   st.ShareID,
+		StartTime: st.StartTime,
+		EndTime:   time.Now(),
+	}
+
+	type itemAcc struct {
+		downloads int
+		bytes     int64
+		clients   map[string]bool
+	}
+	acc := make(map[string]*itemAcc, len(st.Items))
+	for _, item := range st.Items {
+		acc[item.Name] = &itemAcc{clients: make(map[string]bool)}
+	}
+	allClients := make(map[string]bool)
+
+	scanAccessLog(st.StartTime, func(e accessLogEntry) {
+		client := e.client()
+		sum.Requests++
+		sum.BytesSent += e.Bytes
+		allClients[client] = true
+
+		if !e.downloaded() {
+			return
+		}
+		a := acc[st.itemForPath(e.Path)]
+		if a == nil {
+			return
+		}
+		a.downloads++
+		a.bytes += e.Bytes
+		a.clients[client] = true
+	})
+
+	sum.Clients = len(allClients)
+	for _, item := range st.Items {
+		a := acc[item.Name]
+		sum.Items = append(sum.Items, ItemSummary{
+			Name:      item.Name,
+			Downloads: a.downloads,
+			Clients:   len(a.clients),
+			BytesSent: a.bytes,
+		})
+		if a.downloads == 0 {
+			sum.Untouched = append(sum.Untouched, item.Name)
+		}
+	}
+	sort.SliceStable(sum.Items, func(i, j int) bool {
+		return sum.Items[i].Downloads > sum.Items[j].Downloads
+	})
+	return sum
+}
+
+// itemForPath 返回 URL 路径所属分享项的名称
+func (s *State) itemForPath(urlPath string) string {
+	if len(s.Items) == 0 {
+		return ""
+	}
+	if !s.IsMulti {
+		return s.Items[0].Name
+	}
+	name, _, _ := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/")
+	return name
+}
+
+// Format 格式化分享结束时的汇总
+func (sum *Summary) Format() string {
+	out := fmt.Sprintf(`分享汇总
+────────────────────────────────────────
+Duration:    %s
+Requests:    %d
+Clients:     %d
+Bytes Sent:  %s
+`, sum.EndTime.Sub(sum.StartTime).Round(time.Second), sum.Requests, sum.Clients, formatBytes(sum.BytesSent))
+
+	if len(sum.Items) == 0 {
+		return out
+	}
+
+	out += fmt.Sprintf("\n%-30s %9s %8s %12s\n", "ITEM", "DOWNLOADS", "CLIENTS", "BYTES")
+	for _, item := range sum.Items {
+		out += fmt.Sprintf("%-30s %9d %8d %12s\n", item.Name, item.Downloads, item.Clients, formatBytes(item.BytesSent))
+	}
+
+	if len(sum.Untouched) > 0 {
+		out += "\n⚠️  以下项目从未被下载:\n"
+		for _, name := range sum.Untouched {
+			out += "   - " + name + "\n"
+		}
+	} else {
+		out += "\n✅ 所有项目均已被下载\n"
+	}
+	return out
+}
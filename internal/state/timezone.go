@@ -0,0 +1,34 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// displayTimeLayout 是 logs/stats 显示时间的格式，总是带时区偏移
+const displayTimeLayout = "2006-01-02 15:04:05 -07:00"
+
+// ParseTimezone 解析 --tz 参数: 空或 "UTC" 为 UTC，"local" 为本机时区，
+// 其他值按 IANA 时区名 (如 Asia/Shanghai) 解析
+func ParseTimezone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "", "utc", "z":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// DisplayTime 以 loc 时区格式化时间，零值显示为 "-"
+func DisplayTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.In(loc).Format(displayTimeLayout)
+}
@@ -0,0 +1,84 @@
+package state
+
+import "strings"
+
+// 设备类别
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceCLI     = "cli"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// uaRule 按顺序匹配 User-Agent 子串，先匹配的优先
+type uaRule struct {
+	substr string
+	family string
+	device string // 为空时根据 UA 中的平台信息判断
+}
+
+var uaRules = []uaRule{
+	// 爬虫和链接预览要先于浏览器匹配，它们通常也带有 Chrome/Safari 字样
+	{"googlebot", "Googlebot", DeviceBot},
+	{"bingbot", "Bingbot", DeviceBot},
+	{"slackbot", "Slackbot", DeviceBot},
+	{"twitterbot", "Twitterbot", DeviceBot},
+	{"facebookexternalhit", "Facebook", DeviceBot},
+	{"telegrambot", "Telegram", DeviceBot},
+	{"discordbot", "Discord", DeviceBot},
+	{"whatsapp", "WhatsApp", DeviceBot},
+	{"bot", "Bot", DeviceBot},
+	{"crawler", "Bot", DeviceBot},
+	{"spider", "Bot", DeviceBot},
+
+	{"curl/", "curl", DeviceCLI},
+	{"wget/", "Wget", DeviceCLI},
+	{"aria2/", "aria2", DeviceCLI},
+	{"python-requests", "Python", DeviceCLI},
+	{"python-urllib", "Python", DeviceCLI},
+	{"go-http-client", "Go", DeviceCLI},
+	{"powershell", "PowerShell", DeviceCLI},
+	{"okhttp", "OkHttp", DeviceCLI},
+
+	{"edg/", "Edge", ""},
+	{"opr/", "Opera", ""},
+	{"samsungbrowser", "Samsung Internet", ""},
+	{"firefox/", "Firefox", ""},
+	{"fxios/", "Firefox", ""},
+	{"crios/", "Chrome", ""},
+	{"chrome/", "Chrome", ""},
+	{"safari/", "Safari", ""},
+}
+
+// ClassifyUserAgent 把 User-Agent 归类为浏览器/客户端家族和设备类别
+func ClassifyUserAgent(ua string) (family, device string) {
+	if ua == "" {
+		return "(none)", DeviceUnknown
+	}
+
+	lower := strings.ToLower(ua)
+	for _, rule := range uaRules {
+		if strings.Contains(lower, rule.substr) {
+			if rule.device != "" {
+				return rule.family, rule.device
+			}
+			return rule.family, deviceFromPlatform(lower)
+		}
+	}
+	if strings.HasPrefix(lower, "mozilla/") {
+		return "Other", deviceFromPlatform(lower)
+	}
+	return "Other", DeviceUnknown
+}
+
+func deviceFromPlatform(lower string) string {
+	switch {
+	case strings.Contains(lower, "ipad") || strings.Contains(lower, "tablet"):
+		return DeviceTablet
+	case strings.Contains(lower, "mobile") || strings.Contains(lower, "iphone") || strings.Contains(lower, "android"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
@@ -0,0 +1,30 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"cfshare/internal/auth"
+)
+
+// FindUser 返回名为 name 的用户在 Users 中的位置，不存在时返回 -1
+func (s *State) FindUser(name string) int {
+	for i, u := range s.Users {
+		if u.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// FormatUser 格式化一个用户的权限，如 "alice  只读，仅 report.pdf, data"
+func FormatUser(u auth.User) string {
+	perm := "只读"
+	if u.CanUpload() {
+		perm = "可上传"
+	}
+	if len(u.Items) > 0 {
+		perm += "，仅 " + strings.Join(u.Items, ", ")
+	}
+	return fmt.Sprintf("%s  %s", u.Name, perm)
+}
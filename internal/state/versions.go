@@ -0,0 +1,56 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// ItemVersion 是 --watch 监视的分享文件的版本，保存在 versions.json。
+// 修改时间或大小变化后服务进程重新计算 SHA-256，内容确实改变时 Version 加一。
+type ItemVersion struct {
+	Version   int       `json:"version"`
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"mtime"`
+	UpdatedAt time.Time `json:"updated_at"` // 最近一次内容变化被发现的时间，版本 1 为开始分享的时间
+}
+
+// LoadItemVersions 读取各分享文件的版本，键为分享项名称
+func LoadItemVersions() map[string]ItemVersion {
+	versions := make(map[string]ItemVersion)
+	data, err := os.ReadFile(config.GetVersionsPath())
+	if err != nil {
+		return versions
+	}
+	json.Unmarshal(data, &versions)
+	return versions
+}
+
+// SaveItemVersions 写入全部分享文件的版本
+func SaveItemVersions(versions map[string]ItemVersion) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetVersionsPath(), data, 0600)
+}
+
+// ClearItemVersions 删除版本记录，分享停止时调用
+func ClearItemVersions() {
+	os.Remove(config.GetVersionsPath())
+}
+
+// formatItemVersion 返回状态输出中文件的版本，如 "v3，更新于 03-08 14:20"，未变化过时为空
+func formatItemVersion(v ItemVersion) string {
+	if v.Version < 2 {
+		return ""
+	}
+	return fmt.Sprintf("v%d，更新于 %s", v.Version, v.UpdatedAt.Local().Format("01-02 15:04"))
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// 访问者在 --approve 模式下的状态
+const (
+	VisitorPending  = "pending"  // 等待分享者批准
+	VisitorApproved = "approved" // 已批准，可以访问
+	VisitorDenied   = "denied"   // 已拒绝
+)
+
+// Visitor 是 --approve 模式下请求访问的访问者，按 CF-Connecting-IP 区分，保存在 visitors.json。
+// 服务进程把第一次出现的访问者加入待批准队列，分享者用 cfshare approve/deny 或
+// cfshare status --watch 处理，两个进程通过这个文件 (加文件锁) 交换状态。
+type Visitor struct {
+	IP        string    `json:"ip"`
+	Country   string    `json:"country,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	User      string    `json:"user,omitempty"` // 口令保护的分享中访问者登录的用户
+	Path      string    `json:"path"`           // 第一次请求的路径
+	FirstSeen time.Time `json:"first_seen"`
+	Status    string    `json:"status"`
+	DecidedAt time.Time `json:"decided_at,omitempty"`
+}
+
+// LoadVisitors 读取全部访问者，按第一次出现的先后排列
+func LoadVisitors() []Visitor {
+	data, err := os.ReadFile(config.GetVisitorsPath())
+	if err != nil {
+		return nil
+	}
+	var visitors []Visitor
+	json.Unmarshal(data, &visitors)
+	return visitors
+}
+
+// PendingVisitors 返回等待批准的访问者
+func PendingVisitors() []Visitor {
+	var pending []Visitor
+	for _, v := range LoadVisitors() {
+		if v.Status == VisitorPending {
+			pending = append(pending, v)
+		}
+	}
+	return pending
+}
+
+// FindVisitor 返回 IP 对应的访问者
+func FindVisitor(ip string) (Visitor, bool) {
+	for _, v := range LoadVisitors() {
+		if v.IP == ip {
+			return v, true
+		}
+	}
+	return Visitor{}, false
+}
+
+// RequestVisit 把新访问者加入待批准队列。访问者已经存在时返回现有记录，第二个返回值为 false。
+func RequestVisit(v Visitor) (Visitor, bool, error) {
+	var result Visitor
+	added := false
+	err := updateVisitors(func(visitors []Visitor) []Visitor {
+		for _, existing := range visitors {
+			if existing.IP == v.IP {
+				result = existing
+				return visitors
+			}
+		}
+		v.Status = VisitorPending
+		result, added = v, true
+		return append(visitors, v)
+	})
+	return result, added, err
+}
+
+// DecideVisitors 把访问者标记为 status (VisitorApproved 或 VisitorDenied)。ip 为 "all" 时
+// 处理全部待批准的访问者。返回被处理的访问者。
+func DecideVisitors(ip, status string) ([]Visitor, error) {
+	var decided []Visitor
+	err := updateVisitors(func(visitors []Visitor) []Visitor {
+		for i, v := range visitors {
+			if (ip == "all" && v.Status == VisitorPending) || v.IP == ip {
+				visitors[i].Status = status
+				visitors[i].DecidedAt = time.Now()
+				decided = append(decided, visitors[i])
+			}
+		}
+		return visitors
+	})
+	if err == nil && len(decided) == 0 && ip != "all" {
+		err = fmt.Errorf("没有来自 %s 的访问者", ip)
+	}
+	return decided, err
+}
+
+// updateVisitors 在文件锁内读取、修改并写回 visitors.json
+func updateVisitors(fn func([]Visitor) []Visitor) error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(config.GetVisitorsPath(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	var visitors []Visitor
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &visitors); err != nil {
+			return fmt.Errorf("parse visitors: %w", err)
+		}
+	}
+	data, err = json.MarshalIndent(fn(visitors), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(data, 0)
+	return err
+}
+
+// ClearVisitors 删除访问者记录，分享停止时调用
+func ClearVisitors() {
+	os.Remove(config.GetVisitorsPath())
+}
+
+// Describe 返回访问者的简短说明，如 "203.0.113.9 (JP, alice) Firefox / desktop"
+func (v Visitor) Describe() string {
+	out := v.IP
+	switch {
+	case v.Country != "" && v.User != "":
+		out += " (" + v.Country + ", " + v.User + ")"
+	case v.Country != "":
+		out += " (" + v.Country + ")"
+	case v.User != "":
+		out += " (" + v.User + ")"
+	}
+	if v.UserAgent != "" {
+		family, device := ClassifyUserAgent(v.UserAgent)
+		out += " " + family + " / " + device
+	}
+	return out
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package storage
+
+import "golang.org/x/sys/unix"
+
+// FreeSpace 返回 dir 所在文件系统中当前用户可用的字节数
+func FreeSpace(dir string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
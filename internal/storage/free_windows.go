@@ -0,0 +1,16 @@
+package storage
+
+import "golang.org/x/sys/windows"
+
+// FreeSpace 返回 dir 所在磁盘中当前用户可用的字节数
+func FreeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &avail, &total, &free); err != nil {
+		return 0, err
+	}
+	return int64(avail), nil
+}
@@ -0,0 +1,224 @@
+// Package storage 统计 ~/.cfshare 的磁盘占用，并在设置了总配额 (--storage-quota) 时
+// 先按最近使用时间淘汰缓存，仍然超出时拒绝新的写入 (上传、文本片段)。
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// Quota 是 ~/.cfshare 的总大小配额，0 表示不限。
+// CLI 从 --storage-quota 设置，服务进程从分享状态中恢复。
+var Quota int64
+
+// ErrQuota 表示淘汰缓存后仍然没有足够的空间
+var ErrQuota = errors.New("storage quota exceeded")
+
+// 按用途划分的目录，都位于 ~/.cfshare 下
+var (
+	inboxDirs = []string{"requests"} // 文件请求的收件目录
+	pasteDirs = []string{"pastes"}   // cfshare send 的文本片段
+	cacheDirs = []string{"thumbs"}   // 可以随时重建的缓存，超出配额时首先淘汰
+)
+
+var evictMu sync.Mutex
+
+// Usage 是 ~/.cfshare 按用途统计的占用字节数
+type Usage struct {
+	Inbox  int64
+	Pastes int64
+	Cache  int64
+	Logs   int64
+	Other  int64
+}
+
+// Total 返回总占用
+func (u Usage) Total() int64 {
+	return u.Inbox + u.Pastes + u.Cache + u.Logs + u.Other
+}
+
+// Measure 统计 ~/.cfshare 的磁盘占用
+func Measure() Usage {
+	var u Usage
+	root := config.GetConfigDir()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return u
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		size := dirSize(filepath.Join(root, name))
+		switch {
+		case slices.Contains(inboxDirs, name):
+			u.Inbox += size
+		case slices.Contains(pasteDirs, name):
+			u.Pastes += size
+		case slices.Contains(cacheDirs, name):
+			u.Cache += size
+		case strings.HasSuffix(name, ".log"):
+			u.Logs += size
+		case name == "shares":
+			// 挂载的分享各自的目录，其中的日志计入日志
+			filepath.WalkDir(filepath.Join(root, name), func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				if info, err := d.Info(); err == nil {
+					if strings.HasSuffix(d.Name(), ".log") {
+						u.Logs += info.Size()
+					} else {
+						u.Other += info.Size()
+					}
+				}
+				return nil
+			})
+		default:
+			u.Other += size
+		}
+	}
+	return u
+}
+
+// Available 返回配额内还能写入的字节数，必要时先淘汰缓存；没有配额时返回 -1
+func Available() int64 {
+	if Quota <= 0 {
+		return -1
+	}
+	total := Measure().Total()
+	if total > Quota {
+		total -= evictCaches(total - Quota)
+	}
+	return max(Quota-total, 0)
+}
+
+// Reserve 确保配额内还能写入 n 字节: 先按最近使用时间淘汰缓存，仍然不够时返回 ErrQuota
+func Reserve(n int64) error {
+	if Quota <= 0 {
+		return nil
+	}
+	total := Measure().Total()
+	if total+n > Quota {
+		total -= evictCaches(total + n - Quota)
+	}
+	if total+n > Quota {
+		return ErrQuota
+	}
+	return nil
+}
+
+// evictCaches 按修改时间 (缓存命中时会更新) 从旧到新删除缓存文件，直到释放 need 字节，返回实际释放的字节数
+func evictCaches(need int64) int64 {
+	evictMu.Lock()
+	defer evictMu.Unlock()
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	for _, dir := range cacheDirs {
+		filepath.WalkDir(filepath.Join(config.GetConfigDir(), dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				files = append(files, cached{path, info.Size(), info.ModTime()})
+			}
+			return nil
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var freed int64
+	for _, f := range files {
+		if freed >= need {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			freed += f.size
+		}
+	}
+	return freed
+}
+
+// Cache 是一个缓存的条目数和占用
+type Cache struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+	Where   string `json:"where"` // 磁盘目录，或 "memory" 表示服务进程内存中的缓存
+}
+
+// DiskCaches 返回 ~/.cfshare 下各缓存目录的统计
+func DiskCaches() []Cache {
+	var caches []Cache
+	for _, dir := range cacheDirs {
+		c := Cache{Name: dir, Where: filepath.Join(config.GetConfigDir(), dir)}
+		filepath.WalkDir(c.Where, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				c.Entries++
+				c.Bytes += info.Size()
+			}
+			return nil
+		})
+		caches = append(caches, c)
+	}
+	return caches
+}
+
+// IsDiskCache 判断 name 是否为磁盘缓存目录
+func IsDiskCache(name string) bool {
+	return slices.Contains(cacheDirs, name)
+}
+
+// ClearDiskCache 删除缓存目录中的全部文件，返回清除前的统计
+func ClearDiskCache(name string) (Cache, error) {
+	for _, c := range DiskCaches() {
+		if c.Name != name {
+			continue
+		}
+		evictMu.Lock()
+		defer evictMu.Unlock()
+		if err := os.RemoveAll(c.Where); err != nil {
+			return c, err
+		}
+		return c, nil
+	}
+	return Cache{}, fmt.Errorf("unknown cache %q", name)
+}
+
+// InConfigDir 判断 path 是否位于 ~/.cfshare 下，只有这些目录中的写入受配额限制
+func InConfigDir(path string) bool {
+	rel, err := filepath.Rel(config.GetConfigDir(), path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func dirSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	home := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	defer os.Setenv("HOME", origHome)
+
+	dir := filepath.Join(home, ".cfshare")
+	write := func(rel string, size int, age time.Duration) string {
+		path := filepath.Join(dir, rel)
+		os.MkdirAll(filepath.Dir(path), 0700)
+		os.WriteFile(path, make([]byte, size), 0600)
+		mtime := time.Now().Add(-age)
+		os.Chtimes(path, mtime, mtime)
+		return path
+	}
+	write("requests/1/upload.bin", 400, 0)
+	write("pastes/paste.txt", 100, 0)
+	oldThumb := write("thumbs/old.jpg", 200, time.Hour)
+	newThumb := write("thumbs/new.jpg", 200, time.Minute)
+	write("access.log", 50, 0)
+	write("state.json", 50, 0)
+
+	u := Measure()
+	if u.Inbox != 400 || u.Pastes != 100 || u.Cache != 400 || u.Logs != 50 || u.Other != 50 || u.Total() != 1000 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+
+	defer func() { Quota = 0 }()
+	Quota = 0
+	if Available() != -1 || Reserve(1<<30) != nil {
+		t.Fatal("no quota should allow everything")
+	}
+
+	// 需要 150 字节: 只淘汰最旧的缩略图
+	Quota = 1000
+	if err := Reserve(150); err != nil {
+		t.Fatalf("Reserve: %v", err)
+	}
+	if _, err := os.Stat(oldThumb); !os.IsNotExist(err) {
+		t.Error("the least recently used thumbnail should be evicted")
+	}
+	if _, err := os.Stat(newThumb); err != nil {
+		t.Error("the newer thumbnail should be kept")
+	}
+
+	// 缓存全部淘汰后仍然不够
+	if err := Reserve(500); !errors.Is(err, ErrQuota) {
+		t.Errorf("expected ErrQuota, got %v", err)
+	}
+	if got := Available(); got != 400 {
+		t.Errorf("Available = %d, want 400", got)
+	}
+
+	if !InConfigDir(filepath.Join(dir, "requests", "1")) || InConfigDir(filepath.Join(home, "Inbox")) {
+		t.Error("unexpected InConfigDir result")
+	}
+}
+
+func TestDiskCaches(t *testing.T) {
+	home := t.TempDir()
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	defer os.Setenv("HOME", origHome)
+
+	thumbs := filepath.Join(home, ".cfshare", "thumbs")
+	os.MkdirAll(thumbs, 0700)
+	os.WriteFile(filepath.Join(thumbs, "a.jpg"), make([]byte, 10), 0600)
+	os.WriteFile(filepath.Join(thumbs, "b.jpg"), make([]byte, 20), 0600)
+
+	caches := DiskCaches()
+	if len(caches) != 1 || caches[0].Name != "thumbs" || caches[0].Entries != 2 || caches[0].Bytes != 30 {
+		t.Fatalf("unexpected caches: %+v", caches)
+	}
+	if c, err := ClearDiskCache("thumbs"); err != nil || c.Entries != 2 {
+		t.Fatalf("ClearDiskCache = %+v, %v", c, err)
+	}
+	if c := DiskCaches()[0]; c.Entries != 0 {
+		t.Errorf("thumbs not cleared: %+v", c)
+	}
+	if _, err := ClearDiskCache("bogus"); err == nil {
+		t.Error("expected error for an unknown cache")
+	}
+}
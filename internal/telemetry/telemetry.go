@@ -0,0 +1,186 @@
+// Package telemetry 收集匿名的功能使用次数，帮助维护者决定优先改进哪些功能。
+// 只有在用户执行 cfshare telemetry on 明确同意后才记录和发送；数据只包含
+// 命令和参数名的使用次数，不含路径、URL、口令或任何参数值，也没有安装 ID。
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// client 是发送计数专用的 HTTP 客户端，有自己的超时，不使用 http.DefaultClient
+var client = &http.Client{Timeout: config.TelemetryTimeout}
+
+// Data 是保存在 telemetry.json 中的设置和尚未发送的计数
+type Data struct {
+	Enabled     bool           `json:"enabled"`
+	PeriodStart time.Time      `json:"period_start,omitempty"` // 本期计数开始的时间
+	LastSent    time.Time      `json:"last_sent,omitempty"`
+	Counts      map[string]int `json:"counts,omitempty"` // 如 "command:share"、"flag:public"
+}
+
+// Payload 是实际发送的内容，cfshare telemetry status 会原样显示
+type Payload struct {
+	Version string         `json:"version"`
+	OS      string         `json:"os"`
+	Arch    string         `json:"arch"`
+	Period  string         `json:"period"` // 计数开始的日期，只精确到天
+	Counts  map[string]int `json:"counts"`
+}
+
+// Load 读取遥测设置，文件不存在时返回未启用的默认值
+func Load() (*Data, error) {
+	d := &Data{}
+	data, err := os.ReadFile(config.GetTelemetryPath())
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Save 写入遥测设置
+func (d *Data) Save() error {
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.GetTelemetryPath(), data, 0600)
+}
+
+// SetEnabled 开启或关闭遥测，关闭时丢弃尚未发送的计数
+func SetEnabled(enabled bool) error {
+	d, err := Load()
+	if err != nil {
+		d = &Data{}
+	}
+	d.Enabled = enabled
+	d.Counts = nil
+	d.PeriodStart = time.Time{}
+	if enabled {
+		d.PeriodStart = time.Now()
+	}
+	return d.Save()
+}
+
+// Record 为每个功能的计数加一，未开启遥测时什么也不做
+func Record(features ...string) error {
+	d, err := Load()
+	if err != nil || !d.Enabled || len(features) == 0 {
+		return err
+	}
+	if d.Counts == nil {
+		d.Counts = make(map[string]int)
+	}
+	for _, f := range features {
+		d.Counts[f]++
+	}
+	return d.Save()
+}
+
+// Payload 返回下一次将要发送的内容
+func (d *Data) Payload(version string) Payload {
+	counts := d.Counts
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	return Payload{
+		Version: version,
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Period:  d.PeriodStart.UTC().Format("2006-01-02"),
+		Counts:  counts,
+	}
+}
+
+// Due 判断本期计数是否已满 config.TelemetryInterval，可以发送
+func (d *Data) Due(now time.Time) bool {
+	return d.Enabled && len(d.Counts) > 0 && now.Sub(d.PeriodStart) >= config.TelemetryInterval
+}
+
+// Flush 在本期计数到期时把汇总 POST 到 url 并开始新的一期。url 为空 (构建时未设置
+// 接收地址) 时不发送。发送失败时保留计数，下次再试。
+func Flush(ctx context.Context, url, version string) error {
+	d, err := Load()
+	if err != nil || url == "" || !d.Due(time.Now()) {
+		return err
+	}
+
+	body, err := json.Marshal(d.Payload(version))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+
+	d.Counts = nil
+	d.LastSent = time.Now()
+	d.PeriodStart = d.LastSent
+	return d.Save()
+}
+
+// Watch 在服务进程中运行: 启动时和之后每隔 config.TelemetryCheckInterval 调用一次 Flush，
+// 直到 ctx 取消。命令行只记录计数，不在退出前等待网络。
+func Watch(ctx context.Context, url, version string) {
+	if url == "" {
+		return
+	}
+	ticker := time.NewTicker(config.TelemetryCheckInterval)
+	defer ticker.Stop()
+	for {
+		Flush(ctx, url, version)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Format 返回 cfshare telemetry status 的输出，包括下一次将发送的完整内容
+func (d *Data) Format(url, version string) string {
+	if !d.Enabled {
+		return "遥测:       关闭（默认）\n开启: cfshare telemetry on\n"
+	}
+	out := "遥测:       开启\n"
+	if url == "" {
+		out += "接收地址:   此构建未设置，计数只保存在本机\n"
+	} else {
+		out += "接收地址:   " + url + "\n"
+	}
+	if !d.LastSent.IsZero() {
+		out += "上次发送:   " + d.LastSent.Format("2006-01-02 15:04") + "\n"
+	}
+	out += "下次发送:   " + d.PeriodStart.Add(config.TelemetryInterval).Format("2006-01-02") + " 之后（有计数时）\n"
+
+	payload, _ := json.MarshalIndent(d.Payload(version), "", "  ")
+	out += "\n将要发送的内容:\n" + string(payload) + "\n"
+	return out
+}
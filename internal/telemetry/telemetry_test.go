@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+func setupHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	os.MkdirAll(config.GetConfigDir(), 0700)
+}
+
+func TestRecordRequiresOptIn(t *testing.T) {
+	setupHome(t)
+
+	Record("command:share")
+	if _, err := os.Stat(config.GetTelemetryPath()); !os.IsNotExist(err) {
+		t.Fatal("nothing should be written before telemetry is turned on")
+	}
+
+	SetEnabled(true)
+	Record("command:share", "flag:public")
+	Record("command:share")
+	d, _ := Load()
+	if d.Counts["command:share"] != 2 || d.Counts["flag:public"] != 1 {
+		t.Errorf("counts = %v", d.Counts)
+	}
+
+	SetEnabled(false)
+	d, _ = Load()
+	if d.Enabled || len(d.Counts) != 0 {
+		t.Errorf("turning telemetry off should drop pending counts: %+v", d)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	setupHome(t)
+
+	var got Payload
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	SetEnabled(true)
+	Record("command:send")
+
+	// 未满一期时不发送
+	if err := Flush(context.Background(), ts.URL, "1.2.3"); err != nil || got.Version != "" {
+		t.Fatalf("sent before the period ended: %v %+v", err, got)
+	}
+
+	d, _ := Load()
+	d.PeriodStart = time.Now().Add(-config.TelemetryInterval)
+	d.Save()
+
+	if err := Flush(context.Background(), ts.URL, "1.2.3"); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if got.Version != "1.2.3" || got.Counts["command:send"] != 1 {
+		t.Errorf("payload = %+v", got)
+	}
+
+	d, _ = Load()
+	if len(d.Counts) != 0 || d.LastSent.IsZero() {
+		t.Errorf("counts should reset after sending: %+v", d)
+	}
+	if !strings.Contains(d.Format("", "1.2.3"), "此构建未设置") {
+		t.Error("status should say when no endpoint is configured")
+	}
+}
+
+func TestWatchFlushesInBackground(t *testing.T) {
+	setupHome(t)
+
+	sent := make(chan Payload, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		json.NewDecoder(r.Body).Decode(&p)
+		sent <- p
+	}))
+	defer ts.Close()
+
+	SetEnabled(true)
+	Record("command:share")
+	d, _ := Load()
+	d.PeriodStart = time.Now().Add(-config.TelemetryInterval)
+	d.Save()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, ts.URL, "1.2.3")
+		close(done)
+	}()
+	select {
+	case p := <-sent:
+		if p.Counts["command:share"] != 1 {
+			t.Errorf("payload = %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not flush due counts")
+	}
+	cancel()
+	<-done
+}
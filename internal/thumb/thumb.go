@@ -0,0 +1,244 @@
+// Package thumb 生成并缓存图片缩略图，缓存位于 ~/.cfshare/thumbs，
+// 总大小超过上限时按最近使用时间淘汰。
+package thumb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif" // 注册 GIF 解码器
+	"image/jpeg"
+	_ "image/png" // 注册 PNG 解码器
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cfshare/internal/config"
+	"cfshare/internal/storage"
+)
+
+const (
+	// Size 是缩略图最长边的像素数
+	Size = 320
+
+	// MaxSourceBytes 超过该大小的图片不生成缩略图
+	MaxSourceBytes = 64 << 20
+
+	// MaxSourcePixels 限制解码的像素数，防止超大图片耗尽内存
+	MaxSourcePixels = 50_000_000
+
+	// MaxCacheBytes 是缩略图缓存的总大小上限
+	MaxCacheBytes = 256 << 20
+
+	// maxConcurrent 限制同时生成缩略图的数量
+	maxConcurrent = 2
+)
+
+var (
+	sem     = make(chan struct{}, maxConcurrent)
+	evictMu sync.Mutex
+)
+
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// IsImage 判断文件名是否为支持生成缩略图的图片格式
+func IsImage(name string) bool {
+	return imageExts[strings.ToLower(filepath.Ext(name))]
+}
+
+// Get 返回图片对应的缩略图缓存路径，缓存不存在时生成
+func Get(path string, info fs.FileInfo) (string, error) {
+	if info.Size() > MaxSourceBytes {
+		return "", fmt.Errorf("image too large for thumbnail")
+	}
+
+	cachePath := filepath.Join(config.GetThumbsDir(), cacheKey(path, info)+".jpg")
+	if _, err := os.Stat(cachePath); err == nil {
+		// 更新修改时间作为最近使用时间，供淘汰时参考
+		now := time.Now()
+		os.Chtimes(cachePath, now, now)
+		return cachePath, nil
+	}
+
+	sem <- struct{}{}
+	defer func() { <-sem }()
+
+	// 等待期间可能已被其他请求生成
+	if _, err := os.Stat(cachePath); err == nil {
+		return cachePath, nil
+	}
+
+	if err := generate(path, cachePath); err != nil {
+		return "", err
+	}
+
+	evict()
+	return cachePath, nil
+}
+
+// cacheKey 由路径、大小和修改时间决定，源文件变化后自动失效
+func cacheKey(path string, info fs.FileInfo) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano(), Size)))
+	return hex.EncodeToString(h[:16])
+}
+
+func generate(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("decode image config: %w", err)
+	}
+	if cfg.Width*cfg.Height > MaxSourcePixels {
+		return fmt.Errorf("image dimensions too large for thumbnail")
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("decode image: %w", err)
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > Size || h > Size {
+		if w >= h {
+			h = h * Size / w
+			w = Size
+		} else {
+			w = w * Size / h
+			h = Size
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dstImg := scale(img, w, h)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	// 先写临时文件再重命名，避免并发读取到不完整的缩略图
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(tmp, dstImg, &jpeg.Options{Quality: 80}); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	return os.Rename(tmp.Name(), dst)
+}
+
+// evict 在缓存超过上限时删除最久未使用的缩略图
+func evict() {
+	evictMu.Lock()
+	defer evictMu.Unlock()
+
+	entries, err := os.ReadDir(config.GetThumbsDir())
+	if err != nil {
+		return
+	}
+
+	type cached struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		files = append(files, cached{
+			path:    filepath.Join(config.GetThumbsDir(), entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+	}
+
+	// 超出 ~/.cfshare 的总配额时由 storage 继续淘汰缓存
+	defer storage.Reserve(0)
+
+	if total <= MaxCacheBytes {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		if total <= MaxCacheBytes {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+		}
+	}
+}
+
+// scale 使用区域平均 (box filter) 把图片缩小到 w x h
+func scale(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := b.Dx(), b.Dy()
+
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*sh/h
+		y1 := b.Min.Y + (y+1)*sh/h
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*sw/w
+			x1 := b.Min.X + (x+1)*sw/w
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,215 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// signature 是 tunnel.log 中一类常见故障的特征及处理步骤，
+// 步骤中的 <tunnel> 在输出时替换为实际的 tunnel 名称
+type signature struct {
+	pattern *regexp.Regexp
+	problem string
+	steps   []string
+	connect bool // 妨碍连接边缘的故障，之后成功注册连接说明已经解决
+}
+
+var signatures = []signature{
+	{
+		pattern: regexp.MustCompile(`(?i)cannot determine default origin certificate|origin certificate|cert\.pem`),
+		problem: "找不到 Cloudflare 登录证书 (cert.pem)",
+		connect: true,
+		steps: []string{
+			"运行 cloudflared tunnel login 重新登录并授权域名",
+			"确认 ~/.cloudflared/cert.pem 存在且当前用户可读",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)credentials file .*(doesn't exist|does not exist|not found)|tunnel credentials file|no such file or directory.*\.json`),
+		problem: "找不到 tunnel 凭据文件 (<UUID>.json)",
+		connect: true,
+		steps: []string{
+			"运行 ls ~/.cloudflared/*.json 确认凭据文件存在",
+			"检查 config.yml 中 credentials-file 指向的路径是否正确",
+			"凭据已丢失时重新创建: cloudflared tunnel delete <tunnel> && cloudflared tunnel create <tunnel>",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)tunnel not found|unauthorized|\b1033\b`),
+		problem: "Tunnel 不存在或凭据不匹配，访问者会看到 1033 错误",
+		connect: true,
+		steps: []string{
+			"运行 cloudflared tunnel list 确认 tunnel <tunnel> 存在",
+			"确认凭据文件的 UUID 与 tunnel 一致",
+			"重新启动分享: cfshare stop && cfshare <path>",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)failed to add route|code: 1003|record with that host already exists|\b530\b|error 1016|origin dns error`),
+		problem: "DNS 路由缺失或冲突，访问者会看到 530 / 1016 错误",
+		steps: []string{
+			"为分享域名添加路由: cloudflared tunnel route dns <tunnel> <hostname>",
+			"如果已有同名的 A/AAAA/CNAME 记录，先在 Cloudflare 控制台删除",
+			"确认 config.yml 中 ingress 的 hostname 与路由的域名一致",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)failed to dial to edge with quic|quic.*(timeout|no recent network activity)|failed to (serve|create new) quic connection`),
+		problem: "QUIC (UDP 7844) 被网络阻止",
+		connect: true,
+		steps: []string{
+			"使用 --protocol http2 启动分享 (默认的 auto 会在 QUIC 连不上时自动换用 http2)",
+			"如果直接运行 cloudflared，在 config.yml 中删除 protocol: quic 或改为 protocol: http2",
+			"或在防火墙中放行出站 UDP 7844 端口",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)dial tcp .*:7844|lookup .*argotunnel\.com|edge discovery`),
+		problem: "无法连接 Cloudflare 边缘",
+		connect: true,
+		steps: []string{
+			"放行出站 TCP 7844 端口",
+			"检查 DNS 解析: nslookup region1.v2.argotunnel.com",
+			"使用代理的网络需要为 cloudflared 配置直连",
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)unable to reach the origin service|dial tcp (127\.0\.0\.1|\[::1\]|localhost):\d+: connect: connection refused`),
+		problem: "tunnel 无法连接本地的 cfshare 服务，访问者会看到 502 错误",
+		steps: []string{
+			"运行 cfshare status 确认分享仍在运行",
+			"确认 config.yml 中 ingress 的 service 端口与 --port 一致 (默认 8787)",
+		},
+	},
+}
+
+// Finding 是日志中命中的一类故障
+type Finding struct {
+	Problem string
+	Steps   []string
+	Count   int
+	Last    string // 最后一条命中的日志
+}
+
+// Analyze 扫描 cloudflared 日志，按特征归类常见故障，返回顺序与特征列表一致
+func Analyze(r io.Reader) ([]Finding, error) {
+	counts := make([]int, len(signatures))
+	last := make([]string, len(signatures))
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := matchSignature(line); i >= 0 {
+			counts[i]++
+			last[i] = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return collectFindings(counts, last), nil
+}
+
+// Recent 只分析 cloudflared 最近一次启动以来、now 之前 window 内的日志，用于判断 tunnel 当前的问题。
+// tunnel.log 只会追加，更早的错误可能早已解决；之后成功注册过连接的连接类故障
+// (证书、凭据、QUIC 被阻止等) 同样视为已经解决。
+func Recent(r io.Reader, now time.Time, window time.Duration) ([]Finding, error) {
+	var lines []string
+	registered := -1
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "Starting tunnel") {
+			lines, registered = lines[:0], -1
+		}
+		if t, ok := logTime(line); ok && now.Sub(t) > window {
+			continue
+		}
+		if strings.Contains(line, registeredMarker) {
+			registered = len(lines)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int, len(signatures))
+	last := make([]string, len(signatures))
+	for n, line := range lines {
+		i := matchSignature(line)
+		if i < 0 || (signatures[i].connect && n < registered) {
+			continue
+		}
+		counts[i]++
+		last[i] = line
+	}
+	return collectFindings(counts, last), nil
+}
+
+// Diagnosis 把故障汇总为一行，用于 cfshare status 和 cfshare doctor。
+// 特征列表按排查顺序排列，最靠前的故障通常是根本原因。没有故障时返回空字符串。
+func Diagnosis(findings []Finding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	line := fmt.Sprintf("%s (%d 次)", findings[0].Problem, findings[0].Count)
+	if len(findings) > 1 {
+		line += fmt.Sprintf("，另有 %d 类问题", len(findings)-1)
+	}
+	return line
+}
+
+// matchSignature 返回日志行命中的第一个特征，没有命中时返回 -1
+func matchSignature(line string) int {
+	for i, sig := range signatures {
+		if sig.pattern.MatchString(line) {
+			return i
+		}
+	}
+	return -1
+}
+
+// logTime 读取 cloudflared 日志行开头的时间 (如 2026-10-16T08:00:00Z)
+func logTime(line string) (time.Time, bool) {
+	field, _, _ := strings.Cut(line, " ")
+	t, err := time.Parse(time.RFC3339, field)
+	return t, err == nil
+}
+
+// collectFindings 按特征列表的顺序返回命中过的故障
+func collectFindings(counts []int, last []string) []Finding {
+	var findings []Finding
+	for i, sig := range signatures {
+		if counts[i] > 0 {
+			findings = append(findings, Finding{Problem: sig.problem, Steps: sig.steps, Count: counts[i], Last: last[i]})
+		}
+	}
+	return findings
+}
+
+// FormatFindings 输出故障及针对性的处理步骤
+func FormatFindings(findings []Finding, tunnelName string) string {
+	if len(findings) == 0 {
+		return "未在 tunnel 日志中发现已知的故障特征\n"
+	}
+
+	var b strings.Builder
+	for i, f := range findings {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "✗ %s (%d 次)\n", f.Problem, f.Count)
+		fmt.Fprintf(&b, "  最近一次: %s\n", strings.TrimSpace(f.Last))
+		for j, step := range f.Steps {
+			fmt.Fprintf(&b, "  %d. %s\n", j+1, strings.ReplaceAll(step, "<tunnel>", tunnelName))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const tunnelLog = `2026-10-16T08:00:00Z INF Starting tunnel tunnelID=6ff42ae2-765d-4adf-8112-31c55c1551ef
+2026-10-16T08:00:01Z ERR Failed to dial to edge with quic: timeout: no recent network activity
+2026-10-16T08:00:05Z ERR Failed to dial to edge with quic: timeout: no recent network activity
+2026-10-16T08:01:00Z INF Registered tunnel connection connIndex=0 location=sjc01 protocol=http2
+2026-10-16T08:02:00Z ERR  error="Unable to reach the origin service. The service may be down or it may not be responding to traffic from cloudflared: dial tcp 127.0.0.1:8787: connect: connection refused"
+`
+
+func TestAnalyze(t *testing.T) {
+	findings, err := Analyze(strings.NewReader(tunnelLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("findings = %+v", findings)
+	}
+	if !strings.Contains(findings[0].Problem, "QUIC") || findings[0].Count != 2 {
+		t.Errorf("findings[0] = %+v", findings[0])
+	}
+	if !strings.Contains(findings[1].Problem, "502") || findings[1].Count != 1 {
+		t.Errorf("findings[1] = %+v", findings[1])
+	}
+
+	findings, _ = Analyze(strings.NewReader("2026-10-16T08:00:00Z ERR Failed to add route: code: 1003, reason: An A, AAAA, or CNAME record with that host already exists.\n"))
+	out := FormatFindings(findings, "myshare")
+	if !strings.Contains(out, "cloudflared tunnel route dns myshare <hostname>") {
+		t.Errorf("missing remediation:\n%s", out)
+	}
+
+	findings, _ = Analyze(strings.NewReader("2026-10-16T08:00:00Z INF Registered tunnel connection\n"))
+	if len(findings) != 0 {
+		t.Errorf("healthy log findings = %+v", findings)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	log := `2026-10-16T06:00:00Z INF Starting tunnel tunnelID=abc
+2026-10-16T06:00:01Z ERR Failed to add route: code: 1003, reason: An A, AAAA, or CNAME record with that host already exists.
+2026-10-16T08:00:00Z INF Starting tunnel tunnelID=abc
+2026-10-16T08:00:01Z ERR Failed to dial to edge with quic: timeout: no recent network activity
+2026-10-16T08:00:05Z ERR Failed to dial to edge with quic: timeout: no recent network activity
+2026-10-16T08:01:00Z INF Registered tunnel connection connIndex=0 location=sjc01 protocol=http2
+2026-10-16T08:30:00Z ERR  error="Unable to reach the origin service. The service may be down or it may not be responding to traffic from cloudflared: dial tcp 127.0.0.1:8787: connect: connection refused"
+`
+	// 上次启动前的 DNS 错误和之后已经连上边缘的 QUIC 错误都不算
+	findings, err := Recent(strings.NewReader(log), now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || !strings.Contains(findings[0].Problem, "502") {
+		t.Fatalf("findings = %+v", findings)
+	}
+	if d := Diagnosis(findings); !strings.Contains(d, "502") || !strings.Contains(d, "(1 次)") {
+		t.Errorf("Diagnosis = %q", d)
+	}
+
+	// 超出时间窗口的错误不算
+	if findings, _ := Recent(strings.NewReader(log), now.Add(2*time.Hour), time.Hour); len(findings) != 0 {
+		t.Errorf("stale findings = %+v", findings)
+	}
+
+	// 一直没有连上边缘时报告 QUIC 被阻止
+	stuck := "2026-10-16T08:00:00Z INF Starting tunnel\n2026-10-16T08:00:01Z ERR Failed to dial to edge with quic: timeout\n2026-10-16T08:00:02Z ERR Unauthorized: Failed to get tunnel\n"
+	findings, _ = Recent(strings.NewReader(stuck), now, time.Hour)
+	if d := Diagnosis(findings); !strings.HasPrefix(d, "Tunnel 不存在或凭据不匹配") || !strings.HasSuffix(d, "另有 1 类问题") {
+		t.Errorf("Diagnosis = %q", d)
+	}
+	if Diagnosis(nil) != "" {
+		t.Error("empty findings should give empty diagnosis")
+	}
+}
@@ -0,0 +1,129 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// infoTimeout 限制 cloudflared tunnel info 的耗时，避免 cfshare status 卡住
+const infoTimeout = 10 * time.Second
+
+// Connector 是运行同一 tunnel 的一个 cloudflared 实例，每个实例维持多条到边缘的 HA 连接
+type Connector struct {
+	ID          string       `json:"id"`
+	Version     string       `json:"version"`
+	Arch        string       `json:"arch"`
+	RunAt       time.Time    `json:"run_at"`
+	Connections []Connection `json:"conns"`
+}
+
+// Connection 是 connector 到某个 Cloudflare 数据中心 (colo) 的一条连接
+type Connection struct {
+	Colo             string    `json:"colo_name"`
+	OriginIP         string    `json:"origin_ip"`
+	OpenedAt         time.Time `json:"opened_at"`
+	PendingReconnect bool      `json:"is_pending_reconnect"`
+}
+
+// Connectors 通过 cloudflared tunnel info 查询当前在线的 connector
+func (m *Manager) Connectors() ([]Connector, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), infoTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "cloudflared", "tunnel", "info", "--output", "json", m.tunnelName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("get tunnel info: %w", err)
+	}
+	return parseConnectors(output)
+}
+
+func parseConnectors(data []byte) ([]Connector, error) {
+	var info struct {
+		Connectors []Connector `json:"conns"`
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("parse tunnel info: %w", err)
+	}
+	return info.Connectors, nil
+}
+
+// FormatConnectors 汇总 connector 数量、连接的数据中心和最近一次 (重新) 建立连接的时间，
+// 用于 cfshare status 判断冗余和边缘连接是否健康
+func FormatConnectors(connectors []Connector, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("\n边缘连接\n────────────────────────────────────────\n")
+	if len(connectors) == 0 {
+		b.WriteString("Connectors: 0 (tunnel 未连接到边缘)\n")
+		return b.String()
+	}
+
+	var total, pending int
+	var lastOpened time.Time
+	colos := map[string]bool{}
+	for _, c := range connectors {
+		for _, conn := range c.Connections {
+			total++
+			if conn.PendingReconnect {
+				pending++
+			}
+			if conn.OpenedAt.After(lastOpened) {
+				lastOpened = conn.OpenedAt
+			}
+			colos[conn.Colo] = true
+		}
+	}
+
+	fmt.Fprintf(&b, "Connectors: %d (%d 条连接", len(connectors), total)
+	if pending > 0 {
+		fmt.Fprintf(&b, "，%d 条正在重连", pending)
+	}
+	b.WriteString(")\n")
+
+	for _, c := range connectors {
+		id := c.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		var origin string
+		var locations []string
+		for _, conn := range c.Connections {
+			origin = conn.OriginIP
+			locations = append(locations, conn.Colo)
+		}
+		sort.Strings(locations)
+		fmt.Fprintf(&b, "  %s  %s %s  %s  %s\n", id, c.Version, c.Arch, origin, strings.Join(locations, ", "))
+	}
+
+	names := make([]string, 0, len(colos))
+	for name := range colos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(&b, "Locations:  %s\n", strings.Join(names, ", "))
+
+	if !lastOpened.IsZero() {
+		fmt.Fprintf(&b, "Last Reconnect: %s (%s前)\n", lastOpened.Local().Format("2006-01-02 15:04:05"), formatAgo(now.Sub(lastOpened)))
+	}
+	if len(connectors) == 1 {
+		b.WriteString("提示: 只有一个 connector，这台机器离线时分享将不可用 (可用 cfshare standby 在另一台机器热备)\n")
+	}
+	return b.String()
+}
+
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%d 秒", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%d 分钟", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%d 小时", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d 天", int(d.Hours()/24))
+	}
+}
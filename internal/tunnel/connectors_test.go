@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const tunnelInfoJSON = `{
+  "id": "6ff42ae2-765d-4adf-8112-31c55c1551ef",
+  "name": "cfshare",
+  "createdAt": "2026-01-02T03:04:05Z",
+  "conns": [
+    {
+      "id": "1c4b1f3a-0a55-4f9e-9d51-0c1f5b1a2b3c",
+      "features": ["serialized_headers"],
+      "version": "2026.9.1",
+      "arch": "linux_amd64",
+      "run_at": "2026-10-16T08:00:00Z",
+      "conns": [
+        {"colo_name": "sjc01", "id": "a", "is_pending_reconnect": false, "origin_ip": "203.0.113.5", "opened_at": "2026-10-16T08:00:01Z"},
+        {"colo_name": "lax05", "id": "b", "is_pending_reconnect": true, "origin_ip": "203.0.113.5", "opened_at": "2026-10-16T09:30:00Z"}
+      ]
+    },
+    {
+      "id": "9d8e7f60-1111-2222-3333-444455556666",
+      "version": "2026.9.1",
+      "arch": "darwin_arm64",
+      "run_at": "2026-10-16T07:00:00Z",
+      "conns": [
+        {"colo_name": "sjc01", "id": "c", "is_pending_reconnect": false, "origin_ip": "198.51.100.7", "opened_at": "2026-10-16T07:00:02Z"}
+      ]
+    }
+  ]
+}`
+
+func TestFormatConnectors(t *testing.T) {
+	connectors, err := parseConnectors([]byte(tunnelInfoJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(connectors) != 2 || len(connectors[0].Connections) != 2 {
+		t.Fatalf("connectors = %+v", connectors)
+	}
+
+	now := time.Date(2026, 10, 16, 9, 35, 0, 0, time.UTC)
+	out := FormatConnectors(connectors, now)
+	for _, want := range []string{
+		"Connectors: 2 (3 条连接，1 条正在重连)",
+		"1c4b1f3a  2026.9.1 linux_amd64  203.0.113.5  lax05, sjc01",
+		"Locations:  lax05, sjc01",
+		"(5 分钟前)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "只有一个 connector") {
+		t.Errorf("unexpected redundancy warning:\n%s", out)
+	}
+
+	out = FormatConnectors(connectors[1:], now)
+	if !strings.Contains(out, "只有一个 connector") {
+		t.Errorf("missing redundancy warning:\n%s", out)
+	}
+	if out := FormatConnectors(nil, now); !strings.Contains(out, "Connectors: 0") {
+		t.Errorf("empty output = %q", out)
+	}
+}
@@ -0,0 +1,131 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// metricsTimeout 限制读取 cloudflared 指标的耗时
+const metricsTimeout = 5 * time.Second
+
+// ErrNotRunning 表示 cloudflared 没有运行，无法判断连接状态
+var ErrNotRunning = fmt.Errorf("cloudflared 未运行")
+
+// metricsAddr 为 cloudflared 的指标接口选一个本机空闲端口
+func metricsAddr() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return ""
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// Connections 返回 cloudflared 当前注册到边缘的连接数及其来源 ("metrics" 或 "log")。
+// PID 存活不代表连接正常，连接数优先从 cloudflared 的指标接口读取，
+// 接口不可用时 (如旧版本 cfshare 启动的 tunnel) 从 tunnel.log 推断。
+// cloudflared 没有运行时返回 ErrNotRunning。
+func (m *Manager) Connections() (int, string, error) {
+	if m.GetRunningPID() <= 0 {
+		return 0, "", ErrNotRunning
+	}
+	if data, err := os.ReadFile(config.GetTunnelMetricsPath()); err == nil {
+		if n, err := scrapeConnections(strings.TrimSpace(string(data))); err == nil {
+			return n, "metrics", nil
+		}
+	}
+	f, err := os.Open(config.GetTunnelLogPath())
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	n, err := logConnections(f)
+	return n, "log", err
+}
+
+func scrapeConnections(addr string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/metrics", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("metrics: %s", resp.Status)
+	}
+	return parseHAConnections(resp.Body)
+}
+
+// parseHAConnections 从 Prometheus 指标中读取 cloudflared_tunnel_ha_connections
+func parseHAConnections(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || name != "cloudflared_tunnel_ha_connections" {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0, fmt.Errorf("metrics: %w", err)
+		}
+		return int(n), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("metrics: cloudflared_tunnel_ha_connections not found")
+}
+
+var connIndexPattern = regexp.MustCompile(`connIndex=(\d+)`)
+
+// logConnections 按 connIndex 跟踪 tunnel.log 中每条连接最后的状态，返回仍处于注册状态的连接数。
+// cloudflared 每次启动时重新计数。
+func logConnections(r io.Reader) (int, error) {
+	up := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "Starting tunnel") {
+			up = map[string]bool{}
+			continue
+		}
+		match := connIndexPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		switch {
+		case strings.Contains(line, registeredMarker):
+			up[match[1]] = true
+		case strings.Contains(line, "Unregistered tunnel connection"),
+			strings.Contains(line, "Connection terminated"),
+			strings.Contains(line, "Serve tunnel error"),
+			strings.Contains(line, "Retrying connection"):
+			up[match[1]] = false
+		}
+	}
+	n := 0
+	for _, ok := range up {
+		if ok {
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
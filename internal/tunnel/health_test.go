@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseHAConnections(t *testing.T) {
+	metrics := `# HELP cloudflared_tunnel_ha_connections Number of active ha connections
+# TYPE cloudflared_tunnel_ha_connections gauge
+cloudflared_tunnel_ha_connections 4
+# HELP cloudflared_tunnel_total_requests Amount of requests proxied through all the tunnels
+cloudflared_tunnel_total_requests 12
+`
+	if n, err := parseHAConnections(strings.NewReader(metrics)); err != nil || n != 4 {
+		t.Errorf("parseHAConnections = %d, %v", n, err)
+	}
+	if _, err := parseHAConnections(strings.NewReader("go_goroutines 10\n")); err == nil {
+		t.Error("expected error when the gauge is missing")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("cloudflared_tunnel_ha_connections 0\n"))
+	}))
+	defer srv.Close()
+	if n, err := scrapeConnections(strings.TrimPrefix(srv.URL, "http://")); err != nil || n != 0 {
+		t.Errorf("scrapeConnections = %d, %v", n, err)
+	}
+}
+
+func TestLogConnections(t *testing.T) {
+	log := `2024-05-01T10:00:00Z INF Starting tunnel tunnelID=abc
+2024-05-01T10:00:01Z INF Registered tunnel connection connIndex=0 connection=a event=0 ip=198.41.200.13 location=hkg08 protocol=http2
+2024-05-01T10:00:01Z INF Registered tunnel connection connIndex=1 connection=b event=0 ip=198.41.192.7 location=nrt01 protocol=http2
+2024-05-01T10:00:02Z INF Registered tunnel connection connIndex=2 connection=c event=0 ip=198.41.200.23 location=hkg08 protocol=http2
+2024-05-01T11:00:00Z ERR Serve tunnel error error="connection with edge closed" connIndex=1 event=0 ip=198.41.192.7
+2024-05-01T11:00:00Z INF Retrying connection in up to 1s connIndex=1 event=0 ip=198.41.192.7
+2024-05-01T11:00:05Z INF Unregistered tunnel connection connIndex=2 event=0 ip=198.41.200.23
+`
+	if n, err := logConnections(strings.NewReader(log)); err != nil || n != 1 {
+		t.Errorf("logConnections = %d, %v", n, err)
+	}
+
+	// 重新启动后之前的连接不再计数
+	restarted := log + "2024-05-01T12:00:00Z INF Starting tunnel tunnelID=abc\n"
+	if n, _ := logConnections(strings.NewReader(restarted)); n != 0 {
+		t.Errorf("after restart = %d", n)
+	}
+	reconnected := log + "2024-05-01T11:00:06Z INF Registered tunnel connection connIndex=1 connection=d event=0\n"
+	if n, _ := logConnections(strings.NewReader(reconnected)); n != 2 {
+		t.Errorf("after reconnect = %d", n)
+	}
+}
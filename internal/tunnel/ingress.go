@@ -0,0 +1,403 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cfshare/internal/config"
+)
+
+// Origin 是 cloudflared 应当转发到的本机服务: 分享的主机名、挂载路径 (--mount，默认分享为空) 和端口
+type Origin struct {
+	Hostname string
+	Path     string
+	Port     int
+}
+
+// SetOrigins 让 Start 为正在运行的分享生成 cfshare 自己的 cloudflared 配置 (~/.cfshare/cloudflared.yml)，
+// 其中每个分享的 ingress 规则指向它的端口，多个分享可以在不同主机名上共用一个 cloudflared。
+// 用户的 config.yml 不会被修改，--port 与 config.yml 中的端口不一致时 tunnel 也不会返回 502。
+// tunnel 已在运行而生成的配置有变化时，Start 会重启 cloudflared。
+func (m *Manager) SetOrigins(origins []Origin) {
+	m.origins = nil
+	for _, o := range origins {
+		if o.Hostname != "" && o.Port != 0 {
+			m.origins = append(m.origins, o)
+		}
+	}
+}
+
+// UserConfigPath 返回 cloudflared 默认读取的配置文件，都不存在时返回空字符串
+func UserConfigPath() string {
+	home, _ := os.UserHomeDir()
+	for _, path := range []string{
+		filepath.Join(home, ".cloudflared", "config.yml"),
+		filepath.Join(home, ".cloudflared", "config.yaml"),
+		"/etc/cloudflared/config.yml",
+		"/etc/cloudflared/config.yaml",
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func managedConfigPath() string {
+	return filepath.Join(config.GetConfigDir(), "cloudflared.yml")
+}
+
+// writeManagedConfig 以用户的 config.yml 为基础生成 cloudflared.yml，把每个 origin 的 ingress 规则
+// 改写为本机端口。没有 config.yml 时用 tunnel 的 ID 和凭据文件生成最小配置。无法生成时返回空路径，
+// cloudflared 照旧读取默认配置。changed 表示内容与上次生成的不同。
+func (m *Manager) writeManagedConfig() (path string, changed bool, err error) {
+	first := m.origins[0]
+
+	var content string
+	if userPath := UserConfigPath(); userPath != "" {
+		data, err := os.ReadFile(userPath)
+		if err != nil {
+			return "", false, err
+		}
+		content = "# 由 cfshare 根据 " + userPath + " 生成，每次启动 tunnel 时重写，请修改原文件\n" + string(data)
+	} else {
+		id, err := tunnelID(m.tunnelName)
+		if err != nil || id == "" {
+			return "", false, nil
+		}
+		home, _ := os.UserHomeDir()
+		credentials := filepath.Join(home, ".cloudflared", id+".json")
+		if _, err := os.Stat(credentials); err != nil {
+			return "", false, nil
+		}
+		content = ConfigYAML(id, credentials, first.Hostname, first.Port)
+	}
+	for _, o := range m.origins {
+		content = RewriteIngress(content, o)
+	}
+
+	if err := config.EnsureConfigDir(); err != nil {
+		return "", false, err
+	}
+	path = managedConfigPath()
+	old, _ := os.ReadFile(path)
+	if string(old) == content {
+		return path, false, nil
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		return "", false, fmt.Errorf("write %s: %w", path, err)
+	}
+	return path, true, nil
+}
+
+// RouteDNS 为 hostname 创建指向 tunnel 的 DNS 记录 (cloudflared tunnel route dns)，
+// 记录已经指向该 tunnel 时 cloudflared 不做修改
+func RouteDNS(tunnelName, hostname string) error {
+	if out, err := exec.Command("cloudflared", "tunnel", "route", "dns", tunnelName, hostname).CombinedOutput(); err != nil {
+		return fmt.Errorf("cloudflared tunnel route dns: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// RewriteIngress 把 cloudflared 配置中 origin 对应的 ingress 规则 (主机名相同、path 与挂载路径相符)
+// 的 service 改为 http://localhost:<port>。没有对应的规则时在兜底规则之前插入一条，
+// 没有 ingress 时改写旧式的顶层 url，或者添加 ingress。其他内容 (包括注释) 原样保留。
+func RewriteIngress(content string, o Origin) string {
+	service := fmt.Sprintf("http://localhost:%d", o.Port)
+	lines := strings.Split(content, "\n")
+
+	start := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "ingress:" && indentOf(line) == 0 {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		for i, line := range lines {
+			if indentOf(line) == 0 && strings.HasPrefix(line, "url:") {
+				lines[i] = "url: " + service
+				return strings.Join(lines, "\n")
+			}
+		}
+		rules := fmt.Sprintf("ingress:\n  - hostname: %s\n", o.Hostname)
+		if o.Path != "" {
+			rules += fmt.Sprintf("    path: ^%s(/.*)?$\n", o.Path)
+		}
+		rules += "    service: " + service + "\n  - service: http_status:404\n"
+		return strings.TrimRight(content, "\n") + "\n\n" + rules
+	}
+
+	// ingress 块到下一个顶层键为止，每条规则以 "- " 开始
+	end := len(lines)
+	var items []int
+	for i := start + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if indentOf(lines[i]) == 0 && !strings.HasPrefix(trimmed, "-") {
+			end = i
+			break
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if len(items) == 0 || indentOf(lines[i]) == indentOf(lines[items[0]]) {
+				items = append(items, i)
+			}
+		}
+	}
+	if len(items) == 0 {
+		return content
+	}
+
+	catchAll := -1
+	for n, first := range items {
+		last := end
+		if n+1 < len(items) {
+			last = items[n+1]
+		}
+		rule := parseRule(lines[first:last])
+		if rule.hostname == "" {
+			catchAll = first
+			continue
+		}
+		if rule.hostname == o.Hostname && pathMatches(rule.path, o.Path) {
+			if rule.service >= 0 {
+				line := lines[first+rule.service]
+				key := strings.Index(line, "service:")
+				lines[first+rule.service] = line[:key] + "service: " + service
+				return strings.Join(lines, "\n")
+			}
+			// 没有 service 的规则无效，在其后补上
+			indent := strings.Repeat(" ", indentOf(lines[first])+2)
+			lines = insertLines(lines, first+1, indent+"service: "+service)
+			return strings.Join(lines, "\n")
+		}
+	}
+
+	// 新规则放在兜底规则之前，没有兜底规则时放在 ingress 末尾
+	at := catchAll
+	if at < 0 {
+		at = end
+		for at > start+1 && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+	}
+	dash := strings.Repeat(" ", indentOf(lines[items[0]]))
+	rule := []string{dash + "- hostname: " + o.Hostname}
+	if o.Path != "" {
+		rule = append(rule, dash+"  path: ^"+o.Path+"(/.*)?$")
+	}
+	rule = append(rule, dash+"  service: "+service)
+	lines = insertLines(lines, at, rule...)
+	return strings.Join(lines, "\n")
+}
+
+// ingressRule 是 ingress 中的一条规则，service 为 service 行相对规则首行的偏移 (没有时为 -1)
+type ingressRule struct {
+	hostname string
+	path     string
+	service  int
+}
+
+// parseRule 解析规则的顶层键，originRequest 等更深层的键忽略
+func parseRule(lines []string) ingressRule {
+	rule := ingressRule{service: -1}
+	column := -1
+	for i, line := range lines {
+		text := line
+		if i == 0 {
+			// "- hostname: x" 中的键从 "- " 之后开始
+			dash := strings.Index(text, "-")
+			text = strings.Repeat(" ", dash+1) + text[dash+1:]
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if column < 0 {
+			column = indentOf(text)
+		}
+		if indentOf(text) != column {
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		value = unquote(value)
+		switch key {
+		case "hostname":
+			rule.hostname = value
+		case "path":
+			rule.path = value
+		case "service":
+			rule.service = i
+		}
+	}
+	return rule
+}
+
+// pathMatches 判断规则的 path 是否对应挂载路径: 默认分享对应没有 path 的规则
+func pathMatches(rulePath, mount string) bool {
+	if mount == "" {
+		return rulePath == ""
+	}
+	return rulePath != "" && strings.Contains(rulePath, mount)
+}
+
+// unquote 去掉值两端的空白、引号和行尾注释
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if i := strings.Index(value, " #"); i >= 0 && !strings.HasPrefix(value, `"`) && !strings.HasPrefix(value, "'") {
+		value = strings.TrimSpace(value[:i])
+	}
+	return strings.Trim(value, `"'`)
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func insertLines(lines []string, at int, insert ...string) []string {
+	out := make([]string, 0, len(lines)+len(insert))
+	out = append(out, lines[:at]...)
+	out = append(out, insert...)
+	return append(out, lines[at:]...)
+}
+
+// CloudflaredConfig 是 cloudflared 配置文件中 cfshare 关心的部分
+type CloudflaredConfig struct {
+	Tunnel          string
+	CredentialsFile string
+	URL             string // 旧式的单一服务 (顶层 url)
+	Ingress         []IngressRule
+}
+
+// IngressRule 是一条 ingress 规则，没有 Hostname 的是兜底规则
+type IngressRule struct {
+	Hostname string
+	Path     string
+	Service  string
+}
+
+// LoadConfig 读取并解析 cloudflared 的配置文件
+func LoadConfig(path string) (*CloudflaredConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig 解析 cloudflared 的配置
+func ParseConfig(data string) (*CloudflaredConfig, error) {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	top, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping at the top level")
+	}
+	cfg := &CloudflaredConfig{
+		Tunnel:          yamlString(top["tunnel"]),
+		CredentialsFile: yamlString(top["credentials-file"]),
+		URL:             yamlString(top["url"]),
+	}
+	switch ingress := top["ingress"].(type) {
+	case nil, string:
+	case []any:
+		for i, item := range ingress {
+			rule, ok := item.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("ingress rule %d is not a mapping", i+1)
+			}
+			cfg.Ingress = append(cfg.Ingress, IngressRule{
+				Hostname: yamlString(rule["hostname"]),
+				Path:     yamlString(rule["path"]),
+				Service:  yamlString(rule["service"]),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("ingress is not a list")
+	}
+	return cfg, nil
+}
+
+func yamlString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// Hostnames 返回 ingress 中出现的主机名 (不含通配符)，按出现顺序去重
+func (c *CloudflaredConfig) Hostnames() []string {
+	var hosts []string
+	seen := map[string]bool{}
+	for _, rule := range c.Ingress {
+		if rule.Hostname == "" || strings.Contains(rule.Hostname, "*") || seen[rule.Hostname] {
+			continue
+		}
+		seen[rule.Hostname] = true
+		hosts = append(hosts, rule.Hostname)
+	}
+	return hosts
+}
+
+// PublicHostname 选出分享使用的主机名: 优先选择 service 指向本机 port、不带 path 的规则；
+// 没有这样的规则时配置中只有一个主机名就用它。有多个候选时返回错误并列出它们，
+// 由用户用 --url 选择。配置中没有主机名时返回空字符串。
+func (c *CloudflaredConfig) PublicHostname(port int) (string, error) {
+	var matches []string
+	for _, rule := range c.Ingress {
+		if rule.Hostname != "" && !strings.Contains(rule.Hostname, "*") && rule.Path == "" && servicePort(rule.Service) == port && !slices.Contains(matches, rule.Hostname) {
+			matches = append(matches, rule.Hostname)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	candidates := matches
+	if len(candidates) == 0 {
+		candidates = c.Hostnames()
+	}
+	switch len(candidates) {
+	case 0:
+		return "", nil
+	case 1:
+		return candidates[0], nil
+	}
+	return "", fmt.Errorf("cloudflared 配置中有多个主机名 (%s)，请用 --url 指定分享使用哪一个，如 --url https://%s",
+		strings.Join(candidates, ", "), candidates[0])
+}
+
+// servicePort 返回指向本机的 service (http://localhost:8787 等) 的端口，其他 service 返回 0
+func servicePort(service string) int {
+	u, err := url.Parse(service)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1", "0.0.0.0":
+	default:
+		return 0
+	}
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
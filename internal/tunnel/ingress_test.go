@@ -0,0 +1,97 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRewriteIngress(t *testing.T) {
+	config := `tunnel: cfshare
+credentials-file: /home/u/.cloudflared/abc.json
+
+ingress:
+  # 文档
+  - hostname: share.example.com
+    path: ^/docs(/.*)?$
+    service: http://localhost:9001
+  - hostname: "share.example.com"
+    service: http://localhost:8080  # 旧端口
+    originRequest:
+      service: keep-me
+  - hostname: other.example.com
+    service: http://localhost:3000
+  - service: http_status:404
+`
+	got := RewriteIngress(config, Origin{Hostname: "share.example.com", Port: 8787})
+	want := strings.Replace(config, "    service: http://localhost:8080  # 旧端口", "    service: http://localhost:8787", 1)
+	if got != want {
+		t.Errorf("default share:\n%s", got)
+	}
+
+	got = RewriteIngress(config, Origin{Hostname: "share.example.com", Path: "/docs", Port: 9100})
+	if !strings.Contains(got, "    service: http://localhost:9100\n  - hostname: \"share.example.com\"") || !strings.Contains(got, "localhost:8080") {
+		t.Errorf("mounted share should only rewrite its path rule:\n%s", got)
+	}
+
+	// 没有对应的规则时插入到兜底规则之前
+	got = RewriteIngress(config, Origin{Hostname: "new.example.com", Port: 8787})
+	if !strings.Contains(got, "  - hostname: new.example.com\n    service: http://localhost:8787\n  - service: http_status:404\n") {
+		t.Errorf("new rule not inserted before the catch-all:\n%s", got)
+	}
+
+	// 没有 ingress 时添加
+	got = RewriteIngress("tunnel: cfshare\ncredentials-file: /x.json\n", Origin{Hostname: "share.example.com", Port: 8787})
+	if !strings.Contains(got, "ingress:\n  - hostname: share.example.com\n    service: http://localhost:8787\n  - service: http_status:404\n") {
+		t.Errorf("ingress not added:\n%s", got)
+	}
+	// 旧式的顶层 url
+	got = RewriteIngress("tunnel: cfshare\nurl: http://localhost:8080\n", Origin{Hostname: "share.example.com", Port: 8787})
+	if got != "tunnel: cfshare\nurl: http://localhost:8787\n" {
+		t.Errorf("legacy url not rewritten:\n%s", got)
+	}
+}
+
+func TestManagedConfigOrigins(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, ".cloudflared"), 0700)
+	os.WriteFile(filepath.Join(home, ".cloudflared", "config.yml"), []byte(ConfigYAML("abc", "/creds.json", "share.example.com", 8787)), 0600)
+
+	m := NewManager("cfshare")
+	m.SetOrigins([]Origin{
+		{Hostname: "share.example.com", Port: 8787},
+		{Hostname: "docs.example.com", Port: 8788},
+		{Hostname: "photos.example.com", Port: 8789},
+		{Hostname: "", Port: 8790}, // 没有主机名的分享不生成规则
+	})
+	path, changed, err := m.writeManagedConfig()
+	if err != nil || !changed {
+		t.Fatalf("writeManagedConfig = %q, %v, %v", path, changed, err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []IngressRule{
+		{Hostname: "share.example.com", Service: "http://localhost:8787"},
+		{Hostname: "docs.example.com", Service: "http://localhost:8788"},
+		{Hostname: "photos.example.com", Service: "http://localhost:8789"},
+		{Service: "http_status:404"},
+	}
+	if !reflect.DeepEqual(cfg.Ingress, want) {
+		t.Errorf("ingress = %+v", cfg.Ingress)
+	}
+	for port, host := range map[int]string{8788: "docs.example.com", 8789: "photos.example.com"} {
+		if got, err := cfg.PublicHostname(port); err != nil || got != host {
+			t.Errorf("PublicHostname(%d) = %q, %v", port, got, err)
+		}
+	}
+
+	// 内容不变时不需要重启 cloudflared
+	if _, changed, err := m.writeManagedConfig(); err != nil || changed {
+		t.Errorf("second write changed = %v, %v", changed, err)
+	}
+}
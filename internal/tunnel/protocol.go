@@ -0,0 +1,162 @@
+package tunnel
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cfshare/internal/config"
+)
+
+// cloudflared 连接边缘使用的传输协议 (--protocol)，auto 时自动选择
+const (
+	ProtocolAuto  = "auto"
+	ProtocolHTTP2 = "http2"
+	ProtocolQUIC  = "quic"
+)
+
+// ParseProtocol 校验 --protocol 的取值，空字符串视为 auto
+func ParseProtocol(s string) (string, error) {
+	switch s {
+	case "", ProtocolAuto:
+		return ProtocolAuto, nil
+	case ProtocolHTTP2, ProtocolQUIC:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown protocol %q (expected auto, quic or http2)", s)
+}
+
+// SetProtocol 指定 cloudflared 使用的协议 (ProtocolAuto、ProtocolQUIC 或 ProtocolHTTP2)，默认为 auto
+func (m *Manager) SetProtocol(protocol string) {
+	m.protocol = protocol
+}
+
+// registeredMarker 出现在 cloudflared 日志中表示已有连接注册到边缘
+const registeredMarker = "Registered tunnel connection"
+
+// protocolOrder 返回依次尝试的协议。指定了 quic 或 http2 时只用该协议；auto 时本网络记住的协议优先，
+// 否则先用 QUIC，在 TunnelConnectTimeout 内连不上边缘再换 http2 (QUIC 依赖的 UDP 7844 在很多网络中被阻止)
+func protocolOrder(mode, remembered string) []string {
+	switch {
+	case mode == ProtocolHTTP2 || mode == ProtocolQUIC:
+		return []string{mode}
+	case remembered == ProtocolHTTP2:
+		return []string{ProtocolHTTP2, ProtocolQUIC}
+	}
+	return []string{ProtocolQUIC, ProtocolHTTP2}
+}
+
+// waitRegistered 从 offset 开始跟踪日志，直到出现连接注册成功的记录。
+// exited 关闭 (进程退出) 或超时返回 false。
+func waitRegistered(logPath string, offset int64, exited <-chan struct{}, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if logContains(logPath, offset, registeredMarker) {
+			return true
+		}
+		select {
+		case <-exited:
+			// 退出前写入的日志可能刚好包含注册记录
+			return logContains(logPath, offset, registeredMarker)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+func logContains(logPath string, offset int64, marker string) bool {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.Contains(scanner.Text(), marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkID 标识当前所在的网络: 访问公网时使用的网卡及其网段，如 "en0 192.168.1.0/24"。
+// 无法判断时返回空字符串，此时不记住协议。
+func networkID() string {
+	// UDP 的 Dial 不发送数据，只用来让系统选出默认路由的本地地址
+	conn, err := net.Dial("udp", "1.1.1.1:53")
+	if err != nil {
+		return ""
+	}
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(local) {
+				network := &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask), Mask: ipnet.Mask}
+				return iface.Name + " " + network.String()
+			}
+		}
+	}
+	return local.String()
+}
+
+func protocolsPath() string {
+	return filepath.Join(config.GetConfigDir(), "protocols.json")
+}
+
+// rememberedProtocol 返回在该网络中上次成功连接的协议
+func rememberedProtocol(network string) string {
+	if network == "" {
+		return ""
+	}
+	return loadProtocols()[network]
+}
+
+// rememberProtocol 记住在该网络中可用的协议，下次启动时优先使用
+func rememberProtocol(network, protocol string) error {
+	if network == "" {
+		return nil
+	}
+	protocols := loadProtocols()
+	if protocols[network] == protocol {
+		return nil
+	}
+	protocols[network] = protocol
+	data, err := json.MarshalIndent(protocols, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := config.EnsureConfigDir(); err != nil {
+		return err
+	}
+	return os.WriteFile(protocolsPath(), data, 0600)
+}
+
+func loadProtocols() map[string]string {
+	protocols := map[string]string{}
+	if data, err := os.ReadFile(protocolsPath()); err == nil {
+		json.Unmarshal(data, &protocols)
+	}
+	return protocols
+}
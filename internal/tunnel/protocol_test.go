@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProtocolMemory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, ".cfshare"), 0700)
+
+	if got := protocolOrder(ProtocolAuto, rememberedProtocol("en0 192.168.1.0/24")); got[0] != ProtocolQUIC || got[1] != ProtocolHTTP2 {
+		t.Errorf("default order = %v", got)
+	}
+	if err := rememberProtocol("en0 192.168.1.0/24", ProtocolHTTP2); err != nil {
+		t.Fatal(err)
+	}
+	if got := protocolOrder(ProtocolAuto, rememberedProtocol("en0 192.168.1.0/24")); got[0] != ProtocolHTTP2 || got[1] != ProtocolQUIC {
+		t.Errorf("remembered order = %v", got)
+	}
+	// 指定了协议时不回退，也不受记住的协议影响
+	if got := protocolOrder(ProtocolQUIC, ProtocolHTTP2); len(got) != 1 || got[0] != ProtocolQUIC {
+		t.Errorf("fixed order = %v", got)
+	}
+	if got := rememberedProtocol("en0 10.0.0.0/8"); got != "" {
+		t.Errorf("other network remembered %q", got)
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	for in, want := range map[string]string{"": ProtocolAuto, "auto": ProtocolAuto, "quic": ProtocolQUIC, "http2": ProtocolHTTP2} {
+		if got, err := ParseProtocol(in); err != nil || got != want {
+			t.Errorf("ParseProtocol(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseProtocol("h2mux"); err == nil {
+		t.Error("h2mux accepted")
+	}
+}
+
+func TestWaitRegistered(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tunnel.log")
+	// offset 之前的旧注册记录不算
+	old := "INF Registered tunnel connection connIndex=0 protocol=quic\n"
+	os.WriteFile(logPath, []byte(old), 0600)
+	offset := int64(len(old))
+
+	exited := make(chan struct{})
+	if waitRegistered(logPath, offset, exited, 300*time.Millisecond) {
+		t.Fatal("stale registration counted")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f, _ := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+		f.WriteString("INF Registered tunnel connection connIndex=0 protocol=http2\n")
+		f.Close()
+	}()
+	if !waitRegistered(logPath, offset, exited, 5*time.Second) {
+		t.Fatal("registration not detected")
+	}
+
+	close(exited)
+	os.WriteFile(logPath, []byte(old+"ERR failed to dial to edge\n"), 0600)
+	if waitRegistered(logPath, offset, exited, 5*time.Second) {
+		t.Fatal("exited process reported as registered")
+	}
+}
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Provision 一次完成命名 tunnel 的配置 (cfshare setup --create): 没有登录过时运行 cloudflared tunnel login，
+// 创建 tunnel (已存在时直接使用)，写入 ~/.cloudflared/config.yml (ingress 把 hostname 指向本机 port)，
+// 并为 hostname 创建 DNS 记录。已有的 config.yml 内容不同时先备份为 config.yml.bak。
+// 每一步的进度写入 progress。
+func Provision(tunnelName, hostname string, port int, progress io.Writer) error {
+	if _, err := exec.LookPath("cloudflared"); err != nil {
+		// CheckSetup 给出安装 cloudflared 的说明
+		return CheckSetup(tunnelName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(home, ".cloudflared")
+
+	if _, err := os.Stat(filepath.Join(dir, "cert.pem")); err != nil && os.Getenv("TUNNEL_ORIGIN_CERT") == "" {
+		fmt.Fprintln(progress, "→ 登录 Cloudflare (将在浏览器中打开授权页面)...")
+		login := exec.Command("cloudflared", "tunnel", "login")
+		login.Stdin, login.Stdout, login.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := login.Run(); err != nil {
+			return fmt.Errorf("cloudflared tunnel login 失败: %w", err)
+		}
+	}
+
+	id, err := tunnelID(tunnelName)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		fmt.Fprintf(progress, "→ 创建 tunnel %s...\n", tunnelName)
+		if out, err := exec.Command("cloudflared", "tunnel", "create", tunnelName).CombinedOutput(); err != nil {
+			return fmt.Errorf("cloudflared tunnel create 失败: %v\n%s", err, bytes.TrimSpace(out))
+		}
+		if id, err = tunnelID(tunnelName); err != nil {
+			return err
+		}
+		if id == "" {
+			return fmt.Errorf("tunnel '%s' 创建后仍无法在 cloudflared tunnel list 中找到", tunnelName)
+		}
+	} else {
+		fmt.Fprintf(progress, "→ 使用已有的 tunnel %s (%s)\n", tunnelName, id)
+	}
+
+	credentials := filepath.Join(dir, id+".json")
+	if _, err := os.Stat(credentials); err != nil {
+		return fmt.Errorf("找不到 tunnel '%s' 的凭据文件 %s\n\n这个 tunnel 可能是在其他机器上创建的，请复制凭据文件，或用 --tunnel 指定新的 tunnel 名称", tunnelName, credentials)
+	}
+
+	configPath := filepath.Join(dir, "config.yml")
+	content := ConfigYAML(id, credentials, hostname, port)
+	if old, err := os.ReadFile(configPath); err == nil && string(old) != content {
+		if err := os.WriteFile(configPath+".bak", old, 0600); err != nil {
+			return fmt.Errorf("backup config.yml: %w", err)
+		}
+		fmt.Fprintf(progress, "→ 原有的 %s 已备份为 config.yml.bak\n", configPath)
+	}
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("write config.yml: %w", err)
+	}
+	fmt.Fprintf(progress, "→ 已写入 %s (%s → localhost:%d)\n", configPath, hostname, port)
+
+	fmt.Fprintf(progress, "→ 创建 DNS 记录 %s...\n", hostname)
+	if out, err := exec.Command("cloudflared", "tunnel", "route", "dns", tunnelName, hostname).CombinedOutput(); err != nil {
+		return fmt.Errorf("cloudflared tunnel route dns 失败: %v\n%s\n\n如果 %s 已有其他 DNS 记录，请先在 Cloudflare 控制台删除", err, bytes.TrimSpace(out), hostname)
+	}
+	return nil
+}
+
+// ConfigYAML 生成 cloudflared 的 config.yml: hostname 转发到本机 port，其他主机名返回 404
+func ConfigYAML(id, credentials, hostname string, port int) string {
+	return fmt.Sprintf(`# 由 cfshare setup --create 生成
+tunnel: %s
+credentials-file: %s
+
+ingress:
+  - hostname: %s
+    service: http://localhost:%d
+  - service: http_status:404
+`, id, credentials, hostname, port)
+}
+
+// NormalizeHostname 校验 setup --create 的主机名，允许带 https:// 前缀和结尾的 /
+func NormalizeHostname(s string) (string, error) {
+	host := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "https://"), "http://"), "/")
+	host = strings.ToLower(host)
+	if host == "" || !strings.Contains(host, ".") || strings.ContainsAny(host, "/:@ *") {
+		return "", fmt.Errorf("invalid hostname %q (expected something like share.example.com)", s)
+	}
+	return host, nil
+}
+
+// tunnelID 通过 cloudflared tunnel list 查找 tunnel 的 ID，不存在时返回空字符串
+func tunnelID(tunnelName string) (string, error) {
+	out, err := exec.Command("cloudflared", "tunnel", "list", "--output", "json").Output()
+	if err != nil {
+		return "", fmt.Errorf("无法获取 tunnel 列表: %w", err)
+	}
+	return parseTunnelList(out, tunnelName)
+}
+
+func parseTunnelList(data []byte, tunnelName string) (string, error) {
+	var tunnels []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &tunnels); err != nil {
+		return "", fmt.Errorf("parse tunnel list: %w", err)
+	}
+	for _, t := range tunnels {
+		if t.Name == tunnelName {
+			return t.ID, nil
+		}
+	}
+	return "", nil
+}
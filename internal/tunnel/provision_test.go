@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	content := ConfigYAML("6ff42ae2-765d-4adf-8112-31c55c1551ef", "/home/u/.cloudflared/6ff42ae2-765d-4adf-8112-31c55c1551ef.json", "share.example.com", 8787)
+	os.WriteFile(path, []byte(content), 0600)
+
+	// cfshare 从生成的配置中读取公开地址
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, err := cfg.PublicHostname(8787); err != nil || host != "share.example.com" {
+		t.Errorf("hostname from generated config = %q, %v", host, err)
+	}
+	for _, want := range []string{
+		"tunnel: 6ff42ae2-765d-4adf-8112-31c55c1551ef\n",
+		"    service: http://localhost:8787\n",
+		"  - service: http_status:404\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("config missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestParseTunnelList(t *testing.T) {
+	list := []byte(`[{"id":"1111","name":"home","created_at":"2024-01-01T00:00:00Z","connections":[]},
+{"id":"2222","name":"cfshare","created_at":"2024-02-01T00:00:00Z","connections":[]}]`)
+	if id, err := parseTunnelList(list, "cfshare"); err != nil || id != "2222" {
+		t.Errorf("parseTunnelList(cfshare) = %q, %v", id, err)
+	}
+	if id, err := parseTunnelList(list, "missing"); err != nil || id != "" {
+		t.Errorf("parseTunnelList(missing) = %q, %v", id, err)
+	}
+	if id, err := parseTunnelList([]byte("null"), "cfshare"); err != nil || id != "" {
+		t.Errorf("empty list: %q, %v", id, err)
+	}
+}
+
+func TestNormalizeHostname(t *testing.T) {
+	for in, want := range map[string]string{
+		"share.example.com":          "share.example.com",
+		"https://Share.Example.com/": "share.example.com",
+	} {
+		if got, err := NormalizeHostname(in); err != nil || got != want {
+			t.Errorf("NormalizeHostname(%q) = %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "localhost", "share.example.com:8443", "share.example.com/docs", "*.example.com"} {
+		if _, err := NormalizeHostname(in); err == nil {
+			t.Errorf("NormalizeHostname(%q) should fail", in)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"cfshare/internal/config"
+)
+
+// quickURLPattern 匹配 cloudflared 为 Quick Tunnel 分配的地址
+var quickURLPattern = regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`)
+
+// NewQuickManager 创建 Quick Tunnel (trycloudflare.com) 的管理器: cloudflared tunnel --url 把 localURL
+// 暴露到随机分配的 https://*.trycloudflare.com 地址，不需要命名 tunnel、凭据或 DNS 记录，
+// 适合临时的一次性分享。地址在 cloudflared 每次启动时都会改变。
+func NewQuickManager(localURL string) *Manager {
+	return &Manager{localURL: localURL}
+}
+
+// StartQuick 启动 Quick Tunnel，返回 cloudflared 的 PID 和分配的公开地址
+func (m *Manager) StartQuick() (int, string, error) {
+	if m.localURL == "" {
+		return 0, "", fmt.Errorf("not a quick tunnel")
+	}
+	// 已经在运行的 cloudflared 可能是命名 tunnel，地址也无从得知
+	if m.IsRunning() {
+		return 0, "", fmt.Errorf("cloudflared is already running (PID %d), stop it first", m.GetRunningPID())
+	}
+	pid, err := m.Start()
+	if err != nil {
+		return 0, "", err
+	}
+	url, err := QuickURL(config.GetTunnelLogPath())
+	if err != nil {
+		m.Stop()
+		return 0, "", err
+	}
+	return pid, url, nil
+}
+
+// QuickURL 从 tunnel 日志中读取 cloudflared 最近一次分配的 Quick Tunnel 地址
+func QuickURL(logPath string) (string, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	url := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if match := quickURLPattern.FindString(scanner.Text()); match != "" {
+			url = match
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if url == "" {
+		return "", fmt.Errorf("no trycloudflare.com URL in %s", logPath)
+	}
+	return url, nil
+}
+
+// quickConfigPath 返回 Quick Tunnel 使用的空配置文件。~/.cloudflared/config.yml 中配置了命名 tunnel 时
+// cloudflared 会拒绝启动 Quick Tunnel，指定一个空配置文件避开它。用令牌运行的 tunnel 也使用它，
+// 避免本地配置中的 ingress 等设置影响控制台管理的 tunnel。
+func quickConfigPath() (string, error) {
+	path := filepath.Join(config.GetConfigDir(), "quick-tunnel.yml")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := config.EnsureConfigDir(); err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, []byte("# cfshare --quick: intentionally empty\n"), 0600)
+}
@@ -0,0 +1,34 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuickURL(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tunnel.log")
+	log := `2024-05-01T10:00:00Z INF Requesting new quick Tunnel on trycloudflare.com...
+2024-05-01T10:00:01Z INF +--------------------------------------------------------------------------------------------+
+2024-05-01T10:00:01Z INF |  Your quick Tunnel has been created! Visit it at (it may take some time to be reachable):  |
+2024-05-01T10:00:01Z INF |  https://old-words-here.trycloudflare.com                                                  |
+2024-05-01T10:00:03Z INF Registered tunnel connection connIndex=0 location=nrt01 protocol=http2
+2024-05-01T11:00:01Z INF |  https://calm-river-stone-lamp.trycloudflare.com                                           |
+2024-05-01T11:00:03Z INF Registered tunnel connection connIndex=0 location=nrt01 protocol=http2
+`
+	os.WriteFile(logPath, []byte(log), 0600)
+
+	url, err := QuickURL(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 日志是追加写入的，应取最近一次启动分配的地址
+	if url != "https://calm-river-stone-lamp.trycloudflare.com" {
+		t.Errorf("QuickURL = %q", url)
+	}
+
+	os.WriteFile(logPath, []byte("2024-05-01T10:00:00Z ERR failed to request quick Tunnel\n"), 0600)
+	if _, err := QuickURL(logPath); err == nil {
+		t.Error("expected an error when no URL was assigned")
+	}
+}
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cfshare/internal/config"
+)
+
+// 在 Zero Trust 控制台创建的 tunnel (remotely-managed) 只给出 connector 令牌: cloudflared tunnel run --token
+// 不需要 cert.pem、凭据文件或 config.yml，ingress 规则 (公共主机名) 保存在 Cloudflare 上。
+
+// apiBaseURL 是 Cloudflare API 的地址，测试时替换
+var apiBaseURL = "https://api.cloudflare.com/client/v4"
+
+// Token 是 connector 令牌的内容 (base64 编码的 JSON)
+type Token struct {
+	AccountTag string `json:"a"`
+	TunnelID   string `json:"t"`
+	Secret     string `json:"s"`
+}
+
+// ParseToken 解析 --tunnel-token 的 connector 令牌
+func ParseToken(s string) (*Token, error) {
+	s = strings.TrimSpace(s)
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("tunnel token is not base64")
+		}
+	}
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("tunnel token is not a cloudflared connector token")
+	}
+	if t.AccountTag == "" || t.TunnelID == "" || t.Secret == "" {
+		return nil, fmt.Errorf("tunnel token is missing the account, tunnel ID or secret")
+	}
+	return &t, nil
+}
+
+// SetToken 让 Manager 用 connector 令牌运行控制台管理的 tunnel，ingress 由控制台配置，不再生成本地配置
+func (m *Manager) SetToken(token string) {
+	m.token = strings.TrimSpace(token)
+}
+
+// RemoteConfig 通过 Cloudflare API 读取控制台中为 tunnel 配置的 ingress 规则，apiToken 为空时返回错误
+func RemoteConfig(token, apiToken string) (*CloudflaredConfig, error) {
+	t, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if apiToken == "" {
+		return nil, fmt.Errorf("需要 Cloudflare API 令牌才能查询 tunnel 的公共主机名，请设置 %s (权限: Account → Cloudflare Tunnel → Read)", config.CloudflareAPITokenEnv)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/accounts/%s/cfd_tunnel/%s/configurations", apiBaseURL, t.AccountTag, t.TunnelID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiToken)
+	resp, err := (&http.Client{Timeout: infoTimeout}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query tunnel configuration: %w", err)
+	}
+	defer resp.Body.Close()
+	return parseRemoteConfig(resp)
+}
+
+func parseRemoteConfig(resp *http.Response) (*CloudflaredConfig, error) {
+	var body struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result struct {
+			TunnelID string `json:"tunnel_id"`
+			Config   struct {
+				Ingress []struct {
+					Hostname string `json:"hostname"`
+					Path     string `json:"path"`
+					Service  string `json:"service"`
+				} `json:"ingress"`
+			} `json:"config"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("parse tunnel configuration (%s): %w", resp.Status, err)
+	}
+	if !body.Success {
+		var messages []string
+		for _, e := range body.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		return nil, fmt.Errorf("query tunnel configuration: %s: %s", resp.Status, strings.Join(messages, "; "))
+	}
+
+	cfg := &CloudflaredConfig{Tunnel: body.Result.TunnelID}
+	for _, rule := range body.Result.Config.Ingress {
+		cfg.Ingress = append(cfg.Ingress, IngressRule{Hostname: rule.Hostname, Path: rule.Path, Service: rule.Service})
+	}
+	return cfg, nil
+}
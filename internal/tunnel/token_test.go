@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseToken(t *testing.T) {
+	raw := base64.StdEncoding.EncodeToString([]byte(`{"a":"acct","t":"3f1c0b7e-tunnel","s":"c2VjcmV0"}`))
+	tok, err := ParseToken(" " + raw + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccountTag != "acct" || tok.TunnelID != "3f1c0b7e-tunnel" || tok.Secret != "c2VjcmV0" {
+		t.Errorf("token = %+v", tok)
+	}
+
+	for _, bad := range []string{
+		"not base64!",
+		base64.StdEncoding.EncodeToString([]byte("plain text")),
+		base64.StdEncoding.EncodeToString([]byte(`{"a":"acct","t":"tunnel"}`)),
+	} {
+		if _, err := ParseToken(bad); err == nil {
+			t.Errorf("ParseToken(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRemoteConfig(t *testing.T) {
+	token := base64.StdEncoding.EncodeToString([]byte(`{"a":"acct","t":"tid","s":"secret"}`))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/accounts/acct/cfd_tunnel/tid/configurations" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer api-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`))
+			return
+		}
+		w.Write([]byte(`{"success":true,"result":{"tunnel_id":"tid","config":{"ingress":[
+			{"hostname":"wiki.example.com","service":"http://localhost:3000"},
+			{"hostname":"share.example.com","service":"http://localhost:8787"},
+			{"service":"http_status:404"}]}}}`))
+	}))
+	defer srv.Close()
+	old := apiBaseURL
+	apiBaseURL = srv.URL
+	defer func() { apiBaseURL = old }()
+
+	cfg, err := RemoteConfig(token, "api-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, err := cfg.PublicHostname(8787); err != nil || host != "share.example.com" {
+		t.Errorf("PublicHostname = %q, %v", host, err)
+	}
+
+	if _, err := RemoteConfig(token, "wrong"); err == nil {
+		t.Error("API error not reported")
+	}
+	if _, err := RemoteConfig(token, ""); err == nil {
+		t.Error("missing API token accepted")
+	}
+}
@@ -1,7 +1,6 @@
 package tunnel
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"os/exec"
@@ -14,7 +13,11 @@ import (
 
 type Manager struct {
 	tunnelName string
-	configPath string
+	configPath string   // 传给 cloudflared --config 的配置文件，为空时使用 cloudflared 的默认配置
+	localURL   string   // Quick Tunnel 暴露的本地地址 (NewQuickManager)，为空时运行命名 tunnel
+	origins    []Origin // 各分享的本机服务 (SetOrigins)，据此生成 cfshare 自己的配置
+	protocol   string   // 连接边缘的协议 (SetProtocol)，为空时同 auto
+	token      string   // 控制台管理的 tunnel 的 connector 令牌 (SetToken)，ingress 由控制台配置
 }
 
 func NewManager(tunnelName string) *Manager {
@@ -29,42 +32,123 @@ func (m *Manager) Start() (int, error) {
 		return 0, fmt.Errorf("cloudflared not found in PATH: %w\n请先安装 cloudflared: https://developers.cloudflare.com/cloudflare-one/connections/connect-networks/downloads/", err)
 	}
 
-	if pid := m.GetRunningPID(); pid > 0 {
-		return pid, nil
+	running := m.GetRunningPID()
+	if running > 0 && (m.localURL != "" || m.token != "" || len(m.origins) == 0) {
+		return running, nil
 	}
 
-	// 使用 http2 协议，避免 QUIC 在某些网络环境下被阻止
-	cmd := exec.Command(cloudflaredPath, "tunnel", "--protocol", "http2", "run", m.tunnelName)
+	switch {
+	case m.localURL != "" || m.token != "":
+		if m.configPath, err = quickConfigPath(); err != nil {
+			return 0, fmt.Errorf("create quick tunnel config: %w", err)
+		}
+	case len(m.origins) > 0:
+		var changed bool
+		if m.configPath, changed, err = m.writeManagedConfig(); err != nil {
+			return 0, fmt.Errorf("create cloudflared config: %w", err)
+		}
+		if running > 0 {
+			if !changed {
+				return running, nil
+			}
+			// cloudflared 不会重新读取配置，新分享的 ingress 规则要重启后才生效
+			m.Stop()
+		}
+	}
+
+	// auto 时先用本网络上次成功的协议，连不上边缘时换另一种协议重试，
+	// 成功的协议按网络记住，受限网络中不必再手动指定 --protocol
+	auto := m.protocol == "" || m.protocol == ProtocolAuto
+	network := ""
+	if auto {
+		network = networkID()
+	}
+	logPath := config.GetTunnelLogPath()
+	var lastErr error
+	for _, protocol := range protocolOrder(m.protocol, rememberedProtocol(network)) {
+		pid, err := m.startWithProtocol(cloudflaredPath, protocol, logPath)
+		if err == nil {
+			if auto {
+				rememberProtocol(network, protocol)
+			}
+			return pid, nil
+		}
+		lastErr = err
+	}
+	return 0, lastErr
+}
+
+// startWithProtocol 以指定协议启动 cloudflared，等待连接注册到边缘；
+// 失败时结束进程并返回错误
+func (m *Manager) startWithProtocol(cloudflaredPath, protocol, logPath string) (int, error) {
+	args := []string{"tunnel"}
+	if m.configPath != "" {
+		args = append(args, "--config", m.configPath)
+	}
+	metrics := metricsAddr()
+	if metrics != "" {
+		args = append(args, "--metrics", metrics)
+	}
+	args = append(args, "--protocol", protocol)
+	switch {
+	case m.localURL != "":
+		args = append(args, "--no-autoupdate", "--url", m.localURL)
+	case m.token != "":
+		args = append(args, "run")
+	default:
+		args = append(args, "run", m.tunnelName)
+	}
+	cmd := exec.Command(cloudflaredPath, args...)
+	if m.token != "" {
+		// 令牌通过 TUNNEL_TOKEN (即 run --token) 传入，不出现在其他用户可见的命令行中
+		cmd.Env = append(os.Environ(), "TUNNEL_TOKEN="+m.token)
+	}
 
 	setProcAttr(cmd)
 
-	logPath := config.GetConfigDir() + "/tunnel.log"
 	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
 	if err != nil {
 		return 0, fmt.Errorf("create tunnel log file: %w", err)
 	}
+	defer logFile.Close()
+	var offset int64
+	if info, err := logFile.Stat(); err == nil {
+		offset = info.Size()
+	}
 
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
 	if err := cmd.Start(); err != nil {
-		logFile.Close()
 		return 0, fmt.Errorf("start cloudflared: %w", err)
 	}
 
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
 	pid := cmd.Process.Pid
+	if !waitRegistered(logPath, offset, exited, config.TunnelConnectTimeout) {
+		select {
+		case <-exited:
+			return 0, fmt.Errorf("tunnel process (%s) exited before connecting, check %s for details", protocol, logPath)
+		default:
+			signalKill(cmd.Process)
+			<-exited
+			return 0, fmt.Errorf("tunnel (%s) did not connect within %s, check %s for details", protocol, config.TunnelConnectTimeout, logPath)
+		}
+	}
+
 	if err := m.savePID(pid); err != nil {
 		cmd.Process.Kill()
-		logFile.Close()
 		return pid, fmt.Errorf("save tunnel pid: %w", err)
 	}
-
-	time.Sleep(500 * time.Millisecond)
-
-	if !m.isProcessRunning(pid) {
-		return 0, fmt.Errorf("tunnel process died immediately, check %s for details", logPath)
+	os.Remove(config.GetTunnelMetricsPath())
+	if metrics != "" {
+		os.WriteFile(config.GetTunnelMetricsPath(), []byte(metrics), 0600)
 	}
-
 	return pid, nil
 }
 
@@ -148,59 +232,46 @@ func (m *Manager) savePID(pid int) error {
 
 func (m *Manager) removePIDFile() {
 	os.Remove(config.GetTunnelPidFilePath())
+	os.Remove(config.GetTunnelMetricsPath())
 }
 
 func (m *Manager) isProcessRunning(pid int) bool {
 	return isProcessRunning(pid)
 }
 
-func (m *Manager) GetPublicURL() (string, error) {
-	home, _ := os.UserHomeDir()
-	configPaths := []string{
-		home + "/.cloudflared/config.yml",
-		home + "/.cloudflared/config.yaml",
-		"/etc/cloudflared/config.yml",
-		"/etc/cloudflared/config.yaml",
-	}
-
-	for _, configPath := range configPaths {
-		if url := parseHostnameFromConfig(configPath); url != "" {
-			return "https://" + url, nil
+// GetPublicURL 从 cloudflared 的配置中找出分享的公开地址: 优先使用 ingress 中 service 指向本机 port 的主机名
+// (见 CloudflaredConfig.PublicHostname)，配置中没有主机名时查询 cloudflared tunnel info。
+// 使用 connector 令牌时通过 Cloudflare API 读取控制台中配置的公共主机名。
+func (m *Manager) GetPublicURL(port int) (string, error) {
+	if m.token != "" {
+		cfg, err := RemoteConfig(m.token, os.Getenv(config.CloudflareAPITokenEnv))
+		if err != nil {
+			return "", err
 		}
+		host, err := cfg.PublicHostname(port)
+		if err != nil {
+			return "", err
+		}
+		if host == "" {
+			return "", fmt.Errorf("tunnel 在 Zero Trust 控制台中没有配置公共主机名 (Public Hostname)")
+		}
+		return "https://" + host, nil
 	}
-
-	return m.getURLFromTunnelInfo()
-}
-
-func parseHostnameFromConfig(configPath string) string {
-	file, err := os.Open(configPath)
-	if err != nil {
-		return ""
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "hostname:") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1])
-			}
+	if path := UserConfigPath(); path != "" {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			return "", err
 		}
-		if strings.Contains(line, "hostname:") {
-			parts := strings.SplitN(line, "hostname:", 2)
-			if len(parts) == 2 {
-				hostname := strings.TrimSpace(parts[1])
-				hostname = strings.Trim(hostname, "\"'")
-				if hostname != "" && hostname != "*" {
-					return hostname
-				}
-			}
+		host, err := cfg.PublicHostname(port)
+		if err != nil {
+			return "", err
+		}
+		if host != "" {
+			return "https://" + host, nil
 		}
 	}
 
-	return ""
+	return m.getURLFromTunnelInfo()
 }
 
 func (m *Manager) getURLFromTunnelInfo() (string, error) {
@@ -228,10 +299,18 @@ func (m *Manager) getURLFromTunnelInfo() (string, error) {
 	return "", fmt.Errorf("could not determine public URL, please set it in config")
 }
 
-func CheckSetup(tunnelName string) error {
+// CheckInstalled 检查 cloudflared 是否已安装
+func CheckInstalled() error {
 	if _, err := exec.LookPath("cloudflared"); err != nil {
 		return fmt.Errorf("cloudflared 未安装\n\n请先安装:\n  macOS: brew install cloudflared\n  Linux: 参考 https://developers.cloudflare.com/cloudflare-one/connections/connect-networks/downloads/")
 	}
+	return nil
+}
+
+func CheckSetup(tunnelName string) error {
+	if err := CheckInstalled(); err != nil {
+		return err
+	}
 
 	cmd := exec.Command("cloudflared", "tunnel", "list")
 	output, err := cmd.Output()
@@ -0,0 +1,238 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+)
+
+// cloudflared 的配置是 YAML。cfshare 不引入第三方依赖，这里解析其中用到的子集:
+// 块状的映射和列表 (包括 "- key: value" 形式的列表项)、引号字符串、注释以及 | 和 > 多行文本。
+// 映射解析为 map[string]any，列表为 []any，其他值都是 string。
+
+type yamlLine struct {
+	num    int // 行号，从 1 开始
+	indent int
+	text   string
+}
+
+// parseYAML 解析 YAML 文档
+func parseYAML(data string) (any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if strings.Contains(raw, "\t") && strings.TrimLeft(raw, "\t ") != strings.TrimLeft(raw, " ") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		text := stripComment(raw)
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			// 多行文本中的空行要保留
+			lines = append(lines, yamlLine{num: i + 1, indent: -1})
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: indentOf(text), text: trimmed})
+	}
+	p := &yamlParser{lines: lines}
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return map[string]any{}, nil
+	}
+	value, err := p.parseBlock(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return value, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && p.lines[p.pos].indent < 0 {
+		p.pos++
+	}
+}
+
+// parseBlock 解析从当前行开始、缩进为 indent 的映射或列表
+func (p *yamlParser) parseBlock(indent int) (any, error) {
+	if isSeqItem(p.lines[p.pos].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseSeq(indent int) ([]any, error) {
+	var items []any
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) {
+			return items, nil
+		}
+		line := p.lines[p.pos]
+		if line.indent < indent || !isSeqItem(line.text) {
+			return items, nil
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+
+		rest := strings.TrimPrefix(line.text, "-")
+		content := strings.TrimLeft(rest, " ")
+		if content == "" {
+			// 列表项的内容在下面更深缩进的行中
+			p.pos++
+			value, err := p.parseChild(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+		if _, _, ok := splitKey(content); ok || isSeqItem(content) {
+			// "- key: value" 是一个映射，第一个键所在的列就是这个映射的缩进
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + 1 + len(rest) - len(content), text: content}
+			value, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+			continue
+		}
+		p.pos++
+		value, err := p.scalar(content, indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+}
+
+func (p *yamlParser) parseMap(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.lines) {
+			return m, nil
+		}
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && isSeqItem(line.text)) {
+			return m, nil
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.num)
+		}
+		key, value, ok := splitKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", line.num)
+		}
+		p.pos++
+		if value == "" {
+			// 值在下面的行中；列表可以与键对齐
+			child, err := p.parseChild(indent, true)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = child
+			continue
+		}
+		scalar, err := p.scalar(value, indent)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line.num, err)
+		}
+		m[key] = scalar
+	}
+}
+
+// parseChild 解析键或列表项之后的块，没有更深的内容时值为空字符串
+func (p *yamlParser) parseChild(indent int, allowSeqAtSameIndent bool) (any, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return "", nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || (allowSeqAtSameIndent && next.indent == indent && isSeqItem(next.text)) {
+		return p.parseBlock(next.indent)
+	}
+	return "", nil
+}
+
+// scalar 解析标量，| 和 > 开头的多行文本读取下面所有缩进更深的行
+func (p *yamlParser) scalar(value string, indent int) (string, error) {
+	if value == "|" || value == ">" || strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+		var parts []string
+		for p.pos < len(p.lines) && (p.lines[p.pos].indent < 0 || p.lines[p.pos].indent > indent) {
+			parts = append(parts, p.lines[p.pos].text)
+			p.pos++
+		}
+		sep := "\n"
+		if value[0] == '>' {
+			sep = " "
+		}
+		return strings.TrimSpace(strings.Join(parts, sep)), nil
+	}
+	switch {
+	case strings.HasPrefix(value, `"`):
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(value[1 : len(value)-1]), nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
+
+func isSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey 拆分 "key: value"，键可以带引号
+func splitKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key, rest := text[1:end+1], text[end+2:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	if i := strings.Index(text, ": "); i > 0 {
+		return text[:i], strings.TrimSpace(text[i+2:]), true
+	}
+	if strings.HasSuffix(text, ":") && len(text) > 1 {
+		return text[:len(text)-1], "", true
+	}
+	return "", "", false
+}
+
+// stripComment 去掉行中不在引号里的 # 注释 (行首或空白之后的 #)
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == ':' || line[i-1] == '-' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return strings.TrimRight(line[:i], " ")
+		}
+	}
+	return line
+}
@@ -0,0 +1,137 @@
+package tunnel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	doc, err := parseYAML(`# comment
+tunnel: abc # trailing comment
+"quoted key": 'it''s'
+list:
+- a
+- "b # not a comment"
+nested:
+  - name: x
+    opts:
+      k: v
+  -
+    name: y
+note: |
+  line one
+  line two
+empty:
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"tunnel":     "abc",
+		"quoted key": "it's",
+		"list":       []any{"a", "b # not a comment"},
+		"nested": []any{
+			map[string]any{"name": "x", "opts": map[string]any{"k": "v"}},
+			map[string]any{"name": "y"},
+		},
+		"note":  "line one\nline two",
+		"empty": "",
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("parseYAML =\n%#v\nwant\n%#v", doc, want)
+	}
+
+	for _, bad := range []string{"a: 1\n   b: 2\n", "just text\n", "a: \"open\n"} {
+		if _, err := parseYAML(bad); err == nil {
+			t.Errorf("parseYAML(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+const multiHostConfig = `tunnel: 6ff42ae2-765d-4adf-8112-31c55c1551ef
+credentials-file: /home/u/.cloudflared/6ff42ae2.json
+
+ingress:
+  # 家里的其他服务
+  - hostname: "grafana.example.com"
+    service: http://localhost:3000
+    originRequest:
+      noTLSVerify: true
+  - hostname: '*.example.com'
+    service: http://localhost:8080
+  - hostname: share.example.com
+    path: /cfshare
+    service: http://localhost:9999
+  - hostname: files.example.com
+    service: http://127.0.0.1:8787
+  - service: http_status:404
+`
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(multiHostConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tunnel != "6ff42ae2-765d-4adf-8112-31c55c1551ef" || cfg.CredentialsFile != "/home/u/.cloudflared/6ff42ae2.json" {
+		t.Errorf("config = %+v", cfg)
+	}
+	if len(cfg.Ingress) != 5 {
+		t.Fatalf("ingress = %+v", cfg.Ingress)
+	}
+	if r := cfg.Ingress[2]; r.Hostname != "share.example.com" || r.Path != "/cfshare" || r.Service != "http://localhost:9999" {
+		t.Errorf("rule 3 = %+v", r)
+	}
+	if r := cfg.Ingress[4]; r.Hostname != "" || r.Service != "http_status:404" {
+		t.Errorf("catch-all rule = %+v", r)
+	}
+	if hosts := strings.Join(cfg.Hostnames(), ","); hosts != "grafana.example.com,share.example.com,files.example.com" {
+		t.Errorf("Hostnames = %s", hosts)
+	}
+
+	if _, err := ParseConfig("- a\n- b\n"); err == nil {
+		t.Error("top-level list accepted")
+	}
+	if _, err := ParseConfig("ingress:\n  - just-a-string\n"); err == nil {
+		t.Error("non-mapping ingress rule accepted")
+	}
+}
+
+func TestPublicHostname(t *testing.T) {
+	cfg, _ := ParseConfig(multiHostConfig)
+
+	// service 指向分享端口的规则
+	if host, err := cfg.PublicHostname(8787); err != nil || host != "files.example.com" {
+		t.Errorf("PublicHostname(8787) = %q, %v", host, err)
+	}
+	// 没有指向该端口的规则、有多个主机名时要求用户选择
+	host, err := cfg.PublicHostname(5000)
+	if err == nil || !strings.Contains(err.Error(), "grafana.example.com, share.example.com, files.example.com") {
+		t.Errorf("PublicHostname(5000) = %q, %v", host, err)
+	}
+
+	single, _ := ParseConfig("ingress:\n  - hostname: only.example.com\n    service: http://localhost:1234\n  - service: http_status:404\n")
+	if host, err := single.PublicHostname(8787); err != nil || host != "only.example.com" {
+		t.Errorf("single host = %q, %v", host, err)
+	}
+
+	none, _ := ParseConfig("tunnel: abc\nurl: http://localhost:8787\n")
+	if host, err := none.PublicHostname(8787); err != nil || host != "" {
+		t.Errorf("no ingress = %q, %v", host, err)
+	}
+}
+
+func TestServicePort(t *testing.T) {
+	for service, want := range map[string]int{
+		"http://localhost:8787":  8787,
+		"https://127.0.0.1":      443,
+		"http://localhost":       80,
+		"http://192.168.1.5:80":  0,
+		"http_status:404":        0,
+		"unix:/tmp/cfshare.sock": 0,
+	} {
+		if got := servicePort(service); got != want {
+			t.Errorf("servicePort(%q) = %d, want %d", service, got, want)
+		}
+	}
+}
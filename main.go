@@ -1,23 +1,47 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cfshare/internal/auth"
 	"cfshare/internal/config"
+	"cfshare/internal/health"
+	"cfshare/internal/i18n"
+	"cfshare/internal/keychain"
+	"cfshare/internal/mirror"
+	"cfshare/internal/notify"
+	"cfshare/internal/qr"
+	"cfshare/internal/receipt"
 	"cfshare/internal/server"
+	"cfshare/internal/standby"
 	"cfshare/internal/state"
+	"cfshare/internal/storage"
+	"cfshare/internal/telemetry"
 	"cfshare/internal/tunnel"
 )
 
@@ -25,28 +49,124 @@ var (
 	version = "dev"
 	commit  = "none"
 	date    = "unknown"
+
+	// telemetryURL 是匿名使用计数的接收地址，由发布构建通过 -X main.telemetryURL 设置
+	telemetryURL = ""
 )
 
 func main() {
+	// 服务进程等子进程从环境变量继承分享的挂载前缀
+	config.Mount = os.Getenv(config.MountEnv)
+
 	if len(os.Args) >= 2 && os.Args[1] == "__server__" {
 		runServerProcess()
 		return
 	}
+	if len(os.Args) >= 2 && os.Args[1] == "__ended__" {
+		runEndedProcess()
+		return
+	}
+	if len(os.Args) >= 3 && os.Args[1] == "__router__" {
+		runRouterProcess(os.Args[2])
+		return
+	}
 
 	var (
-		publicMode     bool
-		password       string
-		showHelp       bool
+		publicMode      bool
+		password        string
+		showHelp        bool
 		showHelpChinese bool
-		showVersion    bool
-		forceStop      bool
-		tunnelName     string
-		publicURL      string
-		port           int
+		showVersion     bool
+		forceStop       bool
+		tunnelName      string
+		publicURL       string
+		quick           bool
+		protocol        string
+		tunnelToken     string
+		slug            bool
+		mount           string
+		hostname        string
+		router          bool
+		port            int
+		expires         string
+		maxUploads      int
+		receipts        bool
+		watch           bool
+		termsFile       string
+		byUser          bool
+		noStream        bool
+		noKeychain      bool
+		encryptState    bool
+		notifyURL       string
+		honeypot        bool
+		maxAuthFailures int
+		reqRate         int
+		realm           string
+		authMode        string
+		sessionLifetime string
+		contact         string
+		cardFormat      string
+		cardNoPass      bool
+		splitSecret     bool
+		secretVia       string
+		passStyle       string
+		passWords       int
+		theme           string
+		pageTitle       string
+		pageMessage     string
+		pageFooter      string
+		timezone        string
+		confirmSize     string
+		lang            string
+		excludes        stringList
+		allowIPs        stringList
+		blockIPs        stringList
+		allowCountries  stringList
+		blockCountries  stringList
+		showHidden      bool
+		splitSize       string
+		getScript       bool
+		r2Bucket        string
+		allowIndexing   bool
+		statusPage      bool
+		endpoint        string
+		analyze         bool
+		userUpload      bool
+		userItems       string
+		quota           string
+		maxFileSize     string
+		maxRequestSize  string
+		streamName      string
+		maxDuration     string
+		minRate         string
+		limitRate       string
+		inplace         bool
+		maxConns        int
+		maxConnsPerIP   int
+		serveWindow     string
+		waitingRoom     int
+		approve         bool
+		askName         bool
+		effective       bool
+		maxDownloads    string
+		expire          string
+		endedGrace      string
+		burn            burnMode
+		debugAddr       string
+		metricsAddr     string
+		statsBackend    string
+		statsRetention  string
+		statsSince      string
+		digestPeriod    string
+		digestSend      bool
+		setupCreate     string
+		storageQuota    string
 	)
 
 	flag.BoolVar(&publicMode, "public", false, "Public share (no authentication)")
 	flag.StringVar(&password, "pass", "", "Specify password (default: random)")
+	flag.StringVar(&passStyle, "pass-style", auth.PassStyleChars, "Style of random passwords: chars (16 letters and digits) or words (e.g. horse-planet-copper-42)")
+	flag.IntVar(&passWords, "pass-words", config.DefaultPassWords, "Number of words for --pass-style words")
 	flag.BoolVar(&showHelp, "help", false, "Show help")
 	flag.BoolVar(&showHelp, "h", false, "Show help")
 	flag.BoolVar(&showHelpChinese, "hc", false, "Show help in Chinese")
@@ -55,11 +175,101 @@ func main() {
 	flag.BoolVar(&forceStop, "force", false, "Force stop")
 	flag.StringVar(&tunnelName, "tunnel", config.TunnelName, "Cloudflare Tunnel name")
 	flag.StringVar(&publicURL, "url", "", "Public access URL")
+	flag.BoolVar(&quick, "quick", false, "Use a throwaway Quick Tunnel (random https://*.trycloudflare.com URL, no named tunnel or DNS needed)")
+	flag.StringVar(&tunnelToken, "tunnel-token", "", "Connector token of a tunnel created in the Zero Trust dashboard; cloudflared runs it with --token and the public hostname is read from the Cloudflare API ("+config.CloudflareAPITokenEnv+")")
+	flag.StringVar(&protocol, "protocol", tunnel.ProtocolAuto, "cloudflared transport protocol: auto (try QUIC, fall back to http2 if no edge connection), quic or http2")
 	flag.IntVar(&port, "port", config.DefaultPort, "Local listen port")
+	flag.StringVar(&hostname, "hostname", "", "Serve the share on its own hostname of the tunnel, e.g. docs.example.com, so several shares run on different hostnames; other commands then act on that share")
+	flag.BoolVar(&router, "router", false, "Run shares behind one local router on --port that dispatches by hostname and --mount prefix, so cloudflared config never changes")
+	flag.BoolVar(&slug, "slug", false, "Serve the whole share under a random path generated at share time, e.g. https://share.example.com/x9f3kq7m/, so the URL can't be guessed")
+	flag.StringVar(&mount, "mount", "", "Serve the share under this path prefix of the tunnel hostname, e.g. /docs/, so several shares can run at once; other commands then act on that share")
+	flag.StringVar(&expires, "expires", "", "Request link lifetime, e.g. 2d, 12h")
+	flag.BoolVar(&receipts, "receipts", false, "Let recipients confirm receipt with a signed record")
+	flag.BoolVar(&watch, "watch", false, "Watch shared files for content changes, show a version number and notify --notify / the RSS feed")
+	flag.BoolVar(&encryptState, "encrypt-state", false, "Encrypt state and stats files at rest")
+	flag.BoolVar(&noKeychain, "no-keychain", false, "Don't keep the password and share tokens in the OS keychain (the password is then shown only once)")
+	flag.BoolVar(&noStream, "no-stream", false, "Disable in-browser media playback")
+	flag.StringVar(&timezone, "tz", "UTC", "Time zone for logs and stats: UTC, local or an IANA name like Asia/Shanghai")
+	flag.BoolVar(&byUser, "by-user", false, "Break down stats by authenticated user")
+	flag.StringVar(&termsFile, "terms", "", "Require recipients to accept this terms file before downloading")
+	flag.BoolVar(&askName, "ask-name", false, "Ask visitors for their name before downloading and record it in the access log")
+	flag.StringVar(&cardFormat, "format", "text", "Card and digest format: text, md or html")
+	flag.BoolVar(&cardNoPass, "no-pass", false, "Leave the password out of the share card")
+	flag.BoolVar(&splitSecret, "split-secret", false, "Print the URL without the password; deliver the password separately")
+	flag.StringVar(&secretVia, "secret-via", state.SecretReveal, "How --split-secret delivers the password: reveal, keychain or qr")
+	flag.StringVar(&realm, "realm", "", "Basic Auth realm shown in the browser login prompt")
+	flag.StringVar(&authMode, "auth", state.AuthBasic, "How visitors log in: basic (browser prompt), form (login page with a session cookie) or token (no password, a secret token in the link)")
+	flag.StringVar(&sessionLifetime, "session-lifetime", "", "How long a --auth form login lasts, e.g. 12h or 7d (default 24h)")
+	flag.StringVar(&pageTitle, "title", "", "Title shown on the share page")
+	flag.StringVar(&pageMessage, "message", "", "Message shown to recipients above the file list")
+	flag.StringVar(&pageFooter, "footer", "", "Footer text shown at the bottom of the share page")
+	flag.BoolVar(&getScript, "get-script", false, "Serve /__get.sh, a resumable parallel download script")
+	flag.StringVar(&splitSize, "split-size", "2GB", "Part size for split archive downloads (?zip=split)")
+	flag.StringVar(&confirmSize, "confirm-size", config.DefaultConfirmSize, "Show a confirmation page before browsers download files at least this large (0 = off)")
+	flag.Var(&excludes, "exclude", "Hide entries matching this glob from recipients (repeatable)")
+	flag.Var(&allowIPs, "allow-ip", "Only serve visitors (CF-Connecting-IP) in this CIDR or IP (repeatable, comma-separated)")
+	flag.Var(&blockIPs, "block-ip", "Refuse visitors (CF-Connecting-IP) in this CIDR or IP (repeatable, comma-separated)")
+	flag.Var(&allowCountries, "allow-country", "Only serve visitors (CF-IPCountry) from this country code, e.g. CN (repeatable, comma-separated)")
+	flag.Var(&blockCountries, "block-country", "Refuse visitors (CF-IPCountry) from this country code (repeatable, comma-separated)")
+	flag.BoolVar(&showHidden, "show-hidden", false, "Share dotfiles such as .git and .env (hidden by default)")
+	flag.StringVar(&lang, "lang", "auto", "Web UI language: auto (from the visitor's Accept-Language), en or zh")
+	flag.StringVar(&theme, "theme", "default", "Directory listing theme: "+strings.Join(server.ThemeNames(), ", "))
+	flag.StringVar(&contact, "contact", "", "Contact info shown on error pages (email, URL or text)")
+	flag.BoolVar(&allowIndexing, "allow-indexing", false, "Let search engines index the share (no robots.txt Disallow or X-Robots-Tag)")
+	flag.BoolVar(&statusPage, "status-page", false, "Serve a public /__status__ page (no password) with whether the share is active, its expiry and --contact")
+	flag.BoolVar(&honeypot, "honeypot", false, "Temporarily ban IPs that probe decoy paths like /wp-login.php or /.env")
+	flag.IntVar(&maxAuthFailures, "max-auth-failures", config.DefaultMaxAuthFailures, "Lock out an IP for 15m after this many wrong passwords within 10m (0 = never)")
+	flag.IntVar(&reqRate, "req-rate", 0, "Max requests per second per visitor IP (CF-Connecting-IP); excess gets 429 (0 = unlimited)")
+	flag.StringVar(&notifyURL, "notify", "", "POST a download summary to this webhook URL when the share stops")
+	flag.StringVar(&r2Bucket, "r2", "", "R2/S3 bucket for cfshare mirror")
+	flag.StringVar(&endpoint, "endpoint", "", "S3-compatible endpoint for cfshare mirror (default: R2 from R2_ACCOUNT_ID)")
+	flag.BoolVar(&inplace, "inplace", false, "With cfshare upgrade: hand the running share over to this binary without dropping transfers")
+	flag.BoolVar(&effective, "effective", false, "With cfshare config show: print every setting after merging defaults, config file, env and flags")
+	flag.BoolVar(&analyze, "analyze", false, "With cfshare tunnel logs: diagnose common cloudflared failures")
+	flag.BoolVar(&userUpload, "upload", false, "With cfshare user add: allow the user to upload to file requests")
+	flag.StringVar(&userItems, "items", "", "With cfshare user add: comma-separated items the user may access (default: all)")
+	flag.StringVar(&streamName, "as", "", "Download file name when sharing stdin (cfshare - --as backup.tar.gz)")
+	flag.StringVar(&quota, "quota", "", "Total size a request/receive inbox may grow to, e.g. 10GB")
+	flag.StringVar(&maxFileSize, "max-file-size", "", "Largest single file a request/receive link accepts, e.g. 2GB")
+	flag.StringVar(&maxRequestSize, "max-request-size", "", "Largest upload form submission (all files together) a request/receive link accepts, e.g. 4GB (default: 10GB)")
+	flag.StringVar(&maxDuration, "max-duration", "", "Abort any single request after this long, e.g. 6h (default: no limit)")
+	flag.StringVar(&minRate, "min-rate", "", "Abort transfers slower than this many bytes per second, e.g. 1KB (default: off)")
+	flag.StringVar(&limitRate, "limit-rate", "", "Cap download bandwidth: total, or total,per-connection, e.g. 5MB/s or 5MB/s,1MB/s")
+	flag.IntVar(&maxConns, "max-conns", 0, "Max simultaneous downloads/uploads across all visitors; extra requests get 429 (0 = unlimited)")
+	flag.IntVar(&maxConnsPerIP, "max-conns-per-ip", 0, "Max simultaneous downloads/uploads per visitor IP (0 = unlimited)")
+	flag.IntVar(&waitingRoom, "waiting-room", 0, "Let at most n visitors download at once and queue the rest on an auto-refreshing page (0 = off)")
+	flag.BoolVar(&approve, "approve", false, "Hold each new visitor on a waiting page until you approve them (cfshare approve / status --watch)")
+	flag.StringVar(&serveWindow, "serve-window", "", "Only serve file downloads daily in this local time window, e.g. 01:00-07:00 (others get 503)")
+	flag.StringVar(&expire, "expire", "", "Stop the share automatically after this long, e.g. 2h or 3d")
+	flag.StringVar(&endedGrace, "ended-grace", "", "After the share stops, keep the tunnel up for this long answering every link with a \"this share has ended\" page, e.g. 24h")
+	flag.StringVar(&debugAddr, "debug-addr", "", "Serve pprof and expvar for the server process on this loopback address, e.g. 127.0.0.1:6060")
+	flag.StringVar(&storageQuota, "storage-quota", "", "Cap the total size of ~/.cfshare (inboxes, pastes, caches), e.g. 5GB; caches are evicted first")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus /metrics for the share on this loopback address, e.g. 127.0.0.1:9090")
+	flag.StringVar(&statsBackend, "stats-backend", state.StatsBackendJSON, "Where to keep raw per-request records: json or sqlite (needs the sqlite3 command)")
+	flag.StringVar(&statsRetention, "stats-retention", "30d", "Keep raw per-request records this long, e.g. 7d (0 = forever); aggregates are kept forever")
+	flag.StringVar(&statsSince, "since", "", "For stats: also list raw requests from this long ago, e.g. 24h or 7d")
+	flag.StringVar(&digestPeriod, "period", "week", "For digest: the period to report on: day, week, month or a duration like 14d")
+	flag.BoolVar(&digestSend, "send", false, "For digest: also POST the report to the --notify webhook")
+	flag.StringVar(&setupCreate, "create", "", "For setup: create the tunnel, config.yml and DNS route for this hostname")
+	flag.Var(&burn, "burn", "One-time links: each item stops working after its first complete download (--burn=share: the whole share)")
+	flag.StringVar(&maxDownloads, "max-downloads", "", "Stop the share after N complete downloads in total, or N/item for every item")
+	flag.IntVar(&maxUploads, "max-uploads", config.DefaultMaxUploads, "Max files accepted by a request link (0 = unlimited)")
 
 	reorderArgs()
 	flag.Parse()
 
+	sources, err := applySettings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+	if storageQuota != "" {
+		if storage.Quota, err = parseSize(storageQuota); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --storage-quota: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	if showHelp {
 		printUsage()
 		return
@@ -77,26 +287,269 @@ func main() {
 
 	args := flag.Args()
 
+	if config.Mount, err = parseMount(mount); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --mount: %v\n", err)
+		os.Exit(1)
+	}
+	if hostname != "" {
+		if config.Hostname, err = tunnel.NormalizeHostname(hostname); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --hostname: %v\n", err)
+			os.Exit(1)
+		}
+		if publicURL != "" {
+			fmt.Fprintln(os.Stderr, "错误: --hostname 已决定公开地址，不能与 --url 同时使用")
+			os.Exit(1)
+		}
+		// 有独立主机名的分享与默认分享分开保存，没有 --mount 时以主机名区分
+		if config.Mount == "" {
+			config.Mount = config.Hostname
+		}
+		publicURL = "https://" + config.Hostname
+	}
+	os.Setenv(config.MountEnv, config.Mount)
+	if protocol, err = tunnel.ParseProtocol(protocol); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --protocol: %v\n", err)
+		os.Exit(1)
+	}
+	if tunnelToken != "" {
+		if _, err := tunnel.ParseToken(tunnelToken); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --tunnel-token: %v\n", err)
+			os.Exit(1)
+		}
+		// 控制台管理的 tunnel 的 ingress 保存在 Cloudflare 上，cfshare 无法为分享添加主机名或端口
+		if quick || config.Hostname != "" || setupCreate != "" {
+			fmt.Fprintln(os.Stderr, "错误: --tunnel-token 的公共主机名在 Zero Trust 控制台中配置，不能与 --quick、--hostname 或 setup --create 同时使用")
+			os.Exit(1)
+		}
+		if config.Mount != "" && !router {
+			fmt.Fprintln(os.Stderr, "错误: --tunnel-token 时挂载的分享需要 --router，由路由进程按路径转发 (控制台中的公共主机名指向 --port)")
+			os.Exit(1)
+		}
+	}
+	// 挂载的分享固定使用各自的端口，cloudflared 的 ingress 规则不必随每次分享修改。
+	// 经路由进程转发时 --port 是路由进程的端口，每个分享 (包括默认分享) 另有自己的端口。
+	routerPort := 0
+	if router {
+		routerPort = port
+	}
+	if router || (config.Mount != "" && sources["port"] == sourceDefault) {
+		if port, err = state.MountPort(config.Mount); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		if port == routerPort {
+			fmt.Fprintf(os.Stderr, "错误: 端口 %d 已分配给 %s 的分享，请为路由进程指定其他 --port\n", port, formatMounts([]string{config.Mount}))
+			os.Exit(1)
+		}
+	}
+
+	if quick && (config.Mount != "" || router || publicURL != "") {
+		fmt.Fprintln(os.Stderr, "错误: --quick 使用随机分配的地址，不能与 --url、--mount、--hostname 或 --router 同时使用")
+		os.Exit(1)
+	}
+
 	if err := config.EnsureConfigDir(); err != nil {
 		fmt.Fprintf(os.Stderr, "错误: 无法创建配置目录: %v\n", err)
 		os.Exit(1)
 	}
 
+	if encryptState {
+		if err := state.EnableEncryption(); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 启用状态加密失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	recordUsage(args)
+
+	shareOpts := shareOptions{
+		public:          publicMode,
+		password:        password,
+		port:            port,
+		routerPort:      routerPort,
+		tunnelName:      tunnelName,
+		publicURL:       publicURL,
+		quick:           quick,
+		protocol:        protocol,
+		tunnelToken:     tunnelToken,
+		slug:            slug,
+		receipts:        receipts,
+		watch:           watch,
+		termsFile:       termsFile,
+		noStream:        noStream,
+		noKeychain:      noKeychain,
+		notifyURL:       notifyURL,
+		honeypot:        honeypot,
+		maxAuthFailures: maxAuthFailures,
+		reqRate:         reqRate,
+		realm:           realm,
+		authMode:        authMode,
+		session:         sessionLifetime,
+		contact:         contact,
+		secretVia:       splitSecretMode(splitSecret, secretVia),
+		passStyle:       passStyle,
+		passWords:       passWords,
+		theme:           theme,
+		title:           pageTitle,
+		message:         pageMessage,
+		footer:          pageFooter,
+		confirmSize:     confirmSize,
+		lang:            lang,
+		excludes:        excludes,
+		allowIPs:        allowIPs,
+		blockIPs:        blockIPs,
+		allowCountries:  allowCountries,
+		blockCountries:  blockCountries,
+		showHidden:      showHidden,
+		splitSize:       splitSize,
+		getScript:       getScript,
+		allowIndexing:   allowIndexing,
+		statusPage:      statusPage,
+		streamName:      streamName,
+		maxDuration:     maxDuration,
+		minRate:         minRate,
+		limitRate:       limitRate,
+		maxConns:        maxConns,
+		maxConnsPerIP:   maxConnsPerIP,
+		serveWindow:     serveWindow,
+		waitingRoom:     waitingRoom,
+		approve:         approve,
+		askName:         askName,
+		maxDownloads:    maxDownloads,
+		expire:          expire,
+		endedGrace:      endedGrace,
+		burn:            string(burn),
+		debugAddr:       debugAddr,
+		metricsAddr:     metricsAddr,
+		statsBackend:    statsBackend,
+		statsRetention:  statsRetention,
+	}
+
 	switch {
 	case len(args) == 0:
-		cmdStatus()
+		cmdStatus(tunnelName)
 
 	case args[0] == "status":
-		cmdStatus()
+		if watch && sources["watch"] == sourceFlag {
+			cmdStatusWatch()
+			return
+		}
+		cmdStatus(tunnelName)
+
+	case args[0] == "approve":
+		cmdDecideVisitors(args[1:], state.VisitorApproved)
+
+	case args[0] == "deny":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare deny <IP|序号|all>")
+			os.Exit(1)
+		}
+		cmdDecideVisitors(args[1:], state.VisitorDenied)
+
+	case args[0] == "shares":
+		cmdShares()
+
+	case args[0] == "history":
+		if len(args) < 3 || args[1] != "search" {
+			fmt.Fprintln(os.Stderr, "用法: cfshare history search <文件名>")
+			os.Exit(1)
+		}
+		cmdHistorySearch(strings.Join(args[2:], " "))
 
 	case args[0] == "stop":
 		cmdStop(forceStop)
 
 	case args[0] == "setup":
-		cmdSetup(tunnelName)
+		if setupCreate != "" {
+			cmdSetupCreate(tunnelName, setupCreate, ingressPort(port, routerPort))
+		}
+		cmdSetup(tunnelName, tunnelToken, ingressPort(port, routerPort))
+
+	case args[0] == "doctor":
+		cmdDoctor(tunnelName, tunnelToken, ingressPort(port, routerPort))
 
 	case args[0] == "logs":
-		cmdLogs()
+		cmdLogs(displayLocation(timezone))
+
+	case args[0] == "tunnel":
+		if len(args) < 2 || args[1] != "logs" {
+			fmt.Fprintln(os.Stderr, "用法: cfshare tunnel logs [--analyze]")
+			os.Exit(1)
+		}
+		cmdTunnelLogs(tunnelName, analyze)
+
+	case args[0] == "stats":
+		cmdStats(byUser, statsSince, displayLocation(timezone))
+
+	case args[0] == "digest":
+		cmdDigest(digestPeriod, cardFormat, digestSend, notifyURL, displayLocation(timezone))
+
+	case args[0] == "receipts":
+		cmdReceipts()
+
+	case args[0] == "card":
+		cmdCard(cardFormat, !cardNoPass)
+
+	case args[0] == "reveal":
+		cmdReveal(forceStop)
+
+	case args[0] == "rotate-password":
+		cmdRotatePassword(password)
+
+	case args[0] == "owner":
+		cmdOwner()
+
+	case args[0] == "speedtest":
+		cmdSpeedtest(args[1:])
+
+	case args[0] == "user":
+		cmdUser(args[1:], password, passStyle, passWords, userUpload, userItems)
+
+	case args[0] == "qr":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare qr <name> [--expires 24h]")
+			os.Exit(1)
+		}
+		cmdQR(args[1], expires)
+
+	case args[0] == "export":
+		cmdExport()
+
+	case args[0] == "upgrade":
+		cmdUpgrade(inplace)
+
+	case args[0] == "telemetry":
+		cmdTelemetry(args[1:])
+
+	case args[0] == "cache":
+		cmdCache(args[1:])
+
+	case args[0] == "review":
+		cmdReview(expire, forceStop, displayLocation(timezone))
+
+	case args[0] == "diff":
+		cmdDiff(displayLocation(timezone))
+
+	case args[0] == "config":
+		if len(args) < 2 || args[1] != "show" {
+			fmt.Fprintln(os.Stderr, "用法: cfshare config show [--effective]")
+			os.Exit(1)
+		}
+		cmdConfigShow(effective, sources)
+
+	case args[0] == "standby":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare standby <definition.json> [--tunnel name]")
+			os.Exit(1)
+		}
+		cmdStandby(args[1], tunnelName)
+
+	case args[0] == "mirror":
+		if r2Bucket == "" {
+			fmt.Fprintln(os.Stderr, "用法: cfshare mirror --r2 <bucket> [--endpoint <url>] [--expires 7d]")
+			os.Exit(1)
+		}
+		cmdMirror(r2Bucket, endpoint, expires)
 
 	case args[0] == "add":
 		if len(args) < 2 {
@@ -105,6 +558,59 @@ func main() {
 		}
 		cmdAdd(args[1:])
 
+	case args[0] == "request":
+		cmdRequest(requestOptions{
+			message:       strings.Join(args[1:], " "),
+			expires:       expires,
+			maxUploads:    maxUploads,
+			quota:         quota,
+			maxFileSize:   maxFileSize,
+			maxRequest:    maxRequestSize,
+			port:          port,
+			routerPort:    routerPort,
+			tunnelName:    tunnelName,
+			publicURL:     publicURL,
+			quick:         quick,
+			protocol:      protocol,
+			tunnelToken:   tunnelToken,
+			slug:          slug,
+			maxConns:      maxConns,
+			maxConnsPerIP: maxConnsPerIP,
+			noKeychain:    noKeychain,
+		})
+
+	case args[0] == "receive":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare receive <dir> [--quota 10GB] [--max-file-size 2GB]")
+			os.Exit(1)
+		}
+		// 收件箱默认不限文件数，只有显式指定 --max-uploads 时才限制
+		if !flagPassed("max-uploads") {
+			maxUploads = 0
+		}
+		cmdRequest(requestOptions{
+			message:       strings.Join(args[2:], " "),
+			inbox:         args[1],
+			expires:       expires,
+			maxUploads:    maxUploads,
+			quota:         quota,
+			maxFileSize:   maxFileSize,
+			maxRequest:    maxRequestSize,
+			port:          port,
+			routerPort:    routerPort,
+			tunnelName:    tunnelName,
+			publicURL:     publicURL,
+			protocol:      protocol,
+			tunnelToken:   tunnelToken,
+			slug:          slug,
+			maxConns:      maxConns,
+			maxConnsPerIP: maxConnsPerIP,
+			noKeychain:    noKeychain,
+		})
+
+	case args[0] == "send":
+		cmdSend(args[1:], shareOpts)
+
 	case args[0] == "rm" || args[0] == "remove":
 		if len(args) < 2 {
 			fmt.Fprintln(os.Stderr, "用法: cfshare rm <name>...")
@@ -113,7 +619,7 @@ func main() {
 		cmdRemove(args[1:])
 
 	default:
-		cmdShare(args, publicMode, password, port, tunnelName, publicURL)
+		cmdShare(args, shareOpts)
 	}
 }
 
@@ -124,26 +630,265 @@ Usage:
     cfshare <path>...           Share file(s)/directory (password protected)
     cfshare <path>... --public  Share publicly (no authentication)
     cfshare <path>... --pass x  Share with specified password
+    cfshare - [--as name]       Stream stdin (or share a named pipe path) to the first
+                                downloader without storing it; later requests get 410
     cfshare                     Show current share status
     cfshare status              Show detailed status
+    cfshare status --watch      Keep the status on screen; with --approve, ring on new
+                                visitors and approve (a n) or deny (d n) them in place
+    cfshare approve [ip|n|all]  List visitors waiting under --approve, or let one in
+    cfshare deny <ip|n|all>     Turn a waiting visitor away (403)
+    cfshare shares              List all running shares (default, --mount and --hostname ones)
+    cfshare history search <f>  Find past shares of a file and whether anyone downloaded it
     cfshare add <path>...       Add file(s)/directory to current share
     cfshare rm <name>...        Remove item(s) from current share
+    cfshare send "text"         Share a text snippet as a page with a raw endpoint (?raw=1);
+                                use "cfshare send -" to read it from stdin. Saved under
+                                ~/.cfshare/pastes
+    cfshare request [message]   Create an upload-only link to receive files
+    cfshare receive <dir>       Open <dir> as a drop box: recipients upload through a
+                                form, or with PUT/POST to the upload URL (e.g.
+                                curl -T file <url>); no file-count limit by default
     cfshare stop                Stop sharing
     cfshare stop --force        Force stop
     cfshare setup               Check configuration
-    cfshare logs                View access logs
+    cfshare setup --create <hostname>
+                                Create the tunnel, ~/.cloudflared/config.yml and DNS
+                                route in one go (logs in to Cloudflare if needed)
+    cfshare logs [--tz <zone>]  View access logs (times in UTC unless --tz local or
+                                an IANA zone like Asia/Shanghai)
+    cfshare stats [--by-user] [--since 24h]
+                                Show access statistics (also accepts --tz); --since
+                                also lists the raw requests of that period
+    cfshare diff                List shared files modified on disk after recipients
+                                downloaded them (and new files nobody took yet)
+    cfshare tunnel logs [--analyze]
+                                Show the end of the cloudflared log; --analyze spots
+                                common failures (missing DNS route or credentials,
+                                1033/530 errors, blocked QUIC) and prints fixes
+    cfshare doctor              Check cloudflared, the tunnel, its config, edge
+                                connections and recent tunnel log errors
+    cfshare digest [--period week] [--format f] [--send]
+                                Report on all shares of the last day/week/month:
+                                shares run, top downloads, bandwidth and notable
+                                events (text, md, html); --send posts it to --notify
+    cfshare receipts            List signed proof-of-receipt records
+    cfshare card [--format f]   Print a share card for email/chat (text, md, html)
+    cfshare reveal              Show a --split-secret password once
+    cfshare rotate-password [--pass p]
+                                Replace the share password (random unless --pass) and
+                                show it once; old sessions stop working
+    cfshare user add <name> [--pass p] [--upload] [--items a,b]
+                                Add a user with their own password (shown once, only a
+                                hash is kept): read-only unless --upload, limited to
+                                --items if given; stats --by-user tells users apart
+    cfshare user rm <name>      Remove a user (their sessions stop working)
+    cfshare user list           List users and their permissions
+    cfshare export              Print the share definition (incl. password hash) for a standby
+    cfshare standby <file>      Watch the primary's public URL and, if it stops responding
+                                3 times in a row, start this definition here with a
+                                second connector on the same tunnel
+    cfshare upgrade --inplace   After replacing the cfshare binary, move the running share
+                                to it: the new server takes over the port and the old one
+                                finishes in-flight downloads before exiting (not on Windows)
+    cfshare telemetry status|on|off
+                                Anonymous usage counts, off by default: only how often
+                                each command/option is used, never paths, URLs or values;
+                                status prints exactly what would be sent (weekly)
+    cfshare config show [--effective]
+                                Show ~/.cfshare/config.json; --effective lists every setting
+                                after merging defaults < config file < CFSHARE_* env < flags,
+                                with its source (passwords and keys masked)
+    cfshare cache stats|clear [name]
+                                Entries and size of each cache (thumbs on disk; checksums,
+                                directory sizes and templates in the running server);
+                                clear drops them
+    cfshare review [--expire 7d]
+                                Summarize what is exposed (items, visible size, auth), who
+                                accessed what in the last 7 days and how long the share has
+                                run, then ask to extend the expiry by another period from now
+                                (--expire, default: the original one); --force skips the prompt
+    cfshare mirror --r2 <bucket>
+                                Upload the shared items to an R2/S3 bucket (folders as
+                                ZIP) and list a presigned fallback URL per item that
+                                works while this machine is offline. Credentials come
+                                from AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY, the R2
+                                endpoint from R2_ACCOUNT_ID (or --endpoint <url>);
+                                links last 7 days (or --expires)
+    cfshare owner               Show your owner link; it skips the password, bans and
+                                recipient limits so your own checks stay fast
+    cfshare speedtest [MiB]     Measure throughput through the tunnel (default 100 MiB each
+                                way) and print a signed 1-hour /__speedtest__ link so a
+                                recipient can measure their side before a large transfer
+    cfshare qr <name>           Show a QR code with a signed, password-free download
+                                link for one item (valid 24h, or --expires)
 
 Options:
     --public        Public share, no authentication required
     --pass <pwd>    Specify password (default: randomly generated)
+    --pass-style <s>
+                    Style of random passwords: chars (default, 16 letters and
+                    digits) or words (e.g. horse-planet-copper-42), easier to
+                    read out over the phone; also used by user add and
+                    rotate-password
+    --pass-words <n>
+                    Number of words for --pass-style words (default 4)
     --port <port>   Local listen port (default: 8787)
     --tunnel <n>    Cloudflare Tunnel name (default: cfshare)
+    --tunnel-token <t>
+                    Run a tunnel created in the Zero Trust dashboard from its
+                    connector token; the public hostname is read from the
+                    Cloudflare API (set CLOUDFLARE_API_TOKEN) unless --url is given
     --url <url>     Public access URL
+    --quick         Use a throwaway Quick Tunnel instead of the named tunnel: no
+                    Cloudflare account, tunnel or DNS setup; the share gets a random
+                    https://*.trycloudflare.com URL that changes on every share
+    --protocol <p>  cloudflared transport: auto (default; QUIC first, http2 if no
+                    edge connection within 20s), quic or http2
+    --slug          Serve the whole share under a random path generated at share
+                    time, e.g. https://share.example.com/x9f3kq7m/; other paths 404
+    --mount <path>  Serve under a path prefix of the tunnel hostname, e.g. /docs/,
+                    on its own port so several shares run behind one tunnel;
+                    status/stop/logs with --mount act on that share
+    --hostname <h>  Serve at the root of its own tunnel hostname, e.g. docs.example.com,
+                    so several shares run on different hostnames through one
+                    cloudflared (restarted when a new hostname is added);
+                    status/stop/logs with --hostname act on that share
+    --router        Put shares behind one local router listening on --port that
+                    dispatches by hostname and --mount prefix; cloudflared keeps
+                    pointing at that port and each share gets its own port
+    --expires <d>   Request link lifetime, e.g. 2d, 12h (default: never)
+    --max-uploads n Max files a request link accepts (default: 10, 0 = unlimited)
+    --quota <size>  Total size the request/receive inbox may grow to, e.g. 10GB
+    --max-file-size <size>
+                    Largest single upload accepted, e.g. 2GB (larger files get 413)
+    --max-request-size <size>
+                    Largest upload form submission, all files together, e.g. 4GB
+                    (default: 10GB; uploads also stop before the disk fills up)
+    --max-duration <d>
+                    Abort any single request after this long, e.g. 6h (default: no limit)
+    --min-rate <size>
+                    Abort transfers slower than this per second, e.g. 1KB (checked
+                    every 30s after the first 30s; shown as Aborted in stats)
+    --limit-rate <r>
+                    Cap download bandwidth: 5MB/s for all visitors combined, or
+                    5MB/s,1MB/s to also cap each connection (0,1MB/s: per connection only)
+    --expire <d>    Stop the share (tunnel included) after this long, e.g. 2h, 3d;
+                    cfshare status shows the time left
+    --ended-grace <d>
+                    After the share stops (cfshare stop, --expire, --max-downloads),
+                    keep the tunnel up for this long and answer every link with a
+                    "this share has ended" page (410, with --contact), then stop
+                    the tunnel; cfshare stop --force or a new share skips it
+    --max-downloads <n>
+                    Stop the share (tunnel included) after n complete downloads;
+                    n/item waits until every item was downloaded n times
+    --burn[=share]  One-time links: each item returns 410 after its first complete
+                    download (folders: any file or ZIP); =share burns the whole share
+    --debug-addr <addr>
+                    Serve pprof and expvar for the server process on a loopback
+                    address, e.g. 127.0.0.1:6060 (/debug/pprof/, /debug/vars)
+    --metrics-addr <addr>
+                    Serve Prometheus /metrics (requests, bytes, active transfers,
+                    downloads per item, tunnel status) on a loopback address
+    --storage-quota <size>
+                    Cap the total size of ~/.cfshare (request inboxes, pastes,
+                    caches): caches are evicted first, then uploads are refused
+    --stats-backend <name>
+                    Where raw per-request records are kept: json (default) or
+                    sqlite (uses the sqlite3 command; not with --encrypt-state)
+    --stats-retention <duration>
+                    Keep raw records this long (default: 30d, 0 = forever);
+                    aggregate stats are kept forever
+    --max-conns n   Max simultaneous downloads/uploads for all visitors (0 = unlimited)
+    --max-conns-per-ip n
+                    Max simultaneous downloads/uploads per visitor IP (CF-Connecting-IP);
+                    requests over either cap get 429 with Retry-After
+    --waiting-room n
+                    Let at most n visitors download at once; the rest wait in a
+                    queue page that shows their position and refreshes itself
+    --approve       Hold each new visitor (by CF-Connecting-IP) on a waiting page
+                    until you let them in with cfshare approve or status --watch;
+                    you are notified in the server log and via --notify
+    --serve-window <HH:MM-HH:MM>
+                    Serve file downloads only in this daily window (local time, may
+                    cross midnight); outside it downloads get 503 with Retry-After
+                    while listings keep working
+    --receipts      Let recipients confirm receipt of fully downloaded files (signed record)
+    --watch         Watch shared files: bump a version shown in the listing when
+                    their content changes, alert --notify and list updates at /__feed.xml
+    --terms <file>  Require recipients to accept terms before downloading
+    --ask-name      Ask visitors for their name (or a note) before downloading; it is
+                    recorded in the access log and shown in download summaries
+    --no-stream     Disable in-browser playback of video/audio files
+    --no-keychain   Don't escrow the password and share tokens in the OS keychain;
+                    state.json only keeps hashes, so the password is shown once at
+                    share time and tokens go to a 0600 secrets.json
+    --encrypt-state Encrypt state/stats at rest (key from $CFSHARE_STATE_PASSPHRASE
+                    or a machine key file)
+    --notify <url>  POST a download summary (and anomaly alerts) to this webhook
+    --allow-indexing
+                    Let search engines index the share (by default every response
+                    sends X-Robots-Tag: noindex and /robots.txt disallows crawling)
+    --honeypot      Ban IPs for 1h when they probe decoy paths (/wp-login.php, /.env)
+    --max-auth-failures n
+                    Lock out an IP for 15m after n wrong passwords within 10m
+                    (default: 10, 0 = never); lockouts show in logs and status
+    --req-rate n    Max requests per second per visitor IP; excess gets 429
+                    (0 = unlimited)
+    --realm <name>  Basic Auth realm shown in the login prompt (default: cfshare)
+    --auth <mode>   How visitors log in: basic (browser prompt, default) or form
+                    (login page and a signed session cookie, with /__logout;
+                    curl -u still works). Use form on mobile or behind proxies
+                    that strip the Authorization header. token uses no password:
+                    the link carries a secret token (/t/<token>/), recipients
+                    just click it
+    --session-lifetime <d>
+                    How long a --auth form login lasts, e.g. 12h, 7d (default: 24h)
+    --contact <c>   Contact info shown on error pages (wrong password, not found)
+    --status-page   Serve a public /__status__ page (no password) showing only whether
+                    the share is active, when it expires and --contact; browsers
+                    get it instead of a bare 410 once the share has expired
+    --title <text>  Title shown on the share page
+    --message <msg> Message shown to recipients above the file list
+    --footer <text> Footer text at the bottom of the share page
+    --get-script    Serve /__get.sh: a shell script that downloads everything with
+                    resume, parallel Range segments and SHA-256 checks, e.g.
+                    curl -fsSL -u user:pass https://.../__get.sh | sh
+    --split-size <size>
+                    Part size for split directory downloads via ?zip=split
+                    (default: 2GB); each part is a standalone ZIP
+    --confirm-size <size>
+                    Show a confirmation page (size, estimated time, checksum)
+                    before browsers download files this large (default: 1GB, 0 = off)
+    --exclude <glob> Hide matching entries from listings, search, archives and direct
+                    URLs; repeatable (e.g. --exclude node_modules --exclude '*.log')
+    --show-hidden   Share dotfiles such as .git, .env and .DS_Store (hidden by default)
+    --allow-ip <cidr>
+                    Only serve visitors whose CF-Connecting-IP is in this range
+                    (e.g. 203.0.113.0/24); repeatable or comma-separated, others get 403
+    --block-ip <cidr>
+                    Refuse visitors in this range (403); wins over --allow-ip
+    --allow-country <code>
+                    Only serve visitors whose CF-IPCountry is one of these codes
+                    (e.g. CN,US); repeatable or comma-separated, XX = unknown
+    --block-country <code>
+                    Refuse visitors from these countries (403, logged as
+                    geo_blocked); wins over --allow-country
+    --lang <code>   Web UI language: auto (default, follows the visitor's
+                    Accept-Language), en or zh
+    --theme <name>  Listing theme: default, dark or minimal. A custom template at
+                    ~/.cfshare/templates/dir.html replaces the built-in one,
+                    error.html the 401/403/404 pages
+    --no-pass       Leave the password out of the share card (send it separately)
+    --split-secret  Don't print the password with the URL; deliver it via
+                    --secret-via reveal (default), keychain or qr
     -h, --help      Show help (English)
     -hc             Show help (Chinese)
     -v, --version   Show version
 
-First-time setup requires Cloudflare Tunnel configuration:
+First-time setup requires Cloudflare Tunnel configuration. After installing
+cloudflared, cfshare setup --create share.example.com does steps 2-5 for you:
     1. Install cloudflared:
        - macOS: brew install cloudflared
        - Windows: winget install Cloudflare.cloudflared
@@ -161,7 +906,15 @@ Examples:
     cfshare . --pass mypassword
     cfshare file1.pdf file2.txt dir1/    # Multi-file share
     cfshare add newfile.txt              # Dynamically add file
-    cfshare rm oldfile.txt               # Dynamically remove file`)
+    cfshare rm oldfile.txt               # Dynamically remove file
+    cfshare request "send me the contract" --expires 2d
+    tail -n 200 app.log | cfshare send -
+    cfshare - --as backup.tar.gz < backup.tar.gz
+    cfshare receive ~/Inbox --quota 20GB --max-file-size 4GB
+    cfshare ~/Releases --max-duration 6h --min-rate 1KB --limit-rate 5MB/s,1MB/s
+    cfshare ~/Public --public --max-conns 20 --max-conns-per-ip 2
+    cfshare contract.pdf --max-downloads 1 --expire 2h
+    cfshare keys.txt photos/ --burn`)
 }
 
 func printUsageChinese() {
@@ -171,26 +924,237 @@ func printUsageChinese() {
     cfshare <path>...           分享一个或多个文件/目录（需要口令）
     cfshare <path>... --public  公开分享（无需口令）
     cfshare <path>... --pass x  使用指定口令
+    cfshare - [--as name]       把标准输入（或命名管道）直接传给第一个下载者，不落盘；
+                                之后的请求返回 410
     cfshare                     查看当前分享状态
     cfshare status              查看详细状态
+    cfshare status --watch      持续显示状态；--approve 模式下新访问者到达时响铃，
+                                输入 a 序号 批准、d 序号 拒绝
+    cfshare approve [ip|n|all]  列出 --approve 模式下等待批准的访问者，或批准其中一个
+    cfshare deny <ip|n|all>     拒绝等待中的访问者（返回 403）
+    cfshare shares              列出所有运行中的分享（默认分享、--mount 和 --hostname 的分享）
+    cfshare history search <f>  查找文件在哪些分享中出现过，以及是否有人下载
     cfshare add <path>...       添加文件/目录到当前分享
     cfshare rm <name>...        从当前分享中移除项目
+    cfshare send "text"         把一段文本分享为网页，?raw=1 返回原文；
+                                "cfshare send -" 从标准输入读取，保存在 ~/.cfshare/pastes
+    cfshare request [说明]      创建仅用于接收文件的上传链接
+    cfshare receive <dir>       把 <dir> 作为收件箱: 对方可通过网页表单或向上传链接
+                                PUT/POST 上传（如 curl -T file <url>），默认不限文件数
     cfshare stop                停止分享
     cfshare stop --force        强制停止
     cfshare setup               检查配置
-    cfshare logs                查看访问日志
+    cfshare setup --create <hostname>
+                                一次完成 tunnel、~/.cloudflared/config.yml 和 DNS 路由的
+                                配置（需要时先登录 Cloudflare）
+    cfshare logs [--tz <zone>]  查看访问日志（时间默认为 UTC，--tz local 或
+                                Asia/Shanghai 等时区名换算显示）
+    cfshare stats [--by-user] [--since 24h]
+                                查看访问统计（可按用户分组，同样支持 --tz），
+                                --since 同时列出这段时间内的原始请求记录
+    cfshare diff                列出访问者下载之后在磁盘上又被修改的文件（以及还没人下载的新文件）
+    cfshare tunnel logs [--analyze]
+                                查看 cloudflared 日志末尾；--analyze 识别常见故障（DNS 路由
+                                或凭据缺失、1033/530 错误、QUIC 被阻止）并给出处理步骤
+    cfshare doctor              检查 cloudflared、tunnel、配置文件、边缘连接和 tunnel
+                                日志中最近的错误
+    cfshare digest [--period week] [--format f] [--send]
+                                汇总最近一天/一周/一月的所有分享：运行过的分享、下载最多的文件、
+                                流量和值得注意的事件（text、md、html）；--send 推送到 --notify
+    cfshare receipts            查看签收凭证
+    cfshare card [--format f]   生成可粘贴到邮件/聊天的分享卡片（text、md、html）
+    cfshare reveal              查看一次 --split-secret 单独交付的口令
+    cfshare rotate-password [--pass p]
+                                更换分享口令（默认随机生成）并显示一次，旧的登录会话随之失效
+    cfshare user add <name> [--pass p] [--upload] [--items a,b]
+                                添加有独立口令的用户（口令只显示一次，只保存哈希）：默认只读，
+                                --upload 允许向文件请求上传，--items 限定可访问的项目；
+                                stats --by-user 按用户区分下载
+    cfshare user rm <name>      删除用户（其登录会话随之失效）
+    cfshare user list           列出用户及权限
+    cfshare export              输出分享定义（含口令哈希），供热备机使用
+    cfshare standby <file>      热备: 探测主机的公开地址，连续 3 次无响应时在本机以同一
+                                tunnel 的第二个 connector 接管该分享
+    cfshare upgrade --inplace   替换 cfshare 可执行文件后，让运行中的分享切换到新版本:
+                                新服务进程接管端口，旧进程完成进行中的下载后退出（不支持 Windows）
+    cfshare telemetry status|on|off
+                                匿名使用统计，默认关闭: 只记录各命令/参数的使用次数，从不
+                                包含路径、URL 或参数值；status 显示每周将要发送的完整内容
+    cfshare config show [--effective]
+                                显示 ~/.cfshare/config.json；--effective 列出按 默认值 < 配置文件
+                                < CFSHARE_* 环境变量 < 命令行 合并后每个参数的生效值及来源
+                                （口令和密钥已隐藏）
+    cfshare cache stats|clear [name]
+                                查看各缓存的条目数和大小（磁盘上的缩略图；运行中服务进程的
+                                校验值、目录大小和模板），clear 清除缓存
+    cfshare review [--expire 7d]
+                                汇总当前暴露的内容（项目、可见大小、认证方式）、最近 7 天
+                                谁访问了什么、分享已运行多久，确认后把到期时间从现在起再
+                                延长一个周期（--expire，默认沿用分享时的设置）；--force 不询问
+    cfshare mirror --r2 <bucket>
+                                把分享项上传到 R2/S3 存储桶（目录打包为 ZIP），并列出每个
+                                项目的预签名备用链接，本机离线时仍可下载。凭据来自
+                                AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY，R2 地址来自
+                                R2_ACCOUNT_ID（或 --endpoint <url>），链接默认 7 天有效
+    cfshare owner               显示分享者本人的优先通道链接，不受口令、封禁和访问者限制
+    cfshare speedtest [MiB]     经 tunnel 测量上传和下载速度（默认各 100 MiB），并输出 1 小时内
+                                有效的 /__speedtest__ 签名链接，供访问者在大文件传输前测速
+    cfshare qr <name>           以二维码显示单个项目的免口令签名下载链接
+                                （默认 24 小时有效，可用 --expires 指定）
 
 选项:
     --public        公开分享，无需认证
     --pass <pwd>    指定口令（默认随机生成）
+    --pass-style <s>
+                    随机口令的样式：chars（默认，16 位字母和数字）或 words（如
+                    horse-planet-copper-42，便于电话里口头转述）；user add 和
+                    rotate-password 同样适用
+    --pass-words <n>
+                    --pass-style words 的单词数（默认 4）
     --port <port>   本地监听端口（默认 8787）
     --tunnel <n>    Cloudflare Tunnel 名称（默认 cfshare）
+    --tunnel-token <t>
+                    用 connector 令牌运行在 Zero Trust 控制台中创建的 tunnel；
+                    未指定 --url 时通过 Cloudflare API 读取公共主机名（需设置
+                    CLOUDFLARE_API_TOKEN）
     --url <url>     公开访问 URL
+    --quick         使用临时的 Quick Tunnel 代替命名 tunnel：不需要 Cloudflare 账户、
+                    tunnel 或 DNS 配置，分享得到随机的 https://*.trycloudflare.com
+                    地址，每次分享都会改变
+    --protocol <p>  cloudflared 的传输协议：auto（默认，先用 QUIC，20 秒内连不上
+                    边缘时换用 http2）、quic 或 http2
+    --slug          把整个分享放在分享时生成的随机路径下，如
+                    https://share.example.com/x9f3kq7m/，其他路径返回 404
+    --mount <path>  挂载到 tunnel 主机名下的路径前缀，如 /docs/，使用独立端口，
+                    多个分享可以共用一个 tunnel；status/stop 等命令加 --mount
+                    操作对应的分享
+    --hostname <h>  让分享独占 tunnel 的一个主机名，如 docs.example.com，多个分享
+                    通过同一个 cloudflared 在不同主机名上运行 (加入新主机名时重启
+                    cloudflared)；status/stop 等命令加 --hostname 操作对应的分享
+    --router        经本地路由进程转发：路由进程监听 --port，按主机名和 --mount
+                    前缀分发给各分享的独立端口，开始或停止分享时 cloudflared
+                    配置不变
+    --expires <d>   上传链接有效期，如 2d、12h（默认不过期）
+    --max-uploads n 上传链接最多接收的文件数（默认 10，0 表示不限）
+    --quota <size>  收件目录的总大小配额，如 10GB
+    --max-file-size <size>
+                    单个上传文件的大小上限，如 2GB（超出返回 413）
+    --max-request-size <size>
+                    一次表单上传 (所有文件合计) 的大小上限，如 4GB（默认 10GB；
+                    磁盘将满时同样停止接收）
+    --max-duration <d>
+                    单个请求的最长时间，如 6h，超时中止（默认不限）
+    --min-rate <size>
+                    每秒传输低于该值时中止连接，如 1KB（前 30 秒为宽限期，之后
+                    每 30 秒检查一次；统计中显示为 Aborted）
+    --limit-rate <r>
+                    限制下载带宽: 5MB/s 为所有访问者合计的上限，5MB/s,1MB/s 同时
+                    限制单个连接（0,1MB/s 只限制单个连接）
+    --expire <d>    分享在该时长后自动结束（包括 tunnel），如 2h、3d；
+                    cfshare status 显示剩余时间
+    --ended-grace <d>
+                    分享停止后（cfshare stop、--expire、--max-downloads）在该时长内
+                    保留 tunnel，所有链接返回"分享已结束"页面（410，含 --contact），
+                    之后停止 tunnel；cfshare stop --force 或开始新分享时不保留
+    --max-downloads <n>
+                    完整下载 n 次后自动结束分享（包括 tunnel）；n/item 表示每个
+                    分享项都下载满 n 次后结束
+    --burn[=share]  一次性链接：每个项目完整下载一次后返回 410（文件夹：其中任意
+                    文件或 ZIP）；=share 表示整个分享只能下载一次
+    --debug-addr <addr>
+                    在本机回环地址上提供服务进程的 pprof 和 expvar，如 127.0.0.1:6060
+                    （/debug/pprof/、/debug/vars）
+    --metrics-addr <addr>
+                    在本机回环地址上提供 Prometheus 格式的 /metrics（请求数、流量、
+                    进行中的传输、各项目下载次数、tunnel 状态）
+    --storage-quota <size>
+                    限制 ~/.cfshare 的总大小（文件请求收件、文本片段、缓存）：
+                    先按最近使用时间淘汰缓存，仍不够时拒绝上传
+    --stats-backend <name>
+                    原始请求记录的存储方式：json（默认）或 sqlite（使用 sqlite3
+                    命令，不能与 --encrypt-state 同时使用）
+    --stats-retention <duration>
+                    原始请求记录的保留时长（默认 30d，0 表示永久保留），
+                    聚合统计永久保留
+    --max-conns n   所有访问者同时进行的下载/上传数上限（0 表示不限）
+    --max-conns-per-ip n
+                    单个访问者 IP（CF-Connecting-IP）同时进行的下载/上传数上限；
+                    超出任一上限返回 429 和 Retry-After
+    --waiting-room n
+                    最多允许 n 个访问者同时下载，其余访问者进入排队页面，
+                    页面显示排队位置并自动刷新，轮到时开始下载
+    --approve       新访问者（按 CF-Connecting-IP 区分）先看到等待页面，分享者用
+                    cfshare approve 或 status --watch 批准后才能访问；
+                    新访问者会写入服务日志并推送到 --notify
+    --serve-window <HH:MM-HH:MM>
+                    只在每天的该时段（本机时区，可跨午夜）提供文件下载，时段外下载
+                    返回 503 和 Retry-After，目录列表照常访问
+    --receipts      允许接收方确认收到完整下载的文件（生成签名凭证）
+    --watch         监视分享的文件：内容改变时列表显示新版本号，推送 --notify，
+                    并在 /__feed.xml 提供 RSS 订阅
+    --terms <file>  访问者需先同意条款才能下载
+    --ask-name      访问者下载前须填写姓名（或备注），记录到访问日志并显示在下载汇总中
+    --no-stream     禁用视频/音频在线播放
+    --no-keychain   不把口令和令牌托管到系统钥匙串；state.json 只保存哈希，口令只在启动时
+                    显示一次，令牌保存在权限 0600 的 secrets.json
+    --encrypt-state 加密保存状态和统计文件（密钥来自 $CFSHARE_STATE_PASSPHRASE
+                    或本机密钥文件）
+    --notify <url>  把下载汇总（以及异常告警）POST 到该 webhook
+    --allow-indexing
+                    允许搜索引擎收录（默认所有响应带 X-Robots-Tag: noindex，
+                    /robots.txt 禁止抓取）
+    --honeypot      请求诱饵路径（/wp-login.php、/.env 等）的 IP 临时封禁 1 小时
+    --max-auth-failures n
+                    同一 IP 在 10 分钟内口令错误 n 次后锁定 15 分钟（默认 10，
+                    0 表示不锁定），锁定事件显示在 cfshare logs 和 status 中
+    --req-rate n    单个访问者 IP 每秒的请求数上限，超出返回 429（0 表示不限）
+    --realm <name>  浏览器登录框中显示的 realm（默认 cfshare）
+    --auth <mode>   访问者的登录方式：basic（浏览器登录框，默认）或 form（登录页面和
+                    签名的会话 cookie，/__logout 退出；curl -u 仍然可用）。手机上或
+                    代理会删除 Authorization 头部时使用 form。token 不使用口令，
+                    链接中带访问令牌（/t/<token>/），收件人点开即可访问
+    --session-lifetime <d>
+                    --auth form 登录后会话的有效期，如 12h、7d（默认 24h）
+    --contact <c>   错误页面（口令错误、文件不存在）上显示的联系方式
+    --status-page   提供无需口令的公开状态页 /__status__，只显示分享是否可用、
+                    何时到期和 --contact；分享到期后浏览器访问会看到它而不是 410 纯文本
+    --title <text>  分享页面的标题
+    --message <msg> 显示在文件列表上方的说明，例如 "婚礼照片，挑喜欢的下载"
+    --footer <text> 分享页面底部的文字
+    --get-script    提供 /__get.sh 下载脚本：断点续传、大文件分段并行下载并校验
+                    SHA-256，例如 curl -fsSL -u user:pass https://.../__get.sh | sh
+    --split-size <size>
+                    分卷下载（?zip=split）每卷的大小，默认 2GB，每卷都是独立的 ZIP
+    --confirm-size <size>
+                    浏览器下载不小于该大小的文件前先显示确认页（大小、预计耗时、
+                    校验值），默认 1GB，0 表示关闭
+    --exclude <glob> 对访问者隐藏匹配的条目（列表、搜索、打包和直接访问均不可见），
+                    可重复指定，例如 --exclude node_modules --exclude '*.log'
+    --show-hidden   分享 .git、.env、.DS_Store 等以 . 开头的文件（默认隐藏）
+    --allow-ip <cidr>
+                    只允许 CF-Connecting-IP 在该网段内的访问者（如 203.0.113.0/24），
+                    可重复或用逗号分隔，其他访问者得到 403
+    --block-ip <cidr>
+                    拒绝该网段内的访问者（403），优先于 --allow-ip
+    --allow-country <code>
+                    只允许 CF-IPCountry 为这些国家代码的访问者（如 CN,US），
+                    可重复或用逗号分隔，XX 表示未知
+    --block-country <code>
+                    拒绝这些国家的访问者（403，访问日志中记为 geo_blocked），
+                    优先于 --allow-country
+    --lang <code>   网页界面语言: auto（默认，按访问者的 Accept-Language）、en 或 zh
+    --theme <name>  目录列表主题: default、dark 或 minimal。
+                    ~/.cfshare/templates/dir.html 存在时替代内置模板，
+                    error.html 替代 401/403/404 页面
+    --no-pass       分享卡片中不包含口令（另行发送）
+    --split-secret  不与链接一起显示口令，通过 --secret-via 单独交付:
+                    reveal（默认，cfshare reveal 查看一次）、keychain 或 qr
     -h, --help      显示帮助（英文）
     -hc             显示帮助（中文）
     -v, --version   显示版本
 
-首次使用需要配置 Cloudflare Tunnel:
+首次使用需要配置 Cloudflare Tunnel。安装 cloudflared 后，
+cfshare setup --create share.example.com 可以自动完成第 2-5 步:
     1. 安装 cloudflared:
        - macOS: brew install cloudflared
        - Windows: winget install Cloudflare.cloudflared
@@ -208,419 +1172,3237 @@ func printUsageChinese() {
     cfshare . --pass mypassword
     cfshare file1.pdf file2.txt dir1/    # 多文件分享
     cfshare add newfile.txt              # 动态添加文件
-    cfshare rm oldfile.txt               # 动态移除文件`)
+    cfshare rm oldfile.txt               # 动态移除文件
+    cfshare request "请发送合同" --expires 2d
+    tail -n 200 app.log | cfshare send -
+    cfshare - --as backup.tar.gz < backup.tar.gz
+    cfshare receive ~/Inbox --quota 20GB --max-file-size 4GB
+    cfshare ~/Releases --max-duration 6h --min-rate 1KB --limit-rate 5MB/s,1MB/s
+    cfshare ~/Public --public --max-conns 20 --max-conns-per-ip 2
+    cfshare contract.pdf --max-downloads 1 --expire 2h
+    cfshare keys.txt photos/ --burn`)
 }
 
-func cmdStatus() {
-	st, err := state.Load()
+// cmdShares 列出分享索引中正在运行的分享。每个分享的日志、统计和进程文件
+// 在各自的目录中，加上 --mount <名称> 后 status/logs/stats/stop 只操作该分享。
+func cmdShares() {
+	var running []state.ShareEntry
+	for _, e := range state.LoadRegistry() {
+		if e.Running() {
+			running = append(running, e)
+		}
+	}
+	if st, _ := state.Load(); config.Mount == "" && st.IsRunning() && !slices.ContainsFunc(running, func(e state.ShareEntry) bool { return e.Mount == "" }) {
+		// 旧版本启动的默认分享没有登记在索引中
+		running = append([]state.ShareEntry{{ShareID: st.ShareID, Mode: st.Mode, URL: st.ShareURL(), Port: st.Port, StartTime: st.StartTime}}, running...)
+	}
+	if len(running) == 0 {
+		fmt.Println("当前无活动分享")
+		return
+	}
+
+	fmt.Println("运行中的分享")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, e := range running {
+		fmt.Printf("%-10s %s\n", formatMounts([]string{e.Mount}), e.URL)
+		detail := fmt.Sprintf("%s，端口 %d，已运行 %s", e.Mode, e.Port, time.Since(e.StartTime).Round(time.Minute))
+		if len(e.Items) > 0 {
+			detail += "，" + strings.Join(e.Items, ", ")
+		}
+		fmt.Printf("%-10s %s\n", "", detail)
+	}
+	fmt.Println("\n加上 --mount <名称> 或 --hostname <主机名> 查看、停止指定的分享或查看它的日志和统计")
+}
+
+// cmdHistorySearch 在分享历史中查找文件：什么时候分享过、有没有人下载
+func cmdHistorySearch(query string) {
+	matches, err := state.SearchHistory(query)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "错误: 读取分享历史失败: %v\n", err)
 		os.Exit(1)
 	}
-
-	fmt.Println(st.FormatStatus())
+	if len(matches) == 0 {
+		fmt.Printf("分享历史中没有找到 %q\n", query)
+		return
+	}
+	fmt.Printf("🔎 %q 的分享记录 (%d 次)\n", query, len(matches))
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for i, m := range matches {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(m.Format())
+	}
 }
 
-func cmdStop(force bool) {
+func cmdStatus(tunnelName string) {
 	st, err := state.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
 		os.Exit(1)
 	}
 
+	fmt.Println(st.FormatStatus())
+	if others := otherShares(); len(others) > 0 {
+		fmt.Printf("其他分享:   %s (用 --mount 查看或停止)\n", formatMounts(others))
+	}
+	if r := state.LoadRouter(); r.IsRunning() {
+		fmt.Printf("路由进程:   端口 %d (PID %d)\n", r.Port, r.PID)
+	}
 	if st == nil {
-		fmt.Println("当前无活动分享")
+		if e := state.LoadEnded(); e.IsRunning() {
+			fmt.Print(e.FormatStatus())
+		}
 		return
 	}
-
-	if st.ServerPID > 0 {
-		stopProcess(st.ServerPID, force)
+	if st.IsRunning() {
+		fmt.Print(checkEndpoints(st))
 	}
-
-	tm := tunnel.NewManager(config.TunnelName)
-	if force {
-		tm.ForceStop()
-	} else {
-		tm.Stop()
+	if findings, err := recentTunnelFindings(); err == nil && len(findings) > 0 {
+		fmt.Printf("Tunnel 诊断: ⚠️  %s，运行 cfshare tunnel logs --analyze 查看处理步骤\n", tunnel.Diagnosis(findings))
 	}
 
-	state.Clear()
-	os.Remove(config.GetPidFilePath())
-
-	fmt.Println("✅ 分享已停止")
-}
-
-func cmdSetup(tunnelName string) {
-	fmt.Println("检查 Cloudflare Tunnel 配置...")
-
-	if err := tunnel.CheckSetup(tunnelName); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
-		os.Exit(1)
+	// Quick Tunnel 没有命名 tunnel，无法查询 connector
+	if st.Quick {
+		return
 	}
 
-	fmt.Println("✅ Cloudflare Tunnel 配置正确")
-
-	tm := tunnel.NewManager(tunnelName)
-	url, err := tm.GetPublicURL()
+	// 同一 tunnel 可能在多台机器上运行 connector，这里显示边缘看到的全部 connector
+	connectors, err := tunnel.NewManager(tunnelName).Connectors()
 	if err != nil {
-		fmt.Printf("⚠️  无法获取公开 URL: %v\n", err)
-		fmt.Println("   请在运行 cfshare 时使用 --url 参数指定")
-	} else {
-		fmt.Printf("   公开 URL: %s\n", url)
+		fmt.Fprintf(os.Stderr, "警告: 无法获取 connector 信息: %v\n", err)
+		return
 	}
+	fmt.Print(tunnel.FormatConnectors(connectors, time.Now()))
 }
 
-func cmdLogs() {
-	logPath := config.GetAccessLogPath()
-	data, err := os.ReadFile(logPath)
+// cmdDecideVisitors 处理 --approve 模式下等待批准的访问者。target 可以是 IP、
+// cfshare approve 列表中的序号或 all；approve 不带参数时列出等待中的访问者。
+func cmdDecideVisitors(args []string, status string) {
+	st, err := state.Load()
 	if err != nil {
-		if os.IsNotExist(err) {
-			fmt.Println("暂无访问日志")
-			return
-		}
-		fmt.Fprintf(os.Stderr, "错误: 读取日志失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
 		os.Exit(1)
 	}
-
-	lines := strings.Split(string(data), "\n")
-	start := 0
-	if len(lines) > 20 {
-		start = len(lines) - 20
+	if st == nil {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+	if !st.Approve {
+		fmt.Fprintln(os.Stderr, "当前分享没有开启 --approve")
+		os.Exit(1)
 	}
 
-	fmt.Println("最近的访问日志:")
-	fmt.Println("─────────────────────────────────────────")
-	for _, line := range lines[start:] {
-		if line != "" {
-			fmt.Println(line)
+	if len(args) == 0 {
+		pending := state.PendingVisitors()
+		if len(pending) == 0 {
+			fmt.Println("没有等待批准的访问者")
+			return
+		}
+		for i, v := range pending {
+			fmt.Printf("%3d. %s  %s  %s\n", i+1, v.FirstSeen.Local().Format("15:04:05"), v.Describe(), v.Path)
 		}
+		fmt.Println("\n批准: cfshare approve <序号|IP|all>   拒绝: cfshare deny <序号|IP|all>")
+		return
 	}
-}
 
-func cmdAdd(paths []string) {
-	st, err := state.Load()
+	message, err := decideVisitors(args[0], status)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
 		os.Exit(1)
 	}
+	fmt.Println(message)
+}
 
-	if st == nil || !st.IsRunning() {
-		fmt.Fprintln(os.Stderr, "错误: 当前没有活动的分享")
-		fmt.Fprintln(os.Stderr, "请先使用 cfshare <path>... 启动分享")
-		os.Exit(1)
+// decideVisitors 把 target (IP、等待列表中的序号或 all) 对应的访问者标记为 status，返回结果说明
+func decideVisitors(target, status string) (string, error) {
+	ip := target
+	if n, err := strconv.Atoi(target); err == nil {
+		pending := state.PendingVisitors()
+		if n < 1 || n > len(pending) {
+			return "", fmt.Errorf("没有序号为 %d 的等待中访问者", n)
+		}
+		ip = pending[n-1].IP
 	}
-
-	// 构建现有名称集合
-	existingNames := make(map[string]bool)
-	for _, item := range st.Items {
-		existingNames[item.Name] = true
+	decided, err := state.DecideVisitors(ip, status)
+	if err != nil {
+		return "", err
+	}
+	if len(decided) == 0 {
+		return "没有等待批准的访问者", nil
+	}
+	verb := "✅ 已批准"
+	if status == state.VisitorDenied {
+		verb = "🚫 已拒绝"
+	}
+	lines := make([]string, len(decided))
+	for i, v := range decided {
+		lines[i] = verb + " " + v.Describe()
 	}
+	return strings.Join(lines, "\n"), nil
+}
 
-	// 验证并添加新路径
-	var newItems []state.ShareItem
-	for _, path := range paths {
-		if _, err := os.Stat(path); err != nil {
-			fmt.Fprintf(os.Stderr, "错误: 路径不存在: %s\n", path)
-			os.Exit(1)
+// cmdStatusWatch 持续显示分享状态 (cfshare status --watch)，等待批准的访问者变化时重绘。
+// --approve 模式下新访问者到达时响铃，输入 a <序号> 批准、d <序号> 拒绝 (序号也可以是 IP 或 all)，q 退出。
+func cmdStatusWatch() {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
 		}
+		close(lines)
+	}()
 
-		absPath, _ := filepath.Abs(path)
-		name := filepath.Base(absPath)
+	ticker := time.NewTicker(config.StatusWatchInterval)
+	defer ticker.Stop()
 
-		// 检查名称冲突
-		if existingNames[name] {
-			fmt.Fprintf(os.Stderr, "错误: 名称 '%s' 已存在\n", name)
+	// 只在分享或等待列表变化时重绘，避免清掉正在输入的命令
+	var lastKey, notice string
+	seen := make(map[string]bool)
+	render := func(force bool) bool {
+		st, err := state.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
 			os.Exit(1)
 		}
+		if st == nil || !st.IsRunning() {
+			fmt.Println("\n分享已停止")
+			return false
+		}
+		pending := state.PendingVisitors()
+		key := st.ShareURL()
+		bell := ""
+		for _, v := range pending {
+			key += " " + v.IP
+			if !seen[v.IP] {
+				seen[v.IP] = true
+				bell = "\a"
+			}
+		}
+		if key == lastKey && !force {
+			return true
+		}
+		lastKey = key
 
-		fi, _ := os.Stat(absPath)
-		item := state.ShareItem{
-			Path: absPath,
-			Name: name,
+		fmt.Print("\033[H\033[2J" + bell)
+		fmt.Println(st.FormatStatus())
+		if notice != "" {
+			fmt.Println(notice)
+			notice = ""
 		}
-		if fi.IsDir() {
-			item.ShareType = state.TypeDir
-			item.Size = 0
+		if st.Approve {
+			fmt.Print("\n输入 a <序号> 批准，d <序号> 拒绝（序号可换成 IP 或 all），q 退出\n> ")
 		} else {
-			item.ShareType = state.TypeFile
-			item.Size = fi.Size()
+			fmt.Print("\n按 Ctrl+C 或输入 q 退出\n> ")
 		}
+		return true
+	}
 
-		newItems = append(newItems, item)
-		existingNames[name] = true
+	if !render(true) {
+		return
+	}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				// 标准输入已关闭 (如重定向)，继续刷新状态
+				lines = nil
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				render(true)
+				continue
+			}
+			status := ""
+			switch fields[0] {
+			case "q", "quit":
+				return
+			case "a", "approve":
+				status = state.VisitorApproved
+			case "d", "deny":
+				status = state.VisitorDenied
+			}
+			switch {
+			case status == "" || len(fields) != 2:
+				notice = "无法识别的命令: " + line
+			default:
+				msg, err := decideVisitors(fields[1], status)
+				if err != nil {
+					msg = "错误: " + err.Error()
+				}
+				notice = msg
+			}
+			if !render(true) {
+				return
+			}
+		case <-ticker.C:
+			if !render(false) {
+				return
+			}
+		}
 	}
+}
 
-	// 更新状态
-	st.Items = append(st.Items, newItems...)
-	st.IsMulti = len(st.Items) > 1
+// checkEndpoints 请求本地服务的 /healthz 和公开地址的 /readyz，经过 tunnel 验证整条链路，
+// 而不只是检查进程是否存在
+func checkEndpoints(st *state.State) string {
+	client := &http.Client{Timeout: config.StandbyTimeout}
 
-	if err := st.Save(); err != nil {
-		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
-		os.Exit(1)
+	out := "Check:      本地 /healthz "
+	if _, err := fetchReady(client, fmt.Sprintf("http://127.0.0.1:%d/healthz", st.Port), ""); err != nil {
+		out += "❌ " + err.Error()
+	} else {
+		out += "✅"
 	}
+	out += "\n"
 
-	// 重启服务器以加载新配置
-	restartServer(st)
-
-	fmt.Printf("✅ 已添加 %d 个项目\n", len(newItems))
-	for _, item := range newItems {
-		fmt.Printf("  + %s (%s)\n", item.Name, item.ShareType)
+	if st.PublicURL == "" {
+		return out
 	}
-	fmt.Printf("\n当前共 %d 个分享项\n", len(st.Items))
+	out += "            公开地址 /readyz "
+	checks, err := fetchReady(client, strings.TrimRight(st.ShareURL(), "/")+"/readyz", st.OwnerToken)
+	if err != nil {
+		out += "❌ " + err.Error() + "\n"
+		for _, name := range slices.Sorted(maps.Keys(checks)) {
+			if checks[name] != "ok" {
+				out += fmt.Sprintf("            %s: %s\n", name, checks[name])
+			}
+		}
+		return out
+	}
+	return out + "✅\n"
 }
 
-func cmdRemove(names []string) {
-	st, err := state.Load()
+// fetchReady 请求健康检查地址，带分享者令牌时返回 /readyz 的各项检查结果。
+// 探测请求不计入访问日志。
+func fetchReady(client *http.Client, url, token string) (map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(health.ProbeHeader, "1")
+	if token != "" {
+		req.Header.Set(health.OwnerHeader, token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var ready struct {
+		Checks map[string]string `json:"checks"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&ready)
+	if resp.StatusCode != http.StatusOK {
+		return ready.Checks, fmt.Errorf("%s", resp.Status)
+	}
+	return ready.Checks, nil
+}
+
+func cmdCard(format string, includePassword bool) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+
+	card, err := st.Card(format, includePassword)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(card)
+}
+
+func cmdStop(force bool) {
+	stopShare(force, !force)
+}
+
+// stopShare 停止当前分享。ended 为 true 且分享设置了 --ended-grace 时，
+// 保留 tunnel 并启动 "分享已结束" 提示进程；开始新分享时替换旧分享不保留。
+func stopShare(force, ended bool) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if st == nil {
+		// 分享已停止，但提示进程还在运行
+		if e := state.LoadEnded(); e.IsRunning() {
+			stopEndedResponder()
+			stopTunnel(force)
+			fmt.Println("✅ \"分享已结束\"提示已停止")
+			return
+		}
+		fmt.Println("当前无活动分享")
+		return
+	}
+
+	if st.ServerPID > 0 {
+		stopProcess(st.ServerPID, force)
+	}
+
+	if st.Mode != state.ModeRequest {
+		reportSummary(st)
+	}
+	if err := state.RecordHistoryEnd(st.ShareID); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 记录分享历史失败: %v\n", err)
+	}
+
+	// 分享结束后口令和令牌不再需要保留
+	if st.KeychainAccount != "" {
+		keychain.Delete(st.KeychainAccount)
+	}
+	st.DeleteSecrets()
+
+	if ended && st.EndedGrace > 0 {
+		e, err := startEndedResponder(st)
+		if err == nil {
+			state.Clear()
+			os.Remove(config.GetPidFilePath())
+			fmt.Println("✅ 分享已停止")
+			fmt.Printf("🪧 %s 前链接显示\"分享已结束\"提示，之后停止 tunnel (立即停止: cfshare stop)\n", e.Until.Format("2006-01-02 15:04"))
+			return
+		}
+		fmt.Fprintf(os.Stderr, "⚠️  启动\"分享已结束\"提示失败: %v\n", err)
+	}
+
+	stopTunnel(force)
+
+	state.Clear()
+	os.Remove(config.GetPidFilePath())
+
+	fmt.Println("✅ 分享已停止")
+}
+
+// stopTunnel 停止 tunnel 和路由进程。挂载在路径前缀上的其他分享仍在运行时它们还需要 tunnel，保持运行。
+func stopTunnel(force bool) {
+	if others := otherShares(); len(others) > 0 {
+		fmt.Printf("tunnel 仍被其他分享使用 (%s)，保持运行\n", formatMounts(others))
+		return
+	}
+	stopRouter()
+	tm := tunnel.NewManager(config.TunnelName)
+	if force {
+		tm.ForceStop()
+	} else {
+		tm.Stop()
+	}
+}
+
+// startEndedResponder 在分享的端口上启动 "分享已结束" 提示进程 (--ended-grace)
+func startEndedResponder(st *state.State) (*state.Ended, error) {
+	now := time.Now()
+	e := &state.Ended{
+		Port:          st.Port,
+		PublicURL:     st.PublicURL,
+		EndedAt:       now,
+		Until:         now.Add(st.EndedGrace),
+		Contact:       st.Contact,
+		Theme:         st.Theme,
+		Lang:          st.Lang,
+		AllowIndexing: st.AllowIndexing,
+	}
+	// 提示进程启动时读取 ended.json，之后再补上 PID
+	if err := state.SaveEnded(e); err != nil {
+		return nil, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		state.ClearEnded()
+		return nil, fmt.Errorf("get executable: %w", err)
+	}
+	cmd := exec.Command(exe, "__ended__")
+	setProcAttr(cmd)
+	logFile, err := os.OpenFile(config.GetServerLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		state.ClearEnded()
+		return nil, fmt.Errorf("create log file: %w", err)
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		state.ClearEnded()
+		return nil, fmt.Errorf("start responder: %w", err)
+	}
+
+	e.PID = cmd.Process.Pid
+	if err := state.SaveEnded(e); err != nil {
+		cmd.Process.Kill()
+		state.ClearEnded()
+		return nil, err
+	}
+	return e, nil
+}
+
+// routeShare 在路由进程中登记刚启动的分享，需要时启动路由进程。
+// 失败时停止服务进程并退出，与启动服务进程失败的处理相同。
+func routeShare(st *state.State) {
+	if st.RouterPort == 0 {
+		// 之前经路由进程转发的登记已经过时
+		state.ClearRoute()
+		return
+	}
+	// 路由进程只转发随机路径 (--slug) 下的请求
+	route := state.Route{Mount: st.BasePath(), Port: st.Port}
+	if u, err := url.Parse(st.PublicURL); err == nil {
+		route.Host = u.Hostname()
+	}
+	err := state.SaveRoute(route)
+	if err == nil {
+		err = startRouter(st.RouterPort)
+	}
+	if err != nil {
+		stopProcess(st.ServerPID, true)
+		state.Clear()
+		fmt.Fprintf(os.Stderr, "错误: 启动路由进程失败: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// startRouter 确保路由进程在 port 上运行。路由进程开始监听后自己写入 router.json。
+func startRouter(port int) error {
+	if r := state.LoadRouter(); r.IsRunning() {
+		if r.Port != port {
+			return fmt.Errorf("路由进程已在端口 %d 运行，cloudflared 应指向该端口 (或先停止所有分享)", r.Port)
+		}
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("get executable: %w", err)
+	}
+	cmd := exec.Command(exe, "__router__", strconv.Itoa(port))
+	setProcAttr(cmd)
+	logFile, err := os.OpenFile(filepath.Join(config.GetConfigDir(), "router.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("create log file: %w", err)
+	}
+	defer logFile.Close()
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start router: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if r := state.LoadRouter(); r != nil && r.PID == cmd.Process.Pid {
+			return nil
+		}
+		select {
+		case <-exited:
+			return fmt.Errorf("端口 %d 可能已被占用，详见 %s", port, logFile.Name())
+		case <-deadline:
+			cmd.Process.Kill()
+			return fmt.Errorf("路由进程未能在端口 %d 上启动", port)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// stopRouter 停止路由进程，最后一个分享停止时与 tunnel 一起调用
+func stopRouter() {
+	r := state.LoadRouter()
+	if r == nil {
+		return
+	}
+	if r.IsRunning() {
+		stopProcess(r.PID, false)
+	}
+	state.ClearRouter()
+}
+
+// stopEndedResponder 停止 "分享已结束" 提示进程，tunnel 保持不变
+func stopEndedResponder() {
+	e := state.LoadEnded()
+	if e == nil {
+		return
+	}
+	if e.IsRunning() {
+		stopProcess(e.PID, false)
+	}
+	state.ClearEnded()
+}
+
+// reportSummary 打印本次分享的下载汇总，设置了 --notify 时同时推送到 webhook
+func reportSummary(st *state.State) {
+	summary := state.BuildSummary(st)
+	fmt.Println(summary.Format())
+
+	if st.NotifyURL == "" {
+		return
+	}
+	if err := notifySummary(st.NotifyURL, summary); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  发送分享汇总失败: %v\n", err)
+	}
+}
+
+// notifySummary 以 JSON 形式 POST 汇总，text 字段兼容 Slack 等常见 webhook
+func notifySummary(url string, summary *state.Summary) error {
+	return notify.Post(url, struct {
+		Text    string         `json:"text"`
+		Summary *state.Summary `json:"summary"`
+	}{
+		Text:    summary.Format(),
+		Summary: summary,
+	})
+}
+
+func cmdSetup(tunnelName, tunnelToken string, port int) {
+	fmt.Println("检查 Cloudflare Tunnel 配置...")
+
+	// 控制台管理的 tunnel 用令牌运行，不需要登录和本地的 tunnel 列表
+	check := tunnel.CheckSetup
+	if tunnelToken != "" {
+		check = func(string) error { return tunnel.CheckInstalled() }
+	}
+	if err := check(tunnelName); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		fmt.Fprintln(os.Stderr, "\n临时分享可以不配置 tunnel，使用 --quick 通过 trycloudflare.com 分享")
+		os.Exit(1)
+	}
+
+	fmt.Println("✅ Cloudflare Tunnel 配置正确")
+
+	tm := tunnel.NewManager(tunnelName)
+	tm.SetToken(tunnelToken)
+	url, err := tm.GetPublicURL(port)
+	if err != nil {
+		fmt.Printf("⚠️  无法获取公开 URL: %v\n", err)
+		fmt.Println("   请在运行 cfshare 时使用 --url 参数指定")
+	} else {
+		fmt.Printf("   公开 URL: %s\n", url)
+	}
+}
+
+// cmdSetupCreate 自动完成 Cloudflare Tunnel 的配置: 登录、创建 tunnel、写入 config.yml 和创建 DNS 记录，
+// 之后由 cmdSetup 检查配置结果
+func cmdSetupCreate(tunnelName, hostname string, port int) {
+	host, err := tunnel.NormalizeHostname(hostname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --create: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("配置 Cloudflare Tunnel %s: https://%s → localhost:%d\n", tunnelName, host, port)
+	if err := tunnel.Provision(tunnelName, host, port, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println()
+}
+
+// displayLocation 解析 --tz，无效时退出
+func displayLocation(name string) *time.Location {
+	loc, err := state.ParseTimezone(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的时区: %s\n", name)
+		os.Exit(1)
+	}
+	return loc
+}
+
+func cmdLogs(loc *time.Location) {
+	logPath := config.GetAccessLogPath()
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("暂无访问日志")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "错误: 读取日志失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	start := 0
+	if len(lines) > 20 {
+		start = len(lines) - 20
+	}
+
+	fmt.Println("最近的访问日志:")
+	fmt.Println("─────────────────────────────────────────")
+	for _, line := range lines[start:] {
+		if line != "" {
+			fmt.Println(logLineIn(line, loc))
+		}
+	}
+}
+
+// recentTunnelFindings 分析 tunnel 日志中 cloudflared 最近一次启动以来、一小时内的错误
+func recentTunnelFindings() ([]tunnel.Finding, error) {
+	f, err := os.Open(config.GetTunnelLogPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return tunnel.Recent(f, time.Now(), config.TunnelDiagnoseWindow)
+}
+
+// cmdDoctor 依次检查分享依赖的各个环节: cloudflared、命名 tunnel、cloudflared 配置、
+// 运行中的 cloudflared 的边缘连接和 tunnel 日志中最近的错误，有问题时以状态码 1 退出
+func cmdDoctor(tunnelName, tunnelToken string, port int) {
+	problems := 0
+	check := func(ok bool, format string, args ...any) {
+		mark := "✅"
+		if !ok {
+			mark = "❌"
+			problems++
+		}
+		fmt.Printf("%s %s\n", mark, fmt.Sprintf(format, args...))
+	}
+
+	fmt.Println("cfshare doctor")
+	fmt.Println("─────────────────────────────────────────")
+
+	if out, err := exec.Command("cloudflared", "--version").Output(); err != nil {
+		check(false, "找不到 cloudflared: https://developers.cloudflare.com/cloudflare-one/connections/connect-networks/downloads/")
+	} else {
+		check(true, "%s", strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]))
+		if tunnelToken != "" {
+			check(true, "使用 Zero Trust 控制台管理的 tunnel (--tunnel-token)")
+		} else if err := tunnel.CheckSetup(tunnelName); err != nil {
+			check(false, "%s (临时分享可以使用 --quick)", strings.SplitN(err.Error(), "\n", 2)[0])
+		} else {
+			check(true, "tunnel %s 已创建", tunnelName)
+		}
+	}
+
+	tm := tunnel.NewManager(tunnelName)
+	tm.SetToken(tunnelToken)
+	if tunnelToken != "" {
+		if url, err := tm.GetPublicURL(port); err != nil {
+			check(false, "%v", err)
+		} else {
+			check(true, "控制台中的公共主机名: %s", url)
+		}
+	} else if path := tunnel.UserConfigPath(); path == "" {
+		check(false, "找不到 cloudflared 配置 (~/.cloudflared/config.yml)，可运行 cfshare setup --create <hostname> 生成")
+	} else if cfg, err := tunnel.LoadConfig(path); err != nil {
+		check(false, "%v", err)
+	} else if host, err := cfg.PublicHostname(port); err != nil {
+		check(false, "%v", err)
+	} else if host == "" {
+		check(false, "%s 中没有 ingress 主机名，分享时需要用 --url 指定公开地址", path)
+	} else {
+		check(true, "%s: https://%s", path, host)
+	}
+
+	if pid := tm.GetRunningPID(); pid <= 0 {
+		fmt.Println("➖ cloudflared 未运行 (分享时自动启动)")
+	} else if n, source, err := tm.Connections(); err != nil {
+		check(false, "cloudflared 运行中 (PID %d)，无法读取边缘连接: %v", pid, err)
+	} else {
+		check(n > 0, "cloudflared 运行中 (PID %d)，%d 条边缘连接已注册 (来自 %s)", pid, n, source)
+	}
+
+	findings, err := recentTunnelFindings()
+	switch {
+	case os.IsNotExist(err):
+		fmt.Println("➖ 暂无 tunnel 日志")
+	case err != nil:
+		check(false, "读取 tunnel 日志失败: %v", err)
+	case len(findings) == 0:
+		check(true, "tunnel 日志中最近一小时没有已知错误")
+	default:
+		check(false, "tunnel 日志: %s", tunnel.Diagnosis(findings))
+		fmt.Println()
+		fmt.Print(tunnel.FormatFindings(findings, tunnelName))
+	}
+
+	if problems > 0 {
+		fmt.Printf("\n发现 %d 个问题\n", problems)
+		os.Exit(1)
+	}
+	fmt.Println("\n一切正常")
+}
+
+// cmdTunnelLogs 显示 cloudflared 日志的末尾，--analyze 时按已知故障特征给出处理步骤
+func cmdTunnelLogs(tunnelName string, analyze bool) {
+	logPath := config.GetTunnelLogPath()
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("暂无 tunnel 日志")
+			return
+		}
+		fmt.Fprintf(os.Stderr, "错误: 读取日志失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if analyze {
+		findings, err := tunnel.Analyze(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 分析日志失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("tunnel 日志诊断 (%s):\n", logPath)
+		fmt.Println("─────────────────────────────────────────")
+		fmt.Print(tunnel.FormatFindings(findings, tunnelName))
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	start := 0
+	if len(lines) > 50 {
+		start = len(lines) - 50
+	}
+
+	fmt.Println("最近的 tunnel 日志:")
+	fmt.Println("─────────────────────────────────────────")
+	for _, line := range lines[start:] {
+		fmt.Println(line)
+	}
+}
+
+// logLineIn 把日志行中的 time 字段换算到 loc 时区，旧版本按本机时区写入的日志也一并统一。
+// 有传输内容的请求在行尾附上可读的大小、耗时和速度。
+func logLineIn(line string, loc *time.Location) string {
+	var entry struct {
+		Time          string `json:"time"`
+		Bytes         int64  `json:"bytes"`
+		DurationMs    int64  `json:"duration_ms"`
+		ContentLength int64  `json:"content_length"`
+		Event         string `json:"event"`
+		Message       string `json:"message"`
+	}
+	if json.Unmarshal([]byte(line), &entry) != nil || entry.Time == "" {
+		return line
+	}
+	if t, err := time.Parse(time.RFC3339, entry.Time); err == nil {
+		old, _ := json.Marshal(entry.Time)
+		converted, _ := json.Marshal(t.In(loc).Format(time.RFC3339))
+		line = strings.Replace(line, `"time":`+string(old), `"time":`+string(converted), 1)
+	}
+	if entry.Bytes > 0 {
+		line += "  ⏱ " + state.FormatTransfer(entry.Bytes, entry.ContentLength, time.Duration(entry.DurationMs)*time.Millisecond)
+	}
+	if entry.Event == "anomaly" && entry.Message != "" {
+		line += "  ⚠️  " + entry.Message
+	}
+	return line
+}
+
+// cmdDigest 输出一段时间内所有分享的汇总报告，send 为 true 时同时推送到 --notify，
+// 可以放在 cron 中每周或每月运行一次
+func cmdDigest(period, format string, send bool, notifyURL string, loc *time.Location) {
+	now := time.Now()
+	title, from, err := digestPeriod(period, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --period: %v\n", err)
+		os.Exit(1)
+	}
+	if send && notifyURL == "" {
+		fmt.Fprintln(os.Stderr, "错误: --send 需要 --notify <url>（也可以在配置文件中设置）")
+		os.Exit(1)
+	}
+
+	digest, err := state.BuildDigest(title, from, now, loc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取分享历史失败: %v\n", err)
+		os.Exit(1)
+	}
+	report, err := digest.Format(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(report)
+
+	if send {
+		err := notify.Post(notifyURL, struct {
+			Text   string        `json:"text"`
+			Digest *state.Digest `json:"digest"`
+		}{
+			Text:   report,
+			Digest: digest,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 发送汇总报告失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "✅ 已推送到 --notify")
+	}
+}
+
+// digestPeriod 返回 --period 对应的报告标题和起始时间: day、week、month 或 14d 这样的时长
+func digestPeriod(period string, now time.Time) (string, time.Time, error) {
+	switch period {
+	case "day":
+		return "每日汇总", now.AddDate(0, 0, -1), nil
+	case "week":
+		return "每周汇总", now.AddDate(0, 0, -7), nil
+	case "month":
+		return "每月汇总", now.AddDate(0, -1, 0), nil
+	}
+	d, err := parseDuration(period)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if d <= 0 {
+		return "", time.Time{}, fmt.Errorf("period must be positive")
+	}
+	return "最近 " + period + " 汇总", now.Add(-d), nil
+}
+
+// cmdStats 显示聚合统计，指定 --since 时还列出这段时间内的原始访问记录
+func cmdStats(byUser bool, since string, loc *time.Location) {
+	fmt.Println(state.ReadStats().FormatIn(byUser, loc))
+	if since == "" {
+		return
+	}
+	d, err := parseDuration(since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --since: %v\n", err)
+		os.Exit(1)
+	}
+
+	st := &state.State{}
+	if current, err := state.Load(); err == nil && current != nil {
+		st = current
+	}
+	store, err := state.OpenStatsStore(st.StatsBackend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+	records, err := store.Records(time.Now().Add(-d))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取访问记录失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("\n最近 %s 的请求 (%d 条)\n────────────────────────────────────────\n", since, len(records))
+	for _, r := range records {
+		user := r.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Printf("%s  %3d  %10s  %-15s %-10s %s\n", state.DisplayTime(r.Time, loc), r.StatusCode,
+			state.FormatBytes(r.BytesSent), r.RemoteAddr, user, r.Path)
+	}
+}
+
+// cmdReview 汇总当前暴露的内容和最近的访问，确认后把到期时间从现在起延长一个周期
+// (--expire 指定，默认沿用分享时的 --expire)。适合作为长期分享敏感数据时的定期检查点。
+func cmdReview(expire string, force bool, loc *time.Location) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || !st.IsRunning() {
+		fmt.Println("当前无活动分享")
+		return
+	}
+
+	var items []state.ReviewItem
+	for _, item := range st.Items {
+		ri := state.ReviewItem{Name: item.Name, Path: item.Path, ShareType: item.ShareType}
+		switch item.ShareType {
+		case state.TypeDir:
+			if ri.Files, ri.Size, err = server.ExposedSize(item.Path, st.Exclude, st.ShowHidden); err != nil {
+				fmt.Fprintf(os.Stderr, "警告: 无法统计 %s: %v\n", item.Path, err)
+			}
+		case state.TypeFile:
+			if info, err := os.Stat(item.Path); err == nil {
+				ri.Files, ri.Size = 1, info.Size()
+			}
+		}
+		items = append(items, ri)
+	}
+	now := time.Now()
+	fmt.Print(state.BuildReview(st, items, now).FormatIn(loc))
+	fmt.Println()
+
+	period := st.StopAt.Sub(st.StartTime)
+	if expire != "" {
+		if period, err = parseDuration(expire); err != nil || period <= 0 {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --expire: %s\n", expire)
+			os.Exit(1)
+		}
+	}
+	if st.StopAt.IsZero() && expire == "" {
+		fmt.Println("分享不会自动结束。如需设置到期时间: cfshare review --expire 7d")
+		return
+	}
+
+	stopAt := now.Add(period)
+	if !force {
+		fmt.Printf("将到期时间延长至 %s？[y/N] ", state.DisplayTime(stopAt, loc))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("未延长到期时间")
+			return
+		}
+	}
+
+	st.StopAt = stopAt
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	reloadServer(st)
+	fmt.Printf("✅ 到期时间已延长至 %s\n", state.DisplayTime(stopAt, loc))
+}
+
+// cmdDiff 对照访问日志，列出下载之后在磁盘上又被修改的文件，
+// 回答"要不要通知对方重新下载"
+func cmdDiff(loc *time.Location) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || !st.IsRunning() {
+		fmt.Println("当前无活动分享")
+		return
+	}
+	if st.Mode == state.ModeRequest {
+		fmt.Println("文件请求没有可对比的分享文件")
+		return
+	}
+
+	var files []state.SharedFile
+	for _, item := range st.Items {
+		prefix := ""
+		if st.IsMulti {
+			prefix = "/" + item.Name
+		}
+		switch item.ShareType {
+		case state.TypeDir:
+			err := server.WalkExposed(item.Path, st.Exclude, st.ShowHidden, func(rel string, info fs.FileInfo) {
+				files = append(files, state.SharedFile{URLPath: prefix + "/" + rel, ModTime: info.ModTime()})
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "警告: 无法读取 %s: %v\n", item.Path, err)
+			}
+		case state.TypeFile:
+			if info, err := os.Stat(item.Path); err == nil {
+				files = append(files, state.SharedFile{URLPath: "/" + item.Name, ModTime: info.ModTime()})
+			}
+		}
+	}
+	fmt.Print(st.Diff(files).Format(loc))
+}
+
+func cmdReceipts() {
+	receipts, err := receipt.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取签收凭证失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(receipts) == 0 {
+		fmt.Println("暂无签收凭证")
+		return
+	}
+
+	fmt.Println("签收凭证:")
+	fmt.Println("─────────────────────────────────────────")
+	for _, r := range receipts {
+		mark := "✅"
+		if !receipt.Verify(r) {
+			mark = "❌ 签名无效"
+		}
+		fmt.Printf("%s %s  %s  %s (%d B)\n", mark, r.ID, r.Time.Local().Format("2006-01-02 15:04:05"), r.Name, r.Size)
+		fmt.Printf("   IP: %s", r.RemoteAddr)
+		if r.Recipient != "" {
+			fmt.Printf("  签收人: %s", r.Recipient)
+		}
+		fmt.Printf("\n   SHA-256: %s\n", r.SHA256)
+	}
+
+	if pub, err := receipt.PublicKey(); err == nil {
+		fmt.Printf("\n验证公钥 (ed25519): %s\n", pub)
+	}
+}
+
+func cmdAdd(paths []string) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if st == nil || !st.IsRunning() {
+		fmt.Fprintln(os.Stderr, "错误: 当前没有活动的分享")
+		fmt.Fprintln(os.Stderr, "请先使用 cfshare <path>... 启动分享")
+		os.Exit(1)
+	}
+	if st.ShareType == state.TypeStream {
+		fmt.Fprintln(os.Stderr, "错误: 流式分享不能添加其他项目")
+		os.Exit(1)
+	}
+	for _, path := range paths {
+		if server.IsStreamPath(path) {
+			fmt.Fprintf(os.Stderr, "错误: 不能向现有分享添加流: %s\n", path)
+			os.Exit(1)
+		}
+	}
+
+	// 构建现有名称集合
+	existingNames := make(map[string]bool)
+	for _, item := range st.Items {
+		existingNames[item.Name] = true
+	}
+
+	// 验证并添加新路径
+	var newItems []state.ShareItem
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 路径不存在: %s\n", path)
+			os.Exit(1)
+		}
+
+		absPath, _ := filepath.Abs(path)
+		name := filepath.Base(absPath)
+
+		// 检查名称冲突
+		if existingNames[name] {
+			fmt.Fprintf(os.Stderr, "错误: 名称 '%s' 已存在\n", name)
+			os.Exit(1)
+		}
+
+		fi, _ := os.Stat(absPath)
+		item := state.ShareItem{
+			Path: absPath,
+			Name: name,
+		}
+		if fi.IsDir() {
+			item.ShareType = state.TypeDir
+			item.Size = 0
+		} else {
+			item.ShareType = state.TypeFile
+			item.Size = fi.Size()
+		}
+
+		newItems = append(newItems, item)
+		existingNames[name] = true
+	}
+
+	// 更新状态
+	st.Items = append(st.Items, newItems...)
+	st.IsMulti = len(st.Items) > 1
+
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 通知服务器重新加载分享项
+	reloadServer(st)
+
+	fmt.Printf("✅ 已添加 %d 个项目\n", len(newItems))
+	for _, item := range newItems {
+		fmt.Printf("  + %s (%s)\n", item.Name, item.ShareType)
+	}
+	fmt.Printf("\n当前共 %d 个分享项\n", len(st.Items))
+}
+
+func cmdRemove(names []string) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	if st == nil || !st.IsRunning() {
+		fmt.Fprintln(os.Stderr, "错误: 当前没有活动的分享")
+		os.Exit(1)
+	}
+
+	if len(st.Items) == 0 {
+		fmt.Fprintln(os.Stderr, "错误: 当前没有分享项")
+		os.Exit(1)
+	}
+
+	// 构建要删除的名称集合
+	toRemove := make(map[string]bool)
+	for _, name := range names {
+		toRemove[name] = true
+	}
+
+	// 过滤保留的项
+	var remaining []state.ShareItem
+	var removed []string
+	for _, item := range st.Items {
+		if toRemove[item.Name] {
+			removed = append(removed, item.Name)
+		} else {
+			remaining = append(remaining, item)
+		}
+	}
+
+	if len(removed) == 0 {
+		fmt.Fprintln(os.Stderr, "错误: 未找到指定的项目")
+		fmt.Println("当前分享的项目:")
+		for _, item := range st.Items {
+			fmt.Printf("  - %s\n", item.Name)
+		}
+		os.Exit(1)
+	}
+
+	if len(remaining) == 0 {
+		fmt.Fprintln(os.Stderr, "错误: 不能删除所有项目")
+		fmt.Fprintln(os.Stderr, "如需停止分享，请使用 cfshare stop")
+		os.Exit(1)
+	}
+
+	// 更新状态
+	st.Items = remaining
+	st.IsMulti = len(st.Items) > 1
+
+	// 单文件时更新兼容字段
+	if len(st.Items) == 1 {
+		st.Path = st.Items[0].Path
+		st.ShareType = st.Items[0].ShareType
+	}
+
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 通知服务器重新加载分享项
+	reloadServer(st)
+
+	fmt.Printf("✅ 已移除 %d 个项目\n", len(removed))
+	for _, name := range removed {
+		fmt.Printf("  - %s\n", name)
+	}
+	fmt.Printf("\n剩余 %d 个分享项\n", len(st.Items))
+}
+
+// reloadServer 通知服务进程重新读取分享项，进行中的下载不会中断。
+// 不支持信号的平台 (Windows) 或通知失败时退回到重启服务进程。
+func reloadServer(st *state.State) {
+	if sig := reloadSignal(); sig != nil && st.ServerPID > 0 {
+		if process, err := os.FindProcess(st.ServerPID); err == nil && process.Signal(sig) == nil {
+			return
+		}
+	}
+	restartServer(st)
+}
+
+func restartServer(st *state.State) {
+	// 停止旧服务器
+	if st.ServerPID > 0 {
+		stopProcess(st.ServerPID, false)
+		time.Sleep(300 * time.Millisecond)
+	}
+
+	// 收集路径
+	var paths []string
+	for _, item := range st.Items {
+		paths = append(paths, item.Path)
+	}
+
+	// 重新启动服务器
+	serverPID, err := startServerProcess(paths, st.Port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 重启服务器失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	st.ServerPID = serverPID
+	st.Save()
+}
+
+// shareOptions 是启动分享时从命令行收集的选项
+type shareOptions struct {
+	public          bool
+	password        string
+	port            int
+	routerPort      int // 经路由进程 (--router) 转发时路由进程的端口，为 0 时不经路由进程
+	tunnelName      string
+	publicURL       string
+	quick           bool   // 使用 Quick Tunnel (trycloudflare.com)，公开地址在启动 tunnel 后才知道
+	protocol        string // cloudflared 的协议 (--protocol)
+	tunnelToken     string // 控制台管理的 tunnel 的 connector 令牌 (--tunnel-token)
+	slug            bool   // 分享放在随机路径下 (--slug)
+	receipts        bool
+	watch           bool
+	termsFile       string
+	noStream        bool
+	noKeychain      bool
+	notifyURL       string
+	honeypot        bool
+	maxAuthFailures int // 同一 IP 口令错误达到该次数后锁定
+	reqRate         int // 单个 IP 每秒的请求数上限
+	realm           string
+	authMode        string // 访问者的认证方式: state.AuthBasic 或 state.AuthForm
+	session         string // --auth form 会话的有效期
+	contact         string
+	secretVia       string // 非空时启用 --split-secret
+	passStyle       string // 随机口令的样式: auth.PassStyleChars 或 auth.PassStyleWords
+	passWords       int    // --pass-style words 的单词数
+	theme           string
+	title           string
+	message         string
+	footer          string
+	confirmSize     string
+	lang            string
+	excludes        []string
+	allowIPs        []string
+	blockIPs        []string
+	allowCountries  []string
+	blockCountries  []string
+	showHidden      bool
+	splitSize       string
+	getScript       bool
+	allowIndexing   bool
+	statusPage      bool
+	paste           bool   // cfshare send: 分享的是文本片段
+	streamName      string // 标准输入流的下载文件名
+	maxDuration     string // 单个请求的最长时间
+	minRate         string // 最低传输速率 (每秒字节数)
+	limitRate       string // 带宽上限: 合计[,每连接]
+	maxConns        int    // 同时进行的传输总数上限
+	maxConnsPerIP   int    // 单个 IP 同时进行的传输上限
+	serveWindow     string // 每日提供下载的时段 HH:MM-HH:MM
+	waitingRoom     int    // 同时下载的访问者上限，其余排队
+	approve         bool   // 新访问者须经分享者批准
+	askName         bool   // 访问者下载前须填写姓名
+	maxDownloads    string // 下载次数上限: N 或 N/item
+	expire          string // 分享自动结束前的时长
+	endedGrace      string // 分享停止后继续显示 "分享已结束" 提示的时长
+	burn            string // 一次性链接: state.BurnItem 或 state.BurnShare
+	debugAddr       string // pprof/expvar 调试监听地址
+	metricsAddr     string // Prometheus /metrics 监听地址
+	statsBackend    string // 原始访问记录的存储方式
+	statsRetention  string // 原始访问记录的保留时长，"0" 表示永久保留
+}
+
+// burnMode 是 --burn 参数: 单独的 --burn 按项目失效，--burn=share 整个分享失效
+type burnMode string
+
+func (b *burnMode) String() string   { return string(*b) }
+func (b *burnMode) IsBoolFlag() bool { return true }
+
+func (b *burnMode) Set(v string) error {
+	switch v {
+	case "true", state.BurnItem:
+		*b = state.BurnItem
+	case "false", "":
+		*b = ""
+	case state.BurnShare:
+		*b = state.BurnShare
+	default:
+		return fmt.Errorf("expected item or share, got %q", v)
+	}
+	return nil
+}
+
+// stringList 是可以重复指定的字符串参数，例如多个 --exclude
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func cmdShare(paths []string, opts shareOptions) {
+	// 标准输入或命名管道作为流分享，只能单独分享
+	stream := false
+	for _, path := range paths {
+		if server.IsStreamPath(path) {
+			stream = true
+		}
+	}
+	if stream && len(paths) > 1 {
+		fmt.Fprintln(os.Stderr, "错误: 标准输入或命名管道只能单独分享")
+		os.Exit(1)
+	}
+	if stream && paths[0] == server.StdinPath {
+		if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare - < backup.tar.gz 或 command | cfshare - [--as name]")
+			os.Exit(1)
+		}
+	}
+
+	// 验证所有路径存在
+	for _, path := range paths {
+		if path == server.StdinPath {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 路径不存在: %s\n", path)
+			os.Exit(1)
+		}
+	}
+
+	// 检查名称冲突
+	names := make(map[string]string)
+	for _, path := range paths {
+		absPath, _ := filepath.Abs(path)
+		name := filepath.Base(absPath)
+		if existing, ok := names[name]; ok {
+			fmt.Fprintf(os.Stderr, "错误: 名称冲突: '%s'\n", name)
+			fmt.Fprintf(os.Stderr, "  - %s\n", existing)
+			fmt.Fprintf(os.Stderr, "  - %s\n", absPath)
+			fmt.Fprintln(os.Stderr, "请重命名文件后再试")
+			os.Exit(1)
+		}
+		names[name] = absPath
+	}
+
+	if !server.ValidTheme(opts.theme) {
+		fmt.Fprintf(os.Stderr, "错误: 未知主题: %s（可选: %s）\n", opts.theme, strings.Join(server.ThemeNames(), ", "))
+		os.Exit(1)
+	}
+
+	if opts.lang == "auto" {
+		opts.lang = ""
+	}
+	if opts.lang != "" && !i18n.Supported(opts.lang) {
+		fmt.Fprintf(os.Stderr, "错误: 不支持的语言: %s（可选: auto, %s）\n", opts.lang, strings.Join(languageCodes(), ", "))
+		os.Exit(1)
+	}
+
+	for _, pattern := range opts.excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的排除模式: %s\n", pattern)
+			os.Exit(1)
+		}
+	}
+	for _, list := range [][]string{opts.allowIPs, opts.blockIPs} {
+		if _, err := server.ParseIPPrefixes(list); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 IP 规则: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, list := range []*[]string{&opts.allowCountries, &opts.blockCountries} {
+		codes, err := server.ParseCountries(*list)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的国家代码: %v\n", err)
+			os.Exit(1)
+		}
+		*list = codes
+	}
+	if opts.serveWindow != "" {
+		if _, err := server.ParseServeWindow(opts.serveWindow); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的下载时段: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	confirmBytes, err := parseSize(opts.confirmSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的大小: %s\n", opts.confirmSize)
+		os.Exit(1)
+	}
+	splitBytes, err := parseSize(opts.splitSize)
+	if err != nil || splitBytes <= 0 {
+		fmt.Fprintf(os.Stderr, "错误: 无效的分卷大小: %s\n", opts.splitSize)
+		os.Exit(1)
+	}
+
+	var maxDuration time.Duration
+	if opts.maxDuration != "" {
+		maxDuration, err = parseDuration(opts.maxDuration)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的最长请求时间: %s\n", opts.maxDuration)
+			os.Exit(1)
+		}
+	}
+	minRate, err := parseSize(opts.minRate)
+	if opts.minRate != "" && err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的最低传输速率: %s\n", opts.minRate)
+		os.Exit(1)
+	}
+
+	var expireAfter time.Duration
+	if opts.expire != "" {
+		expireAfter, err = parseDuration(opts.expire)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --expire: %s\n", opts.expire)
+			os.Exit(1)
+		}
+	}
+	switch opts.authMode {
+	case state.AuthBasic, state.AuthForm, state.AuthToken:
+	default:
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --auth: %s (可选 basic、form、token)\n", opts.authMode)
+		os.Exit(1)
+	}
+	if opts.authMode != state.AuthBasic && opts.public {
+		fmt.Fprintf(os.Stderr, "错误: --auth %s 不能与 --public 同时使用\n", opts.authMode)
+		os.Exit(1)
+	}
+	if opts.authMode == state.AuthToken && (opts.password != "" || opts.secretVia != "") {
+		fmt.Fprintln(os.Stderr, "错误: --auth token 的链接本身就是凭据，不使用口令，不能与 --pass、--split-secret 同时使用")
+		os.Exit(1)
+	}
+	checkPassStyle(opts.passStyle, opts.passWords)
+	sessionLifetime := config.DefaultSessionLifetime
+	if opts.session != "" {
+		sessionLifetime, err = parseDuration(opts.session)
+		if err != nil || sessionLifetime <= 0 {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --session-lifetime: %s\n", opts.session)
+			os.Exit(1)
+		}
+	}
+	var endedGrace time.Duration
+	if opts.endedGrace != "" {
+		endedGrace, err = parseDuration(opts.endedGrace)
+		if err != nil || endedGrace <= 0 {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --ended-grace: %s\n", opts.endedGrace)
+			os.Exit(1)
+		}
+	}
+	if opts.debugAddr != "" {
+		if err := server.ValidateLoopbackAddr(opts.debugAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --debug-addr: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.metricsAddr != "" {
+		if err := server.ValidateLoopbackAddr(opts.metricsAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --metrics-addr: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.statsBackend == state.StatsBackendSQLite && state.EncryptionEnabled() {
+		fmt.Fprintln(os.Stderr, "错误: --stats-backend sqlite 不支持加密，已启用 --encrypt-state 时请使用 json")
+		os.Exit(1)
+	}
+	if _, err := state.OpenStatsStore(opts.statsBackend); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --stats-backend: %v\n", err)
+		os.Exit(1)
+	}
+	statsRetention := time.Duration(-1)
+	if opts.statsRetention != "0" {
+		if statsRetention, err = parseDuration(opts.statsRetention); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --stats-retention: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	maxDownloads, perItem, err := parseMaxDownloads(opts.maxDownloads)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --max-downloads: %v\n", err)
+		os.Exit(1)
+	}
+	limitTotal, limitConn, err := parseLimitRate(opts.limitRate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --limit-rate: %v\n", err)
+		os.Exit(1)
+	}
+
+	var termsPath string
+	if opts.termsFile != "" {
+		if _, err := os.Stat(opts.termsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 条款文件不存在: %s\n", opts.termsFile)
+			os.Exit(1)
+		}
+		termsPath, _ = filepath.Abs(opts.termsFile)
+	}
+
+	// 上一个分享的 "分享已结束" 提示进程占用着端口，新分享沿用它保留的 tunnel
+	stopEndedResponder()
+	existingState, _ := state.Load()
+	if existingState != nil && existingState.IsRunning() {
+		fmt.Println("正在停止现有分享...")
+		stopShare(false, false)
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	username := ""
+	password := opts.password
+	if !opts.public && opts.authMode != state.AuthToken {
+		username = config.DefaultUsername
+		if password == "" {
+			password = generatePassword(opts.passStyle, opts.passWords)
+		}
+	}
+
+	publicURL := opts.publicURL
+	quickPID := 0
+	if opts.quick {
+		quickPID, publicURL = startQuickTunnel(opts.port, opts.protocol)
+	} else if publicURL == "" {
+		tm := tunnel.NewManager(opts.tunnelName)
+		tm.SetToken(opts.tunnelToken)
+		var err error
+		publicURL, err = tm.GetPublicURL(ingressPort(opts.port, opts.routerPort))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无法获取公开 URL: %v\n", err)
+			fmt.Fprintln(os.Stderr, "请使用 --url 参数指定公开 URL")
+			os.Exit(1)
+		}
+	}
+	publicURL = mountURL(publicURL)
+	publicURL, slugPath := withSlug(publicURL, opts.slug)
+
+	st := &state.State{
+		ShareID:         fmt.Sprintf("%d", time.Now().Unix()),
+		Port:            opts.port,
+		StartTime:       time.Now(),
+		PublicURL:       publicURL,
+		TunnelName:      opts.tunnelName,
+		TunnelProtocol:  opts.protocol,
+		TunnelToken:     opts.tunnelToken,
+		Quick:           opts.quick,
+		Mount:           mountPath(),
+		Hostname:        config.Hostname,
+		Slug:            slugPath,
+		RouterPort:      opts.routerPort,
+		Receipts:        opts.receipts,
+		Watch:           opts.watch,
+		TermsPath:       termsPath,
+		NoStream:        opts.noStream,
+		NotifyURL:       opts.notifyURL,
+		PassStyle:       opts.passStyle,
+		PassWords:       opts.passWords,
+		Honeypot:        opts.honeypot,
+		MaxAuthFailures: opts.maxAuthFailures,
+		ReqRate:         opts.reqRate,
+		Realm:           opts.realm,
+		Contact:         opts.contact,
+		Theme:           opts.theme,
+		Lang:            opts.lang,
+
+		Exclude:        opts.excludes,
+		AllowIPs:       opts.allowIPs,
+		BlockIPs:       opts.blockIPs,
+		AllowCountries: opts.allowCountries,
+		BlockCountries: opts.blockCountries,
+		ShowHidden:     opts.showHidden,
+		Title:          opts.title,
+		Message:        opts.message,
+		Footer:         opts.footer,
+
+		StreamName:    opts.streamName,
+		ConfirmSize:   confirmBytes,
+		SplitSize:     splitBytes,
+		GetScript:     opts.getScript,
+		AllowIndexing: opts.allowIndexing,
+		StatusPage:    opts.statusPage,
+		Paste:         opts.paste,
+
+		MaxRequestDuration: maxDuration,
+		MinRate:            minRate,
+		LimitRate:          limitTotal,
+		LimitRateConn:      limitConn,
+		MaxConns:           opts.maxConns,
+		MaxConnsPerIP:      opts.maxConnsPerIP,
+		ServeWindow:        opts.serveWindow,
+		WaitingRoom:        opts.waitingRoom,
+		Approve:            opts.approve,
+		AskName:            opts.askName,
+
+		MaxDownloads:        maxDownloads,
+		MaxDownloadsPerItem: perItem,
+		Burn:                opts.burn,
+
+		DebugAddr:   opts.debugAddr,
+		MetricsAddr: opts.metricsAddr,
+
+		StorageQuota: storage.Quota,
+
+		StatsBackend:   opts.statsBackend,
+		StatsRetention: statsRetention,
+
+		LinkSecret: auth.GenerateToken(32),
+		OwnerToken: auth.GenerateToken(32),
+	}
+	if expireAfter > 0 {
+		st.StopAt = st.StartTime.Add(expireAfter)
+	}
+	st.EndedGrace = endedGrace
+
+	if opts.public {
+		st.Mode = state.ModePublic
+	} else if opts.authMode == state.AuthToken {
+		st.Mode = state.ModeProtected
+		st.Auth = state.AuthToken
+		st.LinkToken = auth.GenerateToken(config.LinkTokenLength)
+	} else {
+		st.Mode = state.ModeProtected
+		st.Username = username
+		st.SetPassword(password)
+
+		if !opts.noKeychain || opts.secretVia == state.SecretKeychain {
+			escrowPassword(st)
+		}
+		st.SplitSecret = opts.secretVia
+		if opts.authMode == state.AuthForm {
+			st.Auth = state.AuthForm
+			st.SessionSecret = auth.GenerateToken(32)
+			st.SessionLifetime = sessionLifetime
+		}
+		if st.SplitSecret == state.SecretKeychain && st.KeychainAccount == "" {
+			fmt.Fprintln(os.Stderr, "⚠️  系统钥匙串不可用，改为使用 cfshare reveal 查看口令")
+			st.SplitSecret = state.SecretReveal
+		}
+	}
+	escrowSecrets(st, opts.noKeychain)
+
+	// 构建 Items 列表
+	var items []state.ShareItem
+	for _, path := range paths {
+		if stream {
+			items = append(items, server.StreamItem(path, opts.streamName))
+			continue
+		}
+		absPath, _ := filepath.Abs(path)
+		fi, _ := os.Stat(absPath)
+		item := state.ShareItem{
+			Path: absPath,
+			Name: filepath.Base(absPath),
+		}
+		if fi.IsDir() {
+			item.ShareType = state.TypeDir
+			item.Size = 0
+		} else {
+			item.ShareType = state.TypeFile
+			item.Size = fi.Size()
+		}
+		items = append(items, item)
+	}
+
+	st.Items = items
+	st.IsMulti = len(items) > 1
+
+	// 单文件时填充兼容字段
+	if len(items) == 1 {
+		st.Path = items[0].Path
+		st.ShareType = items[0].ShareType
+	}
+
+	// 服务器进程启动时从状态文件读取分享选项，需要先保存
+	if err := st.Save(); err != nil {
+		stopQuickTunnel(quickPID)
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	serverPID, err := startServerProcess(paths, opts.port)
+	if err != nil {
+		stopQuickTunnel(quickPID)
+		state.Clear()
+		fmt.Fprintf(os.Stderr, "错误: 启动服务器失败: %v\n", err)
+		os.Exit(1)
+	}
+	st.ServerPID = serverPID
+	routeShare(st)
+
+	tunnelPID := quickPID
+	if tunnelPID == 0 {
+		routeHostname(opts.tunnelName)
+		tm := tunnel.NewManager(opts.tunnelName)
+		tm.SetOrigins(tunnelOrigins(st))
+		tm.SetProtocol(opts.protocol)
+		tm.SetToken(opts.tunnelToken)
+		if tunnelPID, err = tm.Start(); err != nil {
+			stopProcess(serverPID, true)
+			state.Clear()
+			fmt.Fprintf(os.Stderr, "错误: 启动 tunnel 失败: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	st.TunnelPID = tunnelPID
+
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 保存状态失败: %v\n", err)
+	}
+
+	if err := state.Register(st); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 登记分享失败: %v\n", err)
+	}
+
+	fmt.Print(st.FormatShareOutput())
+	if st.SplitSecret != "" {
+		deliverSecret(st)
+	}
+}
+
+// splitSecretMode 校验 --secret-via，未启用 --split-secret 时返回空
+func splitSecretMode(enabled bool, via string) string {
+	if !enabled {
+		return ""
+	}
+	switch via {
+	case state.SecretReveal, state.SecretKeychain, state.SecretQR:
+		return via
+	}
+	fmt.Fprintf(os.Stderr, "错误: 未知的 --secret-via: %s (可选 reveal、keychain、qr)\n", via)
+	os.Exit(1)
+	return ""
+}
+
+// deliverSecret 按 --secret-via 指定的方式单独交付口令，避免与链接一起被复制
+func deliverSecret(st *state.State) {
+	fmt.Println()
+	switch st.SplitSecret {
+	case state.SecretKeychain:
+		fmt.Printf("🔑 口令已保存到系统钥匙串 (服务 cfshare，账户 %s)\n", st.KeychainAccount)
+		fmt.Println("   请从钥匙串中取出后，通过与链接不同的渠道发送")
+	case state.SecretQR:
+		code, err := qr.Encode(st.Password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  无法生成二维码: %v，请使用 cfshare reveal 查看口令\n", err)
+			return
+		}
+		fmt.Println("🔑 用手机扫描二维码获取口令，并通过与链接不同的渠道发送:")
+		fmt.Print(code.Terminal())
+	default:
+		fmt.Println("🔑 口令未显示。请在准备通过另一渠道发送时运行 cfshare reveal 查看（仅可查看一次）")
+	}
+}
+
+// cmdQR 为单个分享项生成免口令的签名下载链接，并以二维码显示
+func cmdQR(name, expires string) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || st.Mode == state.ModeRequest {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+
+	var item *state.ShareItem
+	for i := range st.Items {
+		if st.Items[i].Name == name {
+			item = &st.Items[i]
+		}
+	}
+	if item == nil {
+		fmt.Fprintf(os.Stderr, "错误: 分享中没有名为 %s 的项目\n", name)
+		os.Exit(1)
+	}
+
+	ttl := config.DefaultLinkTTL
+	if expires != "" {
+		if ttl, err = parseDuration(expires); err != nil || ttl <= 0 {
+			fmt.Fprintf(os.Stderr, "错误: 无效的有效期: %s\n", expires)
+			os.Exit(1)
+		}
+	}
+
+	link, err := signedItemURL(st, item, time.Now().Add(ttl))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	code, err := qr.Encode(link)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无法生成二维码: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(code.Terminal())
+	fmt.Println(link)
+	if st.Mode == state.ModeProtected {
+		fmt.Printf("\n🔗 扫码即可直接下载 %s，无需口令，有效期至 %s\n", item.Name, time.Now().Add(ttl).Format("2006-01-02 15:04"))
+	}
+}
+
+// signedItemURL 返回单个分享项的直接下载链接。需要口令的分享附带签名，
+// 目录以 ZIP 形式下载。
+func signedItemURL(st *state.State, item *state.ShareItem, expires time.Time) (string, error) {
+	urlPath := "/" + item.Name
+	query := url.Values{}
+	if item.ShareType == state.TypeDir {
+		query.Set("zip", "1")
+	}
+
+	if st.Mode == state.ModeProtected {
+		if st.LinkSecret == "" {
+			return "", fmt.Errorf("当前分享不支持签名链接，请重新启动分享")
+		}
+		query.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+		query.Set("sig", auth.SignPath(st.LinkSecret, urlPath, expires))
+	}
+
+	link := strings.TrimSuffix(st.ShareURL(), "/") + "/" + url.PathEscape(item.Name)
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link, nil
+}
+
+// cmdExport 输出当前分享的声明式定义，供另一台机器上的 cfshare standby 使用
+func cmdExport() {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || st.Mode == state.ModeRequest {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+
+	data, err := st.Definition()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+	fmt.Fprintln(os.Stderr, "⚠️  定义中包含口令哈希和签名密钥，请只通过可信渠道复制到热备机")
+}
+
+// cmdStandby 在热备机上运行: 持续探测主机的公开地址，主机连续无响应时用相同的定义
+// 在本机启动服务器和同一 tunnel 的 connector 接管分享，之后与普通分享一样在后台运行。
+// 两台机器共用口令、签名密钥和分享者令牌，主机恢复后两边都能正常服务。
+func cmdStandby(defPath, tunnelName string) {
+	def, err := state.LoadDefinition(defPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取分享定义失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st, _ := state.Load(); st != nil && st.IsRunning() {
+		fmt.Fprintln(os.Stderr, "错误: 本机已有运行中的分享，请先执行 cfshare stop")
+		os.Exit(1)
+	}
+	// 用令牌运行的 tunnel 不需要登录和本地的 tunnel 列表
+	if def.TunnelToken == "" {
+		if err := tunnel.CheckSetup(tunnelName); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), getSignals()...)
+	defer stop()
+
+	fmt.Printf("🛡  热备中: 每 %s 探测一次 %s，连续 %d 次无响应时接管分享\n",
+		config.StandbyInterval, def.PublicURL, config.StandbyFailures)
+	monitor := &standby.Monitor{
+		URL:      def.PublicURL,
+		Token:    def.OwnerToken,
+		Interval: config.StandbyInterval,
+		Failures: config.StandbyFailures,
+		Timeout:  config.StandbyTimeout,
+		OnProbe: func(err error) {
+			if err != nil {
+				fmt.Printf("%s 主机无响应: %v\n", time.Now().Format("15:04:05"), err)
+			}
+		},
+	}
+	if err := monitor.Wait(ctx); err != nil {
+		fmt.Println("已退出热备")
+		return
+	}
+
+	fmt.Println("⚠️  主机已失联，开始接管分享")
+	st := def
+	st.StartTime = time.Now()
+	st.TunnelName = tunnelName
+	if st.Port == 0 {
+		st.Port = config.DefaultPort
+	}
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	var paths []string
+	for _, item := range st.Items {
+		paths = append(paths, item.Path)
+	}
+	serverPID, err := startServerProcess(paths, st.Port)
+	if err != nil {
+		state.Clear()
+		fmt.Fprintf(os.Stderr, "错误: 启动服务器失败: %v\n", err)
+		os.Exit(1)
+	}
+	st.ServerPID = serverPID
+
+	tm := tunnel.NewManager(tunnelName)
+	tm.SetProtocol(st.TunnelProtocol)
+	tm.SetToken(st.TunnelToken)
+	tunnelPID, err := tm.Start()
+	if err != nil {
+		stopProcess(serverPID, true)
+		state.Clear()
+		fmt.Fprintf(os.Stderr, "错误: 启动 tunnel 失败: %v\n", err)
+		os.Exit(1)
+	}
+	st.TunnelPID = tunnelPID
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 保存状态失败: %v\n", err)
+	}
+
+	if err := state.Register(st); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 登记分享失败: %v\n", err)
+	}
+
+	fmt.Print(st.FormatShareOutput())
+	fmt.Println("\n✅ 已接管分享，使用 cfshare status / cfshare stop 管理")
+}
+
+// cmdMirror 把分享项上传到 R2/S3 存储桶 (目录打包为 ZIP)，并为每个项目列出
+// tunnel 链接和预签名的对象链接。本机离线后，接收方仍可通过对象链接下载镜像。
+func cmdMirror(bucketName, endpoint, expires string) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || st.Mode == state.ModeRequest {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+
+	ttl := config.DefaultMirrorTTL
+	if expires != "" {
+		if ttl, err = parseDuration(expires); err != nil || ttl <= 0 || ttl > mirror.MaxPresignTTL {
+			fmt.Fprintf(os.Stderr, "错误: 无效的有效期: %s (最长 7 天)\n", expires)
+			os.Exit(1)
+		}
+	}
+
+	bucket, err := mirror.FromEnv(bucketName, endpoint)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	var links []state.MirrorLink
+	for i := range st.Items {
+		item := &st.Items[i]
+		if item.ShareType == state.TypeStream {
+			fmt.Fprintf(os.Stderr, "⚠️  %s 是流，无法镜像\n", item.Name)
+			continue
+		}
+		fmt.Printf("⬆️  上传 %s ...\n", item.Name)
+		link, err := mirrorItem(bucket, st, item, ttl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		links = append(links, link)
+	}
+
+	st.Mirrors = links
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 保存状态失败: %v\n", err)
+	}
+
+	fmt.Printf("\n✅ 已镜像 %d 个项目到 %s，对象链接有效期至 %s\n", len(links), bucketName, expiresAt.Format("2006-01-02 15:04"))
+	for i, link := range links {
+		fmt.Printf("\n%s\n", link.Name)
+		if tunnelURL, err := signedItemURL(st, &st.Items[i], expiresAt); err == nil {
+			fmt.Printf("  Tunnel: %s\n", tunnelURL)
+		}
+		fmt.Printf("  Mirror: %s\n", link.URL)
+	}
+	if st.Mode == state.ModeProtected {
+		fmt.Println("\n⚠️  以上链接无需口令即可下载，请只发给接收方")
+	}
+}
+
+// mirrorItem 上传单个分享项并返回预签名链接，目录先按分享时的过滤规则打包为临时 ZIP
+func mirrorItem(bucket *mirror.Bucket, st *state.State, item *state.ShareItem, ttl time.Duration) (state.MirrorLink, error) {
+	path, name := item.Path, item.Name
+	if item.ShareType == state.TypeDir {
+		tmp, err := os.CreateTemp("", "cfshare-mirror-*.zip")
+		if err != nil {
+			return state.MirrorLink{}, err
+		}
+		defer os.Remove(tmp.Name())
+		err = server.WriteDirZip(tmp, item.Path, st.Exclude, st.ShowHidden)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return state.MirrorLink{}, fmt.Errorf("打包 %s 失败: %w", item.Name, err)
+		}
+		path, name = tmp.Name(), item.Name+".zip"
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return state.MirrorLink{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return state.MirrorLink{}, err
+	}
+
+	key := "cfshare/" + st.ShareID + "/" + name
+	if err := bucket.Put(context.Background(), key, f, info.Size(), mime.TypeByExtension(filepath.Ext(name))); err != nil {
+		return state.MirrorLink{}, err
+	}
+	link, err := bucket.PresignGet(key, ttl, time.Now())
+	if err != nil {
+		return state.MirrorLink{}, err
+	}
+	return state.MirrorLink{Name: item.Name, Key: key, URL: link, ExpiresAt: time.Now().Add(ttl)}, nil
+}
+
+// cmdReveal 在本机显示一次单独交付的口令
+func cmdReveal(force bool) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || st.Mode != state.ModeProtected || st.Auth == state.AuthToken {
+		fmt.Fprintln(os.Stderr, "当前没有需要口令的分享")
+		os.Exit(1)
+	}
+
+	if st.SecretRevealed && !force {
+		fmt.Fprintln(os.Stderr, "口令已经查看过一次。如确需再次查看，请使用 cfshare reveal --force")
+		os.Exit(1)
+	}
+
+	password := st.Password
+	if password == "" {
+		// state.json 只保存口令哈希。单独交付的口令还没有人见过时，换成新口令显示，效果与查看原口令相同
+		if st.SplitSecret == "" || st.SecretRevealed {
+			fmt.Fprintln(os.Stderr, "state.json 只保存口令哈希，无法再次查看。如需新口令，请使用 cfshare rotate-password")
+			os.Exit(1)
+		}
+		password = generatePassword(st.PassStyle, st.PassWords)
+		rotatePassword(st, password)
+	}
+	fmt.Println(password)
+
+	if st.SplitSecret != "" && !st.SecretRevealed {
+		st.SecretRevealed = true
+		if err := st.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "警告: 保存状态失败: %v\n", err)
+		}
+	}
+}
+
+// cmdRotatePassword 为口令保护的分享更换口令 (默认随机生成，或使用 --pass 指定)，新口令只显示这一次
+func cmdRotatePassword(password string) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || st.Mode != state.ModeProtected || st.Auth == state.AuthToken {
+		fmt.Fprintln(os.Stderr, "当前没有需要口令的分享")
+		os.Exit(1)
+	}
+
+	if password == "" {
+		password = generatePassword(st.PassStyle, st.PassWords)
+	}
+	st.SecretRevealed = st.SplitSecret != ""
+	rotatePassword(st, password)
+
+	fmt.Println("🔑 口令已更换，旧口令和网页登录会话随之失效。新口令只显示这一次:")
+	fmt.Printf("Username: %s\nPassword: %s\n", st.Username, password)
+}
+
+// rotatePassword 换成新口令并保存 (state.json 只保存哈希)，同步更新钥匙串。
+// 服务进程启动时取得口令哈希，运行中的分享需要重启服务进程才能生效。
+func rotatePassword(st *state.State, password string) {
+	st.SetPassword(password)
+	if st.KeychainAccount != "" {
+		if err := keychain.Set(st.KeychainAccount, password); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  无法更新系统钥匙串 (%v)，新口令只显示这一次\n", err)
+			keychain.Delete(st.KeychainAccount)
+			st.KeychainAccount = ""
+		}
+	}
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st.IsRunning() {
+		restartServer(st)
+	}
+}
+
+// cmdOwner 显示分享者本人的优先通道链接。持有令牌的请求跳过口令、封禁和
+// 针对访问者的各种限制，方便在访问者占满带宽时自己验证下载。
+func cmdOwner() {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+	if st.OwnerToken == "" {
+		fmt.Fprintln(os.Stderr, "当前分享不支持分享者令牌，请重新启动分享")
+		os.Exit(1)
+	}
+
+	base := strings.TrimSuffix(st.ShareURL(), "/")
+	fmt.Printf("%s/__owner__?token=%s\n\n", base, st.OwnerToken)
+	fmt.Println("在浏览器中打开上面的链接后，本浏览器的访问都走优先通道，不受访问者限制。")
+	fmt.Println("命令行下载时附加请求头:")
+	fmt.Printf("  curl -H 'X-Cfshare-Owner: %s' %s/...\n", st.OwnerToken, base)
+	fmt.Println("\n⚠️  令牌等同于管理员口令，请勿发给访问者")
+}
+
+// cmdSpeedtest 经公开地址测量 tunnel 的实际吞吐量 (本机 → Cloudflare → 本机)，
+// 并输出一个有效期 1 小时的签名测速链接，访问者可以用它测量自己那一端的下载速度。
+func cmdSpeedtest(args []string) {
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if st == nil || !st.IsRunning() {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+	if st.OwnerToken == "" {
+		fmt.Fprintln(os.Stderr, "当前分享不支持分享者令牌，请重新启动分享")
+		os.Exit(1)
+	}
+	mb := config.SpeedtestDefaultMB
+	if len(args) > 0 {
+		if mb, err = strconv.Atoi(args[0]); err != nil || mb <= 0 || int64(mb)<<20 > config.SpeedtestMaxBytes {
+			fmt.Fprintf(os.Stderr, "错误: 大小应为 1-%d 之间的 MiB 数\n", config.SpeedtestMaxBytes>>20)
+			os.Exit(1)
+		}
+	}
+
+	base := strings.TrimSuffix(st.ShareURL(), "/") + "/__speedtest__"
+	fmt.Printf("经 %s 测速，各传输 %d MiB...\n", st.PublicURL, mb)
+	if rate, err := speedtestRun(http.MethodGet, fmt.Sprintf("%s?mb=%d", base, mb), st.OwnerToken, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "下载测速失败: %v\n", err)
+	} else {
+		fmt.Printf("  下载: %s\n", formatRate(rate))
+	}
+	body := io.LimitReader(rand.Reader, int64(mb)<<20)
+	if rate, err := speedtestRun(http.MethodPost, base, st.OwnerToken, body); err != nil {
+		fmt.Fprintf(os.Stderr, "上传测速失败: %v\n", err)
+	} else {
+		fmt.Printf("  上传: %s\n", formatRate(rate))
+	}
+	fmt.Println("（两个方向都经过本机的网络，访问者的实际速度受本机上行带宽限制）")
+
+	expires := time.Now().Add(config.SpeedtestLinkTTL)
+	link := fmt.Sprintf("%s?%s&mb=%d", base, server.SpeedtestQuery(st.OwnerToken, expires), mb)
+	fmt.Printf("\n访问者测速链接（有效期至 %s）:\n  %s\n", expires.Format("15:04"), link)
+	fmt.Printf("命令行:\n  curl -o /dev/null -w '%%{speed_download} B/s\\n' '%s'\n", link)
+}
+
+// speedtestRun 发送一次测速请求，返回每秒传输的字节数: GET 按收到的响应体计算，
+// POST 按发出的请求体计算 (耗时包含等待服务端确认收完)
+func speedtestRun(method, target, ownerToken string, body io.Reader) (float64, error) {
+	var sent int64
+	if body != nil {
+		body = &countingReader{r: body, n: &sent}
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(health.OwnerHeader, ownerToken)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s", resp.Status)
+	}
+	received, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	n := received
+	if method == http.MethodPost {
+		n = sent
+	}
+	return float64(n) / time.Since(start).Seconds(), nil
+}
+
+// countingReader 统计已读取的字节数
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// formatRate 格式化传输速度，如 "12.50 MB/s (104.9 Mbit/s)"
+func formatRate(bytesPerSecond float64) string {
+	return fmt.Sprintf("%s/s (%.1f Mbit/s)", state.FormatBytes(int64(bytesPerSecond)), bytesPerSecond*8/1e6)
+}
+
+// cmdUser 管理分享口令之外的用户: 每个用户有自己的口令和权限 (只读或可上传，可限定分享项)，
+// 访问日志和 cfshare stats --by-user 按用户名区分谁下载了什么。
+// 口令只保存哈希，添加时显示一次。
+func cmdUser(args []string, password, passStyle string, passWords int, upload bool, items string) {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	st, err := state.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
 		os.Exit(1)
 	}
+	if st == nil || !st.IsRunning() {
+		fmt.Fprintln(os.Stderr, "当前无活动分享")
+		os.Exit(1)
+	}
+	if st.Mode != state.ModeProtected || st.Auth == state.AuthToken {
+		fmt.Fprintln(os.Stderr, "错误: 只有使用口令的分享可以添加用户（公开分享和 --auth token 不支持）")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		if len(st.Users) == 0 {
+			fmt.Println("没有添加用户，使用 cfshare user add <name> 添加")
+			return
+		}
+		for _, u := range st.Users {
+			fmt.Println(state.FormatUser(u))
+		}
+		return
+
+	case "add":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare user add <name> [--pass <password>] [--upload] [--items a,b]")
+			os.Exit(1)
+		}
+		name := args[1]
+		if strings.ContainsAny(name, ":\r\n") || name == st.Username {
+			fmt.Fprintf(os.Stderr, "错误: 用户名 %q 无效（不能包含冒号，也不能与分享的用户名相同）\n", name)
+			os.Exit(1)
+		}
+		u := auth.User{Name: name, Perm: auth.PermRead}
+		if upload {
+			u.Perm = auth.PermUpload
+		}
+		for _, item := range strings.Split(items, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			if !hasItem(st, item) {
+				fmt.Fprintf(os.Stderr, "错误: 分享中没有名为 %q 的项目\n", item)
+				os.Exit(1)
+			}
+			u.Items = append(u.Items, item)
+		}
+		if password == "" {
+			checkPassStyle(passStyle, passWords)
+			password = generatePassword(passStyle, passWords)
+		}
+		u.PasswordHash = auth.HashPassword(password)
+
+		if i := st.FindUser(name); i >= 0 {
+			st.Users[i] = u
+		} else {
+			st.Users = append(st.Users, u)
+		}
+		if err := st.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+			os.Exit(1)
+		}
+		reloadServer(st)
+		fmt.Printf("✅ 已添加用户 %s\n", state.FormatUser(u))
+		fmt.Printf("Username: %s\nPassword: %s\n", name, password)
+		fmt.Println("口令只保存哈希，不会再次显示，请现在交给对方")
+
+	case "rm":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "用法: cfshare user rm <name>")
+			os.Exit(1)
+		}
+		i := st.FindUser(args[1])
+		if i < 0 {
+			fmt.Fprintf(os.Stderr, "错误: 没有名为 %q 的用户\n", args[1])
+			os.Exit(1)
+		}
+		st.Users = append(st.Users[:i], st.Users[i+1:]...)
+		if err := st.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+			os.Exit(1)
+		}
+		reloadServer(st)
+		fmt.Printf("✅ 已删除用户 %s，其登录会话随之失效\n", args[1])
+
+	default:
+		fmt.Fprintln(os.Stderr, "用法: cfshare user [list | add <name> | rm <name>]")
+		os.Exit(1)
+	}
+}
 
-	if st == nil || !st.IsRunning() {
-		fmt.Fprintln(os.Stderr, "错误: 当前没有活动的分享")
-		os.Exit(1)
+// hasItem 判断分享中是否有名为 name 的项目
+func hasItem(st *state.State, name string) bool {
+	for _, item := range st.Items {
+		if item.Name == name {
+			return true
+		}
 	}
+	return false
+}
 
-	if len(st.Items) == 0 {
-		fmt.Fprintln(os.Stderr, "错误: 当前没有分享项")
+// generatePassword 按 --pass-style 生成随机口令，style 为空时使用字母和数字
+func generatePassword(style string, words int) string {
+	if style == auth.PassStyleWords {
+		return auth.GeneratePassphrase(words)
+	}
+	return auth.GeneratePassword(config.PasswordLength)
+}
+
+// checkPassStyle 检查 --pass-style 和 --pass-words，无效时退出
+func checkPassStyle(style string, words int) {
+	switch style {
+	case auth.PassStyleChars, auth.PassStyleWords:
+	default:
+		fmt.Fprintf(os.Stderr, "错误: 无效的 --pass-style: %s (可选 chars、words)\n", style)
+		os.Exit(1)
+	}
+	if style == auth.PassStyleWords && (words < 3 || words > 12) {
+		fmt.Fprintf(os.Stderr, "错误: --pass-words 应在 3 到 12 之间: %d\n", words)
 		os.Exit(1)
 	}
+}
 
-	// 构建要删除的名称集合
-	toRemove := make(map[string]bool)
-	for _, name := range names {
-		toRemove[name] = true
+// escrowPassword 把口令托管到系统钥匙串，之后可以从钥匙串再次查看；失败时口令只在本次显示
+func escrowPassword(st *state.State) {
+	if !keychain.Available() {
+		fmt.Fprintln(os.Stderr, "⚠️  系统钥匙串不可用，口令只在本次显示 (state.json 中只保存哈希)")
+		return
 	}
 
-	// 过滤保留的项
-	var remaining []state.ShareItem
-	var removed []string
-	for _, item := range st.Items {
-		if toRemove[item.Name] {
-			removed = append(removed, item.Name)
-		} else {
-			remaining = append(remaining, item)
-		}
+	account := "share-" + st.ShareID
+	if err := keychain.Set(account, st.Password); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️  无法写入系统钥匙串 (%v)，口令只在本次显示 (state.json 中只保存哈希)\n", err)
+		return
 	}
+	st.KeychainAccount = account
+}
 
-	if len(removed) == 0 {
-		fmt.Fprintln(os.Stderr, "错误: 未找到指定的项目")
-		fmt.Println("当前分享的项目:")
-		for _, item := range st.Items {
-			fmt.Printf("  - %s\n", item.Name)
+// escrowSecrets 让分享者令牌、访问令牌和签名密钥保存到系统钥匙串 (state.json 中只有令牌哈希)，
+// 钥匙串不可用或使用 --no-keychain 时保存在权限 0600 的 secrets.json
+func escrowSecrets(st *state.State, noKeychain bool) {
+	if !noKeychain && keychain.Available() {
+		st.SecretsAccount = "secrets-" + st.ShareID
+	}
+}
+
+// cmdRequest 创建一个仅允许上传的文件请求链接
+// cmdSend 把文本 (参数或 "-" 表示的标准输入) 保存到 ~/.cfshare/pastes 并作为文本片段分享
+func cmdSend(args []string, opts shareOptions) {
+	var text []byte
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-") {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 读取标准输入失败: %v\n", err)
+			os.Exit(1)
 		}
+		text = data
+	} else {
+		text = []byte(strings.Join(args, " ") + "\n")
+	}
+	if len(bytes.TrimSpace(text)) == 0 {
+		fmt.Fprintln(os.Stderr, "用法: cfshare send \"text\" 或 command | cfshare send -")
 		os.Exit(1)
 	}
 
-	if len(remaining) == 0 {
-		fmt.Fprintln(os.Stderr, "错误: 不能删除所有项目")
-		fmt.Fprintln(os.Stderr, "如需停止分享，请使用 cfshare stop")
+	dir := config.GetPastesDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无法创建目录: %v\n", err)
+		os.Exit(1)
+	}
+	if err := storage.Reserve(int64(len(text))); err != nil {
+		fmt.Fprintln(os.Stderr, "错误: ~/.cfshare 已达到存储配额 (--storage-quota)，请删除旧的文本片段或收件后重试")
+		os.Exit(1)
+	}
+	pastePath := filepath.Join(dir, "paste-"+time.Now().Format("20060102-150405")+".txt")
+	if err := os.WriteFile(pastePath, text, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存文本失败: %v\n", err)
 		os.Exit(1)
 	}
 
-	// 更新状态
-	st.Items = remaining
-	st.IsMulti = len(st.Items) > 1
+	opts.paste = true
+	cmdShare([]string{pastePath}, opts)
+}
 
-	// 单文件时更新兼容字段
-	if len(st.Items) == 1 {
-		st.Path = st.Items[0].Path
-		st.ShareType = st.Items[0].ShareType
+// requestOptions 是 cfshare request / cfshare receive 的参数
+type requestOptions struct {
+	message       string
+	inbox         string // 为空时在配置目录下新建收件目录 (cfshare request)
+	expires       string
+	maxUploads    int
+	quota         string
+	maxFileSize   string
+	maxRequest    string // 表单上传一个请求的总大小上限
+	port          int
+	routerPort    int
+	tunnelName    string
+	publicURL     string
+	quick         bool
+	protocol      string
+	tunnelToken   string
+	slug          bool
+	maxConns      int // 同时进行的上传总数上限
+	maxConnsPerIP int // 单个 IP 同时进行的上传上限
+	noKeychain    bool
+}
+
+// 参数生效值的来源，按优先级从低到高
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// nonSettings 是只对单次命令有意义的参数，不从配置文件或环境变量读取
+var nonSettings = map[string]bool{
+	"help": true, "h": true, "hc": true, "version": true, "v": true,
+	"force": true, "effective": true, "inplace": true, "analyze": true,
+	"upload": true, "items": true, "since": true, "period": true, "send": true, "create": true,
+}
+
+// secretSettings 是 config show 中需要隐藏取值的参数
+var secretSettings = map[string]bool{"pass": true, "tunnel-token": true}
+
+// applySettings 把配置文件 (~/.cfshare/config.json) 和 CFSHARE_* 环境变量中的值
+// 应用到命令行没有指定的参数上，优先级: 命令行 > 环境变量 > 配置文件 > 默认值。
+// 返回每个参数生效值的来源。
+func applySettings() (map[string]string, error) {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	file, err := config.LoadFile()
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	for name := range file {
+		if flag.Lookup(name) == nil || nonSettings[name] {
+			return nil, fmt.Errorf("配置文件 %s 中有未知参数: %s", config.GetConfigFilePath(), name)
+		}
 	}
 
-	if err := st.Save(); err != nil {
-		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
-		os.Exit(1)
+	sources := make(map[string]string)
+	var setErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		if nonSettings[f.Name] {
+			return
+		}
+		var values []string
+		switch {
+		case explicit[f.Name]:
+			sources[f.Name] = sourceFlag
+			return
+		case os.Getenv(config.EnvName(f.Name)) != "":
+			sources[f.Name] = sourceEnv
+			values = []string{os.Getenv(config.EnvName(f.Name))}
+		case len(file[f.Name]) > 0:
+			sources[f.Name] = sourceFile
+			values = file[f.Name]
+		default:
+			sources[f.Name] = sourceDefault
+			return
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil && setErr == nil {
+				setErr = fmt.Errorf("参数 %s 的值无效 (来自%s): %s", f.Name, sourceLabel(f.Name, sources[f.Name]), v)
+			}
+		}
+	})
+	return sources, setErr
+}
+
+// sourceLabel 返回参数来源的说明
+func sourceLabel(name, source string) string {
+	switch source {
+	case sourceFlag:
+		return "命令行"
+	case sourceEnv:
+		return "环境变量 " + config.EnvName(name)
+	case sourceFile:
+		return "配置文件"
+	default:
+		return "默认值"
 	}
+}
 
-	// 重启服务器以加载新配置
-	restartServer(st)
+// maskSecret 隐藏敏感取值，只显示是否已设置
+func maskSecret(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "********"
+}
 
-	fmt.Printf("✅ 已移除 %d 个项目\n", len(removed))
-	for _, name := range removed {
-		fmt.Printf("  - %s\n", name)
+// cmdConfigShow 显示配置文件；effective 为 true 时列出合并默认值、配置文件、
+// 环境变量和命令行之后每个参数的生效值及其来源
+func cmdConfigShow(effective bool, sources map[string]string) {
+	path := config.GetConfigFilePath()
+	if !effective {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("配置文件: %s (不存在)\n", path)
+			fmt.Println("键为参数名 (不带 --)，例如 {\"tunnel\": \"home\", \"max-conns\": 20}")
+			return
+		}
+		fmt.Printf("配置文件: %s\n\n%s\n", path, strings.TrimSpace(string(data)))
+		return
+	}
+
+	fmt.Printf("生效配置 (命令行 > 环境变量 %s* > 配置文件 %s > 默认值)\n", config.EnvPrefix, path)
+	fmt.Println("────────────────────────────────────────")
+	fmt.Printf("%-20s %-24s %s\n", "SETTING", "VALUE", "SOURCE")
+	flag.VisitAll(func(f *flag.Flag) {
+		source, ok := sources[f.Name]
+		if !ok {
+			return
+		}
+		value := f.Value.String()
+		if secretSettings[f.Name] {
+			value = maskSecret(value)
+		}
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("%-20s %-24s %s\n", f.Name, value, sourceLabel(f.Name, source))
+	})
+
+	fmt.Println("\n其他环境变量")
+	fmt.Println("────────────────────────────────────────")
+	for _, env := range []struct {
+		name   string
+		secret bool
+	}{
+		{config.StatePassphraseEnv, true},
+		{"AWS_ACCESS_KEY_ID", true},
+		{"AWS_SECRET_ACCESS_KEY", true},
+		{"AWS_REGION", false},
+		{"R2_ACCOUNT_ID", false},
+	} {
+		value := os.Getenv(env.name)
+		if env.secret {
+			value = maskSecret(value)
+		}
+		if value == "" {
+			value = "(未设置)"
+		}
+		fmt.Printf("%-24s %s\n", env.name, value)
 	}
-	fmt.Printf("\n剩余 %d 个分享项\n", len(st.Items))
 }
 
-func restartServer(st *state.State) {
-	// 停止旧服务器
-	if st.ServerPID > 0 {
-		stopProcess(st.ServerPID, false)
-		time.Sleep(300 * time.Millisecond)
+// parseMount 把 --mount 的取值 (docs、/docs、/docs/) 规范为不含斜杠的名称，为空表示不挂载
+func parseMount(mount string) (string, error) {
+	name := strings.Trim(mount, "/")
+	if name == "" {
+		return "", nil
+	}
+	valid := !strings.HasPrefix(name, "__") && !strings.HasPrefix(name, ".")
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			valid = false
+		}
+	}
+	if !valid {
+		return "", fmt.Errorf("%s (只能是一级路径，由字母、数字、.、_、- 组成)", mount)
 	}
+	return name, nil
+}
 
-	// 收集路径
-	var paths []string
-	for _, item := range st.Items {
-		paths = append(paths, item.Path)
+// mountPath 返回当前分享挂载的路径前缀，如 /docs，未挂载或使用独立主机名 (--hostname) 时为空
+func mountPath() string {
+	if config.Mount == "" || config.Hostname != "" {
+		return ""
 	}
+	return "/" + config.Mount
+}
 
-	// 重新启动服务器
-	username := st.Username
-	password := st.Password
+// otherShares 返回当前分享之外正在运行的分享的挂载前缀。
+// 刚停止的服务进程可能还没有完全退出，不能用 ActiveShares 判断自己是否还在运行。
+func otherShares() []string {
+	var others []string
+	for _, m := range state.ActiveShares() {
+		if m != config.Mount {
+			others = append(others, m)
+		}
+	}
+	return others
+}
+
+// formatMounts 把挂载前缀列表格式化为 "/, /docs/"，默认分享显示为 /，使用独立主机名的分享显示主机名
+func formatMounts(mounts []string) string {
+	hostnames := make(map[string]string)
+	for _, e := range state.LoadRegistry() {
+		hostnames[e.Mount] = e.Hostname
+	}
+	paths := make([]string, len(mounts))
+	for i, m := range mounts {
+		switch {
+		case hostnames[m] != "":
+			paths[i] = hostnames[m]
+		case m != "":
+			paths[i] = "/" + m + "/"
+		default:
+			paths[i] = "/"
+		}
+	}
+	return strings.Join(paths, ", ")
+}
+
+// withSlug 为 --slug 生成随机路径，返回加上它的公开地址和路径本身；未启用时原样返回公开地址
+func withSlug(publicURL string, enabled bool) (string, string) {
+	if !enabled {
+		return publicURL, ""
+	}
+	slug := auth.GenerateToken(config.SlugLength)
+	return strings.TrimSuffix(publicURL, "/") + "/" + slug, slug
+}
+
+// mountURL 在公开地址后加上挂载前缀
+func mountURL(publicURL string) string {
+	if mountPath() == "" {
+		return publicURL
+	}
+	return strings.TrimSuffix(publicURL, "/") + mountPath()
+}
+
+// knownCommands 是 cfshare 的子命令。其他第一个参数都是分享路径，遥测中只记为 share
+var knownCommands = map[string]bool{
+	"status": true, "stop": true, "setup": true, "doctor": true, "logs": true, "tunnel": true,
+	"stats": true, "digest": true, "receipts": true, "card": true, "reveal": true, "rotate-password": true, "owner": true,
+	"approve": true, "deny": true,
+	"qr": true, "export": true, "upgrade": true, "config": true, "standby": true,
+	"mirror": true, "add": true, "request": true, "receive": true, "send": true, "rm": true,
+	"cache": true, "review": true, "shares": true, "history": true, "user": true, "diff": true, "speedtest": true,
+}
+
+// recordUsage 在用户开启遥测时记录本次使用的命令和参数名 (不含参数值和路径)。
+// 只写入本地计数，到期的计数由服务进程发送 (telemetry.Watch)，命令行不等待网络
+func recordUsage(args []string) {
+	command := "status"
+	if len(args) > 0 {
+		command = "share"
+		if knownCommands[args[0]] {
+			command = args[0]
+		}
+	}
+	if len(args) > 0 && args[0] == "telemetry" {
+		return
+	}
+
+	features := []string{"command:" + command}
+	flag.Visit(func(f *flag.Flag) {
+		features = append(features, "flag:"+f.Name)
+	})
+	telemetry.Record(features...)
+}
+
+// effectiveTelemetryURL 返回遥测接收地址，CFSHARE_TELEMETRY_URL 优先于构建时的设置
+func effectiveTelemetryURL() string {
+	if url := os.Getenv(config.TelemetryURLEnv); url != "" {
+		return url
+	}
+	return telemetryURL
+}
+
+// cmdCache 显示或清除缓存: ~/.cfshare 下的磁盘缓存直接处理，
+// 服务进程内存中的缓存通过本机的 /__cache__ 接口处理 (需要分享者令牌)
+func cmdCache(args []string) {
+	action := "stats"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+
+	st, _ := state.Load()
+	running := st != nil && st.IsRunning() && st.OwnerToken != ""
+
+	switch action {
+	case "stats":
+		caches := storage.DiskCaches()
+		if running {
+			memory, err := serverCaches(st, http.MethodGet, "")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "警告: 无法获取服务进程的缓存: %v\n", err)
+			}
+			caches = append(caches, memory...)
+		}
+		fmt.Printf("%-12s %8s %12s  %s\n", "缓存", "条目", "大小", "位置")
+		for _, c := range caches {
+			where := c.Where
+			if where == "memory" {
+				where = "服务进程内存"
+			}
+			fmt.Printf("%-12s %8d %12s  %s\n", c.Name, c.Entries, state.FormatBytes(c.Bytes), where)
+		}
+		if !running {
+			fmt.Println("\n(没有运行中的分享，未显示服务进程内存中的缓存)")
+		}
+
+	case "clear":
+		cleared := 0
+		for _, c := range storage.DiskCaches() {
+			if name != "" && name != c.Name {
+				continue
+			}
+			if _, err := storage.ClearDiskCache(c.Name); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: 清除 %s 失败: %v\n", c.Name, err)
+				os.Exit(1)
+			}
+			fmt.Printf("✅ 已清除 %s: %d 个条目, %s\n", c.Name, c.Entries, state.FormatBytes(c.Bytes))
+			cleared++
+		}
+		if running && (name == "" || !storage.IsDiskCache(name)) {
+			before, _ := serverCaches(st, http.MethodGet, "")
+			if _, err := serverCaches(st, http.MethodPost, name); err != nil {
+				fmt.Fprintf(os.Stderr, "错误: 清除服务进程的缓存失败: %v\n", err)
+				os.Exit(1)
+			}
+			for _, c := range before {
+				if name == "" || name == c.Name {
+					fmt.Printf("✅ 已清除 %s: %d 个条目, %s\n", c.Name, c.Entries, state.FormatBytes(c.Bytes))
+					cleared++
+				}
+			}
+		}
+		if cleared == 0 {
+			fmt.Fprintf(os.Stderr, "错误: 未知的缓存 %q (或没有运行中的分享)\n", name)
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, "用法: cfshare cache stats|clear [name]")
+		os.Exit(1)
+	}
+}
 
-	serverPID, err := startServerProcess(paths, st.Port, username, password)
+// serverCaches 请求服务进程的 /__cache__: GET 返回内存缓存统计，POST 清除名为 name 的缓存 (为空时全部)
+func serverCaches(st *state.State, method, name string) ([]storage.Cache, error) {
+	target := fmt.Sprintf("http://127.0.0.1:%d/__cache__", st.Port)
+	if name != "" {
+		target += "?name=" + url.QueryEscape(name)
+	}
+	req, err := http.NewRequest(method, target, nil)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "错误: 重启服务器失败: %v\n", err)
+		return nil, err
+	}
+	req.Header.Set(health.OwnerHeader, st.OwnerToken)
+	resp, err := (&http.Client{Timeout: config.StandbyTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var caches []storage.Cache
+	if err := json.NewDecoder(resp.Body).Decode(&caches); err != nil {
+		return nil, err
+	}
+	return caches, nil
+}
+
+// cmdTelemetry 查看或切换匿名使用统计 (默认关闭)
+func cmdTelemetry(args []string) {
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "on":
+		if err := telemetry.SetEnabled(true); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 保存设置失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("✅ 已开启匿名使用统计")
+		fmt.Println("只记录命令和参数名的使用次数，不含路径、URL、口令或参数值；每 7 天汇总发送一次")
+		fmt.Println("查看将要发送的内容: cfshare telemetry status")
+	case "off":
+		if err := telemetry.SetEnabled(false); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 保存设置失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("✅ 已关闭匿名使用统计，未发送的计数已删除")
+	case "status":
+		d, err := telemetry.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 读取设置失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(d.Format(effectiveTelemetryURL(), version))
+	default:
+		fmt.Fprintln(os.Stderr, "用法: cfshare telemetry status|on|off")
 		os.Exit(1)
 	}
+}
 
-	st.ServerPID = serverPID
-	st.Save()
+// flagPassed 判断命令行中是否显式指定了某个参数
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
 }
 
-func cmdShare(paths []string, public bool, password string, port int, tunnelName, publicURL string) {
-	// 验证所有路径存在
-	for _, path := range paths {
-		if _, err := os.Stat(path); err != nil {
-			fmt.Fprintf(os.Stderr, "错误: 路径不存在: %s\n", path)
+func cmdRequest(opts requestOptions) {
+	var ttl time.Duration
+	if opts.expires != "" {
+		var err error
+		ttl, err = parseDuration(opts.expires)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的有效期 '%s': %v\n", opts.expires, err)
 			os.Exit(1)
 		}
 	}
 
-	// 检查名称冲突
-	names := make(map[string]string)
-	for _, path := range paths {
-		absPath, _ := filepath.Abs(path)
-		name := filepath.Base(absPath)
-		if existing, ok := names[name]; ok {
-			fmt.Fprintf(os.Stderr, "错误: 名称冲突: '%s'\n", name)
-			fmt.Fprintf(os.Stderr, "  - %s\n", existing)
-			fmt.Fprintf(os.Stderr, "  - %s\n", absPath)
-			fmt.Fprintln(os.Stderr, "请重命名文件后再试")
+	var quotaBytes, maxFileBytes int64
+	if opts.quota != "" {
+		var err error
+		if quotaBytes, err = parseSize(opts.quota); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --quota: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.maxFileSize != "" {
+		var err error
+		if maxFileBytes, err = parseSize(opts.maxFileSize); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --max-file-size: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var maxRequestBytes int64
+	if opts.maxRequest != "" {
+		var err error
+		if maxRequestBytes, err = parseSize(opts.maxRequest); err != nil || maxRequestBytes <= 0 {
+			fmt.Fprintf(os.Stderr, "错误: 无效的 --max-request-size: %s\n", opts.maxRequest)
+			os.Exit(1)
+		}
+	}
+
+	shareID := fmt.Sprintf("%d", time.Now().Unix())
+	inbox := opts.inbox
+	if inbox == "" {
+		inbox = filepath.Join(config.GetRequestsDir(), shareID)
+	} else {
+		abs, err := filepath.Abs(inbox)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "错误: 无效的路径 '%s': %v\n", inbox, err)
+			os.Exit(1)
+		}
+		inbox = abs
+		if info, err := os.Stat(inbox); err == nil && !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "错误: '%s' 不是目录\n", inbox)
 			os.Exit(1)
 		}
-		names[name] = absPath
 	}
 
+	// 上一个分享的 "分享已结束" 提示进程占用着端口，新分享沿用它保留的 tunnel
+	stopEndedResponder()
 	existingState, _ := state.Load()
 	if existingState != nil && existingState.IsRunning() {
 		fmt.Println("正在停止现有分享...")
-		cmdStop(false)
+		stopShare(false, false)
 		time.Sleep(500 * time.Millisecond)
 	}
 
-	username := ""
-	if !public {
-		username = config.DefaultUsername
-		if password == "" {
-			password = auth.GeneratePassword(config.PasswordLength)
-		}
-	}
-
-	if publicURL == "" {
-		tm := tunnel.NewManager(tunnelName)
+	publicURL := opts.publicURL
+	if publicURL == "" && !opts.quick {
+		tm := tunnel.NewManager(opts.tunnelName)
+		tm.SetToken(opts.tunnelToken)
 		var err error
-		publicURL, err = tm.GetPublicURL()
+		publicURL, err = tm.GetPublicURL(ingressPort(opts.port, opts.routerPort))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "错误: 无法获取公开 URL: %v\n", err)
 			fmt.Fprintln(os.Stderr, "请使用 --url 参数指定公开 URL")
 			os.Exit(1)
 		}
 	}
+	publicURL = mountURL(publicURL)
+
+	if err := os.MkdirAll(inbox, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无法创建收件目录: %v\n", err)
+		os.Exit(1)
+	}
+	quickPID := 0
+	if opts.quick {
+		quickPID, publicURL = startQuickTunnel(opts.port, opts.protocol)
+	}
+	publicURL, slugPath := withSlug(publicURL, opts.slug)
 
 	st := &state.State{
-		ShareID:   fmt.Sprintf("%d", time.Now().Unix()),
-		Port:      port,
-		StartTime: time.Now(),
-		PublicURL: publicURL,
+		ShareID:        shareID,
+		Mode:           state.ModeRequest,
+		Port:           opts.port,
+		StartTime:      time.Now(),
+		PublicURL:      publicURL,
+		TunnelName:     opts.tunnelName,
+		TunnelProtocol: opts.protocol,
+		TunnelToken:    opts.tunnelToken,
+		Quick:          opts.quick,
+		Mount:          mountPath(),
+		Hostname:       config.Hostname,
+		Slug:           slugPath,
+		RouterPort:     opts.routerPort,
+		RequestToken:   auth.GenerateToken(config.TokenLength),
+		RequestMessage: opts.message,
+		MaxUploads:     opts.maxUploads,
+		MaxFileSize:    maxFileBytes,
+		MaxRequestSize: maxRequestBytes,
+		UploadQuota:    quotaBytes,
+		MaxConns:       opts.maxConns,
+		MaxConnsPerIP:  opts.maxConnsPerIP,
+		Items: []state.ShareItem{{
+			Path:      inbox,
+			Name:      filepath.Base(inbox),
+			ShareType: state.TypeDir,
+		}},
+	}
+	if ttl > 0 {
+		st.ExpiresAt = st.StartTime.Add(ttl)
 	}
+	escrowSecrets(st, opts.noKeychain)
 
-	if public {
-		st.Mode = state.ModePublic
-	} else {
-		st.Mode = state.ModeProtected
-		st.Username = username
-		st.Password = password
+	// 服务器进程启动时读取状态文件，需要先保存
+	if err := st.Save(); err != nil {
+		stopQuickTunnel(quickPID)
+		fmt.Fprintf(os.Stderr, "错误: 保存状态失败: %v\n", err)
+		os.Exit(1)
 	}
 
-	serverPID, err := startServerProcess(paths, port, username, password)
+	serverPID, err := startServerProcess([]string{inbox}, opts.port)
 	if err != nil {
+		stopQuickTunnel(quickPID)
+		state.Clear()
 		fmt.Fprintf(os.Stderr, "错误: 启动服务器失败: %v\n", err)
 		os.Exit(1)
 	}
 	st.ServerPID = serverPID
+	routeShare(st)
 
-	tm := tunnel.NewManager(tunnelName)
-	tunnelPID, err := tm.Start()
-	if err != nil {
-		stopProcess(serverPID, true)
-		fmt.Fprintf(os.Stderr, "错误: 启动 tunnel 失败: %v\n", err)
-		os.Exit(1)
+	tunnelPID := quickPID
+	if tunnelPID == 0 {
+		routeHostname(opts.tunnelName)
+		tm := tunnel.NewManager(opts.tunnelName)
+		tm.SetOrigins(tunnelOrigins(st))
+		tm.SetProtocol(opts.protocol)
+		tm.SetToken(opts.tunnelToken)
+		if tunnelPID, err = tm.Start(); err != nil {
+			stopProcess(serverPID, true)
+			state.Clear()
+			fmt.Fprintf(os.Stderr, "错误: 启动 tunnel 失败: %v\n", err)
+			os.Exit(1)
+		}
 	}
 	st.TunnelPID = tunnelPID
 
-	// 构建 Items 列表
-	var items []state.ShareItem
-	for _, path := range paths {
-		absPath, _ := filepath.Abs(path)
-		fi, _ := os.Stat(absPath)
-		item := state.ShareItem{
-			Path: absPath,
-			Name: filepath.Base(absPath),
+	if err := st.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 保存状态失败: %v\n", err)
+	}
+
+	if err := state.Register(st); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 登记分享失败: %v\n", err)
+	}
+
+	fmt.Print(st.FormatShareOutput())
+}
+
+// parseDuration 在 time.ParseDuration 基础上支持天 (d) 单位，如 "2d"、"1d12h"
+func parseDuration(s string) (time.Duration, error) {
+	var days int
+	if i := strings.Index(s, "d"); i > 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid day count %q", s[:i])
 		}
-		if fi.IsDir() {
-			item.ShareType = state.TypeDir
-			item.Size = 0
-		} else {
-			item.ShareType = state.TypeFile
-			item.Size = fi.Size()
+		days = n
+		s = s[i+1:]
+	}
+
+	d := time.Duration(days) * 24 * time.Hour
+	if s != "" {
+		rest, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, err
 		}
-		items = append(items, item)
+		d += rest
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
 	}
+	return d, nil
+}
 
-	st.Items = items
-	st.IsMulti = len(items) > 1
+// languageCodes 返回网页界面支持的语言代码
+func languageCodes() []string {
+	var codes []string
+	for _, l := range i18n.Languages() {
+		codes = append(codes, l.Code)
+	}
+	return codes
+}
 
-	// 单文件时填充兼容字段
-	if len(items) == 1 {
-		st.Path = items[0].Path
-		st.ShareType = items[0].ShareType
+// parseSize 解析 "500MB"、"2GB"、"1.5G" 这样的大小，单位按 1024 进制，无单位时为字节
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
 	}
+	return int64(n * mult), nil
+}
 
-	if err := st.Save(); err != nil {
-		fmt.Fprintf(os.Stderr, "警告: 保存状态失败: %v\n", err)
+// parseMaxDownloads 解析 --max-downloads: "3" 为所有下载合计 3 次，"1/item" 为每个分享项各 1 次
+func parseMaxDownloads(s string) (n int, perItem bool, err error) {
+	if s == "" {
+		return 0, false, nil
+	}
+	s, perItem = strings.CutSuffix(s, "/item")
+	n, err = strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, false, fmt.Errorf("expected a positive count or <n>/item, got %q", s)
 	}
+	return n, perItem, nil
+}
 
-	fmt.Print(st.FormatShareOutput())
+// parseLimitRate 解析 --limit-rate: "5MB/s" 为所有连接合计的上限，
+// "5MB/s,1MB/s" 同时限制单个连接，"0,1MB/s" 只限制单个连接
+func parseLimitRate(s string) (total, perConn int64, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("expected <total>[,<per-connection>], got %q", s)
+	}
+	rates := make([]int64, 2)
+	for i, part := range parts {
+		part = strings.TrimSuffix(strings.TrimSpace(part), "/s")
+		if rates[i], err = parseSize(part); err != nil {
+			return 0, 0, err
+		}
+	}
+	if rates[0] == 0 && rates[1] == 0 {
+		return 0, 0, fmt.Errorf("rate must be positive")
+	}
+	return rates[0], rates[1], nil
 }
 
-func startServerProcess(paths []string, port int, username, password string) (int, error) {
+func startServerProcess(paths []string, port int) (int, error) {
 	exe, err := os.Executable()
 	if err != nil {
 		return 0, fmt.Errorf("get executable: %w", err)
 	}
+	cmd, err := spawnServerProcess(exe, paths, port, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	return cmd.Process.Pid, nil
+}
 
+// spawnServerProcess 用可执行文件 exe 在后台启动服务进程并写入 PID 文件。
+// listener 不为 nil 时新进程接管这个监听 socket (平滑升级)，不再自己绑定端口。
+// 用户名和口令哈希不放在命令行参数中 (其他本机用户可以用 ps 看到)，服务进程从 state.json 读取
+func spawnServerProcess(exe string, paths []string, port int, listener *os.File) (*exec.Cmd, error) {
 	// 使用 JSON + base64 编码传递多路径
 	pathsJSON, _ := json.Marshal(paths)
 	pathsArg := base64.StdEncoding.EncodeToString(pathsJSON)
-	args := []string{"__server__", pathsArg, strconv.Itoa(port), username, password}
+	args := []string{"__server__", pathsArg, strconv.Itoa(port)}
 	cmd := exec.Command(exe, args...)
 
 	setProcAttr(cmd)
 
-	logPath := config.GetConfigDir() + "/server.log"
+	logPath := config.GetServerLogPath()
 	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
 	if err != nil {
-		return 0, fmt.Errorf("create log file: %w", err)
+		return nil, fmt.Errorf("create log file: %w", err)
 	}
 
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
+	// 流式分享由服务器进程读取标准输入，CLI 退出后管道仍由服务器进程持有
+	if len(paths) == 1 && paths[0] == server.StdinPath {
+		cmd.Stdin = os.Stdin
+	}
+	if listener != nil {
+		// ExtraFiles 中的第一个文件在子进程中是描述符 3
+		cmd.ExtraFiles = []*os.File{listener}
+		cmd.Env = append(os.Environ(), config.ListenFDEnv+"=3")
+	}
 
 	if err := cmd.Start(); err != nil {
 		logFile.Close()
-		return 0, fmt.Errorf("start server: %w", err)
+		return nil, fmt.Errorf("start server: %w", err)
 	}
 
-	pid := cmd.Process.Pid
-
-	os.WriteFile(config.GetPidFilePath(), []byte(strconv.Itoa(pid)), 0600)
+	os.WriteFile(config.GetPidFilePath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0600)
 
-	time.Sleep(300 * time.Millisecond)
+	return cmd, nil
+}
 
-	return pid, nil
+// reloadItems 在服务进程中重新读取 state.json，替换分享项并更新到期时间 (cfshare review 延长后)，
+// 分享项无效时保留原来的分享项
+func reloadItems(srv *server.Server) {
+	st, err := state.Load()
+	if err != nil || st == nil {
+		fmt.Fprintf(os.Stderr, "reload: read state: %v\n", err)
+		return
+	}
+	srv.SetStopAt(st.StopAt)
+	srv.SetUsers(st.Users)
+	var paths []string
+	for _, item := range st.Items {
+		paths = append(paths, item.Path)
+	}
+	if err := srv.Reload(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "reload: %v\n", err)
+		return
+	}
+	fmt.Printf("Reloaded shared items: %v\n", paths)
 }
 
 func runServerProcess() {
@@ -639,17 +4421,12 @@ func runServerProcess() {
 	var paths []string
 	json.Unmarshal(decoded, &paths)
 	port, _ := strconv.Atoi(os.Args[3])
-	username := ""
-	password := ""
-	if len(os.Args) >= 6 {
-		username = os.Args[4]
-		password = os.Args[5]
-	}
 
 	st, err := state.Load()
 	if err != nil || st == nil {
 		st = &state.State{}
 	}
+	storage.Quota = st.StorageQuota
 
 	srv, err := server.NewServer(paths, st)
 	if err != nil {
@@ -659,22 +4436,493 @@ func runServerProcess() {
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, getSignals()...)
+	upgradeChan := make(chan os.Signal, 1)
+	if sig := upgradeSignal(); sig != nil {
+		signal.Notify(upgradeChan, sig)
+	}
+	reloadChan := make(chan os.Signal, 1)
+	if sig := reloadSignal(); sig != nil {
+		signal.Notify(reloadChan, sig)
+	}
+	go func() {
+		for range reloadChan {
+			reloadItems(srv)
+		}
+	}()
+
+	// 下载次数达到 --max-downloads 或到达 --expire 时间后由服务进程自行结束分享
+	srv.OnDownloadLimit(stopShareFromServer)
+	srv.ScheduleExpiry(stopShareFromServer)
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	go srv.WatchEdge(watchCtx, func() error {
+		return restartTunnel(st)
+	})
+	go srv.WatchTunnel(watchCtx, func() server.TunnelCheck {
+		return checkTunnel(st)
+	}, func() error {
+		return restartTunnel(st)
+	})
+	go srv.WatchResources(watchCtx)
+	go srv.WatchFiles(watchCtx)
+	go srv.ServeDebug(watchCtx)
+	go srv.ServeMetrics(watchCtx)
+	go telemetry.Watch(watchCtx, effectiveTelemetryURL(), version)
+
+	ln, err := server.Listen(port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
+		os.Exit(1)
+	}
+	if upgradeSignal() != nil {
+		if err := state.SaveHandoff(state.Handoff{PID: os.Getpid()}); err != nil {
+			fmt.Fprintf(os.Stderr, "save handoff: %v\n", err)
+		}
+	}
 
 	go func() {
-		<-sigChan
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		timeout := 5 * time.Second
+	wait:
+		for {
+			select {
+			case <-sigChan:
+				break wait
+			case <-upgradeChan:
+				if err := handOffListener(ln); err != nil {
+					fmt.Fprintf(os.Stderr, "upgrade: %v\n", err)
+					continue
+				}
+				// 新服务进程已接管监听 socket，不再接受新连接，等进行中的传输完成
+				fmt.Println("Draining: waiting for in-flight requests before exiting")
+				timeout = config.UpgradeDrainTimeout
+				break wait
+			}
+		}
+		stopWatch()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 		srv.Shutdown(ctx)
 		os.Exit(0)
 	}()
 
 	fmt.Printf("Starting server on port %d for paths: %v\n", port, paths)
-	if err := srv.Start(port, username, password); err != nil {
+	if err := srv.Serve(ln, st.Username, st.PasswordHash); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			// 由上面的 goroutine 在 Shutdown 完成后退出
+			select {}
+		}
 		fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// runEndedProcess 是 "分享已结束" 提示进程：在原端口上对所有请求返回 410，
+// 到达 ended.json 中的 Until 后停止 tunnel 并退出
+func runEndedProcess() {
+	e := state.LoadEnded()
+	if e == nil {
+		fmt.Fprintln(os.Stderr, "ended responder: no ended.json")
+		os.Exit(1)
+	}
+
+	ln, err := server.Listen(e.Port)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ended responder: %v\n", err)
+		state.ClearEnded()
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: server.EndedHandler(e), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	fmt.Printf("Serving share-ended page on port %d until %s\n", e.Port, e.Until.Format(time.RFC3339))
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, getSignals()...)
+	select {
+	case <-sigChan:
+		// cfshare stop 或新分享停止了提示进程，tunnel 由它们处理
+	case <-time.After(time.Until(e.Until)):
+		state.ClearEnded()
+		stopTunnel(false)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
+
+// runRouterProcess 是路由进程的入口，监听 cloudflared 指向的端口，
+// 把请求转发给 route.json 登记的、正在运行的分享
+func runRouterProcess(portArg string) {
+	port, err := strconv.Atoi(portArg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "router: invalid port %q\n", portArg)
+		os.Exit(1)
+	}
+	// 端口仍被不经路由进程的分享占用时直接失败
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "router: %v\n", err)
+		os.Exit(1)
+	}
+	if err := state.SaveRouter(&state.Router{PID: os.Getpid(), Port: port}); err != nil {
+		fmt.Fprintf(os.Stderr, "router: %v\n", err)
+		os.Exit(1)
+	}
+	srv := &http.Server{Handler: server.RouterHandler(state.LoadRoutes), ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	fmt.Printf("Routing shares on port %d\n", port)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, getSignals()...)
+	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	if r := state.LoadRouter(); r != nil && r.PID == os.Getpid() {
+		state.ClearRouter()
+	}
+}
+
+// stopShareFromServer 由服务进程发起 cfshare stop，与手动停止走同一清理流程
+// (停止 tunnel、汇总、删除口令和状态)。stop 会终止服务进程，因此放在独立的进程组中运行。
+func stopShareFromServer() {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stop share: %v\n", err)
+		return
+	}
+	cmd := exec.Command(exe, "stop")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	setProcAttr(cmd)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "stop share: %v\n", err)
+	}
+}
+
+// handOffListener 在服务进程中处理升级信号: 用 cfshare upgrade 记录的可执行文件启动新服务进程，
+// 把监听 socket 传给它 (ExtraFiles)，确认新进程没有退出后在状态中换成它的 PID。
+// 返回错误时新进程没有接管端口，当前进程继续服务。
+func handOffListener(ln net.Listener) error {
+	st, err := state.Load()
+	if err != nil || st == nil {
+		return fmt.Errorf("read state: %v", err)
+	}
+	exe, err := os.Executable()
+	if h := state.LoadHandoff(); h != nil && h.Exe != "" {
+		exe, err = h.Exe, nil
+	}
+	if err != nil {
+		return fmt.Errorf("get executable: %w", err)
+	}
+	f, err := server.ListenerFile(ln)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var paths []string
+	for _, item := range st.Items {
+		paths = append(paths, item.Path)
+	}
+	cmd, err := spawnServerProcess(exe, paths, st.Port, f)
+	if err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		os.WriteFile(config.GetPidFilePath(), []byte(strconv.Itoa(os.Getpid())), 0600)
+		state.SaveHandoff(state.Handoff{PID: os.Getpid()})
+		return fmt.Errorf("new server process exited during startup, see %s", config.GetServerLogPath())
+	case <-time.After(config.UpgradeReadyWait):
+	}
+
+	st.ServerPID = cmd.Process.Pid
+	return st.Save()
+}
+
+// cmdUpgrade 用当前的 cfshare 可执行文件替换运行中的服务进程而不中断下载:
+// 通知旧服务进程用新的可执行文件启动服务进程并把监听 socket 交给它，旧进程随后停止接受新连接、
+// 等进行中的传输完成后退出，状态中换成新进程的 PID。tunnel 不受影响。
+func cmdUpgrade(inplace bool) {
+	if !inplace {
+		fmt.Fprintln(os.Stderr, "用法: cfshare upgrade --inplace")
+		fmt.Fprintln(os.Stderr, "先替换 cfshare 可执行文件，再运行此命令让正在运行的分享切换到新版本")
+		os.Exit(1)
+	}
+	if upgradeSignal() == nil {
+		fmt.Fprintln(os.Stderr, "错误: 当前系统不支持平滑升级，请使用 cfshare stop 后重新分享")
+		os.Exit(1)
+	}
+
+	st, err := state.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 读取状态失败: %v\n", err)
+		os.Exit(1)
+	}
+	if !st.IsRunning() {
+		fmt.Fprintln(os.Stderr, "错误: 当前无运行中的分享")
+		os.Exit(1)
+	}
+	if st.ShareType == state.TypeStream {
+		fmt.Fprintln(os.Stderr, "错误: 标准输入流由当前服务进程持有，无法平滑升级")
+		os.Exit(1)
+	}
+
+	oldPID := st.ServerPID
+
+	// 不支持交接的旧版本收到信号会直接退出，先确认服务进程登记过交接记录
+	if h := state.LoadHandoff(); h == nil || h.PID != oldPID {
+		fmt.Fprintln(os.Stderr, "错误: 运行中的服务进程由不支持平滑升级的版本启动，请使用 cfshare stop 后重新分享")
+		os.Exit(1)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+		os.Exit(1)
+	}
+	if err := state.SaveHandoff(state.Handoff{PID: oldPID, Exe: exe}); err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 保存交接记录失败: %v\n", err)
+		os.Exit(1)
+	}
+	process, err := os.FindProcess(oldPID)
+	if err == nil {
+		err = process.Signal(upgradeSignal())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 无法通知服务进程: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 旧进程确认新进程启动后在状态中换成新的 PID
+	deadline := time.Now().Add(config.UpgradeHandoffTimeout)
+	for {
+		time.Sleep(200 * time.Millisecond)
+		if cur, err := state.Load(); err == nil && cur != nil && cur.ServerPID != oldPID {
+			st = cur
+			break
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintln(os.Stderr, "错误: 新服务进程没有接管端口，旧进程继续运行")
+			fmt.Fprintf(os.Stderr, "详情见 %s\n", config.GetServerLogPath())
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("✅ 已切换到新服务进程 (PID %d)\n", st.ServerPID)
+	fmt.Printf("旧进程 (PID %d) 不再接受新连接，进行中的下载完成后退出（最长 %s）\n", oldPID, config.UpgradeDrainTimeout)
+}
+
+// ingressPort 返回 cloudflared 应转发到的本机端口: 经路由进程 (--router) 时为路由进程的端口
+func ingressPort(port, routerPort int) int {
+	if routerPort != 0 {
+		return routerPort
+	}
+	return port
+}
+
+// tunnelOrigin 返回 cloudflared 应转发到的本机服务: 经路由进程 (--router) 时为路由进程的端口，
+// 否则为分享自己的端口和挂载路径
+func tunnelOrigin(st *state.State) tunnel.Origin {
+	origin := tunnel.Origin{Path: st.Mount, Port: st.Port}
+	if st.RouterPort != 0 {
+		origin = tunnel.Origin{Port: st.RouterPort}
+	}
+	if u, err := url.Parse(st.PublicURL); err == nil {
+		origin.Hostname = u.Hostname()
+	}
+	return origin
+}
+
+// tunnelOrigins 返回 st 和其他正在运行的分享的本机服务，cloudflared 配置中为每个分享生成 ingress 规则
+func tunnelOrigins(st *state.State) []tunnel.Origin {
+	origins := []tunnel.Origin{tunnelOrigin(st)}
+	for _, e := range state.LoadRegistry() {
+		if e.Mount == config.Mount || !e.Running() {
+			continue
+		}
+		o := tunnel.Origin{Port: e.Port}
+		if e.RouterPort != 0 {
+			o.Port = e.RouterPort
+		} else if e.Mount != "" && e.Hostname == "" {
+			o.Path = "/" + e.Mount
+		}
+		if u, err := url.Parse(e.URL); err == nil {
+			o.Hostname = u.Hostname()
+		}
+		if !slices.Contains(origins, o) {
+			origins = append(origins, o)
+		}
+	}
+	return origins
+}
+
+// routeHostname 为 --hostname 的主机名创建 DNS 记录，cloudflared 配置中已有该主机名时认为已经配置过。
+// 失败时只给出警告，记录可能已由用户以其他方式创建。
+func routeHostname(tunnelName string) {
+	if config.Hostname == "" {
+		return
+	}
+	if path := tunnel.UserConfigPath(); path != "" {
+		if cfg, err := tunnel.LoadConfig(path); err == nil && slices.Contains(cfg.Hostnames(), config.Hostname) {
+			return
+		}
+	}
+	if err := tunnel.RouteDNS(tunnelName, config.Hostname); err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 无法为 %s 创建 DNS 记录: %v\n", config.Hostname, err)
+	}
+}
+
+// startQuickTunnel 为 --quick 启动 Quick Tunnel，返回 cloudflared 的 PID 和分配的 trycloudflare.com 地址
+func startQuickTunnel(port int, protocol string) (int, string) {
+	if others := otherShares(); len(others) > 0 {
+		fmt.Fprintf(os.Stderr, "错误: tunnel 正被其他分享使用 (%s)，请先停止它们再使用 --quick\n", formatMounts(others))
+		os.Exit(1)
+	}
+	// "分享已结束" 提示进程可能保留着命名 tunnel
+	stopTunnel(false)
+	fmt.Println("正在启动 Quick Tunnel (trycloudflare.com)...")
+	tm := tunnel.NewQuickManager(fmt.Sprintf("http://localhost:%d", port))
+	tm.SetProtocol(protocol)
+	pid, publicURL, err := tm.StartQuick()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: 启动 Quick Tunnel 失败: %v\n", err)
+		os.Exit(1)
+	}
+	return pid, publicURL
+}
+
+// stopQuickTunnel 在分享启动失败时停止已经启动的 Quick Tunnel，pid 为 0 时什么也不做
+func stopQuickTunnel(pid int) {
+	if pid > 0 {
+		tunnel.NewManager(config.TunnelName).ForceStop()
+	}
+}
+
+// lastTunnelRestart 是本进程最近一次重启 tunnel 的时间。公开地址和边缘连接的检测可能
+// 同时发现同一次故障，间隔不到 RestartMinBackoff 的重启只执行一次。
+var lastTunnelRestart struct {
+	sync.Mutex
+	at time.Time
+}
+
+// restartTunnel 由服务进程在公开地址不可达或 cloudflared 没有边缘连接时调用，
+// 重启 cloudflared 并更新状态中的 PID。
+// Quick Tunnel 重启后地址会改变，已发出的链接随之失效，因此不自动重启。
+func restartTunnel(st *state.State) error {
+	if st.Quick {
+		return fmt.Errorf("Quick Tunnel 重启后地址会改变，不自动重启；请重新运行 cfshare 分享")
+	}
+	lastTunnelRestart.Lock()
+	defer lastTunnelRestart.Unlock()
+	if time.Since(lastTunnelRestart.at) < config.RestartMinBackoff {
+		return nil
+	}
+	lastTunnelRestart.at = time.Now()
+
+	tunnelName := st.TunnelName
+	if tunnelName == "" {
+		tunnelName = config.TunnelName
+	}
+	tm := tunnel.NewManager(tunnelName)
+	tm.SetOrigins(tunnelOrigins(st))
+	tm.SetProtocol(st.TunnelProtocol)
+	tm.SetToken(st.TunnelToken)
+	tm.Stop()
+	pid, err := tm.Start()
+	if err != nil {
+		return err
+	}
+	if st, err := state.Load(); err == nil && st != nil {
+		st.TunnelPID = pid
+		st.Save()
+	}
+	return nil
+}
+
+// checkTunnel 读取分享使用的 cloudflared 注册到边缘的连接数，供服务进程的 WatchTunnel 判断
+func checkTunnel(st *state.State) server.TunnelCheck {
+	tunnelName := st.TunnelName
+	if tunnelName == "" {
+		tunnelName = config.TunnelName
+	}
+	n, source, err := tunnel.NewManager(tunnelName).Connections()
+	if errors.Is(err, tunnel.ErrNotRunning) {
+		return server.TunnelCheck{}
+	}
+	return server.TunnelCheck{Running: true, Connections: n, Source: source, Err: err}
+}
+
+// valueFlags 是需要带值的 flag，重排参数时值要跟随 flag 一起移动
+var valueFlags = map[string]bool{
+	"--pass":              true,
+	"--pass-style":        true,
+	"--pass-words":        true,
+	"--port":              true,
+	"--tunnel":            true,
+	"--url":               true,
+	"--mount":             true,
+	"--hostname":          true,
+	"--protocol":          true,
+	"--tunnel-token":      true,
+	"--items":             true,
+	"--expires":           true,
+	"--max-uploads":       true,
+	"--quota":             true,
+	"--max-file-size":     true,
+	"--max-request-size":  true,
+	"--max-duration":      true,
+	"--min-rate":          true,
+	"--limit-rate":        true,
+	"--storage-quota":     true,
+	"--stats-backend":     true,
+	"--stats-retention":   true,
+	"--since":             true,
+	"--metrics-addr":      true,
+	"--debug-addr":        true,
+	"--max-downloads":     true,
+	"--expire":            true,
+	"--ended-grace":       true,
+	"--max-conns":         true,
+	"--max-conns-per-ip":  true,
+	"--terms":             true,
+	"--notify":            true,
+	"--realm":             true,
+	"--auth":              true,
+	"--session-lifetime":  true,
+	"--theme":             true,
+	"--title":             true,
+	"--message":           true,
+	"--footer":            true,
+	"--tz":                true,
+	"--lang":              true,
+	"--exclude":           true,
+	"--allow-ip":          true,
+	"--serve-window":      true,
+	"--max-auth-failures": true,
+	"--req-rate":          true,
+	"--waiting-room":      true,
+	"--block-ip":          true,
+	"--allow-country":     true,
+	"--block-country":     true,
+	"--split-size":        true,
+	"--confirm-size":      true,
+	"--contact":           true,
+	"--format":            true,
+	"--period":            true,
+	"--create":            true,
+	"--secret-via":        true,
+	"--r2":                true,
+	"--endpoint":          true,
+	"--as":                true,
+}
+
 // reorderArgs 重排参数，让 flags 在位置参数之前
 func reorderArgs() {
 	if len(os.Args) <= 2 {
@@ -686,10 +4934,10 @@ func reorderArgs() {
 
 	for i := 1; i < len(os.Args); i++ {
 		arg := os.Args[i]
-		if strings.HasPrefix(arg, "-") {
+		if strings.HasPrefix(arg, "-") && arg != "-" { // 单独的 "-" 表示标准输入
 			flags = append(flags, arg)
 			// 如果是带值的 flag，把值也加进去
-			if (arg == "--pass" || arg == "--port" || arg == "--tunnel" || arg == "--url") && i+1 < len(os.Args) {
+			if valueFlags[arg] && i+1 < len(os.Args) {
 				i++
 				flags = append(flags, os.Args[i])
 			}
@@ -43,3 +43,14 @@ func setProcAttr(cmd *exec.Cmd) {
 func getSignals() []os.Signal {
 	return []os.Signal{syscall.SIGTERM, syscall.SIGINT}
 }
+
+// upgradeSignal 通知服务进程启动新版本的服务进程并把监听 socket 交给它，
+// 之后停止接受新连接，等进行中的传输完成后退出 (cfshare upgrade --inplace)
+func upgradeSignal() os.Signal {
+	return syscall.SIGUSR2
+}
+
+// reloadSignal 通知服务进程重新读取 state.json 中的分享项 (cfshare add/remove)
+func reloadSignal() os.Signal {
+	return syscall.SIGHUP
+}
@@ -41,3 +41,13 @@ func setProcAttr(cmd *exec.Cmd) {
 func getSignals() []os.Signal {
 	return []os.Signal{os.Interrupt}
 }
+
+// upgradeSignal 在 Windows 上不可用，cfshare upgrade --inplace 不支持 Windows
+func upgradeSignal() os.Signal {
+	return nil
+}
+
+// reloadSignal 在 Windows 上不可用，cfshare add/remove 改为重启服务进程
+func reloadSignal() os.Signal {
+	return nil
+}